@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/alert"
+	"gcsfuse-tools-cli/internal/changelog"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func newAlertCmd() *cobra.Command {
+	cfg := alert.Config{}
+	var tools []string
+	var machineType string
+	var maxResults int
+	cmd := &cobra.Command{
+		Use:   "alert",
+		Short: "Detect sustained performance shifts in the registered results and post regressions to a webhook",
+		Long: `alert is meant to run at the end of the nightly benchmarks. It loads the
+results of --tools in --registry-bucket, builds the series of every workload
+metric over its last --window runs and finds the changepoint that best
+separates older from newer runs (the largest Welch t statistic).
+
+A changepoint is a shift when the medians on either side differ by at least
+--min-shift, the score is at least --min-score and at least --sustain runs
+follow it, so a single noisy night does not alert. Shifts at most --max-age
+runs old are new; new regressions are posted to --webhook, a Slack or Google
+Chat incoming webhook, so they are noticed the morning they land. Older shifts
+are only listed.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry alert --webhook=https://chat.googleapis.com/v1/spaces/...
+  gcsfuse-tools --registry-bucket=my-registry -o json alert --window=60 --machine-type=n2-standard-96`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			for _, t := range tools {
+				if !isChangelogTool(t) {
+					return fmt.Errorf("unsupported --tools value %q (want %s)", t, strings.Join(changelog.Tools, ", "))
+				}
+			}
+			ctx := cmd.Context()
+			var runs []changelog.Run
+			err := withRegistry(ctx, func(reg *registry.Registry) (err error) {
+				runs, err = recentRuns(ctx, reg, tools, machineType, maxResults)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			r, err := alert.Detect(ctx, cfg, runs)
+			if err != nil {
+				return err
+			}
+			return writeResult(r)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringSliceVar(&tools, "tools", changelog.Tools, "Result tools to analyze.")
+	f.StringVar(&machineType, "machine-type", "", "Only analyze results measured on this machine type, so a change of runners is not taken for a shift.")
+	f.IntVar(&maxResults, "max-results", 1000, "Load at most this many of the newest results of every tool.")
+	f.IntVar(&cfg.Window, "window", 30, "Most recent runs of every workload metric analyzed.")
+	f.Float64Var(&cfg.MinShift, "min-shift", 0.05, "Smallest relative change of the median that is a shift.")
+	f.Float64Var(&cfg.MinScore, "min-score", 4, "Smallest Welch t statistic between the runs before and after the changepoint that is a shift.")
+	f.IntVar(&cfg.Sustain, "sustain", 3, "Runs needed after the changepoint for a sustained shift.")
+	f.IntVar(&cfg.MaxAge, "max-age", 3, "Most runs after the changepoint for the shift to be new and posted; older shifts are only listed. With nightly runs and the default --sustain, a regression is posted once.")
+	f.StringVar(&cfg.Webhook, "webhook", "", "Slack or Google Chat incoming webhook URL new regressions are posted to.")
+	return cmd
+}
+
+// recentRuns loads the newest registered results of tools, at most maxResults per
+// tool, measured on machineType if set.
+func recentRuns(ctx context.Context, reg *registry.Registry, tools []string, machineType string, maxResults int) ([]changelog.Run, error) {
+	var runs []changelog.Run
+	for _, tool := range tools {
+		entries, err := reg.ListResults(ctx, tool)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, e := range entries {
+			if n == maxResults {
+				break
+			}
+			if machineType != "" && (e.Env == nil || e.Env.MachineType != machineType) {
+				continue
+			}
+			b, err := reg.GetResultBlob(ctx, e.RunID)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, changelog.Run{Entry: e, Blob: b})
+			n++
+		}
+	}
+	slog.Info("Loaded results", "runs", len(runs))
+	return runs, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newAlertCmd())
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"gke-genAI-log-analyzer/analyzer"
+)
+
+func newAnalyzeCmd() *cobra.Command {
+	cfg := analyzer.Config{}
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Find gcsfuse sidecar errors in Cloud Logging and explain them with Gemini",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.ProjectID = globals.project
+			// The global --output replaces the analyzer's -output flag.
+			cfg.Output = string(globals.format)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return analyzer.Run(ctx, cfg)
+		},
+	}
+	addGoFlags(cmd, cfg.RegisterFlags)
+	cmd.AddCommand(newAnalyzeEvalCmd())
+	return cmd
+}
+
+func newAnalyzeEvalCmd() *cobra.Command {
+	cfg := analyzer.EvalConfig{}
+	cmd := &cobra.Command{
+		Use:   "eval",
+		Short: "Score the analyzer's prompt and model against anonymized log fixtures with expected classifications",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.ProjectID = globals.project
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			return analyzer.RunEval(cmd.Context(), cfg)
+		},
+	}
+	addGoFlags(cmd, cfg.RegisterFlags)
+	return cmd
+}
+
+// addGoFlags registers flags declared on a standard library FlagSet by one of
+// the wrapped tools. Flags that duplicate a global flag are dropped so the
+// global value is used instead.
+func addGoFlags(cmd *cobra.Command, register func(*flag.FlagSet)) {
+	fs := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+	register(fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		if rootCmd.PersistentFlags().Lookup(f.Name) != nil {
+			return
+		}
+		cmd.Flags().AddGoFlag(f)
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(newAnalyzeCmd())
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/analyzebucket"
+)
+
+func newAnalyzeBucketCmd() *cobra.Command {
+	cfg := analyzebucket.Config{}
+	cmd := &cobra.Command{
+		Use:   "analyze-bucket",
+		Short: "Sample the layout of an existing bucket and predict how gcsfuse will behave on it",
+		Long: `analyze-bucket lists --bucket (under --prefix, up to --max-objects objects) and
+reports the object count and size distribution, the directory tree gcsfuse
+presents (directories, depth, entries per directory, directories without
+marker objects) and the entropy of the object names.
+
+From these it predicts the number, time and cost of the list calls of one
+full walk of the tree, and recommends metadata and file cache sizes, kernel
+list caching, --implicit-dirs or a hierarchical namespace bucket, and
+changes for small-file or sequentially named datasets. Only object names and
+sizes are read.`,
+		Example: `  gcsfuse-tools analyze-bucket --bucket=customer-training-data --prefix=imagenet/
+  gcsfuse-tools analyze-bucket --bucket=customer-training-data --max-objects=0 -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			r, err := analyzebucket.Analyze(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			return writeResult(r)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket to analyze.")
+	f.StringVar(&cfg.Prefix, "prefix", "", "Only analyze objects with this prefix, e.g. the directory a workload mounts with --only-dir.")
+	f.Int64Var(&cfg.MaxObjects, "max-objects", 1000000, "Stop listing after this many objects, which then describe the lexicographically first part of the bucket. 0 lists all.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newAnalyzeBucketCmd())
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/audit"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func newAuditCmd() *cobra.Command {
+	cfg := audit.Config{}
+	cmd := &cobra.Command{
+		Use:   "audit [DATASET...]",
+		Short: "Check the registered benchmark datasets for stale manifests, broad IAM grants, missing lifecycle rules and expired buckets",
+		Long: `audit goes through the datasets of --registry-bucket, or only the named ones,
+and checks for each bucket holding them that:
+
+  - every object of the registered spec exists with the right size, and the
+    manifest matches the spec (as dataprep --op-type=verify);
+  - no principal has a broad role without a condition, nothing is public,
+    and dataprep's time-bound grants are neither expired nor longer than
+    --max-grant-ttl;
+  - the bucket has lifecycle rules;
+  - the bucket's "expires" label (YYYY-MM-DD) exists and has not passed;
+  - the dataset was registered within --max-age.
+
+It prints one row per finding with the action to take, for a weekly hygiene
+review, e.g. from a cron job, and exits non-zero if any finding is a failure.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry audit
+  gcsfuse-tools --registry-bucket=my-registry -o json audit --max-age=2160h rand-read-1m`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Datasets = args
+			if globals.registryBucket == "" {
+				return errors.New("--registry-bucket is required")
+			}
+			ctx := cmd.Context()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			report, err := audit.Run(ctx, client, registry.New(client, globals.registryBucket), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(report); err != nil {
+				return err
+			}
+			if n := report.Failed(); n > 0 {
+				return fmt.Errorf("%d audit failure(s)", n)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.DurationVar(&cfg.MaxAge, "max-age", 0, "Warn about datasets registered longer ago than this, e.g. 2160h. 0 disables the check.")
+	f.DurationVar(&cfg.MaxGrantTTL, "max-grant-ttl", 7*24*time.Hour, "Warn about time-bound grants that expire further out than this.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newAuditCmd())
+}
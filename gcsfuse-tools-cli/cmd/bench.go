@@ -0,0 +1,554 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/bigdata"
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/mmapload"
+	"gcsfuse-tools-cli/internal/units"
+	"gcsfuse-tools-cli/pkg/workload"
+	"go-client-benchmark/benchmark"
+)
+
+func newBenchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run gcsfuse and GCS client benchmarks",
+	}
+	cmd.AddCommand(newBenchFioCmd(), newBenchGCSReadCmd(), newBenchBigdataSimCmd(), newBenchMmapCmd(), newBenchMultiMountCmd(), newBenchNoisyNeighborCmd(), newBenchSizeProfileCmd(), newBenchWarmColdCmd())
+	return cmd
+}
+
+func newBenchFioCmd() *cobra.Command {
+	cfg := bench.Config{}
+	var dataset, bundle, spec string
+	cmd := &cobra.Command{
+		Use:   "fio",
+		Short: "Run an fio jobfile or workload spec against a gcsfuse mount and summarize the results",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if spec == "" && cfg.JobFile == "" {
+				return errors.New("--jobfile or --spec is required")
+			}
+			if spec != "" {
+				s, err := workload.Load(spec)
+				if err != nil {
+					return err
+				}
+				cfg.Spec = s
+				if dataset == "" {
+					dataset = s.Dataset.Name
+				}
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if bundle != "" && cfg.Seed == 0 {
+				// A bundled run must be repeatable, so it always gets a seed.
+				if err := cmd.Flags().Set("seed", strconv.FormatUint(rand.Uint64()|1, 10)); err != nil {
+					return err
+				}
+			}
+			res, err := bench.Run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			err = writeReproBundle(cmd, bundle, reproInputs{
+				files: []string{"jobfile", "spec"}, seeds: map[string]uint64{"fio": cfg.Seed}, dataset: dataset,
+				env: res.Env, tools: map[string]string{"fio": res.FioVersion}, result: res,
+			})
+			if err != nil {
+				return err
+			}
+			return registerWorkloadResult(cmd.Context(), "bench-fio", dataset, specHash(cfg.Spec), res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	addBenchFlags(f, &cfg)
+	f.StringVar(&spec, "spec", "", "Workload spec (YAML or JSON, see gcsfuse-tools workload) to run instead of --jobfile. Its files are under the dataset prefix of --mount-point, and the result is keyed to the spec hash.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket. Defaults to the dataset name of --spec.")
+	addReproBundleFlag(f, &bundle)
+	return cmd
+}
+
+// addBenchFlags registers the flags of a bench.Config.
+func addBenchFlags(f *pflag.FlagSet, cfg *bench.Config) {
+	f.StringVar(&cfg.JobFile, "jobfile", "", "fio jobfile to run.")
+	f.StringVar(&cfg.MountPoint, "mount-point", "", "Directory fio runs in. Mounted with gcsfuse when --bucket is set.")
+	f.StringVar(&cfg.Target, "target", bench.TargetGcsfuse, "File system to run against: "+strings.Join(bench.TargetNames(), ", ")+". nfs and s3fs produce comparison baselines.")
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket the gcsfuse or s3fs target mounts at --mount-point for the run. If empty, --mount-point must already be mounted.")
+	f.StringVar(&cfg.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "Path to the gcsfuse binary.")
+	f.StringSliceVar(&cfg.GcsfuseFlags, "gcsfuse-flags", nil, "Extra gcsfuse flags, e.g. --gcsfuse-flags=--implicit-dirs,--max-conns-per-host=100.")
+	f.StringVar(&cfg.NFSExport, "nfs-export", "", "server:/path the nfs target mounts, e.g. a Filestore share. If empty, --mount-point must already be mounted.")
+	f.StringVar(&cfg.S3Endpoint, "s3-endpoint", "https://storage.googleapis.com", "S3-compatible endpoint of the s3fs target. The default uses Cloud Storage interoperability with HMAC keys.")
+	f.StringVar(&cfg.S3fsBinary, "s3fs-binary", "s3fs", "Path to the s3fs binary.")
+	f.StringSliceVar(&cfg.MountOptions, "mount-options", nil, "Mount options of the nfs and s3fs targets, e.g. --mount-options=nconnect=16,vers=3.")
+	f.StringVar(&cfg.FioBinary, "fio-binary", "fio", "Path to the fio binary.")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of fio's random offsets and buffers (randseed), so random workloads repeat exactly. 0 keeps the jobfile's.")
+}
+
+func newBenchGCSReadCmd() *cobra.Command {
+	cfg := benchmark.Config{}
+	var dataset, bundle, spec string
+	cmd := &cobra.Command{
+		Use:   "gcs-read",
+		Short: "Read objects directly with the Go storage client and report fio-compatible JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var s *workload.Spec
+			if spec != "" {
+				var err error
+				if s, err = workload.Load(spec); err != nil {
+					return err
+				}
+				if err := gcsReadSpec(cmd.Flags(), &cfg, s); err != nil {
+					return err
+				}
+				if dataset == "" {
+					dataset = s.Dataset.Name
+				}
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := benchmark.Run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			out := struct {
+				*benchmark.OutputSchema
+				Workload *workload.Ref        `json:"workload,omitempty"`
+				Env      *envinfo.Fingerprint `json:"env"`
+			}{res, nil, envinfo.Capture(cmd.Context(), envinfo.Options{})}
+			if s != nil {
+				out.Workload = s.Ref()
+			}
+			if err := writeResult(out); err != nil {
+				return err
+			}
+			err = writeReproBundle(cmd, bundle, reproInputs{files: []string{"spec"}, dataset: dataset, env: out.Env, result: out})
+			if err != nil {
+				return err
+			}
+			return registerWorkloadResult(cmd.Context(), "bench-gcs-read", dataset, specHash(s), out.Env, out)
+		},
+	}
+	addGoFlags(cmd, cfg.RegisterFlags)
+	cmd.Flags().StringVar(&spec, "spec", "", "Workload spec (YAML or JSON, see gcsfuse-tools workload) setting the bucket, prefix, sizes and workers instead of their flags. Only sequential reads of every file once can be run by the Go client.")
+	cmd.Flags().StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket. Defaults to the dataset name of --spec.")
+	addReproBundleFlag(cmd.Flags(), &bundle)
+	return cmd
+}
+
+// gcsReadSpecFlags are the gcs-read flags a workload spec sets.
+var gcsReadSpecFlags = []string{"bs", "filesize", "numjobs", "nrfiles", "obj-prefix"}
+
+// gcsReadSpec sets the workload of cfg from s, which gcs-read must be able to
+// run: whole files read sequentially, once, one block at a time.
+func gcsReadSpec(f *pflag.FlagSet, cfg *benchmark.Config, s *workload.Spec) error {
+	for _, name := range gcsReadSpecFlags {
+		if f.Changed(name) {
+			return fmt.Errorf("--%s is set by --spec", name)
+		}
+	}
+	switch {
+	case len(s.Ops) != 1 || s.Ops[0].Type != workload.OpRead:
+		return fmt.Errorf("spec %s: gcs-read only runs a single %s op", s.Name, workload.OpRead)
+	case s.Duration != "":
+		return fmt.Errorf("spec %s: gcs-read reads every file once and cannot run for a duration", s.Name)
+	case s.Concurrency.IODepth > 1:
+		return fmt.Errorf("spec %s: gcs-read reads one block at a time per worker, iodepth must be 1", s.Name)
+	case f.Changed("csek-key-file"):
+		return errors.New("--csek-key-file keeps its objects apart from the dataset of --spec and cannot be used with it")
+	}
+	switch d := s.Dataset; {
+	case cfg.BucketName == "":
+		cfg.BucketName = d.Bucket
+	case d.Bucket != "" && cfg.BucketName != d.Bucket:
+		return fmt.Errorf("--bucket %s differs from the bucket %s of the spec", cfg.BucketName, d.Bucket)
+	}
+	cfg.ObjectName = func(worker, file int) string {
+		return s.Dataset.ObjectName(workload.OpRead, worker, file)
+	}
+	cfg.FileSizeStr = s.Dataset.FileSize
+	cfg.NrFiles = s.Dataset.FilesPerWorker
+	cfg.BlockSizeStr = s.Ops[0].BlockSize
+	cfg.NumOfWorkers = s.Concurrency.Workers
+	return nil
+}
+
+// specHash returns the hash results of s are keyed to, or "" without a spec.
+func specHash(s *workload.Spec) string {
+	if s == nil {
+		return ""
+	}
+	return s.Hash()
+}
+
+func newBenchBigdataSimCmd() *cobra.Command {
+	cfg := bigdata.Config{}
+	var dataset, splitSize, footerSize, bufferSize string
+	cmd := &cobra.Command{
+		Use:   "bigdata-sim",
+		Short: "Emulate Spark/Hadoop split-based Parquet scans through a mount and through the GCS API",
+		Long: `bigdata-sim reads a dataset the way Spark and Hadoop jobs scan Parquet files.
+The planning phase reads the footer of every file: the last 8 bytes, then the
+footer they describe (--footer-size for files that are not Parquet). The scan
+phase cuts the files into --split-size splits and runs --parallelism tasks,
+each opening its file, reading the footer again and then the column chunks of
+its split, of which --read-columns of --columns are read, as a projecting
+query does.
+
+--dir scans the files under a directory of a gcsfuse mount, and --bucket and
+--prefix the same objects with range requests of the storage client, as the
+Cloud Storage connector does; with both, both are scanned and reported side
+by side.`,
+		Example: `  gcsfuse-tools bench bigdata-sim --dir=/mnt/lake/events --bucket=my-lake --prefix=events/
+  gcsfuse-tools bench bigdata-sim --dir=/mnt/lake/events --parallelism=256 --columns=10 --read-columns=3`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			for _, s := range []struct {
+				flag string
+				dst  *int64
+				val  string
+			}{{"split-size", &cfg.SplitSize, splitSize}, {"footer-size", &cfg.FooterSize, footerSize}, {"buffer-size", &cfg.BufferSize, bufferSize}} {
+				if *s.dst, err = units.ParseSize(s.val); err != nil {
+					return fmt.Errorf("parsing --%s: %w", s.flag, err)
+				}
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			var client *storage.Client
+			if slices.Contains(cfg.Targets, bigdata.TargetAPI) {
+				if client, err = storage.NewClient(ctx); err != nil {
+					return fmt.Errorf("creating storage client: %w", err)
+				}
+				defer client.Close()
+			}
+			res, err := bigdata.Run(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(ctx, "bench-bigdata-sim", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Dir, "dir", "", "Directory of a mount whose files are scanned.")
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket whose objects under --prefix are scanned through the API.")
+	f.StringVar(&cfg.Prefix, "prefix", "", "Prefix of the objects scanned through the API, e.g. the directory --dir mounts.")
+	f.StringSliceVar(&cfg.Targets, "targets", nil, "Targets to scan, in order: mount and/or api. Defaults to those --dir and --bucket configure.")
+	f.StringVar(&splitSize, "split-size", "128M", "Largest range one task reads, like spark.sql.files.maxPartitionBytes.")
+	f.IntVar(&cfg.Parallelism, "parallelism", 64, "Concurrent tasks, i.e. executors times cores.")
+	f.StringVar(&footerSize, "footer-size", "64K", "Footer read of files that do not end with a Parquet footer.")
+	f.IntVar(&cfg.Columns, "columns", 1, "Column chunks every split is divided into.")
+	f.IntVar(&cfg.ReadColumns, "read-columns", 0, "Column chunks of every split a task reads. 0 reads all --columns.")
+	f.StringVar(&bufferSize, "buffer-size", "8M", "Size of the reads a range is fetched with.")
+	f.IntVar(&cfg.MaxFiles, "max-files", 0, "Scan at most this many files, in lexical order. 0 scans all.")
+	f.BoolVar(&cfg.DropCache, "drop-cache", true, "Evict the files under --dir from the page cache before the mount scan.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	return cmd
+}
+
+func newBenchMmapCmd() *cobra.Command {
+	cfg := mmapload.Config{}
+	var dataset, createSize, bundle string
+	cmd := &cobra.Command{
+		Use:   "mmap",
+		Short: "Time safetensors-style checkpoint loading from a mount with mmap page faults vs. read()",
+		Long: `mmap loads a safetensors checkpoint on a mount the way inference servers do:
+it parses the header, then makes every tensor resident in model order, either
+by touching the pages of a shared mapping (page-fault-driven, like
+safe_open) or with explicit pread calls. The time until all tensors are
+resident is the time-to-first-token equivalent. With --create-size a
+synthetic checkpoint is written first when --path does not exist.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if createSize != "" {
+				n, err := units.ParseSize(createSize)
+				if err != nil {
+					return fmt.Errorf("parsing create-size %q: %v", createSize, err)
+				}
+				cfg.CreateSize = n
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := mmapload.Run(cfg)
+			if err != nil {
+				return err
+			}
+			res.Env = envinfo.Capture(cmd.Context(), envinfo.Options{MountPoint: filepath.Dir(cfg.Path)})
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			err = writeReproBundle(cmd, bundle, reproInputs{
+				seeds: map[string]uint64{"order": cfg.Seed}, dataset: dataset, env: res.Env, result: res,
+			})
+			if err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "bench-mmap", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Path, "path", "", "Checkpoint file on the mount.")
+	f.StringVar(&createSize, "create-size", "", "Write a synthetic checkpoint of this size (e.g. 8G) when --path does not exist.")
+	f.IntVar(&cfg.Tensors, "tensors", 200, "Number of tensors of a synthetic checkpoint.")
+	f.StringSliceVar(&cfg.Modes, "modes", []string{mmapload.ModeMmap, mmapload.ModeRead}, "Loading modes to run, in order: mmap, read.")
+	f.StringVar(&cfg.Order, "order", mmapload.OrderModel, "Tensor consumption order: model (layer numbers ascending), file or random.")
+	f.StringVar(&cfg.Madvise, "madvise", "normal", "madvise advice of the mapping: normal, sequential, random or willneed.")
+	f.BoolVar(&cfg.DropCache, "drop-cache", true, "Evict the checkpoint from the page cache before every mode.")
+	f.Uint64Var(&cfg.Seed, "seed", 1, "Seed of --order=random.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	addReproBundleFlag(f, &bundle)
+	return cmd
+}
+
+func newBenchMultiMountCmd() *cobra.Command {
+	cfg := bench.MultiMountConfig{}
+	var dataset, bundle string
+	cmd := &cobra.Command{
+		Use:   "multi-mount",
+		Short: "Measure cross-mount interference of concurrent fio workloads on several gcsfuse mounts",
+		Long: `multi-mount mounts --bucket --mounts times, or each of --buckets once, under
+--mount-point/mount-<i> and runs the jobfile on all mounts at the same time,
+as multi-volume GKE pods do through one gcsfuse sidecar. With --solo, the
+default, the jobfile first runs on every mount alone as the baseline. Each
+mount reports its solo and concurrent throughput, the fraction it retained
+and the CPU of its gcsfuse process; the totals include the host CPU.`,
+		Example: `  gcsfuse-tools bench multi-mount --jobfile=seq-read.fio --mount-point=/mnt/mm --bucket=b --mounts=4
+  gcsfuse-tools bench multi-mount --jobfile=seq-read.fio --mount-point=/mnt/mm --buckets=b1,b2,b3`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if bundle != "" && cfg.Base.Seed == 0 {
+				if err := cmd.Flags().Set("seed", strconv.FormatUint(rand.Uint64()|1, 10)); err != nil {
+					return err
+				}
+			}
+			res, err := bench.RunMultiMount(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			err = writeReproBundle(cmd, bundle, reproInputs{
+				files: []string{"jobfile"}, seeds: map[string]uint64{"fio": cfg.Base.Seed}, dataset: dataset,
+				env: res.Env, tools: map[string]string{"fio": res.FioVersion}, result: res,
+			})
+			if err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "bench-multi-mount", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Base.JobFile, "jobfile", "", "fio jobfile to run on every mount.")
+	f.StringVar(&cfg.Base.MountPoint, "mount-point", "", "Parent directory of the mount-<i> mount points.")
+	f.StringVar(&cfg.Base.Bucket, "bucket", "", "Bucket to mount --mounts times.")
+	f.IntVar(&cfg.Mounts, "mounts", 2, "Number of mounts of --bucket.")
+	f.StringSliceVar(&cfg.Buckets, "buckets", nil, "Buckets to mount once each, instead of --bucket.")
+	f.BoolVar(&cfg.Solo, "solo", true, "Run the jobfile on every mount alone first, as the baseline.")
+	f.StringVar(&cfg.Base.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "Path to the gcsfuse binary.")
+	f.StringSliceVar(&cfg.Base.GcsfuseFlags, "gcsfuse-flags", nil, "Extra gcsfuse flags of every mount, e.g. --gcsfuse-flags=--implicit-dirs,--max-conns-per-host=100.")
+	f.StringVar(&cfg.Base.FioBinary, "fio-binary", "fio", "Path to the fio binary.")
+	f.Uint64Var(&cfg.Base.Seed, "seed", 0, "Seed of fio's random offsets and buffers (randseed), so random workloads repeat exactly. 0 keeps the jobfile's.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	addReproBundleFlag(f, &bundle)
+	return cmd
+}
+
+func newBenchSizeProfileCmd() *cobra.Command {
+	cfg := bench.SizeProfileConfig{}
+	var minSize, maxSize, bytesPerSize, blockSize string
+	cmd := &cobra.Command{
+		Use:   "size-profile",
+		Short: "Sweep file sizes on a log scale and chart read and write throughput and latency against size",
+		Long: `size-profile writes and then reads back files of every size from --min-size to
+--max-size, multiplying by --step, through --mount-point (mounted from
+--bucket if set), and reports throughput, files per second and latency per
+size and operation. Each point moves about --bytes-per-size in files of that
+size, which are deleted before the next size.
+
+The gcsfuse version is recorded with the result, so runs registered in
+--registry-bucket give one curve per release.`,
+		Example: `  gcsfuse-tools bench size-profile --bucket=my-bench-bucket --mount-point=/mnt/bench
+  gcsfuse-tools -o json bench size-profile --mount-point=/mnt/bench --min-size=64K --max-size=1G --ops=read --threads=8`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			for _, s := range []struct {
+				flag string
+				dst  *int64
+				val  string
+			}{{"min-size", &cfg.MinSize, minSize}, {"max-size", &cfg.MaxSize, maxSize}, {"bytes-per-size", &cfg.BytesPerSize, bytesPerSize}, {"block-size", &cfg.BlockSize, blockSize}} {
+				if *s.dst, err = units.ParseSize(s.val); err != nil {
+					return fmt.Errorf("parsing --%s: %w", s.flag, err)
+				}
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := bench.RunSizeProfile(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "bench-size-profile", "", res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Base.MountPoint, "mount-point", "", "Directory the files are written and read in. Mounted with gcsfuse when --bucket is set.")
+	f.StringVar(&cfg.Base.Bucket, "bucket", "", "Bucket mounted at --mount-point for the run. If empty, --mount-point must already be mounted.")
+	f.StringVar(&cfg.Base.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "Path to the gcsfuse binary.")
+	f.StringSliceVar(&cfg.Base.GcsfuseFlags, "gcsfuse-flags", nil, "Extra gcsfuse flags, e.g. --gcsfuse-flags=--implicit-dirs,--max-conns-per-host=100.")
+	f.StringVar(&cfg.Base.FioBinary, "fio-binary", "fio", "Path to the fio binary.")
+	f.StringVar(&minSize, "min-size", "4K", "Smallest file size.")
+	f.StringVar(&maxSize, "max-size", "10G", "Largest file size, always profiled.")
+	f.Int64Var(&cfg.Step, "step", 4, "Factor between two consecutive sizes.")
+	f.StringSliceVar(&cfg.Ops, "ops", []string{bench.ProfileWrite, bench.ProfileRead}, "Operations at every size, in order: write and/or read. Reads without a preceding write let fio lay the files out first.")
+	f.StringVar(&bytesPerSize, "bytes-per-size", "1G", "Data moved by each operation at every size.")
+	f.IntVar(&cfg.MaxFiles, "max-files", 256, "Most files per thread at one size, bounding the run time of small sizes.")
+	f.IntVar(&cfg.Threads, "threads", 1, "Concurrent fio threads, each with its own files.")
+	f.StringVar(&blockSize, "block-size", "1M", "Largest I/O size; smaller files are transferred in one I/O.")
+	return cmd
+}
+
+func newBenchWarmColdCmd() *cobra.Command {
+	cfg := bench.WarmColdConfig{}
+	var dataset string
+	cmd := &cobra.Command{
+		Use:   "warm-cold",
+		Short: "Measure an fio jobfile on a fresh mount and again after a warm-up pass",
+		Long: `warm-cold mounts --bucket at --mount-point and runs the jobfile right after the
+mount, with empty caches, as the first epoch of a training job does. It then
+runs --warmup-jobfile (the jobfile itself by default) --warmup-passes times
+and the jobfile once more, as the steady state. Both passes are reported side
+by side with the time the mount and the warm-up took, so first-epoch and
+steady-state performance are not conflated.`,
+		Example: `  gcsfuse-tools bench warm-cold --jobfile=rand-read.fio --bucket=b --mount-point=/mnt/bench --gcsfuse-flags=--file-cache-max-size-mb=-1,--cache-dir=/tmp/cache
+  gcsfuse-tools bench warm-cold --jobfile=rand-read.fio --warmup-jobfile=seq-read.fio --warmup-passes=2 --bucket=b --mount-point=/mnt/bench`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := bench.RunWarmCold(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "bench-warm-cold", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	addBenchFlags(f, &cfg.Base)
+	f.StringVar(&cfg.WarmupJobFile, "warmup-jobfile", "", "fio jobfile warming the mount up between the cold and the warm pass, e.g. a sequential read of the dataset. Defaults to --jobfile.")
+	f.IntVar(&cfg.WarmupPasses, "warmup-passes", 1, "Runs of --warmup-jobfile after the cold pass. 0 measures the warm pass right after the cold one.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	return cmd
+}
+
+func newBenchNoisyNeighborCmd() *cobra.Command {
+	cfg := bench.NoisyNeighborConfig{}
+	var dataset string
+	cmd := &cobra.Command{
+		Use:   "noisy-neighbor",
+		Short: "Measure how much an fio jobfile slows down next to an aggressive workload",
+		Long: `noisy-neighbor mounts --bucket at <mount-point>/measured and runs the jobfile
+alone, then again while a noise workload runs next to it, started --ramp-up
+before the measured pass and restarted whenever it finishes:
+
+  mount    --noise-jobfile on the measured mount, sharing its gcsfuse process
+  vm       --noise-jobfile on a second mount at <mount-point>/noise of
+           --noise-bucket (--bucket by default), sharing the VM
+  command  --noise-command, e.g. loading the bucket from another VM over ssh,
+           sharing only the bucket
+
+It reports the throughput the jobfile retained, the inflation of its mean and
+p99 latencies and, for noise jobfiles, its share of the combined throughput.
+Repeat --gcsfuse-binary to compare the isolation of several gcsfuse versions.
+On a shared mount, give both jobfiles distinct job names or filenames unless
+they should share files.`,
+		Example: `  gcsfuse-tools bench noisy-neighbor --jobfile=rand-read.fio --noise-jobfile=seq-write.fio --noise-scope=mount --bucket=b --mount-point=/mnt/bench
+  gcsfuse-tools bench noisy-neighbor --jobfile=rand-read.fio --noise-scope=command --bucket=b --mount-point=/mnt/bench \
+      --noise-command='gcloud compute ssh loadgen -- gcsfuse-tools bench gcs-read --bucket=b ...' \
+      --gcsfuse-binary=/opt/gcsfuse-2.4/gcsfuse --gcsfuse-binary=/opt/gcsfuse-2.5/gcsfuse`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := bench.RunNoisyNeighbor(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "bench-noisy-neighbor", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Base.JobFile, "jobfile", "", "fio jobfile of the measured workload.")
+	f.StringVar(&cfg.Base.MountPoint, "mount-point", "", "Parent directory of the measured and noise mount points.")
+	f.StringVar(&cfg.Base.Bucket, "bucket", "", "Bucket of the measured workload.")
+	f.StringArrayVar(&cfg.GcsfuseBinaries, "gcsfuse-binary", []string{"gcsfuse"}, "Path to the gcsfuse binary. Repeat to compare versions.")
+	f.StringSliceVar(&cfg.Base.GcsfuseFlags, "gcsfuse-flags", nil, "Extra gcsfuse flags of every mount, e.g. --gcsfuse-flags=--implicit-dirs,--max-conns-per-host=100.")
+	f.StringVar(&cfg.Base.FioBinary, "fio-binary", "fio", "Path to the fio binary.")
+	f.Uint64Var(&cfg.Base.Seed, "seed", 0, "Seed of fio's random offsets and buffers (randseed) of the measured jobfile. 0 keeps the jobfile's.")
+	f.StringVar(&cfg.Scope, "noise-scope", bench.NoiseMount, "What the noise shares with the measured workload: mount, vm or command.")
+	f.StringVar(&cfg.NoiseJobFile, "noise-jobfile", "", "fio jobfile of the noise, for --noise-scope=mount and vm.")
+	f.StringVar(&cfg.NoiseBucket, "noise-bucket", "", "Bucket of the noise mount with --noise-scope=vm. Defaults to --bucket.")
+	f.StringVar(&cfg.NoiseCommand, "noise-command", "", "Shell command of the noise with --noise-scope=command.")
+	f.DurationVar(&cfg.RampUp, "ramp-up", 10*time.Second, "Time the noise runs before the measured pass starts.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	return cmd
+}
+
+// addReproBundleFlag registers --repro-bundle, see writeReproBundle.
+func addReproBundleFlag(f *pflag.FlagSet, bundle *string) {
+	f.StringVar(bundle, "repro-bundle", "", "Write a tar.gz with the flags, input files, seeds, dataset, environment and tool versions of the run, for repro run.")
+}
+
+func init() {
+	rootCmd.AddCommand(newBenchCmd())
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/bucketwatch"
+)
+
+func newBucketWatchCmd() *cobra.Command {
+	cfg := bucketwatch.Config{}
+	cmd := &cobra.Command{
+		Use:   "bucket-watch",
+		Short: "Record object changes to a benchmark bucket during a run and flag out-of-band modifications",
+		Long: `bucket-watch runs alongside a benchmark and records every object created,
+overwritten, deleted or updated in --bucket until --duration elapses or it is
+interrupted. It then prints the changes and exits non-zero if there were any,
+so results taken while someone else modified the dataset can be discarded.
+
+By default the bucket is listed every --interval, which misses changes that
+cancel out between two listings. With --subscription, the bucket's Pub/Sub
+notifications (gcloud storage buckets notifications create) are pulled
+instead and every change is seen. Objects the benchmark itself writes can be
+excluded with --ignore.`,
+		Example: `  gcsfuse-tools bucket-watch --bucket=my-bench-bucket --prefix=rand-read. --interval=10s &
+  gcsfuse-tools bucket-watch --bucket=my-bench-bucket --ignore='write.*' \
+    --subscription=projects/my-project/subscriptions/bench-bucket-changes --duration=1h`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			r, err := bucketwatch.Watch(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(r); err != nil {
+				return err
+			}
+			if n := len(r.Changes); n > 0 {
+				return fmt.Errorf("%d out-of-band change(s) to gs://%s during the watch", n, cfg.Bucket)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket to watch.")
+	f.StringVar(&cfg.Prefix, "prefix", "", "Only watch objects with this prefix, e.g. the dataset's rand-read.")
+	f.StringVar(&cfg.Subscription, "subscription", "", "Pub/Sub subscription to the bucket's notifications, projects/PROJECT/subscriptions/NAME. Replaces listing.")
+	f.DurationVar(&cfg.Interval, "interval", 30*time.Second, "Time between two listings of the bucket.")
+	f.DurationVar(&cfg.Duration, "duration", 0, "Stop after this long. 0 watches until interrupted.")
+	f.StringArrayVar(&cfg.Ignore, "ignore", nil, "Glob of objects the benchmark itself changes, e.g. 'write.*'. Repeatable.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newBucketWatchCmd())
+}
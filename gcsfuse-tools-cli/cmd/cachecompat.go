@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/cachecompat"
+)
+
+func newCacheCompatCmd() *cobra.Command {
+	cfg := cachecompat.Config{}
+	cmd := &cobra.Command{
+		Use:   "cache-compat",
+		Short: "Check whether a gcsfuse upgrade reuses or invalidates the file cache of the previous release",
+		Long: `cache-compat mounts --bucket with the gcsfuse binary of --gcsfuse-a and the
+file cache in an empty --cache-dir, reads the files under --dir once to
+populate the cache and once more to check that it is hit, and unmounts. It
+then mounts the bucket with --gcsfuse-b on the same cache directory, as after
+an upgrade, and reads the files again.
+
+The hit ratio of every pass is measured from the file cache reads gcsfuse
+logs at trace severity, and the cache directory is compared before and after
+the upgrade. The verdict is reused, invalidated or partial; with --expect the
+command exits non-zero when release B behaves otherwise, so cache format
+changes that throw away warm caches are caught before a release.`,
+		Example: `  gcsfuse-tools cache-compat --bucket=my-bench-bucket --dir=rand-read.0 \
+    --gcsfuse-a=/opt/gcsfuse-2.4.0/gcsfuse --gcsfuse-b=/opt/gcsfuse-2.5.0/gcsfuse \
+    --mount-point=/mnt/compat --cache-dir=/mnt/ssd/compat-cache --expect=reuse`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			r, err := cachecompat.Run(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(r); err != nil {
+				return err
+			}
+			if err := registerResult(ctx, "cache-compat", "", nil, r); err != nil {
+				return err
+			}
+			if !r.OK() {
+				return fmt.Errorf("the file cache was %s after the upgrade to %s, expected %s", r.Verdict, r.VersionB, cfg.Expect)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket to mount.")
+	f.StringVar(&cfg.Dir, "dir", "", "Directory of the bucket whose files are read.")
+	f.IntVar(&cfg.MaxFiles, "max-files", 100, "Read at most this many files, in lexical order.")
+	f.StringVar(&cfg.GcsfuseA, "gcsfuse-a", "", "gcsfuse binary of the release that populates the cache.")
+	f.StringVar(&cfg.GcsfuseB, "gcsfuse-b", "", "gcsfuse binary of the release upgraded to.")
+	f.StringVar(&cfg.MountPoint, "mount-point", "", "Directory both releases mount the bucket at in turn.")
+	f.StringVar(&cfg.CacheDir, "cache-dir", "", "File cache directory shared by both releases. Must be empty or not exist.")
+	f.StringSliceVar(&cfg.Flags, "gcsfuse-flags", nil, "Additional gcsfuse flags of both mounts, e.g. --implicit-dirs.")
+	f.StringVar(&cfg.Expect, "expect", "", "Exit non-zero unless release B does this with the cache: reuse or invalidate.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newCacheCompatCmd())
+}
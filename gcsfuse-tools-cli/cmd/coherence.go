@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/coherence"
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/units"
+	"gke-genAI-log-analyzer/analyzer"
+)
+
+func newCoherenceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "coherence",
+		Short: "Direct I/O helpers for gcsfuse consistency and coherency validation",
+	}
+	cmd.AddCommand(newCoherenceReadCmd(), newCoherenceWriteCmd(), newCoherenceReadConcurrentlyCmd(),
+		newCoherenceElectCmd(), newCoherenceElectVerifyCmd(), newCoherenceFuzzCmd(),
+		newCoherenceAttrsCmd(), newCoherenceKillRemountCmd(), newCoherenceRevokeAccessCmd(), newCoherenceCSEKCmd(), newCoherenceRunCmd())
+	pf := cmd.PersistentFlags()
+	pf.StringVar(&coherenceLog.path, "gcsfuse-log", "", "gcsfuse log file (written with --log-severity=trace) to attach the records around each failure from.")
+	pf.DurationVar(&coherenceLog.window, "log-window", 5*time.Second, "Log records within this duration of a failure are attached to it.")
+	pf.BoolVar(&coherenceLog.gemini, "gemini", false, "Ask Gemini on Vertex AI (in --project) for a first-pass diagnosis of the failures, from their log records with --gcsfuse-log.")
+	pf.StringVar(&coherenceLog.region, "region", "us-central1", "Vertex AI region used with --gemini.")
+	return cmd
+}
+
+// coherenceLog holds the options correlating helper failures with the
+// gcsfuse log.
+var coherenceLog struct {
+	path   string
+	window time.Duration
+	gemini bool
+	region string
+}
+
+func newCoherenceReadCmd() *cobra.Command {
+	cfg := coherence.ReadConfig{}
+	cmd := &cobra.Command{
+		Use:   "read <file-path>",
+		Short: "Read a whole file and print its content",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Path = args[0]
+			return coherence.Read(cfg, os.Stdout)
+		},
+	}
+	cmd.Flags().BoolVar(&cfg.Direct, "direct", false, "Open the file with O_DIRECT (platform-specific).")
+	return cmd
+}
+
+func newCoherenceWriteCmd() *cobra.Command {
+	cfg := coherence.WriteConfig{}
+	var size string
+	cmd := &cobra.Command{
+		Use:   "write <file-path>",
+		Short: "Write content to a file from one or more concurrent writers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Path = args[0]
+			if cmd.Flags().Changed("content") && cmd.Flags().Changed("size") {
+				return fmt.Errorf("cannot specify both --content and --size")
+			}
+			if cfg.Size, err = units.ParseSize(size); err != nil {
+				return fmt.Errorf("parsing size %q: %v", size, err)
+			}
+			if cfg.Seed != 0 && cfg.Size <= 0 {
+				return fmt.Errorf("--seed requires --size")
+			}
+			res, err := coherence.Write(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Content, "content", coherence.DefaultContent, "The string content to write to the file.")
+	f.StringVar(&size, "size", "0", "Size of the file to create (e.g., 1024, 1K, 10M, 1G). Replaces --content when set.")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the content generated with --size. Readers given the same seed verify any range of the file on any host. 0 rotates through printable ASCII.")
+	f.BoolVar(&cfg.NoSync, "no-sync", false, "Skip file.Sync().")
+	f.BoolVar(&cfg.NoFlush, "no-flush", false, "Skip file.Close() and block until interrupted, leaving the handles open.")
+	f.BoolVar(&cfg.Direct, "direct", false, "Open the file with O_DIRECT (platform-specific).")
+	f.IntVar(&cfg.DuplicateWrites, "duplicate-writes", 1, "Number of concurrent writers performing the same write.")
+	return cmd
+}
+
+func newCoherenceReadConcurrentlyCmd() *cobra.Command {
+	cfg := coherence.ReadConcurrentlyConfig{}
+	var size, minReadSize string
+	cmd := &cobra.Command{
+		Use:   "read-concurrently <file-path>",
+		Short: "Read random ranges of a file from concurrent threads and verify them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Path = args[0]
+			if cfg.Size, err = units.ParseSize(size); err != nil {
+				return fmt.Errorf("parsing size %q: %v", size, err)
+			}
+			if cfg.MinReadSize, err = units.ParseSize(minReadSize); err != nil {
+				return fmt.Errorf("parsing min-read-size %q: %v", minReadSize, err)
+			}
+			cfg.Verbose, cfg.Quiet = lastWins(os.Args, cfg.Verbose, cfg.Quiet)
+			res, err := coherence.ReadConcurrently(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&size, "size", "0", "Size of the file to create (e.g., 1024, 1K, 10M, 1G). If 0, uses the existing file.")
+	f.StringVar(&minReadSize, "min-read-size", "0", "Block size for read operations per thread (e.g. 4K, 1M). Defaults to 1M.")
+	f.BoolVar(&cfg.Verify, "verify", false, "Verify the read content against the pattern generated for --seed, also for an existing file written by write --size.")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the generated pattern, as given to the writer. 0 rotates through printable ASCII.")
+	f.IntVar(&cfg.Threads, "threads", 2, "Number of concurrent threads to use.")
+	f.BoolVarP(&cfg.Verbose, "verbose", "v", false, "Enable verbose logging.")
+	f.BoolVarP(&cfg.Quiet, "quiet", "q", false, "Suppress non-error output.")
+	f.BoolVar(&cfg.Direct, "direct", false, "Use O_DIRECT for reading.")
+	return cmd
+}
+
+func newCoherenceElectCmd() *cobra.Command {
+	cfg := coherence.ElectConfig{}
+	cmd := &cobra.Command{
+		Use:   "elect <lock-path>",
+		Short: "Race to create a lock file exclusively and record whether this writer won",
+		Long: `elect creates <lock-path> with O_CREAT|O_EXCL from --contenders concurrent
+writers and records each outcome under --results-dir. Run it on every host
+against the same bucket, then run elect-verify once to check that exactly one
+writer across all hosts won.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Path = args[0]
+			res, err := coherence.Elect(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.WriterID, "writer-id", "", "ID written to the lock by this host's contenders. Defaults to the hostname.")
+	f.IntVar(&cfg.Contenders, "contenders", 1, "Number of concurrent contenders on this host.")
+	f.StringVar(&cfg.ResultsDir, "results-dir", "", "Directory shared by all hosts that receives the outcome records. Defaults to <lock-path>.election.")
+	f.DurationVar(&cfg.Hold, "hold", 0, "How long the winner holds the lock before recording its outcome.")
+	return cmd
+}
+
+func newCoherenceElectVerifyCmd() *cobra.Command {
+	cfg := coherence.VerifyElectionConfig{}
+	cmd := &cobra.Command{
+		Use:   "elect-verify <lock-path>",
+		Short: "Check that exactly one writer of an elect run won the lock",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Path = args[0]
+			res, err := coherence.VerifyElection(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.ResultsDir, "results-dir", "", "Directory the elect runs recorded their outcomes in. Defaults to <lock-path>.election.")
+	f.IntVar(&cfg.Contenders, "contenders", 1, "Total number of contenders across all hosts.")
+	f.DurationVar(&cfg.Timeout, "timeout", time.Minute, "How long to wait for all outcome records to appear.")
+	return cmd
+}
+
+func newCoherenceFuzzCmd() *cobra.Command {
+	cfg := coherence.FuzzConfig{}
+	var maxSize string
+	cmd := &cobra.Command{
+		Use:   "fuzz <dir>",
+		Short: "Run a random sequence of file operations and compare the mount with a model",
+		Long: `fuzz runs random but valid sequences of open, read, write, truncate, rename
+and close on a few files in <dir>, checks every result against an in-memory
+model of the expected state, and stops at the first divergence. The seed is
+printed with the failure so the exact sequence can be replayed with --seed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Dir = args[0]
+			n, err := units.ParseSize(maxSize)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("parsing max-size %q: want a positive size", maxSize)
+			}
+			cfg.MaxSize = int(n)
+			if cfg.Ops <= 0 || cfg.Files <= 0 {
+				return errors.New("--ops and --files must be positive")
+			}
+			res, err := coherence.Fuzz(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the operation sequence. 0 picks one from the clock.")
+	f.IntVar(&cfg.Ops, "ops", 1000, "Number of operations to run.")
+	f.IntVar(&cfg.Files, "files", 4, "Number of distinct file names the operations pick from.")
+	f.StringVar(&maxSize, "max-size", "64K", "Largest offset and length of reads, writes and truncates (e.g. 4K, 1M).")
+	f.BoolVar(&cfg.Keep, "keep", false, "Keep the files after a passing run.")
+	return cmd
+}
+
+func newCoherenceAttrsCmd() *cobra.Command {
+	cfg := coherence.AttrsConfig{}
+	var expect string
+	cmd := &cobra.Command{
+		Use:   "attrs <file-path>",
+		Short: "Check that chmod, chown, utimes and setxattr behave as documented and persist",
+		Long: `attrs creates <file-path>, runs chmod, chown, utimes and setxattr on it and
+classifies each call as ignored (succeeds, attribute unchanged), emulated
+(succeeds, attribute changed) or error. The defaults follow the gcsfuse
+documentation; override them with --expect. With --cache-wait and
+--remount-cmd the attributes are read again after the metadata cache expires
+and after a remount, and must not change.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Path = args[0]
+			if cfg.Expect, err = coherence.ParseAttrExpectations(expect); err != nil {
+				return err
+			}
+			res, err := coherence.Attrs(cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&expect, "expect", "", "Override expected behaviours, e.g. chmod=error,setxattr=emulated (ignored, emulated or error).")
+	f.DurationVar(&cfg.CacheWait, "cache-wait", 0, "Re-check the attributes after this long, e.g. just over the mount's metadata cache TTL.")
+	f.StringVar(&cfg.RemountCmd, "remount-cmd", "", "Shell command that remounts the bucket; the attributes are re-checked afterwards.")
+	return cmd
+}
+
+func newCoherenceKillRemountCmd() *cobra.Command {
+	cfg := coherence.KillRemountConfig{}
+	var size string
+	cmd := &cobra.Command{
+		Use:   "kill-remount <dir>",
+		Short: "SIGKILL gcsfuse mid-read and mid-write, remount and check for hangs and partial objects",
+		Long: `kill-remount creates two files in <dir>, a directory of a gcsfuse mount. A
+reader loops over the first while a writer overwrites half of the second,
+then the gcsfuse process serving --mount-point is killed. Both must fail
+within --error-timeout instead of hanging. After --remount-cmd the first file
+must be intact and the second must hold its old or its new content in full.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Dir = args[0]
+			if cfg.RemountCmd == "" {
+				return errors.New("--remount-cmd is required")
+			}
+			if cfg.Size, err = units.ParseSize(size); err != nil {
+				return fmt.Errorf("parsing size %q: %v", size, err)
+			}
+			if cfg.Size <= 0 {
+				return errors.New("--size must be greater than 0")
+			}
+			res, err := coherence.KillRemount(cmd.Context(), cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.MountPoint, "mount-point", "", "Mount point of the gcsfuse process to kill. Defaults to <dir>.")
+	f.StringVar(&cfg.RemountCmd, "remount-cmd", "", "Shell command that unmounts the dead mount and mounts the bucket again, e.g. 'fusermount -uz /mnt/gcs && gcsfuse my-bucket /mnt/gcs'.")
+	f.StringVar(&size, "size", "64M", "Size of the scenario files (e.g. 1M, 64M, 1G).")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the content of the files. The overwrite uses seed+1.")
+	f.DurationVar(&cfg.KillAfter, "kill-after", 2*time.Second, "How long the reader runs before gcsfuse is killed.")
+	f.DurationVar(&cfg.ErrorTimeout, "error-timeout", 30*time.Second, "How long the interrupted reader and writer may take to fail before they count as hung.")
+	f.BoolVar(&cfg.Direct, "direct", false, "Read with O_DIRECT, bypassing the page cache.")
+	return cmd
+}
+
+func newCoherenceRevokeAccessCmd() *cobra.Command {
+	cfg := coherence.RevokeAccessConfig{}
+	var size string
+	cmd := &cobra.Command{
+		Use:   "revoke-access <dir>",
+		Short: "Revoke the mount's permissions mid-write and check for EACCES and intact objects",
+		Long: `revoke-access overwrites half of a file in <dir>, a directory of a gcsfuse
+mount, then runs --revoke-cmd to take the permissions of the mount's
+credentials away, as happens when a service account key is rotated. Creating
+new files must then fail with EACCES (or EPERM) within --error-timeout. The
+writer finishes without access, --restore-cmd gives the permissions back and
+the file must hold its old or its new content in full, the new one if closing
+it succeeded.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Dir = args[0]
+			if cfg.RevokeCmd == "" || cfg.RestoreCmd == "" {
+				return errors.New("--revoke-cmd and --restore-cmd are required")
+			}
+			if cfg.Size, err = units.ParseSize(size); err != nil {
+				return fmt.Errorf("parsing size %q: %v", size, err)
+			}
+			if cfg.Size <= 0 {
+				return errors.New("--size must be greater than 0")
+			}
+			if cfg.ProbeInterval <= 0 {
+				return errors.New("--probe-interval must be greater than 0")
+			}
+			res, err := coherence.RevokeAccess(cmd.Context(), cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.RevokeCmd, "revoke-cmd", "", "Shell command that takes the mount's access to the bucket away, e.g. 'gcloud storage buckets remove-iam-policy-binding gs://my-bucket --member=serviceAccount:SA --role=roles/storage.objectUser', or swaps the token gcsfuse reads for a restricted one.")
+	f.StringVar(&cfg.RestoreCmd, "restore-cmd", "", "Shell command that gives the access back, e.g. the matching add-iam-policy-binding.")
+	f.StringVar(&size, "size", "64M", "Size of the written file (e.g. 1M, 64M, 1G).")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the content of the file. The overwrite uses seed+1.")
+	f.DurationVar(&cfg.ErrorTimeout, "error-timeout", 5*time.Minute, "How long new files may still be created after --revoke-cmd, and fail to be created after --restore-cmd, as IAM changes take minutes to propagate.")
+	f.DurationVar(&cfg.ProbeInterval, "probe-interval", 2*time.Second, "Pause between two attempts to create a new file.")
+	return cmd
+}
+
+func newCoherenceCSEKCmd() *cobra.Command {
+	cfg := coherence.CSEKConfig{}
+	var size string
+	cmd := &cobra.Command{
+		Use:   "csek <dir>",
+		Short: "Check how gcsfuse surfaces objects encrypted with a customer-supplied key it does not have",
+		Long: `csek writes an object encrypted with a random customer-supplied encryption
+key (CSEK) to gs://--bucket/--prefix, which <dir>, a directory of a gcsfuse
+mount, shows, and checks the error surface of the mount, which has no key: the
+object must be listed and stat'ed with its size, as its metadata needs no
+key, and reading it must fail with EIO within --read-timeout, again on a
+second read, without returning any bytes. The result records the expected
+and observed outcome of every operation. Datasets encrypted with a key are
+written with dataprep --csek-key-file and read with bench gcs-read
+--csek-key-file.`,
+		Example: `  gcsfuse-tools coherence csek /mnt/gcs/data --bucket=my-bucket --prefix=data/`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg.Dir = args[0]
+			if cfg.Bucket == "" {
+				return errors.New("--bucket is required")
+			}
+			if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+				cfg.Prefix += "/"
+			}
+			if cfg.Size, err = units.ParseSize(size); err != nil {
+				return fmt.Errorf("parsing size %q: %v", size, err)
+			}
+			if cfg.Size <= 0 {
+				return errors.New("--size must be greater than 0")
+			}
+			if cfg.ReadTimeout <= 0 {
+				return errors.New("--read-timeout must be greater than 0")
+			}
+			ctx := cmd.Context()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			res, err := coherence.CSEK(ctx, client, cfg)
+			return writeCoherenceResult(ctx, res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket of the mount.")
+	f.StringVar(&cfg.Prefix, "prefix", "", "Prefix of <dir> in the bucket, empty for the root of a mount of the whole bucket.")
+	f.StringVar(&size, "size", "1M", "Size of the encrypted object (e.g. 1M, 64M).")
+	f.Uint64Var(&cfg.Seed, "seed", 0, "Seed of the content of the object.")
+	f.DurationVar(&cfg.ReadTimeout, "read-timeout", 30*time.Second, "How long a read of the encrypted object may take to fail.")
+	return cmd
+}
+
+func newCoherenceRunCmd() *cobra.Command {
+	cfg := coherence.RunConfig{}
+	cmd := &cobra.Command{
+		Use:   "run --proc <command> [--proc <command>...]",
+		Short: "Run a multi-process scenario and consolidate the helpers' results into one verdict",
+		Long: `run starts every --proc shell command concurrently, e.g. one writer and two
+readers, with ` + coherence.ResultsDirEnv + ` pointing at a private
+directory. Every coherence helper started by a process writes its JSON result
+there, and run prints one consolidated verdict: a process passes when it exits
+0 and all the results it reported passed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(cfg.Commands) == 0 {
+				return errors.New("--proc is required")
+			}
+			res, err := coherence.Run(cmd.Context(), cfg)
+			return writeCoherenceResult(cmd.Context(), res, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringArrayVar(&cfg.Commands, "proc", nil, "Shell command of one process. Repeat for every process of the scenario.")
+	f.DurationVar(&cfg.Timeout, "timeout", 0, "Stop processes still running after this long (0 waits forever).")
+	return cmd
+}
+
+// writeCoherenceResult attaches the environment fingerprint to a helper result,
+// prints it and turns a failed verdict into a non-zero exit.
+func writeCoherenceResult(ctx context.Context, res *coherence.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	res.Env = envinfo.Capture(ctx, envinfo.Options{})
+	if !res.Passed && (coherenceLog.path != "" || coherenceLog.gemini) {
+		if err := diagnose(ctx, res); err != nil {
+			slog.Warn("Could not diagnose the failures", "err", err)
+		}
+	}
+	if err := writeResult(res); err != nil {
+		return err
+	}
+	if err := coherence.Report(res); err != nil {
+		return err
+	}
+	if !res.Passed {
+		return fmt.Errorf("%s: %d failure(s)", res.Tool, res.Failures)
+	}
+	return nil
+}
+
+// diagnose attaches, with --gcsfuse-log, the gcsfuse log records around the
+// failures of res and, with --gemini, a diagnosis of them.
+func diagnose(ctx context.Context, res *coherence.Result) error {
+	if coherenceLog.path != "" {
+		if err := coherence.Correlate(res, coherenceLog.path, coherenceLog.window); err != nil {
+			return err
+		}
+	}
+	if !coherenceLog.gemini {
+		return nil
+	}
+	project, err := globals.requireProject()
+	if err != nil {
+		return err
+	}
+	if res.Diagnosis, err = analyzer.Generate(ctx, project, coherenceLog.region, res.Prompt()); err != nil {
+		return fmt.Errorf("gemini diagnosis failed: %w", err)
+	}
+	return nil
+}
+
+// lastWins resolves -v and -q the way the standalone reader does: whichever
+// appears last on the command line takes effect.
+func lastWins(args []string, verbose, quiet bool) (bool, bool) {
+	lastV, lastQ := -1, -1
+	for i, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			lastV = i
+		case "-q", "--quiet":
+			lastQ = i
+		}
+	}
+	switch {
+	case lastQ > lastV:
+		return false, true
+	case lastV > lastQ:
+		return true, false
+	default:
+		return verbose && !quiet, quiet
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(newCoherenceCmd())
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/published"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func init() {
+	rootCmd.AddCommand(newComparePublishedCmd())
+}
+
+func newComparePublishedCmd() *cobra.Command {
+	var resultFile, runID, release, publishedURI string
+	var tolerance float64
+	cmd := &cobra.Command{
+		Use:   "compare-published",
+		Short: "Compare a bench fio result with the published gcsfuse performance numbers",
+		Long: `compare-published matches the jobs of a bench fio result with the published
+numbers of a gcsfuse release by workload and file size, and lists likely causes
+for the jobs below expectations. Jobs are matched by the names fio-render and
+the release jobfiles use, e.g. read_filesize_1M_blocksize_1M.`,
+		Example: `  gcsfuse-tools fio-render seq-read --out=read.fio
+  gcsfuse-tools bench fio --jobfile=read.fio --bucket=my-bucket --mount-point=/mnt -o json > run.json
+  gcsfuse-tools compare-published --result=run.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (resultFile == "") == (runID == "") {
+				return errors.New("exactly one of --result and --run-id is required")
+			}
+			if tolerance < 0 || tolerance >= 1 {
+				return errors.New("--tolerance must be in [0, 1)")
+			}
+			ctx := cmd.Context()
+
+			var b []byte
+			var err error
+			if runID != "" {
+				err = withRegistry(ctx, func(reg *registry.Registry) error {
+					b, err = reg.GetResultBlob(ctx, runID)
+					return err
+				})
+			} else {
+				b, err = os.ReadFile(resultFile)
+			}
+			if err != nil {
+				return err
+			}
+			var res bench.Result
+			if err := json.Unmarshal(b, &res); err != nil {
+				return fmt.Errorf("decoding bench fio result: %w", err)
+			}
+
+			var table *published.Table
+			if publishedURI == "" {
+				table, err = published.Embedded(release)
+			} else {
+				var client *storage.Client
+				if strings.HasPrefix(publishedURI, "gs://") {
+					if client, err = storage.NewClient(ctx); err != nil {
+						return fmt.Errorf("creating storage client: %w", err)
+					}
+					defer client.Close()
+				}
+				table, err = published.Load(ctx, client, publishedURI)
+			}
+			if err != nil {
+				return err
+			}
+			return writeResult(published.Compare(table, &res, tolerance))
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&resultFile, "result", "", "bench fio result written with -o json.")
+	f.StringVar(&runID, "run-id", "", "Run ID of a bench-fio result in --registry-bucket.")
+	f.StringVar(&release, "release", "", "Release of the embedded table to compare against: "+strings.Join(published.Releases(), ", ")+". Defaults to the latest.")
+	f.StringVar(&publishedURI, "published", "", "Published table as gs://BUCKET/OBJECT or a local JSON file, instead of the embedded one.")
+	f.Float64Var(&tolerance, "tolerance", 0.2, "Relative shortfall a job may have before it is reported as below expectations.")
+	return cmd
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/crash"
+	"gke-genAI-log-analyzer/analyzer"
+)
+
+func newCrashAnalyzeCmd() *cobra.Command {
+	var gemini bool
+	var region string
+	var clusters, frames int
+	cmd := &cobra.Command{
+		Use:   "crash-analyze [dump-file]",
+		Short: "Cluster the goroutines of a gcsfuse panic or SIGQUIT dump and flag known hang signatures",
+		Long: `crash-analyze reads gcsfuse panic output or a goroutine dump (kill -QUIT
+<gcsfuse pid> writes one to gcsfuse's stderr) from a file or stdin, groups
+goroutines with identical stacks and reports known signatures such as
+goroutines stuck in semacquire on cache locks. With --gemini the analysis is
+summarized by the same Vertex AI model the log analyzer uses.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var in io.Reader = os.Stdin
+			if len(args) == 1 {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+			dump, err := crash.Parse(in)
+			if err != nil {
+				return err
+			}
+			report := crash.Analyze(dump)
+			report.SetTextLimits(clusters, frames)
+
+			if gemini {
+				project, err := globals.requireProject()
+				if err != nil {
+					return err
+				}
+				if report.Summary, err = analyzer.Generate(cmd.Context(), project, region, report.Prompt()); err != nil {
+					return fmt.Errorf("gemini summary failed: %w", err)
+				}
+			}
+			return writeResult(report)
+		},
+	}
+	f := cmd.Flags()
+	f.BoolVar(&gemini, "gemini", false, "Ask Gemini on Vertex AI (in --project) for a summary of the analysis.")
+	f.StringVar(&region, "region", "us-central1", "Vertex AI region used with --gemini.")
+	f.IntVar(&clusters, "clusters", 10, "Number of goroutine clusters printed in text output.")
+	f.IntVar(&frames, "frames", 12, "Number of frames printed per cluster in text output.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newCrashAnalyzeCmd())
+}
@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+
+	"gcsfuse-tools-cli/internal/dataprep"
+	"gcsfuse-tools-cli/internal/registry"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+func newDataprepCmd() *cobra.Command {
+	cfg := dataprep.Config{}
+	var fileSize, dataset, rate, mix, maxBandwidth string
+	var preset, outputJSON, specFile, mtime, inventoryOutput, metricsAddr, csekKeyFile string
+	var buckets []string
+	var uniformAccess, listPresets, dryRun, yes bool
+	var softDelete time.Duration
+	cmd := &cobra.Command{
+		Use:   "dataprep",
+		Short: "Create or delete the GCS datasets used by read benchmarks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if listPresets {
+				return writeResult(dataprep.PresetCatalog(dataprep.Presets))
+			}
+			if preset != "" {
+				p, err := dataprep.LookupPreset(preset)
+				if err != nil {
+					return err
+				}
+				for _, name := range []string{"bench-type", "filesize", "numjobs", "nrfiles"} {
+					if cmd.Flags().Changed(name) {
+						return fmt.Errorf("--%s cannot be combined with --preset, which sets it", name)
+					}
+				}
+				cfg.BenchType, fileSize, cfg.NumJobs, cfg.NrFiles = p.BenchType, p.FileSize, p.NumJobs, p.NrFiles
+			}
+			if specFile != "" {
+				for _, name := range []string{"preset", "filesize", "numjobs", "nrfiles"} {
+					if cmd.Flags().Changed(name) {
+						return fmt.Errorf("--%s cannot be combined with --spec-file", name)
+					}
+				}
+				if cfg.Classes, err = dataprep.LoadClasses(specFile); err != nil {
+					return fmt.Errorf("loading --spec-file: %w", err)
+				}
+			}
+			if csekKeyFile != "" {
+				if cfg.EncryptionKey, err = dataprep.LoadEncryptionKey(csekKeyFile); err != nil {
+					return fmt.Errorf("loading --csek-key-file: %w", err)
+				}
+			}
+			cfg.Project = globals.project
+			// GCS names storage classes in upper case; accept --storage-class=nearline.
+			cfg.StorageClass = strings.ToUpper(cfg.StorageClass)
+			if cfg.FileSize, err = units.ParseSize(fileSize); err != nil {
+				return fmt.Errorf("parsing --filesize: %w", err)
+			}
+			if maxBandwidth != "" {
+				if cfg.MaxBandwidth, err = units.ParseSize(maxBandwidth); err != nil {
+					return fmt.Errorf("parsing --max-bandwidth: %w", err)
+				}
+			}
+			if cfg.OpType == dataprep.OpChurn {
+				if rate != "" {
+					if cfg.ChurnRate, err = dataprep.ParseRate(rate); err != nil {
+						return fmt.Errorf("parsing --rate: %w", err)
+					}
+				}
+				if cfg.ChurnMix, err = dataprep.ParseChurnMix(mix); err != nil {
+					return fmt.Errorf("parsing --churn-mix: %w", err)
+				}
+			}
+			if cfg.OpType == dataprep.OpSetup && cfg.RunID == "" {
+				cfg.RunID = registry.NewRunID()
+			}
+			if mtime != "" {
+				if cfg.Mtime, err = time.Parse(time.RFC3339, mtime); err != nil {
+					return fmt.Errorf("parsing --mtime: %w", err)
+				}
+			}
+			if cmd.Flags().Changed("uniform-bucket-level-access") {
+				cfg.UniformAccess = &uniformAccess
+			}
+			if cmd.Flags().Changed("soft-delete-retention") {
+				cfg.SoftDeleteRetention = &softDelete
+			}
+			var targets []dataprep.BucketTarget
+			if len(buckets) > 0 {
+				if cfg.Bucket != "" {
+					return errors.New("--bucket cannot be combined with --buckets")
+				}
+				if cfg.OpType == dataprep.OpVerify || cfg.OpType == dataprep.OpChecksumVerify || cfg.OpType == dataprep.OpInventory {
+					return fmt.Errorf("--buckets is not supported with %s", cfg.OpType)
+				}
+				if dataset != "" {
+					return errors.New("--dataset cannot be combined with --buckets; datasets are registered under their bucket names")
+				}
+				if targets, err = dataprep.ParseBuckets(buckets, cfg.Location); err != nil {
+					return err
+				}
+				for _, t := range targets {
+					c := cfg.ForBucket(t)
+					if err := c.Validate(); err != nil {
+						return fmt.Errorf("%s: %w", t.Name, err)
+					}
+				}
+			} else if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if dryRun {
+				if len(targets) > 0 {
+					plans := make(dataprep.Plans, len(targets))
+					for i, t := range targets {
+						plans[i] = dataprep.NewPlan(cfg.ForBucket(t))
+					}
+					return writeResult(plans)
+				}
+				return writeResult(dataprep.NewPlan(cfg))
+			}
+			if cfg.OpType == dataprep.OpDelete && cfg.DeletePrefix == "" && !yes {
+				names := []string{cfg.Bucket}
+				if len(targets) > 0 {
+					names = nil
+					for _, t := range targets {
+						names = append(names, t.Name)
+					}
+				}
+				if err := confirmWipe(names, cfg.KeepBucket); err != nil {
+					return err
+				}
+			}
+
+			ctx := cmd.Context()
+			if metricsAddr != "" {
+				cfg.Metrics = dataprep.NewMetrics()
+				stop, err := serveMetrics(metricsAddr, cfg.Metrics)
+				if err != nil {
+					return err
+				}
+				defer stop()
+			}
+			client, err := dataprep.NewClient(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			if cfg.OpType == dataprep.OpVerify {
+				report, err := dataprep.Verify(ctx, client, cfg)
+				if err != nil {
+					return err
+				}
+				if err := writeResult(report); err != nil {
+					return err
+				}
+				if !report.OK() {
+					return fmt.Errorf("%d missing and %d wrongly sized objects in gs://%s", report.Missing, report.Mismatched, cfg.Bucket)
+				}
+				return nil
+			}
+			if cfg.OpType == dataprep.OpChecksumVerify {
+				report, err := dataprep.ChecksumVerify(ctx, client, cfg)
+				if err != nil {
+					return err
+				}
+				if err := writeResult(report); err != nil {
+					return err
+				}
+				if !report.OK() {
+					return fmt.Errorf("%d of %d sampled objects in gs://%s do not match %s", report.Failed, report.Sampled, cfg.Bucket, dataprep.ManifestObject)
+				}
+				return nil
+			}
+			if cfg.OpType == dataprep.OpInventory {
+				return inventory(ctx, client, cfg, inventoryOutput)
+			}
+			if len(targets) > 0 {
+				summaries, err := dataprep.RunBuckets(ctx, client, cfg, targets)
+				writeSummary(outputJSON, summaries)
+				for i, t := range targets {
+					// Failed buckets are in err; register the others.
+					if summaries[i].Error == "" {
+						if rerr := registerDataset(ctx, client, cfg.ForBucket(t), t.Name); rerr != nil {
+							err = errors.Join(err, rerr)
+						}
+					}
+				}
+				return err
+			}
+			summary, err := dataprep.Run(ctx, client, cfg)
+			writeSummary(outputJSON, summary)
+			if err != nil {
+				return err
+			}
+			if dataset == "" {
+				dataset = cfg.Bucket
+			}
+			return registerDataset(ctx, client, cfg, dataset)
+		},
+	}
+
+	f := cmd.Flags()
+	// The flags were spelled with underscores before they followed the other
+	// commands; keep those spellings working.
+	f.SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		return pflag.NormalizedName(strings.ReplaceAll(name, "_", "-"))
+	})
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket to create and populate, or to delete.")
+	f.StringSliceVar(&buckets, "buckets", nil, "Run the operation on several buckets concurrently instead of --bucket, e.g. bench-us,bench-eu:europe-west4. A bucket without :LOCATION uses --location.")
+	f.StringVar(&cfg.BillingProject, "billing-project", "", "Project billed for the requests to the bucket, required by requester pays buckets, e.g. when the bucket lives in another project of a multi-project benchmark setup.")
+	f.StringVar(&cfg.Location, "location", "us-central1", "Location of the created bucket.")
+	f.BoolVar(&uniformAccess, "uniform-bucket-level-access", false, "Create the bucket with uniform bucket-level access, or with fine-grained ACLs when false. Defaults to uniform with --grant-member, which requires it, and fine-grained otherwise. Setup fails if an organization policy overrides the choice.")
+	f.StringVar(&cfg.PublicAccessPrevention, "public-access-prevention", "", "Public access prevention of the created bucket: enforced or inherited. Defaults to the project's setting.")
+	f.StringVar(&cfg.StorageClass, "storage-class", "", "Default storage class of the created bucket: STANDARD, NEARLINE, COLDLINE or ARCHIVE. Defaults to STANDARD.")
+	f.BoolVar(&cfg.Autoclass, "autoclass", false, "Create the bucket with Autoclass, which moves objects between storage classes by access.")
+	f.BoolVar(&cfg.Versioning, "versioning", false, "Create the bucket with object versioning, keeping the generations churn overwrites as noncurrent versions. Delete removes every generation.")
+	f.DurationVar(&softDelete, "soft-delete-retention", 0, "Soft delete retention of the created bucket, from 168h to 2160h, or 0 to disable soft delete. Defaults to the project's setting, usually 7 days.")
+	f.StringVar(&cfg.BucketType, "bucket-type", dataprep.BucketFlat, "Namespace of the created bucket: flat or hns. HNS buckets require uniform bucket-level access.")
+	f.StringVar(&cfg.OpType, "op-type", dataprep.OpSetup, "Operation: setup, delete, grant (temporary access to an existing bucket), revoke (remove the temporary grants), churn (mutate the dataset while a benchmark runs) verify (check that every object exists with --filesize bytes, exiting non-zero otherwise), checksum-verify (read --sample objects and check their content against the checksums setup recorded in manifest.json, exiting non-zero on a mismatch) or inventory (list the name, size, storage class and generation of every object, under --prefix if set, without modifying anything).")
+	f.StringVar(&cfg.BenchType, "bench-type", dataprep.BenchRandRead, "Benchmark the dataset is prepared for: rand-read, seq-read, small-files, checkpoint, write or rand-write. Used as the object name prefix. Write datasets get the directories of --dir-depth and, for rand-write or with --prefill, objects to overwrite.")
+	f.BoolVar(&cfg.Prefill, "prefill", false, "With --bench-type=write, create the objects for the benchmark to overwrite instead of leaving the bucket empty.")
+	f.StringVar(&preset, "preset", "", "Named dataset behind the published performance tables, e.g. seq-read-100x1G. Sets --bench-type, --filesize, --numjobs and --nrfiles.")
+	f.BoolVar(&listPresets, "list-presets", false, "List the presets instead of preparing a dataset.")
+	f.StringVar(&fileSize, "filesize", "1G", "Size of each object (e.g. 128K, 1M, 1G).")
+	f.IntVar(&cfg.NumJobs, "numjobs", 1, "Number of fio jobs the dataset is laid out for.")
+	f.IntVar(&cfg.NrFiles, "nrfiles", 1, "Number of files per fio job.")
+	f.StringVar(&specFile, "spec-file", "", "YAML or JSON file of file-size classes for a mixed dataset, e.g. classes: [{prefix: small, filesize: 4K, count: 1000}, {prefix: large, filesize: 10G, numjobs: 10, nrfiles: 1}], used by setup and verify instead of --filesize, --numjobs and --nrfiles. Objects are named <prefix>.<job>.<file>.")
+	f.IntVar(&cfg.DirDepth, "dir-depth", 0, "Place each job's files in a balanced directory tree this deep, e.g. <bench_type>.0/d0/d3/7; mount flat buckets with --implicit-dirs. 0 keeps the flat <bench_type>.<job>.<file> names.")
+	f.IntVar(&cfg.FilesPerDir, "files-per-dir", 100, "With --dir-depth, number of files in each leaf directory.")
+	f.StringVar(&cfg.NameTemplate, "name-template", "", "Name the objects after this template instead of <bench_type>.<job>.<file>, e.g. data/{prefix}_{size}/job{job}_file{file} to match a fio filename_format. Placeholders: {prefix} (the bench type or class prefix), {job}, {file} and {size}, e.g. 128M. {job} and {file} are required, each delimited by characters other than digits and placeholders so the names are distinct.")
+	f.StringVar(&cfg.Data, "data", dataprep.DataZero, "Content of the objects: zero, random (incompressible, from --data-seed) or pattern (every 8-byte word holds its offset). All objects are copies of one source object.")
+	f.Uint64Var(&cfg.DataSeed, "data-seed", 1, "Seed of --data=random, so a setup can be repeated byte for byte.")
+	f.StringVar(&cfg.ContentType, "content-type", "", "Content type set on every copy, e.g. application/octet-stream, which gcsfuse returns in its object attributes. Empty keeps the type of the source object.")
+	f.StringToStringVar(&cfg.Metadata, "metadata", nil, "Custom metadata set on every copy, e.g. owner=bench,run=42.")
+	f.StringVar(&mtime, "mtime", "", "RFC 3339 time stored as the gcsfuse_mtime metadata of the first copy, which gcsfuse reports as its mtime instead of the upload time, so metadata cache and --file-mode/--uid tests see the same attributes in every run.")
+	f.DurationVar(&cfg.MtimeStep, "mtime-step", 0, "With --mtime, add this much to the mtime of every further object of the job matrix, e.g. 1s, for distinct but deterministic mtimes.")
+	f.BoolVar(&cfg.Resume, "resume", false, "Continue an interrupted setup: reuse the bucket if it exists and copy only the objects that are missing or have the wrong size.")
+	f.BoolVar(&cfg.SkipBucketCreate, "skip-bucket-create", false, "Populate --bucket, which must already exist, instead of creating it, e.g. a pre-created bucket with CMEK or requester pays. Requester pays requests are billed to --billing-project, or --project.")
+	f.StringVar(&cfg.DeletePrefix, "prefix", "", "With delete, only delete the objects (and HNS folders) with this prefix, e.g. rand-read., and keep the bucket, for benchmarks that share a bucket. With inventory, only list the objects with this prefix.")
+	f.StringVar(&inventoryOutput, "inventory-output", "-", "With inventory, write the objects to this file, or to stdout with -. The totals by storage class are printed unless the objects go to stdout.")
+	f.StringVar(&cfg.InventoryFormat, "inventory-format", dataprep.InventoryCSV, "With inventory, format of the objects: csv or json (an array of {name, size, storage_class, generation}).")
+	f.BoolVar(&cfg.KeepBucket, "keep-bucket", false, "With delete, empty the bucket but do not delete it.")
+	f.BoolVar(&cfg.Force, "force", false, "With delete, wipe the bucket even if it lacks the created-by=gcsfuse-data-prep label setup puts on the buckets it creates.")
+	f.StringVar(&cfg.RunID, "run-id", "", "Run ID setup labels the created bucket with, as gcsfuse-data-prep-run. Generated if empty.")
+	f.DurationVar(&cfg.Expiry, "expiry", 0, "Label the created bucket with expires=<date> this long after setup, e.g. 168h, for cleanup jobs. 0 adds no expiry label.")
+	f.BoolVar(&yes, "yes", false, "Delete every object of the bucket without --prefix without asking for confirmation.")
+	f.IntVar(&cfg.Workers, "workers", 64, "Number of concurrent copy/delete workers.")
+	f.IntVar(&cfg.UploadParallelism, "upload-parallelism", 1, "Upload the source object in up to this many parts of at least 8MiB concurrently and compose them, up to 32, to speed up the creation of very large objects. The content does not depend on it.")
+	f.BoolVar(&dryRun, "dry-run", false, "Print the objects, bytes and Class A/B requests the operation would send and its approximate cost at Standard list prices for --location, without calling Cloud Storage.")
+	f.StringVar(&outputJSON, "output-json", "", "Write a JSON summary of the run (bucket, location, objects, bytes, errors and the elapsed time of every phase) to this file, or to stdout with -, also when the run fails. Not used by verify.")
+	f.Float64Var(&cfg.MaxQPS, "max-qps", 0, "Most copy, delete and upload requests per second to each bucket, shared by all --workers. 0 is unlimited. Requests GCS throttled (429/503) are counted in the summary either way.")
+	f.StringVar(&maxBandwidth, "max-bandwidth", "", "Most bytes per second copied or uploaded to each bucket, e.g. 500M. Empty is unlimited.")
+	d := dataprep.DefaultRetryPolicy
+	f.IntVar(&cfg.Retry.MaxAttempts, "retry-attempts", d.MaxAttempts, "Most times a copy, delete or upload is sent before it fails.")
+	f.DurationVar(&cfg.Retry.InitialBackoff, "retry-initial-backoff", d.InitialBackoff, "Wait after the first failure of a request, doubled after every further one.")
+	f.DurationVar(&cfg.Retry.MaxBackoff, "retry-max-backoff", d.MaxBackoff, "Longest wait between two attempts.")
+	f.Float64Var(&cfg.Retry.Jitter, "retry-jitter", d.Jitter, "Fraction of every wait that is random, from 0 (fixed waits) to 1 (full jitter).")
+	f.StringSliceVar(&cfg.Retry.RetryOn, "retry-on", d.RetryOn, "Error classes retried: throttled (429/503), server (5xx), timeout, network, client (other 4xx) and other. The summary counts failed requests by class.")
+	f.StringVar(&cfg.Protocol, "client-protocol", dataprep.ProtocolHTTP, "API the storage client speaks: http (JSON API) or grpc, to compare the upload throughput of both transports. The summary records the protocol and the MiB/s of every phase.")
+	f.IntVar(&cfg.GRPCConnPool, "grpc-conn-pool-size", 0, "Number of gRPC connections of the client with --client-protocol=grpc. 0 uses the library default.")
+	f.StringVar(&csekKeyFile, "csek-key-file", "", "File holding a base64 encoded AES-256 customer-supplied encryption key (e.g. from openssl rand -base64 32). setup and churn write the objects encrypted with it and checksum-verify reads them with it; gcsfuse cannot read them, see coherence csek.")
+	f.BoolVar(&cfg.Manifest, "manifest", false, "With setup, record the CRC32C and MD5 of every class's source object in a manifest.json object of the bucket, for checksum-verify. Off by default, as listing benchmarks of the bucket would count the object.")
+	f.IntVar(&cfg.Sample, "sample", 100, "Objects checksum-verify reads, chosen at random. 0 reads every object.")
+	f.StringVar(&cfg.Checksum, "checksum", dataprep.ChecksumAuto, "Checksums of the uploads: auto (the client library computes the CRC32C while uploading), crc32c or md5 (computed in a pass over the content before uploading, which GCS then verifies) or none. Except with none, every copy is verified against the CRC32C of the source object. The summary records the mode.")
+	f.DurationVar(&cfg.ProgressInterval, "progress-interval", 10*time.Second, "Log objects/sec, MiB/sec, completed/total and the time remaining of copies and deletes this often. 0 disables it.")
+	f.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics of the run on http://ADDR/metrics, e.g. :9090, for watching long runs remotely: in-flight requests, planned objects, failed requests by error class and latency histograms of the uploads, copies and deletes, whose counts are the completed requests. Google Cloud Managed Service for Prometheus can scrape it.")
+	f.StringVar(&cfg.Hold, "hold", "", "Hold placed on the protected objects: event or temporary. Deleting or overwriting them through gcsfuse must fail with EPERM.")
+	f.DurationVar(&cfg.Retention, "retention", 0, "Unlocked object retention placed on the protected objects for this long. The bucket is created with object retention enabled.")
+	f.IntVar(&cfg.ProtectEvery, "protect-every", 10, "With --hold or --retention, protect files 0, N, 2N, ... of every job.")
+	f.StringVar(&rate, "rate", "", "Churn operations per second, e.g. 50/s or 600/m.")
+	f.DurationVar(&cfg.ChurnInterval, "churn-interval", 0, "Instead of --rate, overwrite every one of the --churn-percent objects once per interval, e.g. 5m, giving each a new generation per interval to benchmark metadata and file cache invalidation.")
+	f.DurationVar(&cfg.Duration, "duration", time.Hour, "How long churn runs.")
+	f.StringVar(&mix, "churn-mix", "create=30,overwrite=40,delete=30", "Percentage of each churn operation. Creates restore deleted objects before adding further files to the jobs, named like the dataset's.")
+	f.IntVar(&cfg.ChurnPercent, "churn-percent", 10, "Percentage of the dataset's objects churn may overwrite or delete. --bench-type, --filesize, --numjobs, --nrfiles and the directory layout must match the setup.")
+	f.StringVar(&cfg.GrantMember, "grant-member", "", "IAM member given time-bound access to the bucket by setup and grant, e.g. serviceAccount:runner@PROJECT.iam.gserviceaccount.com. With revoke, only this member's grants are removed.")
+	f.StringVar(&cfg.GrantRole, "grant-role", "roles/storage.objectAdmin", "Role of the time-bound grant.")
+	f.DurationVar(&cfg.GrantTTL, "grant-ttl", 24*time.Hour, "Lifetime of the grant, enforced by an IAM condition on request.time.")
+	f.StringVar(&cfg.FioJobFile, "fio-jobfile", "", "After setup, write a fio jobfile to this path whose jobs read (or write) exactly the prepared objects, with their numjobs, nrfiles, filesize, rw mode and names, one job per class, so the benchmark does not repeat the dataset flags.")
+	f.StringVar(&cfg.FioDirectory, "fio-directory", "", "Directory of the --fio-jobfile jobs, the mount point of the bucket. Empty writes ${DIR}, which fio takes from the environment.")
+	f.StringVar(&cfg.EmitDir, "emit-terraform", "", "After setup or grant, write definitions of the bucket, its time-bound grants and lifecycle rules to this directory, to import the environment into infrastructure as code.")
+	f.StringVar(&cfg.EmitFormat, "emit-format", dataprep.EmitTerraform, "Format of --emit-terraform: terraform (<bucket>.tf with an import block) or kcc (<bucket>.yaml with Config Connector resources).")
+	f.StringVar(&dataset, "dataset", "", "Name the dataset is registered under in --registry-bucket. Defaults to --bucket.")
+	return cmd
+}
+
+// confirmWipe guards a delete without --prefix, which removes every object
+// of the buckets, including those other benchmarks share them for. On a
+// terminal the user confirms by typing the bucket names; otherwise --yes is
+// required.
+func confirmWipe(buckets []string, keepBucket bool) error {
+	what := "every object of gs://" + strings.Join(buckets, ", gs://")
+	if !keepBucket {
+		what += " and the bucket itself"
+	}
+	if _, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS); err != nil {
+		return fmt.Errorf("delete without --prefix removes %s; pass --yes to confirm, or --prefix to only delete the benchmark's objects", what)
+	}
+	want := strings.Join(buckets, ",")
+	fmt.Fprintf(os.Stderr, "This deletes %s. Type %s to continue: ", what, want)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(line) != want {
+		return errors.New("delete not confirmed")
+	}
+	return nil
+}
+
+// writeSummary writes the --output-json summary of a run, if requested. A
+// failure is logged rather than returned so that it does not hide the
+// outcome of the run.
+func writeSummary(path string, v any) {
+	if path == "" {
+		return
+	}
+	if err := dataprep.WriteJSON(path, v); err != nil {
+		slog.Error("Writing the run summary failed", "path", path, "err", err)
+	}
+}
+
+// inventory writes the inventory of cfg.Bucket to path, or to stdout if path
+// is "-", and prints the totals after a file.
+func inventory(ctx context.Context, client *storage.Client, cfg dataprep.Config, path string) (err error) {
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+		w = f
+	}
+	report, err := dataprep.Inventory(ctx, client, cfg, w)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		slog.Info("Listed inventory", "bucket", cfg.Bucket, "objects", report.Objects, "bytes", report.Bytes)
+		return nil
+	}
+	return writeResult(report)
+}
+
+// registerDataset records the dataset a setup prepared in --registry-bucket,
+// if set, under name. Callers write the summary first, so a registry failure
+// does not lose it.
+func registerDataset(ctx context.Context, client *storage.Client, cfg dataprep.Config, name string) error {
+	if cfg.OpType != dataprep.OpSetup || globals.registryBucket == "" {
+		return nil
+	}
+	spec := cfg.Spec()
+	count := cfg.ObjectCount()
+	e := &registry.DatasetEntry{
+		Name:     name,
+		Bucket:   cfg.Bucket,
+		Location: cfg.Location,
+		SpecHash: spec.Hash(),
+		Spec:     spec,
+		Manifest: registry.Manifest{
+			NamePattern: cfg.NamePattern(),
+			ObjectCount: count,
+			TotalBytes:  cfg.TotalBytes(),
+		},
+	}
+	if err := registry.New(client, globals.registryBucket).PutDataset(ctx, e); err != nil {
+		return fmt.Errorf("dataset %s was prepared but not registered in --registry-bucket: %w", name, err)
+	}
+	slog.Info("Registered dataset", "name", e.Name, "spec_hash", e.SpecHash)
+	return nil
+}
+
+// serveMetrics serves m on http://addr/metrics until stop is called.
+func serveMetrics(addr string, m *dataprep.Metrics) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on --metrics-addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	slog.Info("Serving metrics", "url", "http://"+ln.Addr().String()+"/metrics")
+	return func() { srv.Close() }, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newDataprepCmd())
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/doctor"
+)
+
+func newDoctorCmd() *cobra.Command {
+	cfg := doctor.Config{}
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that this host is ready to run the gcsfuse tools",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report := doctor.Run(cmd.Context(), cfg)
+			if err := writeResult(report); err != nil {
+				return err
+			}
+			if report.Failed() {
+				return errors.New("one or more checks failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cfg.Bucket, "bucket", "", "Also check metadata access to this bucket.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newDoctorCmd())
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/drift"
+)
+
+func newDriftCheckCmd() *cobra.Command {
+	kube := drift.KubeConfig{}
+	var goldenFile string
+	cmd := &cobra.Command{
+		Use:   "drift-check",
+		Short: "Compare the gcsfuse CSI volumes of a cluster against a golden mount configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if goldenFile == "" {
+				return errors.New("--golden is required")
+			}
+			golden, err := drift.LoadGolden(goldenFile)
+			if err != nil {
+				return err
+			}
+			volumes, err := drift.ListVolumes(cmd.Context(), kube)
+			if err != nil {
+				return err
+			}
+			report := drift.Check(golden, volumes)
+			if err := writeResult(report); err != nil {
+				return err
+			}
+			if n := report.Drifted(); n > 0 {
+				return fmt.Errorf("%d volume(s) drifted from %s", n, goldenFile)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&goldenFile, "golden", "", "YAML file with the expected mount_options, volume_attributes, allowed_options and deprecated options.")
+	f.StringVar(&kube.Namespace, "namespace", "", "Namespace to check. Defaults to all namespaces.")
+	f.StringVar(&kube.Context, "context", "", "kubeconfig context of the cluster. Defaults to the current context.")
+	f.StringVar(&kube.Kubectl, "kubectl", "kubectl", "Path to the kubectl binary.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newDriftCheckCmd())
+}
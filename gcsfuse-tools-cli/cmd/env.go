@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+func newEnvCmd() *cobra.Command {
+	opts := envinfo.Options{}
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print the environment fingerprint attached to benchmark and coherence results",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeResult(envinfo.Capture(cmd.Context(), opts))
+		},
+	}
+	cmd.Flags().StringVar(&opts.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "gcsfuse binary whose version is recorded.")
+	cmd.Flags().StringVar(&opts.MountPoint, "mount-point", "", "Only report this gcsfuse mount. Defaults to all gcsfuse mounts.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newEnvCmd())
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/fiojob"
+)
+
+func init() {
+	rootCmd.AddCommand(newFioRenderCmd())
+}
+
+func newFioRenderCmd() *cobra.Command {
+	var p fiojob.Params
+	var list bool
+	var out string
+	cmd := &cobra.Command{
+		Use:   "fio-render TEMPLATE",
+		Short: "Render a standard release-benchmark fio jobfile with the given sizes, threads and runtime",
+		Long: `Render one of the embedded release-benchmark jobfiles (seq-read, rand-read,
+write, mixed, small-files). Unset parameters take the template's defaults,
+which --list prints. The jobfile is written to --out or stdout and can be
+passed to bench fio --jobfile.`,
+		Example: `  gcsfuse-tools fio-render --list
+  gcsfuse-tools fio-render seq-read --sizes=1M,1G --threads=48 --runtime=5m --out=read.fio`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if list {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				return writeResult(fiojob.Catalog(fiojob.Templates))
+			}
+			if out == "" {
+				return fiojob.Render(os.Stdout, args[0], p)
+			}
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			if err := fiojob.Render(f, args[0], p); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("writing %s: %w", out, err)
+			}
+			slog.Info("Wrote jobfile", "template", args[0], "path", out)
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVar(&list, "list", false, "List the templates and their defaults instead of rendering one.")
+	f.StringVar(&out, "out", "", "File to write the jobfile to. If empty, it is printed to stdout.")
+	f.StringSliceVar(&p.Sizes, "sizes", nil, "File sizes, one stonewalled job each, e.g. --sizes=256K,1M,1G.")
+	f.StringVar(&p.BlockSize, "block-size", "", "Block size of every job. If empty, 128K for files up to 256K and 1M otherwise.")
+	f.IntVar(&p.NrFiles, "nrfiles", 0, "Files per job thread. If 0, shrinks with the file size as in the release jobfiles.")
+	f.IntVar(&p.Threads, "threads", 0, "fio numjobs.")
+	f.IntVar(&p.IODepth, "iodepth", 0, "fio iodepth.")
+	f.DurationVar(&p.Runtime, "runtime", 0, "Make every job time based and run it this long. If 0, jobs run until their files are read or written once.")
+	f.IntVar(&p.ReadMix, "read-mix", 0, "Read percentage of the mixed template.")
+	f.StringVar(&p.Directory, "directory", "", "directory= written to every job, e.g. '${DIR}' for the release scripts. bench fio sets it on the command line.")
+	return cmd
+}
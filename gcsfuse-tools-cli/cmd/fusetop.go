@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/fusetop"
+	"gcsfuse-tools-cli/internal/output"
+)
+
+func newFuseTopCmd() *cobra.Command {
+	cfg := fusetop.Config{}
+	cmd := &cobra.Command{
+		Use:   "fuse-top",
+		Short: "Show live per-operation rates, latencies, cache hit ratio and hot files of a gcsfuse mount",
+		Long: `fuse-top samples a running gcsfuse mount every --interval and redraws a view
+of FUSE op and GCS request rates with mean, p50 and p99 latencies, the file
+cache hit ratio and read throughput, and the files read the most.
+
+--metrics-url reads the Prometheus endpoint gcsfuse serves with
+--prometheus-port. --log-file follows a log written with
+--log-severity=trace and is the only source of hot files; with both, the
+metrics provide the rates and the log the hot files. When stdout is not a
+terminal, or with -o json, frames are printed one after another.`,
+		Example: `  gcsfuse-tools fuse-top --metrics-url=http://localhost:9190/metrics --log-file=/tmp/gcsfuse.log
+  gcsfuse-tools fuse-top --log-file=/tmp/gcsfuse.log --interval=5s --iterations=12 -o json > frames.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			redraw := globals.format == output.Text && isTerminal(os.Stdout)
+			return fusetop.Run(ctx, cfg, func(f *fusetop.Frame) error {
+				if redraw {
+					// Move home and clear the screen.
+					os.Stdout.WriteString("\033[H\033[2J")
+				}
+				if err := writeResult(f); err != nil {
+					return err
+				}
+				if !redraw && globals.format == output.Text {
+					os.Stdout.WriteString("\n")
+				}
+				return nil
+			})
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.MetricsURL, "metrics-url", "", "gcsfuse Prometheus endpoint, e.g. http://localhost:9190/metrics for --prometheus-port=9190.")
+	f.StringVar(&cfg.LogFile, "log-file", "", "gcsfuse log file written with --log-severity=trace, followed from its current end.")
+	f.DurationVar(&cfg.Interval, "interval", 2*time.Second, "Refresh interval.")
+	f.IntVar(&cfg.Iterations, "iterations", 0, "Stop after this many refreshes. 0 runs until interrupted.")
+	f.IntVar(&cfg.Top, "top", 10, "Number of hot files to show.")
+	return cmd
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	rootCmd.AddCommand(newFuseTopCmd())
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/gkebench"
+)
+
+func newGKEBenchCmd() *cobra.Command {
+	cfg := gkebench.Config{}
+	var dataset string
+	cmd := &cobra.Command{
+		Use:   "gke-bench",
+		Short: "Run an fio jobfile in a GKE pod on a chosen node pool or machine family and record its placement",
+		Long: `gke-bench runs an fio jobfile in a pod that mounts --bucket with the Cloud
+Storage FUSE CSI driver. --node-pool, --machine-family, --machine-type and
+--local-ssd pin the pod to matching nodes through their GKE labels; once the
+pod is scheduled its node's labels are checked against the request and the
+run fails if they don't match. The node's pool, machine type, zone and local
+SSD are recorded in the result, and stand in for the environment fingerprint
+in --registry-bucket, so results from different hardware are not compared as
+if they were alike.`,
+		Example: `  gcsfuse-tools gke-bench --bucket=my-bucket --jobfile=read.fio --service-account=gcsfuse-ksa \
+    --machine-family=c3 --local-ssd --file-cache-capacity=100Gi`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := gkebench.Run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "gke-bench", dataset, res.Env, res)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket mounted in the pod with the Cloud Storage FUSE CSI driver.")
+	f.StringVar(&cfg.JobFile, "jobfile", "", "fio jobfile to run; it runs in the mounted bucket.")
+	f.StringVar(&cfg.Image, "image", "ubuntu:24.04", "Container image that runs fio. fio is installed with apt-get when missing.")
+	f.StringSliceVar(&cfg.MountOptions, "mount-options", nil, "gcsfuse mount options of the volume, e.g. --mount-options=implicit-dirs,metadata-cache:ttl-secs:-1.")
+	f.StringVar(&cfg.FileCacheCapacity, "file-cache-capacity", "", "Enable the gcsfuse file cache with this capacity, e.g. 100Gi. Kept on local SSD with --local-ssd.")
+	f.StringVar(&cfg.NodePool, "node-pool", "", "Run on this node pool ("+gkebench.LabelNodePool+").")
+	f.StringVar(&cfg.MachineFamily, "machine-family", "", "Run on nodes of this machine family, e.g. c3 or n2 ("+gkebench.LabelMachineFamily+").")
+	f.StringVar(&cfg.MachineType, "machine-type", "", "Run on nodes of this machine type, e.g. c3-standard-88 ("+gkebench.LabelMachineType+").")
+	f.BoolVar(&cfg.LocalSSD, "local-ssd", false, "Run on nodes with local SSD ephemeral storage ("+gkebench.LabelLocalSSD+").")
+	f.StringToStringVar(&cfg.NodeSelector, "node-selector", nil, "Additional node labels to require, e.g. --node-selector=cloud.google.com/gke-spot=true.")
+	f.StringSliceVar(&cfg.Tolerations, "tolerations", nil, "Tolerations for tainted node pools as key[=value]:effect, e.g. nvidia.com/gpu:NoSchedule.")
+	f.StringVar(&cfg.ServiceAccount, "service-account", "", "Kubernetes service account of the pod, with Workload Identity access to --bucket.")
+	f.StringVar(&cfg.Namespace, "namespace", "default", "Namespace of the benchmark pod.")
+	f.StringVar(&cfg.Kubectl, "kubectl", "kubectl", "Path to the kubectl binary.")
+	f.StringVar(&cfg.KubeContext, "context", "", "kubectl context of the cluster. Defaults to the current context.")
+	f.DurationVar(&cfg.Timeout, "timeout", time.Hour, "Give up when the pod has not finished after this long.")
+	f.BoolVar(&cfg.Keep, "keep", false, "Keep the pod and its ConfigMap after the run.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newGKEBenchCmd())
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/imagebuild"
+)
+
+func newImageBuildCmd() *cobra.Command {
+	cfg := imagebuild.Config{}
+	var manifest string
+	cmd := &cobra.Command{
+		Use:   "image-build",
+		Short: "Build VM and container images with gcsfuse, fio and gcsfuse-tools pinned by a manifest",
+		Long: `image-build builds benchmark images containing the gcsfuse, fio and
+gcsfuse-tools versions a YAML --manifest pins, so benchmark environments are
+identical across runs and teams:
+
+  gcsfuse: 2.5.1             # release .deb from GitHub
+  fio: "3.38"                # built from the fio-3.38 tag
+  gcsfuse_tools: v0.7.0      # release make publish wrote to artifacts_bucket
+  artifacts_bucket: my-artifacts
+  arch: amd64                # or arm64
+  base_image: ubuntu:24.04   # of the container image
+  vm_image_family: ubuntu-2404-lts-amd64
+  vm_image_project: ubuntu-os-cloud
+  packages: [python3]        # extra apt packages
+
+The SHA-256 of the gcsfuse-tools binary is resolved from the bucket unless
+gcsfuse_tools_sha256 pins it. One bootstrap script, written to --dir with the
+Dockerfile and the resolved manifest, installs everything: the container image
+runs it in a build step and is tagged with the manifest hash, and the VM image
+is made from the disk of a temporary VM that ran it as its startup script.
+Both record the manifest in /etc/gcsfuse-tools/image-manifest.json, and the
+environment fingerprint of every result names its hash.`,
+		Example: `  gcsfuse-tools image-build --manifest=image.yaml --kinds=container --repository=us-docker.pkg.dev/my-project/bench/gcsfuse-bench --push
+  gcsfuse-tools image-build --manifest=image.yaml --kinds=vm --project=my-project --zone=us-central1-a
+  gcsfuse-tools image-build --manifest=image.yaml --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if manifest == "" {
+				return errors.New("--manifest is required")
+			}
+			cfg.Project = globals.project
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			m, err := imagebuild.LoadManifest(manifest)
+			if err != nil {
+				return err
+			}
+			cfg.Manifest = m
+			ctx := cmd.Context()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			res, err := imagebuild.Build(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			return writeResult(res)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&manifest, "manifest", "", "YAML manifest pinning the versions of the image.")
+	f.StringVar(&cfg.Dir, "dir", "image-build", "Directory receiving the build context: bootstrap.sh, Dockerfile, image-manifest.json and, for the container image, the gcsfuse-tools binary.")
+	f.StringSliceVar(&cfg.Kinds, "kinds", []string{imagebuild.KindContainer, imagebuild.KindVM}, "Images to build: container and/or vm.")
+	f.StringVar(&cfg.Repository, "repository", "", "Repository of the container image, e.g. us-docker.pkg.dev/PROJECT/REPO/gcsfuse-bench. The tag is the manifest hash.")
+	f.BoolVar(&cfg.Push, "push", false, "Push the container image after building it.")
+	f.StringVar(&cfg.Docker, "docker", "docker", "Path to the docker binary.")
+	f.StringVar(&cfg.Zone, "zone", "", "Zone of the temporary VM of the VM image build.")
+	f.StringVar(&cfg.MachineType, "machine-type", "e2-standard-4", "Machine type of the temporary VM; use an Arm type, e.g. t2a-standard-4, for arch arm64.")
+	f.StringVar(&cfg.ImageFamily, "image-family", "gcsfuse-bench", "Family of the VM images, named <family>-<manifest hash>.")
+	f.StringVar(&cfg.Gcloud, "gcloud", "gcloud", "Path to the gcloud binary.")
+	f.DurationVar(&cfg.Timeout, "timeout", 30*time.Minute, "Longest wait for the bootstrap script of the temporary VM.")
+	f.BoolVar(&cfg.DryRun, "dry-run", false, "Only write the build context to --dir.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newImageBuildCmd())
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/k8schaos"
+)
+
+func newK8sChaosCmd() *cobra.Command {
+	cfg := k8schaos.Config{}
+	cmd := &cobra.Command{
+		Use:   "k8s-chaos",
+		Short: "Drain nodes, kill gcsfuse sidecars, evict pods or restart the CSI driver under a running workload and score its recovery",
+		Long: `k8s-chaos disrupts the running pods of --selector, which mount buckets with
+the Cloud Storage FUSE CSI driver, with each of --faults in turn:
+
+  drain-node    drain the node of a workload pod, uncordoning it afterwards
+  kill-sidecar  terminate the gcsfuse sidecar of a workload pod (kubectl debug)
+  evict-pod     delete a workload pod for its controller to recreate
+  restart-csi   roll out a restart of the CSI driver DaemonSet
+
+After each fault it waits up to --recovery-timeout for as many pods as
+before to be Ready, counts the container restarts and the I/O errors
+(ENOTCONN, EIO, ...) the workload logged, lists --mount-path and runs
+--verify-command, e.g. a coherence check, in the workload container. A fault
+passes when the workload recovered and the checks succeeded. The scorecard
+records the CSI driver and gcsfuse sidecar versions, so runs are compared
+per release, and the command exits non-zero if any fault failed.`,
+		Example: `  gcsfuse-tools k8s-chaos --namespace=train --selector=app=trainer \
+    --faults=kill-sidecar,evict-pod,drain-node,restart-csi --mount-path=/data \
+    --verify-command='gcsfuse-tools coherence read-concurrently /data/f1 --size=64M'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			sc, err := k8schaos.Run(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(sc); err != nil {
+				return err
+			}
+			if err := registerResult(ctx, "k8s-chaos", "", nil, sc); err != nil {
+				return err
+			}
+			if n := sc.Failed(); n > 0 {
+				return fmt.Errorf("the workload did not survive %d of %d faults", n, len(sc.Outcomes))
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Selector, "selector", "", "Label selector of the workload pods, e.g. app=trainer.")
+	f.StringVar(&cfg.Container, "container", "", "Workload container for logs and checks. Defaults to the first container that is not the sidecar.")
+	f.StringSliceVar(&cfg.Faults, "faults", []string{k8schaos.FaultKillSidecar, k8schaos.FaultEvictPod}, "Faults to inject, in order: drain-node, kill-sidecar, evict-pod, restart-csi.")
+	f.IntVar(&cfg.Rounds, "rounds", 1, "Number of times to inject every fault.")
+	f.DurationVar(&cfg.RecoveryTimeout, "recovery-timeout", 10*time.Minute, "Fail a fault when the workload is not Ready again after this long.")
+	f.DurationVar(&cfg.Settle, "settle", 30*time.Second, "Pause after each fault before the next one.")
+	f.StringVar(&cfg.MountPath, "mount-path", "", "Path of the volume in the workload container, listed after each recovery to check that it is mounted.")
+	f.StringVar(&cfg.VerifyCommand, "verify-command", "", "Shell command run in the workload container after each recovery to check the data; a non-zero exit fails the fault.")
+	f.StringVar(&cfg.DebugImage, "debug-image", "busybox:1.36", "Image of the ephemeral container that kills the sidecar.")
+	f.StringVar(&cfg.CSINamespace, "csi-namespace", "kube-system", "Namespace of the CSI driver DaemonSet.")
+	f.StringVar(&cfg.CSIDaemonSet, "csi-daemonset", "gcsfusecsi-node", "Name of the CSI driver DaemonSet.")
+	f.StringVar(&cfg.Namespace, "namespace", "default", "Namespace of the workload.")
+	f.StringVar(&cfg.Kubectl, "kubectl", "kubectl", "Path to the kubectl binary.")
+	f.StringVar(&cfg.KubeContext, "context", "", "kubectl context of the cluster. Defaults to the current context.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newK8sChaosCmd())
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/bench"
+)
+
+func newLeakWatchCmd() *cobra.Command {
+	cfg := bench.LeakWatchConfig{}
+	var dataset string
+	cmd := &cobra.Command{
+		Use:   "leak-watch",
+		Short: "Run a workload on a gcsfuse mount for hours and fail if the memory of gcsfuse keeps growing",
+		Long: `leak-watch runs --jobfile in a loop on a gcsfuse mount for --duration, samples
+the RSS of the gcsfuse process every --sample-interval and fits a line through
+the samples taken after --warmup. It fails when the RSS grows faster than
+--max-slope MiB per hour, or when the process exits during the run, e.g.
+OOM-killed.
+
+With --pprof-addr, the in-use heap is read from the net/http/pprof handlers
+of gcsfuse and held to the same limit, and --profile-dir keeps a heap profile
+every --profile-interval, to diff with go tool pprof -base. Interrupting the
+run fits the samples taken so far.`,
+		Example: `  gcsfuse-tools leak-watch --jobfile=read-write-churn.fio --bucket=b --mount-point=/mnt/leak --duration=12h
+  gcsfuse-tools leak-watch --jobfile=list-stat.fio --mount-point=/mnt/gcs --duration=24h --max-slope=2 \
+      --pprof-addr=localhost:6060 --profile-dir=profiles -o json > leak.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			res, err := bench.RunLeakWatch(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			if err := registerResult(cmd.Context(), "leak-watch", dataset, res.Env, res); err != nil {
+				return err
+			}
+			if res.Leaking {
+				return errors.New("gcsfuse memory grew beyond --max-slope or the process exited")
+			}
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Base.JobFile, "jobfile", "", "fio jobfile run over and over on the mount.")
+	f.StringVar(&cfg.Base.MountPoint, "mount-point", "", "Directory the jobfile runs in. Mounted with gcsfuse when --bucket is set.")
+	f.StringVar(&cfg.Base.Bucket, "bucket", "", "Bucket mounted at --mount-point for the run. If empty, --mount-point must already be a gcsfuse mount.")
+	f.StringVar(&cfg.Base.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "Path to the gcsfuse binary.")
+	f.StringSliceVar(&cfg.Base.GcsfuseFlags, "gcsfuse-flags", nil, "Extra gcsfuse flags, e.g. --gcsfuse-flags=--implicit-dirs,--file-cache-max-size-mb=1024.")
+	f.StringVar(&cfg.Base.FioBinary, "fio-binary", "fio", "Path to the fio binary.")
+	f.IntVar(&cfg.PID, "pid", 0, "gcsfuse process to sample. Defaults to the one serving --mount-point.")
+	f.DurationVar(&cfg.Duration, "duration", 6*time.Hour, "How long to run the workload.")
+	f.DurationVar(&cfg.SampleInterval, "sample-interval", time.Minute, "Interval between memory samples.")
+	f.DurationVar(&cfg.Warmup, "warmup", 30*time.Minute, "Initial part of the run left out of the trend, while caches fill up.")
+	f.Float64Var(&cfg.MaxSlopeMiBPerHour, "max-slope", 5, "RSS and heap growth, in MiB per hour, above which the run fails.")
+	f.StringVar(&cfg.PprofAddr, "pprof-addr", "", "host:port of the /debug/pprof handlers of gcsfuse, if it serves them, to sample its heap.")
+	f.StringVar(&cfg.ProfileDir, "profile-dir", "", "Directory to save heap profiles from --pprof-addr to.")
+	f.DurationVar(&cfg.ProfileInterval, "profile-interval", time.Hour, "Interval between saved heap profiles.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the run reads, recorded with the result in --registry-bucket.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newLeakWatchCmd())
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/mountwrap"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func newMountWrapCmd() *cobra.Command {
+	cfg := mountwrap.Config{}
+	cmd := &cobra.Command{
+		Use:   "mount-wrap [flags] -- [gcsfuse flags] BUCKET MOUNT_POINT",
+		Short: "Run gcsfuse and record exactly what was mounted for a run",
+		Long: `mount-wrap runs gcsfuse in the foreground with the arguments after --, waits
+for the mount to appear and records its provenance: the full command line,
+the path and sha256 of the binary, the gcsfuse version, the path, sha256 and
+content of --config-file, the environment variables that affect gcsfuse
+(secrets hashed) and the start, mount and stop times and exit status.
+
+The provenance is stored in --registry-bucket as a mount-wrap result under
+--run-id when the mount is up, and again when gcsfuse exits after the mount
+point is unmounted, so "what exactly was mounted for run X?" is answered by
+registry describe X. It is also printed on exit. Harnesses run mount-wrap in
+the background instead of gcsfuse and unmount as usual; SIGINT and SIGTERM
+are passed on to gcsfuse.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry mount-wrap --run-id=nightly-20261017 -- \
+    --implicit-dirs --config-file=/etc/gcsfuse.yaml my-bench-bucket /mnt/bench &
+  ...
+  fusermount -u /mnt/bench; wait`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Args = args
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if cfg.RunID == "" {
+				cfg.RunID = registry.NewRunID()
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			cfg.OnMounted = func(p *mountwrap.Provenance) error { return registerProvenance(ctx, p) }
+			p, err := mountwrap.Run(ctx, cfg)
+			if p == nil {
+				return err
+			}
+			if rerr := registerProvenance(context.WithoutCancel(ctx), p); rerr != nil && err == nil {
+				err = rerr
+			}
+			if werr := writeResult(p); werr != nil && err == nil {
+				err = werr
+			}
+			return err
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.GcsfuseBinary, "gcsfuse-binary", "gcsfuse", "Path to the gcsfuse binary.")
+	f.StringVar(&cfg.RunID, "run-id", "", "Run the mount belongs to, the ID its provenance is registered under. Generated if empty.")
+	f.DurationVar(&cfg.MountTimeout, "mount-timeout", 2*time.Minute, "Stop gcsfuse if the mount point is not mounted within this time.")
+	return cmd
+}
+
+// registerProvenance stores p as the mount-wrap result of its run when
+// --registry-bucket is set, replacing an earlier record of the run.
+func registerProvenance(ctx context.Context, p *mountwrap.Provenance) error {
+	if globals.registryBucket == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return withRegistry(ctx, func(reg *registry.Registry) error {
+		return reg.PutResult(ctx, &registry.ResultEntry{RunID: p.RunID, Tool: "mount-wrap", Env: p.Env}, b)
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(newMountWrapCmd())
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/netaccount"
+)
+
+func newNetAccountCmd() *cobra.Command {
+	cfg := netaccount.Config{}
+	var resultFile string
+	cmd := &cobra.Command{
+		Use:   "net-account [-- COMMAND [ARG...]]",
+		Short: "Measure gcsfuse's wire bytes during a benchmark and compare them with the goodput",
+		Long: `net-account samples the TCP counters (ss -tinp) of the gcsfuse process serving
+--mount-point every --interval and reports the bytes it received, sent and
+retransmitted while the run lasted. Connections open before the run only count
+what they move during it.
+
+With a COMMAND after --, e.g. gcsfuse-tools bench fio, sampling stops when it
+exits; the gcsfuse process is looked up again at every sample, so mounts the
+command makes itself are followed. Otherwise it samples until interrupted or
+--duration elapses.
+
+With --result, the goodput is the bytes the jobs of that bench fio -o json
+record read and wrote, the overhead ratio (wire bytes per goodput byte) shows
+retries and protocol cost, and the accounting is appended to the record as
+"net".`,
+		Example: `  gcsfuse-tools net-account --mount-point=/mnt/gcs --result=run.json -- \
+    sh -c 'gcsfuse-tools bench fio --jobfile=read.fio --mount-point=/mnt/gcs --bucket=my-bucket -o json > run.json'
+  gcsfuse-tools net-account --pid=4242 --duration=5m`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Command = args
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			r, runErr := netaccount.Run(ctx, cfg)
+			if r == nil {
+				return runErr
+			}
+			if resultFile != "" {
+				if err := appendNetAccount(resultFile, r); err != nil {
+					return err
+				}
+			}
+			if err := writeResult(r); err != nil {
+				return err
+			}
+			return runErr
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.MountPoint, "mount-point", "", "Mount point of the gcsfuse process to account.")
+	f.IntVar(&cfg.PID, "pid", 0, "PID of the gcsfuse process, instead of --mount-point.")
+	f.DurationVar(&cfg.Interval, "interval", time.Second, "Time between two samples. Connections closed between samples lose the bytes since the last one.")
+	f.DurationVar(&cfg.Duration, "duration", 0, "Stop after this long when no COMMAND is given. 0 samples until interrupted.")
+	f.StringVar(&cfg.SS, "ss", "ss", "Path of the iproute2 ss binary.")
+	f.StringVar(&resultFile, "result", "", "bench fio -o json record to take the goodput from and append the accounting to.")
+	return cmd
+}
+
+// appendNetAccount sets r's goodput from the bench record at path and writes
+// the record back with r as its net accounting.
+func appendNetAccount(path string, r *netaccount.Report) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var res bench.Result
+	if err := json.Unmarshal(b, &res); err != nil {
+		return fmt.Errorf("decoding bench fio result: %w", err)
+	}
+	var goodput int64
+	for _, j := range res.Jobs {
+		if j.Read != nil {
+			goodput += j.Read.Bytes
+		}
+		if j.Write != nil {
+			goodput += j.Write.Bytes
+		}
+	}
+	r.SetGoodput(goodput)
+	res.Net = r
+	if b, err = json.MarshalIndent(&res, "", "  "); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func init() {
+	rootCmd.AddCommand(newNetAccountCmd())
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/opcount"
+)
+
+func newOpcountCmd() *cobra.Command {
+	cfg := opcount.Config{}
+	var budgetFile, record string
+	var tolerance float64
+	cmd := &cobra.Command{
+		Use:   "opcount [flags] -- <workload command>",
+		Short: "Count the GCS API calls gcsfuse issues for a workload and check them against a budget",
+		Long: `opcount runs the workload command on a gcsfuse mount and counts the Cloud
+Storage calls gcsfuse issued meanwhile, per method and per billing class.
+With --source=log (default) the calls are read from the gcsfuse --log-file,
+which needs --log-severity=trace. With --source=audit they are read from the
+bucket's Data Access audit logs in --project.
+
+--budget compares the counts with a YAML file of class_a, class_b and
+per-method limits, and fails when a count exceeds its limit. --record writes
+the observed counts as such a file, to be used as the baseline of later runs.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Workload = args
+			switch cfg.Source {
+			case opcount.SourceLog:
+				if cfg.LogFile == "" {
+					return errors.New("--log-file is required with --source=log")
+				}
+			case opcount.SourceAudit:
+				if cfg.Bucket == "" {
+					return errors.New("--bucket is required with --source=audit")
+				}
+				project, err := globals.requireProject()
+				if err != nil {
+					return err
+				}
+				cfg.Project = project
+			default:
+				return fmt.Errorf("invalid --source %q (want %s or %s)", cfg.Source, opcount.SourceLog, opcount.SourceAudit)
+			}
+			if budgetFile != "" {
+				b, err := opcount.LoadBudget(budgetFile)
+				if err != nil {
+					return err
+				}
+				if cmd.Flags().Changed("tolerance") {
+					b.Tolerance = tolerance
+				}
+				cfg.Budget = b
+			}
+
+			r, err := opcount.Run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if record != "" {
+				if err := opcount.BudgetFrom(r, tolerance).Write(record); err != nil {
+					return fmt.Errorf("recording budget: %w", err)
+				}
+				slog.Info("Recorded budget", "file", record)
+			}
+			if err := writeResult(r); err != nil {
+				return err
+			}
+			if !r.Passed {
+				return errors.New("opcount: workload failed or exceeded its budget")
+			}
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.Source, "source", opcount.SourceLog, "Where calls are counted: log (gcsfuse trace log) or audit (Cloud Audit Logs).")
+	f.StringVar(&cfg.LogFile, "log-file", "", "gcsfuse --log-file of the mount, written with --log-severity=trace.")
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket whose audit logs are read with --source=audit.")
+	f.StringVar(&cfg.Principal, "principal", "", "Only count audit entries of this identity, e.g. the service account gcsfuse runs as.")
+	f.DurationVar(&cfg.AuditDelay, "audit-delay", 2*time.Minute, "Time to wait after the workload for audit log entries to be ingested.")
+	f.StringVar(&budgetFile, "budget", "", "YAML budget with class_a, class_b, methods and tolerance.")
+	f.Float64Var(&tolerance, "tolerance", 0.1, "Fraction a count may exceed its budget by. Overrides the budget file when set; also written by --record.")
+	f.StringVar(&record, "record", "", "Write the observed counts as a budget file.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newOpcountCmd())
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/outliers"
+)
+
+func newOutliersCmd() *cobra.Command {
+	cfg := outliers.Config{}
+	var fioStart string
+	cmd := &cobra.Command{
+		Use:   "outliers [NAME=]RUN_DIR...",
+		Short: "Attribute the slowest fio operations of runs to GCS retries, throttling, connection setup or cache misses",
+		Long: `outliers reads the fio latency logs (write_lat_log, *_lat.N.log or
+*_clat.N.log) and the gcsfuse logs (other *.log and *.json files, written with
+--log-severity=trace) in every run directory, takes the ops slower than
+--percentile and attributes each to the highest-ranked cause among the
+gcsfuse records logged during it:
+
+  gcs-5xx-retry     a 5xx response or retry of a GCS request
+  throttling        a 429 or rate limit response
+  connection-setup  a new connection, TLS handshake or reset connection
+  cache-miss        a file cache read with hit: false
+  slow-gcs-request  a GCS request at least half as long as the op
+
+The rest are unattributed. Each run gets a table of causes ranked by their
+number of outliers, and its slowest outliers with the deciding log message.
+
+fio's log timestamps are relative to the job start unless the jobfile sets
+log_unix_epoch=1. Relative logs are aligned with --fio-start, or with the
+first gcsfuse log record, which is only right when gcsfuse was mounted just
+before fio started.`,
+		Example: `  gcsfuse-tools outliers --percentile=99.9 baseline=runs/1 tuned=runs/2`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, a := range args {
+				name, dir, ok := strings.Cut(a, "=")
+				if !ok {
+					name, dir = filepath.Base(filepath.Clean(a)), a
+				}
+				cfg.Runs = append(cfg.Runs, outliers.Run{Name: name, Dir: dir})
+			}
+			if fioStart != "" {
+				t, err := time.Parse(time.RFC3339Nano, fioStart)
+				if err != nil {
+					return fmt.Errorf("parsing --fio-start: %w", err)
+				}
+				cfg.FioStart = t
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			r, err := outliers.Analyze(cfg)
+			if err != nil {
+				return err
+			}
+			return writeResult(r)
+		},
+	}
+	f := cmd.Flags()
+	f.Float64Var(&cfg.Percentile, "percentile", 99.9, "Latency percentile above which an op is an outlier.")
+	f.StringVar(&fioStart, "fio-start", "", "RFC 3339 time fio started, for latency logs written without log_unix_epoch=1.")
+	f.DurationVar(&cfg.Slack, "slack", 10*time.Millisecond, "Widen every op's time window by this much to absorb timestamp skew.")
+	f.IntVar(&cfg.Examples, "examples", 10, "Number of slowest outliers listed per run.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newOutliersCmd())
+}
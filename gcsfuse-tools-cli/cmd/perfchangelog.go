@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/changelog"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func newPerfChangelogCmd() *cobra.Command {
+	var base, head string
+	var tools []string
+	var threshold float64
+	cmd := &cobra.Command{
+		Use:   "perf-changelog",
+		Short: "Summarize the performance changes between two gcsfuse releases as Markdown",
+		Long: `perf-changelog selects the results in --registry-bucket measured with each of
+two gcsfuse releases (by the gcsfuse version in their environment
+fingerprint), takes the median of every workload metric per release and
+prints improved, regressed and neutral workloads with percentages as
+Markdown, ready to paste into release notes.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry perf-changelog --base=v3.3.0 --head=v3.4.0 > perf.md`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if base == "" || head == "" {
+				return errors.New("--base and --head are required")
+			}
+			if threshold < 0 {
+				return errors.New("--threshold must not be negative")
+			}
+			for _, t := range tools {
+				if !isChangelogTool(t) {
+					return fmt.Errorf("unsupported --tools value %q (want %s)", t, strings.Join(changelog.Tools, ", "))
+				}
+			}
+			ctx := cmd.Context()
+			var baseRuns, headRuns []changelog.Run
+			err := withRegistry(ctx, func(reg *registry.Registry) (err error) {
+				if baseRuns, err = releaseRuns(ctx, reg, tools, base); err != nil {
+					return err
+				}
+				headRuns, err = releaseRuns(ctx, reg, tools, head)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if len(baseRuns) == 0 || len(headRuns) == 0 {
+				return fmt.Errorf("found %d result(s) of %s and %d of %s; both releases need results", len(baseRuns), base, len(headRuns), head)
+			}
+			cl, err := changelog.Build(base, head, baseRuns, headRuns, threshold)
+			if err != nil {
+				return err
+			}
+			return writeResult(cl)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&base, "base", "", "Previous gcsfuse release, e.g. v3.3.0.")
+	f.StringVar(&head, "head", "", "New gcsfuse release, e.g. v3.4.0.")
+	f.StringSliceVar(&tools, "tools", changelog.Tools, "Result tools to compare.")
+	f.Float64Var(&threshold, "threshold", 0.05, "Relative change below which a workload is reported as neutral.")
+	return cmd
+}
+
+func isChangelogTool(t string) bool {
+	for _, s := range changelog.Tools {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseRuns loads the registered results of tools measured with release.
+func releaseRuns(ctx context.Context, reg *registry.Registry, tools []string, release string) ([]changelog.Run, error) {
+	var runs []changelog.Run
+	for _, tool := range tools {
+		entries, err := reg.ListResults(ctx, tool)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !changelog.MatchesRelease(e, release) {
+				continue
+			}
+			b, err := reg.GetResultBlob(ctx, e.RunID)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, changelog.Run{Entry: e, Blob: b})
+		}
+	}
+	slog.Info("Selected results", "release", release, "runs", len(runs))
+	return runs, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newPerfChangelogCmd())
+}
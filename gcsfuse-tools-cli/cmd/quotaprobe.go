@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/quotaprobe"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+func newQuotaProbeCmd() *cobra.Command {
+	cfg := quotaprobe.Config{}
+	var objectSize string
+	cmd := &cobra.Command{
+		Use:   "quota-probe",
+		Short: "Find the request rate a bucket sustains per operation before GCS throttles it",
+		Long: `quota-probe sends one kind of request at a time to --bucket (--ops: write,
+read, stat, list) at a rate starting at --start-qps and multiplied by
+--growth after every clean --step-duration, up to --max-qps. A step is clean
+when at most --throttle-threshold of its requests got a 429 or 503 response
+or failed otherwise. After the first throttled step the probe backs off to
+the last clean rate, and lower, until a step runs clean again; that rate is
+the sustainable QPS of the operation.
+
+Requests are sent on schedule without the client library's retries, up to
+--workers in flight. A ramp that cannot reach its target rate stops as
+client-bound: the machine, not the bucket, is the limit, and more workers or
+clients are needed to find the bucket's. Reads, stats and lists go to
+--seed-objects objects written under --prefix first; writes create new
+objects. All of them are deleted at the end unless --keep is set.
+
+GCS scales the request rate of a bucket up gradually, so the result
+describes the bucket now; rerun the probe after sustained load.`,
+		Example: `  gcsfuse-tools quota-probe --bucket=my-bench-bucket --ops=write,read --max-qps=10000 --workers=1024`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := units.ParseSize(objectSize)
+			if err != nil {
+				return fmt.Errorf("parsing --object-size %q: %v", objectSize, err)
+			}
+			cfg.ObjectSize = n
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			res, err := quotaprobe.Run(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			res.Env = envinfo.Capture(ctx, envinfo.Options{})
+			return writeResult(res)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", "", "Bucket to probe.")
+	f.StringVar(&cfg.Prefix, "prefix", "quota-probe/", "Prefix of the objects the probe writes.")
+	f.StringSliceVar(&cfg.Ops, "ops", quotaprobe.Ops, "Operations to probe, in order: write, read, stat and/or list.")
+	f.Float64Var(&cfg.StartQPS, "start-qps", 50, "Request rate of the first step of every operation.")
+	f.Float64Var(&cfg.MaxQPS, "max-qps", 5000, "Highest request rate tried.")
+	f.Float64Var(&cfg.Growth, "growth", 1.5, "Factor the rate is multiplied by after every clean step.")
+	f.DurationVar(&cfg.StepDuration, "step-duration", 30*time.Second, "Duration of every step.")
+	f.Float64Var(&cfg.ThrottleThreshold, "throttle-threshold", 0.01, "Fraction of the requests of a step that may be throttled, or fail, for the step to count as clean.")
+	f.DurationVar(&cfg.Cooldown, "cooldown", 30*time.Second, "Pause between two operations.")
+	f.IntVar(&cfg.Workers, "workers", 512, "Most requests in flight.")
+	f.StringVar(&objectSize, "object-size", "4K", "Size of the objects written and read.")
+	f.IntVar(&cfg.SeedObjects, "seed-objects", 100, "Number of objects read, stat'ed and listed.")
+	f.BoolVar(&cfg.Keep, "keep", false, "Keep the objects the probe wrote.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newQuotaProbeCmd())
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+func newRegistryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "registry",
+		Short: "List and inspect the datasets and results catalogued in --registry-bucket",
+	}
+	cmd.AddCommand(newRegistryListCmd(), newRegistryDescribeCmd(), newRegistryGetCmd(), newRegistryPutResultCmd())
+	return cmd
+}
+
+func newRegistryListCmd() *cobra.Command {
+	var tool string
+	cmd := &cobra.Command{
+		Use:       "list {datasets|results}",
+		Short:     "List registered datasets or results",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"datasets", "results"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRegistry(cmd.Context(), func(reg *registry.Registry) error {
+				if args[0] == "datasets" {
+					ds, err := reg.ListDatasets(cmd.Context())
+					if err != nil {
+						return err
+					}
+					return writeResult(registry.Datasets(ds))
+				}
+				rs, err := reg.ListResults(cmd.Context(), tool)
+				if err != nil {
+					return err
+				}
+				return writeResult(registry.Results(rs))
+			})
+		},
+	}
+	cmd.Flags().StringVar(&tool, "tool", "", "Only list results produced by this tool, e.g. bench-fio.")
+	return cmd
+}
+
+func newRegistryDescribeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe {dataset NAME|result RUN-ID}",
+		Short: "Show a registered dataset or result",
+		Args:  registryKindArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRegistry(cmd.Context(), func(reg *registry.Registry) error {
+				if args[0] == "dataset" {
+					e, err := reg.GetDataset(cmd.Context(), args[1])
+					if err != nil {
+						return err
+					}
+					return writeResult(e)
+				}
+				e, err := reg.GetResult(cmd.Context(), args[1])
+				if err != nil {
+					return err
+				}
+				return writeResult(e)
+			})
+		},
+	}
+}
+
+func newRegistryGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get {dataset NAME|result RUN-ID}",
+		Short: "Print a dataset's bucket or a result's stored blob",
+		Long: `get prints what scripts need from a registry entry: the bucket of a
+dataset, e.g. --bucket=$(gcsfuse-tools registry get dataset rand-read-1g), or the
+result blob exactly as the producing tool wrote it.`,
+		Args: registryKindArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRegistry(cmd.Context(), func(reg *registry.Registry) error {
+				if args[0] == "dataset" {
+					e, err := reg.GetDataset(cmd.Context(), args[1])
+					if err != nil {
+						return err
+					}
+					_, err = fmt.Println(e.Bucket)
+					return err
+				}
+				b, err := reg.GetResultBlob(cmd.Context(), args[1])
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(b)
+				return err
+			})
+		},
+	}
+}
+
+func newRegistryPutResultCmd() *cobra.Command {
+	var file, tool, dataset string
+	cmd := &cobra.Command{
+		Use:   "put-result",
+		Short: "Register a result file produced outside this CLI",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" || tool == "" {
+				return errors.New("--file and --tool are required")
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			return withRegistry(cmd.Context(), func(reg *registry.Registry) error {
+				e := &registry.ResultEntry{
+					Tool:    tool,
+					Dataset: dataset,
+					Env:     envinfo.Capture(cmd.Context(), envinfo.Options{}),
+				}
+				if err := reg.PutResult(cmd.Context(), e, b); err != nil {
+					return err
+				}
+				return writeResult(e)
+			})
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&file, "file", "", "Result file to upload.")
+	f.StringVar(&tool, "tool", "", "Tool that produced the result, e.g. fio.")
+	f.StringVar(&dataset, "dataset", "", "Registered dataset the result was measured on.")
+	return cmd
+}
+
+// registryKindArgs accepts "dataset NAME" or "result RUN-ID".
+func registryKindArgs(cmd *cobra.Command, args []string) error {
+	if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+		return err
+	}
+	if args[0] != "dataset" && args[0] != "result" {
+		return fmt.Errorf("unknown kind %q, want dataset or result", args[0])
+	}
+	return nil
+}
+
+// withRegistry opens the registry in --registry-bucket and passes it to fn.
+func withRegistry(ctx context.Context, fn func(*registry.Registry) error) error {
+	if globals.registryBucket == "" {
+		return errors.New("--registry-bucket is required")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("creating storage client: %w", err)
+	}
+	defer client.Close()
+	return fn(registry.New(client, globals.registryBucket))
+}
+
+// registerResult stores v as a result of tool when --registry-bucket is set.
+// Callers write the result first, so a registry failure does not lose it.
+func registerResult(ctx context.Context, tool, dataset string, env *envinfo.Fingerprint, v any) error {
+	return registerWorkloadResult(ctx, tool, dataset, "", env, v)
+}
+
+// registerWorkloadResult is registerResult for a run of the workload spec
+// with hash specHash, which keys the entry.
+func registerWorkloadResult(ctx context.Context, tool, dataset, specHash string, env *envinfo.Fingerprint, v any) error {
+	if globals.registryBucket == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = withRegistry(ctx, func(reg *registry.Registry) error {
+		e := &registry.ResultEntry{Tool: tool, Dataset: dataset, SpecHash: specHash, Env: env}
+		if err := reg.PutResult(ctx, e, b); err != nil {
+			return err
+		}
+		slog.Info("Registered result", "run_id", e.RunID, "object", e.ResultObject)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("the result was written but not registered in --registry-bucket: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newRegistryCmd())
+}
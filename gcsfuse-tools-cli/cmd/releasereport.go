@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/changelog"
+	"gcsfuse-tools-cli/internal/registry"
+	"gcsfuse-tools-cli/internal/releasereport"
+)
+
+func newReleaseReportCmd() *cobra.Command {
+	in := releasereport.Inputs{}
+	var base, changelogFile, soakURI, knownIssues, out, pdf, converter string
+	var coherenceFiles, signoffs, tools []string
+	var threshold float64
+	cmd := &cobra.Command{
+		Use:   "release-report",
+		Short: "Assemble the qualification report of a gcsfuse release candidate as one HTML or PDF document",
+		Long: `release-report pulls the evidence for qualifying a gcsfuse release candidate
+into one document:
+
+  benchmarks    the perf-changelog comparison of --base and --release, built
+                from --registry-bucket, or read from a --changelog file
+  coherence     coherence results written with -o json (--coherence, repeatable)
+  soak          the history.json of a soak report (--soak, gs:// or a file)
+  known issues  a YAML list of {id, title, severity, status, workaround, link}
+                (--known-issues); severity is blocker, major or minor and
+                status open, mitigated or fixed
+
+The release is qualified when no benchmark regressed, every coherence result
+and soak round passed and no blocker issue is open; otherwise the blockers are
+listed. The HTML document (--out) ends with the --signed-off-by approvers and
+the SHA-256 digest of the evidence they signed off, and is printed to --pdf
+with --pdf-converter if set.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry release-report --release=v3.5.0 --base=v3.4.0 \
+      --coherence=fuzz.json --coherence=kill-remount.json --soak=gs://my-soak/report/history.json \
+      --known-issues=known-issues.yaml --signed-off-by="Alex Doe:release owner" --out=v3.5.0.html --pdf=v3.5.0.pdf`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if base != "" && changelogFile != "" {
+				return errors.New("--base and --changelog are mutually exclusive")
+			}
+			if out == "" {
+				return errors.New("--out is required")
+			}
+			ctx := cmd.Context()
+			var err error
+			switch {
+			case changelogFile != "":
+				if in.Changelog, err = releasereport.LoadChangelog(changelogFile); err != nil {
+					return err
+				}
+			case base != "":
+				for _, t := range tools {
+					if !isChangelogTool(t) {
+						return fmt.Errorf("unsupported --tools value %q (want %s)", t, strings.Join(changelog.Tools, ", "))
+					}
+				}
+				var baseRuns, headRuns []changelog.Run
+				err = withRegistry(ctx, func(reg *registry.Registry) (err error) {
+					if baseRuns, err = releaseRuns(ctx, reg, tools, base); err != nil {
+						return err
+					}
+					headRuns, err = releaseRuns(ctx, reg, tools, in.Release)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				if in.Changelog, err = changelog.Build(base, in.Release, baseRuns, headRuns, threshold); err != nil {
+					return err
+				}
+			}
+			if in.Coherence, err = releasereport.LoadCoherence(coherenceFiles); err != nil {
+				return err
+			}
+			if soakURI != "" {
+				var client *storage.Client
+				if strings.HasPrefix(soakURI, "gs://") {
+					if client, err = storage.NewClient(ctx); err != nil {
+						return fmt.Errorf("creating storage client: %w", err)
+					}
+					defer client.Close()
+				}
+				if in.Soak, err = releasereport.LoadSoak(ctx, client, soakURI); err != nil {
+					return err
+				}
+			}
+			if knownIssues != "" {
+				if in.KnownIssues, err = releasereport.LoadKnownIssues(knownIssues); err != nil {
+					return err
+				}
+			}
+			for _, s := range signoffs {
+				so, err := releasereport.ParseSignoff(s)
+				if err != nil {
+					return err
+				}
+				in.Signoffs = append(in.Signoffs, so)
+			}
+			if err := in.Validate(); err != nil {
+				return err
+			}
+
+			r, err := releasereport.Build(in)
+			if err != nil {
+				return err
+			}
+			if err := releasereport.WriteHTML(r, out); err != nil {
+				return err
+			}
+			if pdf != "" {
+				if err := releasereport.WritePDF(ctx, r, converter, pdf); err != nil {
+					return err
+				}
+			}
+			return writeResult(r)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&in.Release, "release", "", "Release candidate, e.g. v3.5.0.")
+	f.StringVar(&base, "base", "", "Previous release whose registered benchmark results the candidate's are compared with.")
+	f.StringSliceVar(&tools, "tools", changelog.Tools, "Result tools compared with --base.")
+	f.Float64Var(&threshold, "threshold", 0.05, "Relative change below which a benchmark is neutral.")
+	f.StringVar(&changelogFile, "changelog", "", "perf-changelog result written with -o json, instead of --base.")
+	f.StringArrayVar(&coherenceFiles, "coherence", nil, "Coherence result written with -o json. Repeat for every result.")
+	f.StringVar(&soakURI, "soak", "", "history.json of the soak report, as gs://BUCKET/OBJECT or a local file.")
+	f.StringVar(&knownIssues, "known-issues", "", "YAML list of the known issues of the release.")
+	f.StringArrayVar(&signoffs, "signed-off-by", nil, "Approver as NAME or NAME:ROLE. Repeat for every approver.")
+	f.StringVar(&out, "out", "", "HTML document to write.")
+	f.StringVar(&pdf, "pdf", "", "PDF document to print the HTML document to.")
+	f.StringVar(&converter, "pdf-converter", "wkhtmltopdf", "Command printing HTML to PDF, called as CONVERTER HTML PDF.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newReleaseReportCmd())
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/registry"
+	"gcsfuse-tools-cli/internal/repro"
+)
+
+func newReproCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repro",
+		Short: "Replay benchmark runs captured with --repro-bundle",
+	}
+	cmd.AddCommand(newReproRunCmd())
+	return cmd
+}
+
+func newReproRunCmd() *cobra.Command {
+	var dryRun, strict bool
+	cmd := &cobra.Command{
+		Use:   "run BUNDLE",
+		Short: "Rerun the benchmark captured in a reproducibility bundle",
+		Long: `run unpacks a bundle written with --repro-bundle, verifies its input files,
+compares the current host with the bundled environment fingerprint and the
+registered dataset with the bundled one, and reruns the captured command
+with the same flags, seeds and input files. Differences are logged as
+warnings, or fail the replay with --strict. The global --output, --log-level
+and --registry-bucket of run apply to the replay.`,
+		Example: `  gcsfuse-tools bench fio --jobfile=rand-read.fio --mount-point=/mnt/b --bucket=b --repro-bundle=run.tar.gz
+  gcsfuse-tools repro run run.tar.gz --strict`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			dir, err := os.MkdirTemp("", "repro-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			m, err := repro.Extract(args[0], dir)
+			if err != nil {
+				return err
+			}
+
+			opts := envinfo.Options{}
+			if m.Env != nil && m.Env.GcsfuseVersion != "" {
+				opts.GcsfuseBinary = "gcsfuse"
+				for _, a := range m.Args {
+					if v, ok := strings.CutPrefix(a, "--gcsfuse-binary="); ok {
+						opts.GcsfuseBinary = v
+					}
+				}
+			}
+			diffs := m.Differences(envinfo.Capture(ctx, opts))
+			if m.Dataset != nil && m.Dataset.SpecHash != "" && globals.registryBucket != "" {
+				d, err := reproDataset(ctx, m.Dataset.Name)
+				if err != nil {
+					return err
+				}
+				if d.SpecHash != m.Dataset.SpecHash || d.ManifestHash != m.Dataset.ManifestHash {
+					diffs = append(diffs, fmt.Sprintf("dataset %s was re-created since the bundled run", d.Name))
+				}
+			}
+			for _, d := range diffs {
+				slog.Warn("Replay differs from the bundled run", "difference", d)
+			}
+			if strict && len(diffs) > 0 {
+				return fmt.Errorf("%d difference(s) from the bundled run", len(diffs))
+			}
+
+			replay := append(m.ReplayArgs(dir), "--output="+globals.outputFormat, "--log-level="+globals.logLevel)
+			if globals.registryBucket != "" {
+				replay = append(replay, "--registry-bucket="+globals.registryBucket)
+			}
+			if dryRun {
+				_, err := fmt.Println("gcsfuse-tools " + strings.Join(replay, " "))
+				return err
+			}
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			slog.Info("Replaying", "command", m.Command, "bundled_at", m.CreatedAt)
+			c := exec.CommandContext(ctx, exe, replay...)
+			c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+			return c.Run()
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the replay command instead of running it. Input files point into a directory removed on exit.")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of warning when the host, tools or dataset differ from the bundled run.")
+	return cmd
+}
+
+// reproInputs are what a bundle records besides the command's flags.
+type reproInputs struct {
+	// files are the flags naming input files to store in the bundle.
+	files   []string
+	seeds   map[string]uint64
+	dataset string
+	env     *envinfo.Fingerprint
+	// tools are versions of the benchmarked tools, e.g. fio.
+	tools  map[string]string
+	result any
+}
+
+// reproSkipFlags are not recorded in bundles: they select the output or
+// credentials of the run rather than the workload.
+var reproSkipFlags = map[string]bool{
+	"repro-bundle": true, "registry-bucket": true, "output": true,
+	"log-level": true, "credentials-file": true, "help": true,
+}
+
+// writeReproBundle writes a bundle of cmd's run to dst when dst is set. All
+// flags are recorded, defaults included, so a replay is unaffected by later
+// changes of the defaults.
+func writeReproBundle(cmd *cobra.Command, dst string, in reproInputs) error {
+	if dst == "" {
+		return nil
+	}
+	var args []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if reproSkipFlags[f.Name] {
+			return
+		}
+		v := f.Value.String()
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			if len(sv.GetSlice()) == 0 {
+				return
+			}
+			v = strings.Join(sv.GetSlice(), ",")
+		}
+		args = append(args, "--"+f.Name+"="+v)
+	})
+	m := repro.NewManifest(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "), args)
+	for _, name := range in.files {
+		if f := cmd.Flags().Lookup(name); f != nil && f.Value.String() != "" {
+			m.AddFile(name, f.Value.String())
+		}
+	}
+	m.Seeds, m.Env = in.seeds, in.env
+	for k, v := range in.tools {
+		if v != "" {
+			m.Tools[k] = v
+		}
+	}
+	if in.env != nil && in.env.GcsfuseVersion != "" {
+		m.Tools["gcsfuse"] = in.env.GcsfuseVersion
+	}
+	if in.dataset != "" {
+		m.Dataset = &repro.Dataset{Name: in.dataset}
+		if globals.registryBucket != "" {
+			d, err := reproDataset(cmd.Context(), in.dataset)
+			if err != nil {
+				return err
+			}
+			m.Dataset = d
+		}
+	}
+	b, err := json.Marshal(in.result)
+	if err != nil {
+		return err
+	}
+	m.Result = b
+	if err := repro.Write(dst, m); err != nil {
+		return fmt.Errorf("writing reproducibility bundle: %w", err)
+	}
+	slog.Info("Wrote reproducibility bundle", "path", dst)
+	return nil
+}
+
+// reproDataset looks up the identity of a registered dataset.
+func reproDataset(ctx context.Context, name string) (*repro.Dataset, error) {
+	var d *repro.Dataset
+	err := withRegistry(ctx, func(reg *registry.Registry) error {
+		e, err := reg.GetDataset(ctx, name)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(e.Manifest)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		d = &repro.Dataset{Name: name, SpecHash: e.SpecHash, ManifestHash: hex.EncodeToString(sum[:])}
+		return nil
+	})
+	if errors.Is(err, registry.ErrNotFound) {
+		return &repro.Dataset{Name: name}, nil
+	}
+	return d, err
+}
+
+func init() {
+	rootCmd.AddCommand(newReproCmd())
+}
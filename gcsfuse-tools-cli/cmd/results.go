@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/pkg/results"
+)
+
+func newResultsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "results",
+		Short: "Export the results of --registry-bucket to dashboards and notebooks",
+		Long: `The results of --registry-bucket are also available to Go programs through the
+gcsfuse-tools-cli/pkg/results package, which loads them into typed values and
+filters and aggregates them.`,
+	}
+	cmd.AddCommand(newResultsServeCmd())
+	return cmd
+}
+
+func newResultsServeCmd() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the registered results as JSON over HTTP",
+		Long: `serve exports the results of --registry-bucket as JSON:
+
+  GET /runs?tool=&dataset=&spec_hash=&gcsfuse_version=&since=&until=&limit=&data=true
+  GET /aggregate?metric=PATH&group_by=tool|dataset|spec_hash|gcsfuse_version|machine_type|day
+  GET /series?metric=PATH
+
+PATH is a dot-separated path into the result, where * sums over all keys or
+indexes, e.g. jobs.*.read.bw_kibps for the read bandwidth of a bench fio run.
+Every endpoint takes the filters of /runs.`,
+		Example: `  gcsfuse-tools --registry-bucket=my-registry results serve --addr=:8080
+  curl 'localhost:8080/aggregate?tool=bench-fio&metric=jobs.*.read.bw_kibps&group_by=gcsfuse_version'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if globals.registryBucket == "" {
+				return errors.New("--registry-bucket is required")
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+
+			srv := &http.Server{Addr: addr, Handler: results.Handler(results.Open(client, globals.registryBucket))}
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				srv.Shutdown(shutdownCtx)
+			}()
+			slog.Info("Serving results", "addr", addr, "registry", globals.registryBucket)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "localhost:8080", "Address to listen on.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newResultsCmd())
+}
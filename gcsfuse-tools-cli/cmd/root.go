@@ -0,0 +1,104 @@
+// Package cmd wires the gcsfuse-tools subcommands into a single cobra CLI.
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/output"
+)
+
+// globalOptions holds the flags shared by every subcommand.
+type globalOptions struct {
+	project         string
+	credentialsFile string
+	logLevel        string
+	outputFormat    string
+	registryBucket  string
+
+	format output.Format
+}
+
+var globals globalOptions
+
+// version is the release of the binary, set with -ldflags by make release.
+var version = "dev"
+
+// rootCmd is built during package variable initialization so that the global
+// flags exist before the subcommand init functions run.
+var rootCmd = newRootCmd()
+
+func newRootCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "gcsfuse-tools",
+		Short:         "Data preparation, benchmarking, coherence and analysis tools for gcsfuse",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return globals.apply()
+		},
+	}
+
+	pf := cmd.PersistentFlags()
+	pf.StringVar(&globals.project, "project", "", "GCP project ID used by subcommands that talk to Google Cloud APIs.")
+	pf.StringVar(&globals.credentialsFile, "credentials-file", "", "Service account key file. Defaults to Application Default Credentials.")
+	pf.StringVar(&globals.logLevel, "log-level", "info", "Log level: debug, info, warn or error.")
+	pf.StringVarP(&globals.outputFormat, "output", "o", string(output.Text), "Result format: text or json.")
+	pf.StringVar(&globals.registryBucket, "registry-bucket", "", "Bucket of the dataset and result registry. When set, dataprep and bench register what they produce.")
+	return cmd
+}
+
+// apply validates the global flags and configures logging and auth for the
+// selected subcommand.
+func (g *globalOptions) apply() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(g.logLevel))); err != nil {
+		return fmt.Errorf("invalid --log-level %q: %w", g.logLevel, err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	format, err := output.ParseFormat(g.outputFormat)
+	if err != nil {
+		return err
+	}
+	g.format = format
+
+	// The wrapped tools build their clients from Application Default
+	// Credentials, so pointing ADC at the key file covers all of them.
+	if g.credentialsFile != "" {
+		if _, err := os.Stat(g.credentialsFile); err != nil {
+			return fmt.Errorf("invalid --credentials-file: %w", err)
+		}
+		if err := os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", g.credentialsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireProject returns the --project value or an error when it is unset.
+func (g *globalOptions) requireProject() (string, error) {
+	if g.project == "" {
+		return "", fmt.Errorf("--project is required")
+	}
+	return g.project, nil
+}
+
+// writeResult renders v to stdout in the format selected by --output.
+func writeResult(v any) error {
+	return output.Write(os.Stdout, globals.format, v)
+}
+
+// Execute runs the root command and reports any error on stderr.
+func Execute() error {
+	err := rootCmd.Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/selfupdate"
+)
+
+func newSelfUpdateCmd() *cobra.Command {
+	cfg := selfupdate.Config{Bucket: os.Getenv("GCSFUSE_TOOLS_ARTIFACTS_BUCKET")}
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with a release from the artifacts bucket",
+		Long: `self-update downloads the gcsfuse-tools release for this OS and architecture
+that make publish uploaded to --bucket, the latest one unless --version is
+given, checks its SHA-256 and atomically replaces the running binary with it,
+so benchmark VMs and images install a prebuilt binary instead of building
+from source. It does nothing if the binary is already at that version.`,
+		Example: `  gcsfuse-tools self-update --bucket=my-artifacts
+  gcsfuse-tools self-update --bucket=my-artifacts --check
+  gcsfuse-tools self-update --bucket=my-artifacts --version=v0.4.0`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Current = version
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+			client, err := storage.NewClient(ctx)
+			if err != nil {
+				return fmt.Errorf("creating storage client: %w", err)
+			}
+			defer client.Close()
+			res, err := selfupdate.Run(ctx, client, cfg)
+			if err != nil {
+				return err
+			}
+			return writeResult(res)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Bucket, "bucket", cfg.Bucket, "Artifacts bucket written by make publish. Defaults to $GCSFUSE_TOOLS_ARTIFACTS_BUCKET.")
+	f.StringVar(&cfg.Version, "version", "", "Release to install. Defaults to the latest.")
+	f.BoolVar(&cfg.Check, "check", false, "Only report whether an update is available.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newSelfUpdateCmd())
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"gcsfuse-tools-cli/internal/soak"
+)
+
+func newSoakCmd() *cobra.Command {
+	var configFile string
+	var rounds int
+	cmd := &cobra.Command{
+		Use:   "soak",
+		Short: "Run coherence scenarios and light benchmarks on a schedule and publish a trend page",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configFile == "" {
+				return errors.New("--config is required")
+			}
+			cfg, err := soak.LoadConfig(configFile)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("rounds") {
+				cfg.Rounds = rounds
+			}
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			runner := &soak.Runner{Config: cfg, Executable: exe, GlobalArgs: globalArgs(cmd)}
+			if cfg.Report.Bucket != "" {
+				client, err := storage.NewClient(ctx)
+				if err != nil {
+					return fmt.Errorf("creating storage client: %w", err)
+				}
+				defer client.Close()
+				p := &soak.Publisher{Bucket: client.Bucket(cfg.Report.Bucket), Prefix: cfg.Report.Prefix, KeepRounds: cfg.Report.KeepRounds}
+				runner.Publish = p.Publish
+			}
+
+			failed, err := runner.Run(ctx)
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d soak round(s) had failing scenarios", failed)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&configFile, "config", "", "YAML soak configuration with the schedule, report location and scenarios.")
+	f.IntVar(&rounds, "rounds", 0, "Override the number of rounds in the config. 0 runs until the schedule ends.")
+	return cmd
+}
+
+// globalArgs returns the root flags set on the command line, except the
+// output format soak picks itself, for the child commands.
+func globalArgs(cmd *cobra.Command) []string {
+	var args []string
+	cmd.Root().PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed && f.Name != "output" {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+func init() {
+	rootCmd.AddCommand(newSoakCmd())
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/tune"
+)
+
+func newTuneCmd() *cobra.Command {
+	cfg := tune.Config{}
+	var params []string
+	var objective string
+	cmd := &cobra.Command{
+		Use:   "tune",
+		Short: "Search gcsfuse flag values for the best fio result",
+		Long: `tune mounts --bucket with candidate values of the --param flags, runs the fio
+jobfile for each candidate and reports the best configuration with its
+improvement over the gcsfuse defaults, which are always measured first.`,
+		Example: `  gcsfuse-tools tune --bucket=my-bucket --mount-point=/mnt/tune --jobfile=seq_read.fio \
+    --param=file-cache-max-size-mb=0,-1 --param=file-cache-enable-parallel-downloads=false,true \
+    --param=file-cache-parallel-downloads-per-file=4,16,64 --strategy=bayes --budget=8`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if cfg.Objective, err = tune.ParseObjective(objective); err != nil {
+				return err
+			}
+			cfg.Params = nil
+			for _, p := range params {
+				param, err := tune.ParseParam(p)
+				if err != nil {
+					return err
+				}
+				cfg.Params = append(cfg.Params, param)
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			res, err := tune.Run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(res); err != nil {
+				return err
+			}
+			return registerResult(cmd.Context(), "tune", "", res.Env, res)
+		},
+	}
+	f := cmd.Flags()
+	addBenchFlags(f, &cfg.Bench)
+	f.StringArrayVar(&params, "param", nil, "gcsfuse flag and the values to try, as flag=v1,v2,... Repeat for each tuned flag.")
+	f.StringVar(&objective, "objective", string(tune.ReadBandwidth), "Metric to optimize: read-bw, write-bw (maximized) or read-p99-lat (minimized).")
+	f.StringVar(&cfg.Strategy, "strategy", tune.StrategyGrid, "Search strategy: grid (every combination) or bayes (surrogate-guided, needs --budget).")
+	f.IntVar(&cfg.Budget, "budget", 0, "Maximum number of trials besides the baseline. 0 runs the full grid.")
+	f.IntVar(&cfg.Repeat, "repeat", 1, "Runs per trial; the mean is scored.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newTuneCmd())
+}
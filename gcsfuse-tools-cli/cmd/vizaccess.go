@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+	"gcsfuse-tools-cli/internal/viz"
+)
+
+func newVizAccessCmd() *cobra.Command {
+	var outDir, format, layer string
+	var minReads, top int
+	cmd := &cobra.Command{
+		Use:   "viz-access <gcsfuse-log>...",
+		Short: "Plot offset vs. time of the reads in gcsfuse trace logs, per file",
+		Long: `viz-access reads gcsfuse logs written with --log-severity=trace (text or JSON
+format) and plots every file's FUSE reads (from the kernel) and GCS reads (to
+Cloud Storage) as offset vs. time, one plot per file and layer.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch layer {
+			case "", gcsfuselog.LayerFUSE, gcsfuselog.LayerGCS:
+			default:
+				return fmt.Errorf("unsupported --layer %q (want fuse or gcs)", layer)
+			}
+			series, err := viz.Load(args, layer)
+			if err != nil {
+				return err
+			}
+			var kept []*viz.Series
+			for _, s := range series {
+				if s.Count >= int64(minReads) && (top <= 0 || len(kept) < top) {
+					kept = append(kept, s)
+				}
+			}
+			if len(kept) == 0 {
+				return fmt.Errorf("no file with at least %d reads found; were the logs written with --log-severity=trace?", minReads)
+			}
+			if err := viz.Render(outDir, format, kept); err != nil {
+				return err
+			}
+			return writeResult(&viz.Report{OutDir: outDir, Series: kept})
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&outDir, "out-dir", "viz-access", "Directory the plots are written to.")
+	f.StringVar(&format, "format", viz.FormatHTML, "Plot format: html (one page with SVG plots) or png (one image per file).")
+	f.StringVar(&layer, "layer", "", "Only plot fuse or gcs reads. Defaults to both.")
+	f.IntVar(&minReads, "min-reads", 2, "Skip files with fewer reads.")
+	f.IntVar(&top, "top", 50, "Plot at most this many files, busiest first. 0 plots all.")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newVizAccessCmd())
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gcsfuse-tools-cli/internal/fiojob"
+	"gcsfuse-tools-cli/pkg/workload"
+)
+
+func init() {
+	rootCmd.AddCommand(newWorkloadCmd())
+}
+
+func newWorkloadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workload",
+		Short: "Check and render the workload specs the benchmark commands run",
+		Long: `A workload spec is a versioned YAML or JSON file describing the dataset, op
+mix, concurrency and duration of a benchmark:
+
+  version: 1
+  name: ckpt-restore
+  dataset: {name: ckpt-1g, bucket: my-bucket, prefix: ckpt/, file_size: 1G, files_per_worker: 4}
+  ops:
+    - {type: read, weight: 80, block_size: 1M}
+    - {type: randread, weight: 20, block_size: 128K}
+  concurrency: {workers: 64, iodepth: 8}
+  duration: 10m
+  seed: 42
+
+bench fio --spec and bench gcs-read --spec run it, and register their results
+keyed to the hash of the spec, so the runs of one workload are compared across
+backends and gcsfuse versions, e.g. with the spec_hash filter and group_by of
+results serve. The hash covers the normalized spec, not the formatting of the
+file.
+
+dataset.names names the objects under the prefix on every backend, by default
+{op}.{worker}.{file}, with the op type and the worker and file numbers
+counted from 0. Each op runs its weight of the workers, rounded so that they
+add up to concurrency.workers.`,
+	}
+	cmd.AddCommand(newWorkloadValidateCmd(), newWorkloadRenderCmd(), newWorkloadSchemaCmd())
+	return cmd
+}
+
+func newWorkloadValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate FILE",
+		Short: "Check a workload spec and print it with its hash",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := workload.Load(args[0])
+			if err != nil {
+				return err
+			}
+			return writeResult(s.Summary())
+		},
+	}
+}
+
+func newWorkloadRenderCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "render FILE",
+		Short: "Print the fio jobfile bench fio --spec runs for a workload spec",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := workload.Load(args[0])
+			if err != nil {
+				return err
+			}
+			return fiojob.RenderSpec(os.Stdout, s)
+		},
+	}
+}
+
+func newWorkloadSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of workload specs, for editors and CI checks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := os.Stdout.Write(workload.Schema)
+			return err
+		},
+	}
+}
@@ -0,0 +1,331 @@
+// Package alert finds sustained shifts in the registered benchmark results
+// of every workload with changepoint detection and posts the regressions to
+// a chat webhook, so that the nightly pipeline reports them the morning
+// after they land.
+package alert
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/changelog"
+)
+
+// Verdicts of a shift.
+const (
+	Regressed = "regressed"
+	Improved  = "improved"
+)
+
+// Config holds the detection options.
+type Config struct {
+	// Window is the number of most recent runs of a workload analyzed.
+	Window int
+	// MinShift is the smallest relative change of the median, a fraction,
+	// that counts as a shift.
+	MinShift float64
+	// MinScore is the smallest Welch t statistic between the runs before
+	// and after a changepoint that counts as a shift.
+	MinScore float64
+	// Sustain is the number of runs after a changepoint needed to call the
+	// shift sustained, so one noisy night does not alert.
+	Sustain int
+	// MaxAge is the most runs after a changepoint for the shift to be new.
+	// Older shifts have been posted before and are only reported.
+	MaxAge int
+	// Webhook, when set, receives new regressions as a JSON {"text": ...}
+	// message, the format of Slack and Google Chat incoming webhooks.
+	Webhook string
+}
+
+// Validate reports out-of-range options.
+func (c *Config) Validate() error {
+	if c.Sustain < 1 {
+		return errors.New("--sustain must be at least 1")
+	}
+	if c.MaxAge < c.Sustain {
+		return errors.New("--max-age must be at least --sustain")
+	}
+	if c.Window < c.Sustain+2 {
+		return errors.New("--window must exceed --sustain by at least 2 runs")
+	}
+	if c.MinShift < 0 || c.MinScore < 0 {
+		return errors.New("--min-shift and --min-score must not be negative")
+	}
+	return nil
+}
+
+// Shift is a sustained change of one workload metric.
+type Shift struct {
+	Workload string `json:"workload"`
+	Metric   string `json:"metric"`
+	// RunID and Time identify the first run after the changepoint.
+	RunID string    `json:"run_id"`
+	Time  time.Time `json:"time"`
+	// Before and After are the medians of the runs on either side, and
+	// Percent the relative change.
+	Before  float64 `json:"before"`
+	After   float64 `json:"after"`
+	Percent float64 `json:"percent"`
+	Score   float64 `json:"score"`
+	// Runs is the number of runs since the changepoint.
+	Runs    int    `json:"runs"`
+	Verdict string `json:"verdict"`
+	// New is set for shifts at most MaxAge runs old, which are posted.
+	New bool `json:"new"`
+	// Gcsfuse is the gcsfuse version of the first run after the
+	// changepoint, when recorded.
+	Gcsfuse string `json:"gcsfuse,omitempty"`
+}
+
+// Report is the outcome of a detection over all workloads.
+type Report struct {
+	Workloads int     `json:"workloads"`
+	Runs      int     `json:"runs"`
+	Shifts    []Shift `json:"shifts"`
+	// Skipped counts workloads with too few runs to analyze.
+	Skipped int  `json:"skipped"`
+	Posted  bool `json:"posted"`
+}
+
+// point is one measurement of a series.
+type point struct {
+	run     changelog.Run
+	value   float64
+	gcsfuse string
+}
+
+// Detect finds the changepoint of every workload metric in runs and posts
+// the new regressions to cfg.Webhook.
+func Detect(ctx context.Context, cfg Config, runs []changelog.Run) (*Report, error) {
+	slices.SortStableFunc(runs, func(a, b changelog.Run) int { return a.Entry.CreatedAt.Compare(b.Entry.CreatedAt) })
+	type series struct {
+		metric changelog.Metric
+		points []point
+	}
+	bySeries := map[string]*series{}
+	var keys []string
+	for _, r := range runs {
+		ms, err := changelog.Metrics(r)
+		if err != nil {
+			return nil, err
+		}
+		version := ""
+		if r.Entry.Env != nil {
+			version = r.Entry.Env.GcsfuseVersion
+		}
+		for _, m := range ms {
+			key := m.Workload + "\x00" + m.Name
+			s, ok := bySeries[key]
+			if !ok {
+				s = &series{metric: m}
+				bySeries[key] = s
+				keys = append(keys, key)
+			}
+			s.points = append(s.points, point{run: r, value: m.Value, gcsfuse: version})
+		}
+	}
+	slices.Sort(keys)
+
+	rep := &Report{Workloads: len(keys), Runs: len(runs), Shifts: []Shift{}}
+	for _, key := range keys {
+		s := bySeries[key]
+		pts := s.points[max(0, len(s.points)-cfg.Window):]
+		if len(pts) < cfg.Sustain+2 {
+			rep.Skipped++
+			continue
+		}
+		if sh, ok := changepoint(cfg, s.metric, pts); ok {
+			rep.Shifts = append(rep.Shifts, sh)
+		}
+	}
+	// New shifts first, then the largest.
+	slices.SortStableFunc(rep.Shifts, func(a, b Shift) int {
+		if a.New != b.New {
+			if a.New {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(math.Abs(b.Percent), math.Abs(a.Percent))
+	})
+	if cfg.Webhook != "" {
+		msg := rep.message()
+		if msg != "" {
+			if err := post(ctx, cfg.Webhook, msg); err != nil {
+				return rep, err
+			}
+			rep.Posted = true
+		}
+	}
+	return rep, nil
+}
+
+// changepoint finds the split of pts into runs before and after it with the
+// largest Welch t statistic, keeping at least two runs before and
+// cfg.Sustain after, and reports it if it is a shift.
+func changepoint(cfg Config, m changelog.Metric, pts []point) (Shift, bool) {
+	best, bestScore := -1, 0.0
+	for k := 2; k <= len(pts)-cfg.Sustain; k++ {
+		if t := welch(values(pts[:k]), values(pts[k:])); t > bestScore {
+			best, bestScore = k, t
+		}
+	}
+	if best < 0 || bestScore < cfg.MinScore {
+		return Shift{}, false
+	}
+	before, after := median(values(pts[:best])), median(values(pts[best:]))
+	if before == 0 {
+		return Shift{}, false
+	}
+	sh := Shift{
+		Workload: m.Workload,
+		Metric:   m.Name,
+		RunID:    pts[best].run.Entry.RunID,
+		Time:     pts[best].run.Entry.CreatedAt,
+		Before:   before,
+		After:    after,
+		Percent:  (after - before) / before * 100,
+		Score:    bestScore,
+		Runs:     len(pts) - best,
+		Gcsfuse:  pts[best].gcsfuse,
+	}
+	if math.Abs(sh.Percent) < cfg.MinShift*100 {
+		return Shift{}, false
+	}
+	sh.Verdict = Regressed
+	if sh.Percent > 0 == m.HigherBetter {
+		sh.Verdict = Improved
+	}
+	sh.New = sh.Runs <= cfg.MaxAge
+	return sh, true
+}
+
+func values(pts []point) []float64 {
+	vs := make([]float64, len(pts))
+	for i, p := range pts {
+		vs[i] = p.value
+	}
+	return vs
+}
+
+// welch returns the absolute Welch t statistic of the means of a and b. The
+// standard error is at least 0.1% of the larger mean, so constant samples,
+// e.g. of a deterministic metric, do not give an infinite score.
+func welch(a, b []float64) float64 {
+	ma, va := meanVar(a)
+	mb, vb := meanVar(b)
+	se := math.Sqrt(va/float64(len(a)) + vb/float64(len(b)))
+	se = max(se, 0.001*max(math.Abs(ma), math.Abs(mb)))
+	if se == 0 {
+		return 0
+	}
+	return math.Abs(ma-mb) / se
+}
+
+// meanVar returns the mean and the sample variance of vs.
+func meanVar(vs []float64) (mean, variance float64) {
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(len(vs))
+	if len(vs) < 2 {
+		return mean, 0
+	}
+	for _, v := range vs {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, variance / float64(len(vs)-1)
+}
+
+func median(vs []float64) float64 {
+	vs = slices.Clone(vs)
+	slices.Sort(vs)
+	if len(vs)%2 == 1 {
+		return vs[len(vs)/2]
+	}
+	return (vs[len(vs)/2-1] + vs[len(vs)/2]) / 2
+}
+
+// message renders the new regressions as chat text, or "" if there are
+// none.
+func (r *Report) message() string {
+	var b strings.Builder
+	n := 0
+	for _, s := range r.Shifts {
+		if !s.New || s.Verdict != Regressed {
+			continue
+		}
+		n++
+		fmt.Fprintf(&b, "\n• %s: %s %.2f → %.2f (%+.1f%%) since run %s", s.Workload, s.Metric, s.Before, s.After, s.Percent, s.RunID)
+		if s.Gcsfuse != "" {
+			fmt.Fprintf(&b, " (gcsfuse %s)", s.Gcsfuse)
+		}
+		fmt.Fprintf(&b, ", %d run(s)", s.Runs)
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("gcsfuse benchmarks: %d sustained regression(s) detected%s", n, b.String())
+}
+
+// post sends text to a Slack or Google Chat incoming webhook.
+func post(ctx context.Context, url, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("posting to webhook: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// WriteText prints one row per shift.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%d workload metric(s) over %d run(s), %d with too few runs\n", r.Workloads, r.Runs, r.Skipped)
+	if len(r.Shifts) == 0 {
+		fmt.Fprintln(w, "No sustained shift.")
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tMETRIC\tBEFORE\tAFTER\tCHANGE\tSCORE\tSINCE RUN\tRUNS\tVERDICT")
+	for _, s := range r.Shifts {
+		verdict := s.Verdict
+		if s.New {
+			verdict += " (new)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%+.1f%%\t%.1f\t%s\t%d\t%s\n", s.Workload, s.Metric, s.Before, s.After, s.Percent,
+			s.Score, s.RunID, s.Runs, verdict)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if r.Posted {
+		fmt.Fprintln(w, "\nPosted the new regressions to the webhook.")
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+// Package analyzebucket samples the layout of an existing bucket and
+// predicts how gcsfuse will behave on it: listing cost, the caches worth
+// enabling and whether a hierarchical namespace would help.
+package analyzebucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Constants of the predictions.
+const (
+	// listPageSize is the most entries one ListObjects call returns.
+	listPageSize = 1000
+	// listLatency is a typical ListObjects round trip from a VM in the
+	// bucket's region, used to estimate the time of a full walk.
+	listLatency = 50 * time.Millisecond
+	// classAPricePer1000 is the price of 1000 Class A operations, which
+	// include ListObjects, in USD for the Standard storage class.
+	classAPricePer1000 = 0.005
+	// statEntryBytes and typeEntryBytes are the approximate sizes of a stat
+	// cache entry and of a type cache entry gcsfuse documents for sizing
+	// its metadata cache.
+	statEntryBytes = 1600
+	typeEntryBytes = 200
+	// entropyChars is the number of leading characters of every base name
+	// whose distribution measures the name entropy.
+	entropyChars = 3
+)
+
+// Config holds the analyze-bucket options.
+type Config struct {
+	Bucket string
+	Prefix string
+	// MaxObjects stops the listing after this many objects; 0 lists all.
+	MaxObjects int64
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if c.MaxObjects < 0 {
+		return errors.New("--max-objects must not be negative")
+	}
+	return nil
+}
+
+// SizeBin counts the objects of up to UpTo bytes that are larger than those
+// of the previous bin. The last bin has no bound.
+type SizeBin struct {
+	Label   string `json:"label"`
+	UpTo    int64  `json:"up_to,omitempty"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// Sizes describes the object size distribution.
+type Sizes struct {
+	Min       int64     `json:"min"`
+	P50       int64     `json:"p50"`
+	P90       int64     `json:"p90"`
+	P99       int64     `json:"p99"`
+	Max       int64     `json:"max"`
+	Mean      int64     `json:"mean"`
+	Histogram []SizeBin `json:"histogram"`
+}
+
+// Layout describes the directory tree gcsfuse presents, with "/" as the
+// separator.
+type Layout struct {
+	Directories int `json:"directories"`
+	// MarkerObjects are zero-byte "dir/" objects; directories without one
+	// are only visible with --implicit-dirs on a flat bucket.
+	MarkerObjects int     `json:"marker_objects"`
+	ImplicitDirs  int     `json:"implicit_dirs"`
+	MaxDepth      int     `json:"max_depth"`
+	MeanEntries   float64 `json:"mean_entries"`
+	MaxEntries    int     `json:"max_entries"`
+	LargestDir    string  `json:"largest_dir"`
+}
+
+// Listing predicts the cost of walking the whole tree once, e.g. with
+// ls -R or a data loader that enumerates the dataset.
+type Listing struct {
+	Calls   int64   `json:"calls"`
+	Seconds float64 `json:"seconds"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// Recommendation is one piece of advice with the observation behind it.
+type Recommendation struct {
+	Topic  string `json:"topic"`
+	Advice string `json:"advice"`
+	Reason string `json:"reason"`
+}
+
+// Report is the result of analyze-bucket.
+type Report struct {
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix,omitempty"`
+	Location     string `json:"location"`
+	StorageClass string `json:"storage_class"`
+	HNS          bool   `json:"hns"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	// Truncated is set when the listing stopped at Config.MaxObjects, so
+	// the report describes the lexicographically first objects only.
+	Truncated bool   `json:"truncated"`
+	Sizes     Sizes  `json:"sizes"`
+	Layout    Layout `json:"layout"`
+	// NameEntropyBits is the Shannon entropy of the first characters of
+	// the base names. Low values mean sequential names, e.g. timestamps.
+	NameEntropyBits float64          `json:"name_entropy_bits"`
+	Listing         Listing          `json:"listing"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+// dir counts the entries of one directory.
+type dir struct {
+	files, subdirs int
+	marker         bool
+}
+
+// Analyze lists cfg.Bucket under cfg.Prefix and reports its layout and the
+// predicted gcsfuse behavior.
+func Analyze(ctx context.Context, client *storage.Client, cfg Config) (*Report, error) {
+	bucket := client.Bucket(cfg.Bucket)
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading bucket %s: %w", cfg.Bucket, err)
+	}
+	r := &Report{
+		Bucket:       cfg.Bucket,
+		Prefix:       cfg.Prefix,
+		Location:     attrs.Location,
+		StorageClass: attrs.StorageClass,
+		HNS:          attrs.HierarchicalNamespace != nil && attrs.HierarchicalNamespace.Enabled,
+	}
+	slog.Info("Listing bucket", "bucket", cfg.Bucket, "prefix", cfg.Prefix, "max_objects", cfg.MaxObjects)
+
+	q := &storage.Query{Prefix: cfg.Prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return nil, err
+	}
+	var sizes []int64
+	dirs := map[string]*dir{"": {}}
+	leading := map[string]int{}
+	it := bucket.Objects(ctx, q)
+	for {
+		if cfg.MaxObjects > 0 && r.Objects >= cfg.MaxObjects {
+			r.Truncated = true
+			break
+		}
+		a, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing objects in %s: %w", cfg.Bucket, err)
+		}
+		r.Objects++
+		r.Bytes += a.Size
+		name := strings.TrimPrefix(a.Name, cfg.Prefix)
+		if name == "" {
+			continue
+		}
+		if strings.HasSuffix(name, "/") {
+			addDir(dirs, strings.TrimSuffix(name, "/")).marker = true
+			continue
+		}
+		sizes = append(sizes, a.Size)
+		parent, base := "", name
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			parent, base = name[:i], name[i+1:]
+		}
+		addDir(dirs, parent).files++
+		leading[base[:min(len(base), entropyChars)]]++
+		if r.Objects%100000 == 0 {
+			slog.Info("Listed objects", "count", r.Objects)
+		}
+	}
+	r.Sizes = sizeStats(sizes)
+	r.Layout = layout(dirs)
+	r.NameEntropyBits = entropy(leading)
+	for _, d := range dirs {
+		r.Listing.Calls += int64(max(1, (d.files+d.subdirs+listPageSize-1)/listPageSize))
+	}
+	r.Listing.Seconds = float64(r.Listing.Calls) * listLatency.Seconds()
+	r.Listing.CostUSD = float64(r.Listing.Calls) / 1000 * classAPricePer1000
+	r.Recommendations = recommend(r)
+	return r, nil
+}
+
+// addDir returns the directory name, adding it and its parents to dirs.
+// The root is "".
+func addDir(dirs map[string]*dir, name string) *dir {
+	if d, ok := dirs[name]; ok {
+		return d
+	}
+	d := &dir{}
+	dirs[name] = d
+	parent := ""
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		parent = name[:i]
+	}
+	addDir(dirs, parent).subdirs++
+	return d
+}
+
+func layout(dirs map[string]*dir) Layout {
+	var l Layout
+	entries := 0
+	for name, d := range dirs {
+		if name != "" {
+			l.Directories++
+			if d.marker {
+				l.MarkerObjects++
+			} else {
+				l.ImplicitDirs++
+			}
+			l.MaxDepth = max(l.MaxDepth, strings.Count(name, "/")+1)
+		}
+		n := d.files + d.subdirs
+		entries += n
+		if n > l.MaxEntries || n == l.MaxEntries && name < l.LargestDir {
+			l.MaxEntries, l.LargestDir = n, name
+		}
+	}
+	l.MeanEntries = float64(entries) / float64(len(dirs))
+	if l.LargestDir == "" {
+		l.LargestDir = "/"
+	}
+	return l
+}
+
+// sizeBins are the upper bounds of the size histogram.
+var sizeBins = []int64{64 * units.KiB, units.MiB, 16 * units.MiB, 256 * units.MiB, units.GiB, 16 * units.GiB}
+
+func sizeStats(sizes []int64) Sizes {
+	s := Sizes{}
+	for _, b := range sizeBins {
+		s.Histogram = append(s.Histogram, SizeBin{Label: "<= " + units.FormatSize(b), UpTo: b})
+	}
+	s.Histogram = append(s.Histogram, SizeBin{Label: "> " + units.FormatSize(sizeBins[len(sizeBins)-1])})
+	if len(sizes) == 0 {
+		return s
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	var total int64
+	for _, n := range sizes {
+		total += n
+		i := sort.Search(len(sizeBins), func(i int) bool { return n <= sizeBins[i] })
+		s.Histogram[i].Objects++
+		s.Histogram[i].Bytes += n
+	}
+	pct := func(p float64) int64 { return sizes[int(p*float64(len(sizes)-1))] }
+	s.Min, s.Max = sizes[0], sizes[len(sizes)-1]
+	s.P50, s.P90, s.P99 = pct(0.5), pct(0.9), pct(0.99)
+	s.Mean = total / int64(len(sizes))
+	return s
+}
+
+// entropy returns the Shannon entropy in bits of the counts.
+func entropy(counts map[string]int) float64 {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	h := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// smallObjects returns the share of the objects of at most 1M.
+func (r *Report) smallObjects() float64 {
+	files := r.Objects - int64(r.Layout.MarkerObjects)
+	if files == 0 {
+		return 0
+	}
+	var n int64
+	for _, b := range r.Sizes.Histogram {
+		if b.UpTo != 0 && b.UpTo <= units.MiB {
+			n += b.Objects
+		}
+	}
+	return float64(n) / float64(files)
+}
+
+// recommend derives the advice from the observations of r.
+func recommend(r *Report) []Recommendation {
+	recs := []Recommendation{}
+	add := func(topic, reason, advice string, args ...any) {
+		recs = append(recs, Recommendation{Topic: topic, Reason: reason, Advice: fmt.Sprintf(advice, args...)})
+	}
+	files := r.Objects - int64(r.Layout.MarkerObjects)
+
+	if r.Listing.Calls > 1000 {
+		add("listing",
+			fmt.Sprintf("walking the tree takes about %d list calls (%.0fs sequentially, $%.2f)", r.Listing.Calls, r.Listing.Seconds, r.Listing.CostUSD),
+			"Avoid repeated full walks: pass the file list to the workload instead of enumerating the mount on every epoch, and cache listings with --kernel-list-cache-ttl-secs=-1 for read-only data.")
+	} else if r.Layout.MaxEntries > 10*listPageSize {
+		add("listing",
+			fmt.Sprintf("%s holds %d entries, %d list calls per readdir", r.Layout.LargestDir, r.Layout.MaxEntries, (r.Layout.MaxEntries+listPageSize-1)/listPageSize),
+			"Cache directory listings in the kernel with --kernel-list-cache-ttl-secs, or split large directories.")
+	}
+
+	if files > 0 {
+		statMiB := (files*statEntryBytes + units.MiB - 1) / units.MiB
+		typeMiB := max(4, (int64(r.Layout.MaxEntries)*typeEntryBytes+units.MiB-1)/units.MiB)
+		add("metadata-cache",
+			fmt.Sprintf("%d objects in %d directories of up to %d entries", files, r.Layout.Directories+1, r.Layout.MaxEntries),
+			"Size the metadata cache to hold the dataset: --stat-cache-max-size-mb=%d --type-cache-max-size-mb=%d, with --metadata-cache-ttl-secs=-1 if the data does not change while mounted.", statMiB, typeMiB)
+	}
+
+	if r.Sizes.P50 >= units.MiB {
+		advice := fmt.Sprintf("Enable the file cache with --cache-dir on local SSD and --file-cache-max-size-mb=%d (the sampled dataset) for repeated reads", (r.Bytes+units.MiB-1)/units.MiB)
+		if r.Sizes.P90 >= units.GiB {
+			advice += ", with --file-cache-enable-parallel-downloads for the large objects and --file-cache-cache-file-for-range-read for random reads of them"
+		}
+		add("file-cache",
+			fmt.Sprintf("median object size %s, p90 %s, %s in total", formatBytes(r.Sizes.P50), formatBytes(r.Sizes.P90), formatBytes(r.Bytes)),
+			"%s.", advice)
+	}
+	if s := r.smallObjects(); files > 0 && s >= 0.5 {
+		add("small-files",
+			fmt.Sprintf("%.0f%% of the objects are 1M or smaller", 100*s),
+			"Per-file latency dominates: read with many parallel workers (e.g. 64+ loader threads), keep the metadata cache warm and consider packing the files into larger shards (tar, TFRecord, webdataset).")
+	}
+
+	switch {
+	case r.HNS:
+		add("hns", "the bucket has a hierarchical namespace",
+			"Folders are real resources: renames are atomic and listings are fast; no --implicit-dirs needed.")
+	case r.Layout.Directories >= 1000 || r.Layout.MaxDepth >= 3:
+		add("hns",
+			fmt.Sprintf("%d directories, up to %d levels deep", r.Layout.Directories, r.Layout.MaxDepth),
+			"Use a bucket with hierarchical namespace for atomic directory renames (checkpoints), faster listings and no --implicit-dirs overhead.")
+	case r.Layout.Directories == 0:
+		add("hns", "the objects have no directories",
+			"A flat bucket is fine; hierarchical namespace brings no benefit for this layout.")
+	}
+	if !r.HNS && r.Layout.ImplicitDirs > 0 {
+		add("implicit-dirs",
+			fmt.Sprintf("%d of %d directories have no marker object", r.Layout.ImplicitDirs, r.Layout.Directories),
+			"Mount with --implicit-dirs to see them, which costs an extra list call per lookup of a missing name, or create the marker objects.")
+	}
+
+	if files >= 10000 && r.NameEntropyBits < 6 {
+		add("naming",
+			fmt.Sprintf("the first %d characters of the names have %.1f bits of entropy", entropyChars, r.NameEntropyBits),
+			"Names look sequential (e.g. timestamps or counters), which concentrates requests on one key range: ramp up request rates gradually or add a hashed prefix to new objects.")
+	}
+	return recs
+}
+
+// formatBytes renders n with one decimal in the largest binary unit.
+func formatBytes(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"T", units.TiB}, {"G", units.GiB}, {"M", units.MiB}, {"K", units.KiB}} {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// WriteText prints the observations, the size histogram and the
+// recommendations.
+func (r *Report) WriteText(w io.Writer) error {
+	hns := "flat namespace"
+	if r.HNS {
+		hns = "hierarchical namespace"
+	}
+	fmt.Fprintf(w, "gs://%s/%s (%s, %s, %s)\n", r.Bucket, r.Prefix, r.Location, r.StorageClass, hns)
+	fmt.Fprintf(w, "Objects: %d, %s", r.Objects, formatBytes(r.Bytes))
+	if r.Truncated {
+		fmt.Fprint(w, " (listing stopped at --max-objects; the rest of the bucket is not included)")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Sizes: min %s, p50 %s, p90 %s, p99 %s, max %s, mean %s\n",
+		formatBytes(r.Sizes.Min), formatBytes(r.Sizes.P50), formatBytes(r.Sizes.P90), formatBytes(r.Sizes.P99), formatBytes(r.Sizes.Max), formatBytes(r.Sizes.Mean))
+	l := r.Layout
+	fmt.Fprintf(w, "Directories: %d (%d implicit), up to %d deep, %.1f entries on average, up to %d in %s\n",
+		l.Directories, l.ImplicitDirs, l.MaxDepth, l.MeanEntries, l.MaxEntries, l.LargestDir)
+	fmt.Fprintf(w, "Name entropy: %.1f bits in the first %d characters\n", r.NameEntropyBits, entropyChars)
+	fmt.Fprintf(w, "Full listing: %d list calls, ~%.0fs sequentially, ~$%.4f\n\n", r.Listing.Calls, r.Listing.Seconds, r.Listing.CostUSD)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tOBJECTS\tBYTES")
+	for _, b := range r.Sizes.Histogram {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", b.Label, b.Objects, formatBytes(b.Bytes))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, rec := range r.Recommendations {
+		fmt.Fprintf(w, "\n[%s] %s\n  %s\n", rec.Topic, rec.Reason, rec.Advice)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+// Package audit checks the hygiene of the registered benchmark datasets:
+// that their buckets still hold what the registry says, grant no more access
+// than needed, clean up after themselves and are not kept past their expiry.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"gcsfuse-tools-cli/internal/dataprep"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+// ExpiresLabel is the bucket label holding the date, YYYY-MM-DD, after which
+// a benchmark bucket may be deleted.
+const ExpiresLabel = "expires"
+
+// Severities of a finding.
+const (
+	Fail = "fail"
+	Warn = "warn"
+)
+
+// Checks.
+const (
+	CheckBucket    = "bucket"
+	CheckManifest  = "manifest"
+	CheckIAM       = "iam"
+	CheckLifecycle = "lifecycle"
+	CheckExpiry    = "expiry"
+	CheckFreshness = "freshness"
+)
+
+// broadRoles grant more than a benchmark needs when given without a
+// condition to a principal.
+var broadRoles = map[string]bool{
+	"roles/owner":                     true,
+	"roles/editor":                    true,
+	"roles/storage.admin":             true,
+	"roles/storage.legacyBucketOwner": true,
+}
+
+// Config holds the audit options.
+type Config struct {
+	// MaxAge flags datasets registered longer ago; 0 disables the check.
+	MaxAge time.Duration
+	// MaxGrantTTL flags time-bound grants that expire further out.
+	MaxGrantTTL time.Duration
+	// Datasets limits the audit to these names; empty audits all.
+	Datasets []string
+	// Now is the time expiries are compared with; zero means time.Now.
+	Now time.Time
+}
+
+// Finding is one problem of a dataset, with what to do about it.
+type Finding struct {
+	Dataset  string `json:"dataset"`
+	Bucket   string `json:"bucket"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Action   string `json:"action"`
+}
+
+// Report is the outcome of an audit.
+type Report struct {
+	Time     time.Time `json:"time"`
+	Datasets int       `json:"datasets"`
+	Buckets  int       `json:"buckets"`
+	Findings []Finding `json:"findings"`
+}
+
+// Failed returns the number of findings of severity Fail.
+func (r *Report) Failed() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == Fail {
+			n++
+		}
+	}
+	return n
+}
+
+// Run audits the datasets registered in reg and the buckets holding them.
+func Run(ctx context.Context, client *storage.Client, reg *registry.Registry, cfg Config) (*Report, error) {
+	if cfg.Now.IsZero() {
+		cfg.Now = time.Now()
+	}
+	entries, err := reg.ListDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Datasets) > 0 {
+		want := map[string]bool{}
+		for _, name := range cfg.Datasets {
+			want[name] = true
+		}
+		var kept []*registry.DatasetEntry
+		for _, e := range entries {
+			if want[e.Name] {
+				kept = append(kept, e)
+				delete(want, e.Name)
+			}
+		}
+		for name := range want {
+			return nil, fmt.Errorf("dataset %s: %w", name, registry.ErrNotFound)
+		}
+		entries = kept
+	}
+
+	r := &Report{Time: cfg.Now, Datasets: len(entries), Findings: []Finding{}}
+	buckets := map[string][]*registry.DatasetEntry{}
+	for _, e := range entries {
+		buckets[e.Bucket] = append(buckets[e.Bucket], e)
+	}
+	r.Buckets = len(buckets)
+	names := make([]string, 0, len(buckets))
+	for b := range buckets {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	for _, b := range names {
+		slog.Info("Auditing bucket", "bucket", b, "datasets", len(buckets[b]))
+		fs, err := auditBucket(ctx, client, buckets[b], cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.Findings = append(r.Findings, fs...)
+	}
+	return r, nil
+}
+
+// auditBucket runs the bucket checks once and the dataset checks for every
+// dataset the bucket holds.
+func auditBucket(ctx context.Context, client *storage.Client, entries []*registry.DatasetEntry, cfg Config) ([]Finding, error) {
+	name := entries[0].Bucket
+	datasets := make([]string, len(entries))
+	for i, e := range entries {
+		datasets[i] = e.Name
+	}
+	var out []Finding
+	add := func(dataset, check, severity, msg, action string) {
+		if dataset == "" {
+			dataset = strings.Join(datasets, ",")
+		}
+		out = append(out, Finding{Dataset: dataset, Bucket: name, Check: check, Severity: severity, Message: msg, Action: action})
+	}
+
+	bucket := client.Bucket(name)
+	attrs, err := bucket.Attrs(ctx)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		add("", CheckBucket, Fail, "bucket does not exist",
+			"remove the stale registry entries or run dataprep --op-type=setup again")
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading attributes of %s: %w", name, err)
+	}
+
+	for _, e := range entries {
+		if cfg.MaxAge > 0 && cfg.Now.Sub(e.CreatedAt) > cfg.MaxAge {
+			add(e.Name, CheckFreshness, Warn,
+				fmt.Sprintf("registered %d days ago, more than %d", int(cfg.Now.Sub(e.CreatedAt).Hours()/24), int(cfg.MaxAge.Hours()/24)),
+				"recreate the dataset with the current dataprep or delete it if unused")
+		}
+		severity, msg, err := checkManifest(ctx, client, e)
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			add(e.Name, CheckManifest, severity, msg, "run dataprep --op-type=setup --resume with the registered spec, or re-register the dataset")
+		}
+	}
+
+	if len(attrs.Lifecycle.Rules) == 0 {
+		add("", CheckLifecycle, Warn, "no lifecycle rules",
+			"add a Delete rule (e.g. age or days since custom time) so leftovers of write benchmarks are cleaned up")
+	}
+
+	if v, ok := attrs.Labels[ExpiresLabel]; !ok {
+		add("", CheckExpiry, Warn, fmt.Sprintf("no %q label", ExpiresLabel),
+			fmt.Sprintf("label the bucket with %s=YYYY-MM-DD so it can be reclaimed", ExpiresLabel))
+	} else {
+		expiry, err := time.Parse("2006-01-02", v)
+		switch {
+		case err != nil:
+			add("", CheckExpiry, Warn, fmt.Sprintf("%s label %q is not a YYYY-MM-DD date", ExpiresLabel, v),
+				"fix the label")
+		case cfg.Now.After(expiry.AddDate(0, 0, 1)):
+			add("", CheckExpiry, Fail, fmt.Sprintf("expired on %s", v),
+				"delete the bucket with dataprep --op-type=delete, or extend the label if it is still needed")
+		}
+	}
+
+	policy, err := bucket.IAM().V3().Policy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading IAM policy of %s: %w", name, err)
+	}
+	for _, b := range policy.Bindings {
+		if expiry, ok := dataprep.GrantExpiry(b); ok {
+			switch {
+			case cfg.Now.After(expiry):
+				add("", CheckIAM, Warn, fmt.Sprintf("grant of %s to %s expired on %s", b.Role, strings.Join(b.Members, ","), expiry.Format(time.RFC3339)),
+					"remove it with dataprep --op-type=revoke; expired bindings count against the policy limit")
+			case cfg.MaxGrantTTL > 0 && expiry.Sub(cfg.Now) > cfg.MaxGrantTTL:
+				add("", CheckIAM, Warn, fmt.Sprintf("grant of %s to %s lasts until %s, more than %s from now", b.Role, strings.Join(b.Members, ","), expiry.Format(time.RFC3339), cfg.MaxGrantTTL),
+					"revoke it and grant again with a shorter --grant-ttl")
+			}
+			continue
+		}
+		for _, m := range b.Members {
+			switch {
+			case m == "allUsers" || m == "allAuthenticatedUsers":
+				add("", CheckIAM, Fail, fmt.Sprintf("%s has %s", m, b.Role),
+					"remove the public binding; benchmark data should not be public")
+			case broadRoles[b.Role] && b.Condition == nil && !strings.HasPrefix(m, "project"):
+				add("", CheckIAM, Fail, fmt.Sprintf("%s has %s without a condition", m, b.Role),
+					"replace it with a time-bound dataprep --op-type=grant of roles/storage.objectAdmin or narrower")
+			}
+		}
+	}
+	return out, nil
+}
+
+// checkManifest compares the objects of e with its registered spec and
+// manifest, and describes the difference, if any. Objects the spec does not
+// create, e.g. left by churn, only warrant a warning.
+func checkManifest(ctx context.Context, client *storage.Client, e *registry.DatasetEntry) (severity, msg string, err error) {
+	var spec dataprep.Spec
+	b, err := json.Marshal(e.Spec)
+	if err == nil {
+		err = json.Unmarshal(b, &spec)
+	}
+	if err != nil || spec.BenchType == "" {
+		return Warn, "registered without a dataprep spec; cannot compare with the bucket", nil
+	}
+	v, err := dataprep.Verify(ctx, client, spec.Config(e.Bucket))
+	if err != nil {
+		return "", "", err
+	}
+	var problems []string
+	if int64(v.Expected) != e.Manifest.ObjectCount {
+		problems = append(problems, fmt.Sprintf("manifest lists %d objects but the spec has %d", e.Manifest.ObjectCount, v.Expected))
+	}
+	if v.Missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d objects missing", v.Missing, v.Expected))
+	}
+	if v.Mismatched > 0 {
+		problems = append(problems, fmt.Sprintf("%d objects with the wrong size", v.Mismatched))
+	}
+	severity = Fail
+	if len(problems) == 0 {
+		severity = Warn
+	}
+	if v.Unexpected > 0 {
+		problems = append(problems, fmt.Sprintf("%d unexpected objects", v.Unexpected))
+	}
+	return severity, strings.Join(problems, "; "), nil
+}
+
+// WriteText prints one row per finding and a summary line.
+func (r *Report) WriteText(w io.Writer) error {
+	if len(r.Findings) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEVERITY\tBUCKET\tDATASET\tCHECK\tFINDING\tACTION")
+		for _, f := range r.Findings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", strings.ToUpper(f.Severity), f.Bucket, f.Dataset, f.Check, f.Message, f.Action)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "%d dataset(s) in %d bucket(s) audited on %s: %d failure(s), %d warning(s).\n",
+		r.Datasets, r.Buckets, r.Time.Format("2006-01-02"), r.Failed(), len(r.Findings)-r.Failed())
+	return err
+}
@@ -0,0 +1,241 @@
+// Package bench orchestrates fio workloads against a gcsfuse mount, or another
+// file system target for comparison, and summarizes the results.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/netaccount"
+	"gcsfuse-tools-cli/pkg/workload"
+)
+
+// Config describes a single orchestrated fio run.
+type Config struct {
+	// JobFile is the fio jobfile to run.
+	JobFile string
+	// Spec, when set instead of JobFile, is the workload to run, rendered
+	// into a jobfile. Its files are under its dataset prefix of MountPoint.
+	Spec *workload.Spec
+	// MountPoint is the directory fio runs in.
+	MountPoint string
+	// Target is the file system type: gcsfuse (default), nfs, s3fs or a type
+	// added with RegisterTarget.
+	Target string
+	// Bucket, when set, is mounted at MountPoint for the duration of the run
+	// by the gcsfuse and s3fs targets. Otherwise MountPoint must already be
+	// mounted.
+	Bucket        string
+	GcsfuseBinary string
+	GcsfuseFlags  []string
+	// NFSExport is the "server:/path" mounted by the nfs target.
+	NFSExport string
+	// S3Endpoint and S3fsBinary configure the s3fs target.
+	S3Endpoint string
+	S3fsBinary string
+	// MountOptions are passed with -o to the nfs and s3fs targets.
+	MountOptions []string
+	FioBinary    string
+	// Seed, when non-zero, seeds fio's random generators (randseed) so random
+	// offsets and buffer contents repeat from run to run.
+	Seed uint64
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	switch {
+	case c.Spec != nil && c.JobFile != "":
+		return errors.New("--jobfile and --spec are mutually exclusive")
+	case c.Spec != nil:
+		if err := c.specDefaults(); err != nil {
+			return err
+		}
+	case c.JobFile == "":
+		return errors.New("--jobfile is required")
+	}
+	if c.MountPoint == "" {
+		return errors.New("--mount-point is required")
+	}
+	if c.Target == "" {
+		c.Target = TargetGcsfuse
+	}
+	if _, ok := targets[c.Target]; !ok {
+		return fmt.Errorf("unsupported --target %q (want one of %s)", c.Target, strings.Join(TargetNames(), ", "))
+	}
+	return nil
+}
+
+// specDefaults takes the bucket and seed of c.Spec unless they are set.
+func (c *Config) specDefaults() error {
+	d := c.Spec.Dataset
+	switch {
+	case c.Bucket == "":
+		c.Bucket = d.Bucket
+	case d.Bucket != "" && c.Bucket != d.Bucket:
+		return fmt.Errorf("--bucket %s differs from the bucket %s of the spec", c.Bucket, d.Bucket)
+	}
+	switch {
+	case c.Seed == 0:
+		c.Seed = c.Spec.Seed
+	case c.Spec.Seed != 0 && c.Seed != c.Spec.Seed:
+		return fmt.Errorf("--seed %d differs from the seed %d of the spec", c.Seed, c.Spec.Seed)
+	}
+	return nil
+}
+
+// OpStats summarizes one I/O direction of a fio job.
+type OpStats struct {
+	Bytes      int64   `json:"bytes"`
+	BwKiBps    float64 `json:"bw_kibps"`
+	Iops       float64 `json:"iops"`
+	MeanLatNs  float64 `json:"mean_lat_ns"`
+	P99ClatNs  float64 `json:"p99_clat_ns"`
+	P999ClatNs float64 `json:"p999_clat_ns"`
+}
+
+// JobResult is the summary of one fio job.
+type JobResult struct {
+	Name  string   `json:"name"`
+	Error int      `json:"error,omitempty"`
+	Read  *OpStats `json:"read,omitempty"`
+	Write *OpStats `json:"write,omitempty"`
+}
+
+// Result is the outcome of an orchestrated run.
+type Result struct {
+	JobFile    string `json:"jobfile"`
+	MountPoint string `json:"mount_point"`
+	// Target is the file system type the jobs ran against and TargetSource
+	// what it mounted, so comparison runs are never mistaken for gcsfuse runs.
+	Target       string   `json:"target"`
+	TargetSource string   `json:"target_source,omitempty"`
+	Bucket       string   `json:"bucket,omitempty"`
+	GcsfuseFlags []string `json:"gcsfuse_flags,omitempty"`
+	// Workload is the spec the jobfile was rendered from, if any.
+	Workload   *workload.Ref `json:"workload,omitempty"`
+	Seed       uint64        `json:"seed,omitempty"`
+	FioVersion string        `json:"fio_version"`
+	StartTime  time.Time     `json:"start_time"`
+	EndTime    time.Time     `json:"end_time"`
+	Jobs       []JobResult   `json:"jobs"`
+	// Env is the fingerprint of the host and mount the jobs ran on.
+	Env *envinfo.Fingerprint `json:"env"`
+	// Net is the gcsfuse network accounting net-account appends, if any.
+	Net *netaccount.Report `json:"net,omitempty"`
+}
+
+// Run mounts the target if one is configured, runs the jobfile and summarizes
+// fio's report.
+func Run(ctx context.Context, cfg Config) (res *Result, err error) {
+	if cfg.Target == "" {
+		cfg.Target = TargetGcsfuse
+	}
+	target, err := mountTarget(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		defer func() {
+			if uerr := target.Unmount(cfg.MountPoint); uerr != nil {
+				err = errors.Join(err, uerr)
+			}
+		}()
+	}
+
+	res = &Result{
+		JobFile:    cfg.JobFile,
+		MountPoint: cfg.MountPoint,
+		Target:     cfg.Target,
+		Bucket:     cfg.Bucket,
+		Seed:       cfg.Seed,
+		StartTime:  time.Now(),
+	}
+	envOpts := envinfo.Options{MountPoint: cfg.MountPoint}
+	if target != nil {
+		res.TargetSource = target.Source()
+	}
+	if cfg.Target == TargetGcsfuse {
+		res.GcsfuseFlags = cfg.GcsfuseFlags
+		envOpts.GcsfuseBinary = cfg.GcsfuseBinary
+	}
+	res.Env = envinfo.Capture(ctx, envOpts)
+	jobFile, dir := cfg.JobFile, cfg.MountPoint
+	if cfg.Spec != nil {
+		if jobFile, err = specJobFile(cfg.Spec); err != nil {
+			return nil, err
+		}
+		defer os.Remove(jobFile)
+		res.Workload = cfg.Spec.Ref()
+		slog.Info("Rendered workload spec", "name", res.Workload.Name, "hash", res.Workload.Hash)
+		if dir, err = specDirectory(cfg.MountPoint, cfg.Spec); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Seed != 0 {
+		seeded, err := seededJobFile(jobFile, cfg.Seed)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(seeded)
+		jobFile = seeded
+	}
+	slog.Info("Running fio", "jobfile", cfg.JobFile, "directory", dir, "seed", cfg.Seed)
+	out, err := runFio(ctx, cfg.FioBinary, jobFile, dir)
+	if err != nil {
+		return nil, err
+	}
+	res.EndTime = time.Now()
+	res.FioVersion = out.FioVersion
+
+	res.Jobs = jobResults(out)
+	return res, nil
+}
+
+// mountTarget mounts the target of cfg at cfg.MountPoint and returns it, or
+// nil if the configuration names nothing to mount.
+func mountTarget(ctx context.Context, cfg Config) (Target, error) {
+	newTarget, ok := targets[cfg.Target]
+	if !ok {
+		return nil, fmt.Errorf("unsupported target %q", cfg.Target)
+	}
+	target, err := newTarget(cfg)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	if err := target.Mount(ctx, cfg.MountPoint); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// WriteText prints one row per job and direction.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "fio %s on %s [%s] (%s)\n", r.FioVersion, r.MountPoint, r.Target, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	if r.Workload != nil {
+		fmt.Fprintf(w, "Workload %s v%d, spec %s\n", r.Workload.Name, r.Workload.Version, r.Workload.Hash)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tOP\tBW (MiB/s)\tIOPS\tMEAN LAT (ms)\tP99 CLAT (ms)")
+	for _, j := range r.Jobs {
+		for _, op := range []struct {
+			name  string
+			stats *OpStats
+		}{{"read", j.Read}, {"write", j.Write}} {
+			if op.stats == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.0f\t%.2f\t%.2f\n", j.Name, op.name,
+				op.stats.BwKiBps/1024, op.stats.Iops, op.stats.MeanLatNs/1e6, op.stats.P99ClatNs/1e6)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,146 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"gcsfuse-tools-cli/internal/fiojob"
+	"gcsfuse-tools-cli/pkg/workload"
+)
+
+// fioOutput is the subset of `fio --output-format=json` consumed here.
+type fioOutput struct {
+	FioVersion string   `json:"fio version"`
+	Jobs       []fioJob `json:"jobs"`
+}
+
+type fioJob struct {
+	JobName string     `json:"jobname"`
+	Read    fioOpStats `json:"read"`
+	Write   fioOpStats `json:"write"`
+	Error   int        `json:"error"`
+}
+
+type fioOpStats struct {
+	IOBytes int64   `json:"io_bytes"`
+	Bw      float64 `json:"bw"` // KiB/s
+	Iops    float64 `json:"iops"`
+	LatNs   struct {
+		Mean float64 `json:"mean"`
+	} `json:"lat_ns"`
+	ClatNs struct {
+		Percentile map[string]float64 `json:"percentile"`
+	} `json:"clat_ns"`
+}
+
+// runFio executes jobFile with fio inside dir and decodes the JSON report.
+func runFio(ctx context.Context, fioBinary, jobFile, dir string) (*fioOutput, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, fioBinary, "--output-format=json", "--directory="+dir, jobFile)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running fio: %w: %s", err, stderr.String())
+	}
+
+	var out fioOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("decoding fio output: %w", err)
+	}
+	return &out, nil
+}
+
+// seededJobFile writes a copy of jobFile whose jobs all use seed and returns
+// its path. The leading global section applies to every job that doesn't set
+// randseed itself.
+func seededJobFile(jobFile string, seed uint64) (string, error) {
+	b, err := os.ReadFile(jobFile)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "seeded-*.fio")
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, "[global]\nrandrepeat=1\nrandseed=%d\n\n%s", seed, b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// specJobFile renders the workload spec s into a temporary jobfile and
+// returns its path.
+func specJobFile(s *workload.Spec) (string, error) {
+	f, err := os.CreateTemp("", s.Name+"-*.fio")
+	if err != nil {
+		return "", err
+	}
+	err = fiojob.RenderSpec(f, s)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// specDirectory returns the directory of mountPoint holding the dataset of
+// s, creating it for the writes of the workload.
+func specDirectory(mountPoint string, s *workload.Spec) (string, error) {
+	dir := filepath.Join(mountPoint, s.Dataset.Prefix)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// ParseReport decodes an fio JSON report, e.g. one collected from a pod's
+// logs, and returns the fio version and the job summaries.
+func ParseReport(b []byte) (string, []JobResult, error) {
+	var out fioOutput
+	if err := json.Unmarshal(b, &out); err != nil {
+		return "", nil, fmt.Errorf("decoding fio output: %w", err)
+	}
+	return out.FioVersion, jobResults(&out), nil
+}
+
+func jobResults(out *fioOutput) []JobResult {
+	var jobs []JobResult
+	for _, j := range out.Jobs {
+		jobs = append(jobs, JobResult{
+			Name:  j.JobName,
+			Error: j.Error,
+			Read:  summarize(j.Read),
+			Write: summarize(j.Write),
+		})
+	}
+	return jobs
+}
+
+// summarize converts fio's per-direction stats into an OpStats, or nil when
+// the job did no I/O in that direction.
+func summarize(s fioOpStats) *OpStats {
+	if s.IOBytes == 0 {
+		return nil
+	}
+	return &OpStats{
+		Bytes:      s.IOBytes,
+		BwKiBps:    s.Bw,
+		Iops:       s.Iops,
+		MeanLatNs:  s.LatNs.Mean,
+		P99ClatNs:  s.ClatNs.Percentile["99.000000"],
+		P999ClatNs: s.ClatNs.Percentile["99.900000"],
+	}
+}
@@ -0,0 +1,411 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// maxLeakRows bounds the samples WriteText prints.
+const maxLeakRows = 24
+
+// LeakWatchConfig describes a leak hunt: a jobfile run over and over on one
+// gcsfuse mount for hours while the memory of its process is sampled.
+type LeakWatchConfig struct {
+	// Base holds the jobfile, mount point, bucket, fio and gcsfuse options.
+	// The bucket is mounted for the run when set; otherwise MountPoint must
+	// already be a gcsfuse mount.
+	Base Config
+	// PID is the gcsfuse process to sample, by default the one serving
+	// Base.MountPoint.
+	PID            int
+	Duration       time.Duration
+	SampleInterval time.Duration
+	// Warmup is left out of the trend, as caches and buffer pools fill up
+	// in the first minutes.
+	Warmup time.Duration
+	// MaxSlopeMiBPerHour is the RSS growth above which the run fails, and
+	// also applies to the heap when it is sampled.
+	MaxSlopeMiBPerHour float64
+	// PprofAddr is the host:port of the net/http/pprof handlers of gcsfuse,
+	// if it serves them; the in-use heap is then sampled too, and a heap
+	// profile saved to ProfileDir every ProfileInterval.
+	PprofAddr       string
+	ProfileDir      string
+	ProfileInterval time.Duration
+}
+
+// Validate reports missing or inconsistent options.
+func (c *LeakWatchConfig) Validate() error {
+	if c.Base.JobFile == "" {
+		return errors.New("--jobfile is required")
+	}
+	if c.Base.MountPoint == "" {
+		return errors.New("--mount-point is required")
+	}
+	if c.Duration <= 0 || c.SampleInterval <= 0 {
+		return errors.New("--duration and --sample-interval must be positive")
+	}
+	if c.Warmup < 0 || c.Warmup >= c.Duration {
+		return errors.New("--warmup must not be negative and must be shorter than --duration")
+	}
+	if c.MaxSlopeMiBPerHour <= 0 {
+		return errors.New("--max-slope must be positive")
+	}
+	if c.ProfileDir != "" && c.PprofAddr == "" {
+		return errors.New("--profile-dir needs --pprof-addr")
+	}
+	if c.ProfileDir != "" && c.ProfileInterval <= 0 {
+		return errors.New("--profile-interval must be positive")
+	}
+	return nil
+}
+
+// MemorySample is the memory of the gcsfuse process at one point of the run.
+type MemorySample struct {
+	Time     time.Time `json:"time"`
+	RSSBytes int64     `json:"rss_bytes"`
+	// HeapBytes is the in-use heap reported by pprof, if sampled.
+	HeapBytes int64 `json:"heap_bytes,omitempty"`
+}
+
+// Trend is a least-squares line through the samples after the warm-up.
+type Trend struct {
+	SlopeMiBPerHour float64 `json:"slope_mib_per_hour"`
+	// R2 is the coefficient of determination: close to 1 for a steady
+	// growth, close to 0 for noise around a plateau.
+	R2 float64 `json:"r2"`
+}
+
+// LeakWatchResult is the outcome of a leak hunt.
+type LeakWatchResult struct {
+	JobFile      string    `json:"jobfile"`
+	MountPoint   string    `json:"mount_point"`
+	Bucket       string    `json:"bucket,omitempty"`
+	GcsfuseFlags []string  `json:"gcsfuse_flags,omitempty"`
+	PID          int       `json:"pid"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	// Iterations counts the completed runs of the jobfile and
+	// FailedIterations the failed ones.
+	Iterations       int            `json:"iterations"`
+	FailedIterations int            `json:"failed_iterations,omitempty"`
+	WarmupSeconds    float64        `json:"warmup_seconds"`
+	Samples          []MemorySample `json:"samples"`
+	RSS              *Trend         `json:"rss,omitempty"`
+	Heap             *Trend         `json:"heap,omitempty"`
+	MaxSlope         float64        `json:"max_slope_mib_per_hour"`
+	// Exited is set when the gcsfuse process went away during the run,
+	// e.g. because it was OOM-killed.
+	Exited   bool                 `json:"exited,omitempty"`
+	Leaking  bool                 `json:"leaking"`
+	Profiles []string             `json:"profiles,omitempty"`
+	Env      *envinfo.Fingerprint `json:"env"`
+}
+
+// RunLeakWatch runs the jobfile on the mount in a loop for cfg.Duration,
+// samples the RSS (and heap) of the gcsfuse process every
+// cfg.SampleInterval, and fits a trend through the samples after the
+// warm-up. The result is Leaking when a slope exceeds cfg.MaxSlopeMiBPerHour
+// or the process exited. Interrupting ctx ends the run early.
+func RunLeakWatch(ctx context.Context, cfg LeakWatchConfig) (res *LeakWatchResult, err error) {
+	if cfg.Base.MountPoint, err = filepath.Abs(cfg.Base.MountPoint); err != nil {
+		return nil, err
+	}
+	cfg.Base.Target = TargetGcsfuse
+	target, err := mountTarget(ctx, cfg.Base)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		defer func() {
+			if uerr := target.Unmount(cfg.Base.MountPoint); uerr != nil {
+				err = errors.Join(err, uerr)
+			}
+		}()
+	}
+	pid := cfg.PID
+	if pid == 0 {
+		if pid = envinfo.GCSFusePID(cfg.Base.MountPoint); pid == 0 {
+			return nil, fmt.Errorf("no gcsfuse process serves %s; pass --pid", cfg.Base.MountPoint)
+		}
+	}
+	if cfg.ProfileDir != "" {
+		if err := os.MkdirAll(cfg.ProfileDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	res = &LeakWatchResult{
+		JobFile: cfg.Base.JobFile, MountPoint: cfg.Base.MountPoint, Bucket: cfg.Base.Bucket, GcsfuseFlags: cfg.Base.GcsfuseFlags,
+		PID: pid, WarmupSeconds: cfg.Warmup.Seconds(), MaxSlope: cfg.MaxSlopeMiBPerHour, StartTime: time.Now(),
+	}
+	res.Env = envinfo.Capture(ctx, envinfo.Options{MountPoint: cfg.Base.MountPoint, GcsfuseBinary: cfg.Base.GcsfuseBinary})
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The workload stops when the process it loads is gone.
+		defer cancel()
+		sampleMemory(runCtx, cfg, res)
+	}()
+
+	slog.Info("Running the workload in a loop", "jobfile", cfg.Base.JobFile, "duration", cfg.Duration, "pid", pid)
+	for runCtx.Err() == nil {
+		if _, err := runFio(runCtx, cfg.Base.FioBinary, cfg.Base.JobFile, cfg.Base.MountPoint); err != nil {
+			if runCtx.Err() != nil {
+				break
+			}
+			res.FailedIterations++
+			slog.Warn("Workload iteration failed", "iteration", res.Iterations+res.FailedIterations, "err", err)
+			// Don't spin on a broken mount.
+			sleepCtx(runCtx, cfg.SampleInterval)
+			continue
+		}
+		res.Iterations++
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		slog.Warn("Interrupted, fitting the samples taken so far")
+	}
+	res.EndTime = time.Now()
+
+	warm := res.StartTime.Add(cfg.Warmup)
+	res.RSS = fitTrend(res.Samples, warm, func(s MemorySample) int64 { return s.RSSBytes })
+	if cfg.PprofAddr != "" {
+		res.Heap = fitTrend(res.Samples, warm, func(s MemorySample) int64 { return s.HeapBytes })
+	}
+	res.Leaking = res.Exited
+	for _, t := range []*Trend{res.RSS, res.Heap} {
+		if t != nil && t.SlopeMiBPerHour > cfg.MaxSlopeMiBPerHour {
+			res.Leaking = true
+		}
+	}
+	return res, nil
+}
+
+// sampleMemory appends a sample to res every cfg.SampleInterval, and saves
+// a heap profile every cfg.ProfileInterval, until ctx is done or the process
+// exits.
+func sampleMemory(ctx context.Context, cfg LeakWatchConfig, res *LeakWatchResult) {
+	ticker := time.NewTicker(cfg.SampleInterval)
+	defer ticker.Stop()
+	var lastProfile time.Time
+	for {
+		now := time.Now()
+		rss, err := processRSS(res.PID)
+		if err != nil {
+			slog.Warn("gcsfuse process is gone", "pid", res.PID, "err", err)
+			res.Exited = true
+			return
+		}
+		s := MemorySample{Time: now, RSSBytes: rss}
+		if cfg.PprofAddr != "" {
+			if s.HeapBytes, err = heapInuse(ctx, cfg.PprofAddr); err != nil {
+				slog.Warn("Sampling the heap failed", "err", err)
+			}
+		}
+		res.Samples = append(res.Samples, s)
+		if cfg.ProfileDir != "" && now.Sub(lastProfile) >= cfg.ProfileInterval {
+			name := filepath.Join(cfg.ProfileDir, fmt.Sprintf("heap-%06.0fs.pb.gz", now.Sub(res.StartTime).Seconds()))
+			if err := saveHeapProfile(ctx, cfg.PprofAddr, name); err != nil {
+				slog.Warn("Saving a heap profile failed", "err", err)
+			} else {
+				res.Profiles = append(res.Profiles, name)
+				lastProfile = now
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processRSS returns the resident set size of pid from /proc.
+func processRSS(pid int) (int64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "VmRSS:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb << 10, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	// Zombies have no VmRSS.
+	return 0, fmt.Errorf("process %d has no memory", pid)
+}
+
+// heapInuse returns the HeapInuse of the runtime.MemStats that the
+// debug=1 heap profile at addr ends with.
+func heapInuse(ctx context.Context, addr string) (int64, error) {
+	body, err := getPprof(ctx, addr, "debug=1")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	s := bufio.NewScanner(body)
+	s.Buffer(make([]byte, 1<<20), 16<<20)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "# HeapInuse = "); ok {
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no HeapInuse in the heap profile")
+}
+
+// saveHeapProfile writes the heap profile at addr to name, for
+// go tool pprof -base.
+func saveHeapProfile(ctx context.Context, addr, name string) error {
+	body, err := getPprof(ctx, addr, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func getPprof(ctx context.Context, addr, query string) (io.ReadCloser, error) {
+	url := "http://" + addr + "/debug/pprof/heap"
+	if query != "" {
+		url += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fitTrend fits a line through the values of the samples taken after warm,
+// skipping zeros, or returns nil with fewer than 3 of them.
+func fitTrend(samples []MemorySample, warm time.Time, value func(MemorySample) int64) *Trend {
+	var xs, ys []float64
+	for _, s := range samples {
+		if s.Time.Before(warm) || value(s) == 0 {
+			continue
+		}
+		xs = append(xs, s.Time.Sub(warm).Hours())
+		ys = append(ys, float64(value(s))/(1<<20))
+	}
+	if len(xs) < 3 {
+		return nil
+	}
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy, syy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+		syy += ys[i] * ys[i]
+	}
+	varX, varY, cov := sxx-sx*sx/n, syy-sy*sy/n, sxy-sx*sy/n
+	if varX == 0 {
+		return nil
+	}
+	t := &Trend{SlopeMiBPerHour: cov / varX}
+	if varY > 0 {
+		t.R2 = cov * cov / (varX * varY)
+	}
+	return t
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// WriteText prints the trends, the verdict and up to maxLeakRows of the
+// samples.
+func (r *LeakWatchResult) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "gcsfuse pid %d on %s, %d iterations of %s (%d failed) in %s\n\n", r.PID, r.MountPoint,
+		r.Iterations, r.JobFile, r.FailedIterations, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ELAPSED\tRSS MiB\tHEAP MiB")
+	step := int(math.Ceil(float64(len(r.Samples)) / maxLeakRows))
+	for i, s := range r.Samples {
+		if i%max(step, 1) != 0 && i != len(r.Samples)-1 {
+			continue
+		}
+		heap := "-"
+		if s.HeapBytes > 0 {
+			heap = fmt.Sprintf("%.1f", float64(s.HeapBytes)/(1<<20))
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%s\n", s.Time.Sub(r.StartTime).Round(time.Second), float64(s.RSSBytes)/(1<<20), heap)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	for _, t := range []struct {
+		name  string
+		trend *Trend
+	}{{"RSS", r.RSS}, {"Heap", r.Heap}} {
+		if t.trend != nil {
+			fmt.Fprintf(w, "%s trend after %s of warm-up: %+.2f MiB/h (R² %.2f), limit %.2f MiB/h\n", t.name,
+				time.Duration(r.WarmupSeconds*float64(time.Second)), t.trend.SlopeMiBPerHour, t.trend.R2, r.MaxSlope)
+		}
+	}
+	if r.RSS == nil {
+		fmt.Fprintln(w, "Too few samples after the warm-up to fit a trend.")
+	}
+	if r.Exited {
+		fmt.Fprintln(w, "The gcsfuse process exited during the run.")
+	}
+	for _, p := range r.Profiles {
+		fmt.Fprintf(w, "Heap profile: %s\n", p)
+	}
+	verdict := "no leak"
+	if r.Leaking {
+		verdict = "LEAKING"
+	}
+	_, err := fmt.Fprintf(w, "Verdict: %s\n", verdict)
+	return err
+}
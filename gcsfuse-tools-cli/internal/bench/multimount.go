@@ -0,0 +1,287 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// clockTicks is the USER_HZ unit of the CPU times in /proc.
+const clockTicks = 100
+
+// MultiMountConfig describes an interference run: the same fio jobfile on
+// several gcsfuse mounts of one VM at the same time.
+type MultiMountConfig struct {
+	// Base holds the jobfile, fio, gcsfuse and seed options. Its MountPoint
+	// is the parent of the mount-<i> directories, and its Bucket is mounted
+	// Mounts times when Buckets is empty.
+	Base   Config
+	Mounts int
+	// Buckets mounts one bucket per mount instead.
+	Buckets []string
+	// Solo runs the jobfile on every mount alone before the concurrent run,
+	// as the baseline the interference is measured against.
+	Solo bool
+}
+
+// Validate reports missing or inconsistent options.
+func (c *MultiMountConfig) Validate() error {
+	if c.Base.JobFile == "" {
+		return errors.New("--jobfile is required")
+	}
+	if c.Base.MountPoint == "" {
+		return errors.New("--mount-point is required")
+	}
+	switch {
+	case len(c.Buckets) > 0 && c.Base.Bucket != "":
+		return errors.New("--bucket and --buckets are mutually exclusive")
+	case len(c.Buckets) > 0:
+		c.Mounts = len(c.Buckets)
+	case c.Base.Bucket == "":
+		return errors.New("--bucket or --buckets is required")
+	}
+	if c.Mounts < 2 {
+		return errors.New("--mounts must be at least 2")
+	}
+	return nil
+}
+
+func (c *MultiMountConfig) bucket(i int) string {
+	if len(c.Buckets) > 0 {
+		return c.Buckets[i]
+	}
+	return c.Base.Bucket
+}
+
+// Phase is the outcome of the jobfile on one mount in one phase.
+type Phase struct {
+	Jobs       []JobResult `json:"jobs"`
+	ReadMiBps  float64     `json:"read_mib_per_sec"`
+	WriteMiBps float64     `json:"write_mib_per_sec"`
+	Seconds    float64     `json:"seconds"`
+	// CPUCores is the CPU time of the mount's gcsfuse process divided by the
+	// phase's duration.
+	CPUCores float64 `json:"cpu_cores"`
+}
+
+// MiBps is the combined read and write throughput.
+func (p *Phase) MiBps() float64 { return p.ReadMiBps + p.WriteMiBps }
+
+// MountResult is one mount of an interference run.
+type MountResult struct {
+	Index      int    `json:"index"`
+	Bucket     string `json:"bucket"`
+	MountPoint string `json:"mount_point"`
+	PID        int    `json:"pid,omitempty"`
+	Solo       *Phase `json:"solo,omitempty"`
+	Concurrent *Phase `json:"concurrent"`
+	// Retained is the concurrent throughput as a fraction of the solo one.
+	Retained float64 `json:"retained,omitempty"`
+}
+
+// MultiMountResult is the outcome of an interference run.
+type MultiMountResult struct {
+	JobFile      string        `json:"jobfile"`
+	GcsfuseFlags []string      `json:"gcsfuse_flags,omitempty"`
+	Seed         uint64        `json:"seed,omitempty"`
+	FioVersion   string        `json:"fio_version"`
+	StartTime    time.Time     `json:"start_time"`
+	EndTime      time.Time     `json:"end_time"`
+	Mounts       []MountResult `json:"mounts"`
+	// SoloHostCPU and ConcurrentHostCPU are the busy fraction of all CPUs of
+	// the host during the solo runs and the concurrent run.
+	SoloHostCPU       float64              `json:"solo_host_cpu,omitempty"`
+	ConcurrentHostCPU float64              `json:"concurrent_host_cpu"`
+	Env               *envinfo.Fingerprint `json:"env"`
+}
+
+// RunMultiMount mounts the buckets, runs the jobfile on every mount alone if
+// cfg.Solo is set, then on all mounts at once, and reports how much
+// throughput each mount kept and the CPU its gcsfuse process used.
+func RunMultiMount(ctx context.Context, cfg MultiMountConfig) (res *MultiMountResult, err error) {
+	jobFile := cfg.Base.JobFile
+	if cfg.Base.Seed != 0 {
+		if jobFile, err = seededJobFile(cfg.Base.JobFile, cfg.Base.Seed); err != nil {
+			return nil, err
+		}
+		defer os.Remove(jobFile)
+	}
+
+	res = &MultiMountResult{
+		JobFile: cfg.Base.JobFile, GcsfuseFlags: cfg.Base.GcsfuseFlags, Seed: cfg.Base.Seed,
+		StartTime: time.Now(),
+	}
+	for i := 0; i < cfg.Mounts; i++ {
+		mp, err := filepath.Abs(filepath.Join(cfg.Base.MountPoint, fmt.Sprintf("mount-%d", i)))
+		if err != nil {
+			return nil, err
+		}
+		t := &gcsfuseTarget{binary: cfg.Base.GcsfuseBinary, bucket: cfg.bucket(i), flags: cfg.Base.GcsfuseFlags}
+		if err := t.Mount(ctx, mp); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if uerr := t.Unmount(mp); uerr != nil {
+				err = errors.Join(err, uerr)
+			}
+		}()
+		res.Mounts = append(res.Mounts, MountResult{Index: i, Bucket: cfg.bucket(i), MountPoint: mp, PID: envinfo.GCSFusePID(mp)})
+	}
+	res.Env = envinfo.Capture(ctx, envinfo.Options{GcsfuseBinary: cfg.Base.GcsfuseBinary})
+
+	if cfg.Solo {
+		busy, total := hostCPU()
+		for i := range res.Mounts {
+			m := &res.Mounts[i]
+			slog.Info("Running fio alone", "mount", m.Index, "mount_point", m.MountPoint)
+			if m.Solo, err = runPhase(ctx, cfg.Base.FioBinary, jobFile, m, &res.FioVersion); err != nil {
+				return nil, err
+			}
+		}
+		res.SoloHostCPU = hostCPUSince(busy, total)
+	}
+
+	slog.Info("Running fio on all mounts at once", "mounts", len(res.Mounts))
+	busy, total := hostCPU()
+	var wg sync.WaitGroup
+	errs := make([]error, len(res.Mounts))
+	versions := make([]string, len(res.Mounts))
+	for i := range res.Mounts {
+		wg.Add(1)
+		go func(m *MountResult) {
+			defer wg.Done()
+			m.Concurrent, errs[m.Index] = runPhase(ctx, cfg.Base.FioBinary, jobFile, m, &versions[m.Index])
+		}(&res.Mounts[i])
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	res.FioVersion = versions[0]
+	res.ConcurrentHostCPU = hostCPUSince(busy, total)
+	for i := range res.Mounts {
+		m := &res.Mounts[i]
+		if m.Solo != nil && m.Solo.MiBps() > 0 {
+			m.Retained = m.Concurrent.MiBps() / m.Solo.MiBps()
+		}
+	}
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// runPhase runs fio on m and measures the CPU time of its gcsfuse process.
+func runPhase(ctx context.Context, fioBinary, jobFile string, m *MountResult, fioVersion *string) (*Phase, error) {
+	p, err := measurePhase(ctx, fioBinary, jobFile, m.MountPoint, m.PID, fioVersion)
+	if err != nil {
+		return nil, fmt.Errorf("mount %d: %w", m.Index, err)
+	}
+	return p, nil
+}
+
+// measurePhase runs fio in dir and measures the CPU time of the gcsfuse
+// process pid, unless it is 0.
+func measurePhase(ctx context.Context, fioBinary, jobFile, dir string, pid int, fioVersion *string) (*Phase, error) {
+	cpu0 := processCPU(pid)
+	start := time.Now()
+	out, err := runFio(ctx, fioBinary, jobFile, dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Phase{Jobs: jobResults(out), Seconds: time.Since(start).Seconds()}
+	if pid != 0 {
+		p.CPUCores = (processCPU(pid) - cpu0) / p.Seconds
+	}
+	for _, j := range p.Jobs {
+		if j.Read != nil {
+			p.ReadMiBps += j.Read.BwKiBps / 1024
+		}
+		if j.Write != nil {
+			p.WriteMiBps += j.Write.BwKiBps / 1024
+		}
+	}
+	*fioVersion = out.FioVersion
+	return p, nil
+}
+
+// processCPU returns the user and system CPU seconds of pid, or 0.
+func processCPU(pid int) float64 {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// The command name may contain spaces; the fields after it don't.
+	fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:]))
+	if len(fields) < 13 {
+		return 0
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	return (utime + stime) / clockTicks
+}
+
+// hostCPU returns the busy and total jiffies of all CPUs from /proc/stat.
+func hostCPU() (busy, total float64) {
+	b, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0
+	}
+	line, _, _ := strings.Cut(string(b), "\n")
+	for i, f := range strings.Fields(line)[1:] {
+		v, _ := strconv.ParseFloat(f, 64)
+		total += v
+		// idle and iowait.
+		if i != 3 && i != 4 {
+			busy += v
+		}
+	}
+	return busy, total
+}
+
+func hostCPUSince(busy0, total0 float64) float64 {
+	busy, total := hostCPU()
+	if total <= total0 {
+		return 0
+	}
+	return (busy - busy0) / (total - total0)
+}
+
+// WriteText prints one row per mount and the totals.
+func (r *MultiMountResult) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "fio %s on %d gcsfuse mounts (%s)\n\n", r.FioVersion, len(r.Mounts), r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MOUNT\tBUCKET\tSOLO MiB/s\tCONCURRENT MiB/s\tRETAINED\tSOLO CPU (cores)\tCONCURRENT CPU (cores)")
+	var solo, conc float64
+	for _, m := range r.Mounts {
+		soloBw, soloCPU, retained := "-", "-", "-"
+		if m.Solo != nil {
+			solo += m.Solo.MiBps()
+			soloBw = fmt.Sprintf("%.1f", m.Solo.MiBps())
+			soloCPU = fmt.Sprintf("%.2f", m.Solo.CPUCores)
+			retained = fmt.Sprintf("%.0f%%", m.Retained*100)
+		}
+		conc += m.Concurrent.MiBps()
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%.1f\t%s\t%s\t%.2f\n", m.Index, m.Bucket, soloBw, m.Concurrent.MiBps(), retained, soloCPU, m.Concurrent.CPUCores)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nConcurrent total: %.1f MiB/s, host CPU %.0f%% busy.\n", conc, r.ConcurrentHostCPU*100)
+	if solo > 0 {
+		_, err := fmt.Fprintf(w, "Sum of solo runs: %.1f MiB/s (%.0f%% retained together), host CPU %.0f%% busy.\n",
+			solo, conc/solo*100, r.SoloHostCPU*100)
+		return err
+	}
+	return nil
+}
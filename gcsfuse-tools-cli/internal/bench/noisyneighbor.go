@@ -0,0 +1,405 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Where the noise of a noisy-neighbor run comes from.
+const (
+	// NoiseMount runs the noise jobfile on the measured mount, sharing its
+	// gcsfuse process.
+	NoiseMount = "mount"
+	// NoiseVM runs the noise jobfile on a second gcsfuse mount of this VM,
+	// sharing its CPUs and NIC.
+	NoiseVM = "vm"
+	// NoiseCommand runs a shell command instead, e.g. one loading the bucket
+	// from another VM over ssh, so that only the bucket is shared.
+	NoiseCommand = "command"
+)
+
+// NoisyNeighborConfig describes a fairness run: an fio jobfile alone and
+// again while an aggressive workload runs next to it.
+type NoisyNeighborConfig struct {
+	// Base holds the measured jobfile, bucket, fio, gcsfuse flags and seed
+	// options. Its MountPoint is the parent of the measured and noise mount
+	// points.
+	Base Config
+	// GcsfuseBinaries are the gcsfuse versions compared, each measured on
+	// its own mount.
+	GcsfuseBinaries []string
+	// Scope is NoiseMount, NoiseVM or NoiseCommand.
+	Scope        string
+	NoiseJobFile string
+	// NoiseBucket is the bucket of the NoiseVM mount, Base.Bucket if empty.
+	NoiseBucket  string
+	NoiseCommand string
+	// RampUp is how long the noise runs before the contended pass starts.
+	RampUp time.Duration
+}
+
+// Validate reports missing or inconsistent options.
+func (c *NoisyNeighborConfig) Validate() error {
+	if c.Base.JobFile == "" {
+		return errors.New("--jobfile is required")
+	}
+	if c.Base.MountPoint == "" {
+		return errors.New("--mount-point is required")
+	}
+	if c.Base.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if len(c.GcsfuseBinaries) == 0 {
+		return errors.New("--gcsfuse-binary is required")
+	}
+	switch c.Scope {
+	case NoiseMount, NoiseVM:
+		if c.NoiseJobFile == "" {
+			return fmt.Errorf("--noise-jobfile is required with --noise-scope=%s", c.Scope)
+		}
+		if c.NoiseCommand != "" {
+			return fmt.Errorf("--noise-command needs --noise-scope=%s", NoiseCommand)
+		}
+	case NoiseCommand:
+		if c.NoiseCommand == "" {
+			return fmt.Errorf("--noise-command is required with --noise-scope=%s", NoiseCommand)
+		}
+		if c.NoiseJobFile != "" {
+			return fmt.Errorf("--noise-jobfile does not apply to --noise-scope=%s", NoiseCommand)
+		}
+	default:
+		return fmt.Errorf("unsupported --noise-scope %q (want %s, %s or %s)", c.Scope, NoiseMount, NoiseVM, NoiseCommand)
+	}
+	if c.NoiseBucket != "" && c.Scope != NoiseVM {
+		return fmt.Errorf("--noise-bucket needs --noise-scope=%s", NoiseVM)
+	}
+	if c.RampUp < 0 {
+		return errors.New("--ramp-up must not be negative")
+	}
+	return nil
+}
+
+func (c *NoisyNeighborConfig) noiseBucket() string {
+	if c.NoiseBucket != "" {
+		return c.NoiseBucket
+	}
+	return c.Base.Bucket
+}
+
+// LatencyInflation compares the latency of one direction of a job without
+// and with noise.
+type LatencyInflation struct {
+	Job            string  `json:"job"`
+	Op             string  `json:"op"`
+	QuietMeanLatNs float64 `json:"quiet_mean_lat_ns"`
+	NoisyMeanLatNs float64 `json:"noisy_mean_lat_ns"`
+	QuietP99ClatNs float64 `json:"quiet_p99_clat_ns"`
+	NoisyP99ClatNs float64 `json:"noisy_p99_clat_ns"`
+	// MeanInflation and P99Inflation are the noisy latencies as a multiple
+	// of the quiet ones.
+	MeanInflation float64 `json:"mean_inflation,omitempty"`
+	P99Inflation  float64 `json:"p99_inflation,omitempty"`
+}
+
+// NoisyNeighborRun is the outcome of one gcsfuse version.
+type NoisyNeighborRun struct {
+	GcsfuseBinary  string `json:"gcsfuse_binary"`
+	GcsfuseVersion string `json:"gcsfuse_version,omitempty"`
+	// Quiet is the jobfile alone and Noisy the jobfile next to the noise.
+	Quiet *Phase `json:"quiet"`
+	Noisy *Phase `json:"noisy"`
+	// Retained is the noisy throughput as a fraction of the quiet one.
+	Retained float64            `json:"retained,omitempty"`
+	Latency  []LatencyInflation `json:"latency"`
+	// NoiseRuns are the noise jobfile or command runs that completed while
+	// the noise was on, and NoiseMiBps the throughput of the noise jobfile
+	// over them.
+	NoiseRuns  int     `json:"noise_runs"`
+	NoiseMiBps float64 `json:"noise_mib_per_sec,omitempty"`
+	// Share is the measured workload's fraction of the throughput of both
+	// workloads under contention; 0.5 is a fair split.
+	Share float64 `json:"share,omitempty"`
+}
+
+// NoisyNeighborResult is the outcome of a fairness run.
+type NoisyNeighborResult struct {
+	JobFile       string             `json:"jobfile"`
+	Bucket        string             `json:"bucket"`
+	GcsfuseFlags  []string           `json:"gcsfuse_flags,omitempty"`
+	Seed          uint64             `json:"seed,omitempty"`
+	Scope         string             `json:"noise_scope"`
+	NoiseJobFile  string             `json:"noise_jobfile,omitempty"`
+	NoiseBucket   string             `json:"noise_bucket,omitempty"`
+	NoiseCommand  string             `json:"noise_command,omitempty"`
+	RampUpSeconds float64            `json:"ramp_up_seconds"`
+	FioVersion    string             `json:"fio_version"`
+	StartTime     time.Time          `json:"start_time"`
+	EndTime       time.Time          `json:"end_time"`
+	Runs          []NoisyNeighborRun `json:"runs"`
+	// Env is the fingerprint of the host and the last version's mount.
+	Env *envinfo.Fingerprint `json:"env"`
+}
+
+// RunNoisyNeighbor measures the jobfile with every gcsfuse binary in turn:
+// it mounts the bucket, runs the jobfile alone, starts the noise, waits
+// cfg.RampUp, runs the jobfile again and stops the noise. It reports the
+// throughput the jobfile retained, how much its latencies inflated and its
+// share of the combined throughput.
+func RunNoisyNeighbor(ctx context.Context, cfg NoisyNeighborConfig) (res *NoisyNeighborResult, err error) {
+	if cfg.Base.MountPoint, err = filepath.Abs(cfg.Base.MountPoint); err != nil {
+		return nil, err
+	}
+	jobFile := cfg.Base.JobFile
+	if cfg.Base.Seed != 0 {
+		if jobFile, err = seededJobFile(cfg.Base.JobFile, cfg.Base.Seed); err != nil {
+			return nil, err
+		}
+		defer os.Remove(jobFile)
+	}
+
+	res = &NoisyNeighborResult{
+		JobFile: cfg.Base.JobFile, Bucket: cfg.Base.Bucket, GcsfuseFlags: cfg.Base.GcsfuseFlags, Seed: cfg.Base.Seed,
+		Scope: cfg.Scope, NoiseJobFile: cfg.NoiseJobFile, NoiseCommand: cfg.NoiseCommand, RampUpSeconds: cfg.RampUp.Seconds(),
+		StartTime: time.Now(),
+	}
+	if cfg.Scope == NoiseVM {
+		res.NoiseBucket = cfg.noiseBucket()
+	}
+	for _, bin := range cfg.GcsfuseBinaries {
+		run, env, err := runNoisyNeighborVersion(ctx, cfg, bin, jobFile, &res.FioVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", bin, err)
+		}
+		res.Runs = append(res.Runs, *run)
+		res.Env = env
+	}
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// runNoisyNeighborVersion runs the quiet and the noisy pass with the gcsfuse
+// binary bin and returns them with the environment of its mount.
+func runNoisyNeighborVersion(ctx context.Context, cfg NoisyNeighborConfig, bin, jobFile string, fioVersion *string) (run *NoisyNeighborRun, env *envinfo.Fingerprint, err error) {
+	mount := func(bucket, name string) (string, error) {
+		mp := filepath.Join(cfg.Base.MountPoint, name)
+		t := &gcsfuseTarget{binary: bin, bucket: bucket, flags: cfg.Base.GcsfuseFlags}
+		if err := t.Mount(ctx, mp); err != nil {
+			return "", err
+		}
+		return mp, nil
+	}
+	unmount := func(mp string) {
+		if uerr := fuseUnmount(mp); uerr != nil {
+			err = errors.Join(err, uerr)
+		}
+	}
+
+	mp, err := mount(cfg.Base.Bucket, "measured")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unmount(mp)
+	noiseDir := mp
+	if cfg.Scope == NoiseVM {
+		if noiseDir, err = mount(cfg.noiseBucket(), "noise"); err != nil {
+			return nil, nil, err
+		}
+		defer unmount(noiseDir)
+	}
+	pid := envinfo.GCSFusePID(mp)
+
+	run = &NoisyNeighborRun{GcsfuseBinary: bin}
+	slog.Info("Running fio alone", "gcsfuse", bin, "jobfile", cfg.Base.JobFile)
+	if run.Quiet, err = measurePhase(ctx, cfg.Base.FioBinary, jobFile, mp, pid, fioVersion); err != nil {
+		return nil, nil, fmt.Errorf("quiet pass: %w", err)
+	}
+
+	slog.Info("Starting the noise", "scope", cfg.Scope, "ramp_up", cfg.RampUp)
+	n := startNoise(ctx, cfg, noiseDir)
+	select {
+	case <-ctx.Done():
+	case <-n.done:
+	case <-time.After(cfg.RampUp):
+	}
+	slog.Info("Running fio next to the noise", "gcsfuse", bin, "jobfile", cfg.Base.JobFile)
+	run.Noisy, err = measurePhase(ctx, cfg.Base.FioBinary, jobFile, mp, pid, fioVersion)
+	if nerr := n.stop(); nerr != nil {
+		return nil, nil, fmt.Errorf("noise: %w", nerr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("noisy pass: %w", err)
+	}
+
+	run.NoiseRuns = n.runs
+	if n.seconds > 0 {
+		run.NoiseMiBps = float64(n.bytes) / n.seconds / (1 << 20)
+	}
+	if run.Quiet.MiBps() > 0 {
+		run.Retained = run.Noisy.MiBps() / run.Quiet.MiBps()
+	}
+	if total := run.Noisy.MiBps() + run.NoiseMiBps; run.NoiseMiBps > 0 && total > 0 {
+		run.Share = run.Noisy.MiBps() / total
+	}
+	run.Latency = latencyInflation(run.Quiet, run.Noisy)
+	env = envinfo.Capture(ctx, envinfo.Options{MountPoint: mp, GcsfuseBinary: bin})
+	run.GcsfuseVersion = env.GcsfuseVersion
+	return run, env, nil
+}
+
+// noise runs the noise jobfile or command over and over until stopped.
+type noise struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// runs, bytes and seconds add up the runs that completed; a run cut
+	// short by stop is not counted.
+	runs    int
+	bytes   int64
+	seconds float64
+	err     error
+}
+
+// startNoise starts the noise of cfg in dir in the background.
+func startNoise(ctx context.Context, cfg NoisyNeighborConfig, dir string) *noise {
+	ctx, cancel := context.WithCancel(ctx)
+	n := &noise{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(n.done)
+		for ctx.Err() == nil {
+			start := time.Now()
+			var bytes int64
+			var err error
+			if cfg.Scope == NoiseCommand {
+				err = runNoiseCommand(ctx, cfg.NoiseCommand)
+			} else {
+				var out *fioOutput
+				if out, err = runFio(ctx, cfg.Base.FioBinary, cfg.NoiseJobFile, dir); err == nil {
+					for _, j := range out.Jobs {
+						bytes += j.Read.IOBytes + j.Write.IOBytes
+					}
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				n.err = err
+				return
+			}
+			n.runs++
+			n.bytes += bytes
+			n.seconds += time.Since(start).Seconds()
+		}
+	}()
+	return n
+}
+
+// stop stops the noise and returns the error that ended it early, if any.
+func (n *noise) stop() error {
+	n.cancel()
+	<-n.done
+	return n.err
+}
+
+func runNoiseCommand(ctx context.Context, command string) error {
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %q: %w: %s", command, err, stderr.String())
+	}
+	return nil
+}
+
+// latencyInflation pairs the jobs of the quiet and the noisy pass by name.
+func latencyInflation(quiet, noisy *Phase) []LatencyInflation {
+	var out []LatencyInflation
+	for _, q := range quiet.Jobs {
+		for _, n := range noisy.Jobs {
+			if n.Name != q.Name {
+				continue
+			}
+			for _, op := range []struct {
+				name         string
+				quiet, noisy *OpStats
+			}{{"read", q.Read, n.Read}, {"write", q.Write, n.Write}} {
+				if op.quiet == nil || op.noisy == nil {
+					continue
+				}
+				l := LatencyInflation{
+					Job: q.Name, Op: op.name,
+					QuietMeanLatNs: op.quiet.MeanLatNs, NoisyMeanLatNs: op.noisy.MeanLatNs,
+					QuietP99ClatNs: op.quiet.P99ClatNs, NoisyP99ClatNs: op.noisy.P99ClatNs,
+				}
+				if l.QuietMeanLatNs > 0 {
+					l.MeanInflation = l.NoisyMeanLatNs / l.QuietMeanLatNs
+				}
+				if l.QuietP99ClatNs > 0 {
+					l.P99Inflation = l.NoisyP99ClatNs / l.QuietP99ClatNs
+				}
+				out = append(out, l)
+			}
+			break
+		}
+	}
+	return out
+}
+
+// WriteText prints the throughput of every version without and with noise,
+// then the latency inflation of every job.
+func (r *NoisyNeighborResult) WriteText(w io.Writer) error {
+	var noise string
+	switch r.Scope {
+	case NoiseMount:
+		noise = fmt.Sprintf("%s on the same mount", r.NoiseJobFile)
+	case NoiseVM:
+		noise = fmt.Sprintf("%s on a second mount of gs://%s", r.NoiseJobFile, r.NoiseBucket)
+	default:
+		noise = fmt.Sprintf("%q", r.NoiseCommand)
+	}
+	fmt.Fprintf(w, "fio %s on gs://%s next to %s (%s)\n\n", r.FioVersion, r.Bucket, noise, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GCSFUSE\tQUIET MiB/s\tNOISY MiB/s\tRETAINED\tNOISE MiB/s\tSHARE\tNOISY CPU (cores)")
+	for _, run := range r.Runs {
+		noiseBw, share := "-", "-"
+		if run.NoiseMiBps > 0 {
+			noiseBw = fmt.Sprintf("%.1f", run.NoiseMiBps)
+			share = fmt.Sprintf("%.0f%%", run.Share*100)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.0f%%\t%s\t%s\t%.2f\n", run.label(), run.Quiet.MiBps(), run.Noisy.MiBps(),
+			run.Retained*100, noiseBw, share, run.Noisy.CPUCores)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GCSFUSE\tJOB\tOP\tQUIET MEAN (ms)\tNOISY MEAN (ms)\tINFLATION\tQUIET P99 (ms)\tNOISY P99 (ms)\tINFLATION")
+	for _, run := range r.Runs {
+		for _, l := range run.Latency {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%.2f\t%.2fx\t%.2f\t%.2f\t%.2fx\n", run.label(), l.Job, l.Op,
+				l.QuietMeanLatNs/1e6, l.NoisyMeanLatNs/1e6, l.MeanInflation, l.QuietP99ClatNs/1e6, l.NoisyP99ClatNs/1e6, l.P99Inflation)
+		}
+	}
+	return tw.Flush()
+}
+
+// label names the run by its gcsfuse version, e.g. 2.4.0 of "gcsfuse
+// version 2.4.0 (Go version go1.22.4)", or its binary if unknown.
+func (r *NoisyNeighborRun) label() string {
+	if f := strings.Fields(r.GcsfuseVersion); len(f) >= 3 && f[1] == "version" {
+		return f[2]
+	}
+	return r.GcsfuseBinary
+}
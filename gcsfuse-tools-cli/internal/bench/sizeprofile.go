@@ -0,0 +1,256 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Operations of a size profile.
+const (
+	ProfileRead  = "read"
+	ProfileWrite = "write"
+)
+
+// SizeProfileConfig describes a sweep of file sizes through a mount. The
+// JobFile of Base is unused; every point runs a generated jobfile.
+type SizeProfileConfig struct {
+	Base Config
+	// MinSize and MaxSize bound the sweep, which multiplies the size by
+	// Step from MinSize and always ends with MaxSize.
+	MinSize, MaxSize int64
+	Step             int64
+	// Ops are the operations run at every size, in order. Writes create
+	// the files the reads of the same size then read back.
+	Ops []string
+	// BytesPerSize is the data each operation moves at every size, split
+	// in files of that size, at least one and at most MaxFiles per thread.
+	BytesPerSize int64
+	MaxFiles     int
+	Threads      int
+	// BlockSize is the largest I/O size; smaller files are read and
+	// written in one I/O.
+	BlockSize int64
+}
+
+// Validate reports missing or out-of-range options.
+func (c *SizeProfileConfig) Validate() error {
+	if c.Base.MountPoint == "" {
+		return errors.New("--mount-point is required")
+	}
+	if c.MinSize <= 0 || c.MaxSize < c.MinSize {
+		return errors.New("--min-size must be greater than 0 and at most --max-size")
+	}
+	if c.Step < 2 {
+		return errors.New("--step must be at least 2")
+	}
+	if len(c.Ops) == 0 {
+		return errors.New("--ops must not be empty")
+	}
+	for _, op := range c.Ops {
+		if op != ProfileRead && op != ProfileWrite {
+			return fmt.Errorf("unsupported --ops entry %q (want read or write)", op)
+		}
+	}
+	if c.BytesPerSize <= 0 || c.MaxFiles <= 0 || c.Threads <= 0 || c.BlockSize <= 0 {
+		return errors.New("--bytes-per-size, --max-files, --threads and --block-size must be greater than 0")
+	}
+	if c.Base.Target == "" {
+		c.Base.Target = TargetGcsfuse
+	}
+	return nil
+}
+
+// Sizes returns the file sizes of the sweep.
+func (c *SizeProfileConfig) Sizes() []int64 {
+	var sizes []int64
+	for s := c.MinSize; s < c.MaxSize; s *= c.Step {
+		sizes = append(sizes, s)
+	}
+	return append(sizes, c.MaxSize)
+}
+
+// files returns the number of files per thread of size bytes.
+func (c *SizeProfileConfig) files(size int64) int {
+	n := c.BytesPerSize / (size * int64(c.Threads))
+	return int(max(1, min(n, int64(c.MaxFiles))))
+}
+
+// SizePoint is the outcome of one operation at one file size.
+type SizePoint struct {
+	Size  int64    `json:"size"`
+	Op    string   `json:"op"`
+	Files int      `json:"files"`
+	Stats *OpStats `json:"stats"`
+	// Seconds is the wall time of the fio run, including opens and
+	// closes, and FilesPerSec the files completed per second.
+	Seconds     float64 `json:"seconds"`
+	FilesPerSec float64 `json:"files_per_sec"`
+}
+
+// SizeProfileResult is the latency and throughput curve of a mount over
+// file sizes.
+type SizeProfileResult struct {
+	MountPoint     string               `json:"mount_point"`
+	Target         string               `json:"target"`
+	Bucket         string               `json:"bucket,omitempty"`
+	GcsfuseFlags   []string             `json:"gcsfuse_flags,omitempty"`
+	GcsfuseVersion string               `json:"gcsfuse_version,omitempty"`
+	FioVersion     string               `json:"fio_version"`
+	Threads        int                  `json:"threads"`
+	StartTime      time.Time            `json:"start_time"`
+	EndTime        time.Time            `json:"end_time"`
+	Points         []SizePoint          `json:"points"`
+	Env            *envinfo.Fingerprint `json:"env"`
+}
+
+// RunSizeProfile mounts the target if one is configured and runs every
+// operation at every size of the sweep, deleting the files of a size before
+// moving to the next.
+func RunSizeProfile(ctx context.Context, cfg SizeProfileConfig) (res *SizeProfileResult, err error) {
+	target, err := mountTarget(ctx, cfg.Base)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		defer func() {
+			if uerr := target.Unmount(cfg.Base.MountPoint); uerr != nil {
+				err = errors.Join(err, uerr)
+			}
+		}()
+	}
+
+	res = &SizeProfileResult{
+		MountPoint: cfg.Base.MountPoint,
+		Target:     cfg.Base.Target,
+		Bucket:     cfg.Base.Bucket,
+		Threads:    cfg.Threads,
+		StartTime:  time.Now(),
+		Points:     []SizePoint{},
+	}
+	envOpts := envinfo.Options{MountPoint: cfg.Base.MountPoint}
+	if cfg.Base.Target == TargetGcsfuse {
+		res.GcsfuseFlags = cfg.Base.GcsfuseFlags
+		envOpts.GcsfuseBinary = cfg.Base.GcsfuseBinary
+	}
+	res.Env = envinfo.Capture(ctx, envOpts)
+	res.GcsfuseVersion = res.Env.GcsfuseVersion
+
+	for _, size := range cfg.Sizes() {
+		points, err := profileSize(ctx, cfg, size, &res.FioVersion)
+		if err != nil {
+			return nil, fmt.Errorf("size %s: %w", units.FormatSize(size), err)
+		}
+		res.Points = append(res.Points, points...)
+	}
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// profileSize runs the operations at one size and removes its files.
+func profileSize(ctx context.Context, cfg SizeProfileConfig, size int64, fioVersion *string) ([]SizePoint, error) {
+	prefix := "size-profile-" + units.FormatSize(size)
+	defer func() {
+		matches, _ := filepath.Glob(filepath.Join(cfg.Base.MountPoint, prefix+".*"))
+		for _, m := range matches {
+			if err := os.Remove(m); err != nil {
+				slog.Warn("Removing profile file failed", "file", m, "err", err)
+			}
+		}
+	}()
+	files := cfg.files(size)
+	var points []SizePoint
+	for _, op := range cfg.Ops {
+		jobFile, err := sizeProfileJobFile(cfg, op, prefix, size, files)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Profiling", "size", units.FormatSize(size), "op", op, "files", files*cfg.Threads)
+		start := time.Now()
+		out, err := runFio(ctx, cfg.Base.FioBinary, jobFile, cfg.Base.MountPoint)
+		os.Remove(jobFile)
+		if err != nil {
+			return nil, err
+		}
+		*fioVersion = out.FioVersion
+		p := SizePoint{Size: size, Op: op, Files: files * cfg.Threads, Seconds: time.Since(start).Seconds()}
+		// group_reporting folds the threads into one job.
+		for _, j := range jobResults(out) {
+			if j.Error != 0 {
+				return nil, fmt.Errorf("fio job %s failed with error %d", j.Name, j.Error)
+			}
+			p.Stats = j.Read
+			if op == ProfileWrite {
+				p.Stats = j.Write
+			}
+		}
+		if p.Stats == nil {
+			return nil, fmt.Errorf("fio reported no %s I/O", op)
+		}
+		p.FilesPerSec = float64(p.Stats.Bytes) / float64(size) / p.Seconds
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// sizeProfileJobFile writes the jobfile of op at size to a temporary file
+// and returns its path. Reads and writes of a size use the same file names.
+func sizeProfileJobFile(cfg SizeProfileConfig, op, prefix string, size int64, files int) (string, error) {
+	f, err := os.CreateTemp("", "size-profile-*.fio")
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, `[global]
+ioengine=sync
+direct=1
+invalidate=1
+thread=1
+openfiles=1
+group_reporting=1
+numjobs=%d
+nrfiles=%d
+filesize=%s
+bs=%s
+filename_format=%s.$jobnum.$filenum
+
+[%s_%s]
+rw=%s
+`, cfg.Threads, files, units.FormatSize(size), units.FormatSize(min(size, cfg.BlockSize)), prefix, op, units.FormatSize(size), op)
+	if err == nil && op == ProfileWrite {
+		_, err = fmt.Fprint(f, "create_on_open=1\nfile_append=0\n")
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// WriteText prints one row per size and operation.
+func (r *SizeProfileResult) WriteText(w io.Writer) error {
+	version := r.GcsfuseVersion
+	if version == "" {
+		version = "unknown version"
+	}
+	fmt.Fprintf(w, "Size profile of %s (%s) on %s, %d thread(s), fio %s (%s)\n\n", r.Target, version, r.MountPoint,
+		r.Threads, r.FioVersion, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tOP\tFILES\tBW (MiB/s)\tFILES/s\tMEAN LAT (ms)\tP99 CLAT (ms)")
+	for _, p := range r.Points {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%.1f\t%.2f\t%.2f\n", units.FormatSize(p.Size), p.Op, p.Files,
+			p.Stats.BwKiBps/1024, p.FilesPerSec, p.Stats.MeanLatNs/1e6, p.Stats.P99ClatNs/1e6)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,172 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Built-in target types.
+const (
+	TargetGcsfuse = "gcsfuse"
+	TargetNFS     = "nfs"
+	TargetS3fs    = "s3fs"
+)
+
+// Target is a file system the workload runs against. Adapters for other
+// systems than gcsfuse let the same jobfile produce comparison baselines.
+type Target interface {
+	// Source describes what is mounted, e.g. the bucket or NFS export.
+	Source() string
+	Mount(ctx context.Context, mountPoint string) error
+	Unmount(mountPoint string) error
+}
+
+// TargetFactory builds a target from the run configuration. It returns a nil
+// Target when the configuration names nothing to mount, in which case the
+// mount point must already be mounted.
+type TargetFactory func(cfg Config) (Target, error)
+
+var targets = map[string]TargetFactory{
+	TargetGcsfuse: func(cfg Config) (Target, error) {
+		if cfg.Bucket == "" {
+			return nil, nil
+		}
+		return &gcsfuseTarget{binary: cfg.GcsfuseBinary, bucket: cfg.Bucket, flags: cfg.GcsfuseFlags}, nil
+	},
+	TargetNFS: func(cfg Config) (Target, error) {
+		if cfg.NFSExport == "" {
+			return nil, nil
+		}
+		return &nfsTarget{export: cfg.NFSExport, options: cfg.MountOptions}, nil
+	},
+	TargetS3fs: func(cfg Config) (Target, error) {
+		if cfg.Bucket == "" {
+			return nil, nil
+		}
+		return &s3fsTarget{binary: cfg.S3fsBinary, bucket: cfg.Bucket, endpoint: cfg.S3Endpoint, options: cfg.MountOptions}, nil
+	},
+}
+
+// RegisterTarget makes a target type available to Config.Target.
+func RegisterTarget(name string, f TargetFactory) {
+	targets[name] = f
+}
+
+// TargetNames returns the registered target types.
+func TargetNames() []string {
+	var names []string
+	for n := range targets {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// run runs a mount command and includes its output in the error.
+func run(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+type gcsfuseTarget struct {
+	binary string
+	bucket string
+	flags  []string
+}
+
+func (t *gcsfuseTarget) Source() string { return t.bucket }
+
+// Mount mounts the bucket with the configured gcsfuse flags, creating the
+// mount point if needed.
+func (t *gcsfuseTarget) Mount(ctx context.Context, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return fmt.Errorf("creating mount point: %w", err)
+	}
+	slog.Info("Mounting bucket", "bucket", t.bucket, "mount_point", mountPoint, "flags", t.flags)
+	args := append(slices.Clone(t.flags), t.bucket, mountPoint)
+	if err := run(ctx, t.binary, args...); err != nil {
+		return fmt.Errorf("mounting gs://%s at %s: %w", t.bucket, mountPoint, err)
+	}
+	return nil
+}
+
+func (t *gcsfuseTarget) Unmount(mountPoint string) error {
+	return fuseUnmount(mountPoint)
+}
+
+// nfsTarget mounts an NFS export such as a Filestore share. Mounting needs
+// root.
+type nfsTarget struct {
+	export  string
+	options []string
+}
+
+func (t *nfsTarget) Source() string { return t.export }
+
+func (t *nfsTarget) Mount(ctx context.Context, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return fmt.Errorf("creating mount point: %w", err)
+	}
+	slog.Info("Mounting NFS export", "export", t.export, "mount_point", mountPoint, "options", t.options)
+	args := []string{"-t", "nfs"}
+	if len(t.options) > 0 {
+		args = append(args, "-o", strings.Join(t.options, ","))
+	}
+	if err := run(ctx, "mount", append(args, t.export, mountPoint)...); err != nil {
+		return fmt.Errorf("mounting %s at %s: %w", t.export, mountPoint, err)
+	}
+	return nil
+}
+
+func (t *nfsTarget) Unmount(mountPoint string) error {
+	slog.Info("Unmounting", "mount_point", mountPoint)
+	return run(context.Background(), "umount", mountPoint)
+}
+
+// s3fsTarget mounts a bucket through its S3-compatible XML API, by default the
+// Cloud Storage interoperability endpoint with HMAC keys from ~/.passwd-s3fs.
+type s3fsTarget struct {
+	binary   string
+	bucket   string
+	endpoint string
+	options  []string
+}
+
+func (t *s3fsTarget) Source() string { return t.endpoint + "/" + t.bucket }
+
+func (t *s3fsTarget) Mount(ctx context.Context, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return fmt.Errorf("creating mount point: %w", err)
+	}
+	slog.Info("Mounting bucket with s3fs", "bucket", t.bucket, "endpoint", t.endpoint, "mount_point", mountPoint, "options", t.options)
+	args := []string{t.bucket, mountPoint, "-o", "url=" + t.endpoint}
+	for _, o := range t.options {
+		args = append(args, "-o", o)
+	}
+	if err := run(ctx, t.binary, args...); err != nil {
+		return fmt.Errorf("mounting %s at %s: %w", t.Source(), mountPoint, err)
+	}
+	return nil
+}
+
+func (t *s3fsTarget) Unmount(mountPoint string) error {
+	return fuseUnmount(mountPoint)
+}
+
+// fuseUnmount unmounts a FUSE mount point.
+func fuseUnmount(mountPoint string) error {
+	slog.Info("Unmounting", "mount_point", mountPoint)
+	out, err := exec.Command("fusermount", "-u", mountPoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unmounting %s: %w: %s", mountPoint, err, out)
+	}
+	return nil
+}
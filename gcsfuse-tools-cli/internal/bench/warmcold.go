@@ -0,0 +1,163 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// WarmColdConfig describes a warm vs cold run: the same fio jobfile right
+// after the mount and again after a warm-up.
+type WarmColdConfig struct {
+	// Base holds the jobfile, target, fio, gcsfuse and seed options. The
+	// target is mounted for the run, so that the cold pass sees empty caches.
+	Base Config
+	// WarmupJobFile is run WarmupPasses times between the cold and the warm
+	// pass, e.g. a sequential read of the dataset to fill the file cache. The
+	// jobfile itself is run if it is empty.
+	WarmupJobFile string
+	WarmupPasses  int
+}
+
+// Validate reports missing or inconsistent options.
+func (c *WarmColdConfig) Validate() error {
+	if err := c.Base.Validate(); err != nil {
+		return err
+	}
+	if c.Base.Bucket == "" && c.Base.NFSExport == "" {
+		return errors.New("--bucket (or --nfs-export) is required, as the cold pass needs a fresh mount")
+	}
+	if c.WarmupPasses < 0 {
+		return errors.New("--warmup-passes must not be negative")
+	}
+	return nil
+}
+
+// WarmColdResult is the outcome of a warm vs cold run.
+type WarmColdResult struct {
+	JobFile       string   `json:"jobfile"`
+	WarmupJobFile string   `json:"warmup_jobfile,omitempty"`
+	Target        string   `json:"target"`
+	Bucket        string   `json:"bucket,omitempty"`
+	GcsfuseFlags  []string `json:"gcsfuse_flags,omitempty"`
+	Seed          uint64   `json:"seed,omitempty"`
+	FioVersion    string   `json:"fio_version"`
+	// MountSeconds is the time the mount took.
+	MountSeconds float64 `json:"mount_seconds"`
+	// Cold is the jobfile right after the mount, i.e. the first epoch.
+	Cold *Phase `json:"cold"`
+	// WarmupSeconds is the time of the WarmupPasses runs of the warm-up
+	// jobfile after the cold pass.
+	WarmupPasses  int     `json:"warmup_passes"`
+	WarmupSeconds float64 `json:"warmup_seconds"`
+	// Warm is the jobfile after the warm-up, i.e. the steady state.
+	Warm *Phase `json:"warm"`
+	// Speedup is the warm throughput as a multiple of the cold one.
+	Speedup   float64              `json:"speedup,omitempty"`
+	StartTime time.Time            `json:"start_time"`
+	EndTime   time.Time            `json:"end_time"`
+	Env       *envinfo.Fingerprint `json:"env"`
+}
+
+// RunWarmCold mounts the target, runs the jobfile on the fresh mount, runs
+// the warm-up, runs the jobfile again, and reports both passes and the time
+// the warm-up took.
+func RunWarmCold(ctx context.Context, cfg WarmColdConfig) (res *WarmColdResult, err error) {
+	if cfg.Base.MountPoint, err = filepath.Abs(cfg.Base.MountPoint); err != nil {
+		return nil, err
+	}
+	jobFile := cfg.Base.JobFile
+	if cfg.Base.Seed != 0 {
+		if jobFile, err = seededJobFile(cfg.Base.JobFile, cfg.Base.Seed); err != nil {
+			return nil, err
+		}
+		defer os.Remove(jobFile)
+	}
+	warmupJobFile := cfg.WarmupJobFile
+	if warmupJobFile == "" {
+		warmupJobFile = jobFile
+	}
+
+	res = &WarmColdResult{
+		JobFile: cfg.Base.JobFile, WarmupJobFile: cfg.WarmupJobFile, Target: cfg.Base.Target, Bucket: cfg.Base.Bucket,
+		Seed: cfg.Base.Seed, WarmupPasses: cfg.WarmupPasses, StartTime: time.Now(),
+	}
+	target, err := mountTarget(ctx, cfg.Base)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("target %s mounts nothing at %s, so the cold pass would not run on a fresh mount", cfg.Base.Target, cfg.Base.MountPoint)
+	}
+	defer func() {
+		if uerr := target.Unmount(cfg.Base.MountPoint); uerr != nil {
+			err = errors.Join(err, uerr)
+		}
+	}()
+	res.MountSeconds = time.Since(res.StartTime).Seconds()
+
+	pid := 0
+	envOpts := envinfo.Options{MountPoint: cfg.Base.MountPoint}
+	if cfg.Base.Target == TargetGcsfuse {
+		res.GcsfuseFlags = cfg.Base.GcsfuseFlags
+		envOpts.GcsfuseBinary = cfg.Base.GcsfuseBinary
+		pid = envinfo.GCSFusePID(cfg.Base.MountPoint)
+	}
+
+	// The environment is captured after the passes, as reading it may warm
+	// the mount's metadata.
+	slog.Info("Running fio on the fresh mount", "jobfile", cfg.Base.JobFile, "mount_point", cfg.Base.MountPoint)
+	if res.Cold, err = measurePhase(ctx, cfg.Base.FioBinary, jobFile, cfg.Base.MountPoint, pid, &res.FioVersion); err != nil {
+		return nil, fmt.Errorf("cold pass: %w", err)
+	}
+	start := time.Now()
+	for i := 0; i < cfg.WarmupPasses; i++ {
+		slog.Info("Warming up", "jobfile", warmupJobFile, "pass", i+1, "passes", cfg.WarmupPasses)
+		if _, err := runFio(ctx, cfg.Base.FioBinary, warmupJobFile, cfg.Base.MountPoint); err != nil {
+			return nil, fmt.Errorf("warm-up pass %d: %w", i+1, err)
+		}
+	}
+	res.WarmupSeconds = time.Since(start).Seconds()
+	slog.Info("Running fio on the warm mount", "jobfile", cfg.Base.JobFile)
+	if res.Warm, err = measurePhase(ctx, cfg.Base.FioBinary, jobFile, cfg.Base.MountPoint, pid, &res.FioVersion); err != nil {
+		return nil, fmt.Errorf("warm pass: %w", err)
+	}
+	if res.Cold.MiBps() > 0 {
+		res.Speedup = res.Warm.MiBps() / res.Cold.MiBps()
+	}
+	res.Env = envinfo.Capture(ctx, envOpts)
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// WriteText prints the cold and warm passes side by side and the time the
+// mount took to warm up.
+func (r *WarmColdResult) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "fio %s on a fresh %s mount (%s)\n\n", r.FioVersion, r.Target, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PASS\tSECONDS\tREAD MiB/s\tWRITE MiB/s\tCPU (cores)")
+	for _, p := range []struct {
+		name  string
+		phase *Phase
+	}{{"cold", r.Cold}, {"warm", r.Warm}} {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%.2f\n", p.name, p.phase.Seconds, p.phase.ReadMiBps, p.phase.WriteMiBps, p.phase.CPUCores)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nMount took %.1fs and %d warm-up passes %.1fs after the cold pass; steady state was reached %.1fs after mounting.\n",
+		r.MountSeconds, r.WarmupPasses, r.WarmupSeconds, r.MountSeconds+r.Cold.Seconds+r.WarmupSeconds)
+	if r.Speedup > 0 {
+		_, err := fmt.Fprintf(w, "Warm throughput is %.2fx the cold one.\n", r.Speedup)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,595 @@
+// Package bigdata emulates the reads of Hadoop and Spark jobs scanning
+// Parquet-style files: a planning phase that reads the footer of every file,
+// then many concurrent tasks that each read the footer again and the column
+// chunks of one split, through a mount or directly with the storage client.
+package bigdata
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Targets a scan reads through.
+const (
+	// TargetMount reads the files under Config.Dir, normally a gcsfuse mount.
+	TargetMount = "mount"
+	// TargetAPI reads the objects under Config.Prefix of Config.Bucket with
+	// range requests of the storage client, like the Cloud Storage
+	// connector does.
+	TargetAPI = "api"
+)
+
+// parquetMagic ends every Parquet file, preceded by the little-endian
+// length of the footer.
+const parquetMagic = "PAR1"
+
+// tailSize is the footer length and magic a reader fetches first.
+const tailSize = 8
+
+// Config holds the options of a scan.
+type Config struct {
+	// Dir is the directory read by TargetMount.
+	Dir string
+	// Bucket and Prefix are the objects read by TargetAPI.
+	Bucket, Prefix string
+	// Targets are scanned in order. Empty means mount if Dir is set and api
+	// if Bucket is set.
+	Targets []string
+	// SplitSize is the largest range one task reads, like Spark's
+	// spark.sql.files.maxPartitionBytes.
+	SplitSize int64
+	// Parallelism is the number of concurrent tasks.
+	Parallelism int
+	// FooterSize is the footer read of files that are not Parquet files.
+	// The footer of Parquet files is read at its real length.
+	FooterSize int64
+	// Columns divides every split into column chunks, of which tasks read
+	// the first ReadColumns, as a query projecting some columns does.
+	Columns, ReadColumns int
+	// BufferSize is the size of the reads a range is fetched with.
+	BufferSize int64
+	// MaxFiles bounds the files scanned, in lexical order. 0 scans all.
+	MaxFiles int
+	// DropCache evicts the files under Dir from the page cache before the
+	// mount scan.
+	DropCache bool
+}
+
+// Validate reports missing or inconsistent options and fills in the
+// default targets.
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 {
+		if c.Dir != "" {
+			c.Targets = append(c.Targets, TargetMount)
+		}
+		if c.Bucket != "" {
+			c.Targets = append(c.Targets, TargetAPI)
+		}
+	}
+	if len(c.Targets) == 0 {
+		return errors.New("--dir or --bucket is required")
+	}
+	for _, t := range c.Targets {
+		switch t {
+		case TargetMount:
+			if c.Dir == "" {
+				return errors.New("--dir is required for the mount target")
+			}
+		case TargetAPI:
+			if c.Bucket == "" {
+				return errors.New("--bucket is required for the api target")
+			}
+		default:
+			return fmt.Errorf("unsupported --targets entry %q (want %s or %s)", t, TargetMount, TargetAPI)
+		}
+	}
+	if c.SplitSize <= 0 || c.FooterSize <= 0 || c.BufferSize <= 0 {
+		return errors.New("--split-size, --footer-size and --buffer-size must be greater than 0")
+	}
+	if c.Parallelism <= 0 {
+		return errors.New("--parallelism must be greater than 0")
+	}
+	if c.Columns <= 0 {
+		return errors.New("--columns must be greater than 0")
+	}
+	if c.ReadColumns == 0 {
+		c.ReadColumns = c.Columns
+	}
+	if c.ReadColumns < 0 || c.ReadColumns > c.Columns {
+		return errors.New("--read-columns must be between 1 and --columns")
+	}
+	if c.MaxFiles < 0 {
+		return errors.New("--max-files must not be negative")
+	}
+	return nil
+}
+
+// Phase summarizes the reads of one phase of a scan. Latencies are those of
+// one file's footer reads in planning and of one task in the scan.
+type Phase struct {
+	Ops     int     `json:"ops"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	MiBps   float64 `json:"mib_per_sec"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// TargetResult is the outcome of a scan through one target.
+type TargetResult struct {
+	Target string `json:"target"`
+	Files  int    `json:"files"`
+	Splits int    `json:"splits"`
+	// Bytes is the size of the files, of which the scan reads the
+	// projected columns.
+	Bytes    int64 `json:"bytes"`
+	Planning Phase `json:"planning"`
+	Scan     Phase `json:"scan"`
+	// FirstSplitSec is the time from the start of the scan to the first
+	// completed task, and Seconds that of planning and scan together.
+	FirstSplitSec float64 `json:"first_split_sec"`
+	Seconds       float64 `json:"seconds"`
+}
+
+// Result is the outcome of a scan through every target.
+type Result struct {
+	Dir         string               `json:"dir,omitempty"`
+	Bucket      string               `json:"bucket,omitempty"`
+	Prefix      string               `json:"prefix,omitempty"`
+	SplitSize   int64                `json:"split_size"`
+	Parallelism int                  `json:"parallelism"`
+	Columns     int                  `json:"columns"`
+	ReadColumns int                  `json:"read_columns"`
+	StartTime   time.Time            `json:"start_time"`
+	EndTime     time.Time            `json:"end_time"`
+	Targets     []TargetResult       `json:"targets"`
+	Env         *envinfo.Fingerprint `json:"env"`
+}
+
+// file is one file or object of a scan.
+type file struct {
+	name string
+	size int64
+}
+
+// split is the range of a file one task reads.
+type split struct {
+	file file
+	off  int64
+	n    int64
+}
+
+// source lists and opens the files of a target.
+type source interface {
+	list(ctx context.Context) ([]file, error)
+	open(ctx context.Context, name string) (reader, error)
+}
+
+// reader reads ranges of an open file.
+type reader interface {
+	// readRange reads the n bytes at off in reads of at most len(buf)
+	// bytes and returns the bytes read.
+	readRange(ctx context.Context, off, n int64, buf []byte) (int64, error)
+	Close() error
+}
+
+// Run scans the files through every target. client is only used by
+// TargetAPI and may be nil without it.
+func Run(ctx context.Context, client *storage.Client, cfg Config) (*Result, error) {
+	res := &Result{
+		Dir:         cfg.Dir,
+		Bucket:      cfg.Bucket,
+		Prefix:      cfg.Prefix,
+		SplitSize:   cfg.SplitSize,
+		Parallelism: cfg.Parallelism,
+		Columns:     cfg.Columns,
+		ReadColumns: cfg.ReadColumns,
+		StartTime:   time.Now(),
+		Targets:     []TargetResult{},
+	}
+	res.Env = envinfo.Capture(ctx, envinfo.Options{MountPoint: cfg.Dir})
+	for _, t := range cfg.Targets {
+		var src source
+		switch t {
+		case TargetMount:
+			src = &mountSource{dir: cfg.Dir}
+		case TargetAPI:
+			src = &apiSource{bucket: client.Bucket(cfg.Bucket), prefix: cfg.Prefix}
+		}
+		tr, err := scan(ctx, cfg, t, src)
+		if err != nil {
+			return nil, fmt.Errorf("%s scan: %w", t, err)
+		}
+		res.Targets = append(res.Targets, *tr)
+	}
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// scan plans and runs the tasks of one target.
+func scan(ctx context.Context, cfg Config, target string, src source) (*TargetResult, error) {
+	files, err := src.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files to scan")
+	}
+	slices.SortFunc(files, func(a, b file) int { return strings.Compare(a.name, b.name) })
+	if cfg.MaxFiles > 0 && len(files) > cfg.MaxFiles {
+		files = files[:cfg.MaxFiles]
+	}
+	tr := &TargetResult{Target: target, Files: len(files)}
+	for _, f := range files {
+		tr.Bytes += f.size
+	}
+	if target == TargetMount && cfg.DropCache {
+		for _, f := range files {
+			if err := dropCache(filepath.Join(cfg.Dir, f.name)); err != nil {
+				slog.Warn("Could not evict a file from the page cache", "file", f.name, "err", err)
+			}
+		}
+	}
+	start := time.Now()
+
+	// Planning: the driver reads the footer of every file to compute the
+	// splits and prune row groups.
+	slog.Info("Reading footers", "target", target, "files", len(files))
+	items := make([]func(ctx context.Context, buf []byte) (int64, error), len(files))
+	for i, f := range files {
+		items[i] = func(ctx context.Context, buf []byte) (int64, error) {
+			r, err := src.open(ctx, f.name)
+			if err != nil {
+				return 0, err
+			}
+			defer r.Close()
+			return readFooter(ctx, r, f.size, cfg.FooterSize, buf)
+		}
+	}
+	tr.Planning, _, err = runPhase(ctx, cfg, items)
+	if err != nil {
+		return nil, fmt.Errorf("reading footers: %w", err)
+	}
+
+	// Scan: every task opens its file, reads the footer again, as Parquet
+	// readers of tasks do, and then the projected column chunks of its
+	// split. Larger splits are scheduled first.
+	var splits []split
+	for _, f := range files {
+		for off := int64(0); off < f.size || off == 0; off += cfg.SplitSize {
+			splits = append(splits, split{file: f, off: off, n: min(cfg.SplitSize, f.size-off)})
+		}
+	}
+	slices.SortStableFunc(splits, func(a, b split) int { return cmp.Compare(b.n, a.n) })
+	tr.Splits = len(splits)
+	slog.Info("Scanning splits", "target", target, "splits", len(splits), "parallelism", cfg.Parallelism)
+	items = make([]func(ctx context.Context, buf []byte) (int64, error), len(splits))
+	for i, s := range splits {
+		items[i] = func(ctx context.Context, buf []byte) (int64, error) {
+			r, err := src.open(ctx, s.file.name)
+			if err != nil {
+				return 0, err
+			}
+			defer r.Close()
+			total, err := readFooter(ctx, r, s.file.size, cfg.FooterSize, buf)
+			if err != nil {
+				return total, err
+			}
+			chunk := s.n / int64(cfg.Columns)
+			for c := range cfg.ReadColumns {
+				off, n := s.off+int64(c)*chunk, chunk
+				if c == cfg.Columns-1 {
+					n = s.n - int64(c)*chunk
+				}
+				got, err := r.readRange(ctx, off, n, buf)
+				total += got
+				if err != nil {
+					return total, err
+				}
+			}
+			return total, nil
+		}
+	}
+	var first time.Duration
+	tr.Scan, first, err = runPhase(ctx, cfg, items)
+	if err != nil {
+		return nil, fmt.Errorf("scanning splits: %w", err)
+	}
+	tr.FirstSplitSec = first.Seconds()
+	tr.Seconds = time.Since(start).Seconds()
+	return tr, nil
+}
+
+// runPhase runs items on cfg.Parallelism workers, stopping at the first
+// error, and returns their statistics and the time to the first completed
+// item.
+func runPhase(ctx context.Context, cfg Config, items []func(context.Context, []byte) (int64, error)) (Phase, time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		p        Phase
+		lats     []time.Duration
+		first    time.Duration
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan func(context.Context, []byte) (int64, error))
+	start := time.Now()
+	for range min(cfg.Parallelism, len(items)) {
+		wg.Go(func() {
+			buf := make([]byte, cfg.BufferSize)
+			for item := range next {
+				t := time.Now()
+				n, err := item(ctx, buf)
+				mu.Lock()
+				p.Bytes += n
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+				} else {
+					p.Ops++
+					lats = append(lats, time.Since(t))
+					if first == 0 {
+						first = time.Since(start)
+					}
+				}
+				mu.Unlock()
+			}
+		})
+	}
+feed:
+	for _, item := range items {
+		select {
+		case next <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return p, first, firstErr
+	}
+	p.Seconds = time.Since(start).Seconds()
+	if p.Seconds > 0 {
+		p.MiBps = float64(p.Bytes) / float64(units.MiB) / p.Seconds
+	}
+	slices.Sort(lats)
+	p.P50Ms, p.P90Ms, p.P99Ms = quantileMs(lats, 0.5), quantileMs(lats, 0.9), quantileMs(lats, 0.99)
+	p.MaxMs = quantileMs(lats, 1)
+	return p, first, nil
+}
+
+// quantileMs returns the q-quantile of sorted in milliseconds.
+func quantileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(q*float64(len(sorted))), len(sorted)-1)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// readFooter reads the footer length and magic at the end of a file of size
+// bytes and then the footer they describe, or footerSize bytes if the file
+// is not a Parquet file.
+func readFooter(ctx context.Context, r reader, size, footerSize int64, buf []byte) (int64, error) {
+	tail := min(size, tailSize)
+	if tail == 0 {
+		return 0, nil
+	}
+	var tb [tailSize]byte
+	n, err := r.readRange(ctx, size-tail, tail, tb[:tail])
+	if err != nil {
+		return n, err
+	}
+	footer := min(footerSize, size-tail)
+	if tail == tailSize && string(tb[4:]) == parquetMagic {
+		footer = min(int64(binary.LittleEndian.Uint32(tb[:4])), size-tail)
+	}
+	if footer == 0 {
+		return n, nil
+	}
+	m, err := r.readRange(ctx, size-tail-footer, footer, buf)
+	return n + m, err
+}
+
+// mountSource reads the files under a directory.
+type mountSource struct {
+	dir string
+}
+
+func (s *mountSource) list(ctx context.Context) ([]file, error) {
+	var files []file
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{name: rel, size: fi.Size()})
+		return nil
+	})
+	return files, err
+}
+
+func (s *mountSource) open(ctx context.Context, name string) (reader, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &mountReader{f: f}, nil
+}
+
+// mountReader reads a file with pread.
+type mountReader struct {
+	f *os.File
+}
+
+func (r *mountReader) readRange(ctx context.Context, off, n int64, buf []byte) (int64, error) {
+	var total int64
+	for total < n {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		m, err := r.f.ReadAt(buf[:min(int64(len(buf)), n-total)], off+total)
+		total += int64(m)
+		if err != nil {
+			return total, fmt.Errorf("reading %s at %d: %w", r.f.Name(), off+total, err)
+		}
+	}
+	return total, nil
+}
+
+func (r *mountReader) Close() error {
+	return r.f.Close()
+}
+
+// apiSource reads the objects under a prefix with the storage client.
+type apiSource struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+func (s *apiSource) list(ctx context.Context) ([]file, error) {
+	var files []file
+	q := &storage.Query{Prefix: s.prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return nil, err
+	}
+	it := s.bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		files = append(files, file{name: attrs.Name, size: attrs.Size})
+	}
+}
+
+func (s *apiSource) open(ctx context.Context, name string) (reader, error) {
+	return &apiReader{obj: s.bucket.Object(name)}, nil
+}
+
+// apiReader reads an object with one range request per range.
+type apiReader struct {
+	obj *storage.ObjectHandle
+}
+
+func (r *apiReader) readRange(ctx context.Context, off, n int64, buf []byte) (int64, error) {
+	rr, err := r.obj.NewRangeReader(ctx, off, n)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s at %d: %w", r.obj.ObjectName(), off, err)
+	}
+	defer rr.Close()
+	total, err := io.CopyBuffer(io.Discard, io.LimitReader(rr, n), buf)
+	if err == nil && total < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return total, fmt.Errorf("reading %s at %d: %w", r.obj.ObjectName(), off+total, err)
+	}
+	return total, nil
+}
+
+func (r *apiReader) Close() error {
+	return nil
+}
+
+// dropCache evicts path from the page cache.
+func dropCache(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	const fadvDontneed = 4
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontneed, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// formatBytes renders n with one decimal in the largest binary unit.
+func formatBytes(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"T", units.TiB}, {"G", units.GiB}, {"M", units.MiB}, {"K", units.KiB}} {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// WriteText prints the planning and scan phase of every target.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Split scan in %s splits, %d concurrent tasks, %d of %d columns (%s)\n\n",
+		units.FormatSize(r.SplitSize), r.Parallelism, r.ReadColumns, r.Columns, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tPHASE\tOPS\tREAD\tSECONDS\tMiB/s\tP50 (ms)\tP90 (ms)\tP99 (ms)\tMAX (ms)")
+	for _, t := range r.Targets {
+		for _, ph := range []struct {
+			name string
+			p    Phase
+		}{{"footers", t.Planning}, {"splits", t.Scan}} {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", t.Target, ph.name, ph.p.Ops,
+				formatBytes(ph.p.Bytes), ph.p.Seconds, ph.p.MiBps, ph.p.P50Ms, ph.p.P90Ms, ph.p.P99Ms, ph.p.MaxMs)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	for _, t := range r.Targets {
+		fmt.Fprintf(w, "%s: %d files (%s) in %d splits, first split after %.2fs, %.1fs in total\n",
+			t.Target, t.Files, formatBytes(t.Bytes), t.Splits, t.FirstSplitSec, t.Seconds)
+	}
+	return nil
+}
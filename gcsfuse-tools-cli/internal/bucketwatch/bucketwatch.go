@@ -0,0 +1,278 @@
+// Package bucketwatch records the object changes made to a benchmark bucket
+// during a run, so that out-of-band modifications that could invalidate the
+// results are flagged.
+package bucketwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// Change kinds.
+const (
+	Created     = "created"
+	Overwritten = "overwritten"
+	Deleted     = "deleted"
+	Metadata    = "metadata-updated"
+)
+
+// Change sources.
+const (
+	SourcePoll   = "poll"
+	SourcePubSub = "pubsub"
+)
+
+// Config holds the bucket-watch options.
+type Config struct {
+	Bucket string
+	Prefix string
+	// Subscription, e.g. "projects/p/subscriptions/s", receives the bucket's
+	// Pub/Sub notifications. Without it the bucket is listed every Interval.
+	Subscription string
+	Interval     time.Duration
+	// Duration stops the watch; 0 watches until the context is cancelled.
+	Duration time.Duration
+	// Ignore holds path.Match patterns of objects the run itself changes.
+	Ignore []string
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if c.Subscription == "" && c.Interval <= 0 {
+		return errors.New("--interval must be greater than 0")
+	}
+	if c.Subscription != "" && !strings.HasPrefix(c.Subscription, "projects/") {
+		return fmt.Errorf("--subscription %q must be projects/PROJECT/subscriptions/NAME", c.Subscription)
+	}
+	for _, p := range c.Ignore {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid --ignore pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Change is one modification of an object.
+type Change struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Object     string    `json:"object"`
+	Generation int64     `json:"generation,omitempty"`
+}
+
+// Report lists the changes seen during the watch.
+type Report struct {
+	Bucket  string    `json:"bucket"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Source  string    `json:"source"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Changes []Change  `json:"changes"`
+	// Ignored counts the changes matching Config.Ignore.
+	Ignored int `json:"ignored"`
+}
+
+// Watch records the changes to cfg.Bucket until cfg.Duration elapses or ctx
+// is cancelled.
+func Watch(ctx context.Context, client *storage.Client, cfg Config) (*Report, error) {
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	r := &Report{Bucket: cfg.Bucket, Prefix: cfg.Prefix, Start: time.Now(), Changes: []Change{}}
+	var err error
+	if cfg.Subscription != "" {
+		r.Source = SourcePubSub
+		err = watchPubSub(ctx, cfg, r)
+	} else {
+		r.Source = SourcePoll
+		err = poll(ctx, client.Bucket(cfg.Bucket), cfg, r)
+	}
+	r.End = time.Now()
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// record adds c to the report unless it is outside the prefix or ignored.
+func (r *Report) record(cfg Config, c Change) {
+	if !strings.HasPrefix(c.Object, cfg.Prefix) {
+		return
+	}
+	for _, p := range cfg.Ignore {
+		if ok, _ := path.Match(p, c.Object); ok {
+			r.Ignored++
+			return
+		}
+	}
+	slog.Warn("Out-of-band change", "kind", c.Kind, "object", c.Object, "generation", c.Generation)
+	r.Changes = append(r.Changes, c)
+}
+
+// version identifies the content and metadata of an object.
+type version struct {
+	generation, metageneration int64
+}
+
+// poll lists the bucket every cfg.Interval and diffs the listings. Changes
+// that cancel out between two listings, e.g. a create and a delete, are not
+// seen; use Pub/Sub notifications to catch those.
+func poll(ctx context.Context, bucket *storage.BucketHandle, cfg Config, r *Report) error {
+	prev, err := list(ctx, bucket, cfg.Prefix)
+	if err != nil {
+		return err
+	}
+	slog.Info("Watching bucket", "bucket", cfg.Bucket, "prefix", cfg.Prefix, "objects", len(prev), "interval", cfg.Interval)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		cur, err := list(ctx, bucket, cfg.Prefix)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		var changes []Change
+		for name, v := range cur {
+			p, ok := prev[name]
+			switch {
+			case !ok:
+				changes = append(changes, Change{Time: now, Kind: Created, Object: name, Generation: v.generation})
+			case p.generation != v.generation:
+				changes = append(changes, Change{Time: now, Kind: Overwritten, Object: name, Generation: v.generation})
+			case p.metageneration != v.metageneration:
+				changes = append(changes, Change{Time: now, Kind: Metadata, Object: name, Generation: v.generation})
+			}
+		}
+		for name, p := range prev {
+			if _, ok := cur[name]; !ok {
+				changes = append(changes, Change{Time: now, Kind: Deleted, Object: name, Generation: p.generation})
+			}
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Object < changes[j].Object })
+		for _, c := range changes {
+			r.record(cfg, c)
+		}
+		prev = cur
+	}
+}
+
+func list(ctx context.Context, bucket *storage.BucketHandle, prefix string) (map[string]version, error) {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Generation", "Metageneration"}); err != nil {
+		return nil, err
+	}
+	out := map[string]version{}
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing objects in %s: %w", bucket.BucketName(), err)
+		}
+		out[attrs.Name] = version{attrs.Generation, attrs.Metageneration}
+	}
+}
+
+// watchPubSub pulls the bucket's Cloud Storage notifications from
+// cfg.Subscription and acknowledges them.
+func watchPubSub(ctx context.Context, cfg Config, r *Report) error {
+	svc, err := pubsub.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("creating Pub/Sub client: %w", err)
+	}
+	subs := pubsub.NewProjectsSubscriptionsService(svc)
+	slog.Info("Watching bucket notifications", "bucket", cfg.Bucket, "subscription", cfg.Subscription)
+	for ctx.Err() == nil {
+		resp, err := subs.Pull(cfg.Subscription, &pubsub.PullRequest{MaxMessages: 100}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("pulling %s: %w", cfg.Subscription, err)
+		}
+		var acks []string
+		for _, m := range resp.ReceivedMessages {
+			acks = append(acks, m.AckId)
+			if c, ok := notificationChange(m.Message); ok && m.Message.Attributes["bucketId"] == cfg.Bucket {
+				r.record(cfg, c)
+			}
+		}
+		if len(acks) > 0 {
+			if _, err := subs.Acknowledge(cfg.Subscription, &pubsub.AcknowledgeRequest{AckIds: acks}).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("acknowledging %s: %w", cfg.Subscription, err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// notificationChange converts a Cloud Storage Pub/Sub notification. The
+// delete or archive half of an overwrite is dropped, as the finalize of the
+// new generation reports it.
+func notificationChange(m *pubsub.PubsubMessage) (Change, bool) {
+	a := m.Attributes
+	c := Change{Object: a["objectId"]}
+	c.Generation, _ = strconv.ParseInt(a["objectGeneration"], 10, 64)
+	if t, err := time.Parse(time.RFC3339Nano, a["eventTime"]); err == nil {
+		c.Time = t
+	} else {
+		c.Time, _ = time.Parse(time.RFC3339Nano, m.PublishTime)
+	}
+	switch a["eventType"] {
+	case "OBJECT_FINALIZE":
+		c.Kind = Created
+		if a["overwroteGeneration"] != "" {
+			c.Kind = Overwritten
+		}
+	case "OBJECT_DELETE", "OBJECT_ARCHIVE":
+		if a["overwrittenByGeneration"] != "" {
+			return c, false
+		}
+		c.Kind = Deleted
+	case "OBJECT_METADATA_UPDATE":
+		c.Kind = Metadata
+	default:
+		return c, false
+	}
+	return c, true
+}
+
+// WriteText prints one row per change and a summary line.
+func (r *Report) WriteText(w io.Writer) error {
+	if len(r.Changes) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tKIND\tOBJECT\tGENERATION")
+		for _, c := range r.Changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", c.Time.Format(time.RFC3339), c.Kind, c.Object, c.Generation)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "%d out-of-band change(s) to gs://%s/%s between %s and %s (%s, %d ignored).\n",
+		len(r.Changes), r.Bucket, r.Prefix, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Source, r.Ignored)
+	return err
+}
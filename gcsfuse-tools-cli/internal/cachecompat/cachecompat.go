@@ -0,0 +1,428 @@
+// Package cachecompat checks whether the file cache one gcsfuse release
+// wrote is reused or invalidated by another release mounting the same cache
+// directory, as after an upgrade.
+package cachecompat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// Expected behaviors of the upgraded release.
+const (
+	ExpectReuse      = "reuse"
+	ExpectInvalidate = "invalidate"
+)
+
+// Verdicts on the cache after the upgrade.
+const (
+	VerdictReused       = "reused"
+	VerdictInvalidated  = "invalidated"
+	VerdictPartial      = "partial"
+	VerdictInconclusive = "inconclusive"
+)
+
+const (
+	// minWarmRatio is the hit ratio the warm pass of release A must reach
+	// for the cache to be considered working at all.
+	minWarmRatio = 0.9
+	// reusedRatio and invalidatedRatio bound the hit ratio of release B,
+	// relative to the warm pass, of the reused and invalidated verdicts.
+	reusedRatio      = 0.9
+	invalidatedRatio = 0.1
+	// readBufferSize is the size of the reads of the files.
+	readBufferSize = 1 << 20
+)
+
+// Config holds the cache-compat options.
+type Config struct {
+	Bucket string
+	// GcsfuseA populates the cache and GcsfuseB, the release upgraded to,
+	// mounts it next.
+	GcsfuseA string
+	GcsfuseB string
+	// MountPoint is mounted by both releases in turn, with the file cache
+	// in CacheDir, which must be empty or not exist.
+	MountPoint string
+	CacheDir   string
+	// Dir, relative to the bucket root, holds the files that are read, at
+	// most MaxFiles of them.
+	Dir      string
+	MaxFiles int
+	// Flags are added to both mounts, e.g. --implicit-dirs.
+	Flags []string
+	// Expect, when set, is the behavior release B must show.
+	Expect string
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	switch {
+	case c.Bucket == "":
+		return errors.New("--bucket is required")
+	case c.GcsfuseA == "" || c.GcsfuseB == "":
+		return errors.New("--gcsfuse-a and --gcsfuse-b are required")
+	case c.MountPoint == "":
+		return errors.New("--mount-point is required")
+	case c.CacheDir == "":
+		return errors.New("--cache-dir is required")
+	case c.MaxFiles <= 0:
+		return errors.New("--max-files must be greater than 0")
+	}
+	switch c.Expect {
+	case "", ExpectReuse, ExpectInvalidate:
+	default:
+		return fmt.Errorf("unsupported --expect %q (want reuse or invalidate)", c.Expect)
+	}
+	return nil
+}
+
+// Pass is one read of all files, measured from the file cache reads gcsfuse
+// logged.
+type Pass struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Reads and Hits count the file cache reads, and Bytes and HitBytes
+	// their sizes.
+	Reads    int64   `json:"reads"`
+	Hits     int64   `json:"hits"`
+	Bytes    int64   `json:"bytes"`
+	HitBytes int64   `json:"hit_bytes"`
+	HitRatio float64 `json:"hit_ratio"`
+	MiBps    float64 `json:"mib_per_sec"`
+	start    time.Time
+	end      time.Time
+}
+
+// CacheFiles compares the cache directory before and after release B
+// mounted it.
+type CacheFiles struct {
+	// Kept files have the same inode, size and modification time,
+	// Rewritten ones differ.
+	Kept      int `json:"kept"`
+	Rewritten int `json:"rewritten"`
+	Removed   int `json:"removed"`
+	Added     int `json:"added"`
+}
+
+// Report is the result of cache-compat.
+type Report struct {
+	Bucket   string     `json:"bucket"`
+	CacheDir string     `json:"cache_dir"`
+	VersionA string     `json:"version_a"`
+	VersionB string     `json:"version_b"`
+	Files    int        `json:"files"`
+	Passes   []*Pass    `json:"passes"`
+	Cache    CacheFiles `json:"cache"`
+	Verdict  string     `json:"verdict"`
+	Expect   string     `json:"expect,omitempty"`
+}
+
+// OK reports whether release B behaved as expected.
+func (r *Report) OK() bool {
+	switch r.Expect {
+	case ExpectReuse:
+		return r.Verdict == VerdictReused
+	case ExpectInvalidate:
+		return r.Verdict == VerdictInvalidated
+	}
+	return true
+}
+
+// Run populates the file cache with release A, reads it warm, remounts the
+// bucket with release B on the same cache directory and reads the files
+// again.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	// gcsfuse runs as a daemon in /, so its paths must be absolute.
+	var err error
+	if cfg.CacheDir, err = filepath.Abs(cfg.CacheDir); err != nil {
+		return nil, err
+	}
+	if cfg.MountPoint, err = filepath.Abs(cfg.MountPoint); err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(cfg.CacheDir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("--cache-dir %s is not empty", cfg.CacheDir)
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache dir: %w", err)
+	}
+	logDir, err := os.MkdirTemp("", "cache-compat-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(logDir)
+
+	r := &Report{
+		Bucket:   cfg.Bucket,
+		CacheDir: cfg.CacheDir,
+		VersionA: envinfo.Capture(ctx, envinfo.Options{GcsfuseBinary: cfg.GcsfuseA}).GcsfuseVersion,
+		VersionB: envinfo.Capture(ctx, envinfo.Options{GcsfuseBinary: cfg.GcsfuseB}).GcsfuseVersion,
+		Expect:   cfg.Expect,
+	}
+	populate := &Pass{Name: "populate", Version: r.VersionA}
+	warm := &Pass{Name: "warm", Version: r.VersionA}
+	upgraded := &Pass{Name: "upgraded", Version: r.VersionB}
+	r.Passes = []*Pass{populate, warm, upgraded}
+
+	var files []string
+	logA := filepath.Join(logDir, "a.log")
+	err = withMount(ctx, cfg, cfg.GcsfuseA, logA, func(root string) error {
+		if files, err = listFiles(root, cfg.MaxFiles); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no files in gs://%s/%s", cfg.Bucket, cfg.Dir)
+		}
+		if err := readAll(ctx, root, files, populate); err != nil {
+			return err
+		}
+		return readAll(ctx, root, files, warm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Files = len(files)
+	if err := measure(logA, populate, warm); err != nil {
+		return nil, err
+	}
+
+	before, err := inventory(cfg.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	logB := filepath.Join(logDir, "b.log")
+	err = withMount(ctx, cfg, cfg.GcsfuseB, logB, func(root string) error {
+		return readAll(ctx, root, files, upgraded)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := measure(logB, upgraded); err != nil {
+		return nil, err
+	}
+	after, err := inventory(cfg.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	r.Cache = compare(before, after)
+	r.Verdict = verdict(warm, upgraded)
+	return r, nil
+}
+
+// withMount mounts the bucket with binary, the file cache in cfg.CacheDir
+// and trace logs in logFile, calls fn with the directory of the files and
+// unmounts it again.
+func withMount(ctx context.Context, cfg Config, binary, logFile string, fn func(root string) error) (err error) {
+	if err := os.MkdirAll(cfg.MountPoint, 0755); err != nil {
+		return fmt.Errorf("creating mount point: %w", err)
+	}
+	args := append([]string{
+		"--cache-dir=" + cfg.CacheDir,
+		"--file-cache-max-size-mb=-1",
+		"--log-severity=trace",
+		"--log-file=" + logFile,
+	}, cfg.Flags...)
+	args = append(args, cfg.Bucket, cfg.MountPoint)
+	slog.Info("Mounting bucket", "gcsfuse", binary, "bucket", cfg.Bucket, "mount_point", cfg.MountPoint, "cache_dir", cfg.CacheDir)
+	if out, err := exec.CommandContext(ctx, binary, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("mounting gs://%s with %s: %w: %s", cfg.Bucket, binary, err, out)
+	}
+	defer func() {
+		slog.Info("Unmounting", "mount_point", cfg.MountPoint)
+		if out, uerr := exec.Command("fusermount", "-u", cfg.MountPoint).CombinedOutput(); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("unmounting %s: %w: %s", cfg.MountPoint, uerr, out))
+		}
+	}()
+	return fn(filepath.Join(cfg.MountPoint, cfg.Dir))
+}
+
+// listFiles returns up to max regular files under root, relative to it, in
+// lexical order.
+func listFiles(root string, max int) ([]string, error) {
+	var files []string
+	errDone := errors.New("done")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, rel)
+			if len(files) == max {
+				return errDone
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errDone {
+		return nil, fmt.Errorf("listing %s: %w", root, err)
+	}
+	return files, nil
+}
+
+// readAll reads every file to the end and times p.
+func readAll(ctx context.Context, root string, files []string, p *Pass) error {
+	slog.Info("Reading files", "pass", p.Name, "files", len(files))
+	buf := make([]byte, readBufferSize)
+	var total int64
+	p.start = time.Now()
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(root, name))
+		if err != nil {
+			return err
+		}
+		n, err := io.CopyBuffer(io.Discard, f, buf)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		total += n
+	}
+	p.end = time.Now()
+	if secs := p.end.Sub(p.start).Seconds(); secs > 0 {
+		p.MiBps = float64(total) / (1 << 20) / secs
+	}
+	return nil
+}
+
+// measure counts the file cache reads in logFile that fall in the time of
+// each pass.
+func measure(logFile string, passes ...*Pass) error {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return fmt.Errorf("opening gcsfuse log: %w", err)
+	}
+	defer f.Close()
+	err = gcsfuselog.Scan(f, func(e gcsfuselog.Entry) {
+		rd, ok := gcsfuselog.ParseFileCacheRead(e)
+		if !ok {
+			return
+		}
+		for _, p := range passes {
+			if !rd.Time.Before(p.start) && !rd.Time.After(p.end) {
+				p.Reads++
+				p.Bytes += rd.Size
+				if rd.Hit {
+					p.Hits++
+					p.HitBytes += rd.Size
+				}
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("reading gcsfuse log: %w", err)
+	}
+	for _, p := range passes {
+		if p.Bytes > 0 {
+			p.HitRatio = float64(p.HitBytes) / float64(p.Bytes)
+		}
+	}
+	return nil
+}
+
+// cacheFile identifies the content of a file in the cache directory.
+type cacheFile struct {
+	ino     uint64
+	size    int64
+	modTime time.Time
+}
+
+func inventory(dir string) (map[string]cacheFile, error) {
+	files := map[string]cacheFile{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := cacheFile{size: info.Size(), modTime: info.ModTime()}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			f.ino = st.Ino
+		}
+		files[strings.TrimPrefix(path, dir)] = f
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing cache dir: %w", err)
+	}
+	return files, nil
+}
+
+func compare(before, after map[string]cacheFile) CacheFiles {
+	var c CacheFiles
+	for name, b := range before {
+		switch a, ok := after[name]; {
+		case !ok:
+			c.Removed++
+		case a == b:
+			c.Kept++
+		default:
+			c.Rewritten++
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			c.Added++
+		}
+	}
+	return c
+}
+
+// verdict compares the hit ratio of release B with that of the warm pass of
+// release A.
+func verdict(warm, upgraded *Pass) string {
+	if warm.Reads == 0 || warm.HitRatio < minWarmRatio || upgraded.Reads == 0 {
+		return VerdictInconclusive
+	}
+	switch rel := upgraded.HitRatio / warm.HitRatio; {
+	case rel >= reusedRatio:
+		return VerdictReused
+	case rel <= invalidatedRatio:
+		return VerdictInvalidated
+	default:
+		return VerdictPartial
+	}
+}
+
+// WriteText prints the passes, the cache directory changes and the verdict.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PASS\tVERSION\tREADS\tHITS\tHIT RATIO (bytes)\tMiB/s")
+	for _, p := range r.Passes {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\t%.1f\n", p.Name, p.Version, p.Reads, p.Hits, 100*p.HitRatio, p.MiBps)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nCache files after the upgrade: %d kept, %d rewritten, %d removed, %d added.\n",
+		r.Cache.Kept, r.Cache.Rewritten, r.Cache.Removed, r.Cache.Added)
+	if r.Verdict == VerdictInconclusive {
+		fmt.Fprintln(w, "Verdict: inconclusive. The warm pass did not hit the cache or no file cache reads were logged; check that both releases support the file cache flags.")
+	} else {
+		fmt.Fprintf(w, "Verdict: the cache of %s was %s by %s (%d files of gs://%s).\n", r.VersionA, r.Verdict, r.VersionB, r.Files, r.Bucket)
+	}
+	if !r.OK() {
+		fmt.Fprintf(w, "Expected the cache to be %s.\n", map[string]string{ExpectReuse: VerdictReused, ExpectInvalidate: VerdictInvalidated}[r.Expect])
+	}
+	return nil
+}
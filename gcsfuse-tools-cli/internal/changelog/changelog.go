@@ -0,0 +1,263 @@
+// Package changelog compares the registered benchmark results of two gcsfuse
+// releases and renders the differences as Markdown for release notes.
+package changelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/mmapload"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+// Tools lists the result tools whose metrics can be compared.
+var Tools = []string{"bench-fio", "bench-mmap"}
+
+// Verdicts of a change.
+const (
+	Improved  = "improved"
+	Regressed = "regressed"
+	Neutral   = "neutral"
+)
+
+// Run is a registered result and its blob.
+type Run struct {
+	Entry *registry.ResultEntry
+	Blob  []byte
+}
+
+// Metric is one measurement of a workload in a run.
+type Metric struct {
+	Workload     string
+	Name         string
+	HigherBetter bool
+	Value        float64
+}
+
+// MatchesRelease reports whether e was measured with gcsfuse release, e.g.
+// "3.4.0" or "v3.4.0". Builds such as 3.4.0-gke.1 match their release.
+func MatchesRelease(e *registry.ResultEntry, release string) bool {
+	if e.Env == nil || e.Env.GcsfuseVersion == "" {
+		return false
+	}
+	v := regexp.QuoteMeta(strings.TrimPrefix(release, "v"))
+	return regexp.MustCompile(`(^|[^0-9.])v?` + v + `([^0-9.]|$)`).MatchString(e.Env.GcsfuseVersion)
+}
+
+// Metrics extracts the comparable metrics of a run.
+func Metrics(r Run) ([]Metric, error) {
+	suffix := ""
+	if r.Entry.Dataset != "" {
+		suffix = " [" + r.Entry.Dataset + "]"
+	}
+	var out []Metric
+	switch r.Entry.Tool {
+	case "bench-fio":
+		var res bench.Result
+		if err := json.Unmarshal(r.Blob, &res); err != nil {
+			return nil, fmt.Errorf("decoding bench-fio result %s: %w", r.Entry.RunID, err)
+		}
+		for _, j := range res.Jobs {
+			if j.Error != 0 {
+				continue
+			}
+			if j.Read != nil && j.Read.Bytes > 0 {
+				out = append(out, Metric{"fio " + j.Name + " read" + suffix, "MiB/s", true, j.Read.BwKiBps / 1024})
+			}
+			if j.Write != nil && j.Write.Bytes > 0 {
+				out = append(out, Metric{"fio " + j.Name + " write" + suffix, "MiB/s", true, j.Write.BwKiBps / 1024})
+			}
+		}
+	case "bench-mmap":
+		var res mmapload.Result
+		if err := json.Unmarshal(r.Blob, &res); err != nil {
+			return nil, fmt.Errorf("decoding bench-mmap result %s: %w", r.Entry.RunID, err)
+		}
+		for _, m := range res.Modes {
+			out = append(out, Metric{fmt.Sprintf("checkpoint load %s, %s order%s", m.Mode, res.Order, suffix), "TTFT-equiv s", false, m.TotalSeconds})
+		}
+	}
+	return out, nil
+}
+
+// Change is the difference of one workload metric between the releases.
+type Change struct {
+	Workload string  `json:"workload"`
+	Metric   string  `json:"metric"`
+	Base     float64 `json:"base"`
+	Head     float64 `json:"head"`
+	// Percent is the relative change of the metric, positive when it grew.
+	Percent float64 `json:"percent"`
+	Verdict string  `json:"verdict"`
+	// BaseRuns and HeadRuns are the number of runs the medians come from.
+	BaseRuns int `json:"base_runs"`
+	HeadRuns int `json:"head_runs"`
+}
+
+// Changelog is the comparison of two releases.
+type Changelog struct {
+	Base      string   `json:"base"`
+	Head      string   `json:"head"`
+	Threshold float64  `json:"threshold"`
+	BaseRuns  int      `json:"base_runs"`
+	HeadRuns  int      `json:"head_runs"`
+	Changes   []Change `json:"changes"`
+	// OnlyBase and OnlyHead list workloads measured on one release only.
+	OnlyBase []string `json:"only_base,omitempty"`
+	OnlyHead []string `json:"only_head,omitempty"`
+	// Notes are caveats about the comparison, e.g. differing machine types.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Build compares the median of every workload metric over the runs of each
+// release. Changes within threshold (a fraction) are neutral.
+func Build(base, head string, baseRuns, headRuns []Run, threshold float64) (*Changelog, error) {
+	cl := &Changelog{Base: base, Head: head, Threshold: threshold, BaseRuns: len(baseRuns), HeadRuns: len(headRuns)}
+	b, err := collect(baseRuns)
+	if err != nil {
+		return nil, err
+	}
+	h, err := collect(headRuns)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, bm := range b {
+		hm, ok := h[key]
+		if !ok {
+			cl.OnlyBase = append(cl.OnlyBase, bm[0].Workload)
+			continue
+		}
+		c := Change{
+			Workload: bm[0].Workload, Metric: bm[0].Name,
+			Base: medianOf(bm), Head: medianOf(hm),
+			BaseRuns: len(bm), HeadRuns: len(hm),
+		}
+		if c.Base != 0 {
+			c.Percent = (c.Head - c.Base) / c.Base * 100
+		}
+		better := c.Percent > 0 == bm[0].HigherBetter
+		switch {
+		case math.Abs(c.Percent) <= threshold*100:
+			c.Verdict = Neutral
+		case better:
+			c.Verdict = Improved
+		default:
+			c.Verdict = Regressed
+		}
+		cl.Changes = append(cl.Changes, c)
+	}
+	for key, hm := range h {
+		if _, ok := b[key]; !ok {
+			cl.OnlyHead = append(cl.OnlyHead, hm[0].Workload)
+		}
+	}
+	sort.Slice(cl.Changes, func(i, j int) bool {
+		if a, b := math.Abs(cl.Changes[i].Percent), math.Abs(cl.Changes[j].Percent); a != b {
+			return a > b
+		}
+		return cl.Changes[i].Workload < cl.Changes[j].Workload
+	})
+	sort.Strings(cl.OnlyBase)
+	sort.Strings(cl.OnlyHead)
+
+	if bt, ht := machineTypes(baseRuns), machineTypes(headRuns); bt != ht {
+		cl.Notes = append(cl.Notes, fmt.Sprintf("Machine types differ: %s ran on %s, %s on %s.", base, bt, head, ht))
+	}
+	return cl, nil
+}
+
+func collect(runs []Run) (map[string][]Metric, error) {
+	out := map[string][]Metric{}
+	for _, r := range runs {
+		ms, err := Metrics(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			key := m.Workload + "\x00" + m.Name
+			out[key] = append(out[key], m)
+		}
+	}
+	return out, nil
+}
+
+func medianOf(ms []Metric) float64 {
+	vs := make([]float64, len(ms))
+	for i, m := range ms {
+		vs[i] = m.Value
+	}
+	sort.Float64s(vs)
+	if len(vs)%2 == 1 {
+		return vs[len(vs)/2]
+	}
+	return (vs[len(vs)/2-1] + vs[len(vs)/2]) / 2
+}
+
+func machineTypes(runs []Run) string {
+	seen := map[string]bool{}
+	for _, r := range runs {
+		if r.Entry.Env != nil && r.Entry.Env.MachineType != "" {
+			seen[r.Entry.Env.MachineType] = true
+		}
+	}
+	if len(seen) == 0 {
+		return "unknown machines"
+	}
+	types := make([]string, 0, len(seen))
+	for t := range seen {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// WriteText renders the changelog as Markdown.
+func (c *Changelog) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Performance changes from %s to %s\n\n", c.Base, c.Head)
+	fmt.Fprintf(&b, "Medians of %d run(s) of %s and %d run(s) of %s. Changes within ±%.0f%% are reported as neutral.\n",
+		c.BaseRuns, c.Base, c.HeadRuns, c.Head, c.Threshold*100)
+	for _, n := range c.Notes {
+		fmt.Fprintf(&b, "\n> **Note:** %s\n", n)
+	}
+
+	for _, v := range []struct{ verdict, title string }{
+		{Improved, "Improved"}, {Regressed, "Regressed"}, {Neutral, "Neutral"},
+	} {
+		var rows []Change
+		for _, ch := range c.Changes {
+			if ch.Verdict == v.verdict {
+				rows = append(rows, ch)
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", v.title)
+		fmt.Fprintf(&b, "| Workload | Metric | %s | %s | Change |\n", c.Base, c.Head)
+		b.WriteString("|---|---|---:|---:|---:|\n")
+		for _, ch := range rows {
+			fmt.Fprintf(&b, "| %s | %s | %.2f | %.2f | %+.1f%% |\n", ch.Workload, ch.Metric, ch.Base, ch.Head, ch.Percent)
+		}
+	}
+	if len(c.Changes) == 0 {
+		b.WriteString("\nNo workload was measured on both releases.\n")
+	}
+	for _, only := range []struct {
+		release string
+		names   []string
+	}{{c.Base, c.OnlyBase}, {c.Head, c.OnlyHead}} {
+		if len(only.names) > 0 {
+			fmt.Fprintf(&b, "\nOnly measured on %s: %s.\n", only.release, strings.Join(only.names, ", "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
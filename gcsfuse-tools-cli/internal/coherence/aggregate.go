@@ -0,0 +1,211 @@
+package coherence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ResultsDirEnv names the directory a coherence helper reports its result to
+// in addition to printing it. Run sets it for every process it spawns, so any
+// helper started there, directly or from a script, joins the consolidated
+// verdict.
+const ResultsDirEnv = "GCSFUSE_TOOLS_COHERENCE_RESULTS"
+
+// Report writes res to the directory named by ResultsDirEnv, if set. The file
+// is renamed into place so a collector never reads a partial result.
+func Report(res *Result) error {
+	dir := os.Getenv(ResultsDirEnv)
+	if dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%d.json", res.Tool, os.Getpid()))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("reporting result: %w", err)
+	}
+	return os.Rename(tmp, name)
+}
+
+// RunConfig holds the options of the multi-process scenario runner.
+type RunConfig struct {
+	// Commands are shell commands started concurrently, e.g. one writer and
+	// two readers.
+	Commands []string
+	// Timeout, when positive, stops the processes still running after it.
+	Timeout time.Duration
+}
+
+// ProcessResult is the outcome of one process started by Run.
+type ProcessResult struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	// Results are the helper results the process reported through
+	// ResultsDirEnv.
+	Results []*Result `json:"results"`
+	Passed  bool      `json:"passed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Run starts every command with ResultsDirEnv pointing at a private
+// directory, waits for all of them and folds their exit codes and reported
+// results into one verdict. A process passes when it exits 0, reports at
+// least one result and all its reported results passed.
+func Run(ctx context.Context, cfg RunConfig) (*Result, error) {
+	res := newResult("run", fmt.Sprintf("%d process(es)", len(cfg.Commands)))
+	root, err := os.MkdirTemp("", "coherence-run-")
+	if err != nil {
+		return nil, fmt.Errorf("creating results directory: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	procs := make([]ProcessResult, len(cfg.Commands))
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, c := range cfg.Commands {
+		p := &procs[i]
+		p.Name, p.Command = fmt.Sprintf("p%d", i), c
+		dir := filepath.Join(root, p.Name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, fmt.Errorf("creating results directory: %w", err)
+		}
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", c)
+		cmd.Env = append(os.Environ(), ResultsDirEnv+"="+dir)
+		stdout := &prefixWriter{prefix: "[" + p.Name + "] ", w: os.Stdout, mu: &outMu}
+		stderr := &prefixWriter{prefix: "[" + p.Name + "] ", w: os.Stderr, mu: &outMu}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		// Stop the whole process group on timeout, not only the shell.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM) }
+		cmd.WaitDelay = 10 * time.Second
+
+		fmt.Printf("[%s] Starting: %s\n", p.Name, c)
+		if err := cmd.Start(); err != nil {
+			p.ExitCode, p.Error = -1, err.Error()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cmd.Wait()
+			stdout.flush()
+			stderr.flush()
+			p.ExitCode = cmd.ProcessState.ExitCode()
+			if err != nil {
+				p.Error = err.Error()
+				if ctx.Err() != nil {
+					p.Error = "stopped after --timeout: " + p.Error
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failures int32
+	for i := range procs {
+		p := &procs[i]
+		if err := collectResults(filepath.Join(root, p.Name), p); err != nil {
+			return nil, err
+		}
+		p.Passed = p.ExitCode == 0 && len(p.Results) > 0
+		if len(p.Results) == 0 && p.Error == "" {
+			p.Error = "no coherence result reported"
+		}
+		for _, r := range p.Results {
+			p.Passed = p.Passed && r.Passed
+		}
+		if !p.Passed {
+			failures++
+		}
+		res.Processes = append(res.Processes, *p)
+	}
+
+	fmt.Println("Consolidated results:")
+	for _, p := range res.Processes {
+		verdict := "PASS"
+		if !p.Passed {
+			verdict = "FAIL"
+		}
+		fmt.Printf("  %s %s: exit %d, %d result(s)", verdict, p.Name, p.ExitCode, len(p.Results))
+		for _, r := range p.Results {
+			fmt.Printf(", %s %s %d failure(s)", r.Tool, r.Path, r.Failures)
+		}
+		if p.Error != "" {
+			fmt.Printf(" (%s)", p.Error)
+		}
+		fmt.Println()
+	}
+	return res.finish(failures), nil
+}
+
+func collectResults(dir string, p *ProcessResult) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		b, err := os.ReadFile(m)
+		if err != nil {
+			return err
+		}
+		var r Result
+		if err := json.Unmarshal(b, &r); err != nil {
+			return fmt.Errorf("decoding %s: %w", m, err)
+		}
+		p.Results = append(p.Results, &r)
+	}
+	return nil
+}
+
+// prefixWriter writes every complete line to w with prefix, so interleaved
+// process output stays attributable.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.buf = append(pw.buf, b...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		pw.mu.Lock()
+		_, err := fmt.Fprintf(pw.w, "%s%s", pw.prefix, pw.buf[:i+1])
+		pw.mu.Unlock()
+		pw.buf = pw.buf[i+1:]
+		if err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// flush writes a last line that did not end with a newline.
+func (pw *prefixWriter) flush() {
+	if len(pw.buf) > 0 {
+		pw.Write([]byte("\n"))
+	}
+}
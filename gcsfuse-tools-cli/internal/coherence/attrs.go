@@ -0,0 +1,284 @@
+package coherence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Attribute operations checked by Attrs.
+const (
+	AttrChmod    = "chmod"
+	AttrChown    = "chown"
+	AttrUtimes   = "utimes"
+	AttrSetxattr = "setxattr"
+)
+
+// Expected behaviours of an attribute operation.
+const (
+	// BehaviorIgnored: the call succeeds and the attribute is unchanged.
+	BehaviorIgnored = "ignored"
+	// BehaviorEmulated: the call succeeds and the attribute takes the new value.
+	BehaviorEmulated = "emulated"
+	// BehaviorError: the call fails.
+	BehaviorError = "error"
+)
+
+// DefaultAttrExpectations is the behaviour gcsfuse documents: file modes and
+// owners come from --file-mode, --uid and --gid, mtime is persisted in the
+// object's gcsfuse_mtime metadata, and extended attributes are not supported.
+var DefaultAttrExpectations = map[string]string{
+	AttrChmod:    BehaviorIgnored,
+	AttrChown:    BehaviorIgnored,
+	AttrUtimes:   BehaviorEmulated,
+	AttrSetxattr: BehaviorError,
+}
+
+// ParseAttrExpectations parses "chmod=ignored,utimes=emulated" on top of
+// DefaultAttrExpectations.
+func ParseAttrExpectations(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for k, v := range DefaultAttrExpectations {
+		out[k] = v
+	}
+	if s == "" {
+		return out, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		op, b, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if _, ok := DefaultAttrExpectations[op]; !ok {
+			return nil, fmt.Errorf("unknown attribute operation %q (want chmod, chown, utimes or setxattr)", op)
+		}
+		switch b {
+		case BehaviorIgnored, BehaviorEmulated, BehaviorError:
+			out[op] = b
+		default:
+			return nil, fmt.Errorf("invalid behaviour %q for %s (want ignored, emulated or error)", b, op)
+		}
+	}
+	return out, nil
+}
+
+// AttrsConfig holds the options of the attribute persistence check.
+type AttrsConfig struct {
+	Path string
+	// Expect maps each operation to its expected behaviour.
+	Expect map[string]string
+	// CacheWait, when positive, is slept before re-checking the attributes,
+	// e.g. a little longer than the mount's metadata cache TTL.
+	CacheWait time.Duration
+	// RemountCmd, when set, is run with sh -c before a last re-check.
+	RemountCmd string
+}
+
+// AttrCheck is the outcome of one operation across the check phases.
+type AttrCheck struct {
+	Op       string `json:"op"`
+	Expected string `json:"expected"`
+	Observed string `json:"observed"`
+	Error    string `json:"error,omitempty"`
+	// Before and After are the attribute before and right after the call.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// AfterCache and AfterRemount are the attribute after CacheWait and after
+	// RemountCmd, when those phases run.
+	AfterCache   string   `json:"after_cache,omitempty"`
+	AfterRemount string   `json:"after_remount,omitempty"`
+	Problems     []string `json:"problems,omitempty"`
+}
+
+// attrOp applies one operation and reads the attribute it targets.
+type attrOp struct {
+	apply func(path string, before *syscall.Stat_t) error
+	read  func(path string) (string, error)
+}
+
+// attrMtime is the mtime utimes sets, far enough from now to tell apart.
+var attrMtime = time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+var attrOps = map[string]attrOp{
+	AttrChmod: {
+		apply: func(path string, before *syscall.Stat_t) error {
+			// Flip the group and other write bits so the mode always changes.
+			return os.Chmod(path, os.FileMode(before.Mode&0777^0022))
+		},
+		read: statField(func(st *syscall.Stat_t) string { return fmt.Sprintf("%#o", st.Mode&0777) }),
+	},
+	AttrChown: {
+		apply: func(path string, before *syscall.Stat_t) error {
+			// nobody, or root for a file already owned by nobody.
+			id := 65534
+			if before.Uid == 65534 {
+				id = 0
+			}
+			return os.Chown(path, id, id)
+		},
+		read: statField(func(st *syscall.Stat_t) string { return fmt.Sprintf("%d:%d", st.Uid, st.Gid) }),
+	},
+	AttrUtimes: {
+		apply: func(path string, _ *syscall.Stat_t) error {
+			return os.Chtimes(path, attrMtime, attrMtime)
+		},
+		read: statField(func(st *syscall.Stat_t) string {
+			return time.Unix(st.Mtim.Unix()).UTC().Format(time.RFC3339Nano)
+		}),
+	},
+	AttrSetxattr: {
+		apply: func(path string, _ *syscall.Stat_t) error {
+			return syscall.Setxattr(path, "user.gcsfuse-tools", []byte("coherence"), 0)
+		},
+		read: func(path string) (string, error) {
+			buf := make([]byte, 64)
+			n, err := syscall.Getxattr(path, "user.gcsfuse-tools", buf)
+			if err != nil {
+				// A missing or unsupported attribute reads as unset.
+				return "<unset>", nil
+			}
+			return string(buf[:n]), nil
+		},
+	},
+}
+
+func statField(f func(*syscall.Stat_t) string) func(string) (string, error) {
+	return func(path string) (string, error) {
+		var st syscall.Stat_t
+		if err := syscall.Stat(path, &st); err != nil {
+			return "", err
+		}
+		return f(&st), nil
+	}
+}
+
+// Attrs creates cfg.Path, runs chmod, chown, utimes and setxattr on it and
+// checks that each behaves as expected and that the resulting attributes stay
+// the same after the metadata cache expires and after a remount.
+func Attrs(cfg AttrsConfig) (*Result, error) {
+	res := newResult("attrs", cfg.Path)
+	// Start from a fresh object so attributes left by an earlier run do not
+	// make a call look ignored.
+	if err := os.Remove(cfg.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing %s: %w", cfg.Path, err)
+	}
+	if err := os.WriteFile(cfg.Path, []byte(DefaultContent), 0644); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", cfg.Path, err)
+	}
+
+	ops := make([]string, 0, len(cfg.Expect))
+	for op := range cfg.Expect {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		c := AttrCheck{Op: op, Expected: cfg.Expect[op]}
+		if err := runAttrCheck(cfg.Path, &c); err != nil {
+			return nil, err
+		}
+		res.Checks = append(res.Checks, c)
+	}
+
+	if cfg.CacheWait > 0 {
+		fmt.Printf("Waiting %v for the metadata cache to expire...\n", cfg.CacheWait)
+		time.Sleep(cfg.CacheWait)
+		if err := recheckAttrs(cfg.Path, res.Checks, "cache expiry", func(c *AttrCheck, v string) { c.AfterCache = v }); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RemountCmd != "" {
+		fmt.Printf("Remounting with: %s\n", cfg.RemountCmd)
+		cmd := exec.Command("sh", "-c", cfg.RemountCmd)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("running remount command: %w", err)
+		}
+		if err := recheckAttrs(cfg.Path, res.Checks, "remount", func(c *AttrCheck, v string) { c.AfterRemount = v }); err != nil {
+			return nil, err
+		}
+	}
+
+	var failures int32
+	for _, c := range res.Checks {
+		status := "OK"
+		if len(c.Problems) > 0 {
+			status = "FAILURE"
+			res.record(noOffset, fmt.Sprintf("%s: %s", c.Op, strings.Join(c.Problems, "; ")))
+			failures++
+		}
+		fmt.Printf("[%s] %s: expected %s, observed %s (%s -> %s)\n", status, c.Op, c.Expected, c.Observed, c.Before, c.After)
+		for _, p := range c.Problems {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", c.Op, p)
+		}
+	}
+	return res.finish(failures), nil
+}
+
+// runAttrCheck applies c.Op and classifies what happened.
+func runAttrCheck(path string, c *AttrCheck) error {
+	op := attrOps[c.Op]
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	var err error
+	if c.Before, err = op.read(path); err != nil {
+		return fmt.Errorf("reading %s attribute: %w", c.Op, err)
+	}
+
+	callErr := op.apply(path, &st)
+	if c.After, err = op.read(path); err != nil {
+		return fmt.Errorf("reading %s attribute: %w", c.Op, err)
+	}
+	switch {
+	case callErr != nil:
+		c.Observed, c.Error = BehaviorError, describeErrno(callErr)
+	case c.After == c.Before:
+		c.Observed = BehaviorIgnored
+	default:
+		c.Observed = BehaviorEmulated
+	}
+	if c.Observed != c.Expected {
+		c.Problems = append(c.Problems, fmt.Sprintf("%s, want %s", c.Observed, c.Expected))
+	}
+	if callErr != nil && c.After != c.Before {
+		c.Problems = append(c.Problems, fmt.Sprintf("call failed but the attribute changed from %s to %s", c.Before, c.After))
+	}
+	return nil
+}
+
+// recheckAttrs reads every attribute again and flags those that no longer
+// match their value right after the call.
+func recheckAttrs(path string, checks []AttrCheck, phase string, record func(*AttrCheck, string)) error {
+	for i := range checks {
+		c := &checks[i]
+		v, err := attrOps[c.Op].read(path)
+		if err != nil {
+			return fmt.Errorf("reading %s attribute after %s: %w", c.Op, phase, err)
+		}
+		record(c, v)
+		if v != c.After {
+			c.Problems = append(c.Problems, fmt.Sprintf("%s after %s, was %s right after the call", v, phase, c.After))
+		}
+	}
+	return nil
+}
+
+// describeErrno names the errno of err, e.g. "ENOTSUP", when it has one.
+func describeErrno(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		for _, e := range []struct {
+			errno syscall.Errno
+			name  string
+		}{{syscall.EPERM, "EPERM"}, {syscall.EACCES, "EACCES"}, {syscall.ENOTSUP, "ENOTSUP"}, {syscall.ENOSYS, "ENOSYS"}, {syscall.EINVAL, "EINVAL"}, {syscall.EIO, "EIO"}, {syscall.ENOENT, "ENOENT"}} {
+			if errno == e.errno {
+				return e.name
+			}
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,127 @@
+// Package coherence holds the direct I/O helpers used by the coherency
+// validation workflows. They mirror the standalone read.go, write.go and
+// read_concurrently.go programs under coherency-validation/python, which stay
+// in place for the Python fsops harness.
+package coherence
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// oDirect is a platform-specific flag (mainly Linux) for Direct I/O.
+var oDirect = 0
+
+// alignmentBlockSize is the block size (4096 bytes) used for aligned I/O.
+const alignmentBlockSize = 4096
+
+func init() {
+	// O_DIRECT is defined only on Linux and some other Unix-like systems.
+	if runtime.GOOS == "linux" {
+		oDirect = syscall.O_DIRECT
+	}
+}
+
+// patternChunkSize is the buffer size used to generate and check content.
+const patternChunkSize = 8 << 20
+
+// expectedByte returns the byte at off of the content generated for seed. It
+// is a pure function of its arguments, so writers and readers on any host
+// agree on every byte of a file of any size without holding or shipping a
+// reference copy. Seed 0 is the original pattern rotating through printable
+// ASCII; other seeds give pseudo-random bytes, so a stale read of content
+// written with another seed is detected too.
+func expectedByte(seed uint64, off int64) byte {
+	if seed == 0 {
+		return byte(off%94 + 33)
+	}
+	return byte(patternWord(seed, off>>3) >> (8 * (off & 7)))
+}
+
+// patternWord is the i-th 8-byte word of the seeded pattern (splitmix64).
+func patternWord(seed uint64, i int64) uint64 {
+	z := seed + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// fillExpected sets buf to the expected content of the range starting at off,
+// computing each pattern word once.
+func fillExpected(buf []byte, seed uint64, off int64) {
+	if seed == 0 {
+		for i := range buf {
+			buf[i] = expectedByte(0, off+int64(i))
+		}
+		return
+	}
+	for i := 0; i < len(buf); {
+		o := off + int64(i)
+		w := patternWord(seed, o>>3)
+		for b := o & 7; b < 8 && i < len(buf); b, i = b+1, i+1 {
+			buf[i] = byte(w >> (8 * b))
+		}
+	}
+}
+
+// firstMismatch returns the index of the first byte of buf, read at off, that
+// differs from the expected content, or -1. scratch must be at least as long
+// as buf.
+func firstMismatch(buf []byte, seed uint64, off int64, scratch []byte) int {
+	want := scratch[:len(buf)]
+	fillExpected(want, seed, off)
+	if bytes.Equal(buf, want) {
+		return -1
+	}
+	for i := range buf {
+		if buf[i] != want[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// writePattern writes size bytes of the content generated for seed to w,
+// one chunk at a time, so files larger than memory can be written.
+func writePattern(w io.Writer, size int64, seed uint64) (int64, error) {
+	buf := make([]byte, min(size, patternChunkSize))
+	var written int64
+	for written < size {
+		chunk := buf[:min(size-written, int64(len(buf)))]
+		fillExpected(chunk, seed, written)
+		if _, err := w.Write(chunk); err != nil {
+			return written, err
+		}
+		written += int64(len(chunk))
+	}
+	return written, nil
+}
+
+// formatInt formats an integer with commas (e.g., 1000000 -> "1,000,000")
+func formatInt(n int64) string {
+	in := strconv.FormatInt(n, 10)
+	numOfDigits := len(in)
+	if n < 0 {
+		numOfDigits--
+	}
+	numOfCommas := (numOfDigits - 1) / 3
+
+	out := make([]byte, len(in)+numOfCommas)
+	if n < 0 {
+		in, out[0] = in[1:], '-'
+	}
+
+	for i, j, k := len(in)-1, len(out)-1, 0; ; i, j = i-1, j-1 {
+		out[j] = in[i]
+		if i == 0 {
+			return string(out)
+		}
+		if k++; k == 3 {
+			j, k = j-1, 0
+			out[j] = ','
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package coherence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// maxLogLines bounds the log records attached to one failure; those closest
+// to its time are kept.
+const maxLogLines = 200
+
+// Correlate attaches to every failure record of res the gcsfuse log records
+// of logPath within window of its time and, for content mismatches, the FUSE
+// and GCS reads among them covering its offset. gcsfuse must have logged
+// with --log-severity=trace for the reads to show up.
+func Correlate(res *Result, logPath string, window time.Duration) error {
+	if len(res.FailureRecords) == 0 {
+		return nil
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("opening gcsfuse log: %w", err)
+	}
+	defer f.Close()
+
+	entries := make([][]gcsfuselog.Entry, len(res.FailureRecords))
+	err = gcsfuselog.Scan(f, func(e gcsfuselog.Entry) {
+		for i := range res.FailureRecords {
+			fr := &res.FailureRecords[i]
+			if e.Time.Before(fr.Time.Add(-window)) || e.Time.After(fr.Time.Add(window)) {
+				continue
+			}
+			entries[i] = append(entries[i], e)
+			if fr.Offset == nil {
+				continue
+			}
+			if r, ok := gcsfuselog.ParseRead(e); ok && r.Offset <= *fr.Offset && *fr.Offset < r.Offset+r.Length &&
+				(r.Layer == gcsfuselog.LayerFUSE || matchesObject(res.Path, r.File)) {
+				fr.Reads = append(fr.Reads, r)
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("reading gcsfuse log: %w", err)
+	}
+	for i := range res.FailureRecords {
+		fr := &res.FailureRecords[i]
+		for _, e := range nearest(entries[i], fr.Time) {
+			fr.Log = append(fr.Log, fmt.Sprintf("%s %s %s", e.Time.Format("15:04:05.000000"), e.Severity, e.Message))
+		}
+	}
+	return nil
+}
+
+// nearest returns the maxLogLines entries, in log order, around t.
+func nearest(entries []gcsfuselog.Entry, t time.Time) []gcsfuselog.Entry {
+	if len(entries) <= maxLogLines {
+		return entries
+	}
+	i := 0
+	for i < len(entries) && entries[i].Time.Before(t) {
+		i++
+	}
+	start := min(max(0, i-maxLogLines/2), len(entries)-maxLogLines)
+	return entries[start : start+maxLogLines]
+}
+
+// matchesObject reports whether the GCS object name could be the file at
+// path in the mount: FUSE reads carry inodes, GCS reads the object name,
+// which is a suffix of the path without the mount point.
+func matchesObject(path, object string) bool {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path == "/"+object || strings.HasSuffix(path, "/"+object)
+}
+
+// Prompt asks Gemini for a first-pass diagnosis of the failures of r from
+// their correlated gcsfuse log records.
+func (r *Result) Prompt() string {
+	var b strings.Builder
+	b.WriteString(`You are a gcsfuse engineer. A consistency check of a file on a gcsfuse mount
+failed. Below are the failures, each with the gcsfuse trace log records around
+it and the FUSE and GCS reads covering the offset of a content mismatch.
+Explain in a few sentences the most likely cause (stale cache, concurrent
+overwrite, short or misplaced read, remount) and what to look at next. Be
+concise.
+
+`)
+	c := &Result{Tool: r.Tool, Path: r.Path, Failures: r.Failures, StartTime: r.StartTime, EndTime: r.EndTime, FailureRecords: r.FailureRecords}
+	// Records beyond the first few rarely add anything but tokens.
+	c.FailureRecords = c.FailureRecords[:min(len(c.FailureRecords), 5)]
+	c.WriteText(&b)
+	return b.String()
+}
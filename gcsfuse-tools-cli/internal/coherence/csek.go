@@ -0,0 +1,175 @@
+package coherence
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Operations checked by CSEK.
+const (
+	CSEKStat   = "stat"
+	CSEKList   = "list"
+	CSEKRead   = "read"
+	CSEKReread = "reread"
+)
+
+// CSEKConfig holds the options of the customer-supplied encryption key
+// scenario.
+type CSEKConfig struct {
+	// Dir is a directory of the mount, which shows the objects of Bucket
+	// under Prefix, e.g. "data/" for a mount of the whole bucket read at
+	// <mount point>/data.
+	Dir    string
+	Bucket string
+	Prefix string
+	// Size and Seed define the content of the encrypted object.
+	Size int64
+	Seed uint64
+	// ReadTimeout bounds how long a read of the object may take to fail.
+	ReadTimeout time.Duration
+}
+
+// CSEK writes an object encrypted with a random customer-supplied encryption
+// key through client, which the mount has no key for, and checks the error
+// surface of gcsfuse: the object must be listed and stat'ed with its size,
+// as its metadata needs no key, and reading it must fail with EIO within
+// cfg.ReadTimeout, again on a second read, without returning any bytes. The
+// outcome of every operation is recorded in the Checks of the result.
+func CSEK(ctx context.Context, client *storage.Client, cfg CSEKConfig) (*Result, error) {
+	res := newResult("csek", cfg.Dir)
+	res.Seed = cfg.Seed
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("csek.%d", time.Now().UnixNano())
+	obj := client.Bucket(cfg.Bucket).Object(cfg.Prefix + name)
+	w := obj.Key(key).NewWriter(ctx)
+	if _, err := writePattern(w, cfg.Size, cfg.Seed); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("writing gs://%s/%s: %w", cfg.Bucket, obj.ObjectName(), err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("writing gs://%s/%s: %w", cfg.Bucket, obj.ObjectName(), err)
+	}
+	fmt.Printf("Wrote gs://%s/%s encrypted with a customer-supplied key\n", cfg.Bucket, obj.ObjectName())
+	defer func() {
+		// Deleting needs no key.
+		if err := obj.Delete(context.WithoutCancel(ctx)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: deleting gs://%s/%s: %v\n", cfg.Bucket, obj.ObjectName(), err)
+		}
+	}()
+
+	var failures int32
+	check := func(c AttrCheck) {
+		for _, p := range c.Problems {
+			msg := fmt.Sprintf("%s: %s", c.Op, p)
+			fmt.Fprintf(os.Stderr, "FAILURE: %s\n", msg)
+			res.record(noOffset, msg)
+			failures++
+		}
+		if len(c.Problems) == 0 {
+			fmt.Printf("[OK] %s: %s\n", c.Op, c.Observed)
+		}
+		res.Checks = append(res.Checks, c)
+	}
+
+	path := filepath.Join(cfg.Dir, name)
+	c := AttrCheck{Op: CSEKStat, Expected: fmt.Sprintf("size %d", cfg.Size)}
+	if st, err := os.Stat(path); err != nil {
+		c.Observed, c.Error = describeErrno(err), err.Error()
+		c.Problems = append(c.Problems, "stat failed: "+describeErrno(err))
+	} else {
+		c.Observed = fmt.Sprintf("size %d", st.Size())
+		if st.Size() != cfg.Size {
+			c.Problems = append(c.Problems, fmt.Sprintf("stat reports %d bytes, the object has %d", st.Size(), cfg.Size))
+		}
+	}
+	check(c)
+
+	c = AttrCheck{Op: CSEKList, Expected: "listed"}
+	if names, err := readDirNames(cfg.Dir); err != nil {
+		c.Observed, c.Error = describeErrno(err), err.Error()
+		c.Problems = append(c.Problems, "listing failed: "+describeErrno(err))
+	} else if slices.Contains(names, name) {
+		c.Observed = "listed"
+	} else {
+		c.Observed = "missing"
+		c.Problems = append(c.Problems, fmt.Sprintf("%s is not listed in %s", name, cfg.Dir))
+	}
+	check(c)
+
+	for _, op := range []string{CSEKRead, CSEKReread} {
+		check(checkUnreadable(op, path, cfg.ReadTimeout))
+	}
+	return res.finish(failures), nil
+}
+
+// checkUnreadable reads path in full and checks that it fails with EIO
+// within timeout, without returning any bytes.
+func checkUnreadable(op, path string, timeout time.Duration) AttrCheck {
+	c := AttrCheck{Op: op, Expected: "EIO"}
+	type outcome struct {
+		n   int64
+		err error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			done <- outcome{0, err}
+			return
+		}
+		defer f.Close()
+		n, err := io.Copy(io.Discard, f)
+		done <- outcome{n, err}
+	}()
+	var o outcome
+	select {
+	case o = <-done:
+	case <-time.After(timeout):
+		// The read is left behind; gcsfuse may still answer it.
+		c.Observed = "hung"
+		c.Problems = append(c.Problems, fmt.Sprintf("reading did not fail within %v", timeout))
+		return c
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case o.err == nil:
+		c.Observed = fmt.Sprintf("read %d bytes", o.n)
+		c.Problems = append(c.Problems, fmt.Sprintf("reading succeeded with %d bytes without the encryption key", o.n))
+	default:
+		c.Observed, c.Error = fmt.Sprintf("%s after %v", describeErrno(o.err), elapsed), o.err.Error()
+		if !errors.Is(o.err, syscall.EIO) {
+			c.Problems = append(c.Problems, fmt.Sprintf("reading failed with %s, want EIO", describeErrno(o.err)))
+		}
+		if o.n > 0 {
+			c.Problems = append(c.Problems, fmt.Sprintf("reading returned %d bytes before failing", o.n))
+		}
+	}
+	return c
+}
+
+// readDirNames returns the names of the entries of dir.
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
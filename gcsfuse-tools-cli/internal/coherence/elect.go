@@ -0,0 +1,248 @@
+package coherence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ElectConfig holds the options of the single-writer election helper.
+//
+// Every contender creates Path with O_CREAT|O_EXCL, writes its ID and closes
+// the file. On gcsfuse the create is backed by a generation-0 precondition, so
+// exactly one contender across all hosts and mounts must succeed even when the
+// losers' kernels have not seen the winner's file yet; those losers fail at
+// close instead of open.
+type ElectConfig struct {
+	Path string
+	// WriterID identifies this host's contenders. Defaults to the hostname.
+	WriterID string
+	// Contenders is the number of concurrent contenders on this host.
+	Contenders int
+	// ResultsDir receives one outcome record per contender. It must be
+	// shared by all hosts, e.g. a directory on the same bucket. Defaults to
+	// Path + ".election".
+	ResultsDir string
+	// Hold keeps the winner in its critical section this long before the
+	// outcome is recorded, widening the race window for late contenders.
+	Hold time.Duration
+}
+
+// ElectionRecord is the outcome of one contender.
+type ElectionRecord struct {
+	Contender string    `json:"contender"`
+	Won       bool      `json:"won"`
+	Error     string    `json:"error,omitempty"`
+	Owner     string    `json:"owner"`
+	Time      time.Time `json:"time"`
+}
+
+func (c *ElectConfig) resultsDir() string {
+	if c.ResultsDir != "" {
+		return c.ResultsDir
+	}
+	return c.Path + ".election"
+}
+
+// Elect runs the contenders of this host and records their outcomes. A
+// contender that won but does not read back its own ID, or lost but reads
+// back its own ID, is counted as a failure, as are several local winners.
+func Elect(cfg ElectConfig) (*Result, error) {
+	res := newResult("elect", cfg.Path)
+	id := cfg.WriterID
+	if id == "" {
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("resolving writer ID: %w", err)
+		}
+	}
+	dir := cfg.resultsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating results directory: %w", err)
+	}
+
+	n := max(cfg.Contenders, 1)
+	fmt.Printf("Starting %d contender(s) for lock '%s' as '%s'\n", n, cfg.Path, id)
+
+	var wg sync.WaitGroup
+	var failureCount, winners int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contender := fmt.Sprintf("%s-%d", id, i)
+			rec := contend(cfg, contender)
+			if rec.Won {
+				atomic.AddInt32(&winners, 1)
+			}
+			if rec.Won != (rec.Owner == contender) {
+				msg := fmt.Sprintf("%s won=%t but the lock is owned by '%s'", contender, rec.Won, rec.Owner)
+				fmt.Fprintf(os.Stderr, "[%s] FAILURE: %s\n", contender, msg)
+				res.record(noOffset, msg)
+				atomic.AddInt32(&failureCount, 1)
+			}
+			if err := writeRecord(filepath.Join(dir, contender+".json"), rec); err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Error recording outcome: %v\n", contender, err)
+				atomic.AddInt32(&failureCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if winners > 1 {
+		msg := fmt.Sprintf("%d local contenders won the lock", winners)
+		fmt.Fprintf(os.Stderr, "FAILURE: %s\n", msg)
+		res.record(noOffset, msg)
+		failureCount += winners - 1
+	}
+	fmt.Printf("%d of %d local contender(s) won. Outcomes recorded in '%s'.\n", winners, n, dir)
+	return res.finish(failureCount), nil
+}
+
+// contend tries to take the lock once and reads back its owner.
+func contend(cfg ElectConfig, contender string) ElectionRecord {
+	rec := ElectionRecord{Contender: contender}
+	err := createExclusive(cfg.Path, contender)
+	if err == nil {
+		rec.Won = true
+		fmt.Printf("[%s] Won the lock.\n", contender)
+		time.Sleep(cfg.Hold)
+	} else {
+		rec.Error = err.Error()
+		fmt.Printf("[%s] Lost the lock: %v\n", contender, err)
+	}
+	rec.Time = time.Now()
+	if b, err := os.ReadFile(cfg.Path); err == nil {
+		rec.Owner = strings.TrimSpace(string(b))
+	} else {
+		fmt.Fprintf(os.Stderr, "[%s] Error reading back the lock: %v\n", contender, err)
+	}
+	return rec
+}
+
+// createExclusive creates path only if it does not exist. Precondition
+// failures may surface at open (EEXIST) or, on gcsfuse, at sync or close.
+func createExclusive(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeRecord(path string, rec ElectionRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// VerifyElectionConfig holds the options of the election verifier.
+type VerifyElectionConfig struct {
+	Path       string
+	ResultsDir string
+	// Contenders is the total number of records expected from all hosts.
+	Contenders int
+	// Timeout bounds the wait for all records to appear.
+	Timeout time.Duration
+}
+
+// VerifyElection checks the records of all hosts: exactly one contender won,
+// it owns the lock, and every contender saw the same owner.
+func VerifyElection(cfg VerifyElectionConfig) (*Result, error) {
+	res := newResult("elect-verify", cfg.Path)
+	dir := (&ElectConfig{Path: cfg.Path, ResultsDir: cfg.ResultsDir}).resultsDir()
+
+	var recs []ElectionRecord
+	deadline := time.Now().Add(cfg.Timeout)
+	for {
+		var err error
+		if recs, err = readRecords(dir); err != nil {
+			return nil, err
+		}
+		if len(recs) >= cfg.Contenders || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	var failures int32
+	fail := func(n int32, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Fprintf(os.Stderr, "FAILURE: %s\n", msg)
+		res.record(noOffset, msg)
+		failures += n
+	}
+	if len(recs) < cfg.Contenders {
+		fail(1, "%d of %d outcome records found in '%s'", len(recs), cfg.Contenders, dir)
+	}
+	b, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lock '%s': %w", cfg.Path, err)
+	}
+	owner := strings.TrimSpace(string(b))
+
+	var winners []string
+	for _, r := range recs {
+		if r.Won {
+			winners = append(winners, r.Contender)
+		}
+		switch r.Owner {
+		case owner:
+		case "":
+			// Stat and type caches may hide a lock created on another host.
+			fmt.Fprintf(os.Stderr, "Warning: %s could not read back the lock\n", r.Contender)
+		default:
+			fail(1, "%s saw owner '%s', the lock is owned by '%s'", r.Contender, r.Owner, owner)
+		}
+	}
+	switch {
+	case len(winners) == 0:
+		fail(1, "no contender won the lock")
+	case len(winners) > 1:
+		fail(int32(len(winners)-1), "%d contenders won the lock: %s", len(winners), strings.Join(winners, ", "))
+	case winners[0] != owner:
+		fail(1, "%s won but the lock is owned by '%s'", winners[0], owner)
+	default:
+		fmt.Printf("SUCCESS: exactly one of %d contender(s) won: %s\n", len(recs), owner)
+	}
+	return res.finish(failures), nil
+}
+
+func readRecords(dir string) ([]ElectionRecord, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading results directory: %w", err)
+	}
+	var recs []ElectionRecord
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var r ElectionRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", e.Name(), err)
+		}
+		recs = append(recs, r)
+	}
+	return recs, nil
+}
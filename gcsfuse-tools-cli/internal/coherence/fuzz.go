@@ -0,0 +1,344 @@
+package coherence
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fuzzPrefix names the files owned by a fuzz run so they can be cleaned up
+// before a replay.
+const fuzzPrefix = "fuzz-"
+
+// FuzzConfig holds the options of the operation sequence fuzzer.
+type FuzzConfig struct {
+	// Dir is the directory on the mount the files are created in.
+	Dir string
+	// Seed makes the sequence reproducible. 0 picks a seed from the clock.
+	Seed uint64
+	// Ops is the number of operations to run.
+	Ops int
+	// Files is the number of distinct file names operations pick from.
+	Files int
+	// MaxSize bounds the offsets and lengths of reads, writes and truncates.
+	MaxSize int
+	// Keep leaves the files in place after a passing run.
+	Keep bool
+}
+
+// fuzzHandle is an open file together with the name it currently has.
+type fuzzHandle struct {
+	f    *os.File
+	name string
+}
+
+// fuzzer generates valid operations from its model of the directory and
+// checks the mount against it. The generator only looks at the model, never
+// at what the mount returned, so a seed always replays the same sequence up to
+// the first divergence.
+type fuzzer struct {
+	cfg     FuzzConfig
+	rng     *rand.Rand
+	files   map[string][]byte // expected content of every existing file
+	handles []*fuzzHandle
+	history []string
+}
+
+// Fuzz runs a random sequence of open, read, write, truncate, rename and close
+// operations on a few files in cfg.Dir and compares every result with an
+// in-memory model. It stops at the first divergence and prints the seed and
+// the operations that led to it.
+func Fuzz(cfg FuzzConfig) (*Result, error) {
+	res := newResult("fuzz", cfg.Dir)
+	if cfg.Seed == 0 {
+		cfg.Seed = uint64(time.Now().UnixNano())
+	}
+	res.Seed = cfg.Seed
+	if err := removeFuzzFiles(cfg.Dir); err != nil {
+		return nil, err
+	}
+
+	fz := &fuzzer{
+		cfg:   cfg,
+		rng:   rand.New(rand.NewPCG(cfg.Seed, 0)),
+		files: map[string][]byte{},
+	}
+	fmt.Printf("Running %d operation(s) on %d file(s) in '%s' with seed %d\n", cfg.Ops, cfg.Files, cfg.Dir, cfg.Seed)
+
+	err := fz.run()
+	// Handles may still be open after a divergence; the final checks only
+	// make sense once every handle closed cleanly.
+	for _, h := range fz.handles {
+		h.f.Close()
+	}
+	if err == nil {
+		fz.handles = nil
+		err = fz.verifyAll()
+	}
+	if err != nil {
+		fz.report(err)
+		res.record(noOffset, fmt.Sprintf("divergence at operation %d: %s: %v", len(fz.history), fz.history[len(fz.history)-1], err))
+		return res.finish(1), nil
+	}
+
+	fmt.Printf("SUCCESS: %d operation(s) matched the model.\n", cfg.Ops)
+	if !cfg.Keep {
+		if err := removeFuzzFiles(cfg.Dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cleaning up: %v\n", err)
+		}
+	}
+	return res.finish(0), nil
+}
+
+func (fz *fuzzer) run() error {
+	for i := 0; i < fz.cfg.Ops; i++ {
+		if err := fz.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step picks one operation that is valid in the current model state, runs it
+// and checks its outcome.
+func (fz *fuzzer) step() error {
+	type op struct {
+		weight int
+		run    func() error
+	}
+	ops := []op{{3, fz.open}}
+	if len(fz.handles) > 0 {
+		ops = append(ops, op{4, fz.read}, op{4, fz.write}, op{2, fz.close})
+	}
+	if len(fz.files) > 0 {
+		ops = append(ops, op{1, fz.truncate}, op{1, fz.rename})
+	}
+	total := 0
+	for _, o := range ops {
+		total += o.weight
+	}
+	n := fz.rng.IntN(total)
+	for _, o := range ops {
+		if n < o.weight {
+			return o.run()
+		}
+		n -= o.weight
+	}
+	panic("unreachable")
+}
+
+func (fz *fuzzer) logf(format string, args ...any) {
+	fz.history = append(fz.history, fmt.Sprintf(format, args...))
+}
+
+func (fz *fuzzer) path(name string) string {
+	return filepath.Join(fz.cfg.Dir, name)
+}
+
+func (fz *fuzzer) name() string {
+	return fmt.Sprintf("%s%d", fuzzPrefix, fz.rng.IntN(fz.cfg.Files))
+}
+
+// existing returns a random name of an existing file in sorted order, so the
+// pick does not depend on map iteration.
+func (fz *fuzzer) existing() string {
+	names := make([]string, 0, len(fz.files))
+	for n := range fz.files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names[fz.rng.IntN(len(names))]
+}
+
+func (fz *fuzzer) open() error {
+	name := fz.name()
+	fz.logf("open(%s, O_RDWR|O_CREATE)", name)
+	f, err := os.OpenFile(fz.path(name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, ok := fz.files[name]; !ok {
+		fz.files[name] = []byte{}
+	}
+	fz.handles = append(fz.handles, &fuzzHandle{f: f, name: name})
+	return nil
+}
+
+func (fz *fuzzer) handle() (int, *fuzzHandle) {
+	i := fz.rng.IntN(len(fz.handles))
+	return i, fz.handles[i]
+}
+
+func (fz *fuzzer) read() error {
+	i, h := fz.handle()
+	off, n := fz.rng.IntN(fz.cfg.MaxSize), 1+fz.rng.IntN(fz.cfg.MaxSize)
+	fz.logf("pread(h%d=%s, off=%d, len=%d)", i, h.name, off, n)
+	buf := make([]byte, n)
+	got, err := h.f.ReadAt(buf, int64(off))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	want := fz.files[h.name]
+	want = want[min(off, len(want)):min(off+n, len(want))]
+	if !bytes.Equal(buf[:got], want) {
+		return fmt.Errorf("read %d byte(s) differing from the expected %d byte(s)%s", got, len(want), firstDiff(buf[:got], want, off))
+	}
+	return nil
+}
+
+func (fz *fuzzer) write() error {
+	i, h := fz.handle()
+	off, n := fz.rng.IntN(fz.cfg.MaxSize), 1+fz.rng.IntN(fz.cfg.MaxSize)
+	data := make([]byte, n)
+	for j := range data {
+		data[j] = byte(fz.rng.IntN(256))
+	}
+	fz.logf("pwrite(h%d=%s, off=%d, len=%d)", i, h.name, off, n)
+	if _, err := h.f.WriteAt(data, int64(off)); err != nil {
+		return err
+	}
+	content := fz.files[h.name]
+	if end := off + n; end > len(content) {
+		content = append(content, make([]byte, end-len(content))...)
+	}
+	copy(content[off:], data)
+	fz.files[h.name] = content
+	return nil
+}
+
+func (fz *fuzzer) truncate() error {
+	name := fz.existing()
+	size := fz.rng.IntN(fz.cfg.MaxSize)
+	fz.logf("truncate(%s, %d)", name, size)
+	if err := os.Truncate(fz.path(name), int64(size)); err != nil {
+		return err
+	}
+	content := fz.files[name]
+	if size > len(content) {
+		content = append(content, make([]byte, size-len(content))...)
+	}
+	fz.files[name] = content[:size]
+	return nil
+}
+
+// rename moves a file to another name. gcsfuse renames files by copying the
+// object, so only files without open handles are renamed, onto targets
+// without open handles.
+func (fz *fuzzer) rename() error {
+	from, to := fz.existing(), fz.name()
+	if from == to || fz.isOpen(from) || fz.isOpen(to) {
+		fz.logf("skip rename(%s, %s)", from, to)
+		return nil
+	}
+	fz.logf("rename(%s, %s)", from, to)
+	if err := os.Rename(fz.path(from), fz.path(to)); err != nil {
+		return err
+	}
+	fz.files[to] = fz.files[from]
+	delete(fz.files, from)
+	return nil
+}
+
+func (fz *fuzzer) isOpen(name string) bool {
+	for _, h := range fz.handles {
+		if h.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// close closes a handle and checks the size the mount reports afterwards.
+func (fz *fuzzer) close() error {
+	i, h := fz.handle()
+	fz.logf("close(h%d=%s)", i, h.name)
+	fz.handles = append(fz.handles[:i], fz.handles[i+1:]...)
+	if err := h.f.Close(); err != nil {
+		return err
+	}
+	fi, err := os.Stat(fz.path(h.name))
+	if err != nil {
+		return err
+	}
+	if want := int64(len(fz.files[h.name])); fi.Size() != want {
+		return fmt.Errorf("stat after close reports %d byte(s), want %d", fi.Size(), want)
+	}
+	return nil
+}
+
+// verifyAll compares the directory listing and every file with the model.
+func (fz *fuzzer) verifyAll() error {
+	fz.logf("verify directory")
+	entries, err := os.ReadDir(fz.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	var got, want []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), fuzzPrefix) {
+			got = append(got, e.Name())
+		}
+	}
+	for name := range fz.files {
+		want = append(want, name)
+	}
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		return fmt.Errorf("directory lists %v, want %v", got, want)
+	}
+	for _, name := range want {
+		b, err := os.ReadFile(fz.path(name))
+		if err != nil {
+			return err
+		}
+		if w := fz.files[name]; !bytes.Equal(b, w) {
+			return fmt.Errorf("%s has %d byte(s), want %d%s", name, len(b), len(w), firstDiff(b, w, 0))
+		}
+	}
+	return nil
+}
+
+// report prints the divergence, the operations leading to it and how to
+// replay them.
+func (fz *fuzzer) report(err error) {
+	const tail = 20
+	fmt.Fprintf(os.Stderr, "FAILURE: divergence at operation %d: %s: %v\n", len(fz.history), fz.history[len(fz.history)-1], err)
+	start := max(0, len(fz.history)-tail)
+	fmt.Fprintf(os.Stderr, "Last %d operation(s):\n", len(fz.history)-start)
+	for i := start; i < len(fz.history); i++ {
+		fmt.Fprintf(os.Stderr, "  %4d  %s\n", i+1, fz.history[i])
+	}
+	// The sequence up to the divergence is a prefix of the full one.
+	fmt.Fprintf(os.Stderr, "Replay with: coherence fuzz %s --seed %d --ops %d --files %d --max-size %d\n",
+		fz.cfg.Dir, fz.cfg.Seed, min(len(fz.history), fz.cfg.Ops), fz.cfg.Files, fz.cfg.MaxSize)
+}
+
+// firstDiff describes the first differing byte of got and want, where got
+// starts at offset base of the file.
+func firstDiff(got, want []byte, base int) string {
+	for i := 0; i < min(len(got), len(want)); i++ {
+		if got[i] != want[i] {
+			return fmt.Sprintf(", first difference at offset %d: got 0x%02x, want 0x%02x", base+i, got[i], want[i])
+		}
+	}
+	return ""
+}
+
+func removeFuzzFiles(dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, fuzzPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil {
+			return fmt.Errorf("removing %s: %w", m, err)
+		}
+	}
+	return nil
+}
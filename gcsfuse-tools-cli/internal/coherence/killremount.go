@@ -0,0 +1,240 @@
+package coherence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// KillRemountConfig holds the options of the kill-and-remount scenario.
+type KillRemountConfig struct {
+	// Dir is a directory of the mount the scenario files are created in.
+	Dir string
+	// MountPoint identifies the gcsfuse process to kill. Defaults to Dir.
+	MountPoint string
+	// RemountCmd is run with sh -c after the kill. It must clean up the dead
+	// mount, e.g. with fusermount -uz, and mount the bucket again.
+	RemountCmd string
+	// Size and Seed define the pattern of the scenario files.
+	Size int64
+	Seed uint64
+	// KillAfter is how long the reader runs before gcsfuse is killed.
+	KillAfter time.Duration
+	// ErrorTimeout bounds how long interrupted operations may take to fail
+	// once gcsfuse is gone; longer counts as a hang.
+	ErrorTimeout time.Duration
+	// Direct opens the read file with O_DIRECT, so reads cannot be served
+	// from the page cache.
+	Direct bool
+}
+
+// KillRemount SIGKILLs the gcsfuse process serving cfg.MountPoint while a
+// reader loops over one file and a writer is halfway through overwriting
+// another. It checks that both get an error rather than hang, remounts with
+// cfg.RemountCmd and checks that the read file is intact and the written one
+// holds either its old or its new content in full, never a partial object.
+func KillRemount(ctx context.Context, cfg KillRemountConfig) (*Result, error) {
+	res := newResult("kill-remount", cfg.Dir)
+	res.Seed = cfg.Seed
+	if cfg.MountPoint == "" {
+		cfg.MountPoint = cfg.Dir
+	}
+	mountPoint, err := filepath.Abs(cfg.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+	pid := envinfo.GCSFusePID(mountPoint)
+	if pid == 0 {
+		return nil, fmt.Errorf("no gcsfuse process serves %s", mountPoint)
+	}
+
+	readPath := filepath.Join(cfg.Dir, "kill-remount.read")
+	writePath := filepath.Join(cfg.Dir, "kill-remount.write")
+	oldSeed, newSeed := cfg.Seed, cfg.Seed+1
+	for _, path := range []string{readPath, writePath} {
+		if err := createPatternFile(path, cfg.Size, oldSeed); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", path, err)
+		}
+	}
+
+	killed := make(chan struct{})
+	readDone := make(chan error, 1)
+	writeDone := make(chan error, 1)
+	halfWritten := make(chan struct{})
+	go func() { readDone <- readUntilError(readPath, cfg, oldSeed) }()
+	go func() { writeDone <- writeAcross(writePath, cfg.Size, newSeed, halfWritten, killed) }()
+
+	select {
+	case <-halfWritten:
+	case err := <-writeDone:
+		return nil, fmt.Errorf("writing the first half of %s: %w", writePath, err)
+	}
+	time.Sleep(cfg.KillAfter)
+	fmt.Printf("Killing gcsfuse (pid %d) serving %s\n", pid, mountPoint)
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		return nil, fmt.Errorf("killing gcsfuse: %w", err)
+	}
+	killedAt := time.Now()
+	close(killed)
+
+	var failures int32
+	fail := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Fprintf(os.Stderr, "FAILURE: %s\n", msg)
+		res.record(noOffset, msg)
+		failures++
+	}
+	deadline := time.After(cfg.ErrorTimeout)
+	for _, op := range []struct {
+		name string
+		done <-chan error
+	}{{"reader", readDone}, {"writer", writeDone}} {
+		select {
+		case err := <-op.done:
+			if err == nil {
+				fail("%s succeeded after gcsfuse was killed", op.name)
+				continue
+			}
+			fmt.Printf("[OK] %s failed after %v: %s\n", op.name, time.Since(killedAt).Round(time.Millisecond), describeErrno(err))
+		case <-deadline:
+			fail("%s still blocked %v after gcsfuse was killed", op.name, cfg.ErrorTimeout)
+		}
+	}
+
+	fmt.Printf("Remounting with: %s\n", cfg.RemountCmd)
+	cmd := exec.CommandContext(ctx, "sh", "-c", cfg.RemountCmd)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running remount command: %w", err)
+	}
+
+	if off, err := patternMismatch(readPath, cfg.Size, oldSeed); err != nil {
+		fail("reading %s after remount: %v", readPath, err)
+	} else if off >= 0 {
+		fail("%s differs from its content at offset %d after remount", readPath, off)
+	} else {
+		fmt.Printf("[OK] %s is intact\n", readPath)
+	}
+	switch oldOff, err := patternMismatch(writePath, cfg.Size, oldSeed); {
+	case err != nil:
+		fail("reading %s after remount: %v", writePath, err)
+	case oldOff < 0:
+		fmt.Printf("[OK] %s holds its old content\n", writePath)
+	default:
+		newOff, _ := patternMismatch(writePath, cfg.Size, newSeed)
+		if newOff >= 0 {
+			fail("%s holds a partial object: old content up to offset %d, new content up to offset %d", writePath, oldOff, newOff)
+		} else {
+			fmt.Printf("[OK] %s holds its new content\n", writePath)
+		}
+	}
+	return res.finish(failures), nil
+}
+
+// readFlags returns the read-only open flags, with O_DIRECT when requested
+// and supported.
+func readFlags(direct bool) int {
+	if direct {
+		return os.O_RDONLY | oDirect
+	}
+	return os.O_RDONLY
+}
+
+// readUntilError reads path from start to end over and over, reopening it
+// every time, and returns the first error. Content mismatches are errors too.
+func readUntilError(path string, cfg KillRemountConfig, seed uint64) error {
+	buf := make([]byte, patternChunkSize)
+	scratch := make([]byte, patternChunkSize)
+	for {
+		f, err := os.OpenFile(path, readFlags(cfg.Direct), 0)
+		if err != nil {
+			return err
+		}
+		var off int64
+		for {
+			n, err := f.Read(buf)
+			if i := firstMismatch(buf[:n], seed, off, scratch); i >= 0 {
+				f.Close()
+				return fmt.Errorf("read mismatch at offset %d", off+int64(i))
+			}
+			off += int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+	}
+}
+
+// writeAcross overwrites path with size bytes of the pattern of seed, but
+// writes only the first half before signalling halfWritten and waiting for
+// resume, e.g. until gcsfuse was killed. It returns the error of writing the
+// second half and closing.
+func writeAcross(path string, size int64, seed uint64, halfWritten, resume chan struct{}) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	half := size / 2
+	if _, err := writePattern(f, half, seed); err != nil {
+		f.Close()
+		return err
+	}
+	close(halfWritten)
+	<-resume
+
+	rest := make([]byte, size-half)
+	fillExpected(rest, seed, half)
+	if _, err := f.Write(rest); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// patternMismatch returns the offset at which path first differs from size
+// bytes of the pattern of seed, or -1. A wrong size is a mismatch at the end
+// of the shorter of the two.
+func patternMismatch(path string, size int64, seed uint64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, patternChunkSize)
+	scratch := make([]byte, patternChunkSize)
+	var off int64
+	for {
+		n, err := f.Read(buf)
+		if i := firstMismatch(buf[:n], seed, off, scratch); i >= 0 {
+			return off + int64(i), nil
+		}
+		off += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if off != size {
+		return min(off, size), nil
+	}
+	return -1, nil
+}
@@ -0,0 +1,73 @@
+package coherence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// ReadConfig holds the options of the whole-file read helper.
+type ReadConfig struct {
+	Path   string
+	Direct bool
+}
+
+// readDirectAligned reads the file content in block-aligned chunks, which is
+// required by O_DIRECT.
+func readDirectAligned(f *os.File) ([]byte, error) {
+	var content []byte
+	buffer := make([]byte, alignmentBlockSize)
+
+	for {
+		n, err := f.Read(buffer)
+		if n > 0 {
+			content = append(content, buffer[:n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content, nil
+}
+
+// Read reads the whole file at cfg.Path and copies its content to w.
+func Read(cfg ReadConfig, w io.Writer) error {
+	openFlags := os.O_RDONLY
+	isDirect := cfg.Direct
+
+	if isDirect {
+		if oDirect == 0 {
+			isDirect = false // Fallback to standard if O_DIRECT is not supported
+			fmt.Fprintf(os.Stderr, "Warning: --direct flag used, but O_DIRECT is not defined or supported on %s. Opening with standard O_RDONLY.\n", runtime.GOOS)
+		} else {
+			openFlags |= oDirect
+			fmt.Fprintf(os.Stderr, "Attempting to open file '%s' with O_DIRECT (using aligned read loop)...\n", cfg.Path)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Opening file '%s' with standard flags (using io.ReadAll)...\n", cfg.Path)
+	}
+
+	file, err := os.OpenFile(cfg.Path, openFlags, 0)
+	if err != nil {
+		return fmt.Errorf("opening file '%s': %w", cfg.Path, err)
+	}
+	defer file.Close()
+
+	var content []byte
+	if isDirect {
+		content, err = readDirectAligned(file)
+	} else {
+		content, err = io.ReadAll(file)
+	}
+	if err != nil {
+		return fmt.Errorf("reading file content: %w", err)
+	}
+
+	_, err = w.Write(content)
+	return err
+}
@@ -0,0 +1,226 @@
+package coherence
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadConcurrentlyConfig holds the options of the concurrent range reader.
+type ReadConcurrentlyConfig struct {
+	Path string
+	// Size, when positive, creates Path with the pattern generated for Seed
+	// first.
+	Size int64
+	Seed uint64
+	// MinReadSize is the read block size per thread. Defaults to 1MiB.
+	MinReadSize int64
+	// Verify checks an existing file against the pattern generated for Seed,
+	// e.g. one written by write --size on another host, and reports
+	// verification success. Content is always checked when Size is set.
+	Verify  bool
+	Threads int
+	Verbose bool
+	Quiet   bool
+	Direct  bool
+}
+
+// ReadConcurrently reads random, possibly overlapping, ranges of cfg.Path from
+// cfg.Threads goroutines and optionally verifies them. Failed threads are
+// counted in the result.
+func ReadConcurrently(cfg ReadConcurrentlyConfig) (*Result, error) {
+	res := newResult("read-concurrently", cfg.Path)
+	res.Seed = cfg.Seed
+	verbose, quiet := cfg.Verbose, cfg.Quiet
+	if quiet {
+		verbose = false
+	}
+	doVerify := cfg.Verify
+
+	if cfg.Direct && !quiet {
+		fmt.Println("O_DIRECT mode enabled.")
+	}
+
+	if cfg.Size > 0 {
+		if verbose {
+			fmt.Printf("Generating %d bytes of data...\n", cfg.Size)
+		}
+		if err := createPatternFile(cfg.Path, cfg.Size, cfg.Seed); err != nil {
+			return nil, fmt.Errorf("creating input file: %w", err)
+		}
+	} else if doVerify && verbose {
+		fmt.Printf("Verifying the existing file against the pattern of seed %d.\n", cfg.Seed)
+	}
+	check := cfg.Size > 0 || doVerify
+
+	numThreads := cfg.Threads
+	if numThreads < 1 {
+		numThreads = 1
+	}
+
+	if verbose {
+		fmt.Printf("Configuration:\n - Input: %s\n - Threads: %d\n", cfg.Path, numThreads)
+	}
+
+	fileInfo, err := os.Stat(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("stating input file: %w", err)
+	}
+	fileSize := fileInfo.Size()
+
+	// Generate random ranges, allowing overlaps.
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var ranges [][2]int64
+	for i := 0; i < numThreads; i++ {
+		if fileSize == 0 {
+			ranges = append(ranges, [2]int64{0, 0})
+			continue
+		}
+
+		start := rng.Int63n(fileSize)
+		if cfg.Direct {
+			start = (start / alignmentBlockSize) * alignmentBlockSize
+		}
+		length := rng.Int63n(fileSize-start) + 1
+		ranges = append(ranges, [2]int64{start, start + length})
+	}
+
+	minReadSize := cfg.MinReadSize
+	if minReadSize <= 0 {
+		minReadSize = 1024 * 1024 // 1MB default chunk read size
+	}
+	if cfg.Direct && minReadSize < alignmentBlockSize {
+		minReadSize = alignmentBlockSize
+	}
+
+	var wg sync.WaitGroup
+	var failureCount int32
+
+	startTime := time.Now()
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readChunk(cfg.Path, i, r[0], r[1], check, cfg.Seed, minReadSize, verbose, quiet, cfg.Direct, res, &failureCount)
+		}()
+	}
+	wg.Wait()
+
+	if verbose {
+		fmt.Printf("Reading complete in %v\n", time.Since(startTime))
+	}
+
+	if doVerify && verbose && failureCount == 0 {
+		fmt.Println("SUCCESS: All threads verified content successfully.")
+	}
+	return res.finish(failureCount), nil
+}
+
+// createPatternFile writes size bytes of the pattern generated for seed to
+// path.
+func createPatternFile(path string, size int64, seed uint64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := writePattern(f, size, seed); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readChunk reads [start, end) of path and, with check, compares every byte
+// with expectedByte(seed, offset). Failures are recorded in res.
+func readChunk(path string, threadID int, start, end int64, check bool, seed uint64, minReadSize int64, verbose, quiet, useDirect bool, res *Result, failureCount *int32) {
+	if !quiet {
+		fmt.Printf("Starting thread#%d to read [%s -> %s) ...\n", threadID, formatInt(start), formatInt(end))
+	}
+
+	openFlags := os.O_RDONLY
+	if useDirect {
+		if oDirect == 0 {
+			fmt.Fprintf(os.Stderr, "[Thread %d] Warning: O_DIRECT not supported on this platform.\n", threadID)
+		} else {
+			openFlags |= oDirect
+		}
+	}
+
+	f, err := os.OpenFile(path, openFlags, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Thread %d] Error opening file: %v\n", threadID, err)
+		res.record(noOffset, fmt.Sprintf("opening file: %v", err))
+		atomic.AddInt32(failureCount, 1)
+		return
+	}
+	defer f.Close()
+
+	if start >= end {
+		if !quiet {
+			fmt.Printf("... Ended thread#%d (empty/invalid range)\n", threadID)
+		}
+		return
+	}
+
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		fmt.Fprintf(os.Stderr, "[Thread %d] Seek error: %v\n", threadID, err)
+		res.record(start, fmt.Sprintf("seek error: %v", err))
+		atomic.AddInt32(failureCount, 1)
+		return
+	}
+
+	totalBytesToRead := end - start
+	var bytesReadSoFar int64
+
+	// If O_DIRECT, buffer size must be aligned.
+	bufSize := minReadSize
+	if useDirect && bufSize%alignmentBlockSize != 0 {
+		bufSize = ((bufSize / alignmentBlockSize) + 1) * alignmentBlockSize
+	}
+	buffer := make([]byte, bufSize)
+	var scratch []byte
+	if check {
+		scratch = make([]byte, bufSize)
+	}
+
+	for bytesReadSoFar < totalBytesToRead {
+		remaining := totalBytesToRead - bytesReadSoFar
+		readRequestSize := bufSize
+		if !useDirect && remaining < int64(len(buffer)) {
+			readRequestSize = remaining
+		}
+
+		n, err := f.Read(buffer[:readRequestSize])
+		if n > 0 {
+			currentAbsOffset := start + bytesReadSoFar
+			if check {
+				if i := firstMismatch(buffer[:n], seed, currentAbsOffset, scratch); i >= 0 {
+					off := currentAbsOffset + int64(i)
+					msg := fmt.Sprintf("Mismatch at offset %d: read 0x%02x, want 0x%02x", off, buffer[i], expectedByte(seed, off))
+					fmt.Fprintf(os.Stderr, "[Thread %d] FAILURE: %s\n", threadID, msg)
+					res.record(off, msg)
+					atomic.AddInt32(failureCount, 1)
+				}
+			}
+			bytesReadSoFar += int64(n)
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "[Thread %d] Read error: %v\n", threadID, err)
+			res.record(start+bytesReadSoFar, fmt.Sprintf("read error: %v", err))
+			atomic.AddInt32(failureCount, 1)
+			break
+		}
+	}
+
+	if !quiet {
+		fmt.Printf("... Ended thread#%d\n", threadID)
+	}
+}
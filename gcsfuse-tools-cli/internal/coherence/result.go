@@ -0,0 +1,109 @@
+package coherence
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// maxFailureRecords bounds the failures a Result keeps; Failures counts all
+// of them.
+const maxFailureRecords = 100
+
+// noOffset marks a failure that is not about a file offset.
+const noOffset = -1
+
+// Result is the JSON record of a coherence helper run.
+type Result struct {
+	Tool      string    `json:"tool"`
+	Path      string    `json:"path"`
+	Passed    bool      `json:"passed"`
+	Failures  int       `json:"failures"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// Seed replays a fuzz run, or names the content pattern of write and
+	// read-concurrently.
+	Seed uint64 `json:"seed,omitempty"`
+	// Checks holds the per-operation outcomes of an attrs run.
+	Checks []AttrCheck `json:"checks,omitempty"`
+	// Processes holds the per-process outcomes of a multi-process run.
+	Processes []ProcessResult `json:"processes,omitempty"`
+	// Env is the fingerprint of the host and gcsfuse mounts the helper ran on.
+	Env *envinfo.Fingerprint `json:"env,omitempty"`
+	// FailureRecords describe the first failures, in the order detected.
+	FailureRecords []Failure `json:"failure_records,omitempty"`
+	// Diagnosis is the Gemini first-pass diagnosis of the failures, when
+	// requested.
+	Diagnosis string `json:"diagnosis,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Failure is one failed check.
+type Failure struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	// Offset is the file offset of a content mismatch.
+	Offset *int64 `json:"offset,omitempty"`
+	// Log holds the gcsfuse log records around Time and Reads the reads
+	// among them covering Offset, once correlated with the gcsfuse log.
+	Log   []string          `json:"log,omitempty"`
+	Reads []gcsfuselog.Read `json:"reads,omitempty"`
+}
+
+// record keeps the failure described by msg, at offset unless it is
+// noOffset. It is safe for concurrent use.
+func (r *Result) record(offset int64, msg string) {
+	f := Failure{Time: time.Now(), Message: msg}
+	if offset != noOffset {
+		f.Offset = &offset
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.FailureRecords) < maxFailureRecords {
+		r.FailureRecords = append(r.FailureRecords, f)
+	}
+}
+
+func newResult(tool, path string) *Result {
+	return &Result{Tool: tool, Path: path, StartTime: time.Now()}
+}
+
+// finish records the end time and verdict.
+func (r *Result) finish(failures int32) *Result {
+	r.EndTime = time.Now()
+	r.Failures = int(failures)
+	r.Passed = failures == 0
+	return r
+}
+
+// WriteText prints a one-line verdict, followed by the failures correlated
+// with the gcsfuse log and the diagnosis, if any.
+func (r *Result) WriteText(w io.Writer) error {
+	verdict := "PASS"
+	if !r.Passed {
+		verdict = "FAIL"
+	}
+	_, err := fmt.Fprintf(w, "%s: %s %s (%d failures, %v)\n", verdict, r.Tool, r.Path, r.Failures, r.EndTime.Sub(r.StartTime).Round(time.Millisecond))
+	for _, f := range r.FailureRecords {
+		if len(f.Log) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s %s\n", f.Time.Format("15:04:05.000000"), f.Message)
+		for _, rd := range f.Reads {
+			fmt.Fprintf(w, "  covered by %s read of %s [%d, %d) at %s\n", rd.Layer, rd.File, rd.Offset, rd.Offset+rd.Length, rd.Time.Format("15:04:05.000000"))
+		}
+		fmt.Fprintf(w, "  gcsfuse log (%d records):\n", len(f.Log))
+		for _, l := range f.Log {
+			fmt.Fprintf(w, "    %s\n", l)
+		}
+	}
+	if r.Diagnosis != "" {
+		_, err = fmt.Fprintf(w, "\nDiagnosis:\n%s\n", r.Diagnosis)
+	}
+	return err
+}
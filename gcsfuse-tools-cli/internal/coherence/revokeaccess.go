@@ -0,0 +1,184 @@
+package coherence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// RevokeAccessConfig holds the options of the permissions degradation
+// scenario.
+type RevokeAccessConfig struct {
+	// Dir is a directory of the mount the scenario files are created in.
+	Dir string
+	// RevokeCmd and RestoreCmd are run with sh -c to take the permissions of
+	// the mount's credentials away and to give them back, e.g. by removing
+	// and adding an IAM binding or by swapping the token gcsfuse reads.
+	RevokeCmd  string
+	RestoreCmd string
+	// Size and Seed define the pattern of the written file.
+	Size int64
+	Seed uint64
+	// ErrorTimeout bounds how long new operations may keep succeeding after
+	// RevokeCmd, and failing after RestoreCmd; IAM changes take a while to
+	// propagate.
+	ErrorTimeout time.Duration
+	// ProbeInterval is the pause between two probes.
+	ProbeInterval time.Duration
+}
+
+// RevokeAccess revokes the permissions of the mount with cfg.RevokeCmd while
+// a writer is halfway through overwriting a file. It checks that creating
+// new files then fails with EACCES (or EPERM) within cfg.ErrorTimeout,
+// restores the permissions with cfg.RestoreCmd and checks that the written
+// file holds either its old or its new content in full, the new one if
+// closing it succeeded.
+func RevokeAccess(ctx context.Context, cfg RevokeAccessConfig) (*Result, error) {
+	res := newResult("revoke-access", cfg.Dir)
+	res.Seed = cfg.Seed
+	writePath := filepath.Join(cfg.Dir, "revoke-access.write")
+	oldSeed, newSeed := cfg.Seed, cfg.Seed+1
+	if err := createPatternFile(writePath, cfg.Size, oldSeed); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", writePath, err)
+	}
+
+	var failures int32
+	fail := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Fprintf(os.Stderr, "FAILURE: %s\n", msg)
+		res.record(noOffset, msg)
+		failures++
+	}
+
+	revoked := make(chan struct{})
+	writeDone := make(chan error, 1)
+	halfWritten := make(chan struct{})
+	go func() {
+		writeDone <- writeAcross(writePath, cfg.Size, newSeed, halfWritten, revoked)
+	}()
+	select {
+	case <-halfWritten:
+	case err := <-writeDone:
+		return nil, fmt.Errorf("writing the first half of %s: %w", writePath, err)
+	}
+
+	fmt.Printf("Revoking access with: %s\n", cfg.RevokeCmd)
+	if err := runShell(ctx, cfg.RevokeCmd); err != nil {
+		return nil, fmt.Errorf("running revoke command: %w", err)
+	}
+	revokedAt := time.Now()
+	p := &prober{dir: cfg.Dir}
+	err := p.until(ctx, cfg, func(err error) bool { return err != nil })
+	switch {
+	case err == nil:
+		fail("creating files still succeeded %v after access was revoked", cfg.ErrorTimeout)
+	case isPermissionError(err):
+		fmt.Printf("[OK] creating a file failed %v after access was revoked: %s\n", time.Since(revokedAt).Round(time.Millisecond), describeErrno(err))
+	default:
+		fail("creating a file after access was revoked failed with %v, want EACCES or EPERM", err)
+	}
+
+	close(revoked)
+	writeErr := <-writeDone
+	if writeErr == nil {
+		fmt.Printf("%s was closed successfully without access\n", writePath)
+	} else {
+		fmt.Printf("Finishing %s without access failed: %s\n", writePath, describeErrno(writeErr))
+	}
+
+	fmt.Printf("Restoring access with: %s\n", cfg.RestoreCmd)
+	if err := runShell(ctx, cfg.RestoreCmd); err != nil {
+		return nil, fmt.Errorf("running restore command: %w", err)
+	}
+	restoredAt := time.Now()
+	if err := p.until(ctx, cfg, func(err error) bool { return err == nil }); err != nil {
+		fail("creating files still failed %v after access was restored: %s", cfg.ErrorTimeout, describeErrno(err))
+		return res.finish(failures), nil
+	}
+	fmt.Printf("[OK] creating files succeeded again %v after access was restored\n", time.Since(restoredAt).Round(time.Millisecond))
+	p.cleanup()
+
+	switch oldOff, err := patternMismatch(writePath, cfg.Size, oldSeed); {
+	case err != nil:
+		fail("reading %s after access was restored: %v", writePath, err)
+	case oldOff < 0 && writeErr == nil:
+		fail("closing %s succeeded but it holds its old content: the write was lost", writePath)
+	case oldOff < 0:
+		fmt.Printf("[OK] %s holds its old content\n", writePath)
+	default:
+		if newOff, _ := patternMismatch(writePath, cfg.Size, newSeed); newOff >= 0 {
+			fail("%s holds a partial object: old content up to offset %d, new content up to offset %d", writePath, oldOff, newOff)
+		} else {
+			fmt.Printf("[OK] %s holds its new content\n", writePath)
+		}
+	}
+	return res.finish(failures), nil
+}
+
+// prober creates new files in dir, which gcsfuse cannot serve from its
+// caches, to observe whether the mount has access to the bucket.
+type prober struct {
+	dir     string
+	n       int
+	created []string
+}
+
+// until probes every cfg.ProbeInterval until done accepts the error of a
+// probe or cfg.ErrorTimeout passes, and returns the last error.
+func (p *prober) until(ctx context.Context, cfg RevokeAccessConfig, done func(error) bool) error {
+	deadline := time.Now().Add(cfg.ErrorTimeout)
+	for {
+		err := p.probe()
+		if done(err) || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.ProbeInterval):
+		}
+	}
+}
+
+// probe creates, writes and closes a new file.
+func (p *prober) probe() error {
+	p.n++
+	path := filepath.Join(p.dir, fmt.Sprintf("revoke-access.probe-%d", p.n))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	p.created = append(p.created, path)
+	if _, err := f.WriteString("probe\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cleanup removes the probe files that may exist.
+func (p *prober) cleanup() {
+	for _, path := range p.created {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: removing %s: %v\n", path, err)
+		}
+	}
+}
+
+// isPermissionError reports whether err is EACCES or EPERM, how gcsfuse
+// surfaces requests GCS rejected with 401 or 403.
+func isPermissionError(err error) bool {
+	return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)
+}
+
+// runShell runs command with sh -c, forwarding its output.
+func runShell(ctx context.Context, command string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
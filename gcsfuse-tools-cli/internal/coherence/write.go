@@ -0,0 +1,170 @@
+package coherence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// DefaultContent is written when neither Content nor Size is set.
+const DefaultContent = "sample content"
+
+// WriteConfig holds the options of the write helper.
+type WriteConfig struct {
+	Path    string
+	Content string
+	// Size, when positive, replaces Content with that many bytes of the
+	// pattern generated for Seed, written chunk by chunk.
+	Size            int64
+	Seed            uint64
+	NoSync          bool
+	NoFlush         bool
+	Direct          bool
+	DuplicateWrites int
+}
+
+// writeDirectAligned pads the content to the next alignmentBlockSize multiple
+// and writes the entire padded buffer. This satisfies O_DIRECT length alignment.
+func writeDirectAligned(f *os.File, data []byte) (int, error) {
+	dataLen := len(data)
+	if dataLen%alignmentBlockSize == 0 {
+		return f.Write(data)
+	}
+	paddedSize := (dataLen + alignmentBlockSize - 1) / alignmentBlockSize * alignmentBlockSize
+
+	paddedData := make([]byte, paddedSize)
+	copy(paddedData, data)
+
+	n, err := f.Write(paddedData)
+	if err == nil {
+		fmt.Printf("Note: Wrote %d padded bytes, containing %d bytes of actual content.\n", n, dataLen)
+	}
+
+	return n, err
+}
+
+// alignedWriter writes through writeDirectAligned, so only a final partial
+// block of generated content is padded.
+type alignedWriter struct{ f *os.File }
+
+func (w alignedWriter) Write(p []byte) (int, error) {
+	return writeDirectAligned(w.f, p)
+}
+
+// Write writes the configured content to cfg.Path from DuplicateWrites
+// concurrent goroutines. With NoFlush the handles are left open and Write
+// blocks until SIGINT/SIGTERM. Failed writes are counted in the result.
+func Write(cfg WriteConfig) (*Result, error) {
+	res := newResult("write", cfg.Path)
+	res.Seed = cfg.Seed
+	data := []byte(cfg.Content)
+
+	// Start with flags for Write-Only, Create if not exists, and Truncate (overwrite)
+	openFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	isDirect := cfg.Direct
+
+	if isDirect {
+		if oDirect == 0 {
+			isDirect = false // Fallback
+			fmt.Fprintf(os.Stderr, "Warning: --direct flag used, but O_DIRECT is not supported on %s. Using standard flags.\n", runtime.GOOS)
+		} else {
+			openFlags |= oDirect
+			fmt.Printf("Attempting to open file '%s' with O_DIRECT (using aligned write padding).\n", cfg.Path)
+		}
+	} else {
+		fmt.Printf("Opening file '%s' with standard write flags.\n", cfg.Path)
+	}
+
+	numWrites := cfg.DuplicateWrites
+	if numWrites < 1 {
+		numWrites = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(numWrites)
+
+	var errorCount int32
+
+	fmt.Printf("Starting %d concurrent write(s) to '%s'\n", numWrites, cfg.Path)
+
+	for i := 0; i < numWrites; i++ {
+		go func(id int) {
+			defer wg.Done()
+
+			f, err := os.OpenFile(cfg.Path, openFlags, 0666)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[Thread %d] Error opening file '%s': %v\n", id, cfg.Path, err)
+				atomic.AddInt32(&errorCount, 1)
+				return
+			}
+
+			var w io.Writer = f
+			if isDirect {
+				w = alignedWriter{f}
+			}
+			var n int64
+			var writeErr error
+			if cfg.Size > 0 {
+				n, writeErr = writePattern(w, cfg.Size, cfg.Seed)
+			} else {
+				var m int
+				m, writeErr = w.Write(data)
+				n = int64(m)
+			}
+
+			if writeErr != nil {
+				fmt.Fprintf(os.Stderr, "[Thread %d] Error writing content: %v\n", id, writeErr)
+				atomic.AddInt32(&errorCount, 1)
+				if !cfg.NoFlush {
+					f.Close()
+				}
+				return
+			}
+
+			if !isDirect {
+				fmt.Printf("[Thread %d] Wrote %d bytes to file.\n", id, n)
+			}
+
+			if !cfg.NoSync {
+				if err := f.Sync(); err != nil {
+					fmt.Fprintf(os.Stderr, "[Thread %d] Error during file.Sync(): %v\n", id, err)
+					atomic.AddInt32(&errorCount, 1)
+				}
+			}
+
+			if !cfg.NoFlush {
+				if err := f.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "[Thread %d] Error during file.Close(): %v\n", id, err)
+					atomic.AddInt32(&errorCount, 1)
+					return
+				}
+			} else {
+				fmt.Printf("[Thread %d] Action: Skipping file.Close() (--no-flush is true). File handle remains OPEN.\n", id)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	fmt.Println("All write operations completed.")
+
+	if errorCount > 0 {
+		return res.finish(errorCount), nil
+	}
+
+	if cfg.NoFlush {
+		fmt.Println(">> Program is intentionally BLOCKING to keep the file descriptors open.")
+		fmt.Println(">> Waiting for interrupt signal (Ctrl+C) to exit...")
+
+		stopChan := make(chan os.Signal, 1)
+		signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+		<-stopChan
+
+		fmt.Println("\nInterrupt signal received. Exiting now.")
+	}
+	return res.finish(0), nil
+}
@@ -0,0 +1,250 @@
+// Package crash parses gcsfuse panic output and SIGQUIT goroutine dumps,
+// groups goroutines with identical stacks and flags known hang signatures.
+package crash
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Frame is one function call of a goroutine stack.
+type Frame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Goroutine is one goroutine of a dump.
+type Goroutine struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+	// WaitMinutes is how long the goroutine has been blocked, as reported by
+	// the runtime for waits of a minute or more.
+	WaitMinutes int     `json:"wait_minutes,omitempty"`
+	Frames      []Frame `json:"frames"`
+	CreatedBy   string  `json:"created_by,omitempty"`
+}
+
+// Dump is a parsed crash or goroutine dump.
+type Dump struct {
+	// Panic is the panic or fatal error message, empty for SIGQUIT dumps.
+	Panic      string       `json:"panic,omitempty"`
+	Goroutines []*Goroutine `json:"-"`
+}
+
+var (
+	// goroutine 42 [semacquire, 12 minutes]:
+	goroutineHeader = regexp.MustCompile(`goroutine (\d+) (?:gp=\S+ m=\S+ (?:mp=\S+ )?)?\[([^\]]+)\]:`)
+	// 	/go/src/sync/mutex.go:171 +0x15d
+	fileLine = regexp.MustCompile(`^\s+(\S+\.(?:go|s)):(\d+)`)
+)
+
+// Parse reads a dump from r. The input may be a whole gcsfuse log: lines
+// before the dump are skipped, except that the last panic or fatal error
+// message before it is kept.
+func Parse(r io.Reader) (*Dump, error) {
+	d := &Dump{}
+	var g *Goroutine
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 1<<20), 16<<20)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if g == nil {
+			for _, prefix := range []string{"panic: ", "fatal error: "} {
+				if i := strings.Index(trimmed, prefix); i >= 0 {
+					d.Panic = trimmed[i:]
+				}
+			}
+		}
+		if m := goroutineHeader.FindStringSubmatch(line); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			g = &Goroutine{ID: id}
+			g.State, g.WaitMinutes = parseState(m[2])
+			d.Goroutines = append(d.Goroutines, g)
+			continue
+		}
+		if g == nil || trimmed == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "created by "):
+			g.CreatedBy = strings.Fields(strings.TrimPrefix(trimmed, "created by "))[0]
+		case fileLine.MatchString(line):
+			m := fileLine.FindStringSubmatch(line)
+			if n := len(g.Frames); n > 0 && g.Frames[n-1].File == "" {
+				g.Frames[n-1].File = m[1]
+				g.Frames[n-1].Line, _ = strconv.Atoi(m[2])
+			}
+		case !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") && strings.HasSuffix(trimmed, ")"):
+			// The argument list is the last parenthesized group; receivers
+			// such as (*Cache) come before it.
+			g.Frames = append(g.Frames, Frame{Func: trimmed[:strings.LastIndex(trimmed, "(")]})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(d.Goroutines) == 0 {
+		return nil, fmt.Errorf("no goroutine dump found")
+	}
+	return d, nil
+}
+
+// parseState splits "semacquire, 12 minutes" into the state and wait time.
+func parseState(s string) (string, int) {
+	parts := strings.Split(s, ", ")
+	state := parts[0]
+	wait := 0
+	for _, p := range parts[1:] {
+		if n, ok := strings.CutSuffix(p, " minutes"); ok {
+			wait, _ = strconv.Atoi(n)
+		}
+	}
+	return state, wait
+}
+
+// Cluster is a group of goroutines with the same state and stack.
+type Cluster struct {
+	State          string  `json:"state"`
+	Count          int     `json:"count"`
+	MaxWaitMinutes int     `json:"max_wait_minutes,omitempty"`
+	Frames         []Frame `json:"frames"`
+	CreatedBy      string  `json:"created_by,omitempty"`
+	// IDs holds up to the first ten goroutine IDs of the cluster.
+	IDs []int `json:"ids"`
+}
+
+// ClusterGoroutines groups goroutines by state and function stack, largest
+// clusters first.
+func ClusterGoroutines(gs []*Goroutine) []*Cluster {
+	byKey := map[string]*Cluster{}
+	var out []*Cluster
+	for _, g := range gs {
+		var key strings.Builder
+		key.WriteString(g.State)
+		for _, f := range g.Frames {
+			key.WriteString("|" + f.Func)
+		}
+		c := byKey[key.String()]
+		if c == nil {
+			c = &Cluster{State: g.State, Frames: g.Frames, CreatedBy: g.CreatedBy}
+			byKey[key.String()] = c
+			out = append(out, c)
+		}
+		c.Count++
+		c.MaxWaitMinutes = max(c.MaxWaitMinutes, g.WaitMinutes)
+		if len(c.IDs) < 10 {
+			c.IDs = append(c.IDs, g.ID)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b *Cluster) int { return cmp.Compare(b.Count, a.Count) })
+	return out
+}
+
+// Report is the crash-analyze result.
+type Report struct {
+	Panic      string     `json:"panic,omitempty"`
+	Goroutines int        `json:"goroutines"`
+	Clusters   []*Cluster `json:"clusters"`
+	Findings   []Finding  `json:"findings"`
+	// Summary is the Gemini summary, when requested.
+	Summary string `json:"summary,omitempty"`
+
+	// topClusters and maxFrames limit the text rendering.
+	topClusters, maxFrames int
+}
+
+// Analyze clusters the dump and matches the known signatures.
+func Analyze(d *Dump) *Report {
+	r := &Report{Panic: d.Panic, Goroutines: len(d.Goroutines), topClusters: 10, maxFrames: 12}
+	r.Clusters = ClusterGoroutines(d.Goroutines)
+	r.Findings = match(d, r.Clusters)
+	return r
+}
+
+// SetTextLimits bounds how many clusters and frames per cluster WriteText
+// prints. The JSON output is not limited.
+func (r *Report) SetTextLimits(clusters, frames int) {
+	r.topClusters, r.maxFrames = clusters, frames
+}
+
+// Prompt renders the report as the input of a Gemini summary.
+func (r *Report) Prompt() string {
+	var b strings.Builder
+	b.WriteString(`You are a gcsfuse engineer. Below is an analysis of a gcsfuse crash or
+goroutine dump: the panic message if any, detected hang signatures and the
+largest goroutine clusters with their stacks. Explain in a few sentences what
+gcsfuse was doing, the most likely root cause (deadlock, stuck GCS request,
+panic) and what to look at next. Be concise.
+
+`)
+	c := *r
+	c.SetTextLimits(15, 20)
+	c.WriteText(&b)
+	return b.String()
+}
+
+// WriteText prints the findings and the largest clusters with their stacks.
+func (r *Report) WriteText(w io.Writer) error {
+	if r.Panic != "" {
+		fmt.Fprintf(w, "Panic: %s\n", r.Panic)
+	}
+	fmt.Fprintf(w, "%d goroutines in %d distinct stacks.\n\n", r.Goroutines, len(r.Clusters))
+	if len(r.Findings) > 0 {
+		fmt.Fprintln(w, "Known signatures:")
+		for _, f := range r.Findings {
+			fmt.Fprintf(w, "  [%s] %s: %d goroutine(s). %s\n", f.Severity, f.Signature, f.Goroutines, f.Description)
+		}
+		fmt.Fprintln(w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, c := range r.Clusters {
+		if i == r.topClusters {
+			fmt.Fprintf(tw, "... %d more clusters\n", len(r.Clusters)-i)
+			break
+		}
+		wait := ""
+		if c.MaxWaitMinutes > 0 {
+			wait = fmt.Sprintf(", up to %d minutes", c.MaxWaitMinutes)
+		}
+		fmt.Fprintf(tw, "%d goroutine(s) [%s%s] e.g. %v\n", c.Count, c.State, wait, c.IDs[:min(3, len(c.IDs))])
+		for j, f := range c.Frames {
+			if j == r.maxFrames {
+				fmt.Fprintf(tw, "\t...\n")
+				break
+			}
+			fmt.Fprintf(tw, "\t%s\t%s:%d\n", f.Func, shortPath(f.File), f.Line)
+		}
+		if c.CreatedBy != "" {
+			fmt.Fprintf(tw, "\tcreated by %s\n", c.CreatedBy)
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if r.Summary != "" {
+		fmt.Fprintf(w, "Summary:\n%s\n", r.Summary)
+	}
+	return nil
+}
+
+// shortPath trims a source path to the part after the module cache or GOROOT.
+func shortPath(p string) string {
+	for _, marker := range []string{"/pkg/mod/", "/src/"} {
+		if i := strings.LastIndex(p, marker); i >= 0 {
+			return p[i+len(marker):]
+		}
+	}
+	return p
+}
@@ -0,0 +1,141 @@
+package crash
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Finding severities.
+const (
+	Critical = "critical"
+	Warning  = "warning"
+)
+
+// Finding is a known signature matched by the dump.
+type Finding struct {
+	Signature   string `json:"signature"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Goroutines  int    `json:"goroutines"`
+}
+
+// signature describes a known hang or crash pattern. A cluster matches when
+// its state matches States (if set), some frame matches Stack and it has
+// waited at least MinWaitMinutes.
+type signature struct {
+	Name           string
+	Severity       string
+	Description    string
+	States         []string
+	Stack          *regexp.Regexp
+	MinWaitMinutes int
+	// MinGoroutines is the number of matching goroutines needed to report.
+	MinGoroutines int
+	// Panic, when set, matches the panic message instead of the clusters.
+	Panic *regexp.Regexp
+}
+
+var lockStates = []string{"semacquire", "sync.Mutex.Lock", "sync.RWMutex.Lock", "sync.RWMutex.RLock"}
+
+var signatures = []signature{
+	{
+		Name:        "cache lock deadlock",
+		Severity:    Critical,
+		Description: "Goroutines have been blocked for minutes acquiring a lock inside the file, metadata or LRU cache; look for the goroutine holding it in the same cache package.",
+		States:      lockStates,
+		Stack:       regexp.MustCompile(`/internal/cache/`),
+		// Short waits are normal contention.
+		MinWaitMinutes: 1,
+		MinGoroutines:  1,
+	},
+	{
+		Name:           "inode lock contention",
+		Severity:       Warning,
+		Description:    "Many FUSE ops are queued on an inode lock, usually behind a slow GCS call made while holding it.",
+		States:         lockStates,
+		Stack:          regexp.MustCompile(`/internal/fs/inode\.|\(\*fileSystem\)\.lock|/internal/fs/.*\.Lock`),
+		MinWaitMinutes: 1,
+		MinGoroutines:  10,
+	},
+	{
+		Name:           "stuck GCS requests",
+		Severity:       Warning,
+		Description:    "Goroutines have waited minutes on network I/O to Cloud Storage; check read-stall retries, connection limits and egress.",
+		States:         []string{"IO wait", "select"},
+		Stack:          regexp.MustCompile(`/internal/storage/|cloud\.google\.com/go/storage`),
+		MinWaitMinutes: 5,
+		MinGoroutines:  1,
+	},
+	{
+		Name:          "FUSE ops backlog",
+		Severity:      Warning,
+		Description:   "A large number of FUSE op handlers are alive at once, meaning the kernel keeps sending ops faster than gcsfuse completes them.",
+		Stack:         regexp.MustCompile(`jacobsa/fuse.*\.handleOp|/internal/fs/wrappers`),
+		MinGoroutines: 500,
+	},
+	{
+		Name:        "nil pointer dereference",
+		Severity:    Critical,
+		Description: "gcsfuse panicked on a nil pointer; the innermost gcsfuse frame of the panicking goroutine is the bug location.",
+		Panic:       regexp.MustCompile(`nil pointer dereference`),
+	},
+	{
+		Name:        "concurrent map access",
+		Severity:    Critical,
+		Description: "The runtime detected unsynchronized map access, a data race in gcsfuse.",
+		Panic:       regexp.MustCompile(`concurrent map (read and map write|writes|iteration and map write)`),
+	},
+	{
+		Name:        "out of memory",
+		Severity:    Critical,
+		Description: "The Go runtime could not allocate memory; check cache sizes against the VM or sidecar memory limit.",
+		Panic:       regexp.MustCompile(`out of memory|cannot allocate memory`),
+	},
+}
+
+// match returns the findings of all signatures matched by d.
+func match(d *Dump, clusters []*Cluster) []Finding {
+	var out []Finding
+	for _, sig := range signatures {
+		if sig.Panic != nil {
+			if d.Panic != "" && sig.Panic.MatchString(d.Panic) {
+				out = append(out, Finding{sig.Name, sig.Severity, sig.Description, 1})
+			}
+			continue
+		}
+		n := 0
+		for _, c := range clusters {
+			if sig.matches(c) {
+				n += c.Count
+			}
+		}
+		if n > 0 && n >= sig.MinGoroutines {
+			out = append(out, Finding{sig.Name, sig.Severity, sig.Description, n})
+		}
+	}
+	return out
+}
+
+func (sig *signature) matches(c *Cluster) bool {
+	if len(sig.States) > 0 && !hasPrefixAny(c.State, sig.States) {
+		return false
+	}
+	if c.MaxWaitMinutes < sig.MinWaitMinutes {
+		return false
+	}
+	for _, f := range c.Frames {
+		if sig.Stack.MatchString(f.File) || sig.Stack.MatchString(f.Func) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixAny(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
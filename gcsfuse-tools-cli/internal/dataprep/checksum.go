@@ -0,0 +1,100 @@
+package dataprep
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+
+	"cloud.google.com/go/storage"
+)
+
+// Supported --checksum values.
+const (
+	// ChecksumAuto leaves checksumming to the client library, which computes
+	// the CRC32C of the uploaded data on the fly and sends it with the last
+	// request.
+	ChecksumAuto = "auto"
+	// ChecksumCRC32C and ChecksumMD5 compute the checksum of every upload in
+	// a pass over the content before sending it, so GCS rejects the upload
+	// unless it stored exactly that content.
+	ChecksumCRC32C = "crc32c"
+	ChecksumMD5    = "md5"
+	// ChecksumNone sends no checksum and does not verify copies.
+	ChecksumNone = "none"
+)
+
+// validChecksum reports an unsupported --checksum value.
+func validChecksum(mode string) error {
+	switch mode {
+	case "", ChecksumAuto, ChecksumCRC32C, ChecksumMD5, ChecksumNone:
+		return nil
+	}
+	return fmt.Errorf("unsupported --checksum %q", mode)
+}
+
+// checksumMode returns the --checksum of cfg, ChecksumAuto if unset.
+func (c *Config) checksumMode() string {
+	if c.Checksum == "" {
+		return ChecksumAuto
+	}
+	return c.Checksum
+}
+
+// checksums are the precomputed checksums of an upload.
+type checksums struct {
+	crc32c uint32
+	md5    []byte
+}
+
+// precompute returns the checksums --checksum needs of the size bytes of
+// content at offset off, or nil if it needs none.
+func precompute(ctx context.Context, off, size int64, cfg Config) (*checksums, error) {
+	mode := cfg.checksumMode()
+	if mode != ChecksumCRC32C && mode != ChecksumMD5 {
+		return nil, nil
+	}
+	crc, sum := crc32.New(crc32.MakeTable(crc32.Castagnoli)), md5.New()
+	gen := newContentGen(cfg, off)
+	buf := make([]byte, min(size, writeChunkSize))
+	for remaining := size; remaining > 0; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n := min(remaining, int64(len(buf)))
+		gen.fill(buf[:n])
+		if mode == ChecksumCRC32C {
+			crc.Write(buf[:n])
+		} else {
+			sum.Write(buf[:n])
+		}
+		remaining -= n
+	}
+	return &checksums{crc32c: crc.Sum32(), md5: sum.Sum(nil)}, nil
+}
+
+// setChecksums configures w, before its first write, to send the checksums
+// of --checksum.
+func setChecksums(w *storage.Writer, sums *checksums, cfg Config) {
+	switch cfg.checksumMode() {
+	case ChecksumCRC32C:
+		w.CRC32C, w.SendCRC32C = sums.crc32c, true
+	case ChecksumMD5:
+		w.MD5 = sums.md5
+		w.DisableAutoChecksum = true
+	case ChecksumNone:
+		w.DisableAutoChecksum = true
+	}
+}
+
+// verifyCopy checks that the copy dst of the object src has its CRC32C,
+// which GCS computes for every object, unless --checksum is none.
+func verifyCopy(dst, src *storage.ObjectAttrs, cfg Config) error {
+	if cfg.checksumMode() == ChecksumNone || src == nil {
+		return nil
+	}
+	if dst.CRC32C != src.CRC32C {
+		return fmt.Errorf("copy %s has CRC32C %08x instead of %08x of %s", dst.Name, dst.CRC32C, src.CRC32C, src.Name)
+	}
+	return nil
+}
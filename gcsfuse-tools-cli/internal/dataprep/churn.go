@@ -0,0 +1,360 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Churn operations.
+const (
+	ChurnCreate    = "create"
+	ChurnOverwrite = "overwrite"
+	ChurnDelete    = "delete"
+)
+
+// ChurnMix is the percentage of churn operations of each kind.
+type ChurnMix struct {
+	Create, Overwrite, Delete int
+}
+
+// ParseChurnMix parses "create=30,overwrite=40,delete=30". Omitted kinds are
+// 0 and the percentages must add up to 100.
+func ParseChurnMix(s string) (ChurnMix, error) {
+	var m ChurnMix
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(v)
+		if !ok || err != nil || n < 0 {
+			return m, fmt.Errorf("invalid churn mix entry %q, want op=percent", part)
+		}
+		switch k {
+		case ChurnCreate:
+			m.Create = n
+		case ChurnOverwrite:
+			m.Overwrite = n
+		case ChurnDelete:
+			m.Delete = n
+		default:
+			return m, fmt.Errorf("unknown churn operation %q (want create, overwrite or delete)", k)
+		}
+	}
+	if m.Create+m.Overwrite+m.Delete != 100 {
+		return m, fmt.Errorf("churn mix %q does not add up to 100", s)
+	}
+	return m, nil
+}
+
+// pick draws an operation according to the mix.
+func (m ChurnMix) pick(rng *rand.Rand) string {
+	switch n := rng.IntN(100); {
+	case n < m.Create:
+		return ChurnCreate
+	case n < m.Create+m.Overwrite:
+		return ChurnOverwrite
+	default:
+		return ChurnDelete
+	}
+}
+
+// maxChurnRate bounds the churn rate, so the interval between operations
+// stays well above the resolution of the ticker dispatching them.
+const maxChurnRate = 100_000
+
+// ParseRate parses an operation rate like "50/s", "600/m" or "50" (per
+// second) into operations per second.
+func ParseRate(s string) (float64, error) {
+	num, unit, _ := strings.Cut(s, "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("invalid rate %q, want N/s, N/m or N/h", s)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, want a positive number of operations", s)
+	}
+	return n / per.Seconds(), nil
+}
+
+// churnPool tracks which objects churn may touch. Overwrites and deletes
+// target ChurnPercent of the dataset objects; deletes prefer objects churn
+// created itself and creates restore deleted dataset objects first, so the
+// dataset drifts around its original shape instead of draining. New objects
+// are further files of the jobs, named like the dataset's.
+type churnPool struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	live    []string // eligible dataset objects that exist
+	deleted []string // eligible dataset objects churn deleted
+	created []string // objects churn created under new names
+	next    int
+	// ours holds the names churn made up, taken or not.
+	ours map[string]bool
+	name func(j, file int) string
+	jobs int
+	// files is the number of files of every job in the dataset.
+	files int
+}
+
+// churnEligible returns the number of dataset objects churn may touch.
+func (c *Config) churnEligible() int {
+	return max(1, c.NumJobs*c.NrFiles*c.ChurnPercent/100)
+}
+
+func newChurnPool(cfg Config) *churnPool {
+	p := &churnPool{
+		rng:   rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0)),
+		ours:  map[string]bool{},
+		name:  cfg.objectName,
+		jobs:  cfg.NumJobs,
+		files: cfg.NrFiles,
+	}
+	total := cfg.NumJobs * cfg.NrFiles
+	for _, i := range p.rng.Perm(total)[:cfg.churnEligible()] {
+		p.live = append(p.live, cfg.objectName(i/cfg.NrFiles, i%cfg.NrFiles))
+	}
+	return p
+}
+
+// take picks the object for op and reserves it, so concurrent operations do
+// not pick it too, until finish records the outcome. It returns "" when
+// there is nothing to operate on.
+func (p *churnPool) take(op string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch op {
+	case ChurnCreate:
+		if n := len(p.deleted); n > 0 {
+			name := p.deleted[n-1]
+			p.deleted = p.deleted[:n-1]
+			return name
+		}
+		// Round robin over the jobs, after their dataset files.
+		name := p.name(p.next%p.jobs, p.files+p.next/p.jobs)
+		p.next++
+		p.ours[name] = true
+		return name
+	case ChurnOverwrite:
+		return p.takeLive()
+	default:
+		if n := len(p.created); n > 0 {
+			name := p.created[n-1]
+			p.created = p.created[:n-1]
+			return name
+		}
+		return p.takeLive()
+	}
+}
+
+// takeLive takes a random object out of live, or returns "" if there is
+// none.
+func (p *churnPool) takeLive() string {
+	if len(p.live) == 0 {
+		return ""
+	}
+	i := p.rng.IntN(len(p.live))
+	name := p.live[i]
+	p.live[i] = p.live[len(p.live)-1]
+	p.live = p.live[:len(p.live)-1]
+	return name
+}
+
+// finish moves the object take returned for op to where it is now: on
+// success to its new state, on failure back to where it was taken from, so
+// the pool keeps matching the bucket.
+func (p *churnPool) finish(op, name string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ours := p.ours[name]
+	switch {
+	case op == ChurnOverwrite:
+		// The object exists whether or not the overwrite succeeded.
+		p.live = append(p.live, name)
+	case op == ChurnCreate && ours:
+		if ok {
+			p.created = append(p.created, name)
+		}
+	case op == ChurnCreate:
+		if ok {
+			p.live = append(p.live, name)
+		} else {
+			p.deleted = append(p.deleted, name)
+		}
+	case op == ChurnDelete && ours:
+		if !ok {
+			p.created = append(p.created, name)
+		}
+	case op == ChurnDelete:
+		if ok {
+			p.deleted = append(p.deleted, name)
+		} else {
+			p.live = append(p.live, name)
+		}
+	}
+}
+
+// churn creates, overwrites and deletes objects at cfg.ChurnRate operations
+// per second for cfg.Duration, or until ctx is cancelled. With
+// cfg.ChurnInterval it sweeps the eligible objects instead.
+func churn(ctx context.Context, client *storage.Client, cfg Config, s *Summary) error {
+	bucket := cfg.bucket(client)
+	pool := newChurnPool(cfg)
+	if cfg.ChurnInterval > 0 {
+		return sweep(ctx, bucket, pool.live, cfg, s)
+	}
+	slog.Info("Churning dataset", "bucket", cfg.Bucket, "rate_per_sec", cfg.ChurnRate, "duration", cfg.Duration,
+		"eligible_objects", len(pool.live), "mix", fmt.Sprintf("%+v", cfg.ChurnMix))
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var done, failed, skipped, written atomic.Int64
+	ph := s.phase("churn")
+	ops := make(chan string, cfg.Workers)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range ops {
+				name := pool.take(op)
+				if name == "" {
+					skipped.Add(1)
+					continue
+				}
+				obj := cfg.object(bucket, name)
+				var err error
+				if op == ChurnDelete {
+					err = deleteObject(ctx, obj, cfg)
+				} else {
+					err = writeObject(ctx, obj, cfg.FileSize, cfg)
+				}
+				pool.finish(op, name, err == nil)
+				if err != nil {
+					// Operations cut off by the end of the run are not failures.
+					if ctx.Err() == nil {
+						slog.Warn("Churn operation failed", "op", op, "object", name, "err", err)
+						failed.Add(1)
+					}
+					continue
+				}
+				done.Add(1)
+				if op != ChurnDelete {
+					written.Add(cfg.FileSize)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.ChurnRate))
+	defer ticker.Stop()
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 1))
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			select {
+			case ops <- cfg.ChurnMix.pick(rng):
+			default:
+				// All workers are busy: the bucket cannot keep up with the rate.
+				skipped.Add(1)
+			}
+		}
+	}
+	close(ops)
+	wg.Wait()
+
+	slog.Info("Churn finished", "operations", done.Load(), "failed", failed.Load(), "skipped", skipped.Load())
+	// end computes the MiB/s from Bytes.
+	ph.Objects, ph.Bytes, ph.Errors = done.Load(), written.Load(), failed.Load()
+	ph.end(nil)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// sweep overwrites every object of names once per cfg.ChurnInterval for
+// cfg.Duration, or until ctx is cancelled, so that each gets a new
+// generation per interval and cached metadata and file contents go stale at
+// a known pace. A sweep that takes longer than the interval is followed by
+// the next one right away.
+func sweep(ctx context.Context, bucket *storage.BucketHandle, names []string, cfg Config, s *Summary) error {
+	slog.Info("Sweeping dataset", "bucket", cfg.Bucket, "interval", cfg.ChurnInterval, "duration", cfg.Duration,
+		"eligible_objects", len(names))
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var done, failed, written atomic.Int64
+	ph := s.phase("churn")
+	ticker := time.NewTicker(cfg.ChurnInterval)
+	defer ticker.Stop()
+	for round := 1; ctx.Err() == nil; round++ {
+		start := time.Now()
+		work := make(chan string)
+		var wg sync.WaitGroup
+		for w := 0; w < cfg.Workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for name := range work {
+					if err := writeObject(ctx, cfg.object(bucket, name), cfg.FileSize, cfg); err != nil {
+						if ctx.Err() == nil {
+							slog.Warn("Churn overwrite failed", "object", name, "err", err)
+							failed.Add(1)
+						}
+						continue
+					}
+					done.Add(1)
+					written.Add(cfg.FileSize)
+				}
+			}()
+		}
+	feed:
+		for _, name := range names {
+			select {
+			case work <- name:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(work)
+		wg.Wait()
+
+		elapsed := time.Since(start)
+		slog.Info("Churn sweep finished", "round", round, "generations", done.Load(), "elapsed", elapsed.Round(time.Millisecond))
+		if elapsed > cfg.ChurnInterval && ctx.Err() == nil {
+			slog.Warn("Churn sweep took longer than --churn-interval; raise --workers or lower --churn-percent", "elapsed", elapsed.Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	slog.Info("Churn finished", "operations", done.Load(), "failed", failed.Load())
+	ph.Objects, ph.Bytes, ph.Errors = done.Load(), written.Load(), failed.Load()
+	ph.end(nil)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return ctx.Err()
+}
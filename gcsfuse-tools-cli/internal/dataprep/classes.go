@@ -0,0 +1,143 @@
+package dataprep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Class is one file-size class of a mixed dataset: NumJobs x NrFiles
+// objects of FileSize bytes named "<prefix>.<job>.<file>", in the layout of
+// the dataset.
+type Class struct {
+	Prefix   string `json:"prefix"`
+	FileSize int64  `json:"filesize"`
+	NumJobs  int    `json:"numjobs"`
+	NrFiles  int    `json:"nrfiles"`
+}
+
+// classFile is the format of --spec-file, YAML or JSON:
+//
+//	classes:
+//	  - {prefix: small, filesize: 4K, count: 1000}
+//	  - {prefix: medium, filesize: 128M, numjobs: 10, nrfiles: 10}
+//	  - {prefix: large, filesize: 10G, count: 10}
+//
+// count is short for numjobs: 1, nrfiles: count.
+type classFile struct {
+	Classes []struct {
+		Prefix   string `yaml:"prefix"`
+		FileSize string `yaml:"filesize"`
+		Count    int    `yaml:"count"`
+		NumJobs  int    `yaml:"numjobs"`
+		NrFiles  int    `yaml:"nrfiles"`
+	} `yaml:"classes"`
+}
+
+// LoadClasses reads the file-size classes of a --spec-file.
+func LoadClasses(path string) ([]Class, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f classFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(f.Classes) == 0 {
+		return nil, fmt.Errorf("%s has no classes", path)
+	}
+	classes := make([]Class, len(f.Classes))
+	for i, fc := range f.Classes {
+		size, err := units.ParseSize(fc.FileSize)
+		if err != nil {
+			return nil, fmt.Errorf("%s: class %q: parsing filesize: %w", path, fc.Prefix, err)
+		}
+		c := Class{Prefix: fc.Prefix, FileSize: size, NumJobs: fc.NumJobs, NrFiles: fc.NrFiles}
+		if fc.Count > 0 {
+			if fc.NumJobs > 0 || fc.NrFiles > 0 {
+				return nil, fmt.Errorf("%s: class %q: count cannot be combined with numjobs and nrfiles", path, fc.Prefix)
+			}
+			c.NumJobs, c.NrFiles = 1, fc.Count
+		}
+		classes[i] = c
+	}
+	return classes, nil
+}
+
+// validClasses reports unusable classes. Prefixes name the objects, so they
+// must be unique and free of the separators of the layout.
+func validClasses(classes []Class) error {
+	seen := map[string]bool{}
+	for _, c := range classes {
+		if c.Prefix == "" {
+			return errors.New("every class needs a prefix")
+		}
+		if strings.ContainsAny(c.Prefix, "./") {
+			return fmt.Errorf("class prefix %q must not contain . or /", c.Prefix)
+		}
+		if seen[c.Prefix] {
+			return fmt.Errorf("class prefix %q is used twice", c.Prefix)
+		}
+		seen[c.Prefix] = true
+		if c.FileSize <= 0 {
+			return fmt.Errorf("class %s: filesize must be greater than 0", c.Prefix)
+		}
+		if c.NumJobs <= 0 || c.NrFiles <= 0 {
+			return fmt.Errorf("class %s: count, or numjobs and nrfiles, must be greater than 0", c.Prefix)
+		}
+	}
+	return nil
+}
+
+// forClass returns the configuration of the objects of class cl alone.
+func (c Config) forClass(cl Class) Config {
+	c.Classes = nil
+	c.prefix = cl.Prefix
+	c.FileSize, c.NumJobs, c.NrFiles = cl.FileSize, cl.NumJobs, cl.NrFiles
+	return c
+}
+
+// parts returns the single-class configurations of c: one per class of a
+// mixed dataset, and c itself otherwise.
+func (c Config) parts() []Config {
+	if len(c.Classes) == 0 {
+		return []Config{c}
+	}
+	parts := make([]Config, len(c.Classes))
+	for i, cl := range c.Classes {
+		parts[i] = c.forClass(cl)
+	}
+	return parts
+}
+
+// namePrefix is the first component of the object names: the class prefix
+// of a mixed dataset, and the bench type otherwise.
+func (c *Config) namePrefix() string {
+	if c.prefix != "" {
+		return c.prefix
+	}
+	return c.BenchType
+}
+
+// phaseName qualifies the name of a summary phase with the class, if any.
+func (c *Config) phaseName(name string) string {
+	if c.prefix == "" {
+		return name
+	}
+	return name + ":" + c.prefix
+}
+
+// TotalBytes returns the size of all dataset objects setup creates.
+func (c *Config) TotalBytes() int64 {
+	var n int64
+	for _, p := range c.parts() {
+		n += p.ObjectCount() * p.FileSize
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// Supported --client-protocol values.
+const (
+	// ProtocolHTTP is the JSON API over HTTP, the client library's default.
+	ProtocolHTTP = "http"
+	// ProtocolGRPC is the gRPC API, over a pool of GRPCConnPool connections.
+	ProtocolGRPC = "grpc"
+)
+
+// validProtocol reports an unsupported --client-protocol or connection pool
+// size.
+func (c *Config) validProtocol() error {
+	switch c.Protocol {
+	case "", ProtocolHTTP:
+		if c.GRPCConnPool != 0 {
+			return errors.New("--grpc-conn-pool-size needs --client-protocol=grpc")
+		}
+	case ProtocolGRPC:
+		if c.GRPCConnPool < 0 {
+			return errors.New("--grpc-conn-pool-size must not be negative")
+		}
+	default:
+		return fmt.Errorf("unsupported --client-protocol %q", c.Protocol)
+	}
+	return nil
+}
+
+// protocol returns the --client-protocol of cfg, ProtocolHTTP if unset.
+func (c *Config) protocol() string {
+	if c.Protocol == "" {
+		return ProtocolHTTP
+	}
+	return c.Protocol
+}
+
+// NewClient creates the storage client of cfg, speaking its protocol, so
+// that the same dataset can be created over both transports and their
+// upload throughput compared.
+func NewClient(ctx context.Context, cfg Config) (*storage.Client, error) {
+	if cfg.protocol() != ProtocolGRPC {
+		return storage.NewClient(ctx)
+	}
+	var opts []option.ClientOption
+	if cfg.GRPCConnPool > 0 {
+		opts = append(opts, option.WithGRPCConnectionPool(cfg.GRPCConnPool))
+	}
+	slog.Info("Using the gRPC API", "conn_pool_size", cfg.GRPCConnPool)
+	return storage.NewGRPCClient(ctx, opts...)
+}
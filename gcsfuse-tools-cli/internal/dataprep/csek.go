@@ -0,0 +1,54 @@
+package dataprep
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// csekKeySize is the size of an AES-256 customer-supplied encryption key.
+const csekKeySize = 32
+
+// LoadEncryptionKey reads the base64 encoded AES-256 customer-supplied
+// encryption key (CSEK) of a --csek-key-file, e.g. one written by
+// openssl rand -base64 32.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if len(key) != csekKeySize {
+		return nil, fmt.Errorf("%s holds a %d byte key, want a %d byte AES-256 key", path, len(key), csekKeySize)
+	}
+	return key, nil
+}
+
+// object returns the handle of the object name in bucket, encrypted with
+// c.EncryptionKey if set. Reads, writes, copies and composes of CSEK objects
+// all need the key; listing, deleting and metadata updates do not.
+func (c *Config) object(bucket *storage.BucketHandle, name string) *storage.ObjectHandle {
+	obj := bucket.Object(name)
+	if len(c.EncryptionKey) > 0 {
+		obj = obj.Key(c.EncryptionKey)
+	}
+	return obj
+}
+
+// encryptionKeySHA256 returns the base64 encoded SHA-256 of
+// c.EncryptionKey, which GCS reports as the keySha256 of the objects
+// encrypted with it, or "" without a key.
+func (c *Config) encryptionKeySHA256() string {
+	if len(c.EncryptionKey) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(c.EncryptionKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,137 @@
+package dataprep
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+
+	"cloud.google.com/go/storage"
+)
+
+// Supported --data values.
+const (
+	DataZero = "zero"
+	// DataRandom is incompressible ChaCha8 output seeded with DataSeed, so
+	// no layer between GCS and the reader can compress it away.
+	DataRandom = "random"
+	// DataPattern stores the offset of every 8-byte word in the word, so a
+	// misplaced read is recognizable in a dump.
+	DataPattern = "pattern"
+)
+
+// validData reports an unsupported --data value.
+func validData(data string) error {
+	switch data {
+	case DataZero, DataRandom, DataPattern:
+		return nil
+	}
+	return fmt.Errorf("unsupported --data %q", data)
+}
+
+// randomBlock is the span of random content generated from one seed. The
+// generator is reseeded with (DataSeed, block index) at every block, so the
+// content at any offset can be produced without generating what precedes
+// it, e.g. by the parts of a parallel upload.
+const randomBlock = 8 << 20
+
+// contentGen generates the content of one object chunk by chunk, so objects
+// larger than memory can be written.
+type contentGen struct {
+	data string
+	seed uint64
+	rng  *rand.ChaCha8
+	off  int64
+}
+
+// newContentGen returns a generator of the content from offset off on.
+func newContentGen(cfg Config, off int64) *contentGen {
+	g := &contentGen{data: cfg.Data, seed: cfg.DataSeed, off: off}
+	if cfg.Data == DataRandom {
+		g.reseed(off / randomBlock)
+		if skip := off % randomBlock; skip > 0 {
+			g.rng.Read(make([]byte, skip))
+		}
+	}
+	return g
+}
+
+func (g *contentGen) reseed(block int64) {
+	var seed [32]byte
+	binary.LittleEndian.PutUint64(seed[:], g.seed)
+	binary.LittleEndian.PutUint64(seed[8:], uint64(block))
+	g.rng = rand.NewChaCha8(seed)
+}
+
+// fill sets buf to the next len(buf) bytes of the object.
+func (g *contentGen) fill(buf []byte) {
+	switch g.data {
+	case DataRandom:
+		for done := 0; done < len(buf); {
+			off := g.off + int64(done)
+			if off%randomBlock == 0 && off > 0 {
+				g.reseed(off / randomBlock)
+			}
+			n := min(len(buf)-done, int(randomBlock-off%randomBlock))
+			g.rng.Read(buf[done : done+n])
+			done += n
+		}
+	case DataPattern:
+		for i := range buf {
+			off := g.off + int64(i)
+			buf[i] = byte(uint64(off&^7) >> (8 * (off & 7)))
+		}
+	default:
+		clear(buf)
+	}
+	g.off += int64(len(buf))
+}
+
+// writeObject writes size bytes of cfg.Data content to obj, replacing it if
+// it exists.
+func writeObject(ctx context.Context, obj *storage.ObjectHandle, size int64, cfg Config) error {
+	return writeRange(ctx, obj, 0, size, cfg)
+}
+
+// writeRange writes the size bytes of cfg.Data content at offset off of the
+// object being generated to obj, checksummed by cfg.Checksum, uploading it
+// again after failures by cfg.Retry.
+func writeRange(ctx context.Context, obj *storage.ObjectHandle, off, size int64, cfg Config) error {
+	sums, err := precompute(ctx, off, size, cfg)
+	if err != nil {
+		return err
+	}
+	return cfg.retry.do(ctx, reqUpload, "uploading "+obj.ObjectName(), func() error {
+		return uploadRange(ctx, obj, off, size, sums, cfg)
+	})
+}
+
+// uploadRange is one attempt of writeRange.
+func uploadRange(ctx context.Context, obj *storage.ObjectHandle, off, size int64, sums *checksums, cfg Config) error {
+	if err := cfg.limiter.request(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := obj.NewWriter(ctx)
+	setChecksums(w, sums, cfg)
+	gen := newContentGen(cfg, off)
+	buf := make([]byte, min(size, writeChunkSize))
+	for remaining := size; remaining > 0; {
+		n := min(remaining, int64(len(buf)))
+		if err := cfg.limiter.transfer(ctx, n); err != nil {
+			return err
+		}
+		gen.fill(buf[:n])
+		if _, err := w.Write(buf[:n]); err != nil {
+			cfg.limiter.observe(err)
+			return err
+		}
+		remaining -= n
+	}
+	if err := w.Close(); err != nil {
+		cfg.limiter.observe(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,629 @@
+// Package dataprep creates and tears down the GCS datasets read by the
+// release benchmarks.
+//
+// A setup writes one source object, zero-filled by default, and server-side
+// copies it to NumJobs*NrFiles objects named "<bench_type>.<job>.<file>", which is fio's
+// default filename_format for a job named after the bench type. A mixed
+// dataset does the same for every file-size class of a --spec-file, naming
+// the objects after the class prefix.
+package dataprep
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Supported --op-type values.
+const (
+	OpSetup  = "setup"
+	OpDelete = "delete"
+	OpGrant  = "grant"
+	OpRevoke = "revoke"
+	OpChurn  = "churn"
+	OpVerify = "verify"
+	// OpInventory lists a bucket without modifying it, e.g. to check a
+	// third-party bucket before benchmarking it.
+	OpInventory = "inventory"
+	// OpChecksumVerify reads a sample of the dataset and checks it against
+	// the ManifestObject setup wrote.
+	OpChecksumVerify = "checksum-verify"
+)
+
+// Supported --bench-type values.
+const (
+	BenchRandRead   = "rand-read"
+	BenchSeqRead    = "seq-read"
+	BenchSmallFiles = "small-files"
+	BenchCheckpoint = "checkpoint"
+	// BenchWrite and BenchRandWrite prepare buckets for write benchmarks.
+	BenchWrite     = "write"
+	BenchRandWrite = "rand-write"
+)
+
+// Config holds the data-prep flags.
+type Config struct {
+	Project   string
+	Bucket    string
+	Location  string
+	OpType    string
+	BenchType string
+	FileSize  int64
+	NumJobs   int
+	NrFiles   int
+	// BillingProject, when set, is billed for the requests to the bucket,
+	// which requester pays buckets require.
+	BillingProject string
+	// Classes, when set, replace FileSize, NumJobs and NrFiles with one
+	// group of objects per file-size class, for a mixed dataset.
+	Classes []Class
+	Workers int
+	// UploadParallelism is the number of parts the source object is
+	// uploaded in concurrently and then composed from, at most 32.
+	UploadParallelism int
+	// MaxQPS and MaxBandwidth (bytes per second), when positive, cap the
+	// requests and the data that all workers send to a bucket together.
+	MaxQPS       float64
+	MaxBandwidth int64
+	// ProgressInterval is the period of the progress logs of copies and
+	// deletes; 0 disables them.
+	ProgressInterval time.Duration
+	// Prefill creates the objects of a write benchmark for it to overwrite.
+	Prefill bool
+	// Resume continues an interrupted setup in an existing bucket, copying
+	// only the objects that are missing or have the wrong size.
+	Resume bool
+	// SkipBucketCreate populates a bucket that must already exist, e.g. one
+	// with CMEK or requester pays whose lifecycle the run does not own.
+	SkipBucketCreate bool
+	// Data is the content of the objects: DataZero, DataRandom (seeded
+	// with DataSeed) or DataPattern.
+	Data     string
+	DataSeed uint64
+	// GrantMember, e.g. "serviceAccount:runner@p.iam.gserviceaccount.com",
+	// is given GrantRole on the bucket for GrantTTL by setup and grant.
+	GrantMember string
+	GrantRole   string
+	GrantTTL    time.Duration
+	// Hold ("event" or "temporary") and Retention, when set, are placed on
+	// every ProtectEvery-th file of each job by setup.
+	Hold         string
+	Retention    time.Duration
+	ProtectEvery int
+	// ChurnRate (operations per second), Duration, ChurnMix and
+	// ChurnPercent (of the dataset objects that may be overwritten or
+	// deleted) configure churn. ChurnInterval, instead of ChurnRate,
+	// overwrites all the eligible objects once per interval.
+	ChurnRate     float64
+	ChurnInterval time.Duration
+	Duration      time.Duration
+	ChurnMix      ChurnMix
+	ChurnPercent  int
+	// BucketType is BucketFlat or BucketHNS. DirDepth, when positive, nests
+	// every job's files in DirDepth levels of directories holding
+	// FilesPerDir files each.
+	BucketType  string
+	DirDepth    int
+	FilesPerDir int
+	// NameTemplate, when set, names the objects instead of the layout, with
+	// the placeholders {prefix} (the bench type or class prefix), {job},
+	// {file} and {size} (the file size, e.g. 128M).
+	NameTemplate string
+	// UniformAccess, when set, decides uniform bucket-level access of the
+	// created bucket; otherwise it is enabled only for grants.
+	UniformAccess *bool
+	// PublicAccessPrevention ("enforced" or "inherited") of the created
+	// bucket. Empty leaves the project's default.
+	PublicAccessPrevention string
+	// StorageClass, e.g. "NEARLINE", is the default storage class of the
+	// created bucket; empty leaves STANDARD. Autoclass lets GCS move the
+	// objects between classes by access instead.
+	StorageClass string
+	Autoclass    bool
+	// SoftDeleteRetention, when set, is the soft delete retention of the
+	// created bucket; 0 disables soft delete. Nil leaves the project's
+	// default.
+	SoftDeleteRetention *time.Duration
+	// Versioning creates the bucket with object versioning, so that the
+	// generations churn overwrites are kept as noncurrent versions.
+	Versioning bool
+	// ContentType and Metadata, when set, are set on every copy of setup.
+	// Mtime, when set, is stored as the MtimeKey of the first object and
+	// increases by MtimeStep from object to object, so gcsfuse lists the
+	// same mtimes in every run.
+	ContentType string
+	Metadata    map[string]string
+	Mtime       time.Time
+	MtimeStep   time.Duration
+	// DeletePrefix, when set, scopes delete to the objects (and folders)
+	// with this prefix and keeps the bucket, and inventory to the objects
+	// with this prefix. KeepBucket empties the bucket without deleting it.
+	DeletePrefix string
+	KeepBucket   bool
+	// RunID and Expiry label the created bucket, see bucketLabels. Force
+	// lets delete wipe a bucket without the labels.
+	RunID  string
+	Expiry time.Duration
+	Force  bool
+	// InventoryFormat is the format of an inventory: InventoryCSV or
+	// InventoryJSON.
+	InventoryFormat string
+	// Retry is how failed copies, deletes and uploads are retried.
+	Retry RetryPolicy
+	// Checksum is how uploads are checksummed, see ChecksumAuto, and
+	// whether copies are verified.
+	Checksum string
+	// Manifest makes setup write the ManifestObject, and Sample is the
+	// number of objects checksum-verify reads, or 0 for all.
+	Manifest bool
+	Sample   int
+	// Protocol is the API the client speaks, ProtocolHTTP or ProtocolGRPC,
+	// and GRPCConnPool the number of gRPC connections, 0 for the library
+	// default.
+	Protocol     string
+	GRPCConnPool int
+	// EncryptionKey, when set, is the AES-256 customer-supplied encryption
+	// key (CSEK) setup and churn write the objects with and checksum-verify
+	// reads them with.
+	EncryptionKey []byte
+	// EmitDir, when set, receives EmitFormat definitions of the bucket,
+	// grants and lifecycle rules after setup and grant.
+	EmitDir    string
+	EmitFormat string
+	// FioJobFile, when set, receives the JobFile of the dataset after
+	// setup, reading from FioDirectory.
+	FioJobFile   string
+	FioDirectory string
+	// Metrics, when set, receives the requests of the run, and is shared by
+	// the buckets of a fan-out.
+	Metrics *Metrics
+
+	// limiter and retry are shared by the workers of a run; Run sets them.
+	limiter *limiter
+	retry   *retrier
+	// prefix names the objects of one class of a mixed dataset.
+	prefix string
+	// source holds the attributes of the object populate copies, for
+	// verifyCopy.
+	source *storage.ObjectAttrs
+	// versioned is set by delete for buckets with object versioning.
+	versioned bool
+	// manifest collects the checksums of the classes setup creates.
+	manifest *ChecksumManifest
+}
+
+// Validate reports missing or out-of-range flag values.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if c.MaxQPS < 0 || c.MaxBandwidth < 0 {
+		return errors.New("--max-qps and --max-bandwidth must not be negative")
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := validChecksum(c.Checksum); err != nil {
+		return err
+	}
+	if err := c.validProtocol(); err != nil {
+		return err
+	}
+	switch c.OpType {
+	case OpSetup:
+		if c.Project == "" {
+			return errors.New("--project is required for setup")
+		}
+		switch c.BenchType {
+		case BenchRandRead, BenchSeqRead, BenchSmallFiles, BenchCheckpoint, BenchWrite, BenchRandWrite:
+		default:
+			return fmt.Errorf("unsupported --bench-type %q", c.BenchType)
+		}
+		if err := c.validObjects(); err != nil {
+			return err
+		}
+		if len(c.Classes) > 0 && c.writes() {
+			return errors.New("--spec-file is not supported for write benchmarks")
+		}
+		if err := validData(c.Data); err != nil {
+			return err
+		}
+		switch c.Hold {
+		case "", HoldEvent, HoldTemporary:
+		default:
+			return fmt.Errorf("unsupported --hold %q", c.Hold)
+		}
+		if c.protects() && c.ProtectEvery <= 0 {
+			return errors.New("--protect-every must be greater than 0")
+		}
+		if c.protects() && !c.hasObjects() {
+			return errors.New("--hold and --retention need objects to protect: add --prefill")
+		}
+		if c.GrantMember != "" && c.GrantTTL <= 0 {
+			return errors.New("--grant-ttl must be greater than 0")
+		}
+		if c.GrantMember != "" && !c.uniformAccess() {
+			return errors.New("--grant-member needs --uniform-bucket-level-access, as conditional IAM bindings require it")
+		}
+		switch c.BucketType {
+		case BucketFlat:
+		case BucketHNS:
+			if !c.uniformAccess() {
+				return errors.New("--bucket-type=hns needs --uniform-bucket-level-access")
+			}
+			if c.Versioning {
+				return errors.New("--bucket-type=hns buckets do not support --versioning")
+			}
+		default:
+			return fmt.Errorf("unsupported --bucket-type %q", c.BucketType)
+		}
+		switch c.PublicAccessPrevention {
+		case "", PAPEnforced, PAPInherited:
+		default:
+			return fmt.Errorf("unsupported --public-access-prevention %q", c.PublicAccessPrevention)
+		}
+		switch c.StorageClass {
+		case "", StorageStandard, StorageNearline, StorageColdline, StorageArchive:
+		default:
+			return fmt.Errorf("unsupported --storage-class %q", c.StorageClass)
+		}
+		if c.Autoclass && c.StorageClass != "" && c.StorageClass != StorageStandard {
+			return errors.New("--autoclass buckets start in STANDARD; drop --storage-class")
+		}
+		if c.SkipBucketCreate {
+			if c.UniformAccess != nil || c.PublicAccessPrevention != "" || c.StorageClass != "" || c.Autoclass || c.SoftDeleteRetention != nil || c.Expiry > 0 || c.Versioning {
+				return errors.New("--skip-bucket-create cannot be combined with the bucket creation options --uniform-bucket-level-access, --public-access-prevention, --storage-class, --autoclass, --soft-delete-retention, --versioning and --expiry")
+			}
+		}
+		if c.Expiry < 0 {
+			return errors.New("--expiry must not be negative")
+		}
+		if err := c.validStamp(); err != nil {
+			return err
+		}
+		if c.FioJobFile != "" && c.DirDepth > 0 {
+			return errors.New("--fio-jobfile cannot describe the --dir-depth layout; use --name-template")
+		}
+		if d := c.SoftDeleteRetention; d != nil && *d != 0 && (*d < minSoftDelete || *d > maxSoftDelete) {
+			return errors.New("--soft-delete-retention must be 0, which disables soft delete, or between 168h (7 days) and 2160h (90 days)")
+		}
+	case OpGrant:
+		if c.GrantMember == "" {
+			return errors.New("--grant-member is required for grant")
+		}
+		if c.GrantTTL <= 0 {
+			return errors.New("--grant-ttl must be greater than 0")
+		}
+	case OpChurn:
+		if len(c.Classes) > 0 {
+			return errors.New("--spec-file is not supported with churn")
+		}
+		if c.FileSize <= 0 {
+			return errors.New("--filesize must be greater than 0")
+		}
+		if c.NumJobs <= 0 || c.NrFiles <= 0 {
+			return errors.New("--numjobs and --nrfiles must be greater than 0")
+		}
+		if err := validData(c.Data); err != nil {
+			return err
+		}
+		if c.ChurnInterval < 0 {
+			return errors.New("--churn-interval must not be negative")
+		}
+		if (c.ChurnRate > 0) == (c.ChurnInterval > 0) {
+			return errors.New("churn needs either --rate or --churn-interval")
+		}
+		if c.ChurnRate > maxChurnRate {
+			return fmt.Errorf("--rate must be at most %d/s", maxChurnRate)
+		}
+		if c.Duration <= 0 {
+			return errors.New("--duration must be greater than 0")
+		}
+		if c.ChurnPercent <= 0 || c.ChurnPercent > 100 {
+			return errors.New("--churn-percent must be between 1 and 100")
+		}
+	case OpVerify:
+		if err := c.validObjects(); err != nil {
+			return err
+		}
+	case OpChecksumVerify:
+		if err := c.validObjects(); err != nil {
+			return err
+		}
+		if c.Sample < 0 {
+			return errors.New("--sample must not be negative")
+		}
+	case OpInventory:
+		switch c.InventoryFormat {
+		case InventoryCSV, InventoryJSON:
+		default:
+			return fmt.Errorf("unsupported --inventory-format %q", c.InventoryFormat)
+		}
+	case OpDelete, OpRevoke:
+	default:
+		return fmt.Errorf("unsupported --op-type %q", c.OpType)
+	}
+	if c.DeletePrefix != "" && c.OpType != OpDelete && c.OpType != OpInventory {
+		return errors.New("--prefix is only supported with delete and inventory")
+	}
+	if c.FioJobFile != "" && c.OpType != OpSetup {
+		return errors.New("--fio-jobfile is only supported with setup")
+	}
+	if c.Force && c.OpType != OpDelete {
+		return errors.New("--force is only supported with delete")
+	}
+	if c.KeepBucket && c.OpType != OpDelete {
+		return errors.New("--keep-bucket is only supported with delete")
+	}
+	if len(c.EncryptionKey) > 0 {
+		switch c.OpType {
+		case OpSetup, OpChurn, OpChecksumVerify:
+		default:
+			return errors.New("--csek-key-file is only supported with setup, churn and checksum-verify")
+		}
+	}
+	if c.Workers <= 0 {
+		return errors.New("--workers must be greater than 0")
+	}
+	if c.UploadParallelism < 1 || c.UploadParallelism > maxUploadParallelism {
+		return fmt.Errorf("--upload-parallelism must be between 1 and %d", maxUploadParallelism)
+	}
+	if c.DirDepth < 0 {
+		return errors.New("--dir-depth must not be negative")
+	}
+	if c.DirDepth > 0 && c.FilesPerDir <= 0 {
+		return errors.New("--files-per-dir must be greater than 0")
+	}
+	if err := c.validNameTemplate(); err != nil {
+		return err
+	}
+	switch c.EmitFormat {
+	case EmitTerraform, EmitKCC:
+	default:
+		return fmt.Errorf("unsupported --emit-format %q", c.EmitFormat)
+	}
+	return nil
+}
+
+// validObjects reports an unusable object size or count, or unusable
+// classes.
+func (c *Config) validObjects() error {
+	if len(c.Classes) > 0 {
+		return validClasses(c.Classes)
+	}
+	if c.FileSize <= 0 {
+		return errors.New("--filesize must be greater than 0")
+	}
+	if c.NumJobs <= 0 || c.NrFiles <= 0 {
+		return errors.New("--numjobs and --nrfiles must be greater than 0")
+	}
+	return nil
+}
+
+// Supported --public-access-prevention values.
+const (
+	PAPEnforced  = "enforced"
+	PAPInherited = "inherited"
+)
+
+// Supported --storage-class values.
+const (
+	StorageStandard = "STANDARD"
+	StorageNearline = "NEARLINE"
+	StorageColdline = "COLDLINE"
+	StorageArchive  = "ARCHIVE"
+)
+
+// minSoftDelete and maxSoftDelete bound a soft delete retention other than
+// 0.
+const (
+	minSoftDelete = 7 * 24 * time.Hour
+	maxSoftDelete = 90 * 24 * time.Hour
+)
+
+// bucket returns the handle of the bucket of c, billing BillingProject if
+// set.
+func (c *Config) bucket(client *storage.Client) *storage.BucketHandle {
+	b := client.Bucket(c.Bucket)
+	if c.BillingProject != "" {
+		b = b.UserProject(c.BillingProject)
+	}
+	return b
+}
+
+// billingProject returns the project billed for the requests to a requester
+// pays bucket: BillingProject, or Project if unset.
+func (c *Config) billingProject() string {
+	if c.BillingProject != "" {
+		return c.BillingProject
+	}
+	return c.Project
+}
+
+// uniformAccess reports whether setup creates the bucket with uniform
+// bucket-level access. Conditional IAM bindings and hierarchical namespace
+// require it, so it defaults to on with a grant or HNS and to fine-grained
+// ACLs otherwise.
+func (c *Config) uniformAccess() bool {
+	if c.UniformAccess != nil {
+		return *c.UniformAccess
+	}
+	return c.GrantMember != "" || c.BucketType == BucketHNS
+}
+
+// Run executes the configured operation and summarizes it; the summary is
+// returned, up to the failure, even when the operation fails. Verify runs
+// OpVerify, ChecksumVerify OpChecksumVerify and Inventory OpInventory, as
+// they return reports.
+func Run(ctx context.Context, client *storage.Client, cfg Config) (*Summary, error) {
+	s := &Summary{OpType: cfg.OpType, Bucket: cfg.Bucket, Prefix: cfg.DeletePrefix, Protocol: cfg.protocol(), Start: time.Now()}
+	cfg.limiter = newLimiter(cfg)
+	cfg.retry = newRetrier(cfg.Retry)
+	cfg.retry.metrics, cfg.retry.bucket = cfg.Metrics, cfg.Bucket
+	if cfg.OpType == OpSetup {
+		cfg.Metrics.plan(cfg.Bucket, cfg.ObjectCount())
+	}
+	if cfg.OpType == OpSetup || cfg.OpType == OpChurn {
+		s.BenchType, s.Checksum = cfg.BenchType, cfg.checksumMode()
+	}
+	err := run(ctx, client, cfg, s)
+	s.Throttled = cfg.limiter.throttled.Load()
+	s.ErrorClasses, s.Retries = cfg.retry.counts()
+	s.finish(err)
+	if err != nil {
+		return s, err
+	}
+	slog.Info("Data prep completed", "op_type", cfg.OpType, "bucket", cfg.Bucket, "elapsed", s.End.Sub(s.Start).Round(time.Millisecond),
+		"throttled", s.Throttled)
+	return s, nil
+}
+
+func run(ctx context.Context, client *storage.Client, cfg Config, s *Summary) error {
+	var err error
+	switch cfg.OpType {
+	case OpSetup:
+		err = setup(ctx, client, cfg, s)
+	case OpDelete:
+		err = teardown(ctx, client, cfg, s)
+	case OpGrant:
+		err = timed(s, "grant", func() error { return grant(ctx, cfg.bucket(client), cfg) })
+	case OpRevoke:
+		err = timed(s, "revoke", func() error { return revoke(ctx, cfg.bucket(client), cfg) })
+	case OpChurn:
+		err = churn(ctx, client, cfg, s)
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.EmitDir != "" && (cfg.OpType == OpSetup || cfg.OpType == OpGrant) {
+		if err := timed(s, "emit", func() error { return emit(ctx, cfg.bucket(client), cfg) }); err != nil {
+			return fmt.Errorf("emitting infrastructure definitions: %w", err)
+		}
+	}
+	if cfg.FioJobFile != "" {
+		if err := writeJobFile(cfg); err != nil {
+			return fmt.Errorf("writing the fio jobfile: %w", err)
+		}
+	}
+	return nil
+}
+
+// Spec is the part of Config that determines the contents of a dataset. Two
+// setups with equal specs produce interchangeable datasets.
+type Spec struct {
+	BenchType string `json:"bench_type"`
+	FileSize  int64  `json:"filesize"`
+	NumJobs   int    `json:"numjobs"`
+	NrFiles   int    `json:"nrfiles"`
+	// Classes is only set for mixed datasets, whose FileSize, NumJobs and
+	// NrFiles are zero.
+	Classes  []Class `json:"classes,omitempty"`
+	Location string  `json:"location"`
+	// UniformAccess, PublicAccessPrevention, StorageClass and Autoclass are
+	// only set when chosen explicitly, so the hashes of other datasets do not
+	// change; the same goes for the optional fields below.
+	UniformAccess          *bool  `json:"uniform_bucket_level_access,omitempty"`
+	PublicAccessPrevention string `json:"public_access_prevention,omitempty"`
+	StorageClass           string `json:"storage_class,omitempty"`
+	Autoclass              bool   `json:"autoclass,omitempty"`
+	// Prefill is only set for write datasets, Data only for non-zero content
+	// and DataSeed only for random content.
+	Prefill  bool   `json:"prefill,omitempty"`
+	Data     string `json:"data,omitempty"`
+	DataSeed uint64 `json:"data_seed,omitempty"`
+	// BucketType is only set for HNS buckets, DirDepth and FilesPerDir
+	// only for nested layouts and NameTemplate only for custom names.
+	BucketType   string `json:"bucket_type,omitempty"`
+	DirDepth     int    `json:"dir_depth,omitempty"`
+	FilesPerDir  int    `json:"files_per_dir,omitempty"`
+	NameTemplate string `json:"name_template,omitempty"`
+	// Hold, Retention and ProtectEvery are only set for protected datasets.
+	Hold         string        `json:"hold,omitempty"`
+	Retention    time.Duration `json:"retention,omitempty"`
+	ProtectEvery int           `json:"protect_every,omitempty"`
+	// CSEKKeySHA256 is only set for datasets encrypted with a
+	// customer-supplied key, which it names without revealing it.
+	CSEKKeySHA256 string `json:"csek_key_sha256,omitempty"`
+}
+
+// Spec returns the dataset spec of c.
+func (c *Config) Spec() Spec {
+	s := Spec{
+		BenchType:              c.BenchType,
+		FileSize:               c.FileSize,
+		NumJobs:                c.NumJobs,
+		NrFiles:                c.NrFiles,
+		Location:               c.Location,
+		NameTemplate:           c.NameTemplate,
+		UniformAccess:          c.UniformAccess,
+		PublicAccessPrevention: c.PublicAccessPrevention,
+		StorageClass:           c.StorageClass,
+		Autoclass:              c.Autoclass,
+		CSEKKeySHA256:          c.encryptionKeySHA256(),
+	}
+	if len(c.Classes) > 0 {
+		s.FileSize, s.NumJobs, s.NrFiles, s.Classes = 0, 0, 0, c.Classes
+	}
+	if c.BenchType == BenchWrite {
+		s.Prefill = c.Prefill
+	}
+	if c.Data != DataZero {
+		s.Data = c.Data
+	}
+	if c.Data == DataRandom {
+		s.DataSeed = c.DataSeed
+	}
+	if c.BucketType == BucketHNS {
+		s.BucketType = c.BucketType
+	}
+	if c.DirDepth > 0 {
+		s.DirDepth, s.FilesPerDir = c.DirDepth, c.FilesPerDir
+	}
+	if c.protects() {
+		s.Hold, s.Retention, s.ProtectEvery = c.Hold, c.Retention, c.ProtectEvery
+	}
+	return s
+}
+
+// Config returns the configuration of a setup of s in bucket, e.g. to
+// Verify a registered dataset.
+func (s Spec) Config(bucket string) Config {
+	c := Config{
+		Bucket:       bucket,
+		OpType:       OpSetup,
+		BenchType:    s.BenchType,
+		FileSize:     s.FileSize,
+		NumJobs:      s.NumJobs,
+		NrFiles:      s.NrFiles,
+		Classes:      s.Classes,
+		Location:     s.Location,
+		Prefill:      s.Prefill,
+		Data:         s.Data,
+		DataSeed:     s.DataSeed,
+		BucketType:   s.BucketType,
+		DirDepth:     s.DirDepth,
+		FilesPerDir:  s.FilesPerDir,
+		NameTemplate: s.NameTemplate,
+	}
+	if c.Data == "" {
+		c.Data = DataZero
+	}
+	if c.BucketType == "" {
+		c.BucketType = BucketFlat
+	}
+	return c
+}
+
+// Hash returns "sha256:<hex>" of the JSON encoding of s.
+func (s Spec) Hash() string {
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
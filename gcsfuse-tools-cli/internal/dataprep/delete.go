@@ -0,0 +1,140 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// teardown deletes every object in the bucket, the folders of an HNS bucket
+// and then the bucket itself. With cfg.DeletePrefix only the objects and
+// folders under it are deleted, and with cfg.KeepBucket the bucket is kept.
+// Without a prefix, the bucket must have been created by setup, see
+// checkOwned.
+func teardown(ctx context.Context, client *storage.Client, cfg Config, s *Summary) error {
+	bucket := cfg.bucket(client)
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("reading attributes of %s: %w", cfg.Bucket, err)
+	}
+	s.Location = attrs.Location
+	if cfg.DeletePrefix == "" {
+		if err := checkOwned(attrs, cfg); err != nil {
+			return err
+		}
+	}
+	cfg.versioned = attrs.VersioningEnabled
+	if err := deleteObjectsParallel(ctx, bucket, cfg, s); err != nil {
+		return err
+	}
+	if attrs.HierarchicalNamespace != nil && attrs.HierarchicalNamespace.Enabled {
+		if err := timed(s, "delete-folders", func() error { return deleteFolders(ctx, cfg.Bucket, cfg.DeletePrefix) }); err != nil {
+			return err
+		}
+	}
+	if !cfg.deletesBucket() {
+		slog.Info("Keeping bucket", "bucket", cfg.Bucket, "prefix", cfg.DeletePrefix)
+		return nil
+	}
+
+	slog.Info("Deleting bucket", "bucket", cfg.Bucket)
+	return timed(s, "delete-bucket", func() error {
+		if err := bucket.Delete(ctx); err != nil {
+			return fmt.Errorf("deleting bucket %s: %w", cfg.Bucket, err)
+		}
+		return nil
+	})
+}
+
+// deletesBucket reports whether delete removes the bucket itself, rather
+// than the objects under a prefix or all objects of a kept bucket.
+func (c *Config) deletesBucket() bool {
+	return c.DeletePrefix == "" && !c.KeepBucket
+}
+
+// deleteObjectsParallel lists the bucket and deletes every object, or those
+// under cfg.DeletePrefix, with cfg.Workers concurrent workers, releasing
+// holds and unlocked retention first. In a versioned bucket every generation
+// is deleted, as noncurrent ones keep the bucket from being deleted.
+func deleteObjectsParallel(ctx context.Context, bucket *storage.BucketHandle, cfg Config, s *Summary) error {
+	objects := make(chan *storage.ObjectAttrs)
+	var deleted, failed atomic.Int64
+	ph := s.phase("delete-objects")
+	prog := startProgress("delete", bucket.BucketName(), 0, cfg.ProgressInterval)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for attrs := range objects {
+				obj := bucket.Object(attrs.Name)
+				if cfg.versioned {
+					obj = obj.Generation(attrs.Generation)
+				}
+				err := releaseObject(ctx, obj, attrs)
+				if err == nil {
+					err = deleteObject(ctx, obj, cfg)
+				}
+				if err != nil {
+					slog.Error("Delete failed", "object", attrs.Name, "err", err)
+					failed.Add(1)
+					prog.fail()
+					continue
+				}
+				deleted.Add(1)
+				prog.add(attrs.Size)
+			}
+		}()
+	}
+
+	it := bucket.Objects(ctx, &storage.Query{Prefix: cfg.DeletePrefix, Versions: cfg.versioned, Projection: storage.ProjectionNoACL})
+	var listErr error
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			listErr = fmt.Errorf("listing objects in %s: %w", bucket.BucketName(), err)
+			break
+		}
+		prog.addTotal(1)
+		objects <- attrs
+	}
+	prog.setFinal()
+	close(objects)
+	wg.Wait()
+	prog.finish()
+	ph.end(prog)
+
+	slog.Info("Deleted objects", "bucket", bucket.BucketName(), "prefix", cfg.DeletePrefix, "deleted", deleted.Load(), "failed", failed.Load())
+	if listErr != nil {
+		return listErr
+	}
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("failed to delete %d objects from %s", n, bucket.BucketName())
+	}
+	return nil
+}
+
+// deleteObject deletes obj within the request rate of cfg, retrying
+// failures by cfg.Retry. An object that is already gone counts as deleted.
+func deleteObject(ctx context.Context, obj *storage.ObjectHandle, cfg Config) error {
+	return cfg.retry.do(ctx, reqDelete, "deleting "+obj.ObjectName(), func() error {
+		if err := cfg.limiter.request(ctx); err != nil {
+			return err
+		}
+		err := obj.Delete(ctx)
+		if err == nil || errors.Is(err, storage.ErrObjectNotExist) {
+			return nil
+		}
+		cfg.limiter.observe(err)
+		return err
+	})
+}
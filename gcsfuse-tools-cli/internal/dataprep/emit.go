@@ -0,0 +1,318 @@
+package dataprep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported --emit-format values.
+const (
+	EmitTerraform = "terraform"
+	// EmitKCC writes Config Connector resources, which acquire the existing
+	// bucket and binding when applied.
+	EmitKCC = "kcc"
+)
+
+// infra is the created infrastructure as read back from the bucket, so that
+// the definitions match what exists rather than what was asked for.
+type infra struct {
+	project  string
+	attrs    *storage.BucketAttrs
+	bindings []*iampb.Binding
+}
+
+// emit writes the definitions of the bucket, its data-prep grants and its
+// lifecycle rules to cfg.EmitDir.
+func emit(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("reading attributes of %s: %w", bucket.BucketName(), err)
+	}
+	p, err := bucket.IAM().V3().Policy(ctx)
+	if err != nil {
+		return fmt.Errorf("reading IAM policy of %s: %w", bucket.BucketName(), err)
+	}
+	in := infra{project: cfg.Project, attrs: attrs}
+	if in.project == "" {
+		in.project = strconv.FormatUint(attrs.ProjectNumber, 10)
+	}
+	for _, b := range p.Bindings {
+		if b.GetCondition().GetTitle() == grantTitle {
+			in.bindings = append(in.bindings, b)
+		}
+	}
+
+	var b []byte
+	name := filepath.Join(cfg.EmitDir, attrs.Name)
+	switch cfg.EmitFormat {
+	case EmitKCC:
+		name += ".yaml"
+		b, err = in.kcc()
+	default:
+		name += ".tf"
+		b = in.terraform()
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.EmitDir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		return err
+	}
+	slog.Info("Wrote infrastructure definitions", "path", name, "format", cfg.EmitFormat)
+	return nil
+}
+
+var nonIdent = regexp.MustCompile(`[^a-z0-9_]`)
+
+// tfName turns a bucket name into a Terraform resource name.
+func tfName(bucket string) string {
+	return "bench_" + nonIdent.ReplaceAllString(strings.ToLower(bucket), "_")
+}
+
+// hclString quotes s as an HCL string, escaping template sequences.
+func hclString(s string) string {
+	s = strings.NewReplacer("${", "$${", "%{", "%%{").Replace(s)
+	return strconv.Quote(s)
+}
+
+func (in *infra) terraform() []byte {
+	a := in.attrs
+	res := tfName(a.Name)
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "# gcsfuse-tools dataprep created these resources on %s.\n", time.Now().UTC().Format(time.DateOnly))
+	fmt.Fprintf(&w, "# The import block (Terraform 1.5+) adopts the existing bucket.\n\n")
+	fmt.Fprintf(&w, "import {\n  to = google_storage_bucket.%s\n  id = %s\n}\n\n", res, hclString(in.project+"/"+a.Name))
+
+	fmt.Fprintf(&w, "resource \"google_storage_bucket\" %q {\n", res)
+	fmt.Fprintf(&w, "  name                        = %s\n", hclString(a.Name))
+	fmt.Fprintf(&w, "  project                     = %s\n", hclString(in.project))
+	fmt.Fprintf(&w, "  location                    = %s\n", hclString(a.Location))
+	fmt.Fprintf(&w, "  storage_class               = %s\n", hclString(a.StorageClass))
+	fmt.Fprintf(&w, "  uniform_bucket_level_access = %t\n", a.UniformBucketLevelAccess.Enabled)
+	if pap := a.PublicAccessPrevention; pap == storage.PublicAccessPreventionEnforced || pap == storage.PublicAccessPreventionInherited {
+		fmt.Fprintf(&w, "  public_access_prevention    = %s\n", hclString(pap.String()))
+	}
+	if a.ObjectRetentionMode == "Enabled" {
+		fmt.Fprintf(&w, "  enable_object_retention     = true\n")
+	}
+	if a.HierarchicalNamespace != nil && a.HierarchicalNamespace.Enabled {
+		fmt.Fprintf(&w, "\n  hierarchical_namespace {\n    enabled = true\n  }\n")
+	}
+	if a.Autoclass != nil && a.Autoclass.Enabled {
+		fmt.Fprintf(&w, "\n  autoclass {\n    enabled = true\n  }\n")
+	}
+	if a.VersioningEnabled {
+		fmt.Fprintf(&w, "\n  versioning {\n    enabled = true\n  }\n")
+	}
+	if a.SoftDeletePolicy != nil {
+		fmt.Fprintf(&w, "\n  soft_delete_policy {\n    retention_duration_seconds = %d\n  }\n", int64(a.SoftDeletePolicy.RetentionDuration.Seconds()))
+	}
+	for _, r := range a.Lifecycle.Rules {
+		fmt.Fprintf(&w, "\n  lifecycle_rule {\n    action {\n      type = %s\n", hclString(r.Action.Type))
+		if r.Action.StorageClass != "" {
+			fmt.Fprintf(&w, "      storage_class = %s\n", hclString(r.Action.StorageClass))
+		}
+		fmt.Fprintf(&w, "    }\n    condition {\n")
+		for _, kv := range lifecycleCondition(r.Condition) {
+			switch v := kv.value.(type) {
+			case string:
+				fmt.Fprintf(&w, "      %s = %s\n", kv.tf, hclString(v))
+			case []string:
+				q := make([]string, len(v))
+				for i, s := range v {
+					q[i] = hclString(s)
+				}
+				fmt.Fprintf(&w, "      %s = [%s]\n", kv.tf, strings.Join(q, ", "))
+			default:
+				fmt.Fprintf(&w, "      %s = %v\n", kv.tf, v)
+			}
+		}
+		fmt.Fprintf(&w, "    }\n  }\n")
+	}
+	fmt.Fprintf(&w, "}\n")
+
+	for i, b := range in.bindings {
+		for j, m := range b.Members {
+			fmt.Fprintf(&w, "\nresource \"google_storage_bucket_iam_member\" \"%s_grant_%d_%d\" {\n", res, i, j)
+			fmt.Fprintf(&w, "  bucket = google_storage_bucket.%s.name\n", res)
+			fmt.Fprintf(&w, "  role   = %s\n", hclString(b.Role))
+			fmt.Fprintf(&w, "  member = %s\n", hclString(m))
+			c := b.GetCondition()
+			fmt.Fprintf(&w, "\n  condition {\n    title       = %s\n    description = %s\n    expression  = %s\n  }\n}\n",
+				hclString(c.GetTitle()), hclString(c.GetDescription()), hclString(c.GetExpression()))
+		}
+	}
+	return w.Bytes()
+}
+
+// conditionField is a set lifecycle condition with its Terraform and KCC
+// names.
+type conditionField struct {
+	tf, kcc string
+	value   any
+}
+
+// lifecycleCondition lists the set fields of c.
+func lifecycleCondition(c storage.LifecycleCondition) []conditionField {
+	var fs []conditionField
+	add := func(tf, kcc string, v any) { fs = append(fs, conditionField{tf, kcc, v}) }
+	date := func(t time.Time) string { return t.Format(time.DateOnly) }
+	if c.AgeInDays > 0 || c.AllObjects {
+		add("age", "age", c.AgeInDays)
+	}
+	if !c.CreatedBefore.IsZero() {
+		add("created_before", "createdBefore", date(c.CreatedBefore))
+	}
+	if !c.CustomTimeBefore.IsZero() {
+		add("custom_time_before", "customTimeBefore", date(c.CustomTimeBefore))
+	}
+	if c.DaysSinceCustomTime > 0 {
+		add("days_since_custom_time", "daysSinceCustomTime", c.DaysSinceCustomTime)
+	}
+	if c.DaysSinceNoncurrentTime > 0 {
+		add("days_since_noncurrent_time", "daysSinceNoncurrentTime", c.DaysSinceNoncurrentTime)
+	}
+	switch c.Liveness {
+	case storage.Live:
+		add("with_state", "withState", "LIVE")
+	case storage.Archived:
+		add("with_state", "withState", "ARCHIVED")
+	}
+	if len(c.MatchesPrefix) > 0 {
+		add("matches_prefix", "matchesPrefix", c.MatchesPrefix)
+	}
+	if len(c.MatchesStorageClasses) > 0 {
+		add("matches_storage_class", "matchesStorageClass", c.MatchesStorageClasses)
+	}
+	if len(c.MatchesSuffix) > 0 {
+		add("matches_suffix", "matchesSuffix", c.MatchesSuffix)
+	}
+	if !c.NoncurrentTimeBefore.IsZero() {
+		add("noncurrent_time_before", "noncurrentTimeBefore", date(c.NoncurrentTimeBefore))
+	}
+	if c.NumNewerVersions > 0 {
+		add("num_newer_versions", "numNewerVersions", c.NumNewerVersions)
+	}
+	return fs
+}
+
+// kccResource is a Config Connector resource.
+type kccResource struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   kccMetadata    `yaml:"metadata"`
+	Spec       map[string]any `yaml:"spec"`
+}
+
+type kccMetadata struct {
+	Name        string            `yaml:"name"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+const kccStorageAPI = "storage.cnrm.cloud.google.com/v1beta1"
+
+// kccName turns a bucket name, which may contain underscores, into a
+// Kubernetes object name. The bucket is named by spec.resourceID.
+func kccName(bucket string) string {
+	return strings.ReplaceAll(bucket, "_", "-")
+}
+
+func (in *infra) kcc() ([]byte, error) {
+	a := in.attrs
+	spec := map[string]any{
+		"resourceID":               a.Name,
+		"location":                 a.Location,
+		"storageClass":             a.StorageClass,
+		"uniformBucketLevelAccess": a.UniformBucketLevelAccess.Enabled,
+	}
+	if pap := a.PublicAccessPrevention; pap == storage.PublicAccessPreventionEnforced || pap == storage.PublicAccessPreventionInherited {
+		spec["publicAccessPrevention"] = pap.String()
+	}
+	if a.HierarchicalNamespace != nil && a.HierarchicalNamespace.Enabled {
+		spec["hierarchicalNamespace"] = map[string]bool{"enabled": true}
+	}
+	if a.Autoclass != nil && a.Autoclass.Enabled {
+		spec["autoclass"] = map[string]bool{"enabled": true}
+	}
+	if a.VersioningEnabled {
+		spec["versioning"] = map[string]bool{"enabled": true}
+	}
+	if a.SoftDeletePolicy != nil {
+		spec["softDeletePolicy"] = map[string]int64{"retentionDurationSeconds": int64(a.SoftDeletePolicy.RetentionDuration.Seconds())}
+	}
+	var rules []map[string]any
+	for _, r := range a.Lifecycle.Rules {
+		action := map[string]any{"type": r.Action.Type}
+		if r.Action.StorageClass != "" {
+			action["storageClass"] = r.Action.StorageClass
+		}
+		cond := map[string]any{}
+		for _, kv := range lifecycleCondition(r.Condition) {
+			cond[kv.kcc] = kv.value
+		}
+		rules = append(rules, map[string]any{"action": action, "condition": cond})
+	}
+	if len(rules) > 0 {
+		spec["lifecycleRule"] = rules
+	}
+	docs := []kccResource{{
+		APIVersion: kccStorageAPI,
+		Kind:       "StorageBucket",
+		Metadata: kccMetadata{
+			Name: kccName(a.Name),
+			// Keep the bucket and its data when the resource is deleted.
+			Annotations: map[string]string{
+				"cnrm.cloud.google.com/project-id":      in.project,
+				"cnrm.cloud.google.com/deletion-policy": "abandon",
+			},
+		},
+		Spec: spec,
+	}}
+	for i, b := range in.bindings {
+		for j, m := range b.Members {
+			c := b.GetCondition()
+			docs = append(docs, kccResource{
+				APIVersion: "iam.cnrm.cloud.google.com/v1beta1",
+				Kind:       "IAMPolicyMember",
+				Metadata:   kccMetadata{Name: fmt.Sprintf("%s-grant-%d-%d", kccName(a.Name), i, j)},
+				Spec: map[string]any{
+					"member": m,
+					"role":   b.Role,
+					"condition": map[string]string{
+						"title": c.GetTitle(), "description": c.GetDescription(), "expression": c.GetExpression(),
+					},
+					"resourceRef": map[string]string{"apiVersion": kccStorageAPI, "kind": "StorageBucket", "name": kccName(a.Name)},
+				},
+			})
+		}
+	}
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "# gcsfuse-tools dataprep created these resources on %s.\n", time.Now().UTC().Format(time.DateOnly))
+	enc := yaml.NewEncoder(&w)
+	enc.SetIndent(2)
+	for _, d := range docs {
+		if err := enc.Encode(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
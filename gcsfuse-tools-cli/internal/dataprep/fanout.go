@@ -0,0 +1,70 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// BucketTarget is one bucket of a fan-out and the location it is created
+// in.
+type BucketTarget struct {
+	Name     string
+	Location string
+}
+
+// ParseBuckets parses --buckets entries, "NAME" or "NAME:LOCATION"; buckets
+// without a location are created in defaultLocation.
+func ParseBuckets(entries []string, defaultLocation string) ([]BucketTarget, error) {
+	var out []BucketTarget
+	seen := map[string]bool{}
+	for _, e := range entries {
+		name, loc, _ := strings.Cut(strings.TrimSpace(e), ":")
+		if name == "" {
+			return nil, fmt.Errorf("invalid --buckets entry %q", e)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("bucket %s is listed twice in --buckets", name)
+		}
+		seen[name] = true
+		if loc == "" {
+			loc = defaultLocation
+		}
+		out = append(out, BucketTarget{Name: name, Location: loc})
+	}
+	return out, nil
+}
+
+// ForBucket returns c applied to bucket t.
+func (c Config) ForBucket(t BucketTarget) Config {
+	c.Bucket, c.Location = t.Name, t.Location
+	return c
+}
+
+// RunBuckets runs the configured operation on every target concurrently, so
+// identical datasets are prepared in several buckets or regions at once. It
+// returns the summary of every target, in order, and the errors of all
+// those that failed.
+func RunBuckets(ctx context.Context, client *storage.Client, cfg Config, targets []BucketTarget) ([]*Summary, error) {
+	slog.Info("Fanning out", "op_type", cfg.OpType, "buckets", len(targets))
+	summaries := make([]*Summary, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summaries[i], errs[i] = Run(ctx, client, cfg.ForBucket(t))
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", t.Name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return summaries, errors.Join(errs...)
+}
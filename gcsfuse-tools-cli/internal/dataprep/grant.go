@@ -0,0 +1,108 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/storage"
+	"google.golang.org/genproto/googleapis/type/expr"
+)
+
+const (
+	// grantTitle marks the conditional bindings created by data prep so that
+	// revoke never touches bindings it did not create.
+	grantTitle = "gcsfuse-tools-benchmark-grant"
+	// policyAttempts bounds the read-modify-write cycles of a bucket policy
+	// when a concurrent update changes its etag.
+	policyAttempts = 3
+)
+
+// grantExpression matches the condition expression of a grant.
+var grantExpression = regexp.MustCompile(`^request\.time < timestamp\("([^"]+)"\)$`)
+
+// grant gives cfg.GrantMember cfg.GrantRole on the bucket until cfg.GrantTTL
+// from now. Conditional bindings require uniform bucket-level access.
+func grant(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	expiry := time.Now().Add(cfg.GrantTTL).UTC().Truncate(time.Second)
+	cond := &expr.Expr{
+		Title:       grantTitle,
+		Description: fmt.Sprintf("Benchmark access until %s", expiry.Format(time.RFC3339)),
+		Expression:  fmt.Sprintf("request.time < timestamp(%q)", expiry.Format(time.RFC3339)),
+	}
+	slog.Info("Granting temporary bucket access", "bucket", bucket.BucketName(), "member", cfg.GrantMember,
+		"role", cfg.GrantRole, "expires", expiry)
+	return updatePolicy(ctx, bucket, func(p *iam.Policy3) {
+		p.Bindings = append(p.Bindings, &iampb.Binding{
+			Role:      cfg.GrantRole,
+			Members:   []string{cfg.GrantMember},
+			Condition: cond,
+		})
+	})
+}
+
+// revoke removes the bindings created by grant, only those of
+// cfg.GrantMember when it is set. Expired bindings grant nothing but still
+// count against the policy's binding limit.
+func revoke(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	var removed int
+	err := updatePolicy(ctx, bucket, func(p *iam.Policy3) {
+		removed = 0
+		p.Bindings = slices.DeleteFunc(p.Bindings, func(b *iampb.Binding) bool {
+			if b.GetCondition().GetTitle() != grantTitle {
+				return false
+			}
+			if cfg.GrantMember != "" {
+				b.Members = slices.DeleteFunc(b.Members, func(m string) bool { return m == cfg.GrantMember })
+				if len(b.Members) > 0 {
+					return false
+				}
+			}
+			removed++
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Revoked temporary bucket access", "bucket", bucket.BucketName(), "bindings", removed)
+	return nil
+}
+
+// GrantExpiry returns when a binding created by grant expires; ok is false
+// for any other binding.
+func GrantExpiry(b *iampb.Binding) (expiry time.Time, ok bool) {
+	if b.GetCondition().GetTitle() != grantTitle {
+		return time.Time{}, false
+	}
+	m := grantExpression.FindStringSubmatch(b.GetCondition().GetExpression())
+	if m == nil {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339, m[1])
+	return expiry, err == nil
+}
+
+// updatePolicy applies fn to the bucket's version 3 policy and writes it
+// back, retrying when the policy changed in between.
+func updatePolicy(ctx context.Context, bucket *storage.BucketHandle, fn func(*iam.Policy3)) error {
+	h := bucket.IAM().V3()
+	var err error
+	for attempt := 1; attempt <= policyAttempts; attempt++ {
+		var p *iam.Policy3
+		if p, err = h.Policy(ctx); err != nil {
+			return fmt.Errorf("reading IAM policy of %s: %w", bucket.BucketName(), err)
+		}
+		fn(p)
+		if err = h.SetPolicy(ctx, p); err == nil {
+			return nil
+		}
+		slog.Warn("Setting IAM policy failed", "bucket", bucket.BucketName(), "attempt", attempt, "err", err)
+	}
+	return fmt.Errorf("setting IAM policy of %s after %d attempts: %w", bucket.BucketName(), policyAttempts, err)
+}
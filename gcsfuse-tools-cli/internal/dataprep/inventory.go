@@ -0,0 +1,168 @@
+package dataprep
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Supported --inventory-format values.
+const (
+	InventoryCSV  = "csv"
+	InventoryJSON = "json"
+)
+
+// InventoryObject is one row of an inventory.
+type InventoryObject struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	StorageClass string `json:"storage_class"`
+	Generation   int64  `json:"generation"`
+}
+
+// ClassUsage counts the objects of one storage class.
+type ClassUsage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// InventoryReport totals the objects an inventory listed.
+type InventoryReport struct {
+	Bucket  string `json:"bucket"`
+	Prefix  string `json:"prefix,omitempty"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+	// Largest is the size of the largest object.
+	Largest        int64                 `json:"largest"`
+	ByStorageClass map[string]ClassUsage `json:"by_storage_class"`
+}
+
+// Inventory lists the objects of the bucket under cfg.DeletePrefix and writes
+// their name, size, storage class and generation to w as cfg.InventoryFormat,
+// without modifying anything. Requester pays buckets are listed at the cost
+// of cfg.BillingProject, or cfg.Project.
+func Inventory(ctx context.Context, client *storage.Client, cfg Config, w io.Writer) (*InventoryReport, error) {
+	r := &InventoryReport{Bucket: cfg.Bucket, Prefix: cfg.DeletePrefix, ByStorageClass: map[string]ClassUsage{}}
+	slog.Info("Listing inventory", "bucket", cfg.Bucket, "prefix", cfg.DeletePrefix, "format", cfg.InventoryFormat)
+	q := &storage.Query{Prefix: cfg.DeletePrefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "StorageClass", "Generation"}); err != nil {
+		return nil, err
+	}
+	bucket := cfg.bucket(client)
+	it := bucket.Objects(ctx, q)
+
+	out := newInventoryWriter(w, cfg.InventoryFormat)
+	for first := true; ; first = false {
+		attrs, err := it.Next()
+		var gerr *googleapi.Error
+		if first && cfg.billingProject() != "" && errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest {
+			// Requester pays buckets reject requests without a billing
+			// project.
+			it = bucket.UserProject(cfg.billingProject()).Objects(ctx, q)
+			attrs, err = it.Next()
+		}
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing objects in %s: %w", cfg.Bucket, err)
+		}
+		o := InventoryObject{Name: attrs.Name, Size: attrs.Size, StorageClass: attrs.StorageClass, Generation: attrs.Generation}
+		if err := out.write(o); err != nil {
+			return nil, fmt.Errorf("writing inventory: %w", err)
+		}
+		r.Objects++
+		r.Bytes += o.Size
+		r.Largest = max(r.Largest, o.Size)
+		u := r.ByStorageClass[o.StorageClass]
+		u.Objects++
+		u.Bytes += o.Size
+		r.ByStorageClass[o.StorageClass] = u
+	}
+	if err := out.close(); err != nil {
+		return nil, fmt.Errorf("writing inventory: %w", err)
+	}
+	return r, nil
+}
+
+// inventoryWriter streams the rows of an inventory, so that buckets of
+// millions of objects are not held in memory.
+type inventoryWriter struct {
+	w      io.Writer
+	csv    *csv.Writer
+	n      int
+	format string
+}
+
+func newInventoryWriter(w io.Writer, format string) *inventoryWriter {
+	iw := &inventoryWriter{w: w, format: format}
+	if format == InventoryCSV {
+		iw.csv = csv.NewWriter(w)
+		iw.csv.Write([]string{"name", "size", "storage_class", "generation"})
+	}
+	return iw
+}
+
+// write appends one row: a CSV record, or an element of a JSON array.
+func (iw *inventoryWriter) write(o InventoryObject) error {
+	iw.n++
+	if iw.csv != nil {
+		return iw.csv.Write([]string{o.Name, strconv.FormatInt(o.Size, 10), o.StorageClass, strconv.FormatInt(o.Generation, 10)})
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if iw.n == 1 {
+		sep = "[\n  "
+	}
+	_, err = fmt.Fprintf(iw.w, "%s%s", sep, b)
+	return err
+}
+
+// close ends the CSV or the JSON array.
+func (iw *inventoryWriter) close() error {
+	if iw.csv != nil {
+		iw.csv.Flush()
+		return iw.csv.Error()
+	}
+	end := "\n]\n"
+	if iw.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(iw.w, end)
+	return err
+}
+
+// WriteText prints the totals by storage class.
+func (r *InventoryReport) WriteText(w io.Writer) error {
+	where := "gs://" + r.Bucket + "/" + r.Prefix
+	fmt.Fprintf(w, "%s: %d objects, %s, largest %s\n", where, r.Objects, units.FormatSize(r.Bytes), units.FormatSize(r.Largest))
+	if len(r.ByStorageClass) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORAGE CLASS\tOBJECTS\tSIZE")
+	for _, c := range slices.Sorted(maps.Keys(r.ByStorageClass)) {
+		u := r.ByStorageClass[c]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", c, u.Objects, units.FormatSize(u.Bytes))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,98 @@
+package dataprep
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// fioBlockSize is the largest block size of the emitted jobs, that of the
+// release benchmarks.
+const fioBlockSize = 1 << 20
+
+// fioRW maps the bench types to the rw mode of the fio jobs reading or
+// writing their datasets.
+var fioRW = map[string]string{
+	BenchRandRead:   "randread",
+	BenchSeqRead:    "read",
+	BenchSmallFiles: "read",
+	BenchCheckpoint: "read",
+	BenchWrite:      "write",
+	BenchRandWrite:  "randwrite",
+}
+
+// JobFile returns a fio jobfile whose jobs read, or write, exactly the
+// dataset setup creates with cfg: one job per class, with its numjobs,
+// nrfiles, filesize and a filename_format giving the object names, in
+// cfg.FioDirectory, the gcsfuse mount point.
+func JobFile(cfg Config) []byte {
+	dir := cfg.FioDirectory
+	if dir == "" {
+		dir = "${DIR}"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "; Generated by gcsfuse-tools dataprep for the dataset of spec %s.\n", cfg.Spec().Hash())
+	if cfg.FioDirectory == "" {
+		fmt.Fprintf(&b, "; Run with DIR set to the gcsfuse mount point of the bucket.\n")
+	}
+	fmt.Fprintf(&b, `[global]
+direct=1
+invalidate=1
+thread=1
+openfiles=1
+group_reporting=1
+directory=%s
+`, dir)
+	if cfg.writes() {
+		fmt.Fprint(&b, "ioengine=sync\nverify=0\ncreate_on_open=1\nfile_append=0\n")
+	} else {
+		fmt.Fprint(&b, "ioengine=libaio\niodepth=64\ncreate_serialize=0\nallrandrepeat=0\nfile_service_type=random\n")
+	}
+	parts := cfg.parts()
+	for _, p := range parts {
+		fmt.Fprintf(&b, "\n[%s]\n", p.namePrefix())
+		if len(parts) > 1 {
+			fmt.Fprintln(&b, "stonewall")
+		}
+		fmt.Fprintf(&b, "rw=%s\nnumjobs=%d\nnrfiles=%d\nfilesize=%s\nbs=%s\nfilename_format=%s\n", fioRW[p.BenchType],
+			p.NumJobs, p.NrFiles, units.FormatSize(p.FileSize), units.FormatSize(min(p.FileSize, fioBlockSize)), p.fioFilenameFormat())
+	}
+	return b.Bytes()
+}
+
+// fioFilenameFormat is the fio filename_format of the objects of a
+// single-class cfg in the flat layout or NameTemplate.
+func (c *Config) fioFilenameFormat() string {
+	return strings.NewReplacer("{job}", "$jobnum", "{file}", "$filenum").Replace(c.NamePattern())
+}
+
+// writeJobFile writes JobFile to cfg.FioJobFile, replacing it atomically as
+// the setups of a fan-out write the same file.
+func writeJobFile(cfg Config) error {
+	f, err := os.CreateTemp(filepath.Dir(cfg.FioJobFile), ".jobfile-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(JobFile(cfg))
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), cfg.FioJobFile); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	slog.Info("Wrote fio jobfile", "path", cfg.FioJobFile)
+	return nil
+}
@@ -0,0 +1,209 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	control "cloud.google.com/go/storage/control/apiv2"
+	"cloud.google.com/go/storage/control/apiv2/controlpb"
+	"google.golang.org/api/iterator"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Supported --bucket-type values.
+const (
+	BucketFlat = "flat"
+	// BucketHNS is a bucket with hierarchical namespace, whose folders are
+	// real resources that gcsfuse lists and renames atomically.
+	BucketHNS = "hns"
+)
+
+// objectName returns the name of the file-th object of job j:
+// "<bench_type>.<j>.<file>", or "<prefix>.<j>.<file>" for a class, in the flat layout,
+// "<bench_type>.<j>/d<a>/d<b>/.../<file>" with DirDepth levels of
+// directories holding FilesPerDir files each in the nested layout, and
+// NameTemplate expanded when it is set.
+func (c *Config) objectName(j, file int) string {
+	if c.NameTemplate != "" {
+		return c.expandName(strconv.Itoa(j), strconv.Itoa(file))
+	}
+	if c.DirDepth == 0 {
+		return fmt.Sprintf("%s.%d.%d", c.namePrefix(), j, file)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s.%d/", c.namePrefix(), j)
+	for _, d := range c.leafPath(file / c.FilesPerDir) {
+		fmt.Fprintf(&b, "d%d/", d)
+	}
+	fmt.Fprintf(&b, "%d", file)
+	return b.String()
+}
+
+// namePlaceholder matches the placeholders of a NameTemplate.
+var namePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validNameTemplate reports a NameTemplate that does not give every object a
+// distinct name.
+func (c *Config) validNameTemplate() error {
+	if c.NameTemplate == "" {
+		return nil
+	}
+	if c.DirDepth > 0 {
+		return errors.New("--name-template cannot be combined with --dir-depth")
+	}
+	seen := map[string]bool{}
+	for _, p := range namePlaceholder.FindAllString(c.NameTemplate, -1) {
+		switch p {
+		case "{prefix}", "{job}", "{file}", "{size}":
+			seen[p] = true
+		default:
+			return fmt.Errorf("--name-template: unknown placeholder %s; use {prefix}, {job}, {file} or {size}", p)
+		}
+	}
+	if !seen["{job}"] || !seen["{file}"] {
+		return errors.New("--name-template must contain {job} and {file}")
+	}
+	if !separatedNumbers(c.NameTemplate) {
+		// "x{job}{file}" names job 1 file 11 and job 11 file 1 alike.
+		return errors.New("--name-template must separate {job} and {file} from other placeholders and digits, e.g. {job}.{file}")
+	}
+	if len(c.Classes) > 0 && !seen["{prefix}"] {
+		return errors.New("--name-template must contain {prefix} with --spec-file")
+	}
+	if strings.HasPrefix(c.NameTemplate, "/") || strings.Contains(c.NameTemplate, "//") {
+		return errors.New("--name-template must not start with / or contain //")
+	}
+	return nil
+}
+
+// separatedNumbers reports whether every {job} and {file} of template is
+// delimited by a character that is neither a digit nor part of a
+// placeholder, or by the start or end of the template, so the numbers can be
+// told apart in the names.
+func separatedNumbers(template string) bool {
+	for _, loc := range namePlaceholder.FindAllStringIndex(template, -1) {
+		if p := template[loc[0]:loc[1]]; p != "{job}" && p != "{file}" {
+			continue
+		}
+		if loc[0] > 0 && !isSeparator(template[loc[0]-1]) {
+			return false
+		}
+		if loc[1] < len(template) && !isSeparator(template[loc[1]]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSeparator(b byte) bool {
+	return (b < '0' || b > '9') && b != '{' && b != '}'
+}
+
+// expandName expands NameTemplate with job and file, which are kept as
+// placeholders by NamePattern.
+func (c *Config) expandName(job, file string) string {
+	return strings.NewReplacer(
+		"{prefix}", c.namePrefix(),
+		"{size}", units.FormatSize(c.FileSize),
+		"{job}", job,
+		"{file}", file,
+	).Replace(c.NameTemplate)
+}
+
+// listPrefix is the name prefix shared by all dataset objects, which
+// listings of the dataset are limited to: the text of NameTemplate before
+// {job} or {file}, or "<prefix>.".
+func (c *Config) listPrefix() string {
+	if c.NameTemplate == "" {
+		return c.namePrefix() + "."
+	}
+	name := c.expandName("{job}", "{file}")
+	return name[:strings.IndexByte(name, '{')]
+}
+
+// leafPath returns the directory indexes, top down, of the leaf-th leaf
+// directory of a job. The tree is balanced: every level fans out into the
+// smallest number of directories that holds all leaves in DirDepth levels.
+func (c *Config) leafPath(leaf int) []int {
+	leaves := (c.NrFiles + c.FilesPerDir - 1) / c.FilesPerDir
+	fanout := 1
+	for pow(fanout, c.DirDepth) < leaves {
+		fanout++
+	}
+	path := make([]int, c.DirDepth)
+	for i := c.DirDepth - 1; i >= 0; i-- {
+		path[i] = leaf % fanout
+		leaf /= fanout
+	}
+	return path
+}
+
+func pow(b, e int) int {
+	n := 1
+	for ; e > 0; e-- {
+		n *= b
+	}
+	return n
+}
+
+// NamePattern describes the object names for the registry, e.g.
+// "rand-read.{job}.{file}", with one comma-separated pattern per class of a
+// mixed dataset.
+func (c *Config) NamePattern() string {
+	if len(c.Classes) > 0 {
+		patterns := make([]string, len(c.Classes))
+		for i, p := range c.parts() {
+			patterns[i] = p.NamePattern()
+		}
+		return strings.Join(patterns, ",")
+	}
+	if c.NameTemplate != "" {
+		return c.expandName("{job}", "{file}")
+	}
+	if c.DirDepth == 0 {
+		return c.namePrefix() + ".{job}.{file}"
+	}
+	return fmt.Sprintf("%s.{job}/%s{file}", c.namePrefix(), strings.Repeat("d{n}/", c.DirDepth))
+}
+
+// deleteFolders deletes the folders of a hierarchical namespace bucket under
+// prefix, which remain after their objects are deleted and keep the bucket
+// from being deleted. Subfolders go before their parents.
+func deleteFolders(ctx context.Context, bucket, prefix string) error {
+	client, err := control.NewStorageControlClient(ctx)
+	if err != nil {
+		return fmt.Errorf("creating storage control client: %w", err)
+	}
+	defer client.Close()
+
+	parent := "projects/_/buckets/" + bucket
+	var folders []string
+	it := client.ListFolders(ctx, &controlpb.ListFoldersRequest{Parent: parent, Prefix: prefix})
+	for {
+		f, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("listing folders in %s: %w", bucket, err)
+		}
+		folders = append(folders, f.GetName())
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return strings.Count(folders[i], "/") > strings.Count(folders[j], "/")
+	})
+	for _, name := range folders {
+		if err := client.DeleteFolder(ctx, &controlpb.DeleteFolderRequest{Name: name}); err != nil {
+			return fmt.Errorf("deleting folder %s: %w", name, err)
+		}
+	}
+	slog.Info("Deleted folders", "bucket", bucket, "count", len(folders))
+	return nil
+}
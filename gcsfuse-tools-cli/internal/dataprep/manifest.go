@@ -0,0 +1,308 @@
+package dataprep
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ManifestObject is the object setup records the checksums of the dataset
+// in, for checksum-verify.
+const ManifestObject = "manifest.json"
+
+// ChecksumManifest records the checksums of a dataset. Every object of a
+// class is a copy of the same source object, so one checksum per class
+// covers all of them.
+type ChecksumManifest struct {
+	SpecHash string          `json:"spec_hash"`
+	RunID    string          `json:"run_id,omitempty"`
+	Created  time.Time       `json:"created"`
+	Classes  []ManifestClass `json:"classes"`
+}
+
+// ManifestClass holds the checksums, in hex, of the objects of one class.
+// MD5 is empty for sources uploaded in composed parts, which GCS stores
+// without an MD5.
+type ManifestClass struct {
+	Prefix      string `json:"prefix"`
+	NamePattern string `json:"name_pattern"`
+	Objects     int64  `json:"objects"`
+	Size        int64  `json:"size"`
+	CRC32C      string `json:"crc32c"`
+	MD5         string `json:"md5,omitempty"`
+}
+
+// class returns the entry of the class with prefix, or nil.
+func (m *ChecksumManifest) class(prefix string) *ManifestClass {
+	for i := range m.Classes {
+		if m.Classes[i].Prefix == prefix {
+			return &m.Classes[i]
+		}
+	}
+	return nil
+}
+
+// record adds the checksums of the source object every object of the
+// single-class cfg was copied from.
+func (m *ChecksumManifest) record(cfg Config, src *storage.ObjectAttrs) {
+	if m == nil {
+		return
+	}
+	m.Classes = slices.DeleteFunc(m.Classes, func(c ManifestClass) bool { return c.Prefix == cfg.namePrefix() })
+	m.Classes = append(m.Classes, ManifestClass{
+		Prefix: cfg.namePrefix(), NamePattern: cfg.NamePattern(), Objects: cfg.ObjectCount(), Size: cfg.FileSize,
+		CRC32C: fmt.Sprintf("%08x", src.CRC32C), MD5: hex.EncodeToString(src.MD5),
+	})
+}
+
+// readManifest reads the ManifestObject of bucket.
+func readManifest(ctx context.Context, bucket *storage.BucketHandle) (*ChecksumManifest, error) {
+	r, err := bucket.Object(ManifestObject).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var m ChecksumManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", ManifestObject, err)
+	}
+	return &m, nil
+}
+
+// writeManifest writes the manifest of the dataset setup created with cfg.
+// Classes whose objects all existed already keep the checksums of the
+// manifest of the run that created them.
+func writeManifest(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	m := cfg.manifest
+	var previous *ChecksumManifest
+	for _, part := range cfg.parts() {
+		if m.class(part.namePrefix()) != nil {
+			continue
+		}
+		if previous == nil {
+			var err error
+			if previous, err = readManifest(ctx, bucket); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				return fmt.Errorf("reading the previous %s: %w", ManifestObject, err)
+			}
+			if previous == nil {
+				previous = &ChecksumManifest{}
+			}
+		}
+		if c := previous.class(part.namePrefix()); c != nil {
+			m.Classes = append(m.Classes, *c)
+		} else {
+			slog.Warn("No checksums of a class that existed already; checksum-verify cannot check it", "prefix", part.namePrefix())
+		}
+	}
+	m.SpecHash, m.RunID, m.Created = cfg.Spec().Hash(), cfg.RunID, time.Now().UTC()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	w := bucket.Object(ManifestObject).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	slog.Info("Wrote checksum manifest", "object", "gs://"+cfg.Bucket+"/"+ManifestObject, "classes", len(m.Classes))
+	return nil
+}
+
+// ChecksumMismatch is a sampled object whose content does not match the
+// manifest.
+type ChecksumMismatch struct {
+	Name    string `json:"name"`
+	Problem string `json:"problem"`
+}
+
+// ChecksumReport is the outcome of checksum-verify.
+type ChecksumReport struct {
+	Bucket          string    `json:"bucket"`
+	ManifestCreated time.Time `json:"manifest_created"`
+	Objects         int64     `json:"objects"`
+	Sampled         int       `json:"sampled"`
+	Bytes           int64     `json:"bytes"`
+	// Failed counts every sampled object that is missing or does not match
+	// the manifest; Mismatches lists up to maxVerifyListed of them.
+	Failed     int                `json:"failed"`
+	Mismatches []ChecksumMismatch `json:"mismatches,omitempty"`
+}
+
+// OK reports whether every sampled object matched the manifest.
+func (r *ChecksumReport) OK() bool {
+	return r.Failed == 0
+}
+
+// ChecksumVerify reads cfg.Sample objects of the dataset, chosen at random,
+// or all of them if it is 0, and checks the CRC32C, and the MD5 if
+// recorded, of their content against the manifest setup wrote. The layout
+// flags, e.g. --numjobs, --nrfiles and --filesize, must match the setup.
+func ChecksumVerify(ctx context.Context, client *storage.Client, cfg Config) (*ChecksumReport, error) {
+	bucket := cfg.bucket(client)
+	m, err := readManifest(ctx, bucket)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("gs://%s has no %s; it is written by setup with --manifest", cfg.Bucket, ManifestObject)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ManifestObject, err)
+	}
+	parts := cfg.parts()
+	classes := make([]*ManifestClass, len(parts))
+	for i, part := range parts {
+		c := m.class(part.namePrefix())
+		if c == nil {
+			return nil, fmt.Errorf("%s has no checksums of the class %s", ManifestObject, part.namePrefix())
+		}
+		if c.NamePattern != part.NamePattern() || c.Objects != part.ObjectCount() || c.Size != part.FileSize {
+			return nil, fmt.Errorf("%s records %d objects %s of %d bytes for the class %s; pass the dataset flags of the setup",
+				ManifestObject, c.Objects, c.NamePattern, c.Size, c.Prefix)
+		}
+		classes[i] = c
+	}
+
+	r := &ChecksumReport{Bucket: cfg.Bucket, ManifestCreated: m.Created, Objects: cfg.ObjectCount()}
+	sample := sampleIndexes(r.Objects, cfg.Sample)
+	r.Sampled = len(sample)
+	slog.Info("Verifying checksums", "bucket", cfg.Bucket, "objects", r.Objects, "sampled", r.Sampled, "workers", cfg.Workers)
+
+	type item struct {
+		name  string
+		class *ManifestClass
+	}
+	items := make(chan item)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				n, problem := checkObject(ctx, cfg.object(bucket, it.name), it.class)
+				mu.Lock()
+				r.Bytes += n
+				if problem != "" {
+					r.Failed++
+					r.Mismatches = appendListed(r.Mismatches, ChecksumMismatch{Name: it.name, Problem: problem})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, i := range sample {
+		// Find the class and the job and file of the i-th object.
+		p := 0
+		for ; i >= parts[p].ObjectCount(); p++ {
+			i -= parts[p].ObjectCount()
+		}
+		part := parts[p]
+		select {
+		case items <- item{part.objectName(int(i)/part.NrFiles, int(i)%part.NrFiles), classes[p]}:
+		case <-ctx.Done():
+		}
+	}
+	close(items)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(r.Mismatches, func(a, b ChecksumMismatch) int { return strings.Compare(a.Name, b.Name) })
+	return r, nil
+}
+
+// sampleIndexes returns n distinct indexes below total in increasing order,
+// or all of them if n is 0 or at least total.
+func sampleIndexes(total int64, n int) []int64 {
+	if n <= 0 || int64(n) >= total {
+		all := make([]int64, total)
+		for i := range all {
+			all[i] = int64(i)
+		}
+		return all
+	}
+	picked := make(map[int64]bool, n)
+	for len(picked) < n {
+		picked[rand.Int64N(total)] = true
+	}
+	out := make([]int64, 0, n)
+	for i := range picked {
+		out = append(out, i)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// checkObject reads obj and returns the bytes read and why it does not
+// match c, if it does not.
+func checkObject(ctx context.Context, obj *storage.ObjectHandle, c *ManifestClass) (int64, string) {
+	rd, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return 0, "missing"
+	}
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer rd.Close()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	var sum hash.Hash
+	w := io.Writer(crc)
+	if c.MD5 != "" {
+		sum = md5.New()
+		w = io.MultiWriter(crc, sum)
+	}
+	n, err := io.Copy(w, rd)
+	if err != nil {
+		return n, err.Error()
+	}
+	if n != c.Size {
+		return n, fmt.Sprintf("size %d, want %d", n, c.Size)
+	}
+	if got := fmt.Sprintf("%08x", crc.Sum32()); got != c.CRC32C {
+		return n, fmt.Sprintf("CRC32C %s, want %s", got, c.CRC32C)
+	}
+	if sum != nil {
+		if got := hex.EncodeToString(sum.Sum(nil)); got != c.MD5 {
+			return n, fmt.Sprintf("MD5 %s, want %s", got, c.MD5)
+		}
+	}
+	return n, ""
+}
+
+// WriteText prints one row per mismatch and a summary line.
+func (r *ChecksumReport) WriteText(w io.Writer) error {
+	if !r.OK() {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "OBJECT\tPROBLEM")
+		for _, m := range r.Mismatches {
+			fmt.Fprintf(tw, "%s\t%s\n", m.Name, m.Problem)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if n := r.Failed - len(r.Mismatches); n > 0 {
+			fmt.Fprintf(w, "... and %d more\n", n)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "gs://%s: %d of %d sampled objects (of %d) match the manifest of %s.\n",
+		r.Bucket, r.Sampled-r.Failed, r.Sampled, r.Objects, r.ManifestCreated.Format(time.RFC3339))
+	return err
+}
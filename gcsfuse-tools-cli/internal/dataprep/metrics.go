@@ -0,0 +1,142 @@
+package dataprep
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request kinds of the metrics.
+const (
+	reqUpload = "upload"
+	reqCopy   = "copy"
+	reqDelete = "delete"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histograms: from small deletes to the uploads of large source objects.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Metrics exports the requests of the runs sharing it in the Prometheus text
+// format, so that operators can watch multi-hour runs remotely, e.g. with
+// Managed Service for Prometheus. It is safe for concurrent use.
+type Metrics struct {
+	start time.Time
+	mu    sync.Mutex
+	// planned is the number of objects setup creates per bucket.
+	planned  map[string]int64
+	inflight map[string]int64
+	requests map[metricKey]*requestMetrics
+	errors   map[errorKey]int64
+}
+
+type metricKey struct{ bucket, op string }
+
+type errorKey struct{ bucket, op, class string }
+
+// requestMetrics is the latency histogram of the completed requests of one
+// kind; its count is the number of completed requests.
+type requestMetrics struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// NewMetrics returns empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{start: time.Now(), planned: map[string]int64{}, inflight: map[string]int64{},
+		requests: map[metricKey]*requestMetrics{}, errors: map[errorKey]int64{}}
+}
+
+// plan records the number of objects setup creates in bucket.
+func (m *Metrics) plan(bucket string, objects int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.planned[bucket] = objects
+}
+
+// begin records a request sent to bucket.
+func (m *Metrics) begin(bucket string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight[bucket]++
+}
+
+// end records the completion of the request of op begun d ago, or its
+// failure with the error class if not empty.
+func (m *Metrics) end(bucket, op string, d time.Duration, class string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight[bucket]--
+	if class != "" {
+		m.errors[errorKey{bucket, op, class}]++
+		return
+	}
+	k := metricKey{bucket, op}
+	r := m.requests[k]
+	if r == nil {
+		r = &requestMetrics{buckets: make([]int64, len(latencyBuckets))}
+		m.requests[k] = r
+	}
+	sec := d.Seconds()
+	for i, le := range latencyBuckets {
+		if sec <= le {
+			r.buckets[i]++
+		}
+	}
+	r.count++
+	r.sum += sec
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var b strings.Builder
+	m.mu.Lock()
+	fmt.Fprintf(&b, "# HELP gcsfuse_data_prep_start_time_seconds Start time of the data prep run.\n# TYPE gcsfuse_data_prep_start_time_seconds gauge\n")
+	fmt.Fprintf(&b, "gcsfuse_data_prep_start_time_seconds %d\n", m.start.Unix())
+
+	fmt.Fprintf(&b, "# HELP gcsfuse_data_prep_planned_objects Objects setup creates in the bucket.\n# TYPE gcsfuse_data_prep_planned_objects gauge\n")
+	for _, bucket := range slices.Sorted(maps.Keys(m.planned)) {
+		fmt.Fprintf(&b, "gcsfuse_data_prep_planned_objects{bucket=%q} %d\n", bucket, m.planned[bucket])
+	}
+
+	fmt.Fprintf(&b, "# HELP gcsfuse_data_prep_inflight_requests Requests the workers are sending, including the wait for --max-qps.\n# TYPE gcsfuse_data_prep_inflight_requests gauge\n")
+	for _, bucket := range slices.Sorted(maps.Keys(m.inflight)) {
+		fmt.Fprintf(&b, "gcsfuse_data_prep_inflight_requests{bucket=%q} %d\n", bucket, m.inflight[bucket])
+	}
+
+	fmt.Fprintf(&b, "# HELP gcsfuse_data_prep_request_errors_total Failed request attempts by error class.\n# TYPE gcsfuse_data_prep_request_errors_total counter\n")
+	errs := slices.SortedFunc(maps.Keys(m.errors), func(a, b errorKey) int { return strings.Compare(a.bucket+a.op+a.class, b.bucket+b.op+b.class) })
+	for _, k := range errs {
+		fmt.Fprintf(&b, "gcsfuse_data_prep_request_errors_total{bucket=%q,op=%q,class=%q} %d\n", k.bucket, k.op, k.class, m.errors[k])
+	}
+
+	fmt.Fprintf(&b, "# HELP gcsfuse_data_prep_request_duration_seconds Latency of the completed uploads, copies and deletes; the count is the number of completed requests.\n# TYPE gcsfuse_data_prep_request_duration_seconds histogram\n")
+	reqs := slices.SortedFunc(maps.Keys(m.requests), func(a, b metricKey) int { return strings.Compare(a.bucket+a.op, b.bucket+b.op) })
+	for _, k := range reqs {
+		r := m.requests[k]
+		labels := fmt.Sprintf("bucket=%q,op=%q", k.bucket, k.op)
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(&b, "gcsfuse_data_prep_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, r.buckets[i])
+		}
+		fmt.Fprintf(&b, "gcsfuse_data_prep_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, r.count)
+		fmt.Fprintf(&b, "gcsfuse_data_prep_request_duration_seconds_sum{%s} %g\n", labels, r.sum)
+		fmt.Fprintf(&b, "gcsfuse_data_prep_request_duration_seconds_count{%s} %d\n", labels, r.count)
+	}
+	m.mu.Unlock()
+	w.Write([]byte(b.String()))
+}
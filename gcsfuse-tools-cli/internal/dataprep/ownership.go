@@ -0,0 +1,66 @@
+package dataprep
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Labels setup puts on the buckets it creates. Delete only wipes buckets
+// carrying LabelCreatedBy, unless forced, so that a mistyped --bucket does
+// not destroy an unrelated bucket.
+const (
+	LabelCreatedBy = "created-by"
+	CreatedByValue = "gcsfuse-data-prep"
+	// LabelRunID is the run that created the bucket.
+	LabelRunID = "gcsfuse-data-prep-run"
+	// LabelExpires is the date, e.g. 2026-10-24, after which the bucket may
+	// be deleted by cleanup jobs.
+	LabelExpires = "expires"
+)
+
+// bucketLabels returns the ownership labels of a bucket created now.
+func (c *Config) bucketLabels(now time.Time) map[string]string {
+	labels := map[string]string{LabelCreatedBy: CreatedByValue}
+	if c.RunID != "" {
+		labels[LabelRunID] = labelValue(c.RunID)
+	}
+	if c.Expiry > 0 {
+		labels[LabelExpires] = now.Add(c.Expiry).UTC().Format(time.DateOnly)
+	}
+	return labels
+}
+
+// labelValue maps s to a valid label value: at most 63 lowercase letters,
+// digits, dashes and underscores.
+func labelValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+	return s[:min(len(s), 63)]
+}
+
+// checkOwned refuses to wipe the bucket of attrs unless setup created it or
+// cfg.Force is set.
+func checkOwned(attrs *storage.BucketAttrs, cfg Config) error {
+	if attrs.Labels[LabelCreatedBy] == CreatedByValue {
+		slog.Info("Bucket was created by data prep", "bucket", attrs.Name, "run", attrs.Labels[LabelRunID],
+			"expires", attrs.Labels[LabelExpires])
+		return nil
+	}
+	if cfg.Force {
+		slog.Warn("Wiping a bucket not created by data prep", "bucket", attrs.Name)
+		return nil
+	}
+	return fmt.Errorf("bucket %s lacks the label %s=%s of the buckets setup creates; pass --force to wipe it anyway, or --prefix to only delete the benchmark's objects",
+		attrs.Name, LabelCreatedBy, CreatedByValue)
+}
@@ -0,0 +1,329 @@
+package dataprep
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Operation classes of Cloud Storage pricing.
+const (
+	ClassA = "A"
+	ClassB = "B"
+	Free   = "free"
+)
+
+// List prices of the Standard storage class in USD, used by --dry-run. They
+// are approximations for sanity checks, not quotes.
+const (
+	// classAPer1000 and multiRegionClassAPer1000 are the prices of 1000
+	// Class A operations, e.g. uploads, copies and listings, in a region
+	// and in a multi- or dual-region.
+	classAPer1000            = 0.005
+	multiRegionClassAPer1000 = 0.01
+	// classBPer1000 is the price of 1000 Class B operations, e.g. metadata
+	// and IAM policy reads.
+	classBPer1000 = 0.0004
+	// regionPerGiBMonth, multiRegionPerGiBMonth and dualRegionPerGiBMonth
+	// are storage prices per GiB and month. Some regions cost more, see
+	// regionPrices.
+	regionPerGiBMonth      = 0.020
+	multiRegionPerGiBMonth = 0.026
+	dualRegionPerGiBMonth  = 0.044
+)
+
+// regionPrices are the storage prices per GiB and month of the regions
+// that differ from regionPerGiBMonth.
+var regionPrices = map[string]float64{
+	"us-west2":                0.023,
+	"us-west3":                0.023,
+	"us-west4":                0.023,
+	"northamerica-northeast1": 0.023,
+	"northamerica-northeast2": 0.023,
+	"southamerica-east1":      0.035,
+	"europe-west2":            0.023,
+	"europe-west3":            0.023,
+	"europe-west6":            0.025,
+	"asia-east2":              0.025,
+	"asia-northeast1":         0.023,
+	"asia-northeast2":         0.023,
+	"asia-northeast3":         0.023,
+	"asia-south1":             0.023,
+	"asia-southeast2":         0.023,
+	"australia-southeast1":    0.023,
+}
+
+// listPageSize is the most objects one list call returns.
+const listPageSize = 1000
+
+// PlannedOp is one kind of request a run would send.
+type PlannedOp struct {
+	Name  string `json:"name"`
+	Class string `json:"class"`
+	Count int64  `json:"count"`
+}
+
+// Plan describes what a run would do to one bucket, computed by --dry-run
+// from the configuration alone, without calling Cloud Storage.
+type Plan struct {
+	OpType   string `json:"op_type"`
+	Bucket   string `json:"bucket"`
+	Location string `json:"location,omitempty"`
+	// Objects and Bytes are what the run would create, delete, verify or
+	// churn.
+	Objects    int64       `json:"objects"`
+	Bytes      int64       `json:"bytes"`
+	Operations []PlannedOp `json:"operations"`
+	ClassA     int64       `json:"class_a"`
+	ClassB     int64       `json:"class_b"`
+	// OperationsUSD is the price of the operations, and StorageUSDPerMonth
+	// that of keeping the dataset, at Standard storage list prices.
+	OperationsUSD      float64 `json:"operations_usd"`
+	StorageUSDPerMonth float64 `json:"storage_usd_per_month,omitempty"`
+	// Notes are the assumptions behind the plan.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Plans is the plan of every bucket of a --buckets fan-out.
+type Plans []*Plan
+
+// add counts n requests of the given class.
+func (p *Plan) add(name, class string, n int64) {
+	if n <= 0 {
+		return
+	}
+	for i := range p.Operations {
+		if p.Operations[i].Name == name {
+			p.Operations[i].Count += n
+			return
+		}
+	}
+	p.Operations = append(p.Operations, PlannedOp{Name: name, Class: class, Count: n})
+}
+
+// NewPlan computes the requests and the cost of running cfg. Sizes that
+// only GCS knows are assumed: a new bucket for setup, and a bucket holding
+// exactly the dataset for delete.
+func NewPlan(cfg Config) *Plan {
+	p := &Plan{OpType: cfg.OpType, Bucket: cfg.Bucket, Location: cfg.Location}
+	switch cfg.OpType {
+	case OpSetup:
+		p.planSetup(cfg)
+	case OpDelete:
+		p.Objects, p.Bytes = cfg.ObjectCount(), cfg.TotalBytes()
+		p.Location = ""
+		if cfg.DeletePrefix != "" {
+			p.Notes = append(p.Notes, "assumes "+cfg.DeletePrefix+" holds exactly the dataset of the given flags")
+		} else {
+			p.Notes = append(p.Notes, "assumes the bucket holds exactly the dataset of the given flags")
+		}
+		p.add("buckets.get", ClassB, 1)
+		p.add("objects.list", ClassA, pages(p.Objects))
+		p.add("objects.delete", Free, p.Objects)
+		if cfg.BucketType == BucketHNS {
+			p.add("folders.list", ClassA, 1)
+			p.add("folders.delete", Free, int64(len(cfg.dirNames())))
+		}
+		if cfg.deletesBucket() {
+			p.add("buckets.delete", Free, 1)
+		}
+	case OpVerify:
+		p.Location = ""
+		for _, part := range cfg.parts() {
+			n := part.ObjectCount()
+			p.Objects += n
+			p.Bytes += n * part.FileSize
+			p.add("objects.list", ClassA, pages(n))
+		}
+	case OpChecksumVerify:
+		p.Location = ""
+		p.Objects, p.Bytes = cfg.ObjectCount(), cfg.TotalBytes()
+		if cfg.Sample > 0 && int64(cfg.Sample) < p.Objects {
+			p.Bytes = p.Bytes * int64(cfg.Sample) / p.Objects
+			p.Objects = int64(cfg.Sample)
+			p.Notes = append(p.Notes, "assumes the sampled objects have the average size of the dataset")
+		}
+		p.add("objects.get", ClassB, p.Objects+1)
+	case OpInventory:
+		p.Location = ""
+		p.Notes = append(p.Notes, "one more objects.list per 1000 objects; the size of the bucket is unknown")
+		p.add("objects.list", ClassA, 1)
+	case OpGrant, OpRevoke:
+		p.Location = ""
+		p.add("buckets.getIamPolicy", ClassB, 1)
+		p.add("buckets.setIamPolicy", ClassA, 1)
+	case OpChurn:
+		p.Location = ""
+		if cfg.ChurnInterval > 0 {
+			sweeps := int64(max(1, (cfg.Duration+cfg.ChurnInterval-1)/cfg.ChurnInterval))
+			writes := sweeps * int64(cfg.churnEligible())
+			p.Objects, p.Bytes = writes, writes*cfg.FileSize
+			p.Notes = append(p.Notes, "assumes every sweep completes within --churn-interval; in a versioned bucket every overwritten generation is kept and billed")
+			p.add("objects.insert", ClassA, writes)
+			break
+		}
+		ops := int64(cfg.ChurnRate * cfg.Duration.Seconds())
+		total := cfg.ChurnMix.Create + cfg.ChurnMix.Overwrite + cfg.ChurnMix.Delete
+		writes := ops * int64(cfg.ChurnMix.Create+cfg.ChurnMix.Overwrite) / int64(total)
+		p.Objects, p.Bytes = ops, writes*cfg.FileSize
+		p.Notes = append(p.Notes, "assumes every operation is sent; skipped and failed ones cost less")
+		p.add("objects.insert", ClassA, writes)
+		p.add("objects.delete", Free, ops-writes)
+	}
+	if cfg.EmitDir != "" && (cfg.OpType == OpSetup || cfg.OpType == OpGrant) {
+		p.add("buckets.get", ClassB, 1)
+		p.add("buckets.getIamPolicy", ClassB, 1)
+	}
+
+	classA := classAPer1000
+	if multiRegion(p.Location) {
+		classA = multiRegionClassAPer1000
+	}
+	for _, op := range p.Operations {
+		switch op.Class {
+		case ClassA:
+			p.ClassA += op.Count
+		case ClassB:
+			p.ClassB += op.Count
+		}
+	}
+	p.OperationsUSD = float64(p.ClassA)/1000*classA + float64(p.ClassB)/1000*classBPer1000
+	if cfg.OpType == OpSetup {
+		p.StorageUSDPerMonth = float64(p.Bytes) / float64(units.GiB) * storagePrice(p.Location)
+	}
+	return p
+}
+
+// planSetup counts the requests of setup, following setup and populate.
+func (p *Plan) planSetup(cfg Config) {
+	if cfg.SkipBucketCreate {
+		p.Notes = append(p.Notes, "assumes the objects do not exist yet")
+	} else {
+		p.Notes = append(p.Notes, "assumes the bucket and objects do not exist yet")
+	}
+	if cfg.StorageClass != "" && cfg.StorageClass != StorageStandard {
+		p.Notes = append(p.Notes, "prices are Standard ones; "+cfg.StorageClass+" storage costs less but its operations and reads cost more")
+	}
+	if cfg.Resume || cfg.SkipBucketCreate {
+		p.add("buckets.get", ClassB, 1)
+	}
+	if !cfg.SkipBucketCreate {
+		p.add("buckets.insert", ClassA, 1)
+		p.add("buckets.get", ClassB, 1)
+	}
+	if cfg.GrantMember != "" {
+		p.add("buckets.getIamPolicy", ClassB, 1)
+		p.add("buckets.setIamPolicy", ClassA, 1)
+	}
+	if cfg.writes() {
+		if cfg.BucketType == BucketHNS {
+			p.add("folders.insert", ClassA, int64(len(cfg.dirNames())))
+		} else {
+			p.add("objects.insert", ClassA, int64(len(cfg.dirNames())))
+		}
+	}
+	if !cfg.hasObjects() {
+		return
+	}
+	for _, part := range cfg.parts() {
+		n := part.ObjectCount()
+		p.Objects += n
+		p.Bytes += n * part.FileSize
+		if cfg.Resume {
+			p.add("objects.list", ClassA, 1)
+		}
+		if parts, _ := uploadParts(part.FileSize, part); parts > 1 {
+			p.add("objects.insert", ClassA, int64(parts))
+			p.add("objects.compose", ClassA, 1)
+			p.add("objects.delete", Free, int64(parts))
+		} else {
+			p.add("objects.insert", ClassA, 1)
+		}
+		if cfg.checksumMode() != ChecksumNone || cfg.Manifest {
+			p.add("objects.get", ClassB, 1)
+		}
+		p.add("objects.rewrite", ClassA, n)
+		p.add("objects.delete", Free, 1)
+		if part.protects() {
+			protected := int64(0)
+			for f := 0; f < part.NrFiles; f++ {
+				if part.protected(f) {
+					protected++
+				}
+			}
+			p.add("objects.patch", ClassA, protected*int64(part.NumJobs))
+		}
+	}
+	if cfg.Manifest {
+		p.add("objects.insert", ClassA, 1)
+	}
+}
+
+// pages returns the list calls of a listing of n objects.
+func pages(n int64) int64 {
+	return max(1, (n+listPageSize-1)/listPageSize)
+}
+
+// multiRegion reports whether location is a multi-region, e.g. US, or a
+// dual-region, e.g. NAM4 or US-EAST1+US-WEST1, rather than a region.
+func multiRegion(location string) bool {
+	return location != "" && !strings.Contains(location, "-") || strings.Contains(location, "+")
+}
+
+// storagePrice returns the Standard storage price per GiB and month in
+// location.
+func storagePrice(location string) float64 {
+	l := strings.ToLower(location)
+	switch {
+	case l == "us" || l == "eu" || l == "asia":
+		return multiRegionPerGiBMonth
+	case multiRegion(l):
+		return dualRegionPerGiBMonth
+	}
+	if price, ok := regionPrices[l]; ok {
+		return price
+	}
+	return regionPerGiBMonth
+}
+
+// WriteText prints the requests and the estimated cost.
+func (p *Plan) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Dry run of %s on gs://%s", p.OpType, p.Bucket)
+	if p.Location != "" {
+		fmt.Fprintf(w, " in %s", p.Location)
+	}
+	fmt.Fprintf(w, ": %d objects, %s\n\n", p.Objects, units.FormatSize(p.Bytes))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST\tCLASS\tCOUNT")
+	for _, op := range p.Operations {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", op.Name, op.Class, op.Count)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nClass A: %d, Class B: %d, about $%.4f in operations", p.ClassA, p.ClassB, p.OperationsUSD)
+	if p.StorageUSDPerMonth > 0 {
+		fmt.Fprintf(w, " and $%.2f per month of storage", p.StorageUSDPerMonth)
+	}
+	fmt.Fprintln(w, " at Standard list prices.")
+	for _, n := range p.Notes {
+		fmt.Fprintf(w, "Note: %s.\n", n)
+	}
+	return nil
+}
+
+// WriteText prints the plan of every bucket.
+func (ps Plans) WriteText(w io.Writer) error {
+	for i, p := range ps {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if err := p.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
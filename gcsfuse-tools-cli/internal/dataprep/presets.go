@@ -0,0 +1,83 @@
+package dataprep
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Preset is a named dataset layout.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	BenchType   string `json:"bench_type"`
+	FileSize    string `json:"filesize"`
+	NumJobs     int    `json:"numjobs"`
+	NrFiles     int    `json:"nrfiles"`
+}
+
+// Presets are the datasets behind the published Cloud Storage FUSE
+// performance tables, see fio-render and compare-published for the
+// workloads run on them.
+var Presets = []Preset{
+	{
+		Name:        "seq-read-100x1G",
+		Description: "Sequential read of 1G files.",
+		BenchType:   BenchSeqRead,
+		FileSize:    "1G",
+		NumJobs:     100,
+		NrFiles:     1,
+	},
+	{
+		Name:        "rand-read-1000x100M",
+		Description: "Random read of 100M files.",
+		BenchType:   BenchRandRead,
+		FileSize:    "100M",
+		NumJobs:     100,
+		NrFiles:     10,
+	},
+	{
+		Name:        "smallfiles-1M-x-128K",
+		Description: "Whole-file reads of a million 128K files, dominated by open and metadata latency.",
+		BenchType:   BenchSmallFiles,
+		FileSize:    "128K",
+		NumJobs:     1000,
+		NrFiles:     1000,
+	},
+	{
+		Name:        "checkpoint-8x20G",
+		Description: "Checkpoint restore: 8 shards of 20G, one per reader.",
+		BenchType:   BenchCheckpoint,
+		FileSize:    "20G",
+		NumJobs:     8,
+		NrFiles:     1,
+	},
+}
+
+// LookupPreset returns the preset called name.
+func LookupPreset(name string) (Preset, error) {
+	i := slices.IndexFunc(Presets, func(p Preset) bool { return p.Name == name })
+	if i < 0 {
+		names := make([]string, len(Presets))
+		for j, p := range Presets {
+			names[j] = p.Name
+		}
+		return Preset{}, fmt.Errorf("unknown --preset %q (want one of %s)", name, strings.Join(names, ", "))
+	}
+	return Presets[i], nil
+}
+
+// PresetCatalog lists presets.
+type PresetCatalog []Preset
+
+// WriteText prints one preset per line.
+func (c PresetCatalog) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRESET\tBENCH TYPE\tFILESIZE\tNUMJOBS\tNRFILES\tDESCRIPTION")
+	for _, p := range c {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", p.Name, p.BenchType, p.FileSize, p.NumJobs, p.NrFiles, p.Description)
+	}
+	return tw.Flush()
+}
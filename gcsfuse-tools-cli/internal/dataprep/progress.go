@@ -0,0 +1,91 @@
+package dataprep
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// progress counts the objects and bytes a copy or delete has completed and
+// logs throughput and the estimated time remaining every interval, so long
+// runs over 100K+ objects are not silent.
+type progress struct {
+	op       string
+	bucket   string
+	interval time.Duration
+	start    time.Time
+	// total grows while a delete is still listing the bucket; the ETA is
+	// only logged once it is final.
+	total          atomic.Int64
+	final          atomic.Bool
+	objects, bytes atomic.Int64
+	failed         atomic.Int64
+	stop, done     chan struct{}
+}
+
+// startProgress starts logging the progress of op on bucket every interval.
+// A non-positive interval disables the periodic logs.
+func startProgress(op, bucket string, total int64, interval time.Duration) *progress {
+	p := &progress{op: op, bucket: bucket, interval: interval, start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	p.total.Store(total)
+	p.final.Store(total > 0)
+	go p.run()
+	return p
+}
+
+// add records one completed object of size bytes.
+func (p *progress) add(size int64) {
+	p.objects.Add(1)
+	p.bytes.Add(size)
+}
+
+// fail records one object that could not be copied or deleted.
+func (p *progress) fail() { p.failed.Add(1) }
+
+// addTotal grows the total of an operation that discovers its objects as it
+// goes, and setFinal marks it complete.
+func (p *progress) addTotal(n int64) { p.total.Add(n) }
+func (p *progress) setFinal()        { p.final.Store(true) }
+
+// finish stops the periodic logs.
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+	if p.interval <= 0 {
+		<-p.stop
+		return
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.log()
+		}
+	}
+}
+
+func (p *progress) log() {
+	elapsed := time.Since(p.start).Seconds()
+	objects, total := p.objects.Load(), p.total.Load()
+	rate := float64(objects) / elapsed
+	args := []any{
+		"op", p.op,
+		"bucket", p.bucket,
+		"completed", objects,
+		"total", total,
+		"objects_per_sec", int64(rate),
+		"mib_per_sec", int64(float64(p.bytes.Load()) / elapsed / (1 << 20)),
+	}
+	if p.final.Load() && rate > 0 {
+		eta := time.Duration(float64(total-objects) / rate * float64(time.Second))
+		args = append(args, "eta", eta.Round(time.Second))
+	}
+	slog.Info("Progress", args...)
+}
@@ -0,0 +1,100 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Supported --hold values.
+const (
+	HoldEvent     = "event"
+	HoldTemporary = "temporary"
+)
+
+// protected reports whether the file-th object of a job gets the configured
+// hold or retention: every ProtectEvery-th file of every job, starting with
+// file 0.
+func (c *Config) protected(file int) bool {
+	return c.protects() && file%c.ProtectEvery == 0
+}
+
+// protects reports whether setup places holds or retention at all.
+func (c *Config) protects() bool {
+	return c.Hold != "" || c.Retention > 0
+}
+
+// protectObjects places the configured hold and object retention on the
+// protected objects, which gcsfuse must then fail to delete or overwrite
+// with EPERM.
+func protectObjects(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	update := storage.ObjectAttrsToUpdate{}
+	switch cfg.Hold {
+	case HoldEvent:
+		update.EventBasedHold = true
+	case HoldTemporary:
+		update.TemporaryHold = true
+	}
+	if cfg.Retention > 0 {
+		update.Retention = &storage.ObjectRetention{Mode: "Unlocked", RetainUntil: time.Now().Add(cfg.Retention)}
+	}
+
+	var names []string
+	for j := 0; j < cfg.NumJobs; j++ {
+		for n := 0; n < cfg.NrFiles; n++ {
+			if cfg.protected(n) {
+				names = append(names, cfg.objectName(j, n))
+			}
+		}
+	}
+	slog.Info("Protecting objects", "count", len(names), "every", cfg.ProtectEvery, "hold", cfg.Hold, "retention", cfg.Retention)
+
+	sem := make(chan struct{}, cfg.Workers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, name := range names {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := bucket.Object(name).Update(ctx, update); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("protecting %s: %w", name, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// releaseObject removes the holds and unlocked retention of attrs so the
+// object can be deleted. Locked retention cannot be removed and makes the
+// following delete fail.
+func releaseObject(ctx context.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs) error {
+	if !attrs.EventBasedHold && !attrs.TemporaryHold && attrs.Retention == nil {
+		return nil
+	}
+	update := storage.ObjectAttrsToUpdate{}
+	if attrs.EventBasedHold {
+		update.EventBasedHold = false
+	}
+	if attrs.TemporaryHold {
+		update.TemporaryHold = false
+	}
+	if attrs.Retention != nil {
+		obj = obj.OverrideUnlockedRetention(true)
+		update.Retention = &storage.ObjectRetention{}
+	}
+	if _, err := obj.Update(ctx, update); err != nil {
+		return fmt.Errorf("releasing holds and retention of %s: %w", obj.ObjectName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limiter caps the requests per second and bytes per second of all the
+// workers of a run against one bucket, and counts the requests GCS throttled.
+type limiter struct {
+	qps, bandwidth *rate.Limiter
+	throttled      atomic.Int64
+}
+
+// newLimiter returns the limiter of cfg. Zero MaxQPS or MaxBandwidth leaves
+// that dimension unlimited.
+func newLimiter(cfg Config) *limiter {
+	l := &limiter{}
+	if cfg.MaxQPS > 0 {
+		l.qps = rate.NewLimiter(rate.Limit(cfg.MaxQPS), max(1, int(cfg.MaxQPS)))
+	}
+	if cfg.MaxBandwidth > 0 {
+		// transfer waits for larger transfers, e.g. whole-object copies, in
+		// steps of the burst.
+		burst := max(cfg.MaxBandwidth, writeChunkSize)
+		l.bandwidth = rate.NewLimiter(rate.Limit(cfg.MaxBandwidth), int(burst))
+	}
+	return l
+}
+
+// request waits until one more request may be sent. A nil limiter never
+// waits.
+func (l *limiter) request(ctx context.Context) error {
+	if l == nil || l.qps == nil {
+		return nil
+	}
+	return l.qps.Wait(ctx)
+}
+
+// transfer waits until n more bytes may be moved. n may exceed the burst,
+// e.g. a 10G object copied at 100M/s, so it is waited for in steps.
+func (l *limiter) transfer(ctx context.Context, n int64) error {
+	if l == nil || l.bandwidth == nil {
+		return nil
+	}
+	burst := int64(l.bandwidth.Burst())
+	for n > 0 {
+		step := min(n, burst)
+		if err := l.bandwidth.WaitN(ctx, int(step)); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// observe counts err if GCS throttled the request, and reports whether it
+// did.
+func (l *limiter) observe(err error) bool {
+	if !IsThrottled(err) {
+		return false
+	}
+	if l != nil {
+		l.throttled.Add(1)
+	}
+	return true
+}
+
+// IsThrottled reports whether err is a 429 Too Many Requests or a 503
+// SlowDown, the responses GCS sends when a bucket exceeds its request rate.
+func IsThrottled(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusServiceUnavailable
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.ResourceExhausted || s.Code() == codes.Unavailable
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error classes of failed requests, for --retry-on and the summary.
+const (
+	// ErrThrottled is a 429 or a 503 slow down, see IsThrottled.
+	ErrThrottled = "throttled"
+	// ErrServer is any other 5xx or internal error of GCS.
+	ErrServer = "server"
+	// ErrTimeout is a request that timed out while the run went on.
+	ErrTimeout = "timeout"
+	// ErrNetwork is a reset, refused or cut connection.
+	ErrNetwork = "network"
+	// ErrClient is a 4xx other than 429, e.g. a permission or precondition
+	// failure, which retrying does not fix.
+	ErrClient = "client"
+	// ErrOther is anything else.
+	ErrOther = "other"
+)
+
+// ErrorClasses lists the error classes.
+var ErrorClasses = []string{ErrThrottled, ErrServer, ErrTimeout, ErrNetwork, ErrClient, ErrOther}
+
+// RetryPolicy decides how copies, deletes and uploads are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, at least 1.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failure, doubled after
+	// every further one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of every wait that is random, from 0 (fixed
+	// waits) to 1 (full jitter), so workers failing together do not retry
+	// together.
+	Jitter float64
+	// RetryOn are the error classes retried; the others fail at once.
+	RetryOn []string
+}
+
+// DefaultRetryPolicy retries throttling, server, timeout and network errors
+// 5 times from 100ms.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.5,
+	RetryOn:        []string{ErrThrottled, ErrServer, ErrTimeout, ErrNetwork},
+}
+
+// Validate reports out-of-range retry options.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return errors.New("--retry-attempts must be at least 1")
+	}
+	if p.InitialBackoff <= 0 || p.MaxBackoff < p.InitialBackoff {
+		return errors.New("--retry-initial-backoff must be greater than 0 and at most --retry-max-backoff")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("--retry-jitter must be between 0 and 1")
+	}
+	for _, c := range p.RetryOn {
+		if !slices.Contains(ErrorClasses, c) {
+			return fmt.Errorf("unsupported --retry-on class %q (want %v)", c, ErrorClasses)
+		}
+	}
+	return nil
+}
+
+// classifyError returns the error class of err, the error of a request
+// sent with ctx.
+func classifyError(ctx context.Context, err error) string {
+	if IsThrottled(err) {
+		return ErrThrottled
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch {
+		case gerr.Code >= http.StatusInternalServerError:
+			return ErrServer
+		case gerr.Code == http.StatusRequestTimeout:
+			return ErrTimeout
+		case gerr.Code >= http.StatusBadRequest:
+			return ErrClient
+		}
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		switch s.Code() {
+		case codes.Internal, codes.DataLoss:
+			return ErrServer
+		case codes.DeadlineExceeded:
+			return ErrTimeout
+		case codes.Canceled:
+		default:
+			return ErrClient
+		}
+	}
+	if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return ErrTimeout
+	}
+	if errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return ErrNetwork
+	}
+	return ErrOther
+}
+
+// retrier applies a RetryPolicy for all the workers of a run and counts
+// their failed requests by error class.
+type retrier struct {
+	policy  RetryPolicy
+	retryOn map[string]bool
+
+	mu      sync.Mutex
+	errors  map[string]int64
+	retries int64
+
+	// metrics, if not nil, receives every attempt to bucket.
+	metrics *Metrics
+	bucket  string
+}
+
+// newRetrier returns the retrier of p, or of DefaultRetryPolicy if p is
+// the zero value.
+func newRetrier(p RetryPolicy) *retrier {
+	if p.MaxAttempts == 0 {
+		p = DefaultRetryPolicy
+	}
+	r := &retrier{policy: p, retryOn: map[string]bool{}, errors: map[string]int64{}}
+	for _, c := range p.RetryOn {
+		r.retryOn[c] = true
+	}
+	return r
+}
+
+// do calls fn until it succeeds, fails with an error that is not retried or
+// has been called MaxAttempts times, waiting with exponential backoff in
+// between, and returns its last error. op is the kind of the request in
+// the metrics, and what names it in logs.
+func (r *retrier) do(ctx context.Context, op, what string, fn func() error) error {
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		r.metrics.begin(r.bucket)
+		start := time.Now()
+		err := fn()
+		if err == nil {
+			r.metrics.end(r.bucket, op, time.Since(start), "")
+			return nil
+		}
+		class := classifyError(ctx, err)
+		r.metrics.end(r.bucket, op, time.Since(start), class)
+		if ctx.Err() != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.errors[class]++
+		retry := r.retryOn[class] && attempt < r.policy.MaxAttempts
+		if retry {
+			r.retries++
+		}
+		r.mu.Unlock()
+		if !retry {
+			if attempt > 1 {
+				return fmt.Errorf("%s after %d attempts: %w", what, attempt, err)
+			}
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		slog.Warn("Request failed, retrying", "request", what, "attempt", attempt, "class", class, "err", err)
+		wait := time.Duration(float64(backoff) * (1 - r.policy.Jitter*rand.Float64()))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, r.policy.MaxBackoff)
+	}
+}
+
+// counts returns the failed requests by error class and the retries.
+func (r *retrier) counts() (map[string]int64, int64) {
+	if r == nil {
+		return nil, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errors) == 0 {
+		return nil, r.retries
+	}
+	m := make(map[string]int64, len(r.errors))
+	for k, v := range r.errors {
+		m[k] = v
+	}
+	return m, r.retries
+}
@@ -0,0 +1,350 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// writeChunkSize is the buffer size used to stream the source object.
+	writeChunkSize = 8 << 20
+)
+
+func setup(ctx context.Context, client *storage.Client, cfg Config, s *Summary) error {
+	bucket := cfg.bucket(client)
+	if cfg.Retention > 0 {
+		bucket = bucket.SetObjectRetention(true)
+	}
+	s.Location = cfg.Location
+	exists := false
+	if cfg.Resume || cfg.SkipBucketCreate {
+		attrs, err := bucket.Attrs(ctx)
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest {
+			// Requester pays buckets reject requests without a billing
+			// project.
+			attrs, err = bucket.UserProject(cfg.billingProject()).Attrs(ctx)
+		}
+		switch {
+		case err == nil:
+			if cfg.Resume {
+				slog.Info("Resuming setup in the existing bucket", "bucket", cfg.Bucket)
+			} else {
+				slog.Info("Populating the existing bucket", "bucket", cfg.Bucket, "requester_pays", attrs.RequesterPays,
+					"kms_key", attrs.Encryption != nil && attrs.Encryption.DefaultKMSKeyName != "")
+			}
+			if err := checkExisting(attrs, cfg); err != nil {
+				return err
+			}
+			if attrs.RequesterPays {
+				bucket = bucket.UserProject(cfg.billingProject())
+			}
+			exists = true
+			s.Location = attrs.Location
+		case errors.Is(err, storage.ErrBucketNotExist) && cfg.SkipBucketCreate:
+			return fmt.Errorf("bucket %s does not exist; --skip-bucket-create only populates existing buckets", cfg.Bucket)
+		case !errors.Is(err, storage.ErrBucketNotExist):
+			return fmt.Errorf("reading attributes of %s: %w", cfg.Bucket, err)
+		}
+	}
+	if !exists {
+		if err := timed(s, "create-bucket", func() error { return createBucket(ctx, bucket, cfg) }); err != nil {
+			return err
+		}
+	}
+	if cfg.GrantMember != "" {
+		if err := timed(s, "grant", func() error { return grant(ctx, bucket, cfg) }); err != nil {
+			return err
+		}
+	}
+
+	if cfg.writes() {
+		if err := timed(s, "create-dirs", func() error { return createDirs(ctx, bucket, cfg) }); err != nil {
+			return err
+		}
+	}
+	if !cfg.hasObjects() {
+		return nil
+	}
+	if cfg.Manifest {
+		cfg.manifest = &ChecksumManifest{}
+	}
+	for _, part := range cfg.parts() {
+		if err := populate(ctx, bucket, part, exists && cfg.Resume, s); err != nil {
+			return err
+		}
+	}
+	if cfg.Manifest {
+		return timed(s, "write-manifest", func() error { return writeManifest(ctx, bucket, cfg) })
+	}
+	return nil
+}
+
+// checkExisting reports settings of cfg that the existing bucket of attrs
+// cannot satisfy.
+func checkExisting(attrs *storage.BucketAttrs, cfg Config) error {
+	hns := attrs.HierarchicalNamespace != nil && attrs.HierarchicalNamespace.Enabled
+	if hns != (cfg.BucketType == BucketHNS) {
+		return fmt.Errorf("bucket %s has hierarchical namespace %t; set --bucket-type to match", cfg.Bucket, hns)
+	}
+	if cfg.Retention > 0 && attrs.ObjectRetentionMode != "Enabled" {
+		return fmt.Errorf("bucket %s does not have object retention enabled, which --retention needs", cfg.Bucket)
+	}
+	return nil
+}
+
+// populate creates the objects of a single-class cfg that do not exist yet,
+// and protects them.
+func populate(ctx context.Context, bucket *storage.BucketHandle, cfg Config, exists bool, s *Summary) error {
+	var done map[string]bool
+	if exists {
+		err := timed(s, cfg.phaseName("list-existing"), func() (err error) {
+			done, err = existingObjects(ctx, bucket, cfg)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if int64(len(done)) < cfg.ObjectCount() {
+		src := cfg.object(bucket, cfg.namePrefix()+".source")
+		ph := s.phase(cfg.phaseName("create-source"))
+		err := createObject(ctx, bucket, src, cfg.FileSize, cfg)
+		ph.end(nil)
+		if err != nil {
+			return err
+		}
+		// The source is not part of the dataset, but its upload measures the
+		// client protocol.
+		ph.MiBPerSec = mibPerSec(cfg.FileSize, ph.ElapsedSec)
+		if cfg.checksumMode() != ChecksumNone || cfg.manifest != nil {
+			attrs, err := src.Attrs(ctx)
+			if err != nil {
+				return fmt.Errorf("reading attributes of source object %s: %w", src.ObjectName(), err)
+			}
+			cfg.source = attrs
+			cfg.manifest.record(cfg, attrs)
+		}
+		if err := parallelCopyObjects(ctx, bucket, src, done, cfg, s); err != nil {
+			return err
+		}
+		if err := src.Delete(ctx); err != nil {
+			return fmt.Errorf("deleting source object %s: %w", src.ObjectName(), err)
+		}
+	} else {
+		slog.Info("All objects already exist", "count", len(done))
+	}
+	if cfg.protects() {
+		return timed(s, cfg.phaseName("protect"), func() error { return protectObjects(ctx, bucket, cfg) })
+	}
+	return nil
+}
+
+// createBucket creates the benchmark bucket in cfg.Location with the
+// configured access control, and checks that organization policies did not
+// override it, as gcsfuse's permission checks differ between uniform and
+// fine-grained buckets.
+func createBucket(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	uniform := cfg.uniformAccess()
+	slog.Info("Creating bucket", "bucket", bucket.BucketName(), "location", cfg.Location, "type", cfg.BucketType,
+		"uniform_bucket_level_access", uniform, "public_access_prevention", cfg.PublicAccessPrevention,
+		"storage_class", cfg.StorageClass, "autoclass", cfg.Autoclass, "versioning", cfg.Versioning, "run", cfg.RunID)
+	attrs := &storage.BucketAttrs{
+		Location:                 cfg.Location,
+		StorageClass:             cfg.StorageClass,
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: uniform},
+		Labels:                   cfg.bucketLabels(time.Now()),
+		VersioningEnabled:        cfg.Versioning,
+	}
+	if cfg.Autoclass {
+		attrs.Autoclass = &storage.Autoclass{Enabled: true}
+	}
+	if cfg.SoftDeleteRetention != nil {
+		attrs.SoftDeletePolicy = &storage.SoftDeletePolicy{RetentionDuration: *cfg.SoftDeleteRetention}
+	}
+	if cfg.BucketType == BucketHNS {
+		attrs.HierarchicalNamespace = &storage.HierarchicalNamespace{Enabled: true}
+	}
+	switch cfg.PublicAccessPrevention {
+	case PAPEnforced:
+		attrs.PublicAccessPrevention = storage.PublicAccessPreventionEnforced
+	case PAPInherited:
+		attrs.PublicAccessPrevention = storage.PublicAccessPreventionInherited
+	}
+	if err := bucket.Create(ctx, cfg.Project, attrs); err != nil {
+		return fmt.Errorf("creating bucket %s: %w", bucket.BucketName(), err)
+	}
+
+	got, err := bucket.Attrs(ctx)
+	if err != nil {
+		return fmt.Errorf("reading attributes of %s: %w", bucket.BucketName(), err)
+	}
+	if got.UniformBucketLevelAccess.Enabled != uniform {
+		return fmt.Errorf("bucket %s was created with uniform bucket-level access %t instead of %t; an organization policy likely enforces it",
+			bucket.BucketName(), got.UniformBucketLevelAccess.Enabled, uniform)
+	}
+	if cfg.BucketType == BucketHNS && (got.HierarchicalNamespace == nil || !got.HierarchicalNamespace.Enabled) {
+		return fmt.Errorf("bucket %s was created without hierarchical namespace", bucket.BucketName())
+	}
+	if cfg.PublicAccessPrevention != "" && got.PublicAccessPrevention.String() != cfg.PublicAccessPrevention {
+		return fmt.Errorf("bucket %s was created with public access prevention %s instead of %s; an organization policy likely enforces it",
+			bucket.BucketName(), got.PublicAccessPrevention, cfg.PublicAccessPrevention)
+	}
+	if cfg.StorageClass != "" && got.StorageClass != cfg.StorageClass {
+		return fmt.Errorf("bucket %s was created with storage class %s instead of %s", bucket.BucketName(), got.StorageClass, cfg.StorageClass)
+	}
+	if cfg.Autoclass && (got.Autoclass == nil || !got.Autoclass.Enabled) {
+		return fmt.Errorf("bucket %s was created without autoclass", bucket.BucketName())
+	}
+	if d := cfg.SoftDeleteRetention; d != nil && (got.SoftDeletePolicy == nil || got.SoftDeletePolicy.RetentionDuration != *d) {
+		return fmt.Errorf("bucket %s was created without a soft delete retention of %s; an organization policy likely enforces another", bucket.BucketName(), *d)
+	}
+	return nil
+}
+
+// createObject uploads an object of the given size with the cfg.Data
+// content, in parallel parts if cfg.UploadParallelism allows more than one.
+func createObject(ctx context.Context, bucket *storage.BucketHandle, obj *storage.ObjectHandle, size int64, cfg Config) error {
+	slog.Info("Creating source object", "object", obj.ObjectName(), "size", size, "data", cfg.Data)
+	if parts, _ := uploadParts(size, cfg); parts > 1 {
+		return composeUpload(ctx, bucket, obj, size, cfg)
+	}
+	return writeObject(ctx, obj, size, cfg)
+}
+
+// existingObjects returns the dataset objects that already exist with
+// cfg.FileSize bytes. Copies are atomic, so these are complete; objects of
+// another size are copied again.
+func existingObjects(ctx context.Context, bucket *storage.BucketHandle, cfg Config) (map[string]bool, error) {
+	q := &storage.Query{Prefix: cfg.listPrefix()}
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return nil, err
+	}
+	complete := map[string]bool{}
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing objects in %s: %w", bucket.BucketName(), err)
+		}
+		if attrs.Size == cfg.FileSize {
+			complete[attrs.Name] = true
+		}
+	}
+	// Keep only dataset objects, not e.g. the source object or churn's.
+	done := map[string]bool{}
+	for j := 0; j < cfg.NumJobs; j++ {
+		for n := 0; n < cfg.NrFiles; n++ {
+			if name := cfg.objectName(j, n); complete[name] {
+				done[name] = true
+			}
+		}
+	}
+	return done, nil
+}
+
+// parallelCopyObjects copies src to every object of the NumJobs x NrFiles
+// matrix not in skip using cfg.Workers concurrent workers.
+func parallelCopyObjects(ctx context.Context, bucket *storage.BucketHandle, src *storage.ObjectHandle, skip map[string]bool, cfg Config, s *Summary) error {
+	total := cfg.NumJobs * cfg.NrFiles
+	slog.Info("Copying objects", "prefix", cfg.namePrefix(), "count", total, "filesize", cfg.FileSize, "workers", cfg.Workers)
+	var copied, skipped atomic.Int64
+	ph := s.phase(cfg.phaseName("copy"))
+	prog := startProgress("copy", bucket.BucketName(), int64(total-len(skip)), cfg.ProgressInterval)
+	defer func() {
+		prog.finish()
+		ph.end(prog)
+		slog.Info("Copied objects", "copied", copied.Load(), "skipped", skipped.Load(), "total", total)
+	}()
+
+	// The first failure cancels the remaining copies.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Objects are sent by their index in the matrix, which stamp needs.
+	indexes := make(chan int)
+	errs := make(chan error, cfg.Workers)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				name := cfg.objectName(i/cfg.NrFiles, i%cfg.NrFiles)
+				if err := copyObject(ctx, cfg.object(bucket, name), src, i, cfg); err != nil {
+					prog.fail()
+					errs <- err
+					cancel()
+					return
+				}
+				copied.Add(1)
+				prog.add(cfg.FileSize)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexes)
+		for j := 0; j < cfg.NumJobs; j++ {
+			for n := 0; n < cfg.NrFiles; n++ {
+				if skip[cfg.objectName(j, n)] {
+					skipped.Add(1)
+					continue
+				}
+				select {
+				case indexes <- j*cfg.NrFiles + n:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// copyObject performs a server-side copy of src to dst, the index-th object
+// of the job matrix, within the rate limits of cfg, retrying failures by
+// cfg.Retry, and verifies it. The copy gets the metadata of cfg.stamp.
+func copyObject(ctx context.Context, dst, src *storage.ObjectHandle, index int, cfg Config) error {
+	var attrs *storage.ObjectAttrs
+	err := cfg.retry.do(ctx, reqCopy, "copying to "+dst.ObjectName(), func() error {
+		if err := cfg.limiter.request(ctx); err != nil {
+			return err
+		}
+		if err := cfg.limiter.transfer(ctx, cfg.FileSize); err != nil {
+			return err
+		}
+		c := dst.CopierFrom(src)
+		if cfg.stamps() {
+			cfg.stamp(&c.ObjectAttrs, index)
+		}
+		var err error
+		if attrs, err = c.Run(ctx); err != nil {
+			cfg.limiter.observe(err)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return verifyCopy(attrs, cfg.source, cfg)
+}
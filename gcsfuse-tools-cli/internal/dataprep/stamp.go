@@ -0,0 +1,55 @@
+package dataprep
+
+import (
+	"errors"
+	"maps"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// MtimeKey is the custom metadata key gcsfuse reads the mtime of an object
+// from, in RFC 3339 format, instead of its update time.
+const MtimeKey = "gcsfuse_mtime"
+
+// stamps reports whether setup sets metadata on the copies.
+func (c *Config) stamps() bool {
+	return c.ContentType != "" || len(c.Metadata) > 0 || !c.Mtime.IsZero()
+}
+
+// validStamp reports unusable --content-type, --metadata and --mtime
+// options.
+func (c *Config) validStamp() error {
+	if c.MtimeStep < 0 {
+		return errors.New("--mtime-step must not be negative")
+	}
+	if c.MtimeStep > 0 && c.Mtime.IsZero() {
+		return errors.New("--mtime-step needs --mtime")
+	}
+	if _, ok := c.Metadata[MtimeKey]; ok && !c.Mtime.IsZero() {
+		return errors.New("--metadata must not set " + MtimeKey + " with --mtime")
+	}
+	for k := range c.Metadata {
+		if k == "" {
+			return errors.New("--metadata keys must not be empty")
+		}
+	}
+	return nil
+}
+
+// stamp sets the configured content type and metadata on the copy to the
+// index-th object of the job matrix, whose mtime is Mtime plus index
+// MtimeSteps, so that every run creates the same metadata.
+func (c *Config) stamp(attrs *storage.ObjectAttrs, index int) {
+	attrs.ContentType = c.ContentType
+	if len(c.Metadata) == 0 && c.Mtime.IsZero() {
+		return
+	}
+	attrs.Metadata = maps.Clone(c.Metadata)
+	if attrs.Metadata == nil {
+		attrs.Metadata = map[string]string{}
+	}
+	if !c.Mtime.IsZero() {
+		attrs.Metadata[MtimeKey] = c.Mtime.Add(time.Duration(index) * c.MtimeStep).UTC().Format(time.RFC3339Nano)
+	}
+}
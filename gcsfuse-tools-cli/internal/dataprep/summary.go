@@ -0,0 +1,116 @@
+package dataprep
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Summary is the machine-readable record of a data-prep run, written with
+// --output-json so pipelines can archive and compare runs without parsing
+// the logs.
+type Summary struct {
+	OpType string `json:"op_type"`
+	Bucket string `json:"bucket"`
+	// Prefix scopes a delete.
+	Prefix    string `json:"prefix,omitempty"`
+	Location  string `json:"location,omitempty"`
+	BenchType string `json:"bench_type,omitempty"`
+	// Objects and Bytes count what the run copied, deleted or churned, and
+	// Errors the objects that failed.
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	Errors  int64 `json:"errors"`
+	// Throttled counts the requests GCS rejected with 429 or 503 (slow
+	// down); they were retried, but a high count calls for --max-qps.
+	Throttled int64 `json:"throttled"`
+	// ErrorClasses counts the failed requests by error class (throttled,
+	// server, timeout, network, client or other), and Retries the ones
+	// retried under the retry policy.
+	ErrorClasses map[string]int64 `json:"error_classes,omitempty"`
+	Retries      int64            `json:"retries"`
+	// Checksum is the --checksum mode of the uploads and copies of setup
+	// and churn.
+	Checksum string `json:"checksum,omitempty"`
+	// Protocol is the API the client spoke, http or grpc.
+	Protocol   string    `json:"protocol"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	ElapsedSec float64   `json:"elapsed_sec"`
+	Phases     []*Phase  `json:"phases"`
+	// Error is why the run failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Phase is one step of a run, e.g. creating the bucket or copying objects.
+type Phase struct {
+	Name       string  `json:"name"`
+	ElapsedSec float64 `json:"elapsed_sec"`
+	Objects    int64   `json:"objects,omitempty"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	Errors     int64   `json:"errors,omitempty"`
+	// MiBPerSec is the throughput of the bytes the phase moved, to compare
+	// client protocols.
+	MiBPerSec float64 `json:"mib_per_sec,omitempty"`
+	start     time.Time
+}
+
+// phase starts timing the named step of the run.
+func (s *Summary) phase(name string) *Phase {
+	p := &Phase{Name: name, start: time.Now()}
+	s.Phases = append(s.Phases, p)
+	return p
+}
+
+// end stops timing p and takes its counts from prog, if any.
+func (p *Phase) end(prog *progress) {
+	p.ElapsedSec = time.Since(p.start).Seconds()
+	if prog != nil {
+		p.Objects, p.Bytes, p.Errors = prog.objects.Load(), prog.bytes.Load(), prog.failed.Load()
+	}
+	p.MiBPerSec = mibPerSec(p.Bytes, p.ElapsedSec)
+}
+
+// mibPerSec returns the throughput of moving n bytes in sec seconds.
+func mibPerSec(n int64, sec float64) float64 {
+	if n == 0 || sec <= 0 {
+		return 0
+	}
+	return float64(n) / (1 << 20) / sec
+}
+
+// timed runs fn as the named phase of s.
+func timed(s *Summary, name string, fn func() error) error {
+	ph := s.phase(name)
+	defer ph.end(nil)
+	return fn()
+}
+
+// finish totals the phases and records err.
+func (s *Summary) finish(err error) {
+	s.End = time.Now()
+	s.ElapsedSec = s.End.Sub(s.Start).Seconds()
+	for _, p := range s.Phases {
+		s.Objects += p.Objects
+		s.Bytes += p.Bytes
+		s.Errors += p.Errors
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// WriteJSON writes a Summary, or those of a fan-out, to path, or to stdout
+// if path is "-".
+func WriteJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,76 @@
+package dataprep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxUploadParallelism is the most source objects of one compose request,
+// and so the most parts of a parallel upload.
+const maxUploadParallelism = 32
+
+// uploadParts splits an object of size bytes in at most cfg.UploadParallelism
+// parts of a whole number of randomBlocks, returning the part size.
+func uploadParts(size int64, cfg Config) (parts int, partSize int64) {
+	partSize = (size + int64(cfg.UploadParallelism) - 1) / int64(cfg.UploadParallelism)
+	partSize = max(randomBlock, (partSize+randomBlock-1)/randomBlock*randomBlock)
+	return int((size + partSize - 1) / partSize), partSize
+}
+
+// composeUpload writes size bytes of cfg.Data content to obj by uploading
+// its parts concurrently as temporary objects and composing them, the same
+// content writeObject produces sequentially. Composite objects have a CRC32C
+// but no MD5.
+func composeUpload(ctx context.Context, bucket *storage.BucketHandle, obj *storage.ObjectHandle, size int64, cfg Config) error {
+	parts, partSize := uploadParts(size, cfg)
+	slog.Info("Uploading in parallel", "object", obj.ObjectName(), "size", size, "parts", parts, "part_size", partSize)
+
+	// The first failure cancels the other parts.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	handles := make([]*storage.ObjectHandle, parts)
+	errs := make(chan error, parts)
+	var wg sync.WaitGroup
+	for i := range handles {
+		handles[i] = cfg.object(bucket, fmt.Sprintf("%s.part-%d", obj.ObjectName(), i))
+		off := int64(i) * partSize
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeRange(ctx, handles[i], off, min(partSize, size-off), cfg); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	defer func() {
+		// Parts that failed or were never created are not found.
+		for _, h := range handles {
+			if err := h.Delete(context.WithoutCancel(ctx)); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				slog.Warn("Deleting upload part failed", "object", h.ObjectName(), "err", err)
+			}
+		}
+	}()
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	// A failed compose is retried like the part uploads; giving up would
+	// throw all the uploaded parts away.
+	what := fmt.Sprintf("composing %s from %d parts", obj.ObjectName(), parts)
+	return cfg.retry.do(ctx, reqUpload, what, func() error {
+		if err := cfg.limiter.request(ctx); err != nil {
+			return err
+		}
+		_, err := obj.ComposerFrom(handles...).Run(ctx)
+		cfg.limiter.observe(err)
+		return err
+	})
+}
@@ -0,0 +1,165 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// maxVerifyListed bounds how many names of each kind a VerifyReport lists;
+// the counts cover all of them.
+const maxVerifyListed = 100
+
+// SizeMismatch is a dataset object whose size differs from --filesize.
+type SizeMismatch struct {
+	Name string `json:"name"`
+	Want int64  `json:"want_bytes"`
+	Got  int64  `json:"got_bytes"`
+}
+
+// VerifyReport compares the objects of a bucket with the dataset a setup
+// with the same flags creates.
+type VerifyReport struct {
+	Bucket   string `json:"bucket"`
+	Expected int    `json:"expected"`
+	Found    int    `json:"found"`
+	// Missing and Mismatched count every missing and wrongly sized
+	// object; the name lists are truncated to maxVerifyListed entries.
+	Missing        int            `json:"missing"`
+	Mismatched     int            `json:"mismatched"`
+	MissingNames   []string       `json:"missing_names,omitempty"`
+	SizeMismatches []SizeMismatch `json:"size_mismatches,omitempty"`
+	// Unexpected counts other objects under the dataset prefix, e.g. those
+	// created by churn. They do not fail the verification.
+	Unexpected      int      `json:"unexpected"`
+	UnexpectedNames []string `json:"unexpected_names,omitempty"`
+}
+
+// OK reports whether every expected object exists with the expected size.
+func (r *VerifyReport) OK() bool {
+	return r.Missing == 0 && r.Mismatched == 0
+}
+
+// Verify lists the bucket and checks that all NumJobs*NrFiles objects of the
+// dataset, if it has any, exist with FileSize bytes, or those of every class
+// of a mixed dataset with the size of their class.
+func Verify(ctx context.Context, client *storage.Client, cfg Config) (*VerifyReport, error) {
+	r := &VerifyReport{Bucket: cfg.Bucket}
+	for _, part := range cfg.parts() {
+		pr, err := verify(ctx, client, part)
+		if err != nil {
+			return nil, err
+		}
+		r.add(pr)
+	}
+	return r, nil
+}
+
+// add merges the report of one class into r.
+func (r *VerifyReport) add(o *VerifyReport) {
+	r.Expected += o.Expected
+	r.Found += o.Found
+	r.Missing += o.Missing
+	r.Mismatched += o.Mismatched
+	r.Unexpected += o.Unexpected
+	r.MissingNames = appendListed(r.MissingNames, o.MissingNames...)
+	r.SizeMismatches = appendListed(r.SizeMismatches, o.SizeMismatches...)
+	r.UnexpectedNames = appendListed(r.UnexpectedNames, o.UnexpectedNames...)
+}
+
+// appendListed appends to a name list up to maxVerifyListed entries.
+func appendListed[T any](list []T, items ...T) []T {
+	return append(list, items[:min(len(items), max(0, maxVerifyListed-len(list)))]...)
+}
+
+func verify(ctx context.Context, client *storage.Client, cfg Config) (*VerifyReport, error) {
+	expected := make(map[string]bool, cfg.ObjectCount())
+	for j := 0; cfg.hasObjects() && j < cfg.NumJobs; j++ {
+		for n := 0; n < cfg.NrFiles; n++ {
+			expected[cfg.objectName(j, n)] = false
+		}
+	}
+	r := &VerifyReport{Bucket: cfg.Bucket, Expected: len(expected)}
+	slog.Info("Verifying dataset", "bucket", cfg.Bucket, "prefix", cfg.namePrefix(), "expected", r.Expected, "filesize", cfg.FileSize)
+
+	q := &storage.Query{Prefix: cfg.listPrefix()}
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return nil, err
+	}
+	it := cfg.bucket(client).Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing objects in %s: %w", cfg.Bucket, err)
+		}
+		if strings.HasSuffix(attrs.Name, "/") || attrs.Name == ManifestObject {
+			// Directory markers of a write dataset, and the checksums.
+			continue
+		}
+		seen, ok := expected[attrs.Name]
+		if !ok {
+			r.Unexpected++
+			if len(r.UnexpectedNames) < maxVerifyListed {
+				r.UnexpectedNames = append(r.UnexpectedNames, attrs.Name)
+			}
+			continue
+		}
+		if seen {
+			continue
+		}
+		expected[attrs.Name] = true
+		r.Found++
+		if attrs.Size != cfg.FileSize {
+			r.Mismatched++
+			if len(r.SizeMismatches) < maxVerifyListed {
+				r.SizeMismatches = append(r.SizeMismatches, SizeMismatch{Name: attrs.Name, Want: cfg.FileSize, Got: attrs.Size})
+			}
+		}
+	}
+
+	for name, seen := range expected {
+		if !seen {
+			r.Missing++
+			r.MissingNames = append(r.MissingNames, name)
+		}
+	}
+	sort.Strings(r.MissingNames)
+	if len(r.MissingNames) > maxVerifyListed {
+		r.MissingNames = r.MissingNames[:maxVerifyListed]
+	}
+	return r, nil
+}
+
+// WriteText prints one row per problem and a summary line.
+func (r *VerifyReport) WriteText(w io.Writer) error {
+	if !r.OK() {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "OBJECT\tPROBLEM")
+		for _, name := range r.MissingNames {
+			fmt.Fprintf(tw, "%s\tmissing\n", name)
+		}
+		for _, m := range r.SizeMismatches {
+			fmt.Fprintf(tw, "%s\tsize %d, want %d\n", m.Name, m.Got, m.Want)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if n := r.Missing + r.Mismatched - len(r.MissingNames) - len(r.SizeMismatches); n > 0 {
+			fmt.Fprintf(w, "... and %d more\n", n)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "gs://%s: %d of %d objects found, %d missing, %d with the wrong size, %d unexpected.\n",
+		r.Bucket, r.Found, r.Expected, r.Missing, r.Mismatched, r.Unexpected)
+	return err
+}
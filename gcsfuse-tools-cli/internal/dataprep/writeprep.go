@@ -0,0 +1,120 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	control "cloud.google.com/go/storage/control/apiv2"
+	"cloud.google.com/go/storage/control/apiv2/controlpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writes reports whether the dataset is prepared for a write benchmark.
+func (c *Config) writes() bool {
+	return c.BenchType == BenchWrite || c.BenchType == BenchRandWrite
+}
+
+// hasObjects reports whether setup writes the NumJobs x NrFiles objects.
+// Sequential write benchmarks create their files, so they only get objects to
+// overwrite with Prefill; random writes need existing files to write into.
+func (c *Config) hasObjects() bool {
+	return c.BenchType != BenchWrite || c.Prefill
+}
+
+// ObjectCount returns the number of dataset objects setup creates.
+func (c *Config) ObjectCount() int64 {
+	if !c.hasObjects() {
+		return 0
+	}
+	if len(c.Classes) > 0 {
+		var n int64
+		for _, cl := range c.Classes {
+			n += int64(cl.NumJobs * cl.NrFiles)
+		}
+		return n
+	}
+	return int64(c.NumJobs * c.NrFiles)
+}
+
+// dirNames returns the directories of the nested layout, parents before
+// their children, e.g. "write.0/", "write.0/d0/". The flat layout has none.
+func (c *Config) dirNames() []string {
+	if c.DirDepth == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var dirs []string
+	for j := 0; j < c.NumJobs; j++ {
+		for n := 0; n < c.NrFiles; n += c.FilesPerDir {
+			name := c.objectName(j, n)
+			for i := range name {
+				if d := name[:i+1]; name[i] == '/' && !seen[d] {
+					seen[d] = true
+					dirs = append(dirs, d)
+				}
+			}
+		}
+	}
+	return dirs
+}
+
+// createDirs creates the directories of the layout so a write benchmark can
+// create its files in them: folders in an HNS bucket, and zero-byte "dir/"
+// marker objects otherwise, which gcsfuse lists without --implicit-dirs.
+func createDirs(ctx context.Context, bucket *storage.BucketHandle, cfg Config) error {
+	dirs := cfg.dirNames()
+	if len(dirs) == 0 {
+		return nil
+	}
+	slog.Info("Creating directories", "count", len(dirs), "bucket_type", cfg.BucketType)
+	if cfg.BucketType == BucketHNS {
+		return createFolders(ctx, bucket.BucketName(), dirs)
+	}
+
+	sem := make(chan struct{}, cfg.Workers)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, d := range dirs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			w := bucket.Object(d).NewWriter(ctx)
+			if err := w.Close(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("creating directory marker %s: %w", d, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// createFolders creates the folders of an HNS bucket. dirs lists parents
+// before their children, which must exist first.
+func createFolders(ctx context.Context, bucket string, dirs []string) error {
+	client, err := control.NewStorageControlClient(ctx)
+	if err != nil {
+		return fmt.Errorf("creating storage control client: %w", err)
+	}
+	defer client.Close()
+
+	parent := "projects/_/buckets/" + bucket
+	for _, d := range dirs {
+		_, err := client.CreateFolder(ctx, &controlpb.CreateFolderRequest{Parent: parent, FolderId: d})
+		// Folders left by an interrupted setup are reused.
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("creating folder %s: %w", strings.TrimSuffix(d, "/"), err)
+		}
+	}
+	return nil
+}
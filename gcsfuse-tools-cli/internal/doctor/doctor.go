@@ -0,0 +1,144 @@
+// Package doctor checks that a host has what the benchmark and coherence
+// tools need: binaries, the FUSE device, credentials and bucket access.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	OK   Status = "ok"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Check is one line of the doctor report.
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Report is the full set of checks.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText prints one row per check.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "[%s]\t%s\t%s\n", strings.ToUpper(string(c.Status)), c.Name, c.Detail)
+	}
+	return tw.Flush()
+}
+
+// Config selects the optional checks.
+type Config struct {
+	// Bucket, when set, is checked for metadata read access.
+	Bucket string
+}
+
+// Run performs all checks. Individual failures are recorded in the report
+// rather than returned.
+func Run(ctx context.Context, cfg Config) *Report {
+	r := &Report{}
+	r.Checks = append(r.Checks,
+		checkBinary(ctx, "gcsfuse", Fail, "--version"),
+		checkBinary(ctx, "fio", Warn, "--version"),
+		checkBinary(ctx, "fusermount", Fail, "-V"),
+		checkFuseDevice(),
+		checkCredentials(ctx),
+	)
+	if cfg.Bucket != "" {
+		r.Checks = append(r.Checks, checkBucket(ctx, cfg.Bucket))
+	}
+	return r
+}
+
+// checkBinary looks name up on PATH and records the first line of its version
+// output. A missing binary is reported with the given severity.
+func checkBinary(ctx context.Context, name string, missing Status, versionFlag string) Check {
+	c := Check{Name: name}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		c.Status, c.Detail = missing, "not found on PATH"
+		return c
+	}
+	out, err := exec.CommandContext(ctx, path, versionFlag).CombinedOutput()
+	if err != nil {
+		c.Status, c.Detail = Warn, fmt.Sprintf("%s: version check failed: %v", path, err)
+		return c
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	c.Status, c.Detail = OK, fmt.Sprintf("%s (%s)", path, version)
+	return c
+}
+
+func checkFuseDevice() Check {
+	c := Check{Name: "/dev/fuse"}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		c.Status, c.Detail = Fail, err.Error()
+		return c
+	}
+	c.Status, c.Detail = OK, "present"
+	return c
+}
+
+func checkCredentials(ctx context.Context) Check {
+	c := Check{Name: "credentials"}
+	creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly)
+	if err != nil {
+		c.Status, c.Detail = Fail, err.Error()
+		return c
+	}
+	if _, err := creds.TokenSource.Token(); err != nil {
+		c.Status, c.Detail = Fail, fmt.Sprintf("cannot mint token: %v", err)
+		return c
+	}
+	c.Status, c.Detail = OK, "application default credentials"
+	if creds.ProjectID != "" {
+		c.Detail += " (project " + creds.ProjectID + ")"
+	}
+	return c
+}
+
+func checkBucket(ctx context.Context, bucket string) Check {
+	c := Check{Name: "gs://" + bucket}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		c.Status, c.Detail = Fail, err.Error()
+		return c
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		c.Status, c.Detail = Fail, err.Error()
+		return c
+	}
+	c.Status, c.Detail = OK, fmt.Sprintf("location %s, storage class %s", attrs.Location, attrs.StorageClass)
+	return c
+}
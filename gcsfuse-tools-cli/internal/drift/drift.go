@@ -0,0 +1,190 @@
+// Package drift compares the gcsfuse volumes of a GKE cluster against a
+// declared golden configuration and reports pods running with missing,
+// unexpected or deprecated mount options.
+package drift
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Deprecation maps a deprecated mount option to its replacement.
+type Deprecation struct {
+	Option      string `yaml:"option"`
+	Replacement string `yaml:"replacement"`
+}
+
+// DefaultDeprecations are the gcsfuse flags superseded by the metadata-cache
+// config section. They are checked in addition to the golden config's list.
+var DefaultDeprecations = []Deprecation{
+	{Option: "stat-cache-capacity", Replacement: "metadata-cache:stat-cache-max-size-mb"},
+	{Option: "stat-cache-ttl", Replacement: "metadata-cache:ttl-secs"},
+	{Option: "type-cache-ttl", Replacement: "metadata-cache:ttl-secs"},
+}
+
+// Golden is the declared configuration every gcsfuse volume should use.
+type Golden struct {
+	// MountOptions must be present with exactly these values. An option
+	// without a value ("implicit-dirs") only has to be present.
+	MountOptions []string `yaml:"mount_options"`
+	// VolumeAttributes must be set to these values.
+	VolumeAttributes map[string]string `yaml:"volume_attributes"`
+	// AllowedOptions may be set to any value without being reported as
+	// unexpected, e.g. uid, gid or logging:severity.
+	AllowedOptions []string      `yaml:"allowed_options"`
+	Deprecated     []Deprecation `yaml:"deprecated"`
+}
+
+// LoadGolden reads a YAML golden configuration.
+func LoadGolden(path string) (*Golden, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &Golden{}
+	if err := yaml.Unmarshal(b, g); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// Finding kinds.
+const (
+	Missing    = "missing"
+	Mismatch   = "mismatch"
+	Unexpected = "unexpected"
+	Deprecated = "deprecated"
+)
+
+// Finding is one deviation of a volume from the golden config.
+type Finding struct {
+	Kind     string `json:"kind"`
+	Option   string `json:"option"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// VolumeReport lists the findings of one volume.
+type VolumeReport struct {
+	Volume
+	Findings []Finding `json:"findings"`
+}
+
+// Report is the drift-check result.
+type Report struct {
+	Volumes []VolumeReport `json:"volumes"`
+}
+
+// Drifted returns the number of volumes with findings.
+func (r *Report) Drifted() int {
+	n := 0
+	for _, v := range r.Volumes {
+		if len(v.Findings) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Check compares every volume against g.
+func Check(g *Golden, volumes []Volume) *Report {
+	r := &Report{}
+	for _, v := range volumes {
+		r.Volumes = append(r.Volumes, VolumeReport{Volume: v, Findings: g.check(v)})
+	}
+	return r
+}
+
+func (g *Golden) check(v Volume) []Finding {
+	var findings []Finding
+	actual := map[string]string{}
+	for _, o := range v.MountOptions {
+		k, val := parseOption(o)
+		actual[k] = val
+	}
+
+	expected := map[string]string{}
+	for _, o := range g.MountOptions {
+		k, val := parseOption(o)
+		expected[k] = val
+		got, ok := actual[k]
+		switch {
+		case !ok:
+			findings = append(findings, Finding{Kind: Missing, Option: k, Expected: val})
+		case val != "" && got != val:
+			findings = append(findings, Finding{Kind: Mismatch, Option: k, Expected: val, Actual: got})
+		}
+	}
+
+	deprecated := append(slices.Clone(DefaultDeprecations), g.Deprecated...)
+	for _, o := range v.MountOptions {
+		k, val := parseOption(o)
+		if i := slices.IndexFunc(deprecated, func(d Deprecation) bool { return d.Option == k }); i >= 0 {
+			findings = append(findings, Finding{Kind: Deprecated, Option: k, Expected: deprecated[i].Replacement, Actual: val})
+			continue
+		}
+		if _, ok := expected[k]; !ok && !g.allowed(k) {
+			findings = append(findings, Finding{Kind: Unexpected, Option: k, Actual: val})
+		}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(g.VolumeAttributes)) {
+		want := g.VolumeAttributes[k]
+		got, ok := v.VolumeAttributes[k]
+		switch {
+		case !ok:
+			findings = append(findings, Finding{Kind: Missing, Option: "volumeAttributes." + k, Expected: want})
+		case got != want:
+			findings = append(findings, Finding{Kind: Mismatch, Option: "volumeAttributes." + k, Expected: want, Actual: got})
+		}
+	}
+	return findings
+}
+
+// allowed reports whether key or one of its config sections is allowed, so
+// allowing "logging" also allows "logging:severity".
+func (g *Golden) allowed(key string) bool {
+	for _, a := range g.AllowedOptions {
+		if key == a || strings.HasPrefix(key, a+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOption splits a mount option into key and value. Flag style options use
+// "=" ("uid=1001"); config file style options put the value after the last
+// ":" ("file-cache:max-size-mb:-1"). Boolean options have no value.
+func parseOption(o string) (key, value string) {
+	o = strings.TrimLeft(o, "-")
+	if k, v, ok := strings.Cut(o, "="); ok {
+		return k, v
+	}
+	if i := strings.LastIndex(o, ":"); i >= 0 && strings.Contains(o[:i], ":") {
+		return o[:i], o[i+1:]
+	}
+	return o, ""
+}
+
+// WriteText prints one row per finding and a summary line.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tVOLUME\tBUCKET\tKIND\tOPTION\tEXPECTED\tACTUAL")
+	for _, v := range r.Volumes {
+		for _, f := range v.Findings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Namespace, v.Pod, v.Name, v.Bucket, f.Kind, f.Option, f.Expected, f.Actual)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d of %d gcsfuse volumes drifted from the golden config.\n", r.Drifted(), len(r.Volumes))
+	return err
+}
@@ -0,0 +1,179 @@
+package drift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CSIDriver is the name of the Cloud Storage FUSE CSI driver.
+const CSIDriver = "gcsfuse.csi.storage.gke.io"
+
+// Volume is a gcsfuse volume mounted by a pod.
+type Volume struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Name      string `json:"volume"`
+	// Source is "inline" for ephemeral CSI volumes or "pv/<name>".
+	Source           string            `json:"source"`
+	Bucket           string            `json:"bucket"`
+	MountOptions     []string          `json:"mount_options"`
+	VolumeAttributes map[string]string `json:"volume_attributes"`
+}
+
+// KubeConfig selects the cluster and namespaces to read.
+type KubeConfig struct {
+	Kubectl   string
+	Context   string
+	Namespace string
+}
+
+// The subset of the Kubernetes objects read by drift-check.
+type (
+	csiSource struct {
+		Driver           string            `json:"driver"`
+		VolumeHandle     string            `json:"volumeHandle"`
+		VolumeAttributes map[string]string `json:"volumeAttributes"`
+	}
+	podList struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				Volumes []struct {
+					Name                  string     `json:"name"`
+					CSI                   *csiSource `json:"csi"`
+					PersistentVolumeClaim *struct {
+						ClaimName string `json:"claimName"`
+					} `json:"persistentVolumeClaim"`
+				} `json:"volumes"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	pvcList struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				VolumeName string `json:"volumeName"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	pvList struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				MountOptions []string   `json:"mountOptions"`
+				CSI          *csiSource `json:"csi"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+)
+
+// ListVolumes returns the gcsfuse volumes of every running pod, resolving
+// PersistentVolumeClaims to their PersistentVolume.
+func ListVolumes(ctx context.Context, cfg KubeConfig) ([]Volume, error) {
+	var pods podList
+	if err := cfg.get(ctx, "pods", true, &pods, "--field-selector=status.phase=Running"); err != nil {
+		return nil, err
+	}
+	var pvcs pvcList
+	if err := cfg.get(ctx, "persistentvolumeclaims", true, &pvcs); err != nil {
+		return nil, err
+	}
+	var pvs pvList
+	if err := cfg.get(ctx, "persistentvolumes", false, &pvs); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]string{}
+	for _, c := range pvcs.Items {
+		claims[c.Metadata.Namespace+"/"+c.Metadata.Name] = c.Spec.VolumeName
+	}
+	type pv struct {
+		mountOptions []string
+		csi          *csiSource
+	}
+	volumes := map[string]pv{}
+	for _, v := range pvs.Items {
+		if v.Spec.CSI != nil && v.Spec.CSI.Driver == CSIDriver {
+			volumes[v.Metadata.Name] = pv{v.Spec.MountOptions, v.Spec.CSI}
+		}
+	}
+
+	var out []Volume
+	for _, p := range pods.Items {
+		for _, v := range p.Spec.Volumes {
+			vol := Volume{Namespace: p.Metadata.Namespace, Pod: p.Metadata.Name, Name: v.Name}
+			switch {
+			case v.CSI != nil && v.CSI.Driver == CSIDriver:
+				vol.Source = "inline"
+				vol.Bucket = v.CSI.VolumeAttributes["bucketName"]
+				vol.VolumeAttributes = v.CSI.VolumeAttributes
+			case v.PersistentVolumeClaim != nil:
+				name := claims[p.Metadata.Namespace+"/"+v.PersistentVolumeClaim.ClaimName]
+				pv, ok := volumes[name]
+				if !ok {
+					continue
+				}
+				vol.Source = "pv/" + name
+				vol.Bucket = pv.csi.VolumeHandle
+				vol.VolumeAttributes = pv.csi.VolumeAttributes
+				vol.MountOptions = splitOptions(pv.mountOptions...)
+			default:
+				continue
+			}
+			vol.MountOptions = append(vol.MountOptions, splitOptions(vol.VolumeAttributes["mountOptions"])...)
+			out = append(out, vol)
+		}
+	}
+	return out, nil
+}
+
+// get runs kubectl get -o json for resource and decodes the list into v.
+func (cfg KubeConfig) get(ctx context.Context, resource string, namespaced bool, v any, extra ...string) error {
+	args := append([]string{"get", resource, "-o", "json"}, extra...)
+	if cfg.Context != "" {
+		args = append(args, "--context", cfg.Context)
+	}
+	if namespaced {
+		if cfg.Namespace != "" {
+			args = append(args, "--namespace", cfg.Namespace)
+		} else {
+			args = append(args, "--all-namespaces")
+		}
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.Kubectl, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("kubectl get %s: %w: %s", resource, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", resource, err)
+	}
+	return nil
+}
+
+// splitOptions flattens comma-separated mount option strings.
+func splitOptions(opts ...string) []string {
+	var out []string
+	for _, o := range opts {
+		for _, s := range strings.Split(o, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
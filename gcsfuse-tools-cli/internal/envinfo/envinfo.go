@@ -0,0 +1,364 @@
+// Package envinfo captures a machine-readable fingerprint of the host a
+// result was produced on, so benchmark and coherence results stay
+// interpretable long after the VM is gone.
+//
+// Every probe is best effort: a value that cannot be determined is left empty
+// and the reason is recorded in Fingerprint.Errors instead of failing the run.
+package envinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// ImageManifestPath is where images built by image-build record the
+// manifest of the tool versions they contain.
+const ImageManifestPath = "/etc/gcsfuse-tools/image-manifest.json"
+
+// Options selects what is fingerprinted beyond the host itself.
+type Options struct {
+	// GcsfuseBinary is the gcsfuse binary whose version is recorded.
+	GcsfuseBinary string
+	// MountPoint, when set, restricts Mounts to this mount point.
+	MountPoint string
+}
+
+// NIC is a network interface and its negotiated link speed.
+type NIC struct {
+	Name      string `json:"name"`
+	SpeedMbps int    `json:"speed_mbps,omitempty"`
+}
+
+// Mount is a gcsfuse mount and the process serving it.
+type Mount struct {
+	Bucket     string   `json:"bucket"`
+	MountPoint string   `json:"mount_point"`
+	Options    []string `json:"options"`
+	// Args is the gcsfuse command line of the serving process, when found.
+	Args []string `json:"args,omitempty"`
+}
+
+// Fingerprint describes the environment a result was produced in.
+type Fingerprint struct {
+	CapturedAt        time.Time `json:"captured_at"`
+	Hostname          string    `json:"hostname"`
+	MachineType       string    `json:"machine_type,omitempty"`
+	Zone              string    `json:"zone,omitempty"`
+	OS                string    `json:"os,omitempty"`
+	Kernel            string    `json:"kernel,omitempty"`
+	Arch              string    `json:"arch"`
+	NumCPU            int       `json:"num_cpu"`
+	MemTotalBytes     int64     `json:"mem_total_bytes,omitempty"`
+	NICs              []NIC     `json:"nics,omitempty"`
+	GcsfuseVersion    string    `json:"gcsfuse_version,omitempty"`
+	FuseModuleVersion string    `json:"fuse_module_version,omitempty"`
+	Mounts            []Mount   `json:"mounts,omitempty"`
+	// ImageManifest is the hash of the image-build manifest of the VM or
+	// container image the host runs, if any.
+	ImageManifest string `json:"image_manifest,omitempty"`
+	// Errors lists the probes that failed, keyed by field.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Capture probes the local host.
+func Capture(ctx context.Context, opts Options) *Fingerprint {
+	fp := &Fingerprint{
+		CapturedAt: time.Now().UTC(),
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+	}
+
+	var err error
+	if fp.Hostname, err = os.Hostname(); err != nil {
+		fp.addError("hostname", err)
+	}
+	if metadata.OnGCEWithContext(ctx) {
+		if fp.Zone, err = metadata.ZoneWithContext(ctx); err != nil {
+			fp.addError("zone", err)
+		}
+		if mt, err := metadata.GetWithContext(ctx, "instance/machine-type"); err != nil {
+			fp.addError("machine_type", err)
+		} else {
+			// The server returns projects/<num>/machineTypes/<type>.
+			fp.MachineType = path.Base(mt)
+		}
+	}
+	if fp.OS, err = osRelease(); err != nil {
+		fp.addError("os", err)
+	}
+	if fp.Kernel, err = readTrimmed("/proc/sys/kernel/osrelease"); err != nil {
+		fp.addError("kernel", err)
+	}
+	if fp.MemTotalBytes, err = memTotal(); err != nil {
+		fp.addError("mem_total_bytes", err)
+	}
+	if fp.NICs, err = nics(); err != nil {
+		fp.addError("nics", err)
+	}
+	if fp.FuseModuleVersion, err = fuseModuleVersion(); err != nil {
+		fp.addError("fuse_module_version", err)
+	}
+	if opts.GcsfuseBinary != "" {
+		if fp.GcsfuseVersion, err = gcsfuseVersion(ctx, opts.GcsfuseBinary); err != nil {
+			fp.addError("gcsfuse_version", err)
+		}
+	}
+	if fp.Mounts, err = gcsfuseMounts(opts.MountPoint); err != nil {
+		fp.addError("mounts", err)
+	}
+	if fp.ImageManifest, err = imageManifest(); err != nil {
+		fp.addError("image_manifest", err)
+	}
+	return fp
+}
+
+func (fp *Fingerprint) addError(field string, err error) {
+	if fp.Errors == nil {
+		fp.Errors = map[string]string{}
+	}
+	fp.Errors[field] = err.Error()
+}
+
+// imageManifest returns the hash recorded in ImageManifestPath, or "" on
+// hosts not built by image-build.
+func imageManifest() (string, error) {
+	b, err := os.ReadFile(ImageManifestPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var m struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", ImageManifestPath, err)
+	}
+	return m.Hash, nil
+}
+
+func readTrimmed(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// osRelease returns PRETTY_NAME from /etc/os-release.
+func osRelease() (string, error) {
+	b, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+			return strings.Trim(v, `"`), nil
+		}
+	}
+	return "", fmt.Errorf("PRETTY_NAME not found in /etc/os-release")
+}
+
+// memTotal returns MemTotal from /proc/meminfo in bytes.
+func memTotal() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// nics lists the non-loopback interfaces with their link speed. Virtual NICs
+// that don't report a speed are listed without one.
+func nics() ([]NIC, error) {
+	entries, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return nil, err
+	}
+	var out []NIC
+	for _, e := range entries {
+		if e.Name() == "lo" {
+			continue
+		}
+		nic := NIC{Name: e.Name()}
+		if s, err := readTrimmed(filepath.Join("/sys/class/net", e.Name(), "speed")); err == nil {
+			if speed, err := strconv.Atoi(s); err == nil && speed > 0 {
+				nic.SpeedMbps = speed
+			}
+		}
+		out = append(out, nic)
+	}
+	return out, nil
+}
+
+// fuseModuleVersion returns the FUSE kernel protocol version. Built-in fuse
+// exposes it under /sys/module as well, so modinfo is only a fallback.
+func fuseModuleVersion() (string, error) {
+	if v, err := readTrimmed("/sys/module/fuse/version"); err == nil {
+		return v, nil
+	}
+	out, err := exec.Command("modinfo", "-F", "version", "fuse").Output()
+	if err != nil {
+		return "", fmt.Errorf("fuse module version not available: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gcsfuseVersion returns the first line of `gcsfuse --version`.
+func gcsfuseVersion(ctx context.Context, binary string) (string, error) {
+	out, err := exec.CommandContext(ctx, binary, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %w", binary, err)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return line, nil
+}
+
+// gcsfuseMounts parses /proc/mounts for fuse.gcsfuse entries and attaches the
+// command line of the gcsfuse process serving each of them.
+func gcsfuseMounts(mountPoint string) ([]Mount, error) {
+	b, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	if mountPoint != "" {
+		if mountPoint, err = filepath.Abs(mountPoint); err != nil {
+			return nil, err
+		}
+	}
+	procs := gcsfuseProcesses()
+
+	var out []Mount
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "fuse.gcsfuse" {
+			continue
+		}
+		m := Mount{
+			Bucket:     fields[0],
+			MountPoint: fields[1],
+			Options:    strings.Split(fields[3], ","),
+		}
+		if mountPoint != "" && mountPoint != m.MountPoint {
+			continue
+		}
+		for _, p := range procs {
+			if filepath.Clean(p.args[len(p.args)-1]) == m.MountPoint {
+				m.Args = p.args
+				break
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// gcsfuseProcess is a running gcsfuse process.
+type gcsfuseProcess struct {
+	pid  int
+	args []string
+}
+
+// gcsfuseProcesses returns the running gcsfuse processes.
+func gcsfuseProcesses() []gcsfuseProcess {
+	dirs, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	var out []gcsfuseProcess
+	for _, d := range dirs {
+		b, err := os.ReadFile(d)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		args := strings.Split(string(bytes.TrimRight(b, "\x00")), "\x00")
+		if filepath.Base(args[0]) == "gcsfuse" {
+			pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(d)))
+			out = append(out, gcsfuseProcess{pid: pid, args: args})
+		}
+	}
+	return out
+}
+
+// GCSFusePID returns the pid of the gcsfuse process serving mountPoint, whose
+// last argument is the mount point, or 0.
+func GCSFusePID(mountPoint string) int {
+	for _, p := range gcsfuseProcesses() {
+		if filepath.Clean(p.args[len(p.args)-1]) == filepath.Clean(mountPoint) {
+			return p.pid
+		}
+	}
+	return 0
+}
+
+// WriteText prints the fingerprint as aligned key/value lines.
+func (fp *Fingerprint) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", k, v)
+		}
+	}
+	row("Hostname", fp.Hostname)
+	row("Machine type", fp.MachineType)
+	row("Zone", fp.Zone)
+	row("OS", fp.OS)
+	row("Kernel", fp.Kernel)
+	row("Arch", fp.Arch)
+	row("CPUs", strconv.Itoa(fp.NumCPU))
+	if fp.MemTotalBytes > 0 {
+		row("Memory", units.FormatSize(fp.MemTotalBytes/units.MiB*units.MiB))
+	}
+	for _, n := range fp.NICs {
+		speed := "unknown speed"
+		if n.SpeedMbps > 0 {
+			speed = fmt.Sprintf("%d Mb/s", n.SpeedMbps)
+		}
+		row("NIC "+n.Name, speed)
+	}
+	row("gcsfuse", fp.GcsfuseVersion)
+	row("FUSE module", fp.FuseModuleVersion)
+	row("Image manifest", fp.ImageManifest)
+	for _, m := range fp.Mounts {
+		row("Mount "+m.MountPoint, fmt.Sprintf("%s (%s)", m.Bucket, strings.Join(m.Options, ",")))
+		if len(m.Args) > 0 {
+			row("  args", strings.Join(m.Args, " "))
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(fp.Errors)) {
+		row("Error "+k, fp.Errors[k])
+	}
+	return tw.Flush()
+}
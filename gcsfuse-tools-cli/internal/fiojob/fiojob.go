@@ -0,0 +1,271 @@
+// Package fiojob renders the standard release-benchmark fio jobfiles from
+// embedded templates, so sizes, thread counts and runtimes are parameters
+// instead of hand edits of copied jobfiles.
+package fiojob
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"gcsfuse-tools-cli/internal/units"
+	"gcsfuse-tools-cli/pkg/workload"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+// Template describes one embedded jobfile and its defaults.
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// JobPrefix starts the name of every job section.
+	JobPrefix string `json:"-"`
+	Defaults  Params `json:"defaults"`
+}
+
+// releaseSizes are the file sizes of the release read benchmarks.
+var releaseSizes = []string{"128K", "256K", "1M", "5M", "10M", "50M", "100M", "200M", "1G"}
+
+// Templates lists the embedded jobfiles. The read and write defaults match
+// perf-benchmarking-for-releases/fio-job-files.
+var Templates = []Template{
+	{
+		Name:        "seq-read",
+		Description: "Sequential reads, one stonewalled job per file size.",
+		JobPrefix:   "read",
+		Defaults:    Params{Sizes: releaseSizes, Threads: 128, IODepth: 64},
+	},
+	{
+		Name:        "rand-read",
+		Description: "Random reads, one stonewalled job per file size.",
+		JobPrefix:   "randread",
+		Defaults:    Params{Sizes: releaseSizes, Threads: 128, IODepth: 64},
+	},
+	{
+		Name:        "write",
+		Description: "Sequential writes of new files, one stonewalled job per file size.",
+		JobPrefix:   "write",
+		Defaults:    Params{Sizes: []string{"256K", "1M", "50M", "100M", "1G"}, Threads: 112, IODepth: 64},
+	},
+	{
+		Name:        "mixed",
+		Description: "Random reads and writes on the same files, 70% reads by default.",
+		JobPrefix:   "mixed",
+		Defaults:    Params{Sizes: []string{"1M", "100M"}, Threads: 64, IODepth: 64, ReadMix: 70},
+	},
+	{
+		Name:        "small-files",
+		Description: "Many small files each read whole, dominated by open and metadata latency.",
+		JobPrefix:   "smallfiles",
+		Defaults:    Params{Sizes: []string{"4K", "64K"}, Threads: 32, IODepth: 1, NrFiles: 1000},
+	},
+}
+
+// Lookup returns the template called name.
+func Lookup(name string) (Template, error) {
+	i := slices.IndexFunc(Templates, func(t Template) bool { return t.Name == name })
+	if i < 0 {
+		names := make([]string, len(Templates))
+		for j, t := range Templates {
+			names[j] = t.Name
+		}
+		return Template{}, fmt.Errorf("unknown template %q (want one of %s)", name, strings.Join(names, ", "))
+	}
+	return Templates[i], nil
+}
+
+// Params are the knobs of a rendered jobfile. Zero values take the
+// template's defaults.
+type Params struct {
+	// Sizes are the file sizes, one job section each.
+	Sizes []string `json:"sizes"`
+	// BlockSize applies to every job. If empty, it is 128K for files up to
+	// 256K and 1M otherwise, or the file size itself for small-files.
+	BlockSize string `json:"block_size,omitempty"`
+	// NrFiles is the number of files per job thread. If 0, it shrinks with
+	// the file size as in the release jobfiles.
+	NrFiles int `json:"nrfiles,omitempty"`
+	// Threads is fio's numjobs.
+	Threads int `json:"threads"`
+	IODepth int `json:"iodepth"`
+	// Runtime makes every job time based when non-zero.
+	Runtime time.Duration `json:"runtime,omitempty"`
+	// ReadMix is the read percentage of the mixed template.
+	ReadMix int `json:"read_mix,omitempty"`
+	// Directory is written to every job when set, e.g. "${DIR}" for the
+	// release scripts. bench fio passes the directory on the command line.
+	Directory string `json:"directory,omitempty"`
+}
+
+// withDefaults fills the zero fields of p from d.
+func (p Params) withDefaults(d Params) Params {
+	if len(p.Sizes) == 0 {
+		p.Sizes = d.Sizes
+	}
+	if p.NrFiles == 0 {
+		p.NrFiles = d.NrFiles
+	}
+	if p.Threads == 0 {
+		p.Threads = d.Threads
+	}
+	if p.IODepth == 0 {
+		p.IODepth = d.IODepth
+	}
+	if p.ReadMix == 0 {
+		p.ReadMix = d.ReadMix
+	}
+	return p
+}
+
+// job is one rendered job section.
+type job struct {
+	Name, Size, BlockSize string
+	NrFiles               int
+}
+
+// Render writes the jobfile of template name with p to w.
+func Render(w io.Writer, name string, p Params) error {
+	t, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	p = p.withDefaults(t.Defaults)
+	if p.Threads < 0 || p.IODepth < 0 || p.NrFiles < 0 || p.Runtime < 0 {
+		return errors.New("threads, iodepth, nrfiles and runtime must not be negative")
+	}
+	if p.ReadMix < 0 || p.ReadMix > 100 {
+		return fmt.Errorf("read mix must be between 0 and 100, got %d", p.ReadMix)
+	}
+	if p.BlockSize != "" {
+		if _, err := units.ParseSize(p.BlockSize); err != nil {
+			return err
+		}
+	}
+
+	data := struct {
+		Params
+		Runtime string
+		Jobs    []job
+	}{Params: p}
+	if p.Runtime > 0 {
+		data.Runtime = fmt.Sprintf("%ds", int(p.Runtime.Seconds()))
+	}
+	for _, s := range p.Sizes {
+		size, err := units.ParseSize(s)
+		if err != nil {
+			return err
+		}
+		if size <= 0 {
+			return fmt.Errorf("file size %q must be greater than 0", s)
+		}
+		j := job{Size: units.FormatSize(size), BlockSize: p.BlockSize, NrFiles: p.NrFiles}
+		if j.BlockSize == "" {
+			j.BlockSize = defaultBlockSize(t.Name, size)
+		}
+		if j.NrFiles == 0 {
+			j.NrFiles = defaultNrFiles(size)
+		}
+		j.Name = fmt.Sprintf("%s_filesize_%s_blocksize_%s", t.JobPrefix, j.Size, j.BlockSize)
+		data.Jobs = append(data.Jobs, j)
+	}
+
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, t.Name+".fio.tmpl", data); err != nil {
+		return err
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+func defaultBlockSize(template string, size int64) string {
+	switch {
+	case template == "small-files":
+		return units.FormatSize(min(size, units.MiB))
+	case size <= 256*units.KiB:
+		return "128K"
+	default:
+		return "1M"
+	}
+}
+
+// defaultNrFiles keeps the data set of a job roughly constant across sizes,
+// as the release jobfiles do.
+func defaultNrFiles(size int64) int {
+	switch {
+	case size <= units.MiB:
+		return 30
+	case size <= 50*units.MiB:
+		return 20
+	case size <= 200*units.MiB:
+		return 10
+	default:
+		return 2
+	}
+}
+
+// Catalog is the list of embedded templates.
+type Catalog []Template
+
+// WriteText prints one row per template with its default parameters.
+func (c Catalog) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEMPLATE\tSIZES\tTHREADS\tIODEPTH\tDESCRIPTION")
+	for _, t := range c {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", t.Name, strings.Join(t.Defaults.Sizes, ","), t.Defaults.Threads,
+			t.Defaults.IODepth, t.Description)
+	}
+	return tw.Flush()
+}
+
+// specOp is one rendered job section of a workload spec.
+type specOp struct {
+	Name, Type, BlockSize, FilenameFormat string
+	Threads                               int
+}
+
+// RenderSpec writes the jobfile of the validated workload spec s to w: one
+// job per op, all running at once, with the op's share of the workers as
+// numjobs, naming its files as the dataset's names do. The spec's seed is left to the caller, as with bench fio --seed.
+func RenderSpec(w io.Writer, s *workload.Spec) error {
+	data := struct {
+		Name, Hash, FileSize, Runtime string
+		IODepth, NrFiles              int
+		Ops                           []specOp
+	}{
+		Name:     s.Name,
+		Hash:     s.Hash(),
+		FileSize: s.Dataset.FileSize,
+		IODepth:  s.Concurrency.IODepth,
+		NrFiles:  s.Dataset.FilesPerWorker,
+	}
+	if d := s.Runtime(); d > 0 {
+		data.Runtime = fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	workers := s.OpWorkers()
+	for i, op := range s.Ops {
+		data.Ops = append(data.Ops, specOp{
+			Name:           fmt.Sprintf("%s_%s", s.Name, op.Type),
+			Type:           op.Type,
+			BlockSize:      op.BlockSize,
+			Threads:        workers[i],
+			FilenameFormat: s.Dataset.ExpandNames(op.Type, "$jobnum", "$filenum"),
+		})
+	}
+
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, "spec.fio.tmpl", data); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,362 @@
+// Package fusetop samples a running gcsfuse mount, from its Prometheus
+// metrics endpoint or its trace log, and turns consecutive samples into
+// per-interval operation rates, latencies, cache hit ratios and hot files.
+package fusetop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Config selects the sources and the refresh of the view.
+type Config struct {
+	// MetricsURL is the gcsfuse Prometheus endpoint, e.g.
+	// http://localhost:9190/metrics for --prometheus-port=9190.
+	MetricsURL string
+	// LogFile is the gcsfuse log written with --log-severity=trace. It is
+	// the only source of hot files.
+	LogFile  string
+	Interval time.Duration
+	// Iterations stops after that many frames; 0 runs until ctx is done.
+	Iterations int
+	// Top is the number of hot files shown.
+	Top int
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	if c.MetricsURL == "" && c.LogFile == "" {
+		return errors.New("--metrics-url or --log-file is required")
+	}
+	if c.Interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	return nil
+}
+
+// stat accumulates the count and latency histogram of one operation.
+type stat struct {
+	count, errors float64
+	// observed, sumMs and buckets describe the latencies seen; buckets[i]
+	// counts latencies up to bounds[i] ms, cumulatively.
+	observed, sumMs float64
+	bounds, buckets []float64
+}
+
+// logBounds are the latency histogram bounds, in ms, of log sources.
+var logBounds = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, math.Inf(1)}
+
+func (s *stat) observe(ms float64) {
+	if s.bounds == nil {
+		s.bounds, s.buckets = logBounds, make([]float64, len(logBounds))
+	}
+	s.observed++
+	s.sumMs += ms
+	for i, b := range s.bounds {
+		if ms <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (s *stat) clone() *stat {
+	c := *s
+	c.buckets = append([]float64(nil), s.buckets...)
+	return &c
+}
+
+// fileStat accumulates the reads of one file.
+type fileStat struct {
+	reads, bytes float64
+}
+
+// snapshot holds cumulative counters of a mount at one time.
+type snapshot struct {
+	time time.Time
+	ops  map[string]*stat
+	gcs  map[string]*stat
+	// cacheHits and cacheReads count file cache reads, hit or not.
+	cacheHits, cacheReads float64
+	cacheBytes, gcsBytes  float64
+	// files is keyed by layer and file name.
+	files map[[2]string]*fileStat
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{ops: map[string]*stat{}, gcs: map[string]*stat{}, files: map[[2]string]*fileStat{}}
+}
+
+func (s *snapshot) clone() *snapshot {
+	c := *s
+	c.ops, c.gcs, c.files = map[string]*stat{}, map[string]*stat{}, map[[2]string]*fileStat{}
+	for k, v := range s.ops {
+		c.ops[k] = v.clone()
+	}
+	for k, v := range s.gcs {
+		c.gcs[k] = v.clone()
+	}
+	for k, v := range s.files {
+		f := *v
+		c.files[k] = &f
+	}
+	return &c
+}
+
+func statOf(m map[string]*stat, name string) *stat {
+	s, ok := m[name]
+	if !ok {
+		s = &stat{}
+		m[name] = s
+	}
+	return s
+}
+
+// OpRate is the rate and latency of one operation during a frame.
+type OpRate struct {
+	Name      string  `json:"name"`
+	PerSec    float64 `json:"per_sec"`
+	ErrPerSec float64 `json:"errors_per_sec"`
+	// Latencies are zero when the source reported none in the frame.
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
+// FileRate is the read rate of one file during a frame.
+type FileRate struct {
+	File  string `json:"file"`
+	Layer string `json:"layer"`
+	// ReadsPerSec and MiBps count the reads of the layer: FUSE reads name
+	// the inode, GCS reads the object.
+	ReadsPerSec float64 `json:"reads_per_sec"`
+	MiBps       float64 `json:"mib_per_sec"`
+}
+
+// Frame is the activity of the mount between two samples.
+type Frame struct {
+	Time     time.Time  `json:"time"`
+	Seconds  float64    `json:"seconds"`
+	Source   string     `json:"source"`
+	Ops      []OpRate   `json:"fuse_ops"`
+	GCS      []OpRate   `json:"gcs_requests"`
+	HotFiles []FileRate `json:"hot_files,omitempty"`
+	// CacheHitRatio is nil when no file cache reads were reported.
+	CacheHitRatio *float64 `json:"cache_hit_ratio,omitempty"`
+	CacheMiBps    float64  `json:"cache_read_mib_per_sec"`
+	GCSMiBps      float64  `json:"gcs_read_mib_per_sec"`
+}
+
+// frame computes the activity between prev and cur.
+func frame(prev, cur *snapshot, top int) *Frame {
+	secs := cur.time.Sub(prev.time).Seconds()
+	f := &Frame{
+		Time: cur.time, Seconds: secs,
+		Ops: rates(prev.ops, cur.ops, secs), GCS: rates(prev.gcs, cur.gcs, secs),
+		CacheMiBps: (cur.cacheBytes - prev.cacheBytes) / secs / (1 << 20),
+		GCSMiBps:   (cur.gcsBytes - prev.gcsBytes) / secs / (1 << 20),
+	}
+	if reads := cur.cacheReads - prev.cacheReads; reads > 0 {
+		r := (cur.cacheHits - prev.cacheHits) / reads
+		f.CacheHitRatio = &r
+	}
+	for k, c := range cur.files {
+		p := prev.files[k]
+		if p == nil {
+			p = &fileStat{}
+		}
+		if c.reads == p.reads {
+			continue
+		}
+		f.HotFiles = append(f.HotFiles, FileRate{
+			File: k[1], Layer: k[0],
+			ReadsPerSec: (c.reads - p.reads) / secs,
+			MiBps:       (c.bytes - p.bytes) / secs / (1 << 20),
+		})
+	}
+	sort.Slice(f.HotFiles, func(i, j int) bool {
+		if f.HotFiles[i].MiBps != f.HotFiles[j].MiBps {
+			return f.HotFiles[i].MiBps > f.HotFiles[j].MiBps
+		}
+		return f.HotFiles[i].File < f.HotFiles[j].File
+	})
+	if len(f.HotFiles) > top {
+		f.HotFiles = f.HotFiles[:top]
+	}
+	return f
+}
+
+func rates(prev, cur map[string]*stat, secs float64) []OpRate {
+	var out []OpRate
+	for name, c := range cur {
+		p := prev[name]
+		if p == nil {
+			p = &stat{}
+		}
+		n := c.count - p.count
+		if n <= 0 {
+			continue
+		}
+		r := OpRate{Name: name, PerSec: n / secs, ErrPerSec: (c.errors - p.errors) / secs}
+		if obs := c.observed - p.observed; obs > 0 {
+			r.MeanMs = (c.sumMs - p.sumMs) / obs
+			r.P50Ms = quantile(0.5, c, p)
+			r.P99Ms = quantile(0.99, c, p)
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].PerSec != out[j].PerSec {
+			return out[i].PerSec > out[j].PerSec
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// quantile estimates the q-quantile of the latencies observed between p and
+// c by linear interpolation within the histogram bucket, like Prometheus'
+// histogram_quantile.
+func quantile(q float64, c, p *stat) float64 {
+	if len(c.buckets) == 0 {
+		return 0
+	}
+	delta := func(i int) float64 {
+		if i < len(p.buckets) {
+			return c.buckets[i] - p.buckets[i]
+		}
+		return c.buckets[i]
+	}
+	total := delta(len(c.buckets) - 1)
+	if total <= 0 {
+		return 0
+	}
+	target := q * total
+	lower, below := 0.0, 0.0
+	for i, upper := range c.bounds {
+		n := delta(i)
+		if n >= target {
+			if math.IsInf(upper, 1) {
+				return lower
+			}
+			return lower + (upper-lower)*(target-below)/(n-below)
+		}
+		lower, below = upper, n
+	}
+	return lower
+}
+
+// source produces cumulative snapshots of a mount.
+type source interface {
+	snapshot(ctx context.Context) (*snapshot, error)
+}
+
+// Run samples the mount every cfg.Interval and calls show with each frame.
+func Run(ctx context.Context, cfg Config, show func(*Frame) error) error {
+	var metrics, logs source
+	var name string
+	if cfg.MetricsURL != "" {
+		metrics = &metricsSource{url: cfg.MetricsURL}
+		name = cfg.MetricsURL
+	}
+	if cfg.LogFile != "" {
+		ls, err := newLogSource(cfg.LogFile)
+		if err != nil {
+			return err
+		}
+		logs = ls
+		if name != "" {
+			name += " + "
+		}
+		name += cfg.LogFile
+	}
+	sample := func() (*snapshot, error) {
+		var s, l *snapshot
+		var err error
+		if logs != nil {
+			if l, err = logs.snapshot(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if metrics == nil {
+			return l, nil
+		}
+		if s, err = metrics.snapshot(ctx); err != nil {
+			return nil, err
+		}
+		// The metrics carry no file names, so hot files come from the log.
+		if l != nil {
+			s.files = l.files
+		}
+		return s, nil
+	}
+
+	prev, err := sample()
+	if err != nil {
+		return err
+	}
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+	for n := 0; cfg.Iterations == 0 || n < cfg.Iterations; n++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		cur, err := sample()
+		if err != nil {
+			return err
+		}
+		f := frame(prev, cur, cfg.Top)
+		f.Source = name
+		if err := show(f); err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
+
+// WriteText prints the frame as tables of FUSE ops, GCS requests and hot
+// files.
+func (f *Frame) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "fuse-top  %s  %s  (%.1fs)\n", f.Source, f.Time.Format("15:04:05"), f.Seconds)
+	hit := "n/a"
+	if f.CacheHitRatio != nil {
+		hit = fmt.Sprintf("%.1f%%", *f.CacheHitRatio*100)
+	}
+	fmt.Fprintf(w, "File cache hit ratio: %s   cache reads: %.1f MiB/s   GCS reads: %.1f MiB/s\n\n", hit, f.CacheMiBps, f.GCSMiBps)
+
+	for _, t := range []struct {
+		title string
+		ops   []OpRate
+	}{{"FUSE OP", f.Ops}, {"GCS REQUEST", f.GCS}} {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tOPS/S\tERR/S\tMEAN MS\tP50 MS\tP99 MS\n", t.title)
+		for _, o := range t.ops {
+			fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.2f\t%.2f\t%.2f\n", o.Name, o.PerSec, o.ErrPerSec, o.MeanMs, o.P50Ms, o.P99Ms)
+		}
+		if len(t.ops) == 0 {
+			fmt.Fprintln(tw, "(idle)\t\t\t\t\t")
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOT FILE\tLAYER\tREADS/S\tMIB/S")
+	for _, h := range f.HotFiles {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.1f\n", h.File, h.Layer, h.ReadsPerSec, h.MiBps)
+	}
+	if len(f.HotFiles) == 0 {
+		fmt.Fprintln(tw, "(none)\t\t\t")
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,113 @@
+package fusetop
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// maxPending bounds the FUSE ops awaiting a response, as some ops such as
+// ForgetInode are never answered.
+const maxPending = 100000
+
+// logSource follows a gcsfuse trace log from its end at start.
+type logSource struct {
+	path    string
+	offset  int64
+	partial []byte
+	pending map[string]gcsfuselog.Op
+	cum     *snapshot
+}
+
+func newLogSource(path string) (*logSource, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logSource{path: path, offset: fi.Size(), pending: map[string]gcsfuselog.Op{}, cum: newSnapshot()}, nil
+}
+
+func (l *logSource) snapshot(ctx context.Context) (*snapshot, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() < l.offset {
+		// Rotated or truncated.
+		l.offset, l.partial = 0, nil
+	}
+	if _, err := f.Seek(l.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	l.offset += int64(len(b))
+	b = append(l.partial, b...)
+	// Keep an unterminated last line for the next sample.
+	end := bytes.LastIndexByte(b, '\n') + 1
+	l.partial = append([]byte(nil), b[end:]...)
+	if err := gcsfuselog.Scan(bytes.NewReader(b[:end]), l.add); err != nil {
+		return nil, err
+	}
+	s := l.cum.clone()
+	s.time = time.Now()
+	return s, nil
+}
+
+func (l *logSource) add(e gcsfuselog.Entry) {
+	s := l.cum
+	if op, ok := gcsfuselog.ParseOp(e); ok {
+		l.op(op)
+	}
+	if r, ok := gcsfuselog.ParseRead(e); ok {
+		f := s.files[[2]string{r.Layer, r.File}]
+		if f == nil {
+			f = &fileStat{}
+			s.files[[2]string{r.Layer, r.File}] = f
+		}
+		f.reads++
+		f.bytes += float64(r.Length)
+		if r.Layer == gcsfuselog.LayerGCS {
+			s.gcsBytes += float64(r.Length)
+		}
+	}
+	if method, ok := gcsfuselog.ParseGCSCall(e); ok {
+		statOf(s.gcs, method).count++
+	}
+	if r, ok := gcsfuselog.ParseGCSResponse(e); ok {
+		st := statOf(s.gcs, r.Method)
+		if !r.OK {
+			st.errors++
+		}
+		st.observe(float64(r.Latency) / float64(time.Millisecond))
+	}
+}
+
+// op pairs FUSE op requests with their responses.
+func (l *logSource) op(op gcsfuselog.Op) {
+	if op.Status == gcsfuselog.OpRequest {
+		if len(l.pending) >= maxPending {
+			clear(l.pending)
+		}
+		l.pending[op.ID] = op
+		return
+	}
+	req, ok := l.pending[op.ID]
+	if !ok {
+		return
+	}
+	delete(l.pending, op.ID)
+	st := statOf(l.cum.ops, req.Name)
+	st.count++
+	if op.Status != "OK" {
+		st.errors++
+	}
+	st.observe(float64(op.Time.Sub(req.Time)) / float64(time.Millisecond))
+}
@@ -0,0 +1,205 @@
+package fusetop
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sample is one line of the Prometheus text exposition format.
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseMetrics reads the Prometheus text exposition format.
+func parseMetrics(r io.Reader) ([]sample, error) {
+	var out []sample
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 1<<20), 16<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		smp, err := parseSample(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, smp)
+	}
+	return out, s.Err()
+}
+
+func parseSample(line string) (sample, error) {
+	smp := sample{labels: map[string]string{}}
+	i := strings.IndexAny(line, "{ ")
+	if i < 0 {
+		return smp, fmt.Errorf("invalid metric line %q", line)
+	}
+	smp.name = line[:i]
+	rest := line[i:]
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, ", ")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			k, v, ok := strings.Cut(rest, "=")
+			if !ok || !strings.HasPrefix(v, `"`) {
+				return smp, fmt.Errorf("invalid labels in metric line %q", line)
+			}
+			end := 1
+			for end < len(v) && v[end] != '"' {
+				if v[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(v) {
+				return smp, fmt.Errorf("unterminated label value in metric line %q", line)
+			}
+			val, err := strconv.Unquote(v[:end+1])
+			if err != nil {
+				val = v[1:end]
+			}
+			smp.labels[strings.TrimSpace(k)] = val
+			rest = v[end+1:]
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return smp, fmt.Errorf("missing value in metric line %q", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return smp, fmt.Errorf("invalid value in metric line %q: %w", line, err)
+	}
+	smp.value = v
+	return smp, nil
+}
+
+// metricsSource scrapes the gcsfuse Prometheus endpoint.
+type metricsSource struct {
+	url string
+}
+
+func (m *metricsSource) snapshot(ctx context.Context) (*snapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scraping %s: %w", m.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scraping %s: %s", m.url, resp.Status)
+	}
+	samples, err := parseMetrics(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("scraping %s: %w", m.url, err)
+	}
+	s := fromMetrics(samples)
+	s.time = time.Now()
+	return s, nil
+}
+
+// fromMetrics builds a snapshot from the gcsfuse metrics. Names are matched
+// with and without the _total suffix and unit suffixes newer exporters add.
+func fromMetrics(samples []sample) *snapshot {
+	s := newSnapshot()
+	type histKey struct {
+		gcs  bool
+		name string
+	}
+	buckets := map[histKey]map[float64]float64{}
+	bucket := func(gcs bool, name, le string, v float64) {
+		bound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			return
+		}
+		k := histKey{gcs, name}
+		if buckets[k] == nil {
+			buckets[k] = map[float64]float64{}
+		}
+		buckets[k][bound] += v
+	}
+
+	for _, smp := range samples {
+		name := strings.TrimSuffix(smp.name, "_total")
+		op, method := smp.labels["fs_op"], smp.labels["gcs_method"]
+		switch {
+		case strings.HasPrefix(name, "fs_ops_latency"):
+			// Recorded in microseconds.
+			recordHist(statOf(s.ops, op), name, smp, 1e-3, func(le string) { bucket(false, op, le, smp.value) })
+		case strings.HasPrefix(name, "gcs_request_latencies"):
+			// Recorded in milliseconds.
+			recordHist(statOf(s.gcs, method), name, smp, 1, func(le string) { bucket(true, method, le, smp.value) })
+		case strings.HasPrefix(name, "fs_ops_error_count"):
+			statOf(s.ops, op).errors += smp.value
+		case strings.HasPrefix(name, "fs_ops_count"):
+			statOf(s.ops, op).count += smp.value
+		case strings.HasPrefix(name, "gcs_request_count"):
+			statOf(s.gcs, method).count += smp.value
+		case strings.HasPrefix(name, "file_cache_read_count"):
+			s.cacheReads += smp.value
+			if smp.labels["cache_hit"] == "true" {
+				s.cacheHits += smp.value
+			}
+		case strings.HasPrefix(name, "file_cache_read_bytes_count"):
+			s.cacheBytes += smp.value
+		case strings.HasPrefix(name, "gcs_read_bytes_count"):
+			s.gcsBytes += smp.value
+		}
+	}
+
+	for k, bs := range buckets {
+		st := statOf(s.ops, k.name)
+		scale := 1e-3
+		if k.gcs {
+			st, scale = statOf(s.gcs, k.name), 1
+		}
+		bounds := make([]float64, 0, len(bs))
+		for b := range bs {
+			bounds = append(bounds, b)
+		}
+		sort.Float64s(bounds)
+		for _, b := range bounds {
+			st.bounds = append(st.bounds, b*scale)
+			st.buckets = append(st.buckets, bs[b])
+		}
+	}
+	// Some releases export the latency histograms without op counters.
+	for _, m := range []map[string]*stat{s.ops, s.gcs} {
+		for _, st := range m {
+			if st.count == 0 {
+				st.count = st.observed
+			}
+		}
+	}
+	return s
+}
+
+// recordHist adds the _sum or _count sample of a latency histogram to st and
+// hands _bucket samples to bucket. scale converts the unit to ms.
+func recordHist(st *stat, name string, smp sample, scale float64, bucket func(le string)) {
+	switch {
+	case strings.HasSuffix(name, "_bucket"):
+		bucket(smp.labels["le"])
+	case strings.HasSuffix(name, "_sum"):
+		st.sumMs += smp.value * scale
+	case strings.HasSuffix(name, "_count"):
+		st.observed += smp.value
+	}
+}
@@ -0,0 +1,209 @@
+// Package gcsfuselog parses gcsfuse log files written with --log-severity=trace
+// in either the text or the JSON log format.
+package gcsfuselog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is one log record.
+type Entry struct {
+	Time     time.Time
+	Severity string
+	Message  string
+}
+
+// textTimeLayout is the timestamp layout of the text log format.
+const textTimeLayout = "02/01/2006 15:04:05.000000"
+
+var textLine = regexp.MustCompile(`^time="([^"]+)" severity=(\w+) message="(.*)"$`)
+
+// ParseLine parses one line of a text or JSON formatted log. It returns false
+// for lines that are not gcsfuse log records.
+func ParseLine(line string) (Entry, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var rec struct {
+			Timestamp struct {
+				Seconds int64 `json:"seconds"`
+				Nanos   int64 `json:"nanos"`
+			} `json:"timestamp"`
+			Severity string `json:"severity"`
+			Message  string `json:"message"`
+		}
+		if json.Unmarshal([]byte(line), &rec) != nil {
+			return Entry{}, false
+		}
+		return Entry{time.Unix(rec.Timestamp.Seconds, rec.Timestamp.Nanos), rec.Severity, rec.Message}, true
+	}
+
+	m := textLine.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, false
+	}
+	t, err := time.ParseInLocation(textTimeLayout, m[1], time.Local)
+	if err != nil {
+		return Entry{}, false
+	}
+	msg, err := strconv.Unquote(`"` + m[3] + `"`)
+	if err != nil {
+		msg = m[3]
+	}
+	return Entry{t, m[2], msg}, true
+}
+
+// Scan calls fn for every log record read from r.
+func Scan(r io.Reader, fn func(Entry)) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 1<<20), 16<<20)
+	for s.Scan() {
+		if e, ok := ParseLine(s.Text()); ok {
+			fn(e)
+		}
+	}
+	return s.Err()
+}
+
+// Read layers.
+const (
+	// LayerFUSE is a read request from the kernel.
+	LayerFUSE = "fuse"
+	// LayerGCS is a read issued to Cloud Storage.
+	LayerGCS = "gcs"
+)
+
+// Read is a read request found in the log.
+type Read struct {
+	Time  time.Time `json:"time"`
+	Layer string    `json:"layer"`
+	// File is the object name for GCS reads and "inode <n>" for FUSE reads,
+	// whose log lines don't carry the name.
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+var (
+	// fuse_debug: Op 0x00000044 connection.go:420] <- ReadFile (inode 2, PID 8, handle 0, offset 0, 131072 bytes)
+	fuseRead = regexp.MustCompile(`<- ReadFile \(inode (\d+), PID \d+, handle \d+, offset (\d+), (\d+) bytes\)`)
+	// gcs: Req 0x12: <- Read("dir/file", [0, 8388608))
+	gcsRead = regexp.MustCompile(`<- Read\("((?:[^"\\]|\\.)*)", \[(\d+), (\d+)\)\)`)
+)
+
+// ParseRead extracts a read request from e.
+func ParseRead(e Entry) (Read, bool) {
+	if m := fuseRead.FindStringSubmatch(e.Message); m != nil {
+		off, _ := strconv.ParseInt(m[2], 10, 64)
+		n, _ := strconv.ParseInt(m[3], 10, 64)
+		return Read{Time: e.Time, Layer: LayerFUSE, File: "inode " + m[1], Offset: off, Length: n}, true
+	}
+	if m := gcsRead.FindStringSubmatch(e.Message); m != nil {
+		start, _ := strconv.ParseInt(m[2], 10, 64)
+		end, _ := strconv.ParseInt(m[3], 10, 64)
+		name, err := strconv.Unquote(`"` + m[1] + `"`)
+		if err != nil {
+			name = m[1]
+		}
+		return Read{Time: e.Time, Layer: LayerGCS, File: name, Offset: start, Length: end - start}, true
+	}
+	return Read{}, false
+}
+
+// gcs: Req 0x12: <- ListObjects("dir/")
+var gcsCall = regexp.MustCompile(`Req\s+0x[0-9a-fA-F]+: <- (\w+)\(`)
+
+// ParseGCSCall returns the storage API method of a GCS request logged by
+// gcsfuse, e.g. "StatObject" or "Read".
+func ParseGCSCall(e Entry) (string, bool) {
+	m := gcsCall.FindStringSubmatch(e.Message)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// OpRequest is the Status of FUSE op requests.
+const OpRequest = "request"
+
+// Op is a FUSE op request or response logged with --log-severity=trace.
+type Op struct {
+	Time time.Time
+	ID   string
+	// Name is the op of a request, e.g. "ReadFile", and empty for responses.
+	Name string
+	// Status is OpRequest for requests and "OK" or "Error" for responses.
+	Status string
+}
+
+var (
+	// fuse_debug: Op 0x00000044        connection.go:420] <- ReadFile (inode 2, ...)
+	// fuse_debug: Op 0x00000044        connection.go:513] -> OK ()
+	fuseOp = regexp.MustCompile(`Op (0x[0-9a-fA-F]+)\s+\S+\] (<-|->) (\w+)`)
+	// gcs: Req 0x12: -> StatObject("dir/file") (23.4ms): OK
+	gcsResponse = regexp.MustCompile(`Req\s+0x[0-9a-fA-F]+: -> (\w+)\(.*\) \(([^)]+)\): (.*)$`)
+)
+
+// ParseOp extracts a FUSE op request or response from e. Requests and
+// responses of the same op share its ID.
+func ParseOp(e Entry) (Op, bool) {
+	m := fuseOp.FindStringSubmatch(e.Message)
+	if m == nil {
+		return Op{}, false
+	}
+	if m[2] == "<-" {
+		return Op{Time: e.Time, ID: m[1], Name: m[3], Status: OpRequest}, true
+	}
+	return Op{Time: e.Time, ID: m[1], Status: m[3]}, true
+}
+
+// GCSResponse is the completion of a GCS request.
+type GCSResponse struct {
+	Method  string
+	Latency time.Duration
+	OK      bool
+}
+
+// ParseGCSResponse extracts the completion of a GCS request from e.
+func ParseGCSResponse(e Entry) (GCSResponse, bool) {
+	m := gcsResponse.FindStringSubmatch(e.Message)
+	if m == nil {
+		return GCSResponse{}, false
+	}
+	d, err := time.ParseDuration(m[2])
+	if err != nil {
+		return GCSResponse{}, false
+	}
+	return GCSResponse{Method: m[1], Latency: d, OK: m[3] == "OK"}, true
+}
+
+// FileCacheRead is a read served through the file cache, logged with
+// --log-severity=trace when the file cache is enabled.
+type FileCacheRead struct {
+	Time   time.Time
+	Bucket string
+	Object string
+	Offset int64
+	Size   int64
+	// Hit reports whether the cache served the read without downloading.
+	Hit bool
+}
+
+// FileCache(bucket:/dir/file, offset: 0, size: 1048576 handle: 2) -> OK (isSeq: true, hit: false) (12.5ms)
+var fileCacheRead = regexp.MustCompile(`FileCache\(([^:]+):/(.*), offset: (\d+), size: (\d+) handle: \d+\) -> OK \(isSeq: \w+, hit: (true|false)\)`)
+
+// ParseFileCacheRead extracts a file cache read from e.
+func ParseFileCacheRead(e Entry) (FileCacheRead, bool) {
+	m := fileCacheRead.FindStringSubmatch(e.Message)
+	if m == nil {
+		return FileCacheRead{}, false
+	}
+	off, _ := strconv.ParseInt(m[3], 10, 64)
+	n, _ := strconv.ParseInt(m[4], 10, 64)
+	return FileCacheRead{Time: e.Time, Bucket: m[1], Object: m[2], Offset: off, Size: n, Hit: m[5] == "true"}, true
+}
@@ -0,0 +1,490 @@
+// Package gkebench runs an fio jobfile in a pod on GKE against a bucket mounted
+// by the Cloud Storage FUSE CSI driver, pinned to a chosen node pool or machine
+// family, and records where the pod actually ran with the results.
+package gkebench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/envinfo"
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+// GKE node labels used for placement.
+const (
+	LabelNodePool      = "cloud.google.com/gke-nodepool"
+	LabelMachineFamily = "cloud.google.com/machine-family"
+	LabelMachineType   = "node.kubernetes.io/instance-type"
+	LabelLocalSSD      = "cloud.google.com/gke-ephemeral-storage-local-ssd"
+	LabelZone          = "topology.kubernetes.io/zone"
+)
+
+// csiDriver is the name of the Cloud Storage FUSE CSI driver.
+const csiDriver = "gcsfuse.csi.storage.gke.io"
+
+// Markers delimiting the fio report in the pod logs.
+const (
+	reportBegin = "==== gke-bench fio report begin ===="
+	reportEnd   = "==== gke-bench fio report end ===="
+)
+
+// Config describes one benchmark pod.
+type Config struct {
+	Kubectl     string
+	KubeContext string
+	Namespace   string
+	// ServiceAccount is the Kubernetes service account of the pod, bound to
+	// an IAM identity that can read Bucket with Workload Identity.
+	ServiceAccount string
+	Bucket         string
+	JobFile        string
+	// Image runs fio. fio is installed with apt-get when the image lacks it.
+	Image        string
+	MountOptions []string
+	// FileCacheCapacity, when set, enables the gcsfuse file cache of the
+	// volume, e.g. "100Gi". With LocalSSD the cache lives on local SSD.
+	FileCacheCapacity string
+
+	// Placement constraints. Empty values are not constrained.
+	NodePool      string
+	MachineFamily string
+	MachineType   string
+	LocalSSD      bool
+	NodeSelector  map[string]string
+	// Tolerations are "key[=value]:effect" entries, for tainted node pools.
+	Tolerations []string
+
+	Timeout time.Duration
+	// Keep leaves the pod and its ConfigMap in place after the run.
+	Keep bool
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if c.JobFile == "" {
+		return errors.New("--jobfile is required")
+	}
+	for _, t := range c.Tolerations {
+		if _, _, ok := strings.Cut(t, ":"); !ok {
+			return fmt.Errorf("invalid toleration %q, want key[=value]:effect", t)
+		}
+	}
+	return nil
+}
+
+// selector returns the node labels the pod requires.
+func (c *Config) selector() map[string]string {
+	sel := map[string]string{}
+	for k, v := range c.NodeSelector {
+		sel[k] = v
+	}
+	if c.NodePool != "" {
+		sel[LabelNodePool] = c.NodePool
+	}
+	if c.MachineFamily != "" {
+		sel[LabelMachineFamily] = c.MachineFamily
+	}
+	if c.MachineType != "" {
+		sel[LabelMachineType] = c.MachineType
+	}
+	if c.LocalSSD {
+		sel[LabelLocalSSD] = "true"
+	}
+	return sel
+}
+
+// Placement is the node a benchmark pod ran on.
+type Placement struct {
+	Node          string `json:"node"`
+	NodePool      string `json:"node_pool,omitempty"`
+	MachineFamily string `json:"machine_family,omitempty"`
+	MachineType   string `json:"machine_type,omitempty"`
+	Zone          string `json:"zone,omitempty"`
+	LocalSSD      bool   `json:"local_ssd"`
+	// Requested is the node selector the pod was scheduled with and Verified
+	// whether the node's labels satisfy all of it.
+	Requested map[string]string `json:"requested,omitempty"`
+	Verified  bool              `json:"verified"`
+	Mismatch  []string          `json:"mismatch,omitempty"`
+}
+
+// Result is the outcome of a GKE benchmark run.
+type Result struct {
+	Pod          string            `json:"pod"`
+	Namespace    string            `json:"namespace"`
+	Bucket       string            `json:"bucket"`
+	JobFile      string            `json:"jobfile"`
+	Image        string            `json:"image"`
+	MountOptions []string          `json:"mount_options,omitempty"`
+	Placement    Placement         `json:"placement"`
+	FioVersion   string            `json:"fio_version"`
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Jobs         []bench.JobResult `json:"jobs"`
+	// Env describes the node, not the host gke-bench ran on.
+	Env *envinfo.Fingerprint `json:"env"`
+}
+
+// Run creates the benchmark pod, verifies its placement, waits for fio to
+// finish and collects the report from the pod logs.
+func Run(ctx context.Context, cfg Config) (res *Result, err error) {
+	jobfile, err := os.ReadFile(cfg.JobFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	name := "gke-bench-" + registry.NewRunID()
+	k := kube{cfg: cfg}
+	manifest, err := json.Marshal(buildManifest(cfg, name, string(jobfile)))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Creating benchmark pod", "pod", name, "namespace", cfg.Namespace, "selector", cfg.selector())
+	if _, err := k.run(ctx, manifest, "apply", "-f", "-"); err != nil {
+		return nil, err
+	}
+	if !cfg.Keep {
+		defer func() {
+			// The run context may have expired; cleanup gets its own.
+			cctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if _, derr := k.run(cctx, nil, "delete", "pod/"+name, "configmap/"+name, "--ignore-not-found", "--wait=false"); derr != nil {
+				err = errors.Join(err, derr)
+			}
+		}()
+	}
+
+	res = &Result{
+		Pod: name, Namespace: cfg.Namespace, Bucket: cfg.Bucket, JobFile: cfg.JobFile,
+		Image: cfg.Image, MountOptions: cfg.MountOptions, StartTime: time.Now(),
+	}
+
+	p, err := k.waitScheduled(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	n, err := k.node(ctx, p.Spec.NodeName)
+	if err != nil {
+		return nil, err
+	}
+	res.Placement = placement(n, cfg.selector())
+	res.Env = fingerprint(n)
+	slog.Info("Pod scheduled", "node", res.Placement.Node, "pool", res.Placement.NodePool,
+		"machine_type", res.Placement.MachineType, "verified", res.Placement.Verified)
+	if !res.Placement.Verified {
+		return nil, fmt.Errorf("pod %s landed on node %s that does not match the requested placement: %s",
+			name, res.Placement.Node, strings.Join(res.Placement.Mismatch, "; "))
+	}
+
+	phase, err := k.waitDone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := k.run(ctx, nil, "logs", name, "-c", "fio")
+	if err != nil {
+		return nil, err
+	}
+	res.EndTime = time.Now()
+	report, ok := between(logs, reportBegin, reportEnd)
+	if phase != "Succeeded" || !ok {
+		return nil, fmt.Errorf("benchmark pod %s ended in phase %s; last log lines:\n%s", name, phase, tail(logs, 20))
+	}
+	if res.FioVersion, res.Jobs, err = bench.ParseReport(report); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// buildManifest returns a List with the ConfigMap holding the jobfile and the
+// benchmark pod.
+func buildManifest(cfg Config, name, jobfile string) map[string]any {
+	script := strings.Join([]string{
+		"set -e",
+		"command -v fio >/dev/null || (apt-get update -qq && apt-get install -y -qq fio >/dev/null)",
+		"fio --output-format=json --output=/tmp/fio.json --directory=/data /jobs/job.fio",
+		"echo '" + reportBegin + "'",
+		"cat /tmp/fio.json",
+		"echo '" + reportEnd + "'",
+	}, "\n")
+
+	attrs := map[string]string{"bucketName": cfg.Bucket}
+	if len(cfg.MountOptions) > 0 {
+		attrs["mountOptions"] = strings.Join(cfg.MountOptions, ",")
+	}
+	if cfg.FileCacheCapacity != "" {
+		attrs["fileCacheCapacity"] = cfg.FileCacheCapacity
+	}
+	volumes := []map[string]any{
+		{"name": "data", "csi": map[string]any{"driver": csiDriver, "volumeAttributes": attrs}},
+		{"name": "jobs", "configMap": map[string]any{"name": name}},
+	}
+	if cfg.FileCacheCapacity != "" {
+		// The sidecar keeps the file cache in this volume, which is backed
+		// by local SSD on nodes with local SSD ephemeral storage.
+		volumes = append(volumes, map[string]any{"name": "gke-gcsfuse-cache", "emptyDir": map[string]any{}})
+	}
+
+	var tolerations []map[string]any
+	for _, t := range cfg.Tolerations {
+		kv, effect, _ := strings.Cut(t, ":")
+		key, value, hasValue := strings.Cut(kv, "=")
+		tol := map[string]any{"key": key, "effect": effect, "operator": "Exists"}
+		if hasValue {
+			tol["operator"], tol["value"] = "Equal", value
+		}
+		tolerations = append(tolerations, tol)
+	}
+
+	spec := map[string]any{
+		"restartPolicy": "Never",
+		"nodeSelector":  cfg.selector(),
+		"tolerations":   tolerations,
+		"volumes":       volumes,
+		"containers": []map[string]any{{
+			"name":    "fio",
+			"image":   cfg.Image,
+			"command": []string{"/bin/sh", "-c", script},
+			"volumeMounts": []map[string]any{
+				{"name": "data", "mountPath": "/data"},
+				{"name": "jobs", "mountPath": "/jobs"},
+			},
+		}},
+	}
+	if cfg.ServiceAccount != "" {
+		spec["serviceAccountName"] = cfg.ServiceAccount
+	}
+	meta := map[string]any{"name": name, "namespace": cfg.Namespace, "labels": map[string]string{"app": "gke-bench"}}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items": []map[string]any{
+			{"apiVersion": "v1", "kind": "ConfigMap", "metadata": meta, "data": map[string]string{"job.fio": jobfile}},
+			{
+				"apiVersion": "v1", "kind": "Pod",
+				"metadata": map[string]any{
+					"name": name, "namespace": cfg.Namespace, "labels": meta["labels"],
+					"annotations": map[string]string{"gke-gcsfuse/volumes": "true"},
+				},
+				"spec": spec,
+			},
+		},
+	}
+}
+
+// The subset of the Kubernetes objects read by gke-bench.
+type (
+	pod struct {
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type    string `json:"type"`
+				Status  string `json:"status"`
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	node struct {
+		Metadata struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Status struct {
+			NodeInfo struct {
+				KernelVersion string `json:"kernelVersion"`
+				OSImage       string `json:"osImage"`
+				Architecture  string `json:"architecture"`
+			} `json:"nodeInfo"`
+			Capacity map[string]string `json:"capacity"`
+		} `json:"status"`
+	}
+)
+
+func placement(n *node, requested map[string]string) Placement {
+	l := n.Metadata.Labels
+	p := Placement{
+		Node:          n.Metadata.Name,
+		NodePool:      l[LabelNodePool],
+		MachineFamily: l[LabelMachineFamily],
+		MachineType:   l[LabelMachineType],
+		Zone:          l[LabelZone],
+		LocalSSD:      l[LabelLocalSSD] == "true",
+		Requested:     requested,
+	}
+	keys := make([]string, 0, len(requested))
+	for k := range requested {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if got := l[k]; got != requested[k] {
+			p.Mismatch = append(p.Mismatch, fmt.Sprintf("%s=%q, want %q", k, got, requested[k]))
+		}
+	}
+	p.Verified = len(p.Mismatch) == 0
+	return p
+}
+
+// fingerprint describes the node in the shape of a local environment
+// fingerprint, so results are compared by the hardware they ran on.
+func fingerprint(n *node) *envinfo.Fingerprint {
+	l := n.Metadata.Labels
+	fp := &envinfo.Fingerprint{
+		CapturedAt:  time.Now().UTC(),
+		Hostname:    n.Metadata.Name,
+		MachineType: l[LabelMachineType],
+		Zone:        l[LabelZone],
+		OS:          n.Status.NodeInfo.OSImage,
+		Kernel:      n.Status.NodeInfo.KernelVersion,
+		Arch:        n.Status.NodeInfo.Architecture,
+	}
+	fmt.Sscan(n.Status.Capacity["cpu"], &fp.NumCPU)
+	return fp
+}
+
+type kube struct {
+	cfg Config
+}
+
+// run runs kubectl with stdin and returns its stdout.
+func (k kube) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	args = append(args, "--namespace", k.cfg.Namespace)
+	if k.cfg.KubeContext != "" {
+		args = append(args, "--context", k.cfg.KubeContext)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, k.cfg.Kubectl, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("kubectl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (k kube) getJSON(ctx context.Context, v any, args ...string) error {
+	out, err := k.run(ctx, nil, append([]string{"get"}, append(args, "-o", "json")...)...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+func (k kube) node(ctx context.Context, name string) (*node, error) {
+	var n node
+	if err := k.getJSON(ctx, &n, "node", name); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// waitScheduled polls the pod until it is bound to a node. An unschedulable
+// pod is reported with the scheduler's reason, which names the constraint no
+// node satisfied.
+func (k kube) waitScheduled(ctx context.Context, name string) (*pod, error) {
+	var lastReason string
+	for {
+		var p pod
+		if err := k.getJSON(ctx, &p, "pod", name); err != nil {
+			return nil, err
+		}
+		if p.Spec.NodeName != "" {
+			return &p, nil
+		}
+		for _, c := range p.Status.Conditions {
+			if c.Type == "PodScheduled" && c.Status == "False" && c.Message != lastReason {
+				lastReason = c.Message
+				slog.Warn("Pod not scheduled yet", "reason", c.Reason, "message", c.Message)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if lastReason != "" {
+				return nil, fmt.Errorf("pod %s was never scheduled: %s", name, lastReason)
+			}
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// waitDone polls the pod until it succeeds or fails and returns its phase.
+func (k kube) waitDone(ctx context.Context, name string) (string, error) {
+	for {
+		var p pod
+		if err := k.getJSON(ctx, &p, "pod", name); err != nil {
+			return "", err
+		}
+		if p.Status.Phase == "Succeeded" || p.Status.Phase == "Failed" {
+			return p.Status.Phase, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for pod %s: %w", name, ctx.Err())
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func between(b []byte, begin, end string) ([]byte, bool) {
+	_, rest, ok := bytes.Cut(b, []byte(begin))
+	if !ok {
+		return nil, false
+	}
+	report, _, ok := bytes.Cut(rest, []byte(end))
+	return report, ok
+}
+
+func tail(b []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	return strings.Join(lines[max(0, len(lines)-n):], "\n")
+}
+
+// WriteText prints the placement and one row per job and direction.
+func (r *Result) WriteText(w io.Writer) error {
+	p := r.Placement
+	fmt.Fprintf(w, "fio %s in pod %s/%s on gs://%s (%s)\n", r.FioVersion, r.Namespace, r.Pod, r.Bucket, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	fmt.Fprintf(w, "Node %s: pool %s, %s (%s family), zone %s, local SSD %t, placement verified %t\n\n",
+		p.Node, p.NodePool, p.MachineType, p.MachineFamily, p.Zone, p.LocalSSD, p.Verified)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tOP\tBW (MiB/s)\tIOPS\tMEAN LAT (ms)\tP99 CLAT (ms)")
+	for _, j := range r.Jobs {
+		for _, op := range []struct {
+			name  string
+			stats *bench.OpStats
+		}{{"read", j.Read}, {"write", j.Write}} {
+			if op.stats == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.0f\t%.2f\t%.2f\n", j.Name, op.name,
+				op.stats.BwKiBps/1024, op.stats.Iops, op.stats.MeanLatNs/1e6, op.stats.P99ClatNs/1e6)
+		}
+	}
+	return tw.Flush()
+}
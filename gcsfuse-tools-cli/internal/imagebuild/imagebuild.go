@@ -0,0 +1,271 @@
+// Package imagebuild builds VM and container images with gcsfuse, fio and
+// gcsfuse-tools preinstalled at the versions a manifest pins, so benchmark
+// environments are identical across runs and teams.
+//
+// One bootstrap script installs the tools in both images: the container
+// build runs it in a Dockerfile step, and the VM build runs it as the
+// startup script of a temporary VM whose disk becomes the image.
+package imagebuild
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Image kinds.
+const (
+	KindContainer = "container"
+	KindVM        = "vm"
+)
+
+// Config describes an image build.
+type Config struct {
+	Manifest *Manifest
+	// Dir receives the build context: the bootstrap script, the Dockerfile,
+	// the resolved manifest and the gcsfuse-tools binary.
+	Dir string
+	// Kinds are the images to build: KindContainer and/or KindVM.
+	Kinds []string
+	// Repository is the container image repository, e.g.
+	// us-docker.pkg.dev/PROJECT/REPO/gcsfuse-bench; the tag is the manifest
+	// hash. Push pushes the image after building it.
+	Repository string
+	Push       bool
+	Docker     string
+	// Project, Zone and MachineType place the temporary VM of the VM build,
+	// and ImageFamily groups the VM images.
+	Project     string
+	Zone        string
+	MachineType string
+	ImageFamily string
+	Gcloud      string
+	// Timeout bounds the bootstrap of the temporary VM.
+	Timeout time.Duration
+	// DryRun only writes the build context.
+	DryRun bool
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	if c.Dir == "" {
+		return errors.New("--dir is required")
+	}
+	if len(c.Kinds) == 0 {
+		return errors.New("--kinds must name at least one image")
+	}
+	for _, k := range c.Kinds {
+		switch k {
+		case KindContainer:
+			if c.Repository == "" && !c.DryRun {
+				return errors.New("--repository is required to build the container image")
+			}
+		case KindVM:
+			if (c.Project == "" || c.Zone == "") && !c.DryRun {
+				return errors.New("--project and --zone are required to build the VM image")
+			}
+		default:
+			return fmt.Errorf("unsupported image kind %q (want %s or %s)", k, KindContainer, KindVM)
+		}
+	}
+	return nil
+}
+
+// Result is the outcome of an image build.
+type Result struct {
+	ManifestHash string    `json:"manifest_hash"`
+	Manifest     *Manifest `json:"manifest"`
+	Dir          string    `json:"dir"`
+	// ContainerImage and VMImage are the images built, if any.
+	ContainerImage string    `json:"container_image,omitempty"`
+	VMImage        string    `json:"vm_image,omitempty"`
+	DryRun         bool      `json:"dry_run,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+}
+
+// Build resolves the checksum of the gcsfuse-tools binary, writes the build
+// context to cfg.Dir and builds the images of cfg.Kinds.
+func Build(ctx context.Context, client *storage.Client, cfg Config) (*Result, error) {
+	m := cfg.Manifest
+	if err := m.resolve(ctx, client); err != nil {
+		return nil, fmt.Errorf("resolving the checksum of gcsfuse-tools %s: %w", m.GcsfuseTools, err)
+	}
+	r := &Result{ManifestHash: m.Hash(), Manifest: m, Dir: cfg.Dir, DryRun: cfg.DryRun, StartTime: time.Now()}
+	if err := writeContext(ctx, client, cfg); err != nil {
+		return nil, err
+	}
+	slog.Info("Wrote build context", "dir", cfg.Dir, "manifest", r.ManifestHash)
+	for _, k := range cfg.Kinds {
+		if cfg.DryRun {
+			break
+		}
+		var err error
+		switch k {
+		case KindContainer:
+			r.ContainerImage, err = buildContainer(ctx, cfg, r.ManifestHash)
+		case KindVM:
+			r.VMImage, err = buildVM(ctx, cfg, r.ManifestHash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("building the %s image: %w", k, err)
+		}
+	}
+	r.EndTime = time.Now()
+	return r, nil
+}
+
+// writeContext writes the files of the build context. The binary is only
+// downloaded for the container build, which cannot reach the bucket.
+func writeContext(ctx context.Context, client *storage.Client, cfg Config) error {
+	m := cfg.Manifest
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return err
+	}
+	for name, b := range map[string][]byte{
+		BootstrapFile:  Bootstrap(m),
+		DockerfileFile: Dockerfile(m),
+		ManifestFile:   append(ManifestJSON(m), '\n'),
+	} {
+		if err := os.WriteFile(filepath.Join(cfg.Dir, name), b, 0o644); err != nil {
+			return err
+		}
+	}
+	for _, k := range cfg.Kinds {
+		if k == KindContainer {
+			slog.Info("Downloading gcsfuse-tools", "version", m.GcsfuseTools, "arch", m.Arch)
+			return m.download(ctx, client, filepath.Join(cfg.Dir, ToolsFile))
+		}
+	}
+	return nil
+}
+
+// buildContainer builds the container image in cfg.Dir, tagged with the
+// manifest hash, and pushes it if configured.
+func buildContainer(ctx context.Context, cfg Config, hash string) (string, error) {
+	image := cfg.Repository + ":" + hash
+	platform := "linux/" + cfg.Manifest.Arch
+	slog.Info("Building container image", "image", image, "platform", platform)
+	if _, err := run(ctx, cfg.Docker, "build", "--platform", platform, "-t", image, cfg.Dir); err != nil {
+		return "", err
+	}
+	if cfg.Push {
+		slog.Info("Pushing container image", "image", image)
+		if _, err := run(ctx, cfg.Docker, "push", image); err != nil {
+			return "", err
+		}
+	}
+	return image, nil
+}
+
+// buildVM boots a temporary VM from the base image with the bootstrap
+// script as its startup script, waits for the script to finish, and creates
+// the image from its disk. The VM is deleted in any case.
+func buildVM(ctx context.Context, cfg Config, hash string) (image string, err error) {
+	m := cfg.Manifest
+	vm := "gcsfuse-image-build-" + hash
+	image = cfg.ImageFamily + "-" + hash
+	gcloud := func(args ...string) ([]byte, error) {
+		return run(ctx, cfg.Gcloud, append(args, "--project", cfg.Project, "--zone", cfg.Zone, "--quiet")...)
+	}
+
+	slog.Info("Creating build VM", "vm", vm, "image_family", m.VMImageFamily, "zone", cfg.Zone)
+	_, err = gcloud("compute", "instances", "create", vm, "--machine-type", cfg.MachineType,
+		"--image-family", m.VMImageFamily, "--image-project", m.VMImageProject, "--scopes", "storage-ro",
+		"--metadata-from-file", "startup-script="+filepath.Join(cfg.Dir, BootstrapFile))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		slog.Info("Deleting build VM", "vm", vm)
+		if _, derr := run(context.WithoutCancel(ctx), cfg.Gcloud, "compute", "instances", "delete", vm,
+			"--project", cfg.Project, "--zone", cfg.Zone, "--quiet"); derr != nil {
+			err = errors.Join(err, derr)
+		}
+	}()
+
+	if err := waitBootstrap(ctx, cfg, vm); err != nil {
+		return "", err
+	}
+	slog.Info("Stopping build VM", "vm", vm)
+	if _, err := gcloud("compute", "instances", "stop", vm); err != nil {
+		return "", err
+	}
+	slog.Info("Creating VM image", "image", image, "family", cfg.ImageFamily)
+	_, err = run(ctx, cfg.Gcloud, "compute", "images", "create", image, "--project", cfg.Project,
+		"--source-disk", vm, "--source-disk-zone", cfg.Zone, "--family", cfg.ImageFamily,
+		"--labels", "gcsfuse-tools-manifest="+hash, "--quiet")
+	if err != nil {
+		return "", err
+	}
+	return image, nil
+}
+
+// waitBootstrap polls the serial console of vm until the startup script
+// printed doneMarker, failed, or cfg.Timeout passed.
+func waitBootstrap(ctx context.Context, cfg Config, vm string) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	slog.Info("Waiting for the bootstrap script", "vm", vm, "timeout", cfg.Timeout)
+	for {
+		out, err := run(ctx, cfg.Gcloud, "compute", "instances", "get-serial-port-output", vm,
+			"--project", cfg.Project, "--zone", cfg.Zone)
+		if err == nil {
+			for _, line := range strings.Split(string(out), "\n") {
+				switch {
+				case strings.Contains(line, "startup-script") && strings.HasSuffix(strings.TrimSpace(line), doneMarker):
+					return nil
+				case strings.Contains(line, "startup-script") && (strings.Contains(line, "failed with error") ||
+					strings.Contains(line, "exit status") && !strings.HasSuffix(strings.TrimSpace(line), "exit status 0")):
+					return fmt.Errorf("the bootstrap script failed: %s; see the serial console of %s", strings.TrimSpace(line), vm)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the bootstrap script did not finish within %v: %w", cfg.Timeout, ctx.Err())
+		case <-time.After(15 * time.Second):
+		}
+	}
+}
+
+// run runs a command and returns its stdout.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args[:min(len(args), 3)], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// WriteText prints the images built and how to use them.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Manifest %s: gcsfuse %s, fio %s, gcsfuse-tools %s (%s)\n", r.ManifestHash,
+		r.Manifest.Gcsfuse, r.Manifest.Fio, r.Manifest.GcsfuseTools, r.Manifest.Arch)
+	fmt.Fprintf(w, "Build context: %s\n", r.Dir)
+	if r.ContainerImage != "" {
+		fmt.Fprintf(w, "Container image: %s (e.g. gke-bench --image=%s)\n", r.ContainerImage, r.ContainerImage)
+	}
+	if r.VMImage != "" {
+		fmt.Fprintf(w, "VM image: %s\n", r.VMImage)
+	}
+	var err error
+	if r.DryRun {
+		_, err = fmt.Fprintln(w, "Dry run: no image was built.")
+	} else {
+		_, err = fmt.Fprintf(w, "Built in %s.\n", r.EndTime.Sub(r.StartTime).Round(time.Second))
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package imagebuild
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest pins the versions of everything an image contains. Images built
+// from equal manifests are interchangeable.
+type Manifest struct {
+	// Gcsfuse is the gcsfuse release installed from its .deb package, e.g.
+	// 2.5.1.
+	Gcsfuse string `yaml:"gcsfuse" json:"gcsfuse"`
+	// Fio is the fio release built from the fio-<version> tag, e.g. 3.38.
+	Fio string `yaml:"fio" json:"fio"`
+	// GcsfuseTools is the gcsfuse-tools release that make publish wrote to
+	// ArtifactsBucket, and GcsfuseToolsSHA256 the checksum of its binary,
+	// resolved from the bucket if empty.
+	GcsfuseTools       string `yaml:"gcsfuse_tools" json:"gcsfuse_tools"`
+	GcsfuseToolsSHA256 string `yaml:"gcsfuse_tools_sha256,omitempty" json:"gcsfuse_tools_sha256"`
+	ArtifactsBucket    string `yaml:"artifacts_bucket" json:"artifacts_bucket"`
+	// Arch is amd64 or arm64.
+	Arch string `yaml:"arch,omitempty" json:"arch"`
+	// BaseImage is the base of the container image, and VMImageFamily and
+	// VMImageProject that of the VM image.
+	BaseImage      string `yaml:"base_image,omitempty" json:"base_image"`
+	VMImageFamily  string `yaml:"vm_image_family,omitempty" json:"vm_image_family"`
+	VMImageProject string `yaml:"vm_image_project,omitempty" json:"vm_image_project"`
+	// Packages are extra apt packages, e.g. python3 for the Python
+	// benchmarks.
+	Packages []string `yaml:"packages,omitempty" json:"packages,omitempty"`
+}
+
+// Defaults of the optional manifest fields.
+const (
+	DefaultArch           = "amd64"
+	DefaultBaseImage      = "ubuntu:24.04"
+	DefaultVMImageProject = "ubuntu-os-cloud"
+)
+
+// version matches the pinned versions; "latest" and branch names are not
+// pins.
+var version = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*(-[0-9A-Za-z.-]+)?$`)
+
+// LoadManifest reads a YAML manifest and fills in the defaults.
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if m.Arch == "" {
+		m.Arch = DefaultArch
+	}
+	if m.BaseImage == "" {
+		m.BaseImage = DefaultBaseImage
+	}
+	if m.VMImageFamily == "" {
+		m.VMImageFamily = "ubuntu-2404-lts-" + m.Arch
+	}
+	if m.VMImageProject == "" {
+		m.VMImageProject = DefaultVMImageProject
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Validate reports missing or unpinned versions.
+func (m *Manifest) Validate() error {
+	for _, v := range []struct{ field, value string }{{"gcsfuse", m.Gcsfuse}, {"fio", m.Fio}, {"gcsfuse_tools", m.GcsfuseTools}} {
+		if v.value == "" {
+			return fmt.Errorf("%s is required", v.field)
+		}
+		if !version.MatchString(v.value) {
+			return fmt.Errorf("%s %q is not a pinned version", v.field, v.value)
+		}
+	}
+	if m.ArtifactsBucket == "" {
+		return errors.New("artifacts_bucket is required")
+	}
+	if m.Arch != "amd64" && m.Arch != "arm64" {
+		return fmt.Errorf("unsupported arch %q", m.Arch)
+	}
+	for _, p := range m.Packages {
+		if p == "" || strings.ContainsAny(p, " \t\n;&|$`'\"\\") {
+			return fmt.Errorf("invalid package %q", p)
+		}
+	}
+	return nil
+}
+
+// Hash identifies the manifest: the first 12 hex digits of the SHA-256 of
+// its JSON encoding. It tags the images built from it.
+func (m *Manifest) Hash() string {
+	b, _ := json.Marshal(m)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// toolsObject is the gcsfuse-tools binary of the manifest in the artifacts
+// bucket.
+func (m *Manifest) toolsObject() string {
+	return fmt.Sprintf("gcsfuse-tools/%s/gcsfuse-tools-linux-%s", m.GcsfuseTools, m.Arch)
+}
+
+// resolve sets GcsfuseToolsSHA256 from the checksum make publish uploaded
+// with the binary, if it is not pinned yet.
+func (m *Manifest) resolve(ctx context.Context, client *storage.Client) error {
+	if m.GcsfuseToolsSHA256 != "" {
+		return nil
+	}
+	b, err := read(ctx, client.Bucket(m.ArtifactsBucket).Object(m.toolsObject()+".sha256"))
+	if err != nil {
+		return err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+	if len(sum) != sha256.Size*2 {
+		return fmt.Errorf("gs://%s/%s.sha256 holds no SHA-256", m.ArtifactsBucket, m.toolsObject())
+	}
+	m.GcsfuseToolsSHA256 = sum
+	return nil
+}
+
+// download writes the gcsfuse-tools binary of the manifest to path and
+// checks it against GcsfuseToolsSHA256.
+func (m *Manifest) download(ctx context.Context, client *storage.Client, path string) error {
+	bin, err := read(ctx, client.Bucket(m.ArtifactsBucket).Object(m.toolsObject()))
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(bin); hex.EncodeToString(got[:]) != m.GcsfuseToolsSHA256 {
+		return fmt.Errorf("checksum of gs://%s/%s is %x, want %s", m.ArtifactsBucket, m.toolsObject(), got, m.GcsfuseToolsSHA256)
+	}
+	return os.WriteFile(path, bin, 0o755)
+}
+
+func read(ctx context.Context, obj *storage.ObjectHandle) ([]byte, error) {
+	rd, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading gs://%s/%s: %w", obj.BucketName(), obj.ObjectName(), err)
+	}
+	defer rd.Close()
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, fmt.Errorf("reading gs://%s/%s: %w", obj.BucketName(), obj.ObjectName(), err)
+	}
+	return b, nil
+}
@@ -0,0 +1,110 @@
+package imagebuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"text/template"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Files of the build context.
+const (
+	BootstrapFile  = "bootstrap.sh"
+	DockerfileFile = "Dockerfile"
+	ManifestFile   = "image-manifest.json"
+	// ToolsFile is the gcsfuse-tools binary the container build copies in.
+	// The VM downloads it from the artifacts bucket instead.
+	ToolsFile = "gcsfuse-tools"
+)
+
+// doneMarker is the last line the bootstrap script prints, which the VM
+// build waits for on the serial console.
+const doneMarker = "gcsfuse-tools image-build: done"
+
+// stagingDir is where the container build copies the build context to.
+const stagingDir = "/tmp/image-build"
+
+var bootstrapTmpl = template.Must(template.New("bootstrap").Parse(`#!/bin/bash
+# Generated by gcsfuse-tools image-build from manifest {{.Hash}}. Installs the
+# pinned gcsfuse, fio and gcsfuse-tools, identically on VMs and in containers.
+set -euxo pipefail
+export DEBIAN_FRONTEND=noninteractive
+
+apt-get update
+apt-get install -y --no-install-recommends ca-certificates curl git build-essential libaio-dev zlib1g-dev{{range .Packages}} {{.}}{{end}}
+
+curl -fsSL -o /tmp/gcsfuse.deb https://github.com/GoogleCloudPlatform/gcsfuse/releases/download/v{{.Gcsfuse}}/gcsfuse_{{.Gcsfuse}}_{{.Arch}}.deb
+apt-get install -y /tmp/gcsfuse.deb
+rm /tmp/gcsfuse.deb
+
+git clone --depth 1 --branch fio-{{.Fio}} https://github.com/axboe/fio /tmp/fio
+(cd /tmp/fio && ./configure && make -j"$(nproc)" && make install)
+rm -rf /tmp/fio
+
+mkdir -p {{.Staging}}
+if [ ! -f {{.Staging}}/gcsfuse-tools ]; then
+  gcloud storage cp gs://{{.ArtifactsBucket}}/{{.ToolsObject}} {{.Staging}}/gcsfuse-tools
+fi
+echo "{{.GcsfuseToolsSHA256}}  {{.Staging}}/gcsfuse-tools" | sha256sum -c -
+install -m 0755 {{.Staging}}/gcsfuse-tools /usr/local/bin/gcsfuse-tools
+
+mkdir -p {{.ManifestDir}}
+cat > {{.ManifestPath}} <<'EOF'
+{{.JSON}}
+EOF
+
+echo "{{.Done}}"
+`))
+
+var dockerfileTmpl = template.Must(template.New("dockerfile").Parse(`# Generated by gcsfuse-tools image-build from manifest {{.Hash}}.
+FROM {{.BaseImage}}
+COPY {{.Tools}} {{.Bootstrap}} {{.Staging}}/
+RUN bash {{.Staging}}/{{.Bootstrap}} && rm -rf {{.Staging}}
+LABEL gcsfuse-tools.manifest={{.Hash}}
+`))
+
+// record is the content of envinfo.ImageManifestPath: the manifest and its
+// hash.
+type record struct {
+	Hash string `json:"hash"`
+	*Manifest
+}
+
+// ManifestJSON returns the record of m installed in the images.
+func ManifestJSON(m *Manifest) []byte {
+	b, _ := json.MarshalIndent(record{m.Hash(), m}, "", "  ")
+	return b
+}
+
+// Bootstrap returns the script installing the tools of m, which is both the
+// startup script of the VM build and the RUN step of the container build.
+func Bootstrap(m *Manifest) []byte {
+	var b bytes.Buffer
+	err := bootstrapTmpl.Execute(&b, struct {
+		*Manifest
+		Hash, ToolsObject, Staging, ManifestDir, ManifestPath, JSON, Done string
+	}{
+		m, m.Hash(), m.toolsObject(), stagingDir, path.Dir(envinfo.ImageManifestPath),
+		envinfo.ImageManifestPath, string(ManifestJSON(m)), doneMarker,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("rendering the bootstrap script: %v", err))
+	}
+	return b.Bytes()
+}
+
+// Dockerfile returns the Dockerfile of the container image of m, built in a
+// context holding ToolsFile and BootstrapFile.
+func Dockerfile(m *Manifest) []byte {
+	var b bytes.Buffer
+	err := dockerfileTmpl.Execute(&b, struct {
+		Hash, BaseImage, Tools, Bootstrap, Staging string
+	}{m.Hash(), m.BaseImage, ToolsFile, BootstrapFile, stagingDir})
+	if err != nil {
+		panic(fmt.Sprintf("rendering the Dockerfile: %v", err))
+	}
+	return b.Bytes()
+}
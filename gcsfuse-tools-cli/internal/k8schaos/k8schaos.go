@@ -0,0 +1,352 @@
+// Package k8schaos disrupts workloads that mount buckets with the Cloud
+// Storage FUSE CSI driver, by draining their node, killing their gcsfuse
+// sidecar, evicting them or restarting the driver, and scores how they
+// recover: whether the pods come back Ready, the mount works again, the data
+// still checks out and which I/O errors the workload saw.
+package k8schaos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Supported faults.
+const (
+	// FaultDrainNode drains the node of a workload pod and uncordons it
+	// once the workload recovered elsewhere.
+	FaultDrainNode = "drain-node"
+	// FaultKillSidecar terminates the gcsfuse sidecar of a workload pod
+	// from an ephemeral container sharing its process namespace.
+	FaultKillSidecar = "kill-sidecar"
+	// FaultEvictPod deletes a workload pod for its controller to recreate.
+	FaultEvictPod = "evict-pod"
+	// FaultRestartCSI restarts the CSI driver DaemonSet on every node.
+	FaultRestartCSI = "restart-csi"
+)
+
+// SidecarContainer is the name of the container the CSI driver injects to
+// run gcsfuse.
+const SidecarContainer = "gke-gcsfuse-sidecar"
+
+// ioErrors matches the log lines of a workload that saw its mount fail.
+var ioErrors = regexp.MustCompile(`(?i)transport endpoint is not connected|input/output error|\bEIO\b|\bENOTCONN\b|stale file handle|software caused connection abort`)
+
+// Config holds the chaos options.
+type Config struct {
+	Kubectl     string
+	KubeContext string
+	Namespace   string
+	// Selector is the label selector of the workload pods.
+	Selector string
+	// Container is the workload container, for logs and checks. Empty
+	// means the first container that is not the sidecar.
+	Container string
+	Faults    []string
+	Rounds    int
+	// RecoveryTimeout bounds the wait for the workload to be Ready again,
+	// and Settle is the pause after a recovery before the next fault.
+	RecoveryTimeout time.Duration
+	Settle          time.Duration
+	// MountPath, when set, is listed in the workload container after each
+	// recovery to check that the volume is mounted again.
+	MountPath string
+	// VerifyCommand, when set, runs in the workload container after each
+	// recovery with sh -c, e.g. a gcsfuse-tools coherence check; a non-zero
+	// exit fails the fault's integrity check.
+	VerifyCommand string
+	// DebugImage runs the ephemeral container that kills the sidecar.
+	DebugImage string
+	// CSINamespace and CSIDaemonSet locate the CSI driver.
+	CSINamespace string
+	CSIDaemonSet string
+}
+
+// Validate reports missing or unsupported options.
+func (c *Config) Validate() error {
+	if c.Selector == "" {
+		return errors.New("--selector is required")
+	}
+	if len(c.Faults) == 0 {
+		return errors.New("--faults must not be empty")
+	}
+	for _, f := range c.Faults {
+		switch f {
+		case FaultDrainNode, FaultKillSidecar, FaultEvictPod, FaultRestartCSI:
+		default:
+			return fmt.Errorf("unsupported fault %q (want %s, %s, %s or %s)", f, FaultDrainNode, FaultKillSidecar, FaultEvictPod, FaultRestartCSI)
+		}
+	}
+	if c.Rounds <= 0 {
+		return errors.New("--rounds must be greater than 0")
+	}
+	if c.RecoveryTimeout <= 0 {
+		return errors.New("--recovery-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// Outcome is the effect of one fault on the workload.
+type Outcome struct {
+	Fault  string    `json:"fault"`
+	Target string    `json:"target"`
+	Start  time.Time `json:"start"`
+	// Recovered reports whether all pods were Ready again within the
+	// recovery timeout, RecoverySec how long it took.
+	Recovered   bool    `json:"recovered"`
+	RecoverySec float64 `json:"recovery_sec"`
+	// Restarts counts the container restarts of the surviving pods.
+	Restarts int `json:"restarts"`
+	// IOErrors counts the log lines of the workload containers since the
+	// fault that show a failed mount, and Errors holds a few of them.
+	IOErrors int      `json:"io_errors"`
+	Errors   []string `json:"errors,omitempty"`
+	// MountOK and IntegrityOK are nil when not checked.
+	MountOK     *bool `json:"mount_ok,omitempty"`
+	IntegrityOK *bool `json:"integrity_ok,omitempty"`
+	Passed      bool  `json:"passed"`
+	// Problem is why the fault could not be injected or checked.
+	Problem string `json:"problem,omitempty"`
+}
+
+// Scorecard is the resilience of a workload to the faults, for one driver
+// and gcsfuse version.
+type Scorecard struct {
+	Namespace      string    `json:"namespace"`
+	Selector       string    `json:"selector"`
+	DriverVersion  string    `json:"driver_version"`
+	GcsfuseVersion string    `json:"gcsfuse_version"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	Outcomes       []Outcome `json:"outcomes"`
+	Passed         int       `json:"passed"`
+}
+
+// Failed returns the number of faults the workload did not survive.
+func (s *Scorecard) Failed() int {
+	return len(s.Outcomes) - s.Passed
+}
+
+// Run injects every fault of every round in turn, waiting for the workload
+// to recover and checking it after each.
+func Run(ctx context.Context, cfg Config) (*Scorecard, error) {
+	k := kube{cfg: cfg}
+	pods, err := k.pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pods match %s in namespace %s", cfg.Selector, cfg.Namespace)
+	}
+	if cfg.Container == "" {
+		cfg.Container = pods[0].workloadContainer()
+		k.cfg = cfg
+	}
+	sc := &Scorecard{
+		Namespace:      cfg.Namespace,
+		Selector:       cfg.Selector,
+		GcsfuseVersion: imageTag(pods[0].sidecarImage()),
+		StartTime:      time.Now(),
+		Outcomes:       []Outcome{},
+	}
+	if sc.DriverVersion, err = k.driverVersion(ctx); err != nil {
+		slog.Warn("Reading the CSI driver version failed", "err", err)
+	}
+	want := len(pods)
+	slog.Info("Starting chaos", "pods", want, "faults", cfg.Faults, "rounds", cfg.Rounds,
+		"driver", sc.DriverVersion, "gcsfuse", sc.GcsfuseVersion)
+
+	for round := 0; round < cfg.Rounds; round++ {
+		for _, fault := range cfg.Faults {
+			o, err := inject(ctx, k, cfg, fault, want)
+			if err != nil {
+				return nil, err
+			}
+			if o.Passed {
+				sc.Passed++
+			}
+			sc.Outcomes = append(sc.Outcomes, *o)
+			slog.Info("Fault done", "fault", fault, "target", o.Target, "recovered", o.Recovered,
+				"recovery", time.Duration(o.RecoverySec*float64(time.Second)).Round(time.Second), "io_errors", o.IOErrors, "passed", o.Passed)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(cfg.Settle):
+			}
+		}
+	}
+	sc.EndTime = time.Now()
+	return sc, nil
+}
+
+// inject applies one fault and checks the recovery. Failures of the fault
+// itself are recorded in the outcome; only a canceled ctx is returned.
+func inject(ctx context.Context, k kube, cfg Config, fault string, want int) (*Outcome, error) {
+	pods, err := k.pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return &Outcome{Fault: fault, Start: time.Now(), Problem: "no workload pods left"}, nil
+	}
+	victim := pods[0]
+	before := map[string]int{}
+	for _, p := range pods {
+		before[p.Metadata.Name] = p.restarts()
+	}
+
+	o := &Outcome{Fault: fault, Start: time.Now()}
+	var undo func()
+	switch fault {
+	case FaultDrainNode:
+		o.Target = "node/" + victim.Spec.NodeName
+		_, err = k.run(ctx, "drain", victim.Spec.NodeName, "--ignore-daemonsets", "--delete-emptydir-data",
+			"--timeout="+cfg.RecoveryTimeout.String())
+		undo = func() {
+			if _, err := k.run(context.WithoutCancel(ctx), "uncordon", victim.Spec.NodeName); err != nil {
+				slog.Warn("Uncordoning failed", "node", victim.Spec.NodeName, "err", err)
+			}
+		}
+	case FaultKillSidecar:
+		o.Target = "pod/" + victim.Metadata.Name
+		_, err = k.run(ctx, "debug", victim.Metadata.Name, "--namespace", cfg.Namespace, "--image="+cfg.DebugImage,
+			"--target="+SidecarContainer, "--profile=general", "--quiet", "--", "sh", "-c", "kill -TERM 1")
+		if err == nil {
+			// The pod may still look Ready until the kubelet notices.
+			err = k.waitRestart(ctx, victim.Metadata.Name, before[victim.Metadata.Name], cfg.RecoveryTimeout)
+		}
+	case FaultEvictPod:
+		o.Target = "pod/" + victim.Metadata.Name
+		_, err = k.run(ctx, "delete", "pod", victim.Metadata.Name, "--namespace", cfg.Namespace, "--wait=false")
+	case FaultRestartCSI:
+		o.Target = cfg.CSINamespace + "/daemonset/" + cfg.CSIDaemonSet
+		if _, err = k.run(ctx, "rollout", "restart", "daemonset/"+cfg.CSIDaemonSet, "--namespace", cfg.CSINamespace); err == nil {
+			_, err = k.run(ctx, "rollout", "status", "daemonset/"+cfg.CSIDaemonSet, "--namespace", cfg.CSINamespace,
+				"--timeout="+cfg.RecoveryTimeout.String())
+		}
+	}
+	if undo != nil {
+		defer undo()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		o.Problem = err.Error()
+		return o, nil
+	}
+	slog.Info("Injected fault", "fault", fault, "target", o.Target)
+
+	recovered, err := k.waitReady(ctx, want, cfg.RecoveryTimeout)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	o.RecoverySec = time.Since(o.Start).Seconds()
+	if err != nil {
+		o.Problem = err.Error()
+	}
+	o.Recovered = recovered != nil
+	for _, p := range recovered {
+		if n, ok := before[p.Metadata.Name]; ok {
+			o.Restarts += p.restarts() - n
+		}
+		o.IOErrors += k.countErrors(ctx, p.Metadata.Name, o)
+	}
+	if o.Recovered {
+		check(ctx, k, cfg, recovered[0].Metadata.Name, o)
+	}
+	o.Passed = o.Recovered && (o.MountOK == nil || *o.MountOK) && (o.IntegrityOK == nil || *o.IntegrityOK)
+	return o, nil
+}
+
+// check runs the mount and integrity checks of cfg in pod.
+func check(ctx context.Context, k kube, cfg Config, pod string, o *Outcome) {
+	if cfg.MountPath != "" {
+		_, err := k.exec(ctx, pod, "ls", cfg.MountPath)
+		ok := err == nil
+		o.MountOK = &ok
+		if !ok {
+			o.Problem = err.Error()
+		}
+	}
+	if cfg.VerifyCommand != "" {
+		out, err := k.exec(ctx, pod, "sh", "-c", cfg.VerifyCommand)
+		ok := err == nil
+		o.IntegrityOK = &ok
+		if !ok {
+			o.Problem = err.Error()
+			if len(bytes.TrimSpace(out)) > 0 {
+				o.Problem += ": " + tail(out, 5)
+			}
+		}
+	}
+}
+
+// imageTag returns the tag of image, its digest if it has no tag, or image
+// if it has neither.
+func imageTag(image string) string {
+	name, digest, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[i+1:]
+	}
+	if digest != "" {
+		return digest
+	}
+	return image
+}
+
+func tail(b []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	return strings.Join(lines[max(0, len(lines)-n):], "\n")
+}
+
+// WriteText prints the scorecard with one row per fault.
+func (s *Scorecard) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Resilience of %s in %s: CSI driver %s, gcsfuse %s: %d of %d faults survived (%s)\n\n",
+		s.Selector, s.Namespace, orUnknown(s.DriverVersion), orUnknown(s.GcsfuseVersion), s.Passed, len(s.Outcomes),
+		s.EndTime.Sub(s.StartTime).Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FAULT\tTARGET\tRECOVERED\tRECOVERY\tRESTARTS\tIO ERRORS\tMOUNT\tINTEGRITY\tRESULT\tPROBLEM")
+	for _, o := range s.Outcomes {
+		result := "FAIL"
+		if o.Passed {
+			result = "PASS"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", o.Fault, o.Target, o.Recovered,
+			time.Duration(o.RecoverySec*float64(time.Second)).Round(time.Second), o.Restarts, o.IOErrors,
+			checkText(o.MountOK), checkText(o.IntegrityOK), result, o.Problem)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, o := range s.Outcomes {
+		for _, e := range o.Errors {
+			fmt.Fprintf(w, "%s %s: %s\n", o.Fault, o.Target, e)
+		}
+	}
+	return nil
+}
+
+func checkText(ok *bool) string {
+	switch {
+	case ok == nil:
+		return "-"
+	case *ok:
+		return "ok"
+	}
+	return "failed"
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
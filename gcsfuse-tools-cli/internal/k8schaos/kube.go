@@ -0,0 +1,240 @@
+package k8schaos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxErrorLines bounds the log lines kept per outcome.
+const maxErrorLines = 5
+
+// The subset of the Kubernetes objects read by k8s-chaos.
+type (
+	container struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	}
+	containerStatus struct {
+		Name         string `json:"name"`
+		RestartCount int    `json:"restartCount"`
+	}
+	pod struct {
+		Metadata struct {
+			Name              string  `json:"name"`
+			DeletionTimestamp *string `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName       string      `json:"nodeName"`
+			Containers     []container `json:"containers"`
+			InitContainers []container `json:"initContainers"`
+		} `json:"spec"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+			ContainerStatuses     []containerStatus `json:"containerStatuses"`
+			InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+		} `json:"status"`
+	}
+)
+
+func (p *pod) ready() bool {
+	if p.Metadata.DeletionTimestamp != nil || p.Status.Phase != "Running" {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// restarts sums the restarts of the containers, including the sidecar,
+// which runs as a restartable init container on recent clusters.
+func (p *pod) restarts() int {
+	n := 0
+	for _, s := range slices.Concat(p.Status.ContainerStatuses, p.Status.InitContainerStatuses) {
+		n += s.RestartCount
+	}
+	return n
+}
+
+func (p *pod) workloadContainer() string {
+	for _, c := range p.Spec.Containers {
+		if c.Name != SidecarContainer {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+func (p *pod) sidecarImage() string {
+	for _, c := range slices.Concat(p.Spec.InitContainers, p.Spec.Containers) {
+		if c.Name == SidecarContainer {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+type kube struct {
+	cfg Config
+}
+
+// run runs kubectl and returns its stdout. Commands on the workload add
+// their own --namespace.
+func (k kube) run(ctx context.Context, args ...string) ([]byte, error) {
+	if k.cfg.KubeContext != "" {
+		args = append(args, "--context", k.cfg.KubeContext)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, k.cfg.Kubectl, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("kubectl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// pods returns the workload pods that are not being deleted.
+func (k kube) pods(ctx context.Context) ([]pod, error) {
+	out, err := k.run(ctx, "get", "pods", "--namespace", k.cfg.Namespace, "--selector", k.cfg.Selector, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []pod `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("decoding pods: %w", err)
+	}
+	var live []pod
+	for _, p := range list.Items {
+		if p.Metadata.DeletionTimestamp == nil {
+			live = append(live, p)
+		}
+	}
+	return live, nil
+}
+
+// waitReady polls the workload until want pods are Ready, and returns them,
+// or nil and the last state once timeout passed.
+func (k kube) waitReady(ctx context.Context, want int, timeout time.Duration) ([]pod, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := k.pods(ctx)
+		if err != nil {
+			slog.Warn("Listing workload pods failed", "err", err)
+		}
+		var ready []pod
+		for _, p := range pods {
+			if p.ready() {
+				ready = append(ready, p)
+			}
+		}
+		if len(ready) >= want {
+			return ready, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%d of %d pods Ready after %s", len(ready), want, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// waitRestart polls pod until its containers restarted more than n times.
+func (k kube) waitRestart(ctx context.Context, name string, n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := k.pods(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range pods {
+			if p.Metadata.Name == name && p.restarts() > n {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s in pod %s did not restart within %s", SidecarContainer, name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// countErrors counts the I/O error lines the workload container of pod
+// logged since the fault, keeping the first few in o.
+func (k kube) countErrors(ctx context.Context, pod string, o *Outcome) int {
+	out, err := k.run(ctx, "logs", pod, "--namespace", k.cfg.Namespace, "-c", k.cfg.Container,
+		"--since-time="+o.Start.UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Warn("Reading workload logs failed", "pod", pod, "err", err)
+		return 0
+	}
+	n := 0
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if ioErrors.MatchString(sc.Text()) {
+			n++
+			if len(o.Errors) < maxErrorLines {
+				o.Errors = append(o.Errors, pod+": "+strings.TrimSpace(sc.Text()))
+			}
+		}
+	}
+	return n
+}
+
+// exec runs command in the workload container of pod.
+func (k kube) exec(ctx context.Context, pod string, command ...string) ([]byte, error) {
+	args := append([]string{"exec", pod, "--namespace", k.cfg.Namespace, "-c", k.cfg.Container, "--"}, command...)
+	return k.run(ctx, args...)
+}
+
+// driverVersion returns the image tag of the CSI driver container of the
+// driver DaemonSet.
+func (k kube) driverVersion(ctx context.Context) (string, error) {
+	out, err := k.run(ctx, "get", "daemonset", k.cfg.CSIDaemonSet, "--namespace", k.cfg.CSINamespace, "-o", "json")
+	if err != nil {
+		return "", err
+	}
+	var ds struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Containers []container `json:"containers"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(out, &ds); err != nil {
+		return "", fmt.Errorf("decoding daemonset: %w", err)
+	}
+	for _, c := range ds.Spec.Template.Spec.Containers {
+		if strings.Contains(c.Image, "gcs-fuse-csi-driver") {
+			return imageTag(c.Image), nil
+		}
+	}
+	if len(ds.Spec.Template.Spec.Containers) > 0 {
+		return imageTag(ds.Spec.Template.Spec.Containers[0].Image), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,418 @@
+// Package migrate rewrites a gcsfuse invocation or config file written for an
+// older gcsfuse release into its equivalent for a newer one, and flags the
+// removed and renamed options and changed defaults in between.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the input and the migrated output.
+const (
+	FormatFlags  = "flags"
+	FormatConfig = "config"
+)
+
+// Finding kinds besides the rule kinds.
+const (
+	// Unknown options are not known to the advisor and passed through.
+	Unknown = "unknown"
+	// FlagOnly options have no config file key and stay on the command line.
+	FlagOnly = "flag-only"
+)
+
+// Setting is an option, by config key or flag-only name, and its value.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Input is a parsed gcsfuse invocation or config file.
+type Input struct {
+	Format   string
+	Settings []Setting
+	// Args are the positional arguments of an invocation: bucket and mount
+	// point.
+	Args []string
+}
+
+// ParseInvocation parses a gcsfuse command line, with or without the leading
+// "gcsfuse". Flags take one or two dashes and "=value" or a separate value.
+func ParseInvocation(args []string) (*Input, error) {
+	if len(args) > 0 && filepath.Base(args[0]) == "gcsfuse" {
+		args = args[1:]
+	}
+	in := &Input{Format: FormatFlags}
+	for i := 0; i < len(args); i++ {
+		t := args[i]
+		if t == "--" {
+			in.Args = append(in.Args, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(t, "-") || t == "-" {
+			in.Args = append(in.Args, t)
+			continue
+		}
+		name, val, hasVal := strings.Cut(strings.TrimLeft(t, "-"), "=")
+		key := canonical(name)
+		o, known := lookup(key)
+		if !hasVal {
+			if known && o.Kind != kindBool {
+				if i+1 == len(args) {
+					return nil, fmt.Errorf("flag %s needs a value", t)
+				}
+				i++
+				val = args[i]
+			} else {
+				val = "true"
+			}
+		}
+		if j := index(in.Settings, key); j >= 0 && o.Kind == kindList {
+			in.Settings[j].Value += "," + val
+			continue
+		}
+		in.Settings = append(in.Settings, Setting{Key: key, Value: val})
+	}
+	if len(in.Settings) == 0 && len(in.Args) == 0 {
+		return nil, errors.New("empty gcsfuse invocation")
+	}
+	return in, nil
+}
+
+// ParseConfig parses a gcsfuse config.yaml.
+func ParseConfig(b []byte) (*Input, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	in := &Input{Format: FormatConfig}
+	flatten("", doc, &in.Settings)
+	return in, nil
+}
+
+func flatten(prefix string, m map[string]any, out *[]Setting) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := prefix + k
+		switch v := m[k].(type) {
+		case nil:
+		case map[string]any:
+			flatten(key+":", v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			*out = append(*out, Setting{Key: key, Value: strings.Join(items, ",")})
+		default:
+			*out = append(*out, Setting{Key: key, Value: fmt.Sprint(v)})
+		}
+	}
+}
+
+// Config selects the releases and rules of a migration.
+type Config struct {
+	From string
+	// To is the target release; empty applies every newer rule.
+	To    string
+	Rules []Rule
+	// Format of the output; empty keeps the input's.
+	Format string
+	// PinDefaults sets options whose default changed to their old default,
+	// keeping the old behavior.
+	PinDefaults bool
+}
+
+// Finding is one change the migration made or the user has to review.
+type Finding struct {
+	Version string `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+	Option  string `json:"option"`
+	Detail  string `json:"detail"`
+}
+
+// Result is the migrated configuration.
+type Result struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Format   string    `json:"format"`
+	Findings []Finding `json:"findings"`
+	Settings []Setting `json:"settings"`
+	Args     []string  `json:"args,omitempty"`
+	// Output is the migrated invocation or config file.
+	Output string `json:"output"`
+}
+
+// Migrate applies the rules of the releases after cfg.From up to cfg.To to in.
+func Migrate(in *Input, cfg Config) (*Result, error) {
+	from, err := parseVersion(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	to := [3]int{1 << 30}
+	if cfg.To != "" {
+		if to, err = parseVersion(cfg.To); err != nil {
+			return nil, err
+		}
+		if compareVersions(from, to) >= 0 {
+			return nil, fmt.Errorf("target version %s is not newer than %s", cfg.To, cfg.From)
+		}
+	}
+	format := cfg.Format
+	if format == "" {
+		format = in.Format
+	}
+	res := &Result{From: cfg.From, To: cfg.To, Format: format, Args: in.Args}
+	if res.To == "" {
+		res.To = "latest"
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = DefaultRules
+	}
+	rules := slices.Clone(cfg.Rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, _ := parseVersion(rules[i].Version)
+		b, _ := parseVersion(rules[j].Version)
+		return compareVersions(a, b) < 0
+	})
+
+	named := map[string]bool{}
+	for _, r := range rules {
+		named[canonical(r.Option)] = true
+		if r.Replacement != "" {
+			named[canonical(r.Replacement)] = true
+		}
+	}
+	settings := slices.Clone(in.Settings)
+	for _, s := range settings {
+		if _, ok := lookup(s.Key); !ok && !named[s.Key] {
+			res.Findings = append(res.Findings, Finding{Kind: Unknown, Option: display(s.Key, format),
+				Detail: "not known to the advisor; passed through unchanged"})
+		}
+	}
+
+	for _, r := range rules {
+		v, err := parseVersion(r.Version)
+		if err != nil {
+			return nil, err
+		}
+		if compareVersions(v, from) <= 0 || compareVersions(v, to) > 0 {
+			continue
+		}
+		key := canonical(r.Option)
+		f := Finding{Version: r.Version, Kind: r.Kind, Option: display(key, format)}
+		i := index(settings, key)
+		switch r.Kind {
+		case Renamed:
+			if i < 0 {
+				continue
+			}
+			old := settings[i]
+			settings = slices.Delete(settings, i, i+1)
+			val, keep := old.Value, true
+			if conv := converters[r.Convert]; conv != nil {
+				if val, keep, err = conv(old.Value); err != nil {
+					return nil, fmt.Errorf("converting %s=%s: %w", f.Option, old.Value, err)
+				}
+			}
+			repl := canonical(r.Replacement)
+			switch {
+			case !keep:
+				f.Detail = fmt.Sprintf("renamed to %s; dropped because it was turned off", display(repl, format))
+			case index(settings, repl) >= 0:
+				existing := settings[index(settings, repl)].Value
+				f.Detail = fmt.Sprintf("renamed to %s, which is already set; kept %s instead of %s, review it",
+					display(repl, format), existing, val)
+			default:
+				settings = slices.Insert(settings, i, Setting{Key: repl, Value: val})
+				f.Detail = "renamed to " + display(repl, format)
+				if val != old.Value {
+					f.Detail += fmt.Sprintf(" (%s becomes %s)", old.Value, val)
+				}
+			}
+		case Removed:
+			if i < 0 {
+				continue
+			}
+			settings = slices.Delete(settings, i, i+1)
+			f.Detail = "removed"
+			if r.Replacement != "" {
+				f.Detail += fmt.Sprintf("; set %s by hand", display(canonical(r.Replacement), format))
+			}
+		case DefaultChanged:
+			if i >= 0 {
+				continue
+			}
+			f.Detail = fmt.Sprintf("default changed from %s to %s", r.OldDefault, r.NewDefault)
+			if cfg.PinDefaults {
+				settings = append(settings, Setting{Key: key, Value: r.OldDefault})
+				f.Detail += "; pinned to " + r.OldDefault
+			}
+		default:
+			return nil, fmt.Errorf("rule for %s: unknown kind %q", r.Option, r.Kind)
+		}
+		if r.Note != "" {
+			f.Detail += ": " + r.Note
+		}
+		res.Findings = append(res.Findings, f)
+	}
+
+	if format == FormatConfig {
+		for _, s := range settings {
+			if o, ok := lookup(s.Key); ok && o.Key == "" {
+				res.Findings = append(res.Findings, Finding{Kind: FlagOnly, Option: display(s.Key, format),
+					Detail: "has no config file key; pass it on the command line"})
+			}
+		}
+	}
+	res.Settings = settings
+	if res.Output, err = render(format, settings, in.Args); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func index(settings []Setting, key string) int {
+	return slices.IndexFunc(settings, func(s Setting) bool { return s.Key == key })
+}
+
+// display names key as written in format.
+func display(key, format string) string {
+	if o, ok := lookup(key); format == FormatFlags || (ok && o.Key == "") {
+		if n := flagName(key); len(n) == 1 {
+			return "-" + n
+		}
+		return "--" + flagName(key)
+	}
+	return key
+}
+
+func render(format string, settings []Setting, args []string) (string, error) {
+	switch format {
+	case FormatFlags:
+		return renderFlags(settings, args), nil
+	case FormatConfig:
+		return renderConfig(settings)
+	}
+	return "", fmt.Errorf("unknown format %q, want %s or %s", format, FormatFlags, FormatConfig)
+}
+
+func renderFlags(settings []Setting, args []string) string {
+	parts := []string{"gcsfuse"}
+	for _, s := range settings {
+		o, _ := lookup(s.Key)
+		name := display(s.Key, FormatFlags)
+		switch {
+		case len(name) == 2:
+			parts = append(parts, name, shellQuote(s.Value))
+		case o.Kind == kindBool && s.Value == "true":
+			parts = append(parts, name)
+		default:
+			parts = append(parts, name+"="+shellQuote(s.Value))
+		}
+	}
+	for _, a := range args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ") + "\n"
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"$`\\*?;&|<>()") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// renderConfig writes the settings with config keys as YAML and lists the
+// flag-only ones in a trailing comment.
+func renderConfig(settings []Setting) (string, error) {
+	doc := map[string]any{}
+	var flags []string
+	for _, s := range settings {
+		o, known := lookup(s.Key)
+		if known && o.Key == "" {
+			flags = append(flags, "--"+o.Flag+"="+shellQuote(s.Value))
+			continue
+		}
+		path := strings.Split(s.Key, ":")
+		m := doc
+		for _, p := range path[:len(path)-1] {
+			sub, ok := m[p].(map[string]any)
+			if !ok {
+				sub = map[string]any{}
+				m[p] = sub
+			}
+			m = sub
+		}
+		m[path[len(path)-1]] = typed(o.Kind, s.Value)
+	}
+	var b strings.Builder
+	if len(doc) > 0 {
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return "", err
+		}
+		if err := enc.Close(); err != nil {
+			return "", err
+		}
+	}
+	if len(flags) > 0 {
+		fmt.Fprintf(&b, "# Command-line only: %s\n", strings.Join(flags, " "))
+	}
+	return b.String(), nil
+}
+
+// typed converts a value to the YAML type of kind, guessing for unknown
+// options.
+func typed(kind, v string) any {
+	switch kind {
+	case kindString, kindDuration:
+		return v
+	case kindList:
+		return strings.Split(v, ",")
+	}
+	if kind == kindBool || v == "true" || v == "false" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && kind != kindFloat {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// WriteText prints the findings as comments followed by the migrated
+// invocation or config file, so the output can be saved as is.
+func (r *Result) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Migrated from gcsfuse %s to %s: %d finding(s).\n", r.From, r.To, len(r.Findings))
+	for _, f := range r.Findings {
+		label := f.Kind
+		if f.Version != "" {
+			label = f.Version + " " + f.Kind
+		}
+		fmt.Fprintf(&b, "# [%s] %s %s\n", label, f.Option, f.Detail)
+	}
+	b.WriteString(r.Output)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,124 @@
+package migrate
+
+import "strings"
+
+// Value kinds of gcsfuse options.
+const (
+	kindString   = "string"
+	kindBool     = "bool"
+	kindInt      = "int"
+	kindFloat    = "float"
+	kindDuration = "duration"
+	kindList     = "list"
+)
+
+// option maps a gcsfuse command-line flag to its config file key. Key is
+// empty for flags that never had a config file equivalent.
+type option struct {
+	Flag string
+	Key  string
+	Kind string
+}
+
+// options lists the gcsfuse flags known to the advisor, current and removed.
+var options = []option{
+	{"app-name", "app-name", kindString},
+	{"implicit-dirs", "implicit-dirs", kindBool},
+	{"only-dir", "only-dir", kindString},
+	{"cache-dir", "cache-dir", kindString},
+	{"foreground", "foreground", kindBool},
+	{"enable-hns", "enable-hns", kindBool},
+	{"o", "file-system:fuse-options", kindList},
+	{"uid", "file-system:uid", kindInt},
+	{"gid", "file-system:gid", kindInt},
+	{"file-mode", "file-system:file-mode", kindString},
+	{"dir-mode", "file-system:dir-mode", kindString},
+	{"rename-dir-limit", "file-system:rename-dir-limit", kindInt},
+	{"kernel-list-cache-ttl-secs", "file-system:kernel-list-cache-ttl-secs", kindInt},
+	{"ignore-interrupts", "file-system:ignore-interrupts", kindBool},
+	{"disable-parallel-dirops", "file-system:disable-parallel-dirops", kindBool},
+	{"temp-dir", "file-system:temp-dir", kindString},
+	{"precondition-errors", "file-system:precondition-errors", kindBool},
+	{"billing-project", "gcs-connection:billing-project", kindString},
+	{"client-protocol", "gcs-connection:client-protocol", kindString},
+	{"custom-endpoint", "gcs-connection:custom-endpoint", kindString},
+	{"http-client-timeout", "gcs-connection:http-client-timeout", kindDuration},
+	{"limit-bytes-per-sec", "gcs-connection:limit-bytes-per-sec", kindFloat},
+	{"limit-ops-per-sec", "gcs-connection:limit-ops-per-sec", kindFloat},
+	{"max-conns-per-host", "gcs-connection:max-conns-per-host", kindInt},
+	{"max-idle-conns-per-host", "gcs-connection:max-idle-conns-per-host", kindInt},
+	{"sequential-read-size-mb", "gcs-connection:sequential-read-size-mb", kindInt},
+	{"experimental-enable-json-read", "gcs-connection:experimental-enable-json-read", kindBool},
+	{"key-file", "gcs-auth:key-file", kindString},
+	{"token-url", "gcs-auth:token-url", kindString},
+	{"reuse-token-from-url", "gcs-auth:reuse-token-from-url", kindBool},
+	{"anonymous-access", "gcs-auth:anonymous-access", kindBool},
+	{"max-retry-sleep", "gcs-retries:max-retry-sleep", kindDuration},
+	{"retry-multiplier", "gcs-retries:multiplier", kindFloat},
+	{"max-retry-attempts", "gcs-retries:max-retry-attempts", kindInt},
+	{"stat-cache-max-size-mb", "metadata-cache:stat-cache-max-size-mb", kindInt},
+	{"type-cache-max-size-mb", "metadata-cache:type-cache-max-size-mb", kindInt},
+	{"metadata-cache-ttl-secs", "metadata-cache:ttl-secs", kindInt},
+	{"metadata-cache-negative-ttl-secs", "metadata-cache:negative-ttl-secs", kindInt},
+	{"enable-nonexistent-type-cache", "metadata-cache:enable-nonexistent-type-cache", kindBool},
+	{"file-cache-max-size-mb", "file-cache:max-size-mb", kindInt},
+	{"file-cache-cache-file-for-range-read", "file-cache:cache-file-for-range-read", kindBool},
+	{"file-cache-enable-parallel-downloads", "file-cache:enable-parallel-downloads", kindBool},
+	{"file-cache-parallel-downloads-per-file", "file-cache:parallel-downloads-per-file", kindInt},
+	{"file-cache-max-parallel-downloads", "file-cache:max-parallel-downloads", kindInt},
+	{"file-cache-download-chunk-size-mb", "file-cache:download-chunk-size-mb", kindInt},
+	{"log-file", "logging:file-path", kindString},
+	{"log-format", "logging:format", kindString},
+	{"log-severity", "logging:severity", kindString},
+	{"log-rotate-max-file-size-mb", "logging:log-rotate:max-file-size-mb", kindInt},
+	{"log-rotate-backup-file-count", "logging:log-rotate:backup-file-count", kindInt},
+	{"log-rotate-compress", "logging:log-rotate:compress", kindBool},
+	{"debug_invariants", "debug:exit-on-invariant-violation", kindBool},
+	{"debug_mutex", "debug:log-mutex", kindBool},
+	{"enable-streaming-writes", "write:enable-streaming-writes", kindBool},
+	{"write-global-max-blocks", "write:global-max-blocks", kindInt},
+	{"prometheus-port", "metrics:prometheus-port", kindInt},
+	{"cloud-metrics-export-interval-secs", "metrics:cloud-metrics-export-interval-secs", kindInt},
+	{"stackdriver-export-interval", "", kindDuration},
+	{"experimental-stackdriver-export-interval", "", kindDuration},
+	{"stat-cache-capacity", "", kindInt},
+	{"stat-cache-ttl", "", kindDuration},
+	{"type-cache-ttl", "", kindDuration},
+	{"debug_fuse", "", kindBool},
+	{"debug_fuse_errors", "", kindBool},
+	{"debug_gcs", "", kindBool},
+	{"debug_http", "", kindBool},
+	{"enable-storage-client-library", "", kindBool},
+	{"max-retry-duration", "", kindDuration},
+}
+
+// canonical returns the name options are tracked by: the config key when the
+// option has one, otherwise the flag name. Unknown names are returned as is.
+func canonical(name string) string {
+	name = strings.TrimLeft(name, "-")
+	for _, o := range options {
+		if o.Flag == name && o.Key != "" {
+			return o.Key
+		}
+	}
+	return name
+}
+
+// lookup returns the option tracked by the canonical name key.
+func lookup(key string) (option, bool) {
+	for _, o := range options {
+		if o.Key == key || (o.Key == "" && o.Flag == key) {
+			return o, true
+		}
+	}
+	return option{}, false
+}
+
+// flagName returns the command-line flag of key. Unknown config keys follow
+// gcsfuse's convention of joining the sections with dashes.
+func flagName(key string) string {
+	if o, ok := lookup(key); ok {
+		return o.Flag
+	}
+	return strings.ReplaceAll(key, ":", "-")
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule kinds.
+const (
+	// Renamed options move to Replacement, converting the value.
+	Renamed = "renamed"
+	// Removed options are dropped; Replacement, if any, has to be set by hand.
+	Removed = "removed"
+	// DefaultChanged options behave differently when left unset.
+	DefaultChanged = "default-changed"
+)
+
+// Rule is one change of a gcsfuse option in a release.
+type Rule struct {
+	// Version is the gcsfuse release the change shipped in.
+	Version string `yaml:"version" json:"version"`
+	Kind    string `yaml:"kind" json:"kind"`
+	// Option is a flag name or config key ("metadata-cache:ttl-secs").
+	Option      string `yaml:"option" json:"option"`
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	// Convert names the value conversion of a rename, see converters.
+	Convert    string `yaml:"convert,omitempty" json:"convert,omitempty"`
+	OldDefault string `yaml:"old_default,omitempty" json:"old_default,omitempty"`
+	NewDefault string `yaml:"new_default,omitempty" json:"new_default,omitempty"`
+	Note       string `yaml:"note,omitempty" json:"note,omitempty"`
+}
+
+// DefaultRules are the option changes known to the advisor. LoadRules adds
+// to or overrides them for releases it doesn't cover.
+var DefaultRules = []Rule{
+	{Version: "1.0.0", Kind: Removed, Option: "max-retry-duration", Replacement: "gcs-retries:max-retry-sleep",
+		Note: "had no effect; retries are bounded by max-retry-sleep"},
+	{Version: "1.0.0", Kind: Removed, Option: "debug_fuse_errors", Note: "FUSE errors are always logged"},
+	{Version: "1.0.0", Kind: Renamed, Option: "experimental-stackdriver-export-interval", Replacement: "stackdriver-export-interval"},
+	{Version: "2.0.0", Kind: Renamed, Option: "stat-cache-ttl", Replacement: "metadata-cache:ttl-secs", Convert: "duration-to-secs",
+		Note: "one TTL now covers the stat and type caches"},
+	{Version: "2.0.0", Kind: Renamed, Option: "type-cache-ttl", Replacement: "metadata-cache:ttl-secs", Convert: "duration-to-secs",
+		Note: "one TTL now covers the stat and type caches"},
+	{Version: "2.0.0", Kind: Removed, Option: "stat-cache-capacity", Replacement: "metadata-cache:stat-cache-max-size-mb",
+		Note: "the stat cache is now sized in MiB, not entries"},
+	{Version: "2.0.0", Kind: Renamed, Option: "debug_fuse", Replacement: "logging:severity", Convert: "bool-to-trace"},
+	{Version: "2.0.0", Kind: Renamed, Option: "debug_gcs", Replacement: "logging:severity", Convert: "bool-to-trace"},
+	{Version: "2.0.0", Kind: Renamed, Option: "debug_http", Replacement: "logging:severity", Convert: "bool-to-trace"},
+	{Version: "2.0.0", Kind: Removed, Option: "enable-storage-client-library",
+		Note: "the Go storage client library is always used"},
+	{Version: "2.3.0", Kind: Renamed, Option: "stackdriver-export-interval", Replacement: "metrics:cloud-metrics-export-interval-secs", Convert: "duration-to-secs"},
+	{Version: "2.4.0", Kind: DefaultChanged, Option: "metadata-cache:negative-ttl-secs", OldDefault: "0", NewDefault: "5",
+		Note: "lookups of missing objects are cached"},
+	{Version: "3.0.0", Kind: DefaultChanged, Option: "write:enable-streaming-writes", OldDefault: "false", NewDefault: "true",
+		Note: "new files are uploaded while they are written instead of on close"},
+}
+
+// converters convert the value of a renamed option. A false ok drops the
+// option, e.g. a debug flag that was turned off.
+var converters = map[string]func(string) (v string, ok bool, err error){
+	"duration-to-secs": func(s string) (string, bool, error) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return "", false, err
+		}
+		return strconv.FormatInt(int64(d/time.Second), 10), true, nil
+	},
+	"bool-to-trace": func(s string) (string, bool, error) {
+		on, err := strconv.ParseBool(s)
+		if err != nil {
+			return "", false, err
+		}
+		return "trace", on, nil
+	},
+}
+
+// LoadRules reads a YAML list of rules and appends them to DefaultRules. A
+// rule for the same version, kind and option replaces the built-in one.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var extra []Rule
+	if err := yaml.Unmarshal(b, &extra); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	rules := append([]Rule(nil), DefaultRules...)
+	for _, r := range extra {
+		if _, err := parseVersion(r.Version); err != nil {
+			return nil, fmt.Errorf("%s: rule for %s: %w", path, r.Option, err)
+		}
+		switch r.Kind {
+		case Renamed, Removed, DefaultChanged:
+		default:
+			return nil, fmt.Errorf("%s: rule for %s: unknown kind %q", path, r.Option, r.Kind)
+		}
+		if _, ok := converters[r.Convert]; r.Convert != "" && !ok {
+			return nil, fmt.Errorf("%s: rule for %s: unknown convert %q", path, r.Option, r.Convert)
+		}
+		replaced := false
+		for i, d := range rules {
+			if d.Version == r.Version && d.Kind == r.Kind && canonical(d.Option) == canonical(r.Option) {
+				rules[i], replaced = r, true
+			}
+		}
+		if !replaced {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// parseVersion parses "v2.4.0", "2.4" or "3" into major, minor and patch.
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if s == "" || len(parts) > 3 {
+		return v, fmt.Errorf("invalid gcsfuse version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid gcsfuse version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
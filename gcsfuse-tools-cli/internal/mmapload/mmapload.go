@@ -0,0 +1,406 @@
+// Package mmapload benchmarks loading a safetensors-style checkpoint from a
+// gcsfuse mount, comparing page-fault-driven mmap loading with explicit
+// read() loading.
+package mmapload
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Loading modes.
+const (
+	// ModeMmap maps the file and touches every page of every tensor, as
+	// safetensors' safe_open and most mmap-based loaders do.
+	ModeMmap = "mmap"
+	// ModeRead reads every tensor into memory with pread.
+	ModeRead = "read"
+)
+
+// Tensor orders.
+const (
+	// OrderModel consumes tensors in module order, with layer numbers compared
+	// numerically. Checkpoints are written sorted by name, so layer 10 comes
+	// before layer 2 in the file and model order jumps around it.
+	OrderModel = "model"
+	// OrderFile consumes tensors by file offset.
+	OrderFile = "file"
+	// OrderRandom consumes tensors in a random order.
+	OrderRandom = "random"
+)
+
+// pageSize is the stride at which mmap mode touches tensors.
+const pageSize = 4096
+
+// readChunk bounds a single pread of read mode.
+const readChunk = 16 << 20
+
+// Config holds the options of a checkpoint loading run.
+type Config struct {
+	// Path is the checkpoint file on the mount.
+	Path string
+	// CreateSize, when positive and Path does not exist, writes a synthetic
+	// checkpoint of about this many bytes split into Tensors tensors.
+	CreateSize int64
+	Tensors    int
+	Modes      []string
+	Order      string
+	// Madvise is the advice given to the mapping: normal, sequential, random
+	// or willneed.
+	Madvise string
+	// DropCache evicts the file from the page cache before every mode, so
+	// the second mode does not read what the first one cached.
+	DropCache bool
+	// Seed drives OrderRandom.
+	Seed uint64
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	if c.Path == "" {
+		return errors.New("a checkpoint path is required")
+	}
+	for _, m := range c.Modes {
+		if m != ModeMmap && m != ModeRead {
+			return fmt.Errorf("invalid mode %q (want %s or %s)", m, ModeMmap, ModeRead)
+		}
+	}
+	switch c.Order {
+	case OrderModel, OrderFile, OrderRandom:
+	default:
+		return fmt.Errorf("invalid --order %q (want model, file or random)", c.Order)
+	}
+	if _, ok := madvice[c.Madvise]; !ok {
+		return fmt.Errorf("invalid --madvise %q (want normal, sequential, random or willneed)", c.Madvise)
+	}
+	return nil
+}
+
+var madvice = map[string]int{
+	"normal":     syscall.MADV_NORMAL,
+	"sequential": syscall.MADV_SEQUENTIAL,
+	"random":     syscall.MADV_RANDOM,
+	"willneed":   syscall.MADV_WILLNEED,
+}
+
+// Tensor is one entry of the checkpoint header. Begin and End are absolute
+// file offsets.
+type Tensor struct {
+	Name       string
+	Begin, End int64
+}
+
+// ModeResult is the timing of one loading mode.
+type ModeResult struct {
+	Mode string `json:"mode"`
+	// HeaderSeconds is the time to open the file and parse its header.
+	HeaderSeconds float64 `json:"header_seconds"`
+	// FirstTensorSeconds is the time until the first tensor in consumption
+	// order was fully resident.
+	FirstTensorSeconds float64 `json:"first_tensor_seconds"`
+	// TotalSeconds is the time until every tensor was resident: the
+	// time-to-first-token equivalent, since a forward pass needs all weights.
+	TotalSeconds float64 `json:"total_seconds"`
+	MiBps        float64 `json:"mibps"`
+	MajorFaults  int64   `json:"major_faults"`
+	MinorFaults  int64   `json:"minor_faults"`
+}
+
+// Result is the outcome of a loading run.
+type Result struct {
+	Path      string               `json:"path"`
+	Size      int64                `json:"size"`
+	Tensors   int                  `json:"tensors"`
+	Order     string               `json:"order"`
+	Madvise   string               `json:"madvise"`
+	DropCache bool                 `json:"drop_cache"`
+	Modes     []ModeResult         `json:"modes"`
+	Env       *envinfo.Fingerprint `json:"env"`
+}
+
+// Run loads the checkpoint once per mode and times each load.
+func Run(cfg Config) (*Result, error) {
+	if _, err := os.Stat(cfg.Path); errors.Is(err, os.ErrNotExist) && cfg.CreateSize > 0 {
+		if err := Create(cfg.Path, cfg.CreateSize, cfg.Tensors); err != nil {
+			return nil, err
+		}
+	}
+	fi, err := os.Stat(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Path: cfg.Path, Size: fi.Size(), Order: cfg.Order, Madvise: cfg.Madvise, DropCache: cfg.DropCache}
+	for _, mode := range cfg.Modes {
+		if cfg.DropCache {
+			if err := dropCache(cfg.Path); err != nil {
+				slog.Warn("Could not evict the checkpoint from the page cache", "err", err)
+			}
+		}
+		slog.Info("Loading checkpoint", "mode", mode, "path", cfg.Path, "order", cfg.Order)
+		mr, n, err := load(cfg, mode)
+		if err != nil {
+			return nil, fmt.Errorf("%s load: %w", mode, err)
+		}
+		res.Tensors = n
+		res.Modes = append(res.Modes, *mr)
+	}
+	return res, nil
+}
+
+// load runs one mode and returns its timings and the number of tensors.
+func load(cfg Config, mode string) (*ModeResult, int, error) {
+	before := rusage()
+	start := time.Now()
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	tensors, err := ReadHeader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	order(tensors, cfg.Order, cfg.Seed)
+	mr := &ModeResult{Mode: mode, HeaderSeconds: time.Since(start).Seconds()}
+
+	var total int64
+	var sum byte
+	markFirst := func() {
+		if mr.FirstTensorSeconds == 0 {
+			mr.FirstTensorSeconds = time.Since(start).Seconds()
+		}
+	}
+	switch mode {
+	case ModeMmap:
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, 0, fmt.Errorf("mmap: %w", err)
+		}
+		defer syscall.Munmap(data)
+		if err := syscall.Madvise(data, madvice[cfg.Madvise]); err != nil {
+			return nil, 0, fmt.Errorf("madvise: %w", err)
+		}
+		for _, t := range tensors {
+			for off := t.Begin; off < t.End; off += pageSize {
+				sum += data[off]
+			}
+			if t.End > t.Begin {
+				sum += data[t.End-1]
+			}
+			total += t.End - t.Begin
+			markFirst()
+		}
+	case ModeRead:
+		buf := make([]byte, readChunk)
+		for _, t := range tensors {
+			for off := t.Begin; off < t.End; {
+				n, err := f.ReadAt(buf[:min(int64(readChunk), t.End-off)], off)
+				if n == 0 && err != nil {
+					return nil, 0, fmt.Errorf("reading %s: %w", t.Name, err)
+				}
+				sum += buf[0]
+				off += int64(n)
+			}
+			total += t.End - t.Begin
+			markFirst()
+		}
+	}
+	elapsed := time.Since(start)
+	after := rusage()
+
+	mr.TotalSeconds = elapsed.Seconds()
+	mr.MiBps = float64(total) / (1 << 20) / elapsed.Seconds()
+	mr.MajorFaults = after.Majflt - before.Majflt
+	mr.MinorFaults = after.Minflt - before.Minflt
+	slog.Debug("Loaded checkpoint", "mode", mode, "checksum", sum)
+	return mr, len(tensors), nil
+}
+
+func rusage() syscall.Rusage {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return ru
+}
+
+// dropCache asks the kernel to evict the file's pages from the page cache.
+func dropCache(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	const fadvDontneed = 4
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontneed, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ReadHeader parses the safetensors header of r: a little-endian uint64
+// length followed by a JSON object of tensor entries with data offsets
+// relative to the end of the header.
+func ReadHeader(r io.ReaderAt) ([]Tensor, error) {
+	var lenBuf [8]byte
+	if _, err := r.ReadAt(lenBuf[:], 0); err != nil {
+		return nil, fmt.Errorf("reading header length: %w", err)
+	}
+	n := binary.LittleEndian.Uint64(lenBuf[:])
+	if n == 0 || n > 100<<20 {
+		return nil, fmt.Errorf("implausible header length %d: not a safetensors file", n)
+	}
+	hdr := make([]byte, n)
+	if _, err := r.ReadAt(hdr, 8); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(hdr, &entries); err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+	base := 8 + int64(n)
+	var tensors []Tensor
+	for name, raw := range entries {
+		if name == "__metadata__" {
+			continue
+		}
+		var e struct {
+			DataOffsets [2]int64 `json:"data_offsets"`
+		}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, fmt.Errorf("parsing tensor %s: %w", name, err)
+		}
+		tensors = append(tensors, Tensor{Name: name, Begin: base + e.DataOffsets[0], End: base + e.DataOffsets[1]})
+	}
+	return tensors, nil
+}
+
+var digits = regexp.MustCompile(`\d+|\D+`)
+
+// naturalLess compares names with digit runs compared as numbers, so that
+// layers.2 sorts before layers.10.
+func naturalLess(a, b string) bool {
+	pa, pb := digits.FindAllString(a, -1), digits.FindAllString(b, -1)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		na, ea := strconv.Atoi(pa[i])
+		nb, eb := strconv.Atoi(pb[i])
+		if ea == nil && eb == nil {
+			return na < nb
+		}
+		return pa[i] < pb[i]
+	}
+	return len(pa) < len(pb)
+}
+
+func order(tensors []Tensor, by string, seed uint64) {
+	switch by {
+	case OrderFile:
+		sort.Slice(tensors, func(i, j int) bool { return tensors[i].Begin < tensors[j].Begin })
+	case OrderRandom:
+		sort.Slice(tensors, func(i, j int) bool { return tensors[i].Name < tensors[j].Name })
+		rng := rand.New(rand.NewPCG(seed, 0))
+		rng.Shuffle(len(tensors), func(i, j int) { tensors[i], tensors[j] = tensors[j], tensors[i] })
+	default:
+		sort.Slice(tensors, func(i, j int) bool { return naturalLess(tensors[i].Name, tensors[j].Name) })
+	}
+}
+
+// Create writes a synthetic checkpoint of about size bytes: an embedding,
+// n-2 layer weights and an output head, stored sorted by name as safetensors
+// writers do.
+func Create(path string, size int64, n int) error {
+	n = max(n, 3)
+	names := []string{"lm_head.weight", "model.embed_tokens.weight"}
+	for i := 0; i < n-2; i++ {
+		names = append(names, fmt.Sprintf("model.layers.%d.weight", i))
+	}
+	sort.Strings(names)
+
+	per := size / int64(n) / pageSize * pageSize
+	header := map[string]any{"__metadata__": map[string]string{"format": "pt", "generator": "gcsfuse-tools"}}
+	for i, name := range names {
+		header[name] = map[string]any{
+			"dtype":        "BF16",
+			"shape":        []int64{per / 2},
+			"data_offsets": []int64{int64(i) * per, int64(i+1) * per},
+		}
+	}
+	hdr, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	// Pad the header so tensor data starts page aligned.
+	if pad := (8 + len(hdr)) % pageSize; pad != 0 {
+		hdr = append(hdr, []byte(strings.Repeat(" ", pageSize-pad))...)
+	}
+
+	slog.Info("Creating synthetic checkpoint", "path", path, "size", per*int64(n), "tensors", n)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var lenBuf [8]byte
+	binary.LittleEndian.PutUint64(lenBuf[:], uint64(len(hdr)))
+	if _, err := f.Write(append(lenBuf[:], hdr...)); err != nil {
+		f.Close()
+		return err
+	}
+	buf := make([]byte, readChunk)
+	for left := per * int64(n); left > 0; {
+		k, err := f.Write(buf[:min(int64(len(buf)), left)])
+		if err != nil {
+			f.Close()
+			return err
+		}
+		left -= int64(k)
+	}
+	return f.Close()
+}
+
+// WriteText prints one row per mode and the ratio of the load times.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%s: %.1f MiB in %d tensors, %s order, madvise %s\n\n", r.Path, float64(r.Size)/(1<<20), r.Tensors, r.Order, r.Madvise)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODE\tHEADER (ms)\tFIRST TENSOR (ms)\tTTFT-EQUIV (s)\tMiB/s\tMAJOR FAULTS\tMINOR FAULTS")
+	for _, m := range r.Modes {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.2f\t%.1f\t%d\t%d\n", m.Mode, m.HeaderSeconds*1e3, m.FirstTensorSeconds*1e3,
+			m.TotalSeconds, m.MiBps, m.MajorFaults, m.MinorFaults)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	var mm, rd *ModeResult
+	for i := range r.Modes {
+		switch r.Modes[i].Mode {
+		case ModeMmap:
+			mm = &r.Modes[i]
+		case ModeRead:
+			rd = &r.Modes[i]
+		}
+	}
+	if mm != nil && rd != nil && rd.TotalSeconds > 0 {
+		_, err := fmt.Fprintf(w, "\nmmap loading took %.2fx the time of read() loading.\n", mm.TotalSeconds/rd.TotalSeconds)
+		return err
+	}
+	return nil
+}
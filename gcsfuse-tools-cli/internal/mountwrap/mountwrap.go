@@ -0,0 +1,327 @@
+// Package mountwrap runs gcsfuse on behalf of benchmark harnesses and
+// records the provenance of the mount: the exact command line, the hashes
+// of the binary and the config file, the environment gcsfuse saw and when
+// the mount came up and went away.
+package mountwrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// envPrefixes are the environment variables recorded; the rest of the
+// environment does not affect gcsfuse.
+var envPrefixes = []string{"GCSFUSE_", "GOOGLE_", "CLOUDSDK_", "GODEBUG", "GOGC", "GOMAXPROCS", "GOMEMLIMIT", "GOTRACEBACK",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy", "STORAGE_EMULATOR_HOST", "TMPDIR"}
+
+// secretWords mark variables whose values are replaced by their hash.
+var secretWords = []string{"TOKEN", "SECRET", "PASSWORD", "KEY"}
+
+// Config holds the options of a wrapped mount.
+type Config struct {
+	// GcsfuseBinary is run with Args, the gcsfuse flags followed by the
+	// bucket and the mount point. --foreground is added if missing, so
+	// that the wrapper sees gcsfuse exit.
+	GcsfuseBinary string
+	Args          []string
+	// RunID identifies the run the mount belongs to.
+	RunID string
+	// MountTimeout bounds the wait for the mount to appear.
+	MountTimeout time.Duration
+	// OnMounted, if set, is called with the provenance once the mount is
+	// up, e.g. to store it before the run.
+	OnMounted func(*Provenance) error
+}
+
+// Validate reports missing options.
+func (c *Config) Validate() error {
+	if len(c.Args) == 0 {
+		return errors.New("the gcsfuse arguments are required after --, ending with the mount point")
+	}
+	if c.MountTimeout <= 0 {
+		return errors.New("--mount-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// Provenance describes exactly what was mounted for a run.
+type Provenance struct {
+	RunID    string `json:"run_id"`
+	Hostname string `json:"hostname"`
+	// Binary is the resolved path of the gcsfuse binary and BinarySHA256
+	// the hash of its content.
+	Binary         string `json:"binary"`
+	BinarySHA256   string `json:"binary_sha256"`
+	GcsfuseVersion string `json:"gcsfuse_version,omitempty"`
+	// Args are the arguments gcsfuse ran with and CommandLine the same as
+	// one shell-quoted line.
+	Args        []string `json:"args"`
+	CommandLine string   `json:"command_line"`
+	Bucket      string   `json:"bucket,omitempty"`
+	MountPoint  string   `json:"mount_point"`
+	// ConfigFile is the --config-file of the mount, with the hash of its
+	// content and the content itself.
+	ConfigFile    string `json:"config_file,omitempty"`
+	ConfigSHA256  string `json:"config_sha256,omitempty"`
+	ConfigContent string `json:"config_content,omitempty"`
+	// Environ holds the variables of the environment that affect gcsfuse.
+	// The values of those named like secrets are replaced by their hash.
+	Environ map[string]string `json:"environ"`
+	// Start is when gcsfuse was started, Mounted when the mount appeared
+	// and Stop when gcsfuse exited, with ExitCode.
+	Start    time.Time  `json:"start"`
+	Mounted  *time.Time `json:"mounted,omitempty"`
+	Stop     *time.Time `json:"stop,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Signal   string     `json:"signal,omitempty"`
+	// Error is why the mount failed, if it did.
+	Error string               `json:"error,omitempty"`
+	Env   *envinfo.Fingerprint `json:"env,omitempty"`
+}
+
+// Run starts gcsfuse, waits for the mount, calls cfg.OnMounted and then
+// waits until gcsfuse exits, normally after the mount point was unmounted.
+// SIGINT and SIGTERM are passed on to gcsfuse. The provenance is returned
+// whenever gcsfuse was started, also with an error.
+func Run(ctx context.Context, cfg Config) (*Provenance, error) {
+	binary, err := exec.LookPath(cfg.GcsfuseBinary)
+	if err != nil {
+		return nil, fmt.Errorf("finding %s: %w", cfg.GcsfuseBinary, err)
+	}
+	if binary, err = filepath.Abs(binary); err != nil {
+		return nil, err
+	}
+	args := cfg.Args
+	if !slices.ContainsFunc(args, func(a string) bool { return a == "--foreground" || a == "--foreground=true" }) {
+		args = append([]string{"--foreground"}, args...)
+	}
+	p := &Provenance{
+		RunID:      cfg.RunID,
+		Binary:     binary,
+		Args:       args,
+		MountPoint: args[len(args)-1],
+		Environ:    environ(os.Environ()),
+	}
+	p.Hostname, _ = os.Hostname()
+	p.CommandLine = shellQuote(append([]string{binary}, args...))
+	if len(args) >= 2 && !strings.HasPrefix(args[len(args)-2], "-") {
+		p.Bucket = args[len(args)-2]
+	}
+	if p.BinarySHA256, err = fileSHA256(binary); err != nil {
+		return nil, err
+	}
+	if p.ConfigFile = configFile(args); p.ConfigFile != "" {
+		b, err := os.ReadFile(p.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the gcsfuse config file: %w", err)
+		}
+		sum := sha256.Sum256(b)
+		p.ConfigSHA256, p.ConfigContent = hex.EncodeToString(sum[:]), string(b)
+	}
+
+	slog.Info("Starting gcsfuse", "run_id", p.RunID, "command", p.CommandLine)
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	p.Start = time.Now().UTC()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting gcsfuse: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// Wait for the mount; an interrupted harness stops gcsfuse, which
+	// unmounts on SIGTERM.
+	var waitErr error
+	done := false
+	mounted := time.NewTicker(100 * time.Millisecond)
+	defer mounted.Stop()
+	deadline := time.After(cfg.MountTimeout)
+	for !done && p.Mounted == nil {
+		select {
+		case waitErr = <-exited:
+			done = true
+		case <-ctx.Done():
+			cmd.Process.Signal(syscall.SIGTERM)
+			waitErr, done = <-exited, true
+		case <-deadline:
+			cmd.Process.Signal(syscall.SIGTERM)
+			<-exited
+			p.finish(cmd, fmt.Errorf("%s was not mounted within %s", p.MountPoint, cfg.MountTimeout))
+			return p, errors.New(p.Error)
+		case <-mounted.C:
+			if isMounted(p.MountPoint) {
+				t := time.Now().UTC()
+				p.Mounted = &t
+			}
+		}
+	}
+	if p.Mounted != nil {
+		slog.Info("Mounted", "mount_point", p.MountPoint, "after", p.Mounted.Sub(p.Start).Round(time.Millisecond))
+		p.Env = envinfo.Capture(ctx, envinfo.Options{GcsfuseBinary: binary, MountPoint: p.MountPoint})
+		p.GcsfuseVersion = p.Env.GcsfuseVersion
+		if cfg.OnMounted != nil {
+			if err := cfg.OnMounted(p); err != nil {
+				slog.Warn("Recording the mount failed", "err", err)
+			}
+		}
+		select {
+		case waitErr = <-exited:
+		case <-ctx.Done():
+			slog.Info("Stopping gcsfuse", "mount_point", p.MountPoint)
+			cmd.Process.Signal(syscall.SIGTERM)
+			waitErr = <-exited
+		}
+	}
+	if waitErr == nil && p.Mounted == nil {
+		waitErr = errors.New("gcsfuse exited before the mount appeared")
+	}
+	p.finish(cmd, waitErr)
+	if waitErr != nil {
+		return p, fmt.Errorf("gcsfuse: %w", waitErr)
+	}
+	return p, nil
+}
+
+// finish records the exit of cmd and err.
+func (p *Provenance) finish(cmd *exec.Cmd, err error) {
+	t := time.Now().UTC()
+	p.Stop = &t
+	if st := cmd.ProcessState; st != nil {
+		code := st.ExitCode()
+		p.ExitCode = &code
+		if ws, ok := st.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			p.Signal = ws.Signal().String()
+		}
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+}
+
+// isMounted reports whether a FUSE file system is mounted at dir.
+func isMounted(dir string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	b, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 3 && f[1] == dir && strings.HasPrefix(f[2], "fuse") {
+			return true
+		}
+	}
+	return false
+}
+
+// configFile returns the --config-file argument of args, if any.
+func configFile(args []string) string {
+	for i, a := range args {
+		for _, flag := range []string{"--config-file", "-config-file"} {
+			if v, ok := strings.CutPrefix(a, flag+"="); ok {
+				return v
+			}
+			if a == flag && i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+	}
+	return ""
+}
+
+// environ returns the variables of env that affect gcsfuse, with the values
+// of secrets hashed.
+func environ(env []string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if !slices.ContainsFunc(envPrefixes, func(p string) bool { return strings.HasPrefix(k, p) }) {
+			continue
+		}
+		if slices.ContainsFunc(secretWords, func(w string) bool { return strings.Contains(strings.ToUpper(k), w) }) {
+			sum := sha256.Sum256([]byte(v))
+			v = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// shellQuote joins args into a line a POSIX shell splits back into args.
+func shellQuote(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,@+%") == "" {
+			q[i] = a
+		} else {
+			q[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(q, " ")
+}
+
+// WriteText prints the provenance as key/value lines.
+func (p *Provenance) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", k, v)
+		}
+	}
+	row("Run", p.RunID)
+	row("Host", p.Hostname)
+	row("Command", p.CommandLine)
+	row("Binary sha256", p.BinarySHA256)
+	row("gcsfuse version", p.GcsfuseVersion)
+	if p.ConfigFile != "" {
+		row("Config file", p.ConfigFile+" (sha256 "+p.ConfigSHA256+")")
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Environ)) {
+		row("Env "+k, p.Environ[k])
+	}
+	row("Started", p.Start.Format(time.RFC3339Nano))
+	if p.Mounted != nil {
+		row("Mounted", p.Mounted.Format(time.RFC3339Nano))
+	}
+	if p.Stop != nil {
+		row("Stopped", p.Stop.Format(time.RFC3339Nano))
+	}
+	if p.ExitCode != nil {
+		row("Exit code", fmt.Sprint(*p.ExitCode))
+	}
+	row("Signal", p.Signal)
+	row("Error", p.Error)
+	return tw.Flush()
+}
@@ -0,0 +1,278 @@
+// Package netaccount samples the TCP counters of the gcsfuse process during a
+// benchmark and compares the bytes on the wire with the goodput the workload
+// saw, exposing retries, retransmissions and protocol overhead.
+package netaccount
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Config holds the net-account options.
+type Config struct {
+	// MountPoint finds the gcsfuse process at every sample, so mounts made
+	// by the benchmark itself are followed. PID pins one process instead.
+	MountPoint string
+	PID        int
+	Interval   time.Duration
+	// Duration stops sampling when no Command runs; 0 samples until the
+	// context is cancelled.
+	Duration time.Duration
+	// Command, when set, is the benchmark to run; sampling stops when it
+	// exits.
+	Command []string
+	// SS is the path of the iproute2 ss binary.
+	SS string
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	if c.MountPoint == "" && c.PID == 0 {
+		return errors.New("--mount-point or --pid is required")
+	}
+	if c.Interval <= 0 {
+		return errors.New("--interval must be greater than 0")
+	}
+	return nil
+}
+
+// Report is the network accounting of one run.
+type Report struct {
+	MountPoint string    `json:"mount_point,omitempty"`
+	PIDs       []int     `json:"pids"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Samples    int       `json:"samples"`
+	// Connections is the number of distinct TCP connections seen.
+	Connections int `json:"connections"`
+	// WireReceived and WireSent are the TCP payload bytes received and sent,
+	// including TLS, HTTP and retried requests. Retransmitted bytes are
+	// counted once more in Retransmitted.
+	WireReceived  int64 `json:"wire_received_bytes"`
+	WireSent      int64 `json:"wire_sent_bytes"`
+	Retransmitted int64 `json:"retransmitted_bytes"`
+	// Goodput is the data the workload read and wrote, when known, and
+	// Overhead the ratio of wire bytes to it.
+	Goodput  int64   `json:"goodput_bytes,omitempty"`
+	Overhead float64 `json:"overhead_ratio,omitempty"`
+}
+
+// SetGoodput records the workload's bytes and derives the overhead ratio.
+func (r *Report) SetGoodput(n int64) {
+	r.Goodput = n
+	if n > 0 {
+		r.Overhead = float64(r.WireReceived+r.WireSent) / float64(n)
+	}
+}
+
+// counters are the cumulative byte counters of one connection.
+type counters struct {
+	received, sent, retrans int64
+}
+
+// conn identifies a connection of a process.
+type conn struct {
+	pid           int
+	local, remote string
+}
+
+// Run samples the gcsfuse connections every cfg.Interval, around cfg.Command
+// when set. Connections that close between two samples lose the bytes moved
+// since the last one, so keep the interval short.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.SS == "" {
+		cfg.SS = "ss"
+	}
+	r := &Report{MountPoint: cfg.MountPoint, Start: time.Now()}
+	acc := &accountant{baseline: map[conn]counters{}, last: map[conn]counters{}, pids: map[int]bool{}}
+
+	// Counters of connections open before the run are not part of it.
+	if err := acc.sample(ctx, cfg, true); err != nil {
+		return nil, err
+	}
+	r.Samples++
+
+	done := make(chan error, 1)
+	if len(cfg.Command) > 0 {
+		cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("starting %s: %w", cfg.Command[0], err)
+		}
+		go func() { done <- cmd.Wait() }()
+	} else if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	var cmdErr error
+loop:
+	for {
+		select {
+		case cmdErr = <-done:
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		if err := acc.sample(ctx, cfg, false); err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		r.Samples++
+	}
+	// A last sample catches what moved since the previous tick.
+	if err := acc.sample(context.WithoutCancel(ctx), cfg, false); err == nil {
+		r.Samples++
+	}
+	r.End = time.Now()
+	acc.fill(r)
+	if cmdErr != nil {
+		return r, fmt.Errorf("%s: %w", cfg.Command[0], cmdErr)
+	}
+	return r, nil
+}
+
+// accountant accumulates the counters of every connection seen.
+type accountant struct {
+	baseline map[conn]counters
+	last     map[conn]counters
+	pids     map[int]bool
+}
+
+func (a *accountant) sample(ctx context.Context, cfg Config, baseline bool) error {
+	pid := cfg.PID
+	if pid == 0 {
+		if pid = envinfo.GCSFusePID(cfg.MountPoint); pid == 0 {
+			// Not mounted (yet, or any more).
+			return nil
+		}
+	}
+	out, err := exec.CommandContext(ctx, cfg.SS, "-tinpH").Output()
+	if err != nil {
+		return fmt.Errorf("running %s: %w", cfg.SS, err)
+	}
+	socks, err := parseSS(bytes.NewReader(out), pid)
+	if err != nil {
+		return err
+	}
+	if !a.pids[pid] {
+		slog.Info("Accounting gcsfuse connections", "pid", pid, "connections", len(socks))
+		a.pids[pid] = true
+	}
+	for c, v := range socks {
+		if baseline {
+			a.baseline[c] = v
+		}
+		a.last[c] = v
+	}
+	return nil
+}
+
+func (a *accountant) fill(r *Report) {
+	for c, v := range a.last {
+		b := a.baseline[c]
+		if v == b {
+			continue
+		}
+		r.Connections++
+		r.WireReceived += v.received - b.received
+		r.WireSent += v.sent - b.sent
+		r.Retransmitted += v.retrans - b.retrans
+	}
+	r.PIDs = []int{}
+	for pid := range a.pids {
+		r.PIDs = append(r.PIDs, pid)
+	}
+	sort.Ints(r.PIDs)
+}
+
+var (
+	ssPID     = regexp.MustCompile(`pid=(\d+),`)
+	ssCounter = regexp.MustCompile(`\b(bytes_received|bytes_acked|bytes_retrans):(\d+)`)
+)
+
+// parseSS reads `ss -tinpH` output, where every connection is a line with
+// its addresses and owning processes followed by an indented tcp_info line,
+// and returns the counters of pid's connections. bytes_acked rather than
+// bytes_sent counts sent data, so retransmissions are not counted twice.
+func parseSS(r io.Reader, pid int) (map[conn]counters, error) {
+	out := map[conn]counters{}
+	var cur *conn
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			cur = nil
+			f := strings.Fields(line)
+			if len(f) < 5 {
+				continue
+			}
+			for _, m := range ssPID.FindAllStringSubmatch(line, -1) {
+				if p, _ := strconv.Atoi(m[1]); p == pid {
+					cur = &conn{pid: pid, local: f[3], remote: f[4]}
+				}
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		var c counters
+		for _, m := range ssCounter.FindAllStringSubmatch(line, -1) {
+			v, _ := strconv.ParseInt(m[2], 10, 64)
+			switch m[1] {
+			case "bytes_received":
+				c.received = v
+			case "bytes_acked":
+				c.sent = v
+			case "bytes_retrans":
+				c.retrans = v
+			}
+		}
+		out[*cur] = c
+		cur = nil
+	}
+	return out, sc.Err()
+}
+
+// WriteText prints the accounting as aligned key/value lines.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	secs := r.End.Sub(r.Start).Seconds()
+	mib := func(n int64) string {
+		return fmt.Sprintf("%.1f MiB (%.1f MiB/s)", float64(n)/(1<<20), float64(n)/(1<<20)/secs)
+	}
+	fmt.Fprintf(tw, "gcsfuse pids\t%s\n", strings.Trim(fmt.Sprint(r.PIDs), "[]"))
+	fmt.Fprintf(tw, "duration\t%v (%d samples)\n", r.End.Sub(r.Start).Round(time.Millisecond), r.Samples)
+	fmt.Fprintf(tw, "connections\t%d\n", r.Connections)
+	fmt.Fprintf(tw, "wire received\t%s\n", mib(r.WireReceived))
+	fmt.Fprintf(tw, "wire sent\t%s\n", mib(r.WireSent))
+	fmt.Fprintf(tw, "retransmitted\t%s\n", mib(r.Retransmitted))
+	if r.Goodput > 0 {
+		fmt.Fprintf(tw, "goodput\t%s\n", mib(r.Goodput))
+		fmt.Fprintf(tw, "overhead\t%.3fx wire bytes per goodput byte\n", r.Overhead)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,361 @@
+// Package opcount runs a workload on a gcsfuse mount, counts the Cloud
+// Storage API calls gcsfuse issued for it and checks them against a budget,
+// so that a gcsfuse change that silently doubles Class A operations fails a
+// run instead of showing up on the bill.
+package opcount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"gopkg.in/yaml.v3"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// Call sources.
+const (
+	// SourceLog counts the "gcs: Req" lines of a gcsfuse log written with
+	// --log-severity=trace.
+	SourceLog = "log"
+	// SourceAudit counts the Cloud Audit Logs Data Access entries of the
+	// bucket, which must have DATA_READ and DATA_WRITE logs enabled.
+	SourceAudit = "audit"
+)
+
+// Operation classes as billed by Cloud Storage.
+const (
+	ClassA    = "A"
+	ClassB    = "B"
+	ClassFree = "free"
+)
+
+// Classify returns the billing class of a gcsfuse method name, such as
+// "ListObjects", or of an audit log method name, such as
+// "storage.objects.list". Unknown methods are counted as Class A so that new
+// calls are never hidden by a budget.
+func Classify(method string) string {
+	m := strings.ToLower(method[strings.LastIndex(method, ".")+1:])
+	switch {
+	case strings.HasPrefix(m, "delete"):
+		return ClassFree
+	case strings.HasPrefix(m, "get"), strings.HasPrefix(m, "stat"), strings.HasPrefix(m, "read"),
+		strings.HasPrefix(m, "newreader"), strings.HasPrefix(m, "testiampermissions"):
+		return ClassB
+	default:
+		return ClassA
+	}
+}
+
+// Budget is the expected number of calls of a workload. Unset limits are not
+// checked.
+type Budget struct {
+	ClassA *int `yaml:"class_a,omitempty"`
+	ClassB *int `yaml:"class_b,omitempty"`
+	// Methods limits single methods, keyed like the counts of the source.
+	Methods map[string]int `yaml:"methods,omitempty"`
+	// Tolerance is the fraction a count may exceed its budget by.
+	Tolerance float64 `yaml:"tolerance,omitempty"`
+}
+
+// LoadBudget reads a YAML budget file.
+func LoadBudget(path string) (*Budget, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bud Budget
+	if err := yaml.Unmarshal(b, &bud); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &bud, nil
+}
+
+// BudgetFrom returns a budget equal to the counts of r, to be recorded as the
+// baseline of a workload.
+func BudgetFrom(r *Report, tolerance float64) *Budget {
+	a, b := r.ClassA, r.ClassB
+	bud := &Budget{ClassA: &a, ClassB: &b, Methods: map[string]int{}, Tolerance: tolerance}
+	for _, m := range r.Methods {
+		bud.Methods[m.Method] = m.Count
+	}
+	return bud
+}
+
+// Write stores the budget as YAML.
+func (b *Budget) Write(path string) error {
+	out, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// Config holds the options of a counted workload run.
+type Config struct {
+	// Workload is the command run on the mount, e.g. a training step or fio.
+	Workload []string
+	Source   string
+	// LogFile is the gcsfuse --log-file read with SourceLog.
+	LogFile string
+	// Project, Bucket and Principal select the audit log entries read with
+	// SourceAudit. Principal, when set, keeps only the calls of gcsfuse's
+	// identity.
+	Project   string
+	Bucket    string
+	Principal string
+	// AuditDelay is waited after the workload for audit entries to arrive.
+	AuditDelay time.Duration
+	Budget     *Budget
+}
+
+// MethodCount is the number of calls of one method.
+type MethodCount struct {
+	Method string `json:"method"`
+	Class  string `json:"class"`
+	Count  int    `json:"count"`
+}
+
+// Check is the comparison of one count against its budget.
+type Check struct {
+	Name     string `json:"name"`
+	Observed int    `json:"observed"`
+	Budget   int    `json:"budget"`
+	Limit    int    `json:"limit"`
+	Passed   bool   `json:"passed"`
+}
+
+// Report is the outcome of a counted run.
+type Report struct {
+	Source        string        `json:"source"`
+	Workload      string        `json:"workload"`
+	Start         time.Time     `json:"start"`
+	End           time.Time     `json:"end"`
+	WorkloadError string        `json:"workload_error,omitempty"`
+	Methods       []MethodCount `json:"methods"`
+	ClassA        int           `json:"class_a"`
+	ClassB        int           `json:"class_b"`
+	Free          int           `json:"free"`
+	Checks        []Check       `json:"checks,omitempty"`
+	Passed        bool          `json:"passed"`
+}
+
+// Run runs the workload, counts the calls gcsfuse issued meanwhile and checks
+// them against cfg.Budget.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if len(cfg.Workload) == 0 {
+		return nil, errors.New("no workload command")
+	}
+	r := &Report{Source: cfg.Source, Workload: strings.Join(cfg.Workload, " ")}
+
+	var offset int64
+	if cfg.Source == SourceLog {
+		// Only lines appended while the workload runs are counted.
+		fi, err := os.Stat(cfg.LogFile)
+		if err != nil {
+			return nil, fmt.Errorf("gcsfuse log: %w", err)
+		}
+		offset = fi.Size()
+	}
+
+	slog.Info("Running workload", "cmd", r.Workload, "source", cfg.Source)
+	r.Start = time.Now()
+	cmd := exec.CommandContext(ctx, cfg.Workload[0], cfg.Workload[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		r.WorkloadError = err.Error()
+		slog.Warn("Workload failed", "err", err)
+	}
+	r.End = time.Now()
+
+	var counts map[string]int
+	var err error
+	switch cfg.Source {
+	case SourceLog:
+		// gcsfuse may still be writing the last lines of the workload.
+		time.Sleep(time.Second)
+		counts, err = countLog(cfg.LogFile, offset)
+	case SourceAudit:
+		slog.Info("Waiting for audit log entries", "delay", cfg.AuditDelay)
+		select {
+		case <-time.After(cfg.AuditDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		counts, err = countAudit(ctx, cfg, r.Start, r.End)
+	default:
+		return nil, fmt.Errorf("unknown source %q (want %s or %s)", cfg.Source, SourceLog, SourceAudit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for method, n := range counts {
+		mc := MethodCount{Method: method, Class: Classify(method), Count: n}
+		r.Methods = append(r.Methods, mc)
+		switch mc.Class {
+		case ClassA:
+			r.ClassA += n
+		case ClassB:
+			r.ClassB += n
+		default:
+			r.Free += n
+		}
+	}
+	sort.Slice(r.Methods, func(i, j int) bool {
+		if r.Methods[i].Count != r.Methods[j].Count {
+			return r.Methods[i].Count > r.Methods[j].Count
+		}
+		return r.Methods[i].Method < r.Methods[j].Method
+	})
+
+	r.Passed = r.WorkloadError == ""
+	if cfg.Budget != nil {
+		r.check(cfg.Budget, counts)
+	}
+	return r, nil
+}
+
+// check compares the counts with the budget and updates r.Passed.
+func (r *Report) check(b *Budget, counts map[string]int) {
+	add := func(name string, observed, budget int) {
+		limit := int(float64(budget) * (1 + b.Tolerance))
+		c := Check{Name: name, Observed: observed, Budget: budget, Limit: limit, Passed: observed <= limit}
+		r.Checks = append(r.Checks, c)
+		r.Passed = r.Passed && c.Passed
+	}
+	if b.ClassA != nil {
+		add("class A", r.ClassA, *b.ClassA)
+	}
+	if b.ClassB != nil {
+		add("class B", r.ClassB, *b.ClassB)
+	}
+	methods := make([]string, 0, len(b.Methods))
+	for m := range b.Methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	for _, m := range methods {
+		add(m, counts[m], b.Methods[m])
+	}
+}
+
+func countLog(path string, offset int64) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("gcsfuse log: %w", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+		// The log was rotated during the run: count the new file.
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	err = gcsfuselog.Scan(f, func(e gcsfuselog.Entry) {
+		if m, ok := gcsfuselog.ParseGCSCall(e); ok {
+			counts[m]++
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading gcsfuse log: %w", err)
+	}
+	if len(counts) == 0 {
+		slog.Warn("No GCS requests found in the log; is gcsfuse running with --log-severity=trace?", "log", path)
+	}
+	return counts, nil
+}
+
+func countAudit(ctx context.Context, cfg Config, start, end time.Time) (map[string]int, error) {
+	client, err := logadmin.NewClient(ctx, cfg.Project)
+	if err != nil {
+		return nil, fmt.Errorf("creating logging client: %w", err)
+	}
+	defer client.Close()
+
+	filter := fmt.Sprintf(`logName="projects/%s/logs/cloudaudit.googleapis.com%%2Fdata_access"`+
+		` AND protoPayload.serviceName="storage.googleapis.com"`+
+		` AND resource.labels.bucket_name="%s"`+
+		` AND timestamp>="%s" AND timestamp<="%s"`,
+		cfg.Project, cfg.Bucket, start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano))
+	if cfg.Principal != "" {
+		filter += fmt.Sprintf(` AND protoPayload.authenticationInfo.principalEmail="%s"`, cfg.Principal)
+	}
+	slog.Debug("Reading audit logs", "filter", filter)
+
+	counts := map[string]int{}
+	it := client.Entries(ctx, logadmin.Filter(filter))
+	for {
+		e, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading audit logs: %w", err)
+		}
+		if m := auditMethod(e); m != "" {
+			counts[m]++
+		}
+	}
+	if len(counts) == 0 {
+		slog.Warn("No audit log entries found; are Data Access audit logs enabled for Cloud Storage?", "bucket", cfg.Bucket)
+	}
+	return counts, nil
+}
+
+// auditMethod returns the methodName of an audit log entry.
+func auditMethod(e *logging.Entry) string {
+	type auditLog interface{ GetMethodName() string }
+	if p, ok := e.Payload.(auditLog); ok {
+		return p.GetMethodName()
+	}
+	return ""
+}
+
+// WriteText prints the per-method counts and the budget checks.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Workload:\t%s\n", r.Workload)
+	fmt.Fprintf(tw, "Source:\t%s\n", r.Source)
+	fmt.Fprintf(tw, "Duration:\t%v\n", r.End.Sub(r.Start).Round(time.Millisecond))
+	if r.WorkloadError != "" {
+		fmt.Fprintf(tw, "Workload error:\t%s\n", r.WorkloadError)
+	}
+	fmt.Fprintf(tw, "Calls:\tclass A %d, class B %d, free %d\n", r.ClassA, r.ClassB, r.Free)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "METHOD\tCLASS\tCOUNT")
+	for _, m := range r.Methods {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", m.Method, m.Class, m.Count)
+	}
+	if len(r.Checks) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "CHECK\tOBSERVED\tBUDGET\tLIMIT\tSTATUS")
+		for _, c := range r.Checks {
+			status := "ok"
+			if !c.Passed {
+				status = "OVER"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", c.Name, c.Observed, c.Budget, c.Limit, status)
+		}
+	}
+	verdict := "PASS"
+	if !r.Passed {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(tw, "\n%s\n", verdict)
+	return tw.Flush()
+}
@@ -0,0 +1,385 @@
+// Package outliers attributes the slowest operations of fio runs to causes
+// found in the gcsfuse logs written meanwhile: GCS 5xx retries, throttling,
+// connection setup, file cache misses and slow GCS requests.
+package outliers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+)
+
+// Causes, in the order an outlier is attributed to them when several apply.
+const (
+	CauseRetry      = "gcs-5xx-retry"
+	CauseThrottling = "throttling"
+	CauseConnection = "connection-setup"
+	CauseCacheMiss  = "cache-miss"
+	CauseSlowGCS    = "slow-gcs-request"
+	CauseUnknown    = "unattributed"
+)
+
+var causeOrder = []string{CauseRetry, CauseThrottling, CauseConnection, CauseCacheMiss, CauseSlowGCS, CauseUnknown}
+
+var (
+	throttling = regexp.MustCompile(`(?i)(error|status|code)[ :=]*429\b|rateLimitExceeded|TooManyRequests|too many requests|ResourceExhausted`)
+	retry5xx   = regexp.MustCompile(`(?i)(error|status|code)[ :=]*50[0-4]\b|InternalError|BackendError|ServiceUnavailable|backendFailed|code = Unavailable`)
+	connection = regexp.MustCompile(`(?i)dial tcp|tls handshake|connection (reset|refused)|broken pipe|new (grpc )?connection|http2: client connection|GOAWAY`)
+	// FileCache(bucket:/dir/file, offset: 0, size: 1048576 handle: 2) -> OK (isSeq: true, hit: false) (12.5ms)
+	cacheMiss = regexp.MustCompile(`\bhit: false\b`)
+)
+
+// Config selects the runs to analyze.
+type Config struct {
+	// Runs maps run names to directories holding the fio latency logs
+	// (write_lat_log) and gcsfuse logs of a run.
+	Runs []Run
+	// Percentile is the latency percentile above which an op is an outlier.
+	Percentile float64
+	// FioStart is the wall time fio's relative log timestamps count from.
+	// When zero, logs written with log_unix_epoch=1 are used as they are and
+	// relative logs are aligned to the first gcsfuse log record.
+	FioStart time.Time
+	// Slack widens every op's time window to absorb log timestamp skew.
+	Slack time.Duration
+	// Examples is the number of slowest outliers listed per run.
+	Examples int
+}
+
+// Run names a run directory.
+type Run struct {
+	Name string
+	Dir  string
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	if len(c.Runs) == 0 {
+		return errors.New("at least one run directory is required")
+	}
+	if c.Percentile <= 0 || c.Percentile >= 100 {
+		return fmt.Errorf("--percentile must be between 0 and 100, got %g", c.Percentile)
+	}
+	return nil
+}
+
+// Op is one fio I/O from a latency log.
+type Op struct {
+	// End is when the I/O completed; it started Latency earlier.
+	End     time.Time     `json:"end"`
+	Latency time.Duration `json:"latency"`
+	Dir     string        `json:"dir"`
+	Offset  int64         `json:"offset"`
+	Size    int64         `json:"size"`
+	Log     string        `json:"log"`
+}
+
+// Outlier is a slow op and what it was attributed to.
+type Outlier struct {
+	Op
+	Cause string `json:"cause"`
+	// Evidence is the gcsfuse log message that decided the cause.
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// CauseStat summarizes the outliers attributed to one cause.
+type CauseStat struct {
+	Cause    string        `json:"cause"`
+	Outliers int           `json:"outliers"`
+	Share    float64       `json:"share"`
+	Mean     time.Duration `json:"mean_latency"`
+	Max      time.Duration `json:"max_latency"`
+}
+
+// RunReport is the attribution of one run.
+type RunReport struct {
+	Name      string        `json:"name"`
+	Ops       int           `json:"ops"`
+	Threshold time.Duration `json:"threshold"`
+	Outliers  int           `json:"outliers"`
+	// Causes are ranked by their number of outliers.
+	Causes []CauseStat `json:"causes"`
+	// Slowest are the slowest outliers, slowest first.
+	Slowest []Outlier `json:"slowest"`
+}
+
+// Report is the attribution of all runs.
+type Report struct {
+	Percentile float64      `json:"percentile"`
+	Runs       []*RunReport `json:"runs"`
+}
+
+// Analyze attributes the outliers of every run.
+func Analyze(cfg Config) (*Report, error) {
+	rep := &Report{Percentile: cfg.Percentile}
+	for _, r := range cfg.Runs {
+		rr, err := analyzeRun(cfg, r)
+		if err != nil {
+			return nil, fmt.Errorf("run %s: %w", r.Name, err)
+		}
+		rep.Runs = append(rep.Runs, rr)
+	}
+	return rep, nil
+}
+
+// fioLog matches the latency logs of write_lat_log, e.g. job_lat.1.log and
+// job_clat.1.log. Submission latency logs (slat) are not of interest.
+var fioLog = regexp.MustCompile(`_(c?lat)\.\d+\.log$`)
+
+func analyzeRun(cfg Config, r Run) (*RunReport, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Total latency (lat) includes submission; completion latency (clat) is
+	// the fallback when only that was logged.
+	var lat, clat, gcsfuse []string
+	for _, e := range entries {
+		p := filepath.Join(r.Dir, e.Name())
+		switch m := fioLog.FindStringSubmatch(e.Name()); {
+		case e.IsDir():
+		case m != nil && m[1] == "lat":
+			lat = append(lat, p)
+		case m != nil:
+			clat = append(clat, p)
+		case strings.HasSuffix(e.Name(), ".log") || strings.HasSuffix(e.Name(), ".json"):
+			gcsfuse = append(gcsfuse, p)
+		}
+	}
+	if len(lat) == 0 {
+		lat = clat
+	}
+	if len(lat) == 0 {
+		return nil, fmt.Errorf("no fio latency logs (*_lat.N.log) in %s; run fio with write_lat_log", r.Dir)
+	}
+
+	events, first, err := loadEvents(gcsfuse)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		slog.Warn("No attributable gcsfuse log records found; were the logs written with --log-severity=trace?", "run", r.Name)
+	}
+	start := cfg.FioStart
+	if start.IsZero() {
+		start = first
+	}
+	var ops []Op
+	for _, p := range lat {
+		o, err := readLatLog(p, start)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, o...)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("the fio latency logs in %s are empty", r.Dir)
+	}
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Latency > ops[j].Latency })
+	// The epsilon keeps 100-99.9 from rounding 5 of 5000 ops down to 4.
+	n := max(int(math.Ceil(float64(len(ops))*(100-cfg.Percentile)/100-1e-9)), 1)
+	rr := &RunReport{Name: r.Name, Ops: len(ops), Threshold: ops[n-1].Latency, Outliers: n}
+	stats := map[string]*CauseStat{}
+	for _, op := range ops[:n] {
+		o := attribute(op, events, cfg.Slack)
+		st := stats[o.Cause]
+		if st == nil {
+			st = &CauseStat{Cause: o.Cause}
+			stats[o.Cause] = st
+		}
+		st.Outliers++
+		st.Mean += o.Latency
+		st.Max = max(st.Max, o.Latency)
+		if len(rr.Slowest) < cfg.Examples {
+			rr.Slowest = append(rr.Slowest, o)
+		}
+	}
+	for _, c := range causeOrder {
+		if st := stats[c]; st != nil {
+			st.Mean /= time.Duration(st.Outliers)
+			st.Share = float64(st.Outliers) / float64(n)
+			rr.Causes = append(rr.Causes, *st)
+		}
+	}
+	sort.SliceStable(rr.Causes, func(i, j int) bool { return rr.Causes[i].Outliers > rr.Causes[j].Outliers })
+	return rr, nil
+}
+
+// event is a gcsfuse log record pointing at a cause, spanning [start, end].
+type event struct {
+	start, end time.Time
+	cause      string
+	latency    time.Duration
+	message    string
+}
+
+// loadEvents returns the attributable records of the gcsfuse logs, sorted by
+// end time, and the time of the first record.
+func loadEvents(paths []string) ([]event, time.Time, error) {
+	var events []event
+	var first time.Time
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, first, err
+		}
+		err = gcsfuselog.Scan(f, func(e gcsfuselog.Entry) {
+			if first.IsZero() || e.Time.Before(first) {
+				first = e.Time
+			}
+			if ev, ok := classify(e); ok {
+				events = append(events, ev)
+			}
+		})
+		f.Close()
+		if err != nil {
+			return nil, first, fmt.Errorf("reading %s: %w", p, err)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].end.Before(events[j].end) })
+	return events, first, nil
+}
+
+func classify(e gcsfuselog.Entry) (event, bool) {
+	ev := event{start: e.Time, end: e.Time, message: e.Message}
+	switch {
+	case throttling.MatchString(e.Message):
+		ev.cause = CauseThrottling
+	case retry5xx.MatchString(e.Message):
+		ev.cause = CauseRetry
+	case connection.MatchString(e.Message):
+		ev.cause = CauseConnection
+	case cacheMiss.MatchString(e.Message):
+		ev.cause = CauseCacheMiss
+	default:
+		r, ok := gcsfuselog.ParseGCSResponse(e)
+		if !ok {
+			return event{}, false
+		}
+		ev.cause, ev.latency, ev.start = CauseSlowGCS, r.Latency, e.Time.Add(-r.Latency)
+	}
+	return ev, true
+}
+
+// maxSpan bounds the duration of the GCS requests considered, so that the
+// search for events overlapping an op stops.
+const maxSpan = time.Minute
+
+// attribute picks the highest-ranked cause among the events overlapping op.
+// A GCS request only explains an op when it took at least half as long.
+func attribute(op Op, events []event, slack time.Duration) Outlier {
+	from, to := op.End.Add(-op.Latency-slack), op.End.Add(slack)
+	best := Outlier{Op: op, Cause: CauseUnknown}
+	rank := func(c string) int {
+		for i, o := range causeOrder {
+			if o == c {
+				return i
+			}
+		}
+		return len(causeOrder)
+	}
+	i := sort.Search(len(events), func(i int) bool { return !events[i].end.Before(from) })
+	for ; i < len(events) && events[i].end.Sub(to) <= maxSpan; i++ {
+		ev := events[i]
+		if ev.start.After(to) {
+			continue
+		}
+		if ev.cause == CauseSlowGCS && ev.latency < op.Latency/2 {
+			continue
+		}
+		if rank(ev.cause) < rank(best.Cause) {
+			best.Cause, best.Evidence = ev.cause, ev.message
+		}
+	}
+	return best
+}
+
+// ddirs are the data directions of fio log entries.
+var ddirs = []string{"read", "write", "trim", "sync"}
+
+// readLatLog reads a fio latency log. Its lines are "time, latency, ddir,
+// block size, offset[, priority]" with the time in ms since start, or since
+// the epoch with log_unix_epoch=1, and the latency in ns.
+func readLatLog(path string, start time.Time) ([]Op, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Op
+	for n, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		f := strings.Split(line, ",")
+		if len(f) < 4 {
+			return nil, fmt.Errorf("%s:%d: malformed latency log line %q", path, n+1, line)
+		}
+		var v [5]int64
+		for i := 0; i < len(f) && i < len(v); i++ {
+			if v[i], err = strconv.ParseInt(strings.TrimSpace(f[i]), 10, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: malformed latency log line %q", path, n+1, line)
+			}
+		}
+		end := time.UnixMilli(v[0])
+		if v[0] < 1e12 {
+			end = start.Add(time.Duration(v[0]) * time.Millisecond)
+		}
+		op := Op{End: end, Latency: time.Duration(v[1]), Dir: fmt.Sprint(v[2]), Size: v[3], Offset: v[4], Log: filepath.Base(path)}
+		if v[2] >= 0 && int(v[2]) < len(ddirs) {
+			op.Dir = ddirs[v[2]]
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// WriteText prints the ranked causes and slowest outliers of every run.
+func (r *Report) WriteText(w io.Writer) error {
+	for i, run := range r.Runs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Run %s: %d ops, %d above p%g (%s)\n\n", run.Name, run.Ops, run.Outliers, r.Percentile, run.Threshold)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CAUSE\tOUTLIERS\tSHARE\tMEAN LAT\tMAX LAT")
+		for _, c := range run.Causes {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\n", c.Cause, c.Outliers, c.Share*100, c.Mean.Round(time.Microsecond), c.Max.Round(time.Microsecond))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if len(run.Slowest) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "\nSlowest:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "LATENCY\tDIR\tOFFSET\tSIZE\tCAUSE\tEVIDENCE")
+		for _, o := range run.Slowest {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", o.Latency.Round(time.Microsecond), o.Dir, o.Offset, o.Size, o.Cause, truncate(o.Evidence, 100))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
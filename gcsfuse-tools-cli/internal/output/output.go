@@ -0,0 +1,53 @@
+// Package output renders command results in the format selected by the
+// global --output flag.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format is a result rendering format.
+type Format string
+
+const (
+	// Text renders results for humans.
+	Text Format = "text"
+	// JSON renders results as indented JSON for pipelines.
+	JSON Format = "json"
+)
+
+// ParseFormat validates a --output flag value.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case Text, JSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid output format %q (want text or json)", s)
+	}
+}
+
+// TextWriter is implemented by results that have a human-readable rendering.
+// Results that don't implement it are printed with %+v in text mode.
+type TextWriter interface {
+	WriteText(w io.Writer) error
+}
+
+// Write renders v to w in format f.
+func Write(w io.Writer, f Format, v any) error {
+	if f == JSON {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling output: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	if tw, ok := v.(TextWriter); ok {
+		return tw.WriteText(w)
+	}
+	_, err := fmt.Fprintf(w, "%+v\n", v)
+	return err
+}
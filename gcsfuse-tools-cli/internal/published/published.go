@@ -0,0 +1,307 @@
+// Package published compares a local bench fio run against the published
+// gcsfuse performance numbers of a release and explains the shortfalls with
+// the differences between the two environments.
+package published
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+//go:embed data/*.json
+var dataFS embed.FS
+
+// Environment is the setup the published numbers were measured on.
+type Environment struct {
+	MachineType  string   `json:"machine_type"`
+	NumCPU       int      `json:"num_cpu"`
+	NICGbps      int      `json:"nic_gbps"`
+	GcsfuseFlags []string `json:"gcsfuse_flags"`
+}
+
+// Case is one published measurement.
+type Case struct {
+	// Workload is seq-read, rand-read or write, as in fio-render.
+	Workload       string  `json:"workload"`
+	FileSize       string  `json:"file_size"`
+	BlockSize      string  `json:"block_size"`
+	Threads        int     `json:"threads"`
+	BandwidthMiBps float64 `json:"bandwidth_mibps"`
+}
+
+// Table is the published performance table of a release.
+type Table struct {
+	Release     string      `json:"release"`
+	Source      string      `json:"source"`
+	Note        string      `json:"note,omitempty"`
+	Environment Environment `json:"environment"`
+	Cases       []Case      `json:"cases"`
+}
+
+// Releases lists the releases with an embedded table, oldest first.
+func Releases() []string {
+	entries, _ := dataFS.ReadDir("data")
+	var out []string
+	for _, e := range entries {
+		out = append(out, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	return out
+}
+
+// Embedded returns the embedded table of release, or of the latest release
+// when release is empty.
+func Embedded(release string) (*Table, error) {
+	if release == "" {
+		rs := Releases()
+		release = rs[len(rs)-1]
+	}
+	b, err := dataFS.ReadFile("data/" + release + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no embedded table for release %q (have %s)", release, strings.Join(Releases(), ", "))
+	}
+	return decode(b)
+}
+
+// Load reads a table from a gs://BUCKET/OBJECT URI or a local file.
+func Load(ctx context.Context, client *storage.Client, uri string) (*Table, error) {
+	var b []byte
+	var err error
+	if rest, ok := strings.CutPrefix(uri, "gs://"); ok {
+		bucket, object, _ := strings.Cut(rest, "/")
+		if client == nil {
+			return nil, fmt.Errorf("reading %s: no storage client", uri)
+		}
+		var r *storage.Reader
+		if r, err = client.Bucket(bucket).Object(object).NewReader(ctx); err == nil {
+			b, err = io.ReadAll(r)
+			r.Close()
+		}
+	} else {
+		b, err = os.ReadFile(uri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", uri, err)
+	}
+	return decode(b)
+}
+
+func decode(b []byte) (*Table, error) {
+	var t Table
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("decoding published table: %w", err)
+	}
+	if len(t.Cases) == 0 {
+		return nil, fmt.Errorf("published table %q has no cases", t.Release)
+	}
+	return &t, nil
+}
+
+var (
+	// read_filesize_1M_blocksize_1M, as written by fio-render and the
+	// release read jobfiles.
+	renderedJob = regexp.MustCompile(`(?i)^(read|randread|write)_filesize_(\d+[KMGT]?)B?_blocksize_`)
+	// write_256kb_seq, as in the release write jobfile.
+	releaseWriteJob = regexp.MustCompile(`(?i)^write_(\d+[KMGT]?)B?_seq$`)
+)
+
+var workloads = map[string]string{"read": "seq-read", "randread": "rand-read", "write": "write"}
+
+// parseJob maps a fio job name to a workload and file size in bytes.
+func parseJob(name string) (string, int64, bool) {
+	var workload, size string
+	if m := renderedJob.FindStringSubmatch(name); m != nil {
+		workload, size = workloads[strings.ToLower(m[1])], m[2]
+	} else if m := releaseWriteJob.FindStringSubmatch(name); m != nil {
+		workload, size = "write", m[1]
+	} else {
+		return "", 0, false
+	}
+	n, err := units.ParseSize(size)
+	return workload, n, err == nil
+}
+
+// Statuses of a compared job.
+const (
+	StatusOK    = "ok"
+	StatusBelow = "below"
+	StatusAbove = "above"
+)
+
+// Row compares one local job with the matching published case.
+type Row struct {
+	Job            string  `json:"job"`
+	Workload       string  `json:"workload"`
+	FileSize       string  `json:"file_size"`
+	LocalMiBps     float64 `json:"local_mibps"`
+	PublishedMiBps float64 `json:"published_mibps"`
+	// Ratio is local over published bandwidth.
+	Ratio  float64 `json:"ratio"`
+	Status string  `json:"status"`
+}
+
+// Comparison is the result of compare-published.
+type Comparison struct {
+	Release   string  `json:"release"`
+	Source    string  `json:"source"`
+	Note      string  `json:"note,omitempty"`
+	Tolerance float64 `json:"tolerance"`
+	Rows      []Row   `json:"rows"`
+	// Unmatched lists the local jobs without a published counterpart.
+	Unmatched []string `json:"unmatched,omitempty"`
+	// Causes are the likely reasons for the jobs below expectations.
+	Causes []string `json:"causes,omitempty"`
+}
+
+// Below reports whether any job underperformed the published numbers.
+func (c *Comparison) Below() bool {
+	return slices.ContainsFunc(c.Rows, func(r Row) bool { return r.Status == StatusBelow })
+}
+
+// Compare matches the jobs of res with the published cases by workload and
+// file size. A job is below expectations when its bandwidth is less than
+// (1 - tolerance) of the published one.
+func Compare(t *Table, res *bench.Result, tolerance float64) *Comparison {
+	c := &Comparison{Release: t.Release, Source: t.Source, Note: t.Note, Tolerance: tolerance}
+	for _, j := range res.Jobs {
+		workload, size, ok := parseJob(j.Name)
+		i := slices.IndexFunc(t.Cases, func(pc Case) bool {
+			n, err := units.ParseSize(pc.FileSize)
+			return err == nil && pc.Workload == workload && n == size
+		})
+		if !ok || i < 0 || j.Error != 0 {
+			c.Unmatched = append(c.Unmatched, j.Name)
+			continue
+		}
+		stats := j.Read
+		if workload == "write" {
+			stats = j.Write
+		}
+		if stats == nil {
+			c.Unmatched = append(c.Unmatched, j.Name)
+			continue
+		}
+		pc := t.Cases[i]
+		r := Row{
+			Job:            j.Name,
+			Workload:       workload,
+			FileSize:       units.FormatSize(size),
+			LocalMiBps:     stats.BwKiBps / 1024,
+			PublishedMiBps: pc.BandwidthMiBps,
+			Status:         StatusOK,
+		}
+		r.Ratio = r.LocalMiBps / r.PublishedMiBps
+		switch {
+		case r.Ratio < 1-tolerance:
+			r.Status = StatusBelow
+		case r.Ratio > 1+tolerance:
+			r.Status = StatusAbove
+		}
+		c.Rows = append(c.Rows, r)
+	}
+	if c.Below() {
+		c.Causes = causes(t, res, c.Rows)
+	}
+	return c
+}
+
+// causes lists environment differences and shortfall patterns that usually
+// explain lower numbers, most decisive first.
+func causes(t *Table, res *bench.Result, rows []Row) []string {
+	var out []string
+	want := t.Environment
+	if res.Target != "" && res.Target != bench.TargetGcsfuse {
+		out = append(out, fmt.Sprintf("The run used the %s target; the published numbers are for gcsfuse.", res.Target))
+	}
+	if env := res.Env; env != nil {
+		if want.MachineType != "" && env.MachineType != "" && env.MachineType != want.MachineType {
+			out = append(out, fmt.Sprintf("Machine type %s differs from the published %s.", env.MachineType, want.MachineType))
+		}
+		if want.NumCPU > 0 && env.NumCPU < want.NumCPU {
+			out = append(out, fmt.Sprintf("%d CPUs instead of %d: gcsfuse and fio threads compete for CPU.", env.NumCPU, want.NumCPU))
+		}
+		var nicMbps int
+		for _, n := range env.NICs {
+			nicMbps = max(nicMbps, n.SpeedMbps)
+		}
+		if want.NICGbps > 0 && nicMbps > 0 && nicMbps < want.NICGbps*1000 {
+			out = append(out, fmt.Sprintf("The fastest NIC negotiated %d Gbps instead of %d Gbps.", nicMbps/1000, want.NICGbps))
+		}
+	}
+	if res.Target == "" || res.Target == bench.TargetGcsfuse {
+		var missing []string
+		for _, f := range want.GcsfuseFlags {
+			if !slices.Contains(res.GcsfuseFlags, f) {
+				missing = append(missing, f)
+			}
+		}
+		if len(missing) > 0 {
+			out = append(out, fmt.Sprintf("The published runs used gcsfuse flags missing here: %s.", strings.Join(missing, " ")))
+		}
+	}
+
+	var small, large, writes, below int
+	for _, r := range rows {
+		if r.Status != StatusBelow {
+			continue
+		}
+		below++
+		n, _ := units.ParseSize(r.FileSize)
+		switch {
+		case r.Workload == "write":
+			writes++
+		case n <= units.MiB:
+			small++
+		default:
+			large++
+		}
+	}
+	switch {
+	case writes == below:
+		out = append(out, "Only writes are slow: check the disk gcsfuse stages writes on (--temp-dir) or try streaming writes.")
+	case small > 0 && large == 0:
+		out = append(out, "Only small files are slow, so per-file latency dominates: check that the VM runs in the bucket's region and that metadata caches are enabled.")
+	case large > 0 && small == 0:
+		out = append(out, "Only large files are slow, so per-host throughput is the limit: check NIC bandwidth, CPU saturation and the client protocol.")
+	}
+	return out
+}
+
+// WriteText prints one row per compared job followed by the likely causes.
+func (c *Comparison) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Published gcsfuse %s numbers from %s, tolerance %.0f%%\n", c.Release, c.Source, c.Tolerance*100)
+	if c.Note != "" {
+		fmt.Fprintf(w, "Note: %s\n", c.Note)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tWORKLOAD\tFILE SIZE\tLOCAL (MiB/s)\tPUBLISHED (MiB/s)\tRATIO\tSTATUS")
+	for _, r := range c.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\t%.1f\t%.2f\t%s\n", r.Job, r.Workload, r.FileSize, r.LocalMiBps, r.PublishedMiBps, r.Ratio, r.Status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(c.Unmatched) > 0 {
+		fmt.Fprintf(w, "\nNo published counterpart: %s\n", strings.Join(c.Unmatched, ", "))
+	}
+	if len(c.Causes) > 0 {
+		fmt.Fprintln(w, "\nLikely causes:")
+		for _, cause := range c.Causes {
+			fmt.Fprintf(w, "  - %s\n", cause)
+		}
+	}
+	return nil
+}
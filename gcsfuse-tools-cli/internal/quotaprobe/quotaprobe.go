@@ -0,0 +1,360 @@
+// Package quotaprobe measures the request rate a bucket sustains per
+// operation: it raises the rate of one kind of request step by step until
+// GCS throttles it, then backs off to the highest rate that runs clean, so
+// benchmark parallelism can be chosen from data.
+package quotaprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/time/rate"
+
+	"gcsfuse-tools-cli/internal/dataprep"
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Probed operations.
+const (
+	// OpWrite uploads a new object of ObjectSize bytes per request.
+	OpWrite = "write"
+	// OpRead reads a seed object in full.
+	OpRead = "read"
+	// OpStat gets the metadata of a seed object.
+	OpStat = "stat"
+	// OpList lists one page of the seed objects.
+	OpList = "list"
+)
+
+// Ops lists the probed operations.
+var Ops = []string{OpWrite, OpRead, OpStat, OpList}
+
+// Reasons the ramp of an operation stopped.
+const (
+	// StopThrottled is a step with more than ThrottleThreshold of its
+	// requests throttled.
+	StopThrottled = "throttled"
+	// StopErrors is a step with more than ThrottleThreshold of its requests
+	// failing otherwise, e.g. for lack of permissions.
+	StopErrors = "errors"
+	// StopClient is a step the workers could not keep up with: the client,
+	// not the bucket, is the bottleneck.
+	StopClient = "client-bound"
+	// StopMaxQPS is a clean step at MaxQPS.
+	StopMaxQPS = "max-qps"
+)
+
+// Step phases.
+const (
+	PhaseRamp    = "ramp"
+	PhaseBackoff = "backoff"
+)
+
+// maxBackoffSteps bounds the steps spent looking for a clean rate after the
+// ramp was throttled.
+const maxBackoffSteps = 3
+
+// Config describes a probe.
+type Config struct {
+	Bucket string
+	// Prefix holds the seed objects and the objects written by the probe,
+	// which are deleted at the end unless Keep is set.
+	Prefix string
+	Ops    []string
+	// The rate of every operation starts at StartQPS and is multiplied by
+	// Growth after every clean step of StepDuration, up to MaxQPS.
+	StartQPS     float64
+	MaxQPS       float64
+	Growth       float64
+	StepDuration time.Duration
+	// ThrottleThreshold is the fraction of the requests of a step that may
+	// be throttled, or fail, for the step to count as clean.
+	ThrottleThreshold float64
+	// Cooldown is the pause between operations, letting the throttling of
+	// one fade before the next is probed.
+	Cooldown time.Duration
+	// Workers bounds the requests in flight.
+	Workers int
+	// ObjectSize is the size of the written and seed objects.
+	ObjectSize int64
+	// SeedObjects is the number of objects read, stat'ed and listed.
+	SeedObjects int
+	Keep        bool
+}
+
+// Validate reports missing or out-of-range options.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	if len(c.Ops) == 0 {
+		return errors.New("--ops must name at least one operation")
+	}
+	for _, op := range c.Ops {
+		if !slices.Contains(Ops, op) {
+			return fmt.Errorf("unsupported operation %q (want %v)", op, Ops)
+		}
+	}
+	if c.StartQPS <= 0 || c.MaxQPS < c.StartQPS {
+		return errors.New("--start-qps must be greater than 0 and at most --max-qps")
+	}
+	if c.Growth <= 1 {
+		return errors.New("--growth must be greater than 1")
+	}
+	if c.StepDuration <= 0 {
+		return errors.New("--step-duration must be greater than 0")
+	}
+	if c.ThrottleThreshold < 0 || c.ThrottleThreshold >= 1 {
+		return errors.New("--throttle-threshold must be at least 0 and below 1")
+	}
+	if c.Workers < 1 {
+		return errors.New("--workers must be at least 1")
+	}
+	if c.ObjectSize < 0 {
+		return errors.New("--object-size must not be negative")
+	}
+	if c.SeedObjects < 1 {
+		return errors.New("--seed-objects must be at least 1")
+	}
+	return nil
+}
+
+// Step is the outcome of running one kind of request at a target rate for
+// a step.
+type Step struct {
+	Phase       string  `json:"phase"`
+	TargetQPS   float64 `json:"target_qps"`
+	AchievedQPS float64 `json:"achieved_qps"`
+	Requests    int64   `json:"requests"`
+	Throttled   int64   `json:"throttled"`
+	Errors      int64   `json:"errors"`
+	// Skipped counts the requests not sent because every worker was busy.
+	Skipped int64   `json:"skipped,omitempty"`
+	P50Ms   float64 `json:"p50_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+}
+
+// OpResult is the probe of one operation.
+type OpResult struct {
+	Op string `json:"op"`
+	// SustainableQPS is the highest rate achieved by a clean step: the last
+	// clean ramp step, or the backoff step that ran clean after throttling.
+	SustainableQPS float64 `json:"sustainable_qps"`
+	// ThrottledAtQPS is the target rate of the first throttled step, if any.
+	ThrottledAtQPS float64 `json:"throttled_at_qps,omitempty"`
+	StopReason     string  `json:"stop_reason"`
+	Steps          []Step  `json:"steps"`
+}
+
+// Result is the outcome of a probe.
+type Result struct {
+	Bucket    string               `json:"bucket"`
+	Ops       []OpResult           `json:"ops"`
+	Workers   int                  `json:"workers"`
+	StartTime time.Time            `json:"start_time"`
+	EndTime   time.Time            `json:"end_time"`
+	Env       *envinfo.Fingerprint `json:"env,omitempty"`
+}
+
+// request sends one request of an operation; seq numbers the requests of
+// the operation from 0.
+type request func(ctx context.Context, seq int64) error
+
+// Run seeds the bucket, probes every operation of cfg in turn and deletes
+// the objects it created. Requests are sent without the retries of the
+// client library, so that every throttled response is seen.
+func Run(ctx context.Context, client *storage.Client, cfg Config) (*Result, error) {
+	p := newProber(client, cfg)
+	res := &Result{Bucket: cfg.Bucket, Workers: cfg.Workers, StartTime: time.Now()}
+	if !cfg.Keep {
+		defer p.cleanup(context.WithoutCancel(ctx))
+	}
+	if slices.ContainsFunc(cfg.Ops, func(op string) bool { return op != OpWrite }) {
+		if err := p.seed(ctx); err != nil {
+			return nil, fmt.Errorf("writing the seed objects: %w", err)
+		}
+	}
+	for i, op := range cfg.Ops {
+		if i > 0 && cfg.Cooldown > 0 {
+			slog.Info("Cooling down", "duration", cfg.Cooldown)
+			select {
+			case <-time.After(cfg.Cooldown):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		r, err := p.probe(ctx, op)
+		if err != nil {
+			return nil, fmt.Errorf("probing %s: %w", op, err)
+		}
+		res.Ops = append(res.Ops, *r)
+	}
+	res.EndTime = time.Now()
+	return res, nil
+}
+
+// probe ramps the rate of op until a step is not clean, then backs off.
+func (p *prober) probe(ctx context.Context, op string) (*OpResult, error) {
+	cfg := p.cfg
+	var seq int64
+	send := p.request(op)
+	r := &OpResult{Op: op}
+	var clean float64
+	for qps := cfg.StartQPS; ; qps = min(qps*cfg.Growth, cfg.MaxQPS) {
+		st, err := p.step(ctx, send, &seq, PhaseRamp, qps)
+		if err != nil {
+			return nil, err
+		}
+		r.Steps = append(r.Steps, st)
+		switch {
+		case p.throttled(st):
+			r.StopReason, r.ThrottledAtQPS = StopThrottled, qps
+		case p.failing(st):
+			r.StopReason = StopErrors
+		case st.AchievedQPS < 0.9*qps:
+			// The client is saturated; the bucket took all it was sent.
+			r.StopReason, clean = StopClient, max(clean, st.AchievedQPS)
+		case qps >= cfg.MaxQPS:
+			r.StopReason, clean = StopMaxQPS, st.AchievedQPS
+		default:
+			clean = st.AchievedQPS
+			continue
+		}
+		break
+	}
+	r.SustainableQPS = clean
+	if r.StopReason != StopThrottled {
+		return r, nil
+	}
+
+	// Throttling lingers, so the last clean rate is confirmed, and lowered
+	// further if it is throttled too.
+	target := clean
+	if target == 0 {
+		target = cfg.StartQPS / cfg.Growth
+	}
+	r.SustainableQPS = 0
+	for i := 0; i < maxBackoffSteps; i, target = i+1, target/cfg.Growth {
+		st, err := p.step(ctx, send, &seq, PhaseBackoff, target)
+		if err != nil {
+			return nil, err
+		}
+		r.Steps = append(r.Steps, st)
+		if !p.throttled(st) && !p.failing(st) {
+			r.SustainableQPS = st.AchievedQPS
+			break
+		}
+	}
+	return r, nil
+}
+
+func (p *prober) throttled(st Step) bool {
+	return st.Requests > 0 && float64(st.Throttled) > p.cfg.ThrottleThreshold*float64(st.Requests)
+}
+
+func (p *prober) failing(st Step) bool {
+	return st.Requests > 0 && float64(st.Errors) > p.cfg.ThrottleThreshold*float64(st.Requests)
+}
+
+// step sends requests at qps for cfg.StepDuration, numbered from *seq.
+// Requests are started on schedule whether or not earlier ones finished, up
+// to cfg.Workers in flight; the ones due while every worker is busy are
+// skipped.
+func (p *prober) step(ctx context.Context, send request, seq *int64, phase string, qps float64) (Step, error) {
+	cfg := p.cfg
+	slog.Info("Probing", "phase", phase, "target_qps", qps, "duration", cfg.StepDuration)
+	st := Step{Phase: phase, TargetQPS: qps}
+	stepCtx, cancel := context.WithTimeout(ctx, cfg.StepDuration)
+	defer cancel()
+	lim := rate.NewLimiter(rate.Limit(qps), max(1, int(qps/100)))
+
+	var mu sync.Mutex
+	var lats []time.Duration
+	// The buffer lets the first request wait for the workers to start.
+	jobs := make(chan int64, 1)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				// In-flight requests finish after the step ends.
+				start := time.Now()
+				err := send(ctx, n)
+				d := time.Since(start)
+				mu.Lock()
+				switch {
+				case err == nil:
+					lats = append(lats, d)
+				case dataprep.IsThrottled(err):
+					st.Throttled++
+				case ctx.Err() == nil:
+					st.Errors++
+					slog.Debug("Request failed", "err", err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	start := time.Now()
+	for lim.Wait(stepCtx) == nil {
+		select {
+		case jobs <- *seq:
+			*seq++
+			st.Requests++
+		default:
+			st.Skipped++
+		}
+	}
+	elapsed := time.Since(start)
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return st, err
+	}
+
+	st.AchievedQPS = float64(st.Requests) / elapsed.Seconds()
+	slices.Sort(lats)
+	st.P50Ms, st.P99Ms = quantileMs(lats, 0.5), quantileMs(lats, 0.99)
+	slog.Info("Step done", "phase", phase, "target_qps", qps, "achieved_qps", st.AchievedQPS,
+		"throttled", st.Throttled, "errors", st.Errors, "skipped", st.Skipped, "p99_ms", st.P99Ms)
+	return st, nil
+}
+
+// quantileMs returns the q-quantile of sorted in milliseconds.
+func quantileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(q*float64(len(sorted))), len(sorted)-1)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// prober sends the requests of a probe and remembers the objects it wrote.
+type prober struct {
+	cfg     Config
+	bucket  *storage.BucketHandle
+	payload []byte
+	// writes counts the objects written by OpWrite, named writeName(0) to
+	// writeName(writes-1).
+	writes atomic.Int64
+}
+
+func newProber(client *storage.Client, cfg Config) *prober {
+	return &prober{cfg: cfg, bucket: client.Bucket(cfg.Bucket), payload: make([]byte, cfg.ObjectSize)}
+}
+
+func (p *prober) seedName(i int64) string {
+	return fmt.Sprintf("%sseed/%06d", p.cfg.Prefix, i)
+}
+
+func (p *prober) writeName(seq int64) string {
+	return fmt.Sprintf("%swrite/%09d", p.cfg.Prefix, seq)
+}
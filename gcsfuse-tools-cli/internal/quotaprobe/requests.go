@@ -0,0 +1,155 @@
+package quotaprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// noRetry makes the library return throttled responses instead of retrying
+// them.
+var noRetry = storage.WithPolicy(storage.RetryNever)
+
+// request returns the function sending one request of op.
+func (p *prober) request(op string) request {
+	seed := func(seq int64) *storage.ObjectHandle {
+		return p.bucket.Object(p.seedName(seq % int64(p.cfg.SeedObjects))).Retryer(noRetry)
+	}
+	switch op {
+	case OpWrite:
+		return func(ctx context.Context, seq int64) error {
+			p.writes.Add(1)
+			return p.write(ctx, p.bucket.Object(p.writeName(seq)).Retryer(noRetry))
+		}
+	case OpRead:
+		return func(ctx context.Context, seq int64) error {
+			rd, err := seed(seq).NewReader(ctx)
+			if err != nil {
+				return err
+			}
+			defer rd.Close()
+			_, err = io.Copy(io.Discard, rd)
+			return err
+		}
+	case OpStat:
+		return func(ctx context.Context, seq int64) error {
+			_, err := seed(seq).Attrs(ctx)
+			return err
+		}
+	default:
+		return func(ctx context.Context, seq int64) error {
+			it := p.bucket.Retryer(noRetry).Objects(ctx, &storage.Query{Prefix: p.cfg.Prefix + "seed/"})
+			var page []*storage.ObjectAttrs
+			_, err := iterator.NewPager(it, 1000, "").NextPage(&page)
+			return err
+		}
+	}
+}
+
+// write uploads the payload to obj in a single request.
+func (p *prober) write(ctx context.Context, obj *storage.ObjectHandle) error {
+	w := obj.NewWriter(ctx)
+	w.ChunkSize = 0
+	if _, err := w.Write(p.payload); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// seed writes the objects read, stat'ed and listed by the probe.
+func (p *prober) seed(ctx context.Context) error {
+	slog.Info("Writing seed objects", "objects", p.cfg.SeedObjects, "prefix", p.cfg.Prefix+"seed/")
+	return p.each(ctx, int64(p.cfg.SeedObjects), func(ctx context.Context, i int64) error {
+		return p.write(ctx, p.bucket.Object(p.seedName(i)))
+	})
+}
+
+// cleanup deletes the seed objects and the objects written by the probe.
+func (p *prober) cleanup(ctx context.Context) {
+	for _, set := range []struct {
+		n    int64
+		name func(int64) string
+	}{{int64(p.cfg.SeedObjects), p.seedName}, {p.writes.Load(), p.writeName}} {
+		err := p.each(ctx, set.n, func(ctx context.Context, i int64) error {
+			if err := p.bucket.Object(set.name(i)).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("Could not delete the probe objects", "prefix", p.cfg.Prefix, "err", err)
+			return
+		}
+	}
+	slog.Info("Deleted the probe objects", "prefix", p.cfg.Prefix)
+}
+
+// each calls fn for 0..n-1 on cfg.Workers workers and returns the first
+// error.
+func (p *prober) each(ctx context.Context, n int64, fn func(context.Context, int64) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	items := make(chan int64)
+	var wg sync.WaitGroup
+	for w := 0; w < p.cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if err := fn(ctx, i); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+	for i := int64(0); i < n; i++ {
+		select {
+		case items <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(items)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// WriteText prints one row per step and the sustainable rate of every
+// operation.
+func (r *Result) WriteText(w io.Writer) error {
+	for _, op := range r.Ops {
+		fmt.Fprintf(w, "%s:\n", op.Op)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "PHASE\tTARGET QPS\tACHIEVED QPS\tREQUESTS\tTHROTTLED\tERRORS\tSKIPPED\tP50 MS\tP99 MS\t")
+		for _, s := range op.Steps {
+			fmt.Fprintf(tw, "%s\t%.0f\t%.1f\t%d\t%d\t%d\t%d\t%.1f\t%.1f\t\n", s.Phase, s.TargetQPS, s.AchievedQPS,
+				s.Requests, s.Throttled, s.Errors, s.Skipped, s.P50Ms, s.P99Ms)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OP\tSUSTAINABLE QPS\tTHROTTLED AT\tSTOPPED BY")
+	for _, op := range r.Ops {
+		at := "-"
+		if op.ThrottledAtQPS > 0 {
+			at = fmt.Sprintf("%.0f", op.ThrottledAtQPS)
+		}
+		fmt.Fprintf(tw, "%s\t%.0f\t%s\t%s\n", op.Op, op.SustainableQPS, at, op.StopReason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "gs://%s, %d workers, %s\n", r.Bucket, r.Workers, r.EndTime.Sub(r.StartTime).Round(time.Second))
+	return err
+}
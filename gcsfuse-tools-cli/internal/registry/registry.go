@@ -0,0 +1,241 @@
+// Package registry catalogs prepared benchmark datasets and benchmark results
+// in a GCS bucket with a well-known layout:
+//
+//	datasets/<name>.json          DatasetEntry
+//	results/<run-id>/entry.json   ResultEntry
+//	results/<run-id>/result.json  the result blob written by the tool
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+const (
+	datasetsPrefix = "datasets/"
+	resultsPrefix  = "results/"
+	entryObject    = "entry.json"
+	resultObject   = "result.json"
+)
+
+// ErrNotFound is returned when a dataset or result is not registered.
+var ErrNotFound = errors.New("not found in registry")
+
+// Manifest summarizes the objects of a prepared dataset.
+type Manifest struct {
+	// NamePattern describes the object names, e.g. "rand-read.{job}.{file}".
+	NamePattern string `json:"name_pattern"`
+	ObjectCount int64  `json:"object_count"`
+	TotalBytes  int64  `json:"total_bytes"`
+}
+
+// DatasetEntry describes a prepared dataset.
+type DatasetEntry struct {
+	Name      string    `json:"name"`
+	Bucket    string    `json:"bucket"`
+	Location  string    `json:"location,omitempty"`
+	SpecHash  string    `json:"spec_hash"`
+	Spec      any       `json:"spec,omitempty"`
+	Manifest  Manifest  `json:"manifest"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ResultEntry describes a stored benchmark or coherence result.
+type ResultEntry struct {
+	RunID   string `json:"run_id"`
+	Tool    string `json:"tool"`
+	Dataset string `json:"dataset,omitempty"`
+	// SpecHash is the hash of the workload spec the run executed, if any,
+	// see pkg/workload.
+	SpecHash  string               `json:"spec_hash,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	Env       *envinfo.Fingerprint `json:"env,omitempty"`
+	// ResultObject is the gs:// URL of the result blob.
+	ResultObject string `json:"result_object"`
+}
+
+// Registry reads and writes entries in a registry bucket.
+type Registry struct {
+	bucket *storage.BucketHandle
+}
+
+// New returns a Registry backed by bucket.
+func New(client *storage.Client, bucket string) *Registry {
+	return &Registry{bucket: client.Bucket(bucket)}
+}
+
+// NewRunID returns a sortable, unique run ID such as
+// "20260102-150405-1a2b3c4d".
+func NewRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// PutDataset registers or replaces a dataset entry.
+func (r *Registry) PutDataset(ctx context.Context, e *DatasetEntry) error {
+	if e.Name == "" {
+		return errors.New("dataset name is required")
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	return r.putJSON(ctx, datasetsPrefix+e.Name+".json", e)
+}
+
+// GetDataset returns the named dataset entry.
+func (r *Registry) GetDataset(ctx context.Context, name string) (*DatasetEntry, error) {
+	e := &DatasetEntry{}
+	if err := r.getJSON(ctx, datasetsPrefix+name+".json", e); err != nil {
+		return nil, fmt.Errorf("dataset %q: %w", name, err)
+	}
+	return e, nil
+}
+
+// ListDatasets returns all dataset entries sorted by name.
+func (r *Registry) ListDatasets(ctx context.Context) ([]*DatasetEntry, error) {
+	var out []*DatasetEntry
+	err := r.list(ctx, datasetsPrefix, func(name string) error {
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		e := &DatasetEntry{}
+		if err := r.getJSON(ctx, name, e); err != nil {
+			return err
+		}
+		out = append(out, e)
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, err
+}
+
+// PutResult stores blob as the result of e.RunID and registers e. A run ID is
+// generated when e.RunID is empty.
+func (r *Registry) PutResult(ctx context.Context, e *ResultEntry, blob []byte) error {
+	if e.RunID == "" {
+		e.RunID = NewRunID()
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	name := resultsPrefix + e.RunID + "/" + resultObject
+	if err := r.write(ctx, name, blob); err != nil {
+		return err
+	}
+	e.ResultObject = fmt.Sprintf("gs://%s/%s", r.bucket.BucketName(), name)
+	return r.putJSON(ctx, resultsPrefix+e.RunID+"/"+entryObject, e)
+}
+
+// GetResult returns the entry registered for runID.
+func (r *Registry) GetResult(ctx context.Context, runID string) (*ResultEntry, error) {
+	e := &ResultEntry{}
+	if err := r.getJSON(ctx, resultsPrefix+runID+"/"+entryObject, e); err != nil {
+		return nil, fmt.Errorf("result %q: %w", runID, err)
+	}
+	return e, nil
+}
+
+// GetResultBlob returns the raw result stored for runID.
+func (r *Registry) GetResultBlob(ctx context.Context, runID string) ([]byte, error) {
+	b, err := r.read(ctx, resultsPrefix+runID+"/"+resultObject)
+	if err != nil {
+		return nil, fmt.Errorf("result %q: %w", runID, err)
+	}
+	return b, nil
+}
+
+// ListResults returns the result entries, newest first. A non-empty tool
+// restricts the listing to results of that tool.
+func (r *Registry) ListResults(ctx context.Context, tool string) ([]*ResultEntry, error) {
+	var out []*ResultEntry
+	err := r.list(ctx, resultsPrefix, func(name string) error {
+		if path.Base(name) != entryObject {
+			return nil
+		}
+		e := &ResultEntry{}
+		if err := r.getJSON(ctx, name, e); err != nil {
+			return err
+		}
+		if tool == "" || e.Tool == tool {
+			out = append(out, e)
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].RunID > out[j].RunID })
+	return out, err
+}
+
+func (r *Registry) list(ctx context.Context, prefix string, fn func(name string) error) error {
+	it := r.bucket.Objects(ctx, &storage.Query{Prefix: prefix, Projection: storage.ProjectionNoACL})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("listing gs://%s/%s: %w", r.bucket.BucketName(), prefix, err)
+		}
+		if err := fn(attrs.Name); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Registry) putJSON(ctx context.Context, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.write(ctx, name, b)
+}
+
+func (r *Registry) write(ctx context.Context, name string, b []byte) error {
+	w := r.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *Registry) getJSON(ctx context.Context, name string, v any) error {
+	b, err := r.read(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *Registry) read(ctx context.Context, name string) ([]byte, error) {
+	rc, err := r.bucket.Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Datasets is a dataset listing.
+type Datasets []*DatasetEntry
+
+// WriteText prints one row per dataset.
+func (ds Datasets) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tBUCKET\tOBJECTS\tSIZE\tSPEC HASH\tCREATED")
+	for _, d := range ds {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", d.Name, d.Bucket, d.Manifest.ObjectCount,
+			units.FormatSize(d.Manifest.TotalBytes), shortHash(d.SpecHash), d.CreatedAt.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+// Results is a result listing.
+type Results []*ResultEntry
+
+// WriteText prints one row per result.
+func (rs Results) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tTOOL\tDATASET\tHOST\tCREATED")
+	for _, r := range rs {
+		host := ""
+		if r.Env != nil {
+			host = r.Env.Hostname
+			if r.Env.MachineType != "" {
+				host += " (" + r.Env.MachineType + ")"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.RunID, r.Tool, r.Dataset, host, r.CreatedAt.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+// WriteText prints the dataset entry as key/value lines.
+func (e *DatasetEntry) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", e.Name)
+	fmt.Fprintf(tw, "Bucket:\tgs://%s\n", e.Bucket)
+	if e.Location != "" {
+		fmt.Fprintf(tw, "Location:\t%s\n", e.Location)
+	}
+	fmt.Fprintf(tw, "Objects:\t%d x %s\n", e.Manifest.ObjectCount, e.Manifest.NamePattern)
+	fmt.Fprintf(tw, "Total size:\t%s\n", units.FormatSize(e.Manifest.TotalBytes))
+	fmt.Fprintf(tw, "Spec hash:\t%s\n", e.SpecHash)
+	if e.Spec != nil {
+		b, err := json.Marshal(e.Spec)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "Spec:\t%s\n", b)
+	}
+	fmt.Fprintf(tw, "Created:\t%s\n", e.CreatedAt.Format(time.RFC3339))
+	return tw.Flush()
+}
+
+// WriteText prints the result entry followed by its environment fingerprint.
+func (e *ResultEntry) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run ID:\t%s\n", e.RunID)
+	fmt.Fprintf(tw, "Tool:\t%s\n", e.Tool)
+	if e.Dataset != "" {
+		fmt.Fprintf(tw, "Dataset:\t%s\n", e.Dataset)
+	}
+	if e.SpecHash != "" {
+		fmt.Fprintf(tw, "Workload spec:\t%s\n", e.SpecHash)
+	}
+	fmt.Fprintf(tw, "Result:\t%s\n", e.ResultObject)
+	fmt.Fprintf(tw, "Created:\t%s\n", e.CreatedAt.Format(time.RFC3339))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if e.Env == nil {
+		return nil
+	}
+	fmt.Fprintln(w, "\nEnvironment:")
+	return e.Env.WriteText(w)
+}
+
+// shortHash trims a "sha256:<hex>" hash to 12 hex digits for listings.
+func shortHash(h string) string {
+	const n = len("sha256:") + 12
+	if len(h) > n {
+		return h[:n]
+	}
+	return h
+}
@@ -0,0 +1,290 @@
+// Package releasereport assembles the qualification document of a gcsfuse
+// release candidate from the benchmark comparison, the coherence suite and
+// soak results and the known-issue list, with a verdict and a sign-off
+// block, as one HTML page.
+package releasereport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"gopkg.in/yaml.v3"
+
+	"gcsfuse-tools-cli/internal/changelog"
+	"gcsfuse-tools-cli/internal/coherence"
+	"gcsfuse-tools-cli/internal/soak"
+)
+
+// Verdicts of a report.
+const (
+	Qualified    = "qualified"
+	NotQualified = "not qualified"
+)
+
+// Known issue severities and statuses. Open blockers fail the
+// qualification.
+const (
+	SeverityBlocker = "blocker"
+	SeverityMajor   = "major"
+	SeverityMinor   = "minor"
+
+	StatusOpen      = "open"
+	StatusMitigated = "mitigated"
+	StatusFixed     = "fixed"
+)
+
+// KnownIssue is an entry of the known-issue list.
+type KnownIssue struct {
+	ID         string `yaml:"id" json:"id"`
+	Title      string `yaml:"title" json:"title"`
+	Severity   string `yaml:"severity" json:"severity"`
+	Status     string `yaml:"status" json:"status"`
+	Workaround string `yaml:"workaround,omitempty" json:"workaround,omitempty"`
+	Link       string `yaml:"link,omitempty" json:"link,omitempty"`
+}
+
+// Signoff is a person approving the release.
+type Signoff struct {
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"`
+}
+
+// ParseSignoff parses "NAME" or "NAME:ROLE".
+func ParseSignoff(s string) (Signoff, error) {
+	name, role, _ := strings.Cut(s, ":")
+	if name = strings.TrimSpace(name); name == "" {
+		return Signoff{}, fmt.Errorf("invalid sign-off %q (want NAME or NAME:ROLE)", s)
+	}
+	return Signoff{Name: name, Role: strings.TrimSpace(role)}, nil
+}
+
+// Inputs are the results a report is assembled from. Every section is
+// optional; a missing one is noted in the report.
+type Inputs struct {
+	// Release is the release candidate, e.g. v3.5.0-rc1.
+	Release string
+	// Changelog compares the benchmarks of the previous release and the
+	// candidate.
+	Changelog   *changelog.Changelog
+	Coherence   []*coherence.Result
+	Soak        *soak.History
+	KnownIssues []KnownIssue
+	Signoffs    []Signoff
+}
+
+// Report is a release qualification document.
+type Report struct {
+	Release   string    `json:"release"`
+	Generated time.Time `json:"generated"`
+	Verdict   string    `json:"verdict"`
+	// Blockers are the reasons of a NotQualified verdict.
+	Blockers []string `json:"blockers,omitempty"`
+	// Notes are caveats, e.g. sections without results.
+	Notes       []string             `json:"notes,omitempty"`
+	Changelog   *changelog.Changelog `json:"changelog,omitempty"`
+	Coherence   []*coherence.Result  `json:"coherence,omitempty"`
+	Soak        *soak.History        `json:"soak,omitempty"`
+	KnownIssues []KnownIssue         `json:"known_issues,omitempty"`
+	Signoffs    []Signoff            `json:"signoffs,omitempty"`
+	// Digest is the SHA-256 of the inputs other than the sign-offs, naming
+	// exactly what was signed off.
+	Digest string `json:"digest"`
+	// HTMLPath and PDFPath are where the document was written.
+	HTMLPath string `json:"html_path,omitempty"`
+	PDFPath  string `json:"pdf_path,omitempty"`
+}
+
+// Build assembles the report of in and decides its verdict: a regressed
+// benchmark, a failed coherence result, a failed soak round or an open
+// blocker issue each block the release.
+func Build(in Inputs) (*Report, error) {
+	r := &Report{
+		Release: in.Release, Generated: time.Now().UTC(),
+		Changelog: in.Changelog, Coherence: in.Coherence, Soak: in.Soak,
+		KnownIssues: in.KnownIssues, Signoffs: in.Signoffs,
+	}
+
+	if cl := in.Changelog; cl == nil {
+		r.Notes = append(r.Notes, "No benchmark comparison was supplied.")
+	} else {
+		for _, c := range cl.Changes {
+			if c.Verdict == changelog.Regressed {
+				r.Blockers = append(r.Blockers, fmt.Sprintf("Benchmark regression: %s %s %+.1f%% from %s.", c.Workload, c.Metric, c.Percent, cl.Base))
+			}
+		}
+		r.Notes = append(r.Notes, cl.Notes...)
+	}
+
+	if len(in.Coherence) == 0 {
+		r.Notes = append(r.Notes, "No coherence results were supplied.")
+	}
+	for _, c := range in.Coherence {
+		if !c.Passed {
+			r.Blockers = append(r.Blockers, fmt.Sprintf("Coherence failure: %s on %s, %d failure(s).", c.Tool, c.Path, c.Failures))
+		}
+		if c.Env != nil && c.Env.GcsfuseVersion != "" && in.Release != "" && !strings.Contains(c.Env.GcsfuseVersion, strings.TrimPrefix(in.Release, "v")) {
+			r.Notes = append(r.Notes, fmt.Sprintf("Coherence result %s ran with gcsfuse %s.", c.Tool, c.Env.GcsfuseVersion))
+		}
+	}
+
+	if in.Soak == nil || len(in.Soak.Rounds) == 0 {
+		r.Notes = append(r.Notes, "No soak results were supplied.")
+	} else {
+		failed := 0
+		for _, round := range in.Soak.Rounds {
+			if !round.Passed() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			r.Blockers = append(r.Blockers, fmt.Sprintf("Soak: %d of %d rounds failed.", failed, len(in.Soak.Rounds)))
+		}
+	}
+
+	for _, ki := range in.KnownIssues {
+		if ki.Severity == SeverityBlocker && ki.Status == StatusOpen {
+			r.Blockers = append(r.Blockers, fmt.Sprintf("Open blocker issue %s: %s.", ki.ID, ki.Title))
+		}
+	}
+	if len(in.Signoffs) == 0 {
+		r.Notes = append(r.Notes, "Nobody has signed off yet.")
+	}
+
+	r.Verdict = Qualified
+	if len(r.Blockers) > 0 {
+		r.Verdict = NotQualified
+	}
+	evidence := in
+	evidence.Signoffs = nil
+	b, err := json.Marshal(evidence)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	r.Digest = hex.EncodeToString(sum[:])
+	return r, nil
+}
+
+// LoadKnownIssues reads a YAML list of known issues.
+func LoadKnownIssues(path string) ([]KnownIssue, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var issues []KnownIssue
+	if err := yaml.Unmarshal(b, &issues); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, ki := range issues {
+		if ki.ID == "" || ki.Title == "" {
+			return nil, fmt.Errorf("%s: issue %d needs an id and a title", path, i)
+		}
+		if !slices.Contains([]string{SeverityBlocker, SeverityMajor, SeverityMinor}, ki.Severity) {
+			return nil, fmt.Errorf("%s: issue %s has severity %q (want blocker, major or minor)", path, ki.ID, ki.Severity)
+		}
+		if !slices.Contains([]string{StatusOpen, StatusMitigated, StatusFixed}, ki.Status) {
+			return nil, fmt.Errorf("%s: issue %s has status %q (want open, mitigated or fixed)", path, ki.ID, ki.Status)
+		}
+	}
+	return issues, nil
+}
+
+// LoadCoherence reads coherence results written with -o json.
+func LoadCoherence(paths []string) ([]*coherence.Result, error) {
+	var out []*coherence.Result
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var res coherence.Result
+		if err := json.Unmarshal(b, &res); err != nil {
+			return nil, fmt.Errorf("decoding coherence result %s: %w", p, err)
+		}
+		out = append(out, &res)
+	}
+	return out, nil
+}
+
+// LoadSoak reads the history.json of a soak report, from gs://BUCKET/OBJECT
+// or a local file.
+func LoadSoak(ctx context.Context, client *storage.Client, uri string) (*soak.History, error) {
+	b, err := read(ctx, client, uri)
+	if err != nil {
+		return nil, err
+	}
+	var h soak.History
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("decoding soak history %s: %w", uri, err)
+	}
+	return &h, nil
+}
+
+// LoadChangelog reads a perf-changelog result written with -o json.
+func LoadChangelog(path string) (*changelog.Changelog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cl changelog.Changelog
+	if err := json.Unmarshal(b, &cl); err != nil {
+		return nil, fmt.Errorf("decoding perf-changelog result %s: %w", path, err)
+	}
+	return &cl, nil
+}
+
+func read(ctx context.Context, client *storage.Client, uri string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	if !ok {
+		return os.ReadFile(uri)
+	}
+	if client == nil {
+		return nil, fmt.Errorf("reading %s: no storage client", uri)
+	}
+	bucket, object, _ := strings.Cut(rest, "/")
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", uri, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// WriteText prints the verdict, its blockers and where the document is.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Release %s: %s\n", r.Release, strings.ToUpper(r.Verdict))
+	for _, b := range r.Blockers {
+		fmt.Fprintf(w, "  BLOCKER  %s\n", b)
+	}
+	for _, n := range r.Notes {
+		fmt.Fprintf(w, "  NOTE     %s\n", n)
+	}
+	for _, p := range []string{r.HTMLPath, r.PDFPath} {
+		if p != "" {
+			fmt.Fprintf(w, "Wrote %s\n", p)
+		}
+	}
+	_, err := fmt.Fprintf(w, "Digest %s\n", r.Digest)
+	return err
+}
+
+// Validate reports inputs that cannot make a report.
+func (in *Inputs) Validate() error {
+	if in.Release == "" {
+		return errors.New("--release is required")
+	}
+	if in.Changelog == nil && len(in.Coherence) == 0 && in.Soak == nil && len(in.KnownIssues) == 0 {
+		return errors.New("no results to report: pass a benchmark comparison, coherence or soak results, or known issues")
+	}
+	return nil
+}
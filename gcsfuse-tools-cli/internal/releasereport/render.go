@@ -0,0 +1,120 @@
+package releasereport
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gcsfuse-tools-cli/internal/changelog"
+	"gcsfuse-tools-cli/internal/soak"
+)
+
+//go:embed report.html.tmpl
+var pageTemplate string
+
+// soakScenario summarizes one soak scenario over all rounds.
+type soakScenario struct {
+	Name          string
+	Rounds        int
+	Passed        int
+	LatestMiBps   float64
+	LastError     string
+	LastErrorTime string
+}
+
+// soakSummary returns the per-scenario pass counts of h.
+func soakSummary(h *soak.History) []soakScenario {
+	var out []soakScenario
+	index := map[string]int{}
+	for _, round := range h.Rounds {
+		for _, s := range round.Scenarios {
+			i, ok := index[s.Name]
+			if !ok {
+				i = len(out)
+				index[s.Name] = i
+				out = append(out, soakScenario{Name: s.Name})
+			}
+			sc := &out[i]
+			sc.Rounds++
+			if s.Passed {
+				sc.Passed++
+			} else {
+				sc.LastError, sc.LastErrorTime = s.Error, round.Start.Format("2006-01-02 15:04")
+			}
+			if s.MiBps > 0 {
+				sc.LatestMiBps = s.MiBps
+			}
+		}
+	}
+	return out
+}
+
+// RenderHTML writes the report as a self-contained HTML page.
+func RenderHTML(w io.Writer, r *Report) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"upper":   strings.ToUpper,
+		"join":    strings.Join,
+		"percent": func(f float64) float64 { return f * 100 },
+		// verdicts orders the benchmark tables.
+		"verdicts": func() []string { return []string{changelog.Regressed, changelog.Improved, changelog.Neutral} },
+		"title":    func(s string) string { return strings.ToUpper(s[:1]) + s[1:] },
+	}).Parse(pageTemplate)
+	if err != nil {
+		return err
+	}
+	var changes map[string][]changelog.Change
+	if r.Changelog != nil {
+		changes = map[string][]changelog.Change{}
+		for _, c := range r.Changelog.Changes {
+			changes[c.Verdict] = append(changes[c.Verdict], c)
+		}
+	}
+	var soakRows []soakScenario
+	var soakFailed int
+	if r.Soak != nil {
+		soakRows = soakSummary(r.Soak)
+		for _, round := range r.Soak.Rounds {
+			if !round.Passed() {
+				soakFailed++
+			}
+		}
+	}
+	return tmpl.Execute(w, struct {
+		*Report
+		Changes    map[string][]changelog.Change
+		SoakRows   []soakScenario
+		SoakFailed int
+	}{r, changes, soakRows, soakFailed})
+}
+
+// WriteHTML renders the report to path.
+func WriteHTML(r *Report, path string) error {
+	var b bytes.Buffer
+	if err := RenderHTML(&b, r); err != nil {
+		return fmt.Errorf("rendering the report: %w", err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	r.HTMLPath = path
+	return nil
+}
+
+// WritePDF prints the HTML document of r to pdfPath with converter, a
+// command called as CONVERTER HTML PDF, e.g. wkhtmltopdf.
+func WritePDF(ctx context.Context, r *Report, converter, pdfPath string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, converter, r.HTMLPath, pdfPath)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("converting %s to PDF with %s: %w: %s", r.HTMLPath, converter, err, strings.TrimSpace(stderr.String()))
+	}
+	r.PDFPath = pdfPath
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package repro writes and reads reproducibility bundles: a tar.gz holding
+// the command line, input files, seeds, dataset identity, environment
+// fingerprint and tool versions of a benchmark run, from which the run can be
+// replayed.
+package repro
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// FormatVersion is the version of the bundle layout.
+const FormatVersion = 1
+
+// manifestName is the name of the manifest in the bundle.
+const manifestName = "manifest.json"
+
+// File is an input file of the run, stored in the bundle.
+type File struct {
+	// Flag is the flag that named the file, without dashes.
+	Flag string `json:"flag"`
+	// Original is the path the run read it from and Name its path in the
+	// bundle.
+	Original string `json:"original"`
+	Name     string `json:"name"`
+	SHA256   string `json:"sha256"`
+}
+
+// Dataset identifies the dataset a run read.
+type Dataset struct {
+	Name string `json:"name"`
+	// SpecHash and ManifestHash are empty when the dataset was not looked
+	// up in a registry.
+	SpecHash     string `json:"spec_hash,omitempty"`
+	ManifestHash string `json:"manifest_hash,omitempty"`
+}
+
+// Manifest describes a bundled run.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Command is the subcommand path, e.g. "bench fio", and Args its flags
+	// as "--name=value", with input files pointing at their originals.
+	Command string               `json:"command"`
+	Args    []string             `json:"args"`
+	Files   []File               `json:"files,omitempty"`
+	Seeds   map[string]uint64    `json:"seeds,omitempty"`
+	Dataset *Dataset             `json:"dataset,omitempty"`
+	Env     *envinfo.Fingerprint `json:"env,omitempty"`
+	// Tools are the versions of gcsfuse-tools, Go and the benchmarked tools.
+	Tools map[string]string `json:"tools"`
+	// Result is the result of the bundled run, to compare the replay with.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// ToolVersion returns the version of the running gcsfuse-tools binary: its
+// module version and VCS revision when built from a checkout.
+func ToolVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := bi.Main.Version
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v += " " + s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				v += " (modified)"
+			}
+		}
+	}
+	return v
+}
+
+// NewManifest returns a manifest for command with the versions of
+// gcsfuse-tools and Go filled in.
+func NewManifest(command string, args []string) *Manifest {
+	return &Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Command:       command,
+		Args:          args,
+		Tools:         map[string]string{"gcsfuse-tools": ToolVersion(), "go": runtime.Version()},
+	}
+}
+
+// AddFile records the file named by flag for inclusion in the bundle.
+func (m *Manifest) AddFile(flag, original string) {
+	name := fmt.Sprintf("files/%d-%s", len(m.Files), filepath.Base(original))
+	m.Files = append(m.Files, File{Flag: flag, Original: original, Name: name})
+}
+
+// Write writes the bundle to dst: the manifest and the files added with
+// AddFile, whose checksums it fills in.
+func Write(dst string, m *Manifest) (err error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for i, f := range m.Files {
+		b, err := os.ReadFile(f.Original)
+		if err != nil {
+			return fmt.Errorf("bundling --%s: %w", f.Flag, err)
+		}
+		sum := sha256.Sum256(b)
+		m.Files[i].SHA256 = hex.EncodeToString(sum[:])
+		if err := writeEntry(tw, f.Name, b); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifestName, b); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, b []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// Extract unpacks the bundle src into dir, verifies the files against their
+// checksums and returns the manifest.
+func Extract(src, dir string) (*Manifest, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", src, err)
+	}
+	tr := tar.NewReader(gz)
+	var m *Manifest
+	sums := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", src, err)
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s: unexpected entry %q", src, hdr.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", src, err)
+		}
+		if name == manifestName {
+			m = &Manifest{}
+			if err := json.Unmarshal(b, m); err != nil {
+				return nil, fmt.Errorf("%s: decoding manifest: %w", src, err)
+			}
+			continue
+		}
+		sum := sha256.Sum256(b)
+		sums[name] = hex.EncodeToString(sum[:])
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dst, b, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s: no %s, not a reproducibility bundle", src, manifestName)
+	}
+	if m.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%s: bundle format %d is newer than the supported %d", src, m.FormatVersion, FormatVersion)
+	}
+	for _, f := range m.Files {
+		if sums[f.Name] != f.SHA256 {
+			return nil, fmt.Errorf("%s: %s is missing or does not match its checksum", src, f.Name)
+		}
+	}
+	return m, nil
+}
+
+// ReplayArgs returns the subcommand path and flags that replay the run, with
+// input files read from the bundle extracted to dir.
+func (m *Manifest) ReplayArgs(dir string) []string {
+	args := strings.Fields(m.Command)
+	for _, a := range m.Args {
+		for _, f := range m.Files {
+			if name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "="); name == f.Flag {
+				a = "--" + f.Flag + "=" + filepath.Join(dir, filepath.FromSlash(f.Name))
+			}
+		}
+		args = append(args, a)
+	}
+	return args
+}
+
+// Differences lists how the environment cur differs from the bundled one in
+// the properties that affect performance.
+func (m *Manifest) Differences(cur *envinfo.Fingerprint) []string {
+	if m.Env == nil || cur == nil {
+		return nil
+	}
+	var diffs []string
+	for _, p := range []struct{ name, was, now string }{
+		{"machine type", m.Env.MachineType, cur.MachineType},
+		{"zone", m.Env.Zone, cur.Zone},
+		{"kernel", m.Env.Kernel, cur.Kernel},
+		{"OS", m.Env.OS, cur.OS},
+		{"arch", m.Env.Arch, cur.Arch},
+		{"gcsfuse version", m.Env.GcsfuseVersion, cur.GcsfuseVersion},
+		{"CPUs", fmt.Sprint(m.Env.NumCPU), fmt.Sprint(cur.NumCPU)},
+	} {
+		if p.was != p.now {
+			diffs = append(diffs, fmt.Sprintf("%s was %q, is %q", p.name, p.was, p.now))
+		}
+	}
+	if v := ToolVersion(); m.Tools["gcsfuse-tools"] != v {
+		diffs = append(diffs, fmt.Sprintf("gcsfuse-tools was %q, is %q", m.Tools["gcsfuse-tools"], v))
+	}
+	return diffs
+}
@@ -0,0 +1,158 @@
+// Package selfupdate replaces the running gcsfuse-tools binary with a release
+// from the artifacts bucket that make publish writes:
+//
+//	gcsfuse-tools/latest                                 the latest version
+//	gcsfuse-tools/<version>/gcsfuse-tools-linux-<arch>   the binary
+//	gcsfuse-tools/<version>/gcsfuse-tools-linux-<arch>.sha256
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const prefix = "gcsfuse-tools/"
+
+// Config holds the self-update options.
+type Config struct {
+	Bucket string
+	// Version is the release to install; empty means the latest.
+	Version string
+	// Current is the version of the running binary.
+	Current string
+	// Check only reports whether an update is available.
+	Check bool
+	// Path is the binary to replace; empty means the running executable.
+	Path string
+}
+
+// Validate reports missing options.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("--bucket is required")
+	}
+	return nil
+}
+
+// Result is the outcome of a self-update.
+type Result struct {
+	Current string `json:"current"`
+	Target  string `json:"target"`
+	Path    string `json:"path"`
+	Object  string `json:"object"`
+	Updated bool   `json:"updated"`
+}
+
+// Run resolves the target version and, unless it is the current one or
+// cfg.Check is set, downloads it, verifies its checksum and atomically
+// replaces the binary.
+func Run(ctx context.Context, client *storage.Client, cfg Config) (*Result, error) {
+	bucket := client.Bucket(cfg.Bucket)
+	target := cfg.Version
+	if target == "" {
+		b, err := read(ctx, bucket.Object(prefix+"latest"))
+		if err != nil {
+			return nil, err
+		}
+		if target = strings.TrimSpace(string(b)); target == "" {
+			return nil, fmt.Errorf("gs://%s/%slatest is empty", cfg.Bucket, prefix)
+		}
+	}
+	name := fmt.Sprintf("%s%s/gcsfuse-tools-%s-%s", prefix, target, runtime.GOOS, runtime.GOARCH)
+	r := &Result{Current: cfg.Current, Target: target, Path: cfg.Path, Object: "gs://" + cfg.Bucket + "/" + name}
+	if r.Path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		if r.Path, err = filepath.EvalSymlinks(exe); err != nil {
+			return nil, err
+		}
+	}
+	if target == cfg.Current || cfg.Check {
+		return r, nil
+	}
+
+	sum, err := read(ctx, bucket.Object(name+".sha256"))
+	if err != nil {
+		return nil, err
+	}
+	want, _, _ := strings.Cut(strings.TrimSpace(string(sum)), " ")
+	slog.Info("Downloading release", "version", target, "object", r.Object)
+	bin, err := read(ctx, bucket.Object(name))
+	if err != nil {
+		return nil, err
+	}
+	got := sha256.Sum256(bin)
+	if hex.EncodeToString(got[:]) != want {
+		return nil, fmt.Errorf("checksum of %s is %x, want %s", r.Object, got, want)
+	}
+	if err := replace(r.Path, bin); err != nil {
+		return nil, err
+	}
+	r.Updated = true
+	return r, nil
+}
+
+func read(ctx context.Context, obj *storage.ObjectHandle) ([]byte, error) {
+	rd, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading gs://%s/%s: %w", obj.BucketName(), obj.ObjectName(), err)
+	}
+	defer rd.Close()
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, rd); err != nil {
+		return nil, fmt.Errorf("reading gs://%s/%s: %w", obj.BucketName(), obj.ObjectName(), err)
+	}
+	return b.Bytes(), nil
+}
+
+// replace writes bin next to path and renames it over path, so a running
+// copy keeps its inode and an interrupted update leaves the old binary.
+func replace(path string, bin []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".gcsfuse-tools-update-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bin)
+	if err == nil {
+		err = f.Chmod(0o755)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteText prints what the update did.
+func (r *Result) WriteText(w io.Writer) error {
+	var err error
+	switch {
+	case r.Updated:
+		_, err = fmt.Fprintf(w, "Updated %s from %s to %s.\n", r.Path, r.Current, r.Target)
+	case r.Target == r.Current:
+		_, err = fmt.Fprintf(w, "%s is already at %s.\n", r.Path, r.Current)
+	default:
+		_, err = fmt.Fprintf(w, "%s is at %s; %s is available at %s.\n", r.Path, r.Current, r.Target, r.Object)
+	}
+	return err
+}
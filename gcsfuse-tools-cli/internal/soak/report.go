@@ -0,0 +1,173 @@
+package soak
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+//go:embed report.html.tmpl
+var pageTemplate string
+
+// History is the list of published rounds, oldest first. It is stored next to
+// the page as history.json.
+type History struct {
+	Rounds []*Round `json:"rounds"`
+}
+
+// Publisher appends rounds to the history in a GCS prefix and re-renders the
+// static index.html there.
+type Publisher struct {
+	Bucket *storage.BucketHandle
+	Prefix string
+	// KeepRounds bounds the history length.
+	KeepRounds int
+}
+
+func (p *Publisher) object(name string) *storage.ObjectHandle {
+	return p.Bucket.Object(strings.TrimSuffix(p.Prefix, "/") + "/" + name)
+}
+
+// Publish adds r to the history and rewrites the page.
+func (p *Publisher) Publish(ctx context.Context, r *Round) error {
+	h := &History{}
+	rc, err := p.object("history.json").NewReader(ctx)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+	case err != nil:
+		return fmt.Errorf("reading history: %w", err)
+	default:
+		err = json.NewDecoder(rc).Decode(h)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("decoding history: %w", err)
+		}
+	}
+
+	h.Rounds = append(h.Rounds, r)
+	if p.KeepRounds > 0 && len(h.Rounds) > p.KeepRounds {
+		h.Rounds = h.Rounds[len(h.Rounds)-p.KeepRounds:]
+	}
+
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := p.write(ctx, "history.json", "application/json", b); err != nil {
+		return err
+	}
+	var page bytes.Buffer
+	if err := RenderPage(&page, h); err != nil {
+		return err
+	}
+	if err := p.write(ctx, "index.html", "text/html; charset=utf-8", page.Bytes()); err != nil {
+		return err
+	}
+	slog.Info("Published soak report", "url", fmt.Sprintf("https://storage.googleapis.com/%s/%s", p.Bucket.BucketName(), p.object("index.html").ObjectName()))
+	return nil
+}
+
+func (p *Publisher) write(ctx context.Context, name, contentType string, b []byte) error {
+	w := p.object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	// The page must not be served stale right after a round.
+	w.CacheControl = "no-cache"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// trend is the per-scenario row of the page.
+type trend struct {
+	Name string
+	// Cells holds one entry per round, oldest first; nil when the scenario
+	// did not run in that round.
+	Cells []*ScenarioResult
+	// Spark is the SVG polyline of the bandwidth trend, empty when the
+	// scenario reports no bandwidth.
+	Spark  template.HTML
+	Latest float64
+}
+
+// RenderPage writes the HTML trend page for h.
+func RenderPage(w io.Writer, h *History) error {
+	var names []string
+	for _, r := range h.Rounds {
+		for _, s := range r.Scenarios {
+			if !slices.Contains(names, s.Name) {
+				names = append(names, s.Name)
+			}
+		}
+	}
+
+	var trends []trend
+	for _, name := range names {
+		t := trend{Name: name}
+		var bw []float64
+		for _, r := range h.Rounds {
+			var cell *ScenarioResult
+			for i := range r.Scenarios {
+				if r.Scenarios[i].Name == name {
+					cell = &r.Scenarios[i]
+				}
+			}
+			t.Cells = append(t.Cells, cell)
+			if cell != nil && cell.MiBps > 0 {
+				bw = append(bw, cell.MiBps)
+				t.Latest = cell.MiBps
+			}
+		}
+		t.Spark = sparkline(bw)
+		trends = append(trends, t)
+	}
+
+	tmpl, err := template.New("page").Parse(pageTemplate)
+	if err != nil {
+		return err
+	}
+	var latest *Round
+	if len(h.Rounds) > 0 {
+		latest = h.Rounds[len(h.Rounds)-1]
+	}
+	return tmpl.Execute(w, struct {
+		Latest *Round
+		Rounds []*Round
+		Trends []trend
+	}{latest, h.Rounds, trends})
+}
+
+// sparkline renders values as an inline SVG polyline.
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+	const width, height = 240.0, 40.0
+	lo, hi := slices.Min(values), slices.Max(values)
+	if hi == lo {
+		hi = lo + 1
+	}
+	var pts []string
+	for i, v := range values {
+		x := float64(i) * width / float64(len(values)-1)
+		y := height - (v-lo)/(hi-lo)*height
+		pts = append(pts, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg width="%.0f" height="%.0f"><polyline fill="none" stroke="#1a73e8" stroke-width="2" points="%s"/></svg>`,
+		width, height, strings.Join(pts, " ")))
+}
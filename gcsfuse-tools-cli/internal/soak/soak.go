@@ -0,0 +1,278 @@
+// Package soak runs a fixed set of coherence scenarios and light benchmarks
+// over long periods and publishes a pass/fail and performance trend page.
+//
+// Each scenario is a gcsfuse-tools command line. It is run as a child process
+// with -o json so a crashing scenario cannot take the soak run down with it.
+package soak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gcsfuse-tools-cli/internal/doctor"
+)
+
+// Scenario is one command run in every round.
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Args is the gcsfuse-tools command line without the binary, e.g.
+	// ["coherence", "write", "--path=/mnt/gcs/soak/f", "--size=1048576"].
+	Args []string `yaml:"args"`
+}
+
+// Report selects where the trend page is published.
+type Report struct {
+	Bucket string `yaml:"bucket"`
+	Prefix string `yaml:"prefix"`
+	// KeepRounds bounds the history shown on the page.
+	KeepRounds int `yaml:"keep_rounds"`
+}
+
+// Config is the soak configuration file.
+//
+// Without Every or Duration a single round is run. Duration repeats rounds back
+// to back until it has elapsed (one long run); Every starts a round at that
+// interval until Rounds is reached, or forever when Rounds is 0.
+type Config struct {
+	Every    time.Duration `yaml:"every"`
+	Duration time.Duration `yaml:"duration"`
+	Rounds   int           `yaml:"rounds"`
+	// Retries is the number of times a scenario is restarted after an
+	// environmental failure. Scenario failures are never retried.
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	Report     Report        `yaml:"report"`
+	Scenarios  []Scenario    `yaml:"scenarios"`
+}
+
+// LoadConfig reads and validates a YAML soak configuration.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Retries: 2, RetryDelay: time.Minute, Report: Report{KeepRounds: 60}}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(cfg.Scenarios) == 0 {
+		return nil, fmt.Errorf("%s: no scenarios", path)
+	}
+	for i, s := range cfg.Scenarios {
+		if s.Name == "" || len(s.Args) == 0 {
+			return nil, fmt.Errorf("%s: scenario %d needs a name and args", path, i)
+		}
+	}
+	return cfg, nil
+}
+
+// ScenarioResult is the outcome of one scenario in one round.
+type ScenarioResult struct {
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Attempts int     `json:"attempts"`
+	Error    string  `json:"error,omitempty"`
+	Seconds  float64 `json:"seconds"`
+	// MiBps is the aggregate bandwidth reported by benchmark scenarios.
+	MiBps float64 `json:"mibps,omitempty"`
+}
+
+// Round is one pass over all scenarios.
+type Round struct {
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Host      string           `json:"host"`
+	Scenarios []ScenarioResult `json:"scenarios"`
+}
+
+// Passed reports whether every scenario of the round passed.
+func (r *Round) Passed() bool {
+	for _, s := range r.Scenarios {
+		if !s.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Runner executes rounds and hands each finished round to Publish.
+type Runner struct {
+	Config *Config
+	// Executable is the gcsfuse-tools binary scenarios are run with.
+	Executable string
+	// GlobalArgs are passed to every scenario before its args, e.g. the
+	// --project and --registry-bucket soak was run with.
+	GlobalArgs []string
+	// Publish, when set, is called after every round.
+	Publish func(ctx context.Context, r *Round) error
+}
+
+// Run runs rounds as scheduled by the config until it is done or ctx is
+// cancelled. It returns the number of rounds that had failing scenarios.
+func (rn *Runner) Run(ctx context.Context) (failedRounds int, err error) {
+	cfg := rn.Config
+	start := time.Now()
+	for n := 1; ; n++ {
+		roundStart := time.Now()
+		slog.Info("Starting soak round", "round", n)
+		r := rn.runRound(ctx)
+		if !r.Passed() {
+			failedRounds++
+		}
+		if rn.Publish != nil {
+			if err := rn.Publish(ctx, r); err != nil {
+				slog.Error("Publishing soak report failed", "err", err)
+			}
+		}
+		if ctx.Err() != nil {
+			return failedRounds, ctx.Err()
+		}
+		if cfg.Rounds > 0 && n >= cfg.Rounds {
+			return failedRounds, nil
+		}
+
+		switch {
+		case cfg.Every > 0:
+			if err := sleep(ctx, time.Until(roundStart.Add(cfg.Every))); err != nil {
+				return failedRounds, err
+			}
+		case cfg.Duration > 0:
+			if time.Since(start) >= cfg.Duration {
+				return failedRounds, nil
+			}
+		default:
+			return failedRounds, nil
+		}
+	}
+}
+
+func (rn *Runner) runRound(ctx context.Context) *Round {
+	r := &Round{Start: time.Now().UTC()}
+	r.Host, _ = os.Hostname()
+	for _, s := range rn.Config.Scenarios {
+		if ctx.Err() != nil {
+			break
+		}
+		res := rn.runScenario(ctx, s)
+		slog.Info("Scenario finished", "name", s.Name, "passed", res.Passed, "attempts", res.Attempts, "error", res.Error)
+		r.Scenarios = append(r.Scenarios, res)
+	}
+	r.End = time.Now().UTC()
+	return r
+}
+
+// runScenario runs s, restarting it while its failures are environmental.
+func (rn *Runner) runScenario(ctx context.Context, s Scenario) ScenarioResult {
+	res := ScenarioResult{Name: s.Name}
+	for {
+		res.Attempts++
+		start := time.Now()
+		stdout, err := rn.exec(ctx, s.Args)
+		res.Seconds = time.Since(start).Seconds()
+		if err == nil {
+			res.Passed, res.Error = true, ""
+			res.MiBps = bandwidth(stdout)
+			return res
+		}
+		res.Error = err.Error()
+
+		reason := environmental(ctx, err)
+		if reason == "" || res.Attempts > rn.Config.Retries || ctx.Err() != nil {
+			return res
+		}
+		slog.Warn("Environmental failure, restarting scenario", "name", s.Name, "reason", reason, "delay", rn.Config.RetryDelay)
+		if sleep(ctx, rn.Config.RetryDelay) != nil {
+			return res
+		}
+	}
+}
+
+// exec runs the scenario command line and returns its stdout. The error
+// carries the last stderr line, which is where the tools report failures.
+func (rn *Runner) exec(ctx context.Context, args []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	argv := append(append([]string{"-o", "json"}, rn.GlobalArgs...), args...)
+	cmd := exec.CommandContext(ctx, rn.Executable, argv...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if line := lastLine(stderr.String()); line != "" {
+			err = fmt.Errorf("%w: %s", err, line)
+		}
+	}
+	return stdout.Bytes(), err
+}
+
+// environmental returns why a failure should be blamed on the host rather than
+// on gcsfuse, or "" if it should not. A scenario killed by a signal (OOM
+// killer, preemption) or one that fails while the host itself fails the
+// doctor checks is environmental.
+func environmental(ctx context.Context, err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == -1 {
+		return "killed: " + exitErr.String()
+	}
+	if report := doctor.Run(ctx, doctor.Config{}); report.Failed() {
+		for _, c := range report.Checks {
+			if c.Status == doctor.Fail {
+				return c.Name + ": " + c.Detail
+			}
+		}
+	}
+	return ""
+}
+
+// bandwidth sums the read and write bandwidth of a bench fio result in MiB/s.
+// Other outputs yield 0.
+func bandwidth(stdout []byte) float64 {
+	var res struct {
+		Jobs []struct {
+			Read, Write *struct {
+				BwKiBps float64 `json:"bw_kibps"`
+			}
+		} `json:"jobs"`
+	}
+	if json.Unmarshal(stdout, &res) != nil {
+		return 0
+	}
+	var kib float64
+	for _, j := range res.Jobs {
+		if j.Read != nil {
+			kib += j.Read.BwKiBps
+		}
+		if j.Write != nil {
+			kib += j.Write.BwKiBps
+		}
+	}
+	return kib / 1024
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
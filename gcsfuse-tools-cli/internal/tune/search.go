@@ -0,0 +1,203 @@
+package tune
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Search strategies.
+const (
+	StrategyGrid  = "grid"
+	StrategyBayes = "bayes"
+)
+
+// space is the cartesian product of the parameter values. A point holds one
+// value index per parameter.
+type space struct {
+	params []Param
+}
+
+func newSpace(params []Param) *space {
+	return &space{params: params}
+}
+
+func (s *space) size() int {
+	n := 1
+	for _, p := range s.params {
+		n *= len(p.Values)
+	}
+	return n
+}
+
+// point returns the i-th point in mixed-radix order.
+func (s *space) point(i int) []int {
+	pt := make([]int, len(s.params))
+	for j := len(s.params) - 1; j >= 0; j-- {
+		n := len(s.params[j].Values)
+		pt[j] = i % n
+		i /= n
+	}
+	return pt
+}
+
+func (s *space) flags(pt []int) []string {
+	out := make([]string, len(pt))
+	for j, v := range pt {
+		out[j] = fmt.Sprintf("--%s=%s", s.params[j].Flag, s.params[j].Values[v])
+	}
+	return out
+}
+
+// coords maps a point into the unit cube, treating each parameter's values as
+// ordered.
+func (s *space) coords(pt []int) []float64 {
+	c := make([]float64, len(pt))
+	for j, v := range pt {
+		if n := len(s.params[j].Values); n > 1 {
+			c[j] = float64(v) / float64(n-1)
+		}
+	}
+	return c
+}
+
+// strategy proposes the next point to measure.
+type strategy interface {
+	next() ([]int, bool)
+	observe(pt []int, t *Trial)
+}
+
+// grid visits every point in order.
+type grid struct {
+	space *space
+	i     int
+}
+
+func (g *grid) next() ([]int, bool) {
+	if g.i >= g.space.size() {
+		return nil, false
+	}
+	g.i++
+	return g.space.point(g.i - 1), true
+}
+
+func (g *grid) observe([]int, *Trial) {}
+
+const (
+	// bayesInit is the number of random points measured before the
+	// surrogate is used.
+	bayesInit = 3
+	// bayesLengthScale is the kernel width in unit-cube coordinates.
+	bayesLengthScale = 0.3
+	// bayesKappa weighs uncertainty against the predicted value.
+	bayesKappa = 1.5
+)
+
+// bayes is a small Bayesian optimizer: a Gaussian-kernel regression over the
+// measured points serves as the surrogate and the upper confidence bound picks
+// the unvisited point to measure next. It is meant for the handful of trials a
+// mount-and-fio loop can afford, not for large spaces.
+type bayes struct {
+	space    *space
+	minimize bool
+	visited  map[int]bool
+	xs       [][]float64
+	ys       []float64
+	rng      *rand.Rand
+}
+
+func newBayes(s *space, minimize bool) *bayes {
+	return &bayes{space: s, minimize: minimize, visited: map[int]bool{}, rng: rand.New(rand.NewPCG(1, 2))}
+}
+
+func (b *bayes) index(pt []int) int {
+	i := 0
+	for j, v := range pt {
+		i = i*len(b.space.params[j].Values) + v
+	}
+	return i
+}
+
+func (b *bayes) next() ([]int, bool) {
+	size := b.space.size()
+	if len(b.visited) >= size {
+		return nil, false
+	}
+	if len(b.ys) < bayesInit {
+		for {
+			i := b.rng.IntN(size)
+			if !b.visited[i] {
+				b.visited[i] = true
+				return b.space.point(i), true
+			}
+		}
+	}
+
+	// Standardize the observations so kappa means the same for every metric.
+	mean, std := meanStd(b.ys)
+	best, bestScore := -1, math.Inf(-1)
+	for i := 0; i < size; i++ {
+		if b.visited[i] {
+			continue
+		}
+		x := b.space.coords(b.space.point(i))
+		var wsum, wy float64
+		for k, xk := range b.xs {
+			w := math.Exp(-sqDist(x, xk) / (2 * bayesLengthScale * bayesLengthScale))
+			wsum += w
+			wy += w * (b.ys[k] - mean) / std
+		}
+		mu := 0.0
+		if wsum > 1e-9 {
+			mu = wy / wsum
+		}
+		score := mu + bayesKappa/math.Sqrt(1+wsum)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	b.visited[best] = true
+	return b.space.point(best), true
+}
+
+// observe records a measurement. Values are negated when minimizing so the
+// optimizer always maximizes; failed trials count as the worst value seen.
+func (b *bayes) observe(pt []int, t *Trial) {
+	y := t.Value
+	if b.minimize {
+		y = -y
+	}
+	if t.Error != "" {
+		if len(b.ys) == 0 {
+			return
+		}
+		y = slices.Min(b.ys)
+	}
+	b.xs = append(b.xs, b.space.coords(pt))
+	b.ys = append(b.ys, y)
+}
+
+func meanStd(ys []float64) (float64, float64) {
+	var sum, sq float64
+	for _, y := range ys {
+		sum += y
+	}
+	mean := sum / float64(len(ys))
+	for _, y := range ys {
+		sq += (y - mean) * (y - mean)
+	}
+	std := math.Sqrt(sq / float64(len(ys)))
+	if std == 0 {
+		std = 1
+	}
+	return mean, std
+}
+
+func sqDist(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
@@ -0,0 +1,264 @@
+// Package tune searches gcsfuse flag settings for the configuration that
+// maximizes (or minimizes) a benchmark objective.
+//
+// Every trial remounts the bucket with the candidate flags and runs the same
+// fio jobfile through package bench. The first trial always measures the
+// gcsfuse defaults so the best configuration is reported with its
+// improvement over them.
+package tune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gcsfuse-tools-cli/internal/bench"
+	"gcsfuse-tools-cli/internal/envinfo"
+)
+
+// Param is a gcsfuse flag and the values to try for it.
+type Param struct {
+	Flag   string   `json:"flag"`
+	Values []string `json:"values"`
+}
+
+// ParseParam parses "flag=v1,v2,...", e.g.
+// "file-cache-max-size-mb=0,1024,-1". Leading dashes on the flag are ignored.
+func ParseParam(s string) (Param, error) {
+	flag, values, ok := strings.Cut(s, "=")
+	flag = strings.TrimLeft(flag, "-")
+	if !ok || flag == "" || values == "" {
+		return Param{}, fmt.Errorf("invalid --param %q, want flag=value1,value2", s)
+	}
+	return Param{Flag: flag, Values: strings.Split(values, ",")}, nil
+}
+
+// Objective is the metric a trial is scored by.
+type Objective string
+
+// Supported objectives.
+const (
+	ReadBandwidth  Objective = "read-bw"
+	WriteBandwidth Objective = "write-bw"
+	ReadP99Latency Objective = "read-p99-lat"
+)
+
+// ParseObjective validates a --objective value.
+func ParseObjective(s string) (Objective, error) {
+	switch o := Objective(s); o {
+	case ReadBandwidth, WriteBandwidth, ReadP99Latency:
+		return o, nil
+	}
+	return "", fmt.Errorf("unsupported objective %q (want read-bw, write-bw or read-p99-lat)", s)
+}
+
+// Minimize reports whether lower values of o are better.
+func (o Objective) Minimize() bool {
+	return o == ReadP99Latency
+}
+
+// Unit is the unit of the objective's value.
+func (o Objective) Unit() string {
+	if o == ReadP99Latency {
+		return "ms"
+	}
+	return "MiB/s"
+}
+
+// Value extracts the objective from a bench result: the summed bandwidth of
+// all jobs, or the worst p99 completion latency.
+func (o Objective) Value(r *bench.Result) (float64, error) {
+	var v float64
+	for _, j := range r.Jobs {
+		if j.Error != 0 {
+			return 0, fmt.Errorf("fio job %s failed with error %d", j.Name, j.Error)
+		}
+		switch o {
+		case ReadBandwidth:
+			if j.Read != nil {
+				v += j.Read.BwKiBps / 1024
+			}
+		case WriteBandwidth:
+			if j.Write != nil {
+				v += j.Write.BwKiBps / 1024
+			}
+		case ReadP99Latency:
+			if j.Read != nil {
+				v = max(v, j.Read.P99ClatNs/1e6)
+			}
+		}
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("the jobfile reported no %s", o)
+	}
+	return v, nil
+}
+
+// Config describes a tuning run.
+type Config struct {
+	Bench     bench.Config
+	Params    []Param
+	Objective Objective
+	Strategy  string
+	// Budget bounds the number of trials after the baseline. Grid search
+	// stops early when the budget is exhausted; 0 means the full grid.
+	Budget int
+	// Repeat runs every trial this many times and scores the mean.
+	Repeat int
+}
+
+// Validate reports missing or inconsistent options.
+func (c *Config) Validate() error {
+	if err := c.Bench.Validate(); err != nil {
+		return err
+	}
+	if c.Bench.Target != bench.TargetGcsfuse {
+		return errors.New("tune only supports the gcsfuse target")
+	}
+	if c.Bench.Bucket == "" {
+		return errors.New("--bucket is required: every trial remounts it with different flags")
+	}
+	if len(c.Params) == 0 {
+		return errors.New("at least one --param is required")
+	}
+	switch c.Strategy {
+	case StrategyGrid:
+	case StrategyBayes:
+		if c.Budget <= 0 {
+			return errors.New("--budget is required for the bayes strategy")
+		}
+	default:
+		return fmt.Errorf("unsupported --strategy %q (want grid or bayes)", c.Strategy)
+	}
+	if c.Repeat <= 0 {
+		return errors.New("--repeat must be greater than 0")
+	}
+	return nil
+}
+
+// Trial is one measured configuration.
+type Trial struct {
+	// Flags are the tuned flags on top of the base gcsfuse flags; empty for
+	// the baseline.
+	Flags []string `json:"flags"`
+	Value float64  `json:"value,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Result is the outcome of a tuning run.
+type Result struct {
+	Objective Objective `json:"objective"`
+	Unit      string    `json:"unit"`
+	Strategy  string    `json:"strategy"`
+	Baseline  *Trial    `json:"baseline"`
+	Best      *Trial    `json:"best"`
+	// Improvement is the relative gain of Best over Baseline, positive when
+	// Best is better.
+	Improvement float64  `json:"improvement"`
+	Trials      []*Trial `json:"trials"`
+	// Env is the fingerprint captured during the baseline.
+	Env *envinfo.Fingerprint `json:"env"`
+}
+
+// Run measures the baseline and then the candidates proposed by the strategy.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	space := newSpace(cfg.Params)
+	res := &Result{Objective: cfg.Objective, Unit: cfg.Objective.Unit(), Strategy: cfg.Strategy}
+
+	res.Baseline, res.Env = runTrial(ctx, cfg, nil)
+	if res.Baseline.Error != "" {
+		return nil, fmt.Errorf("baseline failed: %s", res.Baseline.Error)
+	}
+
+	var s strategy
+	if cfg.Strategy == StrategyGrid {
+		s = &grid{space: space}
+	} else {
+		s = newBayes(space, cfg.Objective.Minimize())
+	}
+	for n := 0; cfg.Budget <= 0 || n < cfg.Budget; n++ {
+		point, ok := s.next()
+		if !ok {
+			break
+		}
+		t, _ := runTrial(ctx, cfg, space.flags(point))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		res.Trials = append(res.Trials, t)
+		s.observe(point, t)
+		if t.Error == "" && (res.Best == nil || cfg.Objective.better(t.Value, res.Best.Value)) {
+			res.Best = t
+		}
+		slog.Info("Trial finished", "n", n+1, "flags", t.Flags, "value", t.Value, "error", t.Error)
+	}
+
+	if res.Best == nil || !cfg.Objective.better(res.Best.Value, res.Baseline.Value) {
+		res.Best = res.Baseline
+	}
+	res.Improvement = (res.Best.Value - res.Baseline.Value) / res.Baseline.Value
+	if cfg.Objective.Minimize() {
+		res.Improvement = -res.Improvement
+	}
+	return res, nil
+}
+
+func (o Objective) better(a, b float64) bool {
+	if o.Minimize() {
+		return a < b
+	}
+	return a > b
+}
+
+// runTrial mounts with the base flags plus flags and scores cfg.Repeat runs.
+// It also returns the fingerprint of the last run.
+func runTrial(ctx context.Context, cfg Config, flags []string) (*Trial, *envinfo.Fingerprint) {
+	t := &Trial{Flags: flags}
+	bc := cfg.Bench
+	bc.GcsfuseFlags = append(slices.Clone(cfg.Bench.GcsfuseFlags), flags...)
+	var sum float64
+	var env *envinfo.Fingerprint
+	for i := 0; i < cfg.Repeat; i++ {
+		r, err := bench.Run(ctx, bc)
+		if err == nil {
+			env = r.Env
+			var v float64
+			if v, err = cfg.Objective.Value(r); err == nil {
+				sum += v
+				continue
+			}
+		}
+		t.Error = err.Error()
+		return t, env
+	}
+	t.Value = sum / float64(cfg.Repeat)
+	return t, env
+}
+
+// WriteText prints the best configuration followed by all trials.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Baseline (gcsfuse defaults): %.2f %s\n", r.Baseline.Value, r.Unit)
+	fmt.Fprintf(w, "Best: %.2f %s (%+.1f%%) with %s\n\n", r.Best.Value, r.Unit, r.Improvement*100, flagsText(r.Best.Flags))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "#\t%s (%s)\tFLAGS\n", strings.ToUpper(string(r.Objective)), r.Unit)
+	for i, t := range r.Trials {
+		value := fmt.Sprintf("%.2f", t.Value)
+		if t.Error != "" {
+			value = "error: " + t.Error
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, value, flagsText(t.Flags))
+	}
+	return tw.Flush()
+}
+
+func flagsText(flags []string) string {
+	if len(flags) == 0 {
+		return "the defaults"
+	}
+	return strings.Join(flags, " ")
+}
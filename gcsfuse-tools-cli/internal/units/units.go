@@ -0,0 +1,63 @@
+// Package units parses and formats the human-readable sizes used in flags,
+// e.g. "4K", "128M" or "10G".
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB int64 = 1 << (10 * (iota + 1))
+	MiB
+	GiB
+	TiB
+)
+
+// ParseSize parses a string size like "1K", "10M", "1G" into bytes. A bare
+// number is taken as bytes.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	numStr := s
+
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = KiB
+	case strings.HasSuffix(s, "M"):
+		multiplier = MiB
+	case strings.HasSuffix(s, "G"):
+		multiplier = GiB
+	case strings.HasSuffix(s, "T"):
+		multiplier = TiB
+	}
+	if multiplier != 1 {
+		numStr = s[:len(s)-1]
+	}
+
+	val, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+
+	return val * multiplier, nil
+}
+
+// FormatSize renders n bytes with the largest binary unit that divides it
+// evenly, e.g. 1073741824 -> "1G".
+func FormatSize(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"T", TiB}, {"G", GiB}, {"M", MiB}, {"K", KiB}} {
+		if n != 0 && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,162 @@
+// Package viz renders offset-vs-time plots of the reads found in gcsfuse
+// trace logs, so sequential, random and cache-thrashing access patterns can be
+// told apart at a glance.
+package viz
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Series is the reads of one file at one layer, in time order.
+type Series struct {
+	File  string            `json:"file"`
+	Layer string            `json:"layer"`
+	Reads []gcsfuselog.Read `json:"-"`
+
+	Count int64 `json:"reads"`
+	Bytes int64 `json:"bytes"`
+	// Sequential is the fraction of reads that start where the previous
+	// read of the file ended.
+	Sequential float64 `json:"sequential"`
+	// Reread is the fraction of bytes read more than once, which for GCS
+	// reads points at cache thrash.
+	Reread float64 `json:"reread"`
+	// Image is the rendered plot, relative to the output directory.
+	Image string `json:"image,omitempty"`
+}
+
+// Pattern classifies the series for the summary table.
+func (s *Series) Pattern() string {
+	switch {
+	case s.Reread > 0.2:
+		return "re-read"
+	case s.Sequential >= 0.8:
+		return "sequential"
+	case s.Sequential <= 0.2:
+		return "random"
+	default:
+		return "mixed"
+	}
+}
+
+// Load reads the given log files and groups their reads into series. layer
+// restricts the result to one layer when non-empty.
+func Load(files []string, layer string) ([]*Series, error) {
+	byKey := map[[2]string]*Series{}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = gcsfuselog.Scan(f, func(e gcsfuselog.Entry) {
+			r, ok := gcsfuselog.ParseRead(e)
+			if !ok || (layer != "" && r.Layer != layer) {
+				return
+			}
+			key := [2]string{r.File, r.Layer}
+			s := byKey[key]
+			if s == nil {
+				s = &Series{File: r.File, Layer: r.Layer}
+				byKey[key] = s
+			}
+			s.Reads = append(s.Reads, r)
+		})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+
+	var out []*Series
+	for _, s := range byKey {
+		s.summarize()
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b *Series) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.File, b.File), cmp.Compare(a.Layer, b.Layer))
+	})
+	return out, nil
+}
+
+func (s *Series) summarize() {
+	slices.SortStableFunc(s.Reads, func(a, b gcsfuselog.Read) int { return a.Time.Compare(b.Time) })
+	s.Count = int64(len(s.Reads))
+
+	var sequential int
+	var covered []span
+	var reread int64
+	for i, r := range s.Reads {
+		s.Bytes += r.Length
+		if i > 0 && r.Offset == s.Reads[i-1].Offset+s.Reads[i-1].Length {
+			sequential++
+		}
+		reread += overlap(covered, r.Offset, r.Offset+r.Length)
+		covered = insert(covered, r.Offset, r.Offset+r.Length)
+	}
+	if s.Count > 1 {
+		s.Sequential = float64(sequential) / float64(s.Count-1)
+	}
+	if s.Bytes > 0 {
+		s.Reread = float64(reread) / float64(s.Bytes)
+	}
+}
+
+// span is a half-open byte range.
+type span struct{ start, end int64 }
+
+// overlap returns how many bytes of [start, end) are already in the sorted,
+// disjoint spans.
+func overlap(spans []span, start, end int64) int64 {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].end > start })
+	var n int64
+	for ; i < len(spans) && spans[i].start < end; i++ {
+		n += min(end, spans[i].end) - max(start, spans[i].start)
+	}
+	return n
+}
+
+// insert adds [start, end) to the sorted, disjoint spans, merging the spans
+// it overlaps or touches.
+func insert(spans []span, start, end int64) []span {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].end >= start })
+	j := sort.Search(len(spans), func(j int) bool { return spans[j].start > end })
+	if i < j {
+		start, end = min(start, spans[i].start), max(end, spans[j-1].end)
+	}
+	return slices.Replace(spans, i, j, span{start, end})
+}
+
+// Report is the result of viz-access.
+type Report struct {
+	OutDir string    `json:"out_dir"`
+	Series []*Series `json:"series"`
+}
+
+// WriteText prints one row per plotted series.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tLAYER\tREADS\tBYTES\tSEQUENTIAL\tRE-READ\tPATTERN\tPLOT")
+	for _, s := range r.Series {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.0f%%\t%.0f%%\t%s\t%s\n", s.File, s.Layer, s.Count, units.FormatSize(s.Bytes),
+			s.Sequential*100, s.Reread*100, s.Pattern(), s.Image)
+	}
+	return tw.Flush()
+}
+
+// duration returns the time span of the series.
+func (s *Series) duration() time.Duration {
+	if len(s.Reads) == 0 {
+		return 0
+	}
+	return s.Reads[len(s.Reads)-1].Time.Sub(s.Reads[0].Time)
+}
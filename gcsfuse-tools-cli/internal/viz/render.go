@@ -0,0 +1,190 @@
+package viz
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gcsfuse-tools-cli/internal/gcsfuselog"
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Output formats.
+const (
+	FormatHTML = "html"
+	FormatPNG  = "png"
+)
+
+const (
+	plotWidth  = 800
+	plotHeight = 400
+	margin     = 40
+)
+
+var layerColors = map[string]color.RGBA{
+	gcsfuselog.LayerFUSE: {0x1a, 0x73, 0xe8, 0xff},
+	gcsfuselog.LayerGCS:  {0xd9, 0x30, 0x25, 0xff},
+}
+
+// point is a read mapped to plot coordinates: a vertical bar at x covering
+// the byte range [y0, y1].
+type point struct{ x, y0, y1 int }
+
+// points maps the reads of s to plot coordinates with the origin at the top
+// left, offset 0 at the bottom.
+func (s *Series) points() []point {
+	var maxOff int64 = 1
+	for _, r := range s.Reads {
+		maxOff = max(maxOff, r.Offset+r.Length)
+	}
+	span := max(s.duration().Seconds(), 1e-9)
+	start := s.Reads[0].Time
+	h := plotHeight - 2*margin
+	pts := make([]point, 0, len(s.Reads))
+	for _, r := range s.Reads {
+		x := margin + int(r.Time.Sub(start).Seconds()/span*float64(plotWidth-2*margin))
+		y0 := plotHeight - margin - int(float64(r.Offset)/float64(maxOff)*float64(h))
+		y1 := plotHeight - margin - int(float64(r.Offset+r.Length)/float64(maxOff)*float64(h))
+		pts = append(pts, point{x, max(y0, y1+1), y1})
+	}
+	return pts
+}
+
+// Render writes one plot per series to dir and records its path in
+// Series.Image.
+func Render(dir, format string, series []*Series) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	switch format {
+	case FormatHTML:
+		return renderHTML(dir, series)
+	case FormatPNG:
+		for i, s := range series {
+			s.Image = fmt.Sprintf("%03d-%s-%s.png", i, s.Layer, fileSlug(s.File))
+			if err := renderPNG(filepath.Join(dir, s.Image), s); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q (want html or png)", format)
+	}
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func fileSlug(name string) string {
+	slug := strings.Trim(unsafeChars.ReplaceAllString(name, "_"), "_")
+	if len(slug) > 80 {
+		slug = slug[len(slug)-80:]
+	}
+	return slug
+}
+
+func renderPNG(path string, s *Series) error {
+	img := image.NewRGBA(image.Rect(0, 0, plotWidth, plotHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	axis := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	for x := margin; x <= plotWidth-margin; x++ {
+		img.Set(x, plotHeight-margin, axis)
+	}
+	for y := margin; y <= plotHeight-margin; y++ {
+		img.Set(margin, y, axis)
+	}
+
+	c := layerColors[s.Layer]
+	for _, p := range s.points() {
+		draw.Draw(img, image.Rect(p.x, p.y1, p.x+2, p.y0+1), &image.Uniform{c}, image.Point{}, draw.Over)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>gcsfuse read patterns</title>
+<style>body { font-family: sans-serif; margin: 2em; } svg { border: 1px solid #eee; } text { font-size: 11px; fill: #555; }</style>
+</head>
+<body>
+<h1>gcsfuse read patterns</h1>
+<p>Each bar is one read: x is the time since the first read of the file, y the byte range read.
+<span style="color:#1a73e8">FUSE reads</span> come from the kernel, <span style="color:#d93025">GCS reads</span> are issued to Cloud Storage.</p>
+{{range $i, $s := .Series}}
+<h2 id="s{{$i}}">{{$s.File}} ({{$s.Layer}})</h2>
+<p>{{$s.Count}} reads, {{$s.Size}}, {{$s.SeqPct}}% sequential, {{$s.RereadPct}}% re-read: <b>{{$s.Pattern}}</b></p>
+<svg width="{{$.Width}}" height="{{$.Height}}">
+  <line x1="{{$.Margin}}" y1="{{$.Bottom}}" x2="{{$.Right}}" y2="{{$.Bottom}}" stroke="#888"/>
+  <line x1="{{$.Margin}}" y1="{{$.Margin}}" x2="{{$.Margin}}" y2="{{$.Bottom}}" stroke="#888"/>
+  <text x="{{$.Margin}}" y="{{$.Label}}">0s</text>
+  <text x="{{$.Right}}" y="{{$.Label}}" text-anchor="end">{{$s.Duration}}</text>
+  <text x="2" y="{{$.Margin}}">{{$s.MaxOffset}}</text>
+  {{range $s.Points}}<rect x="{{.X}}" y="{{.Y}}" width="2" height="{{.H}}" fill="{{$s.Color}}"/>{{end}}
+</svg>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlPoint struct{ X, Y, H int }
+
+type htmlSeries struct {
+	*Series
+	Size, Duration, MaxOffset, Color string
+	SeqPct, RereadPct                int
+	Points                           []htmlPoint
+}
+
+func renderHTML(dir string, series []*Series) error {
+	var data []htmlSeries
+	for i, s := range series {
+		s.Image = fmt.Sprintf("index.html#s%d", i)
+		var maxOff int64
+		for _, r := range s.Reads {
+			maxOff = max(maxOff, r.Offset+r.Length)
+		}
+		c := layerColors[s.Layer]
+		hs := htmlSeries{
+			Series:    s,
+			Size:      units.FormatSize(s.Bytes),
+			Duration:  s.duration().String(),
+			MaxOffset: units.FormatSize(maxOff),
+			Color:     fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+			SeqPct:    int(s.Sequential * 100),
+			RereadPct: int(s.Reread * 100),
+		}
+		for _, p := range s.points() {
+			hs.Points = append(hs.Points, htmlPoint{p.x, p.y1, p.y0 - p.y1 + 1})
+		}
+		data = append(data, hs)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	err = pageTemplate.Execute(f, struct {
+		Series                                      []htmlSeries
+		Width, Height, Margin, Bottom, Right, Label int
+	}{data, plotWidth, plotHeight, margin, plotHeight - margin, plotWidth - margin, plotHeight - margin + 15})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"gcsfuse-tools-cli/cmd"
+)
+
+func main() {
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package results
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Key functions for Aggregate.
+var (
+	ByTool           = func(r *Run) string { return r.Tool }
+	ByDataset        = func(r *Run) string { return r.Dataset }
+	BySpecHash       = func(r *Run) string { return r.SpecHash }
+	ByGcsfuseVersion = func(r *Run) string { return r.GcsfuseVersion }
+	ByMachineType    = func(r *Run) string { return r.MachineType }
+	// ByDay groups by the UTC day the run was registered, YYYY-MM-DD.
+	ByDay = func(r *Run) string { return r.CreatedAt.UTC().Format(time.DateOnly) }
+)
+
+// Stats summarizes the values of a metric.
+type Stats struct {
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+}
+
+// Summarize returns the statistics of vs.
+func Summarize(vs []float64) Stats {
+	if len(vs) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), vs...)
+	sort.Float64s(sorted)
+	s := Stats{N: len(sorted), Min: sorted[0], Max: sorted[len(sorted)-1],
+		P50: percentile(sorted, 50), P90: percentile(sorted, 90)}
+	for _, v := range sorted {
+		s.Mean += v
+	}
+	s.Mean /= float64(s.N)
+	for _, v := range sorted {
+		s.StdDev += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(s.StdDev / float64(s.N))
+	return s
+}
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// Group is the statistics of a metric over the runs sharing a key.
+type Group struct {
+	Key    string   `json:"key"`
+	Metric string   `json:"metric"`
+	RunIDs []string `json:"run_ids"`
+	Stats  Stats    `json:"stats"`
+}
+
+// Aggregate groups runs by key and summarizes the metric at path (see
+// Run.Metric) of every group. Runs without the metric are left out. Groups
+// are sorted by key.
+func Aggregate(runs []*Run, key func(*Run) string, path string) []Group {
+	values := map[string][]float64{}
+	ids := map[string][]string{}
+	for _, r := range runs {
+		v, ok := r.Metric(path)
+		if !ok {
+			continue
+		}
+		k := key(r)
+		values[k] = append(values[k], v)
+		ids[k] = append(ids[k], r.RunID)
+	}
+	groups := make([]Group, 0, len(values))
+	for k, vs := range values {
+		groups = append(groups, Group{Key: k, Metric: path, RunIDs: ids[k], Stats: Summarize(vs)})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+// Point is the value of a metric in one run.
+type Point struct {
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Value     float64   `json:"value"`
+}
+
+// Series returns the value of the metric at path in every run that has it,
+// oldest first, for plotting over time.
+func Series(runs []*Run, path string) []Point {
+	points := []Point{}
+	for _, r := range runs {
+		if v, ok := r.Metric(path); ok {
+			points = append(points, Point{RunID: r.RunID, CreatedAt: r.CreatedAt, Value: v})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].CreatedAt.Before(points[j].CreatedAt) })
+	return points
+}
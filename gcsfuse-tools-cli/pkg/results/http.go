@@ -0,0 +1,117 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// groupKeys are the group_by values of the HTTP export.
+var groupKeys = map[string]func(*Run) string{
+	"tool":            ByTool,
+	"dataset":         ByDataset,
+	"spec_hash":       BySpecHash,
+	"gcsfuse_version": ByGcsfuseVersion,
+	"machine_type":    ByMachineType,
+	"day":             ByDay,
+}
+
+// Handler exports the store as JSON over HTTP, for dashboards that cannot
+// link Go:
+//
+//	GET /runs?tool=&dataset=&spec_hash=&gcsfuse_version=&since=&until=&limit=&data=true
+//	GET /aggregate?metric=PATH&group_by=tool|dataset|spec_hash|gcsfuse_version|machine_type|day&...
+//	GET /series?metric=PATH&...
+//
+// since and until are RFC 3339 times or YYYY-MM-DD dates. /runs leaves out
+// the result data unless data=true.
+func Handler(s *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", func(w http.ResponseWriter, req *http.Request) {
+		runs, ok := load(w, req, s)
+		if !ok {
+			return
+		}
+		if req.URL.Query().Get("data") != "true" {
+			for _, r := range runs {
+				r.Data = nil
+			}
+		}
+		writeJSON(w, runs)
+	})
+	mux.HandleFunc("GET /aggregate", func(w http.ResponseWriter, req *http.Request) {
+		metric := req.URL.Query().Get("metric")
+		key, ok := groupKeys[req.URL.Query().Get("group_by")]
+		if metric == "" || !ok {
+			http.Error(w, "metric and group_by (tool, dataset, spec_hash, gcsfuse_version, machine_type or day) are required", http.StatusBadRequest)
+			return
+		}
+		if runs, ok := load(w, req, s); ok {
+			writeJSON(w, Aggregate(runs, key, metric))
+		}
+	})
+	mux.HandleFunc("GET /series", func(w http.ResponseWriter, req *http.Request) {
+		metric := req.URL.Query().Get("metric")
+		if metric == "" {
+			http.Error(w, "metric is required", http.StatusBadRequest)
+			return
+		}
+		if runs, ok := load(w, req, s); ok {
+			writeJSON(w, Series(runs, metric))
+		}
+	})
+	return mux
+}
+
+// load runs the query of the request parameters, writing the error response
+// if it fails.
+func load(w http.ResponseWriter, req *http.Request, s *Store) ([]*Run, bool) {
+	q, err := parseQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	runs, err := s.Load(req.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil, false
+	}
+	return runs, true
+}
+
+func parseQuery(req *http.Request) (Query, error) {
+	v := req.URL.Query()
+	q := Query{Tool: v.Get("tool"), Dataset: v.Get("dataset"), SpecHash: v.Get("spec_hash"), GcsfuseVersion: v.Get("gcsfuse_version")}
+	var err error
+	if q.Since, err = parseTime(v.Get("since")); err != nil {
+		return q, fmt.Errorf("since: %w", err)
+	}
+	if q.Until, err = parseTime(v.Get("until")); err != nil {
+		return q, fmt.Errorf("until: %w", err)
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			return q, fmt.Errorf("limit: %w", err)
+		}
+	}
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
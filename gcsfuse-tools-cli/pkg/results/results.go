@@ -0,0 +1,183 @@
+// Package results loads the benchmark results catalogued in a registry
+// bucket (see gcsfuse-tools registry) into typed values, with filtering and
+// aggregation helpers, for analysis notebooks and dashboards:
+//
+//	store := results.Open(client, "my-registry")
+//	runs, err := store.Load(ctx, results.Query{Tool: "bench-fio", Since: time.Now().AddDate(0, -1, 0)})
+//	groups := results.Aggregate(runs, results.ByGcsfuseVersion, "jobs.*.read.bw_kibps")
+//
+// Unlike the rest of the module it is importable from other modules.
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"gcsfuse-tools-cli/internal/registry"
+)
+
+// loadWorkers bounds the concurrent reads of result blobs.
+const loadWorkers = 16
+
+// Run is one registered result.
+type Run struct {
+	RunID   string `json:"run_id"`
+	Tool    string `json:"tool"`
+	Dataset string `json:"dataset,omitempty"`
+	// SpecHash is the hash of the workload spec the run executed, if any.
+	SpecHash  string    `json:"spec_hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// GcsfuseVersion, MachineType, Zone and Hostname come from the
+	// environment fingerprint of the run, when it has one.
+	GcsfuseVersion string `json:"gcsfuse_version,omitempty"`
+	MachineType    string `json:"machine_type,omitempty"`
+	Zone           string `json:"zone,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	ResultObject   string `json:"result_object"`
+	// Data is the result written by the tool, e.g. a bench fio result.
+	Data json.RawMessage `json:"data,omitempty"`
+
+	decoded any
+}
+
+// Decode unmarshals the result into v, e.g. a struct with the fields of
+// interest.
+func (r *Run) Decode(v any) error {
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		return fmt.Errorf("decoding result %s: %w", r.RunID, err)
+	}
+	return nil
+}
+
+// Metric returns the number at path in the result, a dot-separated list of
+// object keys and array indexes, e.g. "jobs.0.read.bw_kibps". A "*" element
+// matches every key or index and the matched numbers are summed, e.g.
+// "jobs.*.read.bw_kibps" is the bandwidth of all jobs. ok is false when
+// nothing matches.
+func (r *Run) Metric(path string) (v float64, ok bool) {
+	if r.decoded == nil {
+		if err := json.Unmarshal(r.Data, &r.decoded); err != nil {
+			return 0, false
+		}
+	}
+	return lookup(r.decoded, strings.Split(path, "."))
+}
+
+func lookup(node any, path []string) (float64, bool) {
+	if len(path) == 0 {
+		f, ok := node.(float64)
+		return f, ok
+	}
+	var children []any
+	switch n := node.(type) {
+	case map[string]any:
+		if path[0] == "*" {
+			for _, c := range n {
+				children = append(children, c)
+			}
+		} else if c, ok := n[path[0]]; ok {
+			children = append(children, c)
+		}
+	case []any:
+		if path[0] == "*" {
+			children = n
+		} else if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(n) {
+			children = append(children, n[i])
+		}
+	}
+	var sum float64
+	found := false
+	for _, c := range children {
+		if v, ok := lookup(c, path[1:]); ok {
+			sum += v
+			found = true
+		}
+	}
+	return sum, found
+}
+
+// Query selects results. Zero fields do not restrict the selection.
+type Query struct {
+	Tool    string
+	Dataset string
+	// SpecHash selects the runs of one workload spec, see pkg/workload.
+	SpecHash string
+	// GcsfuseVersion matches the version of the fingerprint exactly.
+	GcsfuseVersion string
+	Since, Until   time.Time
+	// Filter, if set, keeps only the runs it returns true for. It sees the
+	// entry fields, not Data.
+	Filter func(*Run) bool
+	// Limit keeps only the newest Limit runs.
+	Limit int
+}
+
+func (q *Query) match(r *Run) bool {
+	switch {
+	case q.Dataset != "" && r.Dataset != q.Dataset,
+		q.SpecHash != "" && r.SpecHash != q.SpecHash,
+		q.GcsfuseVersion != "" && r.GcsfuseVersion != q.GcsfuseVersion,
+		!q.Since.IsZero() && r.CreatedAt.Before(q.Since),
+		!q.Until.IsZero() && !r.CreatedAt.Before(q.Until):
+		return false
+	}
+	return q.Filter == nil || q.Filter(r)
+}
+
+// Store reads results from a registry bucket.
+type Store struct {
+	reg *registry.Registry
+}
+
+// Open returns the Store of the registry in bucket.
+func Open(client *storage.Client, bucket string) *Store {
+	return &Store{reg: registry.New(client, bucket)}
+}
+
+// Load returns the runs matching q, newest first, with their data.
+func (s *Store) Load(ctx context.Context, q Query) ([]*Run, error) {
+	entries, err := s.reg.ListResults(ctx, q.Tool)
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	for _, e := range entries {
+		r := &Run{RunID: e.RunID, Tool: e.Tool, Dataset: e.Dataset, SpecHash: e.SpecHash, CreatedAt: e.CreatedAt, ResultObject: e.ResultObject}
+		if e.Env != nil {
+			r.GcsfuseVersion, r.MachineType, r.Zone, r.Hostname = e.Env.GcsfuseVersion, e.Env.MachineType, e.Env.Zone, e.Env.Hostname
+		}
+		if q.match(r) {
+			runs = append(runs, r)
+		}
+		if q.Limit > 0 && len(runs) == q.Limit {
+			break
+		}
+	}
+
+	sem := make(chan struct{}, loadWorkers)
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			b, err := s.reg.GetResultBlob(ctx, r.RunID)
+			r.Data, errs[i] = b, err
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
@@ -0,0 +1,52 @@
+package workload
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Summary is a validated spec with its hash.
+type Summary struct {
+	Hash string `json:"hash"`
+	Spec *Spec  `json:"spec"`
+}
+
+// Summary returns s with its hash.
+func (s *Spec) Summary() *Summary {
+	return &Summary{Hash: s.Hash(), Spec: s}
+}
+
+// WriteText prints the spec as key/value lines, one per op.
+func (s *Summary) WriteText(w io.Writer) error {
+	sp := s.Spec
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s (version %d)\n", sp.Name, sp.Version)
+	fmt.Fprintf(tw, "Hash:\t%s\n", s.Hash)
+	if sp.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", sp.Description)
+	}
+	d := sp.Dataset
+	if d.Name != "" {
+		fmt.Fprintf(tw, "Dataset:\t%s\n", d.Name)
+	}
+	if d.Bucket != "" || d.Prefix != "" {
+		fmt.Fprintf(tw, "Location:\tgs://%s/%s\n", d.Bucket, d.Prefix)
+	}
+	fmt.Fprintf(tw, "Names:\t%s\n", d.Names)
+	fmt.Fprintf(tw, "Files:\t%d of %s per worker\n", d.FilesPerWorker, d.FileSize)
+	fmt.Fprintf(tw, "Workers:\t%d, iodepth %d\n", sp.Concurrency.Workers, sp.Concurrency.IODepth)
+	workers := sp.OpWorkers()
+	for i, op := range sp.Ops {
+		fmt.Fprintf(tw, "Op:\t%s %d%% (%d workers), %s blocks\n", op.Type, op.Weight, workers[i], op.BlockSize)
+	}
+	if sp.Duration != "" {
+		fmt.Fprintf(tw, "Duration:\t%s\n", sp.Duration)
+	} else {
+		fmt.Fprintf(tw, "Duration:\tone pass over the files\n")
+	}
+	if sp.Seed != 0 {
+		fmt.Fprintf(tw, "Seed:\t%d\n", sp.Seed)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,333 @@
+// Package workload defines the workload spec shared by the benchmark tools:
+// one versioned file describing the dataset, op mix, concurrency and duration
+// of a benchmark, which every backend able to express it can run. Results
+// record the hash of the spec, so runs of the same workload are found and
+// compared across backends, gcsfuse versions and machines.
+//
+// A spec is written in YAML or JSON, see Schema:
+//
+//	version: 1
+//	name: ckpt-restore
+//	dataset: {bucket: my-bucket, prefix: ckpt/, names: "{op}.{worker}.{file}", file_size: 1G, files_per_worker: 4}
+//	ops: [{type: read, block_size: 1M}]
+//	concurrency: {workers: 64}
+//	duration: 10m
+package workload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gcsfuse-tools-cli/internal/units"
+)
+
+// Version is the spec version this package reads. Specs of older versions
+// are read as long as their fields keep their meaning; a new meaning of a
+// field bumps the version.
+const Version = 1
+
+// Operation types.
+const (
+	// OpRead reads whole files sequentially.
+	OpRead = "read"
+	// OpRandRead reads files at random offsets.
+	OpRandRead = "randread"
+	// OpWrite writes new files sequentially.
+	OpWrite = "write"
+)
+
+// OpTypes lists the operation types.
+var OpTypes = []string{OpRead, OpRandRead, OpWrite}
+
+// Schema is the JSON Schema of a spec, for editors and CI checks.
+//
+//go:embed schema.json
+var Schema []byte
+
+// validName keeps names usable in job and file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// DefaultNames is the object naming of datasets that do not set one.
+const DefaultNames = "{op}.{worker}.{file}"
+
+// validNames keeps the object names of a dataset usable as fio
+// filename_format and GCS object names.
+var validNames = regexp.MustCompile(`^([A-Za-z0-9._/-]|\{op\}|\{worker\}|\{file\})+$`)
+
+// Spec is a benchmark workload.
+type Spec struct {
+	Version int `json:"version" yaml:"version"`
+	// Name names the workload in job names and reports.
+	Name        string      `json:"name" yaml:"name"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Dataset     Dataset     `json:"dataset" yaml:"dataset"`
+	Ops         []Op        `json:"ops" yaml:"ops"`
+	Concurrency Concurrency `json:"concurrency" yaml:"concurrency"`
+	// Duration runs the ops for this long, e.g. "10m". If empty, every
+	// worker goes through its files once.
+	Duration string `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Seed drives the random offsets and buffers, so random workloads
+	// repeat exactly. 0 leaves them to the backend.
+	Seed uint64 `json:"seed,omitempty" yaml:"seed,omitempty"`
+}
+
+// Dataset is the set of files a workload reads or writes.
+type Dataset struct {
+	// Name is the registered dataset, recorded with the results.
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Bucket string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	// Prefix is the object prefix, i.e. the directory of the mount, the
+	// files are in. It ends with a slash.
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	// Names names the objects under Prefix, the same on every backend:
+	// {op} is the op type, {worker} the worker of the op and {file} the file
+	// of the worker, all counted from 0. Without {op}, the workers of all
+	// ops share their files. Defaults to DefaultNames.
+	Names string `json:"names,omitempty" yaml:"names,omitempty"`
+	// FileSize is the size of every file, e.g. "1G".
+	FileSize       string `json:"file_size" yaml:"file_size"`
+	FilesPerWorker int    `json:"files_per_worker" yaml:"files_per_worker"`
+}
+
+// Op is one operation of the mix.
+type Op struct {
+	Type string `json:"type" yaml:"type"`
+	// Weight is the percentage of the workers running the op. The weights of
+	// a mix add up to 100; a single op defaults to 100.
+	Weight    int    `json:"weight" yaml:"weight"`
+	BlockSize string `json:"block_size" yaml:"block_size"`
+}
+
+// Concurrency is how many operations run at once.
+type Concurrency struct {
+	Workers int `json:"workers" yaml:"workers"`
+	// IODepth is the asynchronous I/Os in flight per worker, 1 if 0.
+	IODepth int `json:"iodepth,omitempty" yaml:"iodepth,omitempty"`
+}
+
+// Ref identifies the spec a result was produced from.
+type Ref struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// Load reads and validates the YAML or JSON spec at path. Unknown fields are
+// errors, so a misspelled field does not silently change the workload.
+func Load(path string) (*Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate reports missing or inconsistent fields, fills in the default
+// weights and iodepth and normalizes sizes and the duration, e.g. 1024K to
+// 1M, so that equivalent specs hash alike.
+func (s *Spec) Validate() error {
+	switch {
+	case s.Version == 0:
+		return errors.New("version is required")
+	case s.Version > Version:
+		return fmt.Errorf("spec version %d is newer than the supported version %d", s.Version, Version)
+	case s.Name == "":
+		return errors.New("name is required")
+	case !validName.MatchString(s.Name):
+		return fmt.Errorf("name %q may only contain letters, digits, '.', '_' and '-'", s.Name)
+	case len(s.Ops) == 0:
+		return errors.New("ops must list at least one operation")
+	case s.Concurrency.Workers < len(s.Ops):
+		return errors.New("concurrency.workers must be at least the number of ops")
+	case s.Concurrency.IODepth < 0:
+		return errors.New("concurrency.iodepth must not be negative")
+	case s.Dataset.FilesPerWorker <= 0:
+		return errors.New("dataset.files_per_worker must be greater than 0")
+	}
+	if s.Concurrency.IODepth == 0 {
+		s.Concurrency.IODepth = 1
+	}
+	size, err := units.ParseSize(s.Dataset.FileSize)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("dataset.file_size %q must be a size greater than 0, e.g. 1G", s.Dataset.FileSize)
+	}
+	s.Dataset.FileSize = units.FormatSize(size)
+	if err := s.Dataset.normalizeNames(); err != nil {
+		return err
+	}
+	if s.Duration != "" {
+		d, err := time.ParseDuration(s.Duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("duration %q must be a positive duration, e.g. 10m", s.Duration)
+		}
+		s.Duration = d.String()
+	}
+	if len(s.Ops) == 1 && s.Ops[0].Weight == 0 {
+		s.Ops[0].Weight = 100
+	}
+	total := 0
+	seen := map[string]bool{}
+	for i := range s.Ops {
+		op := &s.Ops[i]
+		if !isOpType(op.Type) {
+			return fmt.Errorf("ops[%d]: unknown type %q (want one of %s)", i, op.Type, strings.Join(OpTypes, ", "))
+		}
+		if seen[op.Type] {
+			return fmt.Errorf("ops[%d]: %s is listed twice", i, op.Type)
+		}
+		seen[op.Type] = true
+		if op.Weight <= 0 {
+			return fmt.Errorf("ops[%d]: weight must be greater than 0", i)
+		}
+		bs, err := units.ParseSize(op.BlockSize)
+		if err != nil || bs <= 0 {
+			return fmt.Errorf("ops[%d]: block_size %q must be a size greater than 0, e.g. 1M", i, op.BlockSize)
+		}
+		op.BlockSize = units.FormatSize(bs)
+		total += op.Weight
+	}
+	if total != 100 {
+		return fmt.Errorf("the op weights add up to %d, want 100", total)
+	}
+	return nil
+}
+
+// normalizeNames checks Names, which must name every file of every worker
+// apart, and fills in the defaults of Names and the trailing slash of
+// Prefix.
+func (d *Dataset) normalizeNames() error {
+	if d.Prefix != "" && !strings.HasSuffix(d.Prefix, "/") {
+		d.Prefix += "/"
+	}
+	if strings.HasPrefix(d.Prefix, "/") {
+		return fmt.Errorf("dataset.prefix %q must not start with /", d.Prefix)
+	}
+	if d.Names == "" {
+		d.Names = DefaultNames
+	}
+	switch {
+	case !validNames.MatchString(d.Names):
+		return fmt.Errorf("dataset.names %q may only contain letters, digits, '.', '_', '-', '/' and the {op}, {worker} and {file} placeholders", d.Names)
+	case !strings.Contains(d.Names, "{worker}") || !strings.Contains(d.Names, "{file}"):
+		return fmt.Errorf("dataset.names %q must contain {worker} and {file}", d.Names)
+	case strings.HasPrefix(d.Names, "/") || strings.Contains(d.Names, "//"):
+		return fmt.Errorf("dataset.names %q must not start with / or contain //", d.Names)
+	}
+	// "{worker}{file}" names worker 1 file 11 and worker 11 file 1 alike.
+	for _, p := range []string{"{worker}", "{file}"} {
+		for rest := d.Names; ; {
+			i := strings.Index(rest, p)
+			if i < 0 {
+				break
+			}
+			before, after := rest[:i], rest[i+len(p):]
+			if before != "" && !isDelimiter(before[len(before)-1]) || after != "" && !isDelimiter(after[0]) {
+				return fmt.Errorf("dataset.names %q must delimit %s by characters other than digits and placeholders", d.Names, p)
+			}
+			rest = after
+		}
+	}
+	return nil
+}
+
+func isDelimiter(b byte) bool {
+	return (b < '0' || b > '9') && b != '{' && b != '}'
+}
+
+// ExpandNames returns Names with op, worker and file substituted, e.g. fio's
+// $jobnum and $filenum.
+func (d *Dataset) ExpandNames(op, worker, file string) string {
+	return strings.NewReplacer("{op}", op, "{worker}", worker, "{file}", file).Replace(d.Names)
+}
+
+// ObjectName returns the name of the file-th object of the worker-th worker
+// of op, prefix included.
+func (d *Dataset) ObjectName(op string, worker, file int) string {
+	return d.Prefix + d.ExpandNames(op, strconv.Itoa(worker), strconv.Itoa(file))
+}
+
+func isOpType(t string) bool {
+	for _, o := range OpTypes {
+		if t == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Runtime returns the parsed Duration, or 0 if it is empty.
+func (s *Spec) Runtime() time.Duration {
+	d, _ := time.ParseDuration(s.Duration)
+	return d
+}
+
+// OpWorkers returns the workers of every op: its weight of all workers, at
+// least one, adding up to all workers. The rounding goes to the ops with the
+// largest remainders, e.g. weights 33/33/34 of 10 workers give 3, 3 and 4.
+func (s *Spec) OpWorkers() []int {
+	total := s.Concurrency.Workers
+	n := make([]int, len(s.Ops))
+	rem := make([]int, len(s.Ops))
+	left := total
+	for i, op := range s.Ops {
+		n[i], rem[i] = total*op.Weight/100, total*op.Weight%100
+		left -= n[i]
+	}
+	order := make([]int, len(s.Ops))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return rem[order[a]] > rem[order[b]] })
+	for _, i := range order[:left] {
+		n[i]++
+	}
+	// Every op runs; its worker comes from the op with the most.
+	for i := range n {
+		if n[i] == 0 {
+			n[i] = 1
+			n[slices.Index(n, slices.Max(n))]--
+		}
+	}
+	return n
+}
+
+// Hash returns the SHA-256 of the canonical JSON encoding of the validated
+// spec. Formatting, comments and key order of the file do not change it;
+// the name and description do, as they name what the results measure.
+func (s *Spec) Hash() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		// A Spec only holds strings and numbers.
+		panic(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Ref returns the reference results record.
+func (s *Spec) Ref() *Ref {
+	return &Ref{Name: s.Name, Version: s.Version, Hash: s.Hash()}
+}
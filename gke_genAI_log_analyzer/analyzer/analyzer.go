@@ -0,0 +1,427 @@
+package analyzer
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/genai"
+)
+
+const (
+	contextLookback    = 2 * time.Minute
+	contextLookforward = 1 * time.Minute
+
+	geminiPromptTemplate = `
+	You are a Google Cloud Support Engineer expert in GKE and GCSFuse.
+	Analyze the following log sequence from the gke-gcsfuse-sidecar.
+	The logs are provided in chronological order.
+	
+	Focus on:
+	1. Ignore "failed to calculate volume total size for" kind of error
+	2. What triggered the first error real error which cause failure in model running? (Look at the INFO logs immediately preceding the ERROR). Please be straightforward and don't wrote extra info.
+	3. Is this a permission issue (403), network (timeout), or configuration?
+	4. Does the model get crashed or failed? If yes what gcsfuse error cause model to get crashed?
+
+	` + sourcesNote + `
+
+	LOGS:
+	%s
+	`
+	geminiModel    = "gemini-2.5-flash"
+	maxContextLogs = 500
+)
+
+// Config holds our runtime flags
+type Config struct {
+	ProjectID string
+	Region    string
+	PodName   string
+	Namespace string
+
+	// Time Flags
+	Lookback    time.Duration
+	StartString string // New flag for explicit start
+	EndString   string // New flag for explicit end
+
+	// Analysis mode flags
+	Mode             string
+	Metrics          string
+	MetricFilter     string
+	AnomalyThreshold float64
+
+	// Impact enrichment flags
+	EnrichOwners bool
+	OwnerKeys    string
+	Kubectl      string
+	KubeContext  string
+
+	// Report language flag
+	ReportLanguage string
+
+	// Correlation flag
+	Correlate bool
+
+	// Output format flag
+	Output string
+
+	// Watch mode flags
+	Watch         bool
+	WatchInterval time.Duration
+	DedupWindow   time.Duration
+
+	// Error clustering flag
+	MaxErrors int
+
+	// Local classification flags
+	LocalRules bool
+	RulesFile  string
+	rules      []*Rule
+}
+
+// Run scans the configured window for a GCSFuse sidecar error, expands the
+// surrounding log context and prints a Gemini root cause analysis. With
+// -watch it keeps polling for new errors until ctx is done.
+func Run(ctx context.Context, cfg Config) error {
+	// 1. Resolve Time Window
+	searchStart, searchEnd, err := resolveTimeWindow(cfg)
+	if err != nil {
+		return fmt.Errorf("time window error: %w", err)
+	}
+
+	logClient, err := logadmin.NewClient(ctx, cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to create logging client: %w", err)
+	}
+	defer logClient.Close()
+
+	if cfg.LocalRules {
+		if cfg.rules, err = loadRules(cfg); err != nil {
+			return fmt.Errorf("loading -rules-file: %w", err)
+		}
+	}
+
+	if cfg.Watch {
+		return runWatch(ctx, logClient, cfg, searchStart)
+	}
+
+	switch cfg.Mode {
+	case ModeAnomaly:
+		return runAnomaly(ctx, logClient, cfg, searchStart, searchEnd)
+	case ModePods:
+		return runPods(ctx, logClient, cfg, searchStart, searchEnd)
+	}
+	if cfg.MaxErrors > 1 {
+		return runClusters(ctx, logClient, cfg, searchStart, searchEnd)
+	}
+
+	// 2. Step 1: Find the "Anchor" (The Error within the window)
+	anchorEntry, err := findAnchorError(ctx, logClient, cfg, searchStart, searchEnd)
+	if err != nil {
+		return fmt.Errorf("error reading logs: %w", err)
+	}
+	if anchorEntry == nil {
+		cfg.progressf("✅ No GCSFuse errors found in the specified window.\n")
+		if cfg.Output == OutputJSON {
+			return newReport(cfg, searchStart, searchEnd).writeJSON()
+		}
+		return nil
+	}
+
+	cfg.progressf("🚨 Found Error at %s: %v\n", anchorEntry.Timestamp.Format(time.TimeOnly), parsePayload(anchorEntry.Payload))
+
+	// 3. Step 2: Expand Context (2 mins before the found error)
+	logDump, err := fetchLogContext(ctx, logClient, anchorEntry.Timestamp, anchorEntry, cfg)
+	if err != nil {
+		return fmt.Errorf("error fetching context logs: %w", err)
+	}
+
+	// 4. Step 3: Classify known failures locally, send the others to Gemini
+	analysis, rule, err := explain(ctx, cfg, anchorEntry, logDump)
+	if err != nil {
+		return fmt.Errorf("gemini analysis failed: %w", err)
+	}
+
+	// 5. Optional: resolve the impacted workload and its owners
+	var impact *Impact
+	if cfg.EnrichOwners {
+		cfg.progressf("👥 Resolving affected workload and owners...\n")
+		impact = resolveImpact(ctx, cfg, anchorEntry)
+	}
+
+	// 6. Output Result
+	if cfg.Output == OutputJSON {
+		r := newReport(cfg, searchStart, searchEnd)
+		f := newFinding(cfg, anchorEntry, logDump, analysis)
+		f.Rule = rule
+		if impact != nil {
+			f.Impacts = []*Impact{impact}
+		}
+		r.Findings = append(r.Findings, f)
+		return r.writeJSON()
+	}
+	printReport(analysis, impact)
+	return nil
+}
+
+// resolveTimeWindow handles the logic between explicit (-start) vs relative (-lookback) time
+func resolveTimeWindow(cfg Config) (time.Time, time.Time, error) {
+	// Case 1: Relative Mode (Default)
+	if cfg.StartString == "" {
+		end := time.Now()
+		start := end.Add(-cfg.Lookback)
+		return start, end, nil
+	}
+
+	// Case 2: Explicit Mode
+	start, err := time.Parse(time.RFC3339, cfg.StartString)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time format (use RFC3339 e.g., 2025-01-02T15:04:05Z): %v", err)
+	}
+
+	end := time.Now()
+	if cfg.EndString != "" {
+		end, err = time.Parse(time.RFC3339, cfg.EndString)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end time format: %v", err)
+		}
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end time cannot be before start time")
+	}
+
+	return start, end, nil
+}
+
+// RegisterFlags binds the analyzer flags to fs. It is shared by the standalone
+// binary and the gcsfuse-tools CLI so both accept the same options.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.ProjectID, "project", "", "GCP Project ID")
+	fs.StringVar(&cfg.Region, "region", "us-central1", "Vertex AI Region")
+	fs.StringVar(&cfg.PodName, "pod", "", "Specific Pod Name (optional)")
+	fs.StringVar(&cfg.Namespace, "namespace", "", "Kubernetes namespace of the pods (optional)")
+
+	// Time Window Flags
+	fs.DurationVar(&cfg.Lookback, "lookback", 1*time.Hour, "Relative lookback window (e.g., 1h, 30m). Ignored if -start is set.")
+	fs.StringVar(&cfg.StartString, "start", "", "Explicit Start Time (RFC3339 format, e.g., 2025-01-07T10:00:00Z)")
+	fs.StringVar(&cfg.EndString, "end", "", "Explicit End Time (RFC3339). Defaults to Now if not set.")
+
+	// Analysis Mode Flags
+	fs.StringVar(&cfg.Mode, "mode", ModeErrors, "Analysis mode: errors (explain the latest ERROR) anomaly (detect latency spikes and throughput cliffs in metric log lines) or pods (analyze the errors of every pod, one analysis per distinct failure)")
+	fs.StringVar(&cfg.Metrics, "metrics", "", "Comma-separated metric names read from JSON fields or name=value pairs of the sidecar logs in anomaly mode, e.g. read_latency_ms,read_mibps")
+	fs.StringVar(&cfg.MetricFilter, "metric-filter", "", "Extra Cloud Logging filter selecting the metric log lines in anomaly mode, e.g. jsonPayload.message:\"metrics\"")
+	fs.Float64Var(&cfg.AnomalyThreshold, "anomaly-threshold", 4, "Change, in robust standard deviations, reported as an anomaly")
+
+	// Impact Enrichment Flags
+	fs.BoolVar(&cfg.EnrichOwners, "enrich-owners", false, "Resolve the failing pod's owning workload and namespace owners with kubectl and report who is impacted")
+	fs.StringVar(&cfg.OwnerKeys, "owner-keys", "team,owner", "Comma-separated namespace label/annotation keys that name the owning team")
+	fs.StringVar(&cfg.Kubectl, "kubectl", "kubectl", "Path to kubectl, used by -enrich-owners")
+	fs.StringVar(&cfg.KubeContext, "kube-context", "", "kubectl context of the cluster the pod runs in. Defaults to the current context.")
+
+	// Correlation Flag
+	fs.BoolVar(&cfg.Correlate, "correlate", true, "Add the logs of the failing pod's workload containers and of the gcs-fuse-csi-driver on its node to the context, interleaved with the sidecar logs")
+
+	// Report Language Flag
+	fs.StringVar(&cfg.ReportLanguage, "report-language", "", "Language of the Gemini report, e.g. ja, de or \"Brazilian Portuguese\". Log excerpts stay verbatim. Defaults to English.")
+
+	// Watch Mode Flags
+	fs.BoolVar(&cfg.Watch, "watch", false, "Keep polling Cloud Logging for new errors, starting with the -lookback or -start window, and stream an analysis of every distinct error until interrupted")
+	fs.DurationVar(&cfg.WatchInterval, "watch-interval", 30*time.Second, "Polling interval of -watch")
+	fs.DurationVar(&cfg.DedupWindow, "dedup-window", time.Hour, "How long -watch only counts repetitions of an analyzed error, identified by its message with pod names, paths, IDs and numbers masked, before analyzing it again")
+
+	// Error Clustering Flag
+	fs.IntVar(&cfg.MaxErrors, "max-errors", 1, "In errors mode, cluster all errors of the window by signature (message with pod names, paths, IDs and numbers masked) and analyze the N clusters with the most occurrences, reporting their counts and first and last timestamps. 1 analyzes a single error.")
+
+	// Local Classification Flags
+	fs.BoolVar(&cfg.LocalRules, "local-rules", true, "Classify well-known failures (403, DNS, OOM kill, mount timeout, missing bucket...) from the anchor error with built-in rules and error codes, and only send unknown ones to Gemini")
+	fs.StringVar(&cfg.RulesFile, "rules-file", "", "JSON list of extra local rules (name, category, pattern, explanation), tried before the built-in ones, which same-named rules replace")
+
+	// Output Format Flag
+	fs.StringVar(&cfg.Output, "output", OutputText, "Output format: text (human report) or json (anchor error, classification, affected pod and volume, context excerpt and Gemini verdict for automation; progress goes to stderr)")
+}
+
+// Validate reports missing or inconsistent flag values.
+func (cfg *Config) Validate() error {
+	if cfg.ProjectID == "" {
+		return fmt.Errorf("please provide -project <PROJECT_ID>")
+	}
+	if cfg.Watch {
+		if cfg.Mode != ModeErrors {
+			return fmt.Errorf("-watch only supports -mode %s", ModeErrors)
+		}
+		if cfg.EndString != "" {
+			return fmt.Errorf("-end cannot be used with -watch, which runs until interrupted")
+		}
+		if cfg.WatchInterval <= 0 || cfg.DedupWindow < 0 {
+			return fmt.Errorf("-watch-interval must be greater than 0 and -dedup-window must not be negative")
+		}
+	}
+	if cfg.MaxErrors < 1 {
+		return fmt.Errorf("-max-errors must be at least 1")
+	}
+	if cfg.MaxErrors > 1 && (cfg.Mode != ModeErrors || cfg.Watch) {
+		return fmt.Errorf("-max-errors only applies to -mode %s without -watch", ModeErrors)
+	}
+	if cfg.RulesFile != "" && !cfg.LocalRules {
+		return fmt.Errorf("-rules-file cannot be used with -local-rules=false")
+	}
+	switch cfg.Output {
+	case OutputText, OutputJSON:
+	default:
+		return fmt.Errorf("unsupported -output %q (want %s or %s)", cfg.Output, OutputText, OutputJSON)
+	}
+	switch cfg.Mode {
+	case ModeErrors:
+	case ModeAnomaly:
+		if cfg.Metrics == "" {
+			return fmt.Errorf("please provide -metrics for -mode %s", ModeAnomaly)
+		}
+		if cfg.AnomalyThreshold <= 0 {
+			return fmt.Errorf("-anomaly-threshold must be greater than 0")
+		}
+	case ModePods:
+		if cfg.PodName != "" {
+			return fmt.Errorf("-pod cannot be used with -mode %s; use -namespace to select the pods", ModePods)
+		}
+	default:
+		return fmt.Errorf("unsupported -mode %q (want %s, %s or %s)", cfg.Mode, ModeErrors, ModeAnomaly, ModePods)
+	}
+	return nil
+}
+
+func getBaseFilter(cfg Config) string {
+	baseFilter := `resource.type="k8s_container" AND resource.labels.container_name="gke-gcsfuse-sidecar"`
+	if cfg.Namespace != "" {
+		baseFilter += fmt.Sprintf(` AND resource.labels.namespace_name="%s"`, cfg.Namespace)
+	}
+	podName := cfg.PodName
+	if podName != "" {
+		baseFilter += fmt.Sprintf(` AND resource.labels.pod_name="%s"`, podName)
+	}
+	return baseFilter
+}
+
+func findAnchorError(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) (*logging.Entry, error) {
+	cfg.progressf("🔍 Scanning logs for GCSFuse errors between %s and %s...\n",
+		start.Format(time.TimeOnly), end.Format(time.TimeOnly))
+
+	baseFilter := getBaseFilter(cfg)
+
+	// Strict filter: Error must be INSIDE the requested window
+	anchorFilter := fmt.Sprintf(`%s AND severity>=ERROR AND timestamp >= "%s" AND timestamp <= "%s"`,
+		baseFilter, start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	// Fetch the most recent error inside that window
+	iter := client.Entries(ctx, logadmin.Filter(anchorFilter))
+	return firstFailure(cfg, iter.Next)
+}
+
+// firstFailure returns the first entry of next that is not a benign error,
+// or nil if there is none among the first maxPodErrors.
+func firstFailure(cfg Config, next func() (*logging.Entry, error)) (*logging.Entry, error) {
+	for n := 0; n < maxPodErrors; n++ {
+		e, err := next()
+		if err == iterator.Done {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !benign(cfg, entryMessage(e)) {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchLogContext returns the sidecar logs around errorTime and, with
+// -correlate, the logs of the workload containers and CSI driver in the scope
+// of anchor, which may be nil, interleaved chronologically and tagged with
+// their source.
+func fetchLogContext(ctx context.Context, client *logadmin.Client, errorTime time.Time, anchor *logging.Entry, cfg Config) (string, error) {
+	// Note: We respect the error time, not the window boundaries, for context.
+	// If the error was at 10:00:05, we want logs from 09:58:05, even if the user said -start 10:00.
+	contextStart := errorTime.Add(-contextLookback).Format(time.RFC3339)
+	contextEnd := errorTime.Add(contextLookforward).Format(time.RFC3339)
+	window := fmt.Sprintf(`timestamp >= "%s" AND timestamp <= "%s"`, contextStart, contextEnd)
+
+	cfg.progressf("📜 Fetching surrounding logs (context window)...\n")
+	lines := readContextLines(ctx, client, getBaseFilter(cfg)+" AND "+window, SourceSidecar, maxContextLogs)
+
+	if cfg.Correlate {
+		for _, src := range correlatedSources(scopeOf(cfg, anchor)) {
+			cfg.progressf("🔗 Fetching %s logs...\n", src.name)
+			lines = append(lines, readContextLines(ctx, client, src.filter+" AND "+window, src.name, maxCorrelatedLogs)...)
+		}
+	}
+	return interleave(lines), nil
+}
+
+func printReport(analysis string, impact *Impact) {
+	fmt.Println("\n" + strings.Repeat("-", 50))
+	fmt.Println("🕵️  LOG DETECTIVE REPORT")
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Println(analysis)
+	if impact != nil {
+		fmt.Println(strings.Repeat("-", 50))
+		fmt.Println("👥 WHO IS IMPACTED")
+		fmt.Println(strings.Repeat("-", 50))
+		fmt.Print(formatImpact(impact))
+	}
+}
+
+func analyzeWithGemini(ctx context.Context, cfg Config, logs string) (string, error) {
+	prompt := fmt.Sprintf(geminiPromptTemplate, logs) + languageInstruction(cfg.ReportLanguage) + classifyInstruction(cfg)
+	return Generate(ctx, cfg.ProjectID, cfg.Region, prompt)
+}
+
+// languageInstruction asks the model to write its report in lang, leaving the
+// quoted logs untranslated so they can still be searched for and matched
+// against the original entries. It is empty for English.
+func languageInstruction(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if lang == "" || strings.EqualFold(lang, "en") || strings.EqualFold(lang, "english") {
+		return ""
+	}
+	return fmt.Sprintf(`
+	Write the whole report in %s.
+	Quote log lines, error messages, file paths, flags, metric names and other identifiers verbatim, exactly as they appear in the logs; do not translate them.
+	`, lang)
+}
+
+// Generate sends prompt to the analyzer's Gemini model on Vertex AI and returns
+// the text of the response. Other tools use it to get summaries from the same
+// backend.
+func Generate(ctx context.Context, projectID, region, prompt string) (string, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		Project:  projectID,
+		Location: region,
+		Backend:  genai.BackendVertexAI,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create genai client: %w", err)
+	}
+
+	resp, err := client.Models.GenerateContent(ctx, geminiModel, genai.Text(prompt), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %w", err)
+	}
+	return resp.Text(), nil
+}
+
+func parsePayload(p interface{}) string {
+	switch v := p.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
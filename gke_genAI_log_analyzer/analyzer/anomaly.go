@@ -0,0 +1,285 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// Analysis modes
+	ModeErrors  = "errors"
+	ModeAnomaly = "anomaly"
+	ModePods    = "pods"
+
+	// anomalyWindow is the number of samples compared on each side of a
+	// candidate changepoint.
+	anomalyWindow = 5
+	// maxExplainedAnomalies bounds how many anomalies are sent to Gemini.
+	maxExplainedAnomalies = 3
+	// maxMetricSamples bounds the metric log lines read per run.
+	maxMetricSamples = 20000
+
+	geminiAnomalyPromptTemplate = `
+	You are a Google Cloud Support Engineer expert in GKE and GCSFuse.
+	A changepoint detector found the following anomalies in the gcsfuse metrics
+	logged by the gke-gcsfuse-sidecar. Each anomaly is followed by the sidecar
+	logs around it, in chronological order.
+
+	For each anomaly:
+	1. Say what most likely caused it, based on the surrounding logs (throttling, retries, cache eviction, remounts, node pressure...).
+	2. Say whether it points to a problem in gcsfuse, in GCS, or in the workload's access pattern.
+	Be straightforward and don't write extra info.
+
+	%s
+	`
+)
+
+// metricPair matches "name=12.5" or "name: 12.5" in text payloads.
+var metricPair = regexp.MustCompile(`([A-Za-z_][\w.]*)\s*[=:]\s*([-+]?\d*\.?\d+(?:[eE][-+]?\d+)?)`)
+
+// sample is one metric value read from a log line.
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// Anomaly is a detected spike or level shift of a metric.
+type Anomaly struct {
+	Metric string    `json:"metric"`
+	Time   time.Time `json:"time"`
+	// Kind is "spike" for a single outlier or "shift" for a lasting change,
+	// e.g. a throughput cliff.
+	Kind   string  `json:"kind"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	// Score is the change in robust standard deviations.
+	Score float64 `json:"score"`
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s %s of %s at %s: %.4g -> %.4g (%.1f sigma)",
+		a.Metric, a.Kind, direction(a.Before, a.After), a.Time.Format(time.RFC3339), a.Before, a.After, a.Score)
+}
+
+func direction(before, after float64) string {
+	if after < before {
+		return "drop"
+	}
+	return "rise"
+}
+
+// runAnomaly reads the metric log lines of the window, detects anomalies and
+// asks Gemini to explain the strongest ones with their surrounding logs.
+func runAnomaly(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) error {
+	metrics := map[string]bool{}
+	for _, m := range strings.Split(cfg.Metrics, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			metrics[m] = true
+		}
+	}
+	if len(metrics) == 0 {
+		return fmt.Errorf("-metrics is required in %s mode", ModeAnomaly)
+	}
+
+	cfg.progressf("📈 Reading gcsfuse metric logs between %s and %s...\n", start.Format(time.TimeOnly), end.Format(time.TimeOnly))
+	series, err := fetchMetricSeries(ctx, client, cfg, metrics, start, end)
+	if err != nil {
+		return fmt.Errorf("error reading metric logs: %w", err)
+	}
+
+	var anomalies []Anomaly
+	for name, s := range series {
+		cfg.progressf("   %s: %d samples\n", name, len(s))
+		anomalies = append(anomalies, detectAnomalies(name, s, cfg.AnomalyThreshold)...)
+	}
+	if len(anomalies) == 0 {
+		cfg.progressf("✅ No anomalies found in the specified window.\n")
+		if cfg.Output == OutputJSON {
+			return newReport(cfg, start, end).writeJSON()
+		}
+		return nil
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Score > anomalies[j].Score })
+	for _, a := range anomalies {
+		cfg.progressf("🚨 %s\n", a)
+	}
+
+	var prompt strings.Builder
+	for i, a := range anomalies[:min(len(anomalies), maxExplainedAnomalies)] {
+		logDump, err := fetchLogContext(ctx, client, a.Time, nil, cfg)
+		if err != nil {
+			return fmt.Errorf("error fetching context logs: %w", err)
+		}
+		fmt.Fprintf(&prompt, "ANOMALY %d: %s\nLOGS:\n%s\n\n", i+1, a, logDump)
+	}
+
+	cfg.progressf("🧠 Sending to Gemini for analysis...\n")
+	analysis, err := Generate(ctx, cfg.ProjectID, cfg.Region, fmt.Sprintf(geminiAnomalyPromptTemplate, prompt.String())+languageInstruction(cfg.ReportLanguage))
+	if err != nil {
+		return fmt.Errorf("gemini analysis failed: %w", err)
+	}
+	if cfg.Output == OutputJSON {
+		r := newReport(cfg, start, end)
+		r.Anomalies, r.Analysis = anomalies, analysis
+		return r.writeJSON()
+	}
+	printReport(analysis, nil)
+	return nil
+}
+
+// fetchMetricSeries collects the values of the requested metrics from the
+// sidecar logs, oldest first.
+func fetchMetricSeries(ctx context.Context, client *logadmin.Client, cfg Config, metrics map[string]bool, start, end time.Time) (map[string][]sample, error) {
+	filter := fmt.Sprintf(`%s AND timestamp >= "%s" AND timestamp <= "%s"`,
+		getBaseFilter(cfg), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if cfg.MetricFilter != "" {
+		filter += " AND (" + cfg.MetricFilter + ")"
+	}
+	iter := client.Entries(ctx, logadmin.Filter(filter), logadmin.NewestFirst())
+
+	series := map[string][]sample{}
+	for n := 0; n < maxMetricSamples; n++ {
+		e, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for name, v := range extractMetrics(e.Payload) {
+			if metrics[name] {
+				series[name] = append(series[name], sample{e.Timestamp, v})
+			}
+		}
+	}
+	for _, s := range series {
+		sort.Slice(s, func(i, j int) bool { return s[i].t.Before(s[j].t) })
+	}
+	return series, nil
+}
+
+// extractMetrics returns the numeric fields of a JSON payload, with nested
+// keys joined by dots, and the name=value pairs of its message or of a text
+// payload.
+func extractMetrics(payload interface{}) map[string]float64 {
+	out := map[string]float64{}
+	var text string
+	switch p := payload.(type) {
+	case *structpb.Struct:
+		flatten("", p.AsMap(), out)
+		text, _ = p.AsMap()["message"].(string)
+	default:
+		text = parsePayload(p)
+	}
+	for _, m := range metricPair.FindAllStringSubmatch(text, -1) {
+		if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+			out[m[1]] = v
+		}
+	}
+	return out
+}
+
+func flatten(prefix string, m map[string]interface{}, out map[string]float64) {
+	for k, v := range m {
+		switch x := v.(type) {
+		case float64:
+			out[prefix+k] = x
+		case map[string]interface{}:
+			flatten(prefix+k+".", x, out)
+		}
+	}
+}
+
+// detectAnomalies finds spikes and level shifts in s. Scale is estimated with
+// the median absolute deviation of the first differences, so a few outliers
+// or a single shift do not inflate it.
+func detectAnomalies(name string, s []sample, threshold float64) []Anomaly {
+	if len(s) < 2*anomalyWindow {
+		return nil
+	}
+	values := make([]float64, len(s))
+	diffs := make([]float64, len(s)-1)
+	for i := range s {
+		values[i] = s[i].v
+		if i > 0 {
+			diffs[i-1] = math.Abs(s[i].v - s[i-1].v)
+		}
+	}
+	// For a stationary normal series, median(|x[i]-x[i-1]|) ~ 0.954 sigma.
+	sigma := median(diffs) / 0.954
+	if sigma == 0 {
+		sigma = 1e-9 + 0.01*math.Abs(median(values))
+	}
+
+	var out []Anomaly
+	var shiftAt []int
+	// Level shifts: compare the medians of the windows before and after each
+	// index and keep the local maxima above the threshold.
+	bestAt, bestScore := -1, 0.0
+	flush := func() {
+		if bestAt >= 0 {
+			before := median(values[bestAt-anomalyWindow : bestAt])
+			after := median(values[bestAt : bestAt+anomalyWindow])
+			out = append(out, Anomaly{Metric: name, Time: s[bestAt].t, Kind: "shift", Before: before, After: after, Score: bestScore})
+			shiftAt = append(shiftAt, bestAt)
+		}
+		bestAt, bestScore = -1, 0
+	}
+	for i := anomalyWindow; i+anomalyWindow <= len(values); i++ {
+		before := median(values[i-anomalyWindow : i])
+		after := median(values[i : i+anomalyWindow])
+		score := math.Abs(after-before) / sigma
+		if score < threshold {
+			if bestAt >= 0 && i-bestAt >= anomalyWindow {
+				flush()
+			}
+			continue
+		}
+		if score > bestScore {
+			bestAt, bestScore = i, score
+		}
+	}
+	flush()
+
+	// Spikes: single samples far from both neighbouring windows, away from
+	// the shifts found above.
+	for i := anomalyWindow; i+anomalyWindow < len(values); i++ {
+		nearShift := false
+		for _, j := range shiftAt {
+			if i > j-anomalyWindow && i < j+anomalyWindow {
+				nearShift = true
+			}
+		}
+		if nearShift {
+			continue
+		}
+		around := append(append([]float64{}, values[i-anomalyWindow:i]...), values[i+1:i+1+anomalyWindow]...)
+		base := median(around)
+		if score := math.Abs(values[i]-base) / sigma; score >= 2*threshold {
+			out = append(out, Anomaly{Metric: name, Time: s[i].t, Kind: "spike", Before: base, After: values[i], Score: score})
+		}
+	}
+	return out
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	c := append([]float64{}, xs...)
+	sort.Float64s(c)
+	if len(c)%2 == 1 {
+		return c[len(c)/2]
+	}
+	return (c[len(c)/2-1] + c[len(c)/2]) / 2
+}
@@ -0,0 +1,180 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+)
+
+// errorCluster is the ERROR entries of the window sharing a failure
+// signature.
+type errorCluster struct {
+	Signature   string
+	Count       int
+	First, Last time.Time
+	// Latest is the most recent error, whose context is analyzed.
+	Latest *logging.Entry
+	Pods   []*PodCount
+	// Context, Analysis, Rule and Impact are set when the cluster is
+	// analyzed.
+	Context  string
+	Analysis string
+	Rule     string
+	Impact   *Impact
+}
+
+// config returns cfg scoped to the pod of the latest error.
+func (c *errorCluster) config(cfg Config) Config {
+	scope := scopeOf(cfg, c.Latest)
+	cfg.Namespace, cfg.PodName = scope.Namespace, scope.Pod
+	return cfg
+}
+
+// finding returns c as a finding of the JSON report, anchored at its latest
+// error.
+func (c *errorCluster) finding(cfg Config) Finding {
+	f := newFinding(c.config(cfg), c.Latest, c.Context, c.Analysis)
+	f.Signature, f.Rule = c.Signature, c.Rule
+	f.Count, f.FirstSeen, f.LastSeen = c.Count, &c.First, &c.Last
+	for _, p := range c.Pods {
+		f.Pods = append(f.Pods, *p)
+	}
+	if c.Impact != nil {
+		f.Impacts = []*Impact{c.Impact}
+	}
+	return f
+}
+
+// runClusters reads the ERROR entries of the window, clusters them by failure
+// signature and analyzes the cfg.MaxErrors clusters with the most errors,
+// each at its latest error.
+func runClusters(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) error {
+	cfg.progressf("🔍 Scanning logs for GCSFuse errors between %s and %s...\n",
+		start.Format(time.TimeOnly), end.Format(time.TimeOnly))
+	clusters, err := fetchErrorClusters(ctx, client, cfg, start, end)
+	if err != nil {
+		return fmt.Errorf("error reading logs: %w", err)
+	}
+	if len(clusters) == 0 {
+		cfg.progressf("✅ No GCSFuse errors found in the specified window.\n")
+		if cfg.Output == OutputJSON {
+			return newReport(cfg, start, end).writeJSON()
+		}
+		return nil
+	}
+	analyzed := clusters[:min(len(clusters), cfg.MaxErrors)]
+	cfg.progressf("🚨 Found %d distinct errors, analyzing %d\n", len(clusters), len(analyzed))
+
+	for i, c := range analyzed {
+		cfg.progressf("🧩 Error %d/%d, seen %d times: %s\n", i+1, len(analyzed), c.Count, c.Signature)
+		podCfg := c.config(cfg)
+		if c.Context, err = fetchLogContext(ctx, client, c.Latest.Timestamp, c.Latest, podCfg); err != nil {
+			return fmt.Errorf("error fetching context logs: %w", err)
+		}
+		if c.Analysis, c.Rule, err = explain(ctx, podCfg, c.Latest, c.Context); err != nil {
+			return fmt.Errorf("gemini analysis failed: %w", err)
+		}
+		if cfg.EnrichOwners {
+			cfg.progressf("👥 Resolving affected workload and owners...\n")
+			c.Impact = resolveImpact(ctx, cfg, c.Latest)
+		}
+	}
+
+	if cfg.Output == OutputJSON {
+		r := newReport(cfg, start, end)
+		for _, c := range analyzed {
+			r.Findings = append(r.Findings, c.finding(cfg))
+		}
+		return r.writeJSON()
+	}
+	printClustersReport(clusters, len(analyzed))
+	return nil
+}
+
+// fetchErrorClusters reads up to maxPodErrors ERROR entries of the window,
+// newest first, and groups the ones that are not benign by failure
+// signature, the clusters with the most errors first.
+func fetchErrorClusters(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) ([]*errorCluster, error) {
+	filter := fmt.Sprintf(`%s AND severity>=ERROR AND timestamp >= "%s" AND timestamp <= "%s"`,
+		getBaseFilter(cfg), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	iter := client.Entries(ctx, logadmin.Filter(filter), logadmin.NewestFirst())
+
+	bySig := map[string]*errorCluster{}
+	var clusters []*errorCluster
+	for n := 0; ; n++ {
+		if n == maxPodErrors {
+			log.Printf("Warning: only the latest %d errors were read; narrow the window or pass -namespace to see all of them", maxPodErrors)
+			break
+		}
+		e, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := entryMessage(e)
+		if benign(cfg, msg) {
+			continue
+		}
+		scope := scopeOf(cfg, e)
+		sig := failureSignature(msg, scope.Pod)
+		c, ok := bySig[sig]
+		if !ok {
+			c = &errorCluster{Signature: sig, Latest: e, Last: e.Timestamp}
+			bySig[sig] = c
+			clusters = append(clusters, c)
+		}
+		c.Count++
+		c.First = e.Timestamp
+		c.countPod(scope.Namespace, scope.Pod, e.Timestamp)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Count > clusters[j].Count })
+	return clusters, nil
+}
+
+// countPod counts an error of the pod, read newest first.
+func (c *errorCluster) countPod(namespace, pod string, t time.Time) {
+	for _, p := range c.Pods {
+		if p.Namespace == namespace && p.Pod == pod {
+			p.Errors++
+			return
+		}
+	}
+	c.Pods = append(c.Pods, &PodCount{Namespace: namespace, Pod: pod, Errors: 1, Latest: t})
+}
+
+// printClustersReport prints the analyzed clusters, then the counts of the
+// others.
+func printClustersReport(clusters []*errorCluster, analyzed int) {
+	fmt.Println("\n" + strings.Repeat("-", 50))
+	fmt.Println("🕵️  LOG DETECTIVE REPORT")
+	fmt.Println(strings.Repeat("-", 50))
+	for i, c := range clusters[:analyzed] {
+		fmt.Printf("\n🧩 ERROR %d/%d: %d occurrences in %d pods, first at %s, last at %s\n", i+1, analyzed, c.Count, len(c.Pods),
+			c.First.Format(time.RFC3339), c.Last.Format(time.RFC3339))
+		fmt.Printf("Signature: %s\n", c.Signature)
+		fmt.Println(strings.Repeat("-", 50))
+		fmt.Println(c.Analysis)
+		if c.Impact != nil {
+			fmt.Println(strings.Repeat("-", 50))
+			fmt.Println("👥 WHO IS IMPACTED")
+			fmt.Println(strings.Repeat("-", 50))
+			fmt.Print(formatImpact(c.Impact))
+		}
+	}
+	if rest := clusters[analyzed:]; len(rest) > 0 {
+		fmt.Println("\n" + strings.Repeat("-", 50))
+		fmt.Printf("%d more distinct errors were not analyzed (raise -max-errors):\n", len(rest))
+		for _, c := range rest {
+			fmt.Printf("  %d× between %s and %s: %s\n", c.Count, c.First.Format(time.RFC3339), c.Last.Format(time.RFC3339), c.Signature)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// Log sources tagged on every context line.
+	SourceSidecar   = "sidecar"
+	SourceWorkload  = "workload"
+	SourceCSIDriver = "csi-driver"
+
+	sidecarContainer   = "gke-gcsfuse-sidecar"
+	csiDriverContainer = "gcs-fuse-csi-driver"
+	csiDriverNamespace = "kube-system"
+	// nodeLabel is the log entry label naming the node of a container.
+	nodeLabel = "compute.googleapis.com/resource_name"
+
+	// maxCorrelatedLogs bounds the lines read from each correlated source.
+	maxCorrelatedLogs = 200
+
+	sourcesNote = `Each log line is tagged with its source: sidecar (the gke-gcsfuse-sidecar container), workload:<container> (the application containers of the same pod) or csi-driver (the gcs-fuse-csi-driver node plugin on the pod's node). Follow the failure across sources.`
+)
+
+// contextLine is one log line of the context sent to Gemini.
+type contextLine struct {
+	t    time.Time
+	text string
+}
+
+// contextScope locates the error a context is fetched for: the pod of the
+// workload container logs, and the node and cluster of the CSI driver logs.
+type contextScope struct {
+	Cluster   string
+	Namespace string
+	Pod       string
+	Node      string
+}
+
+// scopeOf returns the scope of the anchor entry, falling back to the pod
+// and namespace flags for the fields the entry does not have, e.g. when
+// there is no anchor entry at all.
+func scopeOf(cfg Config, anchor *logging.Entry) contextScope {
+	s := contextScope{Namespace: cfg.Namespace, Pod: cfg.PodName}
+	if anchor == nil {
+		return s
+	}
+	if anchor.Resource != nil {
+		s.Cluster = anchor.Resource.Labels["cluster_name"]
+		if ns := anchor.Resource.Labels["namespace_name"]; ns != "" {
+			s.Namespace = ns
+		}
+		if pod := anchor.Resource.Labels["pod_name"]; pod != "" {
+			s.Pod = pod
+		}
+	}
+	s.Node = anchor.Labels[nodeLabel]
+	return s
+}
+
+// logSource is a filter selecting the logs of one source.
+type logSource struct {
+	name   string
+	filter string
+}
+
+// correlatedSources returns the workload container and CSI driver logs of
+// scope. A source whose scope is unknown is left out: workload logs need the
+// pod and CSI driver logs the node.
+func correlatedSources(scope contextScope) []logSource {
+	var sources []logSource
+	if scope.Pod != "" {
+		f := fmt.Sprintf(`resource.type="k8s_container" AND resource.labels.pod_name="%s" AND resource.labels.container_name!="%s"`,
+			scope.Pod, sidecarContainer)
+		if scope.Namespace != "" {
+			f += fmt.Sprintf(` AND resource.labels.namespace_name="%s"`, scope.Namespace)
+		}
+		sources = append(sources, logSource{SourceWorkload, f})
+	}
+	if scope.Node != "" {
+		f := fmt.Sprintf(`resource.type="k8s_container" AND resource.labels.namespace_name="%s" AND resource.labels.container_name="%s" AND labels."%s"="%s"`,
+			csiDriverNamespace, csiDriverContainer, nodeLabel, scope.Node)
+		if scope.Cluster != "" {
+			f += fmt.Sprintf(` AND resource.labels.cluster_name="%s"`, scope.Cluster)
+		}
+		sources = append(sources, logSource{SourceCSIDriver, f})
+	}
+	return sources
+}
+
+// readContextLines reads up to limit entries matching filter, tagging each
+// line with source; workload lines also name their container.
+func readContextLines(ctx context.Context, client *logadmin.Client, filter, source string, limit int) []contextLine {
+	iter := client.Entries(ctx, logadmin.Filter(filter))
+	var lines []contextLine
+	for len(lines) < limit {
+		e, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Warning: error fetching %s log line: %v", source, err)
+			break
+		}
+		tag := source
+		if source == SourceWorkload && e.Resource != nil {
+			tag += ":" + e.Resource.Labels["container_name"]
+		}
+		lines = append(lines, contextLine{e.Timestamp, fmt.Sprintf("[%s] [%s] %v", tag, e.Severity, parsePayload(e.Payload))})
+	}
+	return lines
+}
+
+// interleave sorts the lines of all sources chronologically and formats
+// them; lines logged at the same time keep the order of their sources.
+func interleave(lines []contextLine) string {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].t.Before(lines[j].t) })
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = fmt.Sprintf("[%s] %s", l.t.Format("15:04:05"), l.text)
+	}
+	return strings.Join(out, "\n")
+}
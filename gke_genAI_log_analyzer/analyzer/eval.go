@@ -0,0 +1,208 @@
+package analyzer
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Classifications scored by the evaluation harness.
+var evalCategories = []string{"permission", "network", "throttling", "configuration", "not-found", "resource", "none"}
+
+// evalInstruction is appended to the production prompt so the answer ends
+// with a classification that can be scored. JSON reports use it too.
+const evalInstruction = `
+	After your analysis, add a last line of the form "CATEGORY: <category>" where <category> is exactly one of:
+	permission (403, missing IAM roles), network (timeouts, resets, DNS, metadata server), throttling (429, rate limits, quota),
+	configuration (invalid flags, mount options or config), not-found (missing bucket or object), resource (out of memory or disk),
+	none (no real failure).
+	`
+
+//go:embed evaldata/*.json
+var evalFS embed.FS
+
+// categoryLine matches the classification line, tolerating Markdown emphasis.
+var categoryLine = regexp.MustCompile(`(?im)^[\s*_#>-]*CATEGORY[\s*_]*:[\s*_]*([a-z-]+)`)
+
+// Fixture is an anonymized sidecar log excerpt with its expected
+// classification.
+type Fixture struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Expected    string   `json:"expected"`
+	Logs        []string `json:"logs"`
+}
+
+// EvalConfig holds the flags of the evaluation harness.
+type EvalConfig struct {
+	ProjectID string
+	Region    string
+	// Fixtures is a directory of extra fixture files; fixtures with the same
+	// name replace the embedded ones.
+	Fixtures    string
+	Only        string
+	MinAccuracy float64
+	Verbose     bool
+}
+
+// RegisterFlags binds the eval flags to fs.
+func (cfg *EvalConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.ProjectID, "project", "", "GCP Project ID")
+	fs.StringVar(&cfg.Region, "region", "us-central1", "Vertex AI Region")
+	fs.StringVar(&cfg.Fixtures, "fixtures", "", "Directory of additional fixture JSON files (name, description, expected, logs); same-named fixtures replace the built-in ones")
+	fs.StringVar(&cfg.Only, "only", "", "Comma-separated fixture names to run. Defaults to all.")
+	fs.Float64Var(&cfg.MinAccuracy, "min-accuracy", 0.8, "Fail when the fraction of correctly classified fixtures is lower")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "Print the model's full answer for misclassified fixtures")
+}
+
+// Validate reports missing or inconsistent flag values.
+func (cfg *EvalConfig) Validate() error {
+	if cfg.ProjectID == "" {
+		return fmt.Errorf("please provide -project <PROJECT_ID>")
+	}
+	if cfg.MinAccuracy < 0 || cfg.MinAccuracy > 1 {
+		return fmt.Errorf("-min-accuracy must be between 0 and 1")
+	}
+	return nil
+}
+
+// LoadFixtures returns the built-in fixtures, replaced or extended by those
+// in dir when it is set, sorted by name.
+func LoadFixtures(dir string) ([]Fixture, error) {
+	byName := map[string]Fixture{}
+	load := func(fsys fs.FS, pattern string) error {
+		paths, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			b, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			var f Fixture
+			if err := json.Unmarshal(b, &f); err != nil {
+				return fmt.Errorf("decoding fixture %s: %w", p, err)
+			}
+			if f.Name == "" {
+				f.Name = strings.TrimSuffix(filepath.Base(p), ".json")
+			}
+			if !validCategory(f.Expected) {
+				return fmt.Errorf("fixture %s: unknown expected category %q (want one of %s)", p, f.Expected, strings.Join(evalCategories, ", "))
+			}
+			byName[f.Name] = f
+		}
+		return nil
+	}
+	if err := load(evalFS, "evaldata/*.json"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := load(os.DirFS(dir), "*.json"); err != nil {
+			return nil, err
+		}
+	}
+	fixtures := make([]Fixture, 0, len(byName))
+	for _, f := range byName {
+		fixtures = append(fixtures, f)
+	}
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].Name < fixtures[j].Name })
+	return fixtures, nil
+}
+
+func validCategory(c string) bool {
+	for _, v := range evalCategories {
+		if c == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCategory returns the classification of a model answer, or "" when it
+// has none.
+func parseCategory(answer string) string {
+	m := categoryLine.FindAllStringSubmatch(answer, -1)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[len(m)-1][1])
+}
+
+// RunEval sends every fixture through the current prompt and model and scores
+// the classifications. It fails when the accuracy is below cfg.MinAccuracy,
+// so prompt and model changes can be checked before they are merged.
+func RunEval(ctx context.Context, cfg EvalConfig) error {
+	fixtures, err := LoadFixtures(cfg.Fixtures)
+	if err != nil {
+		return err
+	}
+	if cfg.Only != "" {
+		only := map[string]bool{}
+		for _, n := range strings.Split(cfg.Only, ",") {
+			only[strings.TrimSpace(n)] = true
+		}
+		var kept []Fixture
+		for _, f := range fixtures {
+			if only[f.Name] {
+				kept = append(kept, f)
+			}
+		}
+		fixtures = kept
+	}
+	if len(fixtures) == 0 {
+		return fmt.Errorf("no fixtures to evaluate")
+	}
+
+	fmt.Printf("🧪 Evaluating %s on %d fixtures...\n", geminiModel, len(fixtures))
+	correct := 0
+	// confusion counts expected -> got for the misclassifications.
+	confusion := map[string]int{}
+	for _, f := range fixtures {
+		prompt := fmt.Sprintf(geminiPromptTemplate, strings.Join(f.Logs, "\n")) + evalInstruction
+		answer, err := Generate(ctx, cfg.ProjectID, cfg.Region, prompt)
+		if err != nil {
+			return fmt.Errorf("fixture %s: %w", f.Name, err)
+		}
+		got := parseCategory(answer)
+		if got == f.Expected {
+			correct++
+			fmt.Printf("✅ %-28s %s\n", f.Name, got)
+			continue
+		}
+		if got == "" {
+			got = "(none given)"
+		}
+		confusion[f.Expected+" -> "+got]++
+		fmt.Printf("❌ %-28s expected %s, got %s\n", f.Name, f.Expected, got)
+		if cfg.Verbose {
+			fmt.Println(strings.TrimSpace(answer))
+		}
+	}
+
+	accuracy := float64(correct) / float64(len(fixtures))
+	fmt.Printf("\n📊 Accuracy: %d/%d (%.0f%%), minimum %.0f%%\n", correct, len(fixtures), accuracy*100, cfg.MinAccuracy*100)
+	if len(confusion) > 0 {
+		keys := make([]string, 0, len(confusion))
+		for k := range confusion {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Println("Misclassifications:")
+		for _, k := range keys {
+			fmt.Printf("   %s: %d\n", k, confusion[k])
+		}
+	}
+	if accuracy < cfg.MinAccuracy {
+		return fmt.Errorf("accuracy %.2f is below -min-accuracy %.2f", accuracy, cfg.MinAccuracy)
+	}
+	return nil
+}
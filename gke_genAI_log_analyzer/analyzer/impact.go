@@ -0,0 +1,174 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+// Impact describes the workload hit by an error and the people to route the
+// report to.
+type Impact struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	// WorkloadKind and WorkloadName identify the top-level owner of the pod,
+	// e.g. Deployment/inference or CronJob/nightly-training.
+	WorkloadKind string `json:"workload_kind,omitempty"`
+	WorkloadName string `json:"workload_name,omitempty"`
+	// Owners holds the namespace labels and annotations matching -owner-keys.
+	Owners map[string]string `json:"owners,omitempty"`
+	// Source says whether the owner chain came from the cluster or had to be
+	// guessed from the log entry's pod labels.
+	Source string `json:"source"`
+}
+
+// kubeObject is the subset of a Kubernetes object read for enrichment.
+type kubeObject struct {
+	Metadata struct {
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		OwnerReferences []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			Controller bool   `json:"controller"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+}
+
+// resolveImpact finds the workload owning the pod of entry and the owner
+// labels of its namespace. Failures to reach the cluster are logged and the
+// pod labels recorded in the entry are used instead.
+func resolveImpact(ctx context.Context, cfg Config, entry *logging.Entry) *Impact {
+	im := &Impact{Owners: map[string]string{}}
+	if entry.Resource != nil {
+		im.Cluster = entry.Resource.Labels["cluster_name"]
+		im.Namespace = entry.Resource.Labels["namespace_name"]
+		im.Pod = entry.Resource.Labels["pod_name"]
+	}
+	if im.Pod == "" || im.Namespace == "" {
+		return im
+	}
+
+	obj, err := getKubeObject(ctx, cfg, "Pod", im.Pod, im.Namespace)
+	if err != nil {
+		log.Printf("Warning: resolving the owner of pod %s/%s: %v", im.Namespace, im.Pod, err)
+		im.WorkloadKind, im.WorkloadName = guessWorkload(im.Pod, entry.Labels)
+		im.Source = "log labels"
+	} else {
+		// Walk the controller chain, e.g. Pod -> ReplicaSet -> Deployment. An
+		// owner that cannot be read (say, a custom resource without list
+		// permissions) ends the walk as the workload.
+		im.WorkloadKind, im.WorkloadName, im.Source = "Pod", im.Pod, "cluster"
+		for owner := controllerOf(obj); owner != ""; owner = controllerOf(obj) {
+			im.WorkloadKind, im.WorkloadName, _ = strings.Cut(owner, "/")
+			if obj, err = getKubeObject(ctx, cfg, im.WorkloadKind, im.WorkloadName, im.Namespace); err != nil {
+				break
+			}
+		}
+	}
+
+	ns, err := getKubeObject(ctx, cfg, "Namespace", im.Namespace, "")
+	if err != nil {
+		log.Printf("Warning: reading namespace %s: %v", im.Namespace, err)
+		return im
+	}
+	for _, key := range strings.Split(cfg.OwnerKeys, ",") {
+		key = strings.TrimSpace(key)
+		if v, ok := ns.Metadata.Labels[key]; ok && key != "" {
+			im.Owners[key] = v
+		} else if v, ok := ns.Metadata.Annotations[key]; ok && key != "" {
+			im.Owners[key] = v
+		}
+	}
+	return im
+}
+
+// controllerOf returns "Kind/name" of the controlling owner of obj, or "".
+func controllerOf(obj *kubeObject) string {
+	for _, ref := range obj.Metadata.OwnerReferences {
+		if ref.Controller {
+			return ref.Kind + "/" + ref.Name
+		}
+	}
+	return ""
+}
+
+func getKubeObject(ctx context.Context, cfg Config, kind, name, namespace string) (*kubeObject, error) {
+	args := []string{"get", strings.ToLower(kind), name, "-o", "json"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if cfg.KubeContext != "" {
+		args = append(args, "--context", cfg.KubeContext)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.Kubectl, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("kubectl get %s %s: %v: %s", kind, name, err, strings.TrimSpace(stderr.String()))
+	}
+	var obj kubeObject
+	if err := json.Unmarshal(stdout.Bytes(), &obj); err != nil {
+		return nil, fmt.Errorf("decoding %s %s: %v", kind, name, err)
+	}
+	return &obj, nil
+}
+
+// guessWorkload derives the owning workload of a pod that no longer exists
+// from the k8s-pod/* labels Cloud Logging records with container logs, which
+// have the dots of the pod label keys replaced by underscores.
+func guessWorkload(pod string, labels map[string]string) (string, string) {
+	if job := labels["k8s-pod/job-name"]; job != "" {
+		return "Job", job
+	}
+	if set := labels["k8s-pod/statefulset_kubernetes_io/pod-name"]; set != "" {
+		if i := strings.LastIndex(pod, "-"); i > 0 {
+			return "StatefulSet", pod[:i]
+		}
+	}
+	// Deployment pods are named <deployment>-<pod-template-hash>-<suffix>.
+	if hash := labels["k8s-pod/pod-template-hash"]; hash != "" {
+		if i := strings.Index(pod, "-"+hash+"-"); i > 0 {
+			return "Deployment", pod[:i]
+		}
+	}
+	return "Pod", pod
+}
+
+func formatImpact(im *Impact) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Cluster:   %s\n", orUnknown(im.Cluster))
+	fmt.Fprintf(&b, "Namespace: %s\n", orUnknown(im.Namespace))
+	fmt.Fprintf(&b, "Pod:       %s\n", orUnknown(im.Pod))
+	if im.WorkloadKind != "" {
+		fmt.Fprintf(&b, "Workload:  %s/%s (from %s)\n", im.WorkloadKind, im.WorkloadName, im.Source)
+	}
+	if len(im.Owners) == 0 {
+		b.WriteString("Owners:    none found on the namespace\n")
+		return b.String()
+	}
+	keys := make([]string, 0, len(im.Owners))
+	for k := range im.Owners {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "Owner:     %s=%s\n", k, im.Owners[k])
+	}
+	return b.String()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,167 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Output formats.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// A finding keeps up to maxExcerptLines lines of its context, ending
+// excerptLookahead lines after the anchor error.
+const (
+	maxExcerptLines  = 50
+	excerptLookahead = 10
+)
+
+// volumeMount matches the CSI mount path of a volume, e.g.
+// /var/lib/kubelet/pods/<uid>/volumes/kubernetes.io~csi/<volume>/mount.
+var volumeMount = regexp.MustCompile(`/volumes/kubernetes\.io~csi/([^/\s"']+)/mount`)
+
+// LogLine is a log entry of a JSON report.
+type LogLine struct {
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+}
+
+// PodCount is a pod sharing the failure of a finding in pods mode.
+type PodCount struct {
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Errors    int       `json:"errors"`
+	Latest    time.Time `json:"latest"`
+}
+
+// Finding is one analyzed failure of a JSON report.
+type Finding struct {
+	AnchorError LogLine `json:"anchor_error"`
+	// Classification is one of permission, network, throttling,
+	// configuration, not-found, resource or none, or empty when Gemini did
+	// not classify the failure.
+	Classification string `json:"classification"`
+	// Rule is the local rule that classified the failure without Gemini,
+	// or empty when Gemini analyzed it.
+	Rule      string `json:"rule,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	// Volume is the CSI volume named by the mount paths in the logs.
+	Volume string `json:"volume,omitempty"`
+	// Signature and Pods are the failure signature and its pods in pods
+	// mode and with -max-errors, which also counts the errors of the
+	// signature in the window.
+	Signature string     `json:"signature,omitempty"`
+	Pods      []PodCount `json:"pods,omitempty"`
+	Count     int        `json:"count,omitempty"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// Context is an excerpt of the interleaved log context around the anchor
+	// error.
+	Context []string  `json:"context"`
+	Verdict string    `json:"verdict"`
+	Impacts []*Impact `json:"impacts,omitempty"`
+}
+
+// Report is the -output=json result of a run, for alerting pipelines and
+// ticketing bots. Findings is empty when the window has no errors.
+type Report struct {
+	Mode     string    `json:"mode"`
+	Project  string    `json:"project"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Findings []Finding `json:"findings"`
+	// Anomalies and Analysis are the result of anomaly mode.
+	Anomalies []Anomaly `json:"anomalies,omitempty"`
+	Analysis  string    `json:"analysis,omitempty"`
+}
+
+func newReport(cfg Config, start, end time.Time) *Report {
+	return &Report{Mode: cfg.Mode, Project: cfg.ProjectID, Start: start, End: end, Findings: []Finding{}}
+}
+
+// writeJSON prints r to stdout.
+func (r *Report) writeJSON() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// newFinding builds the finding of the anchor error, its interleaved context
+// and the Gemini answer, whose classification line becomes Classification.
+func newFinding(cfg Config, anchor *logging.Entry, logDump, answer string) Finding {
+	scope := scopeOf(cfg, anchor)
+	payload := parsePayload(anchor.Payload)
+	lines := strings.Split(logDump, "\n")
+	if logDump == "" {
+		lines = nil
+	}
+	return Finding{
+		AnchorError:    LogLine{Time: anchor.Timestamp, Severity: anchor.Severity.String(), Message: entryMessage(anchor)},
+		Classification: parseCategory(answer),
+		Cluster:        scope.Cluster,
+		Namespace:      scope.Namespace,
+		Pod:            scope.Pod,
+		Volume:         volumeOf(payload, lines),
+		Context:        excerpt(lines, payload),
+		Verdict:        strings.TrimSpace(categoryLine.ReplaceAllString(answer, "")),
+	}
+}
+
+// volumeOf returns the volume mounted at the path named by the anchor error
+// payload or, failing that, by the latest context line naming one.
+func volumeOf(payload string, lines []string) string {
+	if m := volumeMount.FindStringSubmatch(payload); m != nil {
+		return m[1]
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := volumeMount.FindStringSubmatch(lines[i]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// excerpt returns up to maxExcerptLines lines of the context ending
+// excerptLookahead lines after the anchor error, or its last lines when the
+// anchor error is not among them.
+func excerpt(lines []string, payload string) []string {
+	end := len(lines)
+	for i, l := range lines {
+		if strings.HasSuffix(l, payload) {
+			end = min(i+1+excerptLookahead, len(lines))
+			break
+		}
+	}
+	return lines[max(0, end-maxExcerptLines):end]
+}
+
+// classifyInstruction asks Gemini for a classification line in JSON output,
+// where it becomes the classification of the finding.
+func classifyInstruction(cfg Config) string {
+	if cfg.Output != OutputJSON {
+		return ""
+	}
+	return evalInstruction
+}
+
+// progressf prints a progress line: on stdout with the text report and on
+// stderr with the JSON one, so stdout only carries JSON.
+func (cfg Config) progressf(format string, args ...any) {
+	var w io.Writer = os.Stdout
+	if cfg.Output == OutputJSON {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, args...)
+}
@@ -0,0 +1,312 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// maxPodErrors bounds the ERROR entries read per run in pods mode and
+	// with -max-errors.
+	maxPodErrors = 5000
+	// maxSignaturePods bounds how many pods of a failure signature have their
+	// context sent to Gemini; the others are only listed in the report.
+	maxSignaturePods = 3
+	// maxSignatureLen truncates failure signatures.
+	maxSignatureLen = 200
+
+	geminiPodsPromptTemplate = `
+	You are a Google Cloud Support Engineer expert in GKE and GCSFuse.
+	The following pods failed with the same gke-gcsfuse-sidecar error signature:
+	%s
+
+	The logs of some of them follow, each in chronological order.
+	` + sourcesNote + `
+
+	Focus on:
+	1. What triggered the first real error which caused the failure? (Look at the INFO logs immediately preceding the ERROR). Please be straightforward and don't write extra info.
+	2. Is this a permission issue (403), network (timeout), or configuration?
+	3. Does the failure depend on the pod, e.g. its node or its volume attributes, or is it common to all of them?
+
+	%s
+	`
+)
+
+// signatureNoise lists the variable parts of error messages, in the order
+// they are replaced to compute failure signatures.
+var signatureNoise = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ][\d:.]+(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`gs://\S+|(/[\w.@%+=-]+)+/?`), "<path>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<id>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]{8,}\b`), "<hex>"},
+	// HTTP status codes and other short numbers are kept: 403 and 404 are
+	// different failures.
+	{regexp.MustCompile(`\b\d+\.\d+\w*|\b\d{4,}\w*`), "<n>"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+// podErrors is the ERROR entries of one pod in the window.
+type podErrors struct {
+	Namespace string
+	Pod       string
+	Count     int
+	// Latest is the most recent error, whose context is analyzed.
+	Latest *logging.Entry
+}
+
+func (p *podErrors) name() string {
+	return p.Namespace + "/" + p.Pod
+}
+
+// failure groups the pods whose latest error has the same signature.
+type failure struct {
+	Signature string
+	Pods      []*podErrors
+	Errors    int
+	Analysis  string
+	// Rule is the local rule that classified the failure, if any.
+	Rule    string
+	Impacts []*Impact
+	// Context is the log context of the first pod.
+	Context string
+}
+
+// finding returns f as a finding of the JSON report, anchored at the latest
+// error of its first pod.
+func (f *failure) finding(cfg Config) Finding {
+	first := f.Pods[0]
+	podCfg := cfg
+	podCfg.Namespace, podCfg.PodName = first.Namespace, first.Pod
+	out := newFinding(podCfg, first.Latest, f.Context, f.Analysis)
+	out.Signature, out.Rule, out.Impacts = f.Signature, f.Rule, f.Impacts
+	for _, p := range f.Pods {
+		out.Pods = append(out.Pods, PodCount{Namespace: p.Namespace, Pod: p.Pod, Errors: p.Count, Latest: p.Latest.Timestamp})
+	}
+	return out
+}
+
+// runPods reads the ERROR entries of every pod in the window, groups the pods
+// by the failure signature of their latest error and asks Gemini for one
+// analysis per signature, with the context of up to maxSignaturePods of its
+// pods.
+func runPods(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) error {
+	scope := "all namespaces"
+	if cfg.Namespace != "" {
+		scope = "namespace " + cfg.Namespace
+	}
+	cfg.progressf("🔍 Scanning logs of the pods in %s for GCSFuse errors between %s and %s...\n",
+		scope, start.Format(time.TimeOnly), end.Format(time.TimeOnly))
+	pods, err := fetchPodErrors(ctx, client, cfg, start, end)
+	if err != nil {
+		return fmt.Errorf("error reading logs: %w", err)
+	}
+	if len(pods) == 0 {
+		cfg.progressf("✅ No GCSFuse errors found in the specified window.\n")
+		if cfg.Output == OutputJSON {
+			return newReport(cfg, start, end).writeJSON()
+		}
+		return nil
+	}
+
+	failures := groupFailures(pods)
+	cfg.progressf("🚨 Found errors in %d pods with %d distinct failure signatures\n", len(pods), len(failures))
+
+	for i, f := range failures {
+		msg := entryMessage(f.Pods[0].Latest)
+		rule := classifyLocally(cfg, msg)
+		pods := f.Pods[:min(len(f.Pods), maxSignaturePods)]
+		if rule != nil {
+			// Only the context of the finding is needed.
+			pods = f.Pods[:1]
+		}
+		var logs strings.Builder
+		for _, p := range pods {
+			podCfg := cfg
+			podCfg.Namespace, podCfg.PodName = p.Namespace, p.Pod
+			logDump, err := fetchLogContext(ctx, client, p.Latest.Timestamp, p.Latest, podCfg)
+			if err != nil {
+				return fmt.Errorf("error fetching context logs of pod %s: %w", p.name(), err)
+			}
+			if f.Context == "" {
+				f.Context = logDump
+			}
+			fmt.Fprintf(&logs, "POD %s:\nLOGS:\n%s\n\n", p.name(), logDump)
+		}
+
+		if rule != nil {
+			cfg.progressf("📚 Failure %d/%d is the known failure %s, skipping Gemini\n", i+1, len(failures), rule.Name)
+			f.Analysis, f.Rule = rule.analysis(msg), rule.Name
+		} else {
+			cfg.progressf("🧠 Sending failure %d/%d to Gemini for analysis...\n", i+1, len(failures))
+			prompt := fmt.Sprintf(geminiPodsPromptTemplate, f.Signature, logs.String()) + languageInstruction(cfg.ReportLanguage) + classifyInstruction(cfg)
+			if f.Analysis, err = Generate(ctx, cfg.ProjectID, cfg.Region, prompt); err != nil {
+				return fmt.Errorf("gemini analysis failed: %w", err)
+			}
+		}
+
+		if cfg.EnrichOwners {
+			cfg.progressf("👥 Resolving affected workloads and owners...\n")
+			f.Impacts = resolveImpacts(ctx, cfg, f.Pods)
+		}
+	}
+
+	if cfg.Output == OutputJSON {
+		r := newReport(cfg, start, end)
+		for _, f := range failures {
+			r.Findings = append(r.Findings, f.finding(cfg))
+		}
+		return r.writeJSON()
+	}
+	printPodsReport(failures)
+	return nil
+}
+
+// fetchPodErrors reads up to maxPodErrors ERROR entries of the window, newest
+// first, and counts the ones that are not benign per pod.
+func fetchPodErrors(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time) ([]*podErrors, error) {
+	filter := fmt.Sprintf(`%s AND severity>=ERROR AND timestamp >= "%s" AND timestamp <= "%s"`,
+		getBaseFilter(cfg), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	iter := client.Entries(ctx, logadmin.Filter(filter), logadmin.NewestFirst())
+
+	byPod := map[string]*podErrors{}
+	var pods []*podErrors
+	for n := 0; ; n++ {
+		if n == maxPodErrors {
+			log.Printf("Warning: only the latest %d errors were read; narrow the window or pass -namespace to see all of them", maxPodErrors)
+			break
+		}
+		e, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ns, pod string
+		if e.Resource != nil {
+			ns, pod = e.Resource.Labels["namespace_name"], e.Resource.Labels["pod_name"]
+		}
+		if pod == "" || benign(cfg, entryMessage(e)) {
+			continue
+		}
+		p, ok := byPod[ns+"/"+pod]
+		if !ok {
+			p = &podErrors{Namespace: ns, Pod: pod, Latest: e}
+			byPod[ns+"/"+pod] = p
+			pods = append(pods, p)
+		}
+		p.Count++
+	}
+	return pods, nil
+}
+
+// groupFailures groups pods by the signature of their latest error, the
+// failures hitting the most pods first.
+func groupFailures(pods []*podErrors) []*failure {
+	bySig := map[string]*failure{}
+	var failures []*failure
+	for _, p := range pods {
+		sig := failureSignature(entryMessage(p.Latest), p.Pod)
+		f, ok := bySig[sig]
+		if !ok {
+			f = &failure{Signature: sig}
+			bySig[sig] = f
+			failures = append(failures, f)
+		}
+		f.Pods = append(f.Pods, p)
+		f.Errors += p.Count
+	}
+	for _, f := range failures {
+		sort.SliceStable(f.Pods, func(i, j int) bool { return f.Pods[i].Count > f.Pods[j].Count })
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		if len(failures[i].Pods) != len(failures[j].Pods) {
+			return len(failures[i].Pods) > len(failures[j].Pods)
+		}
+		return failures[i].Errors > failures[j].Errors
+	})
+	return failures
+}
+
+// failureSignature strips the parts of msg that vary between pods hitting the
+// same failure: the pod name, timestamps, quoted values, paths, IDs and long
+// numbers.
+func failureSignature(msg, pod string) string {
+	if pod != "" {
+		msg = strings.ReplaceAll(msg, pod, "<pod>")
+	}
+	for _, n := range signatureNoise {
+		msg = n.re.ReplaceAllString(msg, n.repl)
+	}
+	msg = strings.TrimSpace(msg)
+	if len(msg) > maxSignatureLen {
+		msg = msg[:maxSignatureLen] + "..."
+	}
+	return msg
+}
+
+// entryMessage returns the message field of a JSON payload, or the text of
+// any other payload.
+func entryMessage(e *logging.Entry) string {
+	if p, ok := e.Payload.(*structpb.Struct); ok {
+		if msg, ok := p.AsMap()["message"].(string); ok {
+			return msg
+		}
+	}
+	return parsePayload(e.Payload)
+}
+
+// resolveImpacts resolves the impact of each pod, keeping one per workload.
+func resolveImpacts(ctx context.Context, cfg Config, pods []*podErrors) []*Impact {
+	seen := map[string]bool{}
+	var impacts []*Impact
+	for _, p := range pods {
+		im := resolveImpact(ctx, cfg, p.Latest)
+		key := im.Namespace + "/" + im.WorkloadKind + "/" + im.WorkloadName
+		if im.WorkloadKind == "" || !seen[key] {
+			seen[key] = true
+			impacts = append(impacts, im)
+		}
+	}
+	return impacts
+}
+
+func printPodsReport(failures []*failure) {
+	fmt.Println("\n" + strings.Repeat("-", 50))
+	fmt.Println("🕵️  LOG DETECTIVE REPORT")
+	fmt.Println(strings.Repeat("-", 50))
+	for i, f := range failures {
+		fmt.Printf("\n🧩 FAILURE %d/%d: %d pods, %d errors\n", i+1, len(failures), len(f.Pods), f.Errors)
+		fmt.Printf("Signature: %s\n", f.Signature)
+		for _, p := range f.Pods {
+			fmt.Printf("  %s: %d errors, latest at %s\n", p.name(), p.Count, p.Latest.Timestamp.Format(time.RFC3339))
+		}
+		fmt.Println(strings.Repeat("-", 50))
+		fmt.Println(f.Analysis)
+		if len(f.Impacts) > 0 {
+			fmt.Println(strings.Repeat("-", 50))
+			fmt.Println("👥 WHO IS IMPACTED")
+			fmt.Println(strings.Repeat("-", 50))
+			for j, im := range f.Impacts {
+				if j > 0 {
+					fmt.Println()
+				}
+				fmt.Print(formatImpact(im))
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+// Rule classifies a well-known failure from the message of its anchor error,
+// so that it is reported without calling Gemini.
+type Rule struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Pattern is a regular expression matched against the anchor error.
+	Pattern string `json:"pattern"`
+	// Explanation is the cause and fix reported in place of Gemini's verdict.
+	Explanation string `json:"explanation"`
+
+	re *regexp.Regexp
+}
+
+// builtinRules are tried in order, so the specific rules come before the
+// generic ones; a mount that times out on a deadline is a configuration
+// issue, not a network one. Rules of category none match benign errors,
+// which are skipped when looking for the failure to analyze.
+var builtinRules = []*Rule{
+	{
+		Name: "benign-volume-size", Category: "none",
+		Pattern:     `failed to calculate volume total size for`,
+		Explanation: "The CSI driver cannot compute the size of a gcsfuse volume, which is expected and harmless; it is not the cause of a failure.",
+	},
+	{
+		Name: "mount-timeout", Category: "configuration",
+		Pattern:     `(?i)MountVolume\.SetUp failed.*(timed out|deadline exceeded)|timed out waiting for (the )?(condition|mount)|failed to wait for (the )?mount`,
+		Explanation: "The volume was not mounted in time, usually because the gcsfuse sidecar was not injected or not started: check the gke-gcsfuse/volumes: \"true\" pod annotation, that the GCS FUSE CSI driver is enabled on the cluster, and the sidecar resources.",
+	},
+	{
+		Name: "invalid-flag", Category: "configuration",
+		Pattern:     `(?i)unknown (shorthand )?flag|flag provided but not defined|failed to parse (flags|config|mount options)|invalid (argument|value) .* for (flag|option)`,
+		Explanation: "gcsfuse rejected a mount option: fix or remove it in the mountOptions of the volume, checking that the gcsfuse version of the sidecar supports it.",
+	},
+	{
+		Name: "bucket-not-found", Category: "not-found",
+		Pattern:     `(?i)bucket (does not|doesn't) exist|bucket not found|no such bucket`,
+		Explanation: "The bucket of the volume does not exist: check the bucketName volume attribute, and that the bucket lives in the project it is expected in.",
+	},
+	{
+		Name: "permission-denied", Category: "permission",
+		Pattern:     `(?i)\bError 40[13]\b|does not have storage\.\w+\.\w+ access|AccessDenied|PermissionDenied|, forbidden\b|\b403 Forbidden\b|is forbidden: User\b`,
+		Explanation: "The Kubernetes service account of the pod lacks access to the bucket: grant its Workload Identity principal roles/storage.objectViewer (read-only) or roles/storage.objectUser on the bucket.",
+	},
+	{
+		Name: "throttling", Category: "throttling",
+		Pattern:     `(?i)\bError 429\b|rateLimitExceeded|Too Many Requests|ResourceExhausted|quota exceeded`,
+		Explanation: "Cloud Storage throttled the requests: spread the writes over more objects, lower the request rate or the parallelism of the workload, or request a higher quota.",
+	},
+	{
+		Name: "metadata-server", Category: "network",
+		Pattern:     `169\.254\.169\.254|metadata\.google\.internal|oauth2: cannot fetch token`,
+		Explanation: "gcsfuse cannot get a token from the GKE metadata server: check that the GKE metadata server runs on the node and Workload Identity is enabled on the node pool, and that network policies allow the pod to reach 169.254.169.254.",
+	},
+	{
+		Name: "dns-failure", Category: "network",
+		Pattern:     `(?i)no such host|dial tcp: lookup|server misbehaving|temporary failure in name resolution`,
+		Explanation: "storage.googleapis.com could not be resolved: check kube-dns or Cloud DNS on the cluster and the DNS settings of the pod.",
+	},
+	{
+		Name: "connection-failure", Category: "network",
+		Pattern:     `(?i)connection reset by peer|connection refused|i/o timeout|TLS handshake timeout|broken pipe|unexpected EOF`,
+		Explanation: "A connection to Cloud Storage failed: check the egress of the node, e.g. its Private Google Access, firewall rules and NAT, and whether the failures are transient retries.",
+	},
+	{
+		Name: "oom-kill", Category: "resource",
+		Pattern:     `(?i)OOMKilled|out of memory|oom[- ]?kill`,
+		Explanation: "The gcsfuse sidecar ran out of memory: raise the gke-gcsfuse/memory-limit pod annotation, or lower the parallelism and buffer sizes of gcsfuse.",
+	},
+	{
+		Name: "disk-full", Category: "resource",
+		Pattern:     `(?i)no space left on device|disk quota exceeded`,
+		Explanation: "The disk of the file cache or of the sidecar's temporary files is full: raise the gke-gcsfuse/ephemeral-storage-limit annotation or the size of the cache volume, or limit file-cache:max-size-mb.",
+	},
+	{
+		Name: "object-not-found", Category: "not-found",
+		Pattern:     `(?i)\bError 404\b|object doesn't exist|no such object`,
+		Explanation: "An object the workload opens does not exist in the bucket: check its path, and whether another writer deleted or renamed it.",
+	},
+}
+
+// errorCodes classifies the HTTP status codes and gRPC codes of the anchor
+// errors the rules do not match.
+var errorCodes = map[string]struct{ category, meaning string }{
+	"401":               {"permission", "the request was not authenticated"},
+	"403":               {"permission", "the caller lacks access to the bucket or object"},
+	"404":               {"not-found", "the bucket or object does not exist"},
+	"408":               {"network", "the request timed out"},
+	"429":               {"throttling", "the request rate was limited"},
+	"502":               {"network", "a gateway between gcsfuse and Cloud Storage failed"},
+	"503":               {"network", "Cloud Storage was unavailable"},
+	"504":               {"network", "a gateway between gcsfuse and Cloud Storage timed out"},
+	"Unauthenticated":   {"permission", "the request was not authenticated"},
+	"PermissionDenied":  {"permission", "the caller lacks access to the bucket or object"},
+	"NotFound":          {"not-found", "the bucket or object does not exist"},
+	"ResourceExhausted": {"throttling", "the request rate or quota was exhausted"},
+	"DeadlineExceeded":  {"network", "the request timed out"},
+	"Unavailable":       {"network", "Cloud Storage was unavailable"},
+}
+
+// errorCode matches an HTTP status, e.g. "googleapi: Error 403" or
+// "status 503", or a gRPC code, e.g. "rpc error: code = Unavailable".
+var errorCode = regexp.MustCompile(`(?i)\b(?:error|status|code)\s*[:=]?\s*(\d{3})\b|\bcode\s*=\s*([A-Z][A-Za-z]+)`)
+
+// loadRules returns the rules of cfg.RulesFile followed by the built-in
+// ones, which those of the file replace when named alike.
+func loadRules(cfg Config) ([]*Rule, error) {
+	var rules []*Rule
+	if cfg.RulesFile != "" {
+		b, err := os.ReadFile(cfg.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", cfg.RulesFile, err)
+		}
+	}
+	named := map[string]bool{}
+	for _, r := range rules {
+		if r.Name == "" || r.Pattern == "" {
+			return nil, fmt.Errorf("%s: every rule needs a name and a pattern", cfg.RulesFile)
+		}
+		if !validCategory(r.Category) {
+			return nil, fmt.Errorf("%s: rule %s: unknown category %q (want one of %s)", cfg.RulesFile, r.Name, r.Category, strings.Join(evalCategories, ", "))
+		}
+		named[r.Name] = true
+	}
+	for _, r := range builtinRules {
+		if !named[r.Name] {
+			rules = append(rules, r)
+		}
+	}
+	for _, r := range rules {
+		if r.re != nil {
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.re = re
+	}
+	return rules, nil
+}
+
+// benign reports whether msg matches a rule of category none, i.e. is not a
+// failure worth analyzing.
+func benign(cfg Config, msg string) bool {
+	for _, r := range cfg.rules {
+		if r.Category == "none" && r.re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyLocally returns the first failure rule matching msg or, failing
+// that, a rule made from the first known error code in msg. It returns nil
+// for unknown failures and benign errors, which are left to Gemini.
+func classifyLocally(cfg Config, msg string) *Rule {
+	if !cfg.LocalRules {
+		return nil
+	}
+	for _, r := range cfg.rules {
+		if r.Category != "none" && r.re.MatchString(msg) {
+			return r
+		}
+	}
+	for _, m := range errorCode.FindAllStringSubmatch(msg, -1) {
+		code := m[1] + m[2]
+		if c, ok := errorCodes[code]; ok {
+			return &Rule{
+				Name:        "code-" + code,
+				Category:    c.category,
+				Explanation: fmt.Sprintf("The error carries code %s: %s.", code, c.meaning),
+			}
+		}
+	}
+	return nil
+}
+
+// explain classifies the anchor error with the local rules or, if none
+// matches, asks Gemini to analyze its context. It returns the analysis and
+// the name of the rule, which is empty for Gemini's.
+func explain(ctx context.Context, cfg Config, anchor *logging.Entry, logDump string) (string, string, error) {
+	msg := entryMessage(anchor)
+	if r := classifyLocally(cfg, msg); r != nil {
+		cfg.progressf("📚 Known failure %s, skipping Gemini\n", r.Name)
+		return r.analysis(msg), r.Name, nil
+	}
+	cfg.progressf("🧠 Sending to Gemini for analysis...\n")
+	analysis, err := analyzeWithGemini(ctx, cfg, logDump)
+	return analysis, "", err
+}
+
+// analysis returns the report of the failure msg classified by r, ending
+// with a classification line like the ones Gemini is asked for.
+func (r *Rule) analysis(msg string) string {
+	return fmt.Sprintf("Known failure %s, classified by a local rule without Gemini.\n\n%s\n\nError: %s\n\nCATEGORY: %s",
+		r.Name, r.Explanation, msg, r.Category)
+}
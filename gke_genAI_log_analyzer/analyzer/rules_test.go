@@ -0,0 +1,118 @@
+package analyzer
+
+import (
+	"regexp"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/iterator"
+)
+
+// fixtureSeverity matches the severity of a fixture log line.
+var fixtureSeverity = regexp.MustCompile(`^\[[^]]*\] \[([A-Z]+)\] (.*)$`)
+
+// anchorOf returns the message of the first error of f, which the analyzer
+// would anchor on.
+func anchorOf(t *testing.T, f Fixture) string {
+	t.Helper()
+	for _, l := range f.Logs {
+		if m := fixtureSeverity.FindStringSubmatch(l); m != nil && m[1] == "ERROR" {
+			return m[2]
+		}
+	}
+	t.Fatalf("fixture %s has no error", f.Name)
+	return ""
+}
+
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	rules, err := loadRules(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{LocalRules: true, rules: rules}
+}
+
+func TestClassifyLocallyFixtures(t *testing.T) {
+	cfg := testConfig(t)
+	fixtures, err := LoadFixtures("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			msg := anchorOf(t, f)
+			if f.Expected == "none" {
+				if !benign(cfg, msg) {
+					t.Errorf("%q is not benign", msg)
+				}
+				return
+			}
+			r := classifyLocally(cfg, msg)
+			if r == nil {
+				t.Fatalf("no rule matches %q, want category %s", msg, f.Expected)
+			}
+			if r.Category != f.Expected {
+				t.Errorf("rule %s classifies %q as %s, want %s", r.Name, msg, r.Category, f.Expected)
+			}
+		})
+	}
+}
+
+func TestClassifyLocally(t *testing.T) {
+	cfg := testConfig(t)
+	for _, tc := range []struct {
+		msg, want string
+	}{
+		{`rpc error: code = PermissionDenied desc = caller lacks storage.objects.get`, "permission"},
+		{`pods "trainer-0" is forbidden: User "system:serviceaccount:train:trainer" cannot get resource "pods"`, "permission"},
+		{`GET https://storage.googleapis.com/b/o: 403 Forbidden`, "permission"},
+		{`rpc error: code = Unavailable desc = connection closed`, "network"},
+		{`googleapi: Error 503: backend error, backendError`, "network"},
+		{`MountVolume.SetUp failed for volume "gcs": rpc error: code = DeadlineExceeded desc = context deadline exceeded`, "configuration"},
+		// Benign, and neither a failure of access nor of memory.
+		{`failed to calculate volume total size for "/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/gcs/mount": operation not supported`, ""},
+		{`pods "trainer-0" is forbidden: exceeded quota: compute-resources`, ""},
+		{`gcsfuse process exited: signal: killed`, ""},
+		{`file system is not mounted yet`, ""},
+	} {
+		r := classifyLocally(cfg, tc.msg)
+		got := ""
+		if r != nil {
+			got = r.Category
+		}
+		if got != tc.want {
+			t.Errorf("classifyLocally(%q) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
+
+// entriesOf returns an iterator over entries with the messages msgs.
+func entriesOf(msgs ...string) func() (*logging.Entry, error) {
+	return func() (*logging.Entry, error) {
+		if len(msgs) == 0 {
+			return nil, iterator.Done
+		}
+		e := &logging.Entry{Payload: msgs[0]}
+		msgs = msgs[1:]
+		return e, nil
+	}
+}
+
+func TestFirstFailureSkipsBenign(t *testing.T) {
+	cfg := testConfig(t)
+	const benignMsg = `failed to calculate volume total size for "/var/lib/kubelet/pods/p/volumes/kubernetes.io~csi/gcs/mount": operation not supported`
+	e, err := firstFailure(cfg, entriesOf(benignMsg, `ReadFile: permission denied, googleapi: Error 403: caller does not have storage.objects.get access, forbidden`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e == nil {
+		t.Fatal("no failure found after the benign error")
+	}
+	if r := classifyLocally(cfg, entryMessage(e)); r == nil || r.Category != "permission" {
+		t.Errorf("the failure after the benign error %q is classified as %v, want permission", entryMessage(e), r)
+	}
+	if e, err := firstFailure(cfg, entriesOf(benignMsg, benignMsg)); err != nil || e != nil {
+		t.Errorf("firstFailure of benign errors = %v, %v, want none", e, err)
+	}
+}
@@ -0,0 +1,191 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// watchOverlap is how far every poll reads back into the previous one,
+	// as entries reach Cloud Logging late; entries seen already are skipped
+	// by their insert ID.
+	watchOverlap = time.Minute
+	// maxWatchErrors bounds the ERROR entries read per poll.
+	maxWatchErrors = 1000
+)
+
+// watchedError tracks the occurrences of one error signature in watch mode.
+type watchedError struct {
+	signature string
+	// analyzed is when the signature was last analyzed, and repeats and
+	// pods count its occurrences since.
+	analyzed time.Time
+	repeats  int
+	pods     map[string]bool
+	// reported is the repeat count last printed.
+	reported int
+}
+
+// runWatch polls Cloud Logging every cfg.WatchInterval for new ERROR entries,
+// starting at start, until ctx is done. The first occurrence of an error
+// signature, and the first after cfg.DedupWindow, is analyzed like in errors
+// mode; repetitions are only counted and summarized once per poll.
+func runWatch(ctx context.Context, client *logadmin.Client, cfg Config, start time.Time) error {
+	cfg.progressf("👀 Watching for GCSFuse errors every %v, analyzing each distinct error once per %v (Ctrl-C to stop)...\n",
+		cfg.WatchInterval, cfg.DedupWindow)
+	seen := map[string]time.Time{}
+	watched := map[string]*watchedError{}
+	ticker := time.NewTicker(cfg.WatchInterval)
+	defer ticker.Stop()
+	for from := start; ; {
+		now := time.Now()
+		entries, err := fetchNewErrors(ctx, client, cfg, from.Add(-watchOverlap), now, seen)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// The entries read before a failed page are handled too; the next
+		// poll reads the window again and skips them.
+		if err := handleNewErrors(ctx, client, cfg, entries, watched, seen); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch {
+		case err != nil:
+			log.Printf("Warning: polling Cloud Logging failed, retrying in %v: %v", cfg.WatchInterval, err)
+		case len(entries) == maxWatchErrors:
+			// Read the rest of a burst in the next poll.
+			from = entries[len(entries)-1].Timestamp
+		default:
+			from = now
+		}
+		for id, t := range seen {
+			if t.Before(from.Add(-2 * watchOverlap)) {
+				delete(seen, id)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchNewErrors reads the ERROR entries between start and end, oldest
+// first, skipping the insert IDs in seen. On error, it returns the entries
+// read before it.
+func fetchNewErrors(ctx context.Context, client *logadmin.Client, cfg Config, start, end time.Time, seen map[string]time.Time) ([]*logging.Entry, error) {
+	filter := fmt.Sprintf(`%s AND severity>=ERROR AND timestamp >= "%s" AND timestamp <= "%s"`,
+		getBaseFilter(cfg), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	iter := client.Entries(ctx, logadmin.Filter(filter))
+	var entries []*logging.Entry
+	for len(entries) < maxWatchErrors {
+		e, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		if _, ok := seen[e.InsertID]; ok && e.InsertID != "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// handleNewErrors handles the entries, remembering the insert ID of each in
+// seen once it is handled, then prints how often the known signatures
+// repeated.
+func handleNewErrors(ctx context.Context, client *logadmin.Client, cfg Config, entries []*logging.Entry, watched map[string]*watchedError, seen map[string]time.Time) error {
+	for _, e := range entries {
+		if err := handleNewError(ctx, client, cfg, e, watched); err != nil {
+			return err
+		}
+		seen[e.InsertID] = e.Timestamp
+	}
+
+	var repeated []*watchedError
+	for _, w := range watched {
+		if w.repeats > w.reported {
+			repeated = append(repeated, w)
+		}
+	}
+	sort.Slice(repeated, func(i, j int) bool { return repeated[i].repeats > repeated[j].repeats })
+	for _, w := range repeated {
+		cfg.progressf("🔁 Seen %d more times (%d since the analysis at %s, %d pods): %s\n",
+			w.repeats-w.reported, w.repeats, w.analyzed.Format(time.TimeOnly), len(w.pods), w.signature)
+		w.reported = w.repeats
+	}
+	return nil
+}
+
+// handleNewError skips a benign entry, analyzes the entry of a new signature
+// and counts the others.
+func handleNewError(ctx context.Context, client *logadmin.Client, cfg Config, e *logging.Entry, watched map[string]*watchedError) error {
+	msg := entryMessage(e)
+	if benign(cfg, msg) {
+		return nil
+	}
+	scope := scopeOf(cfg, e)
+	sig := failureSignature(msg, scope.Pod)
+	w := watched[sig]
+	if w != nil && e.Timestamp.Sub(w.analyzed) < cfg.DedupWindow {
+		w.repeats++
+		w.pods[scope.Namespace+"/"+scope.Pod] = true
+		return nil
+	}
+	if w == nil {
+		w = &watchedError{signature: sig}
+		watched[sig] = w
+	}
+	w.analyzed, w.repeats, w.reported = e.Timestamp, 0, 0
+	w.pods = map[string]bool{scope.Namespace + "/" + scope.Pod: true}
+	return analyzeWatched(ctx, client, cfg, e)
+}
+
+// analyzeWatched explains a new error like errors mode does, and prints
+// the analysis as a text report or one line of JSON.
+func analyzeWatched(ctx context.Context, client *logadmin.Client, cfg Config, e *logging.Entry) error {
+	scope := scopeOf(cfg, e)
+	cfg.progressf("🚨 New error at %s in %s/%s: %v\n", e.Timestamp.Format(time.TimeOnly), scope.Namespace, scope.Pod, parsePayload(e.Payload))
+	podCfg := cfg
+	podCfg.Namespace, podCfg.PodName = scope.Namespace, scope.Pod
+	logDump, err := fetchLogContext(ctx, client, e.Timestamp, e, podCfg)
+	if err != nil {
+		return fmt.Errorf("error fetching context logs: %w", err)
+	}
+	analysis, rule, err := explain(ctx, podCfg, e, logDump)
+	if err != nil {
+		// One failed analysis does not stop the watch.
+		log.Printf("Warning: gemini analysis failed: %v", err)
+		return nil
+	}
+	var impact *Impact
+	if cfg.EnrichOwners {
+		cfg.progressf("👥 Resolving affected workload and owners...\n")
+		impact = resolveImpact(ctx, cfg, e)
+	}
+	if cfg.Output == OutputJSON {
+		f := newFinding(podCfg, e, logDump, analysis)
+		f.Rule = rule
+		if impact != nil {
+			f.Impacts = []*Impact{impact}
+		}
+		return json.NewEncoder(os.Stdout).Encode(f)
+	}
+	printReport(analysis, impact)
+	return nil
+}
@@ -0,0 +1,537 @@
+package benchmark
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+
+	"go-client-benchmark/servertiming"
+)
+
+// Config holds the flags of a single read benchmark run.
+type Config struct {
+	BucketName       string
+	ClientProtocol   string
+	BlockSizeStr     string
+	FileSizeStr      string
+	NumOfWorkers     int
+	NrFiles          int
+	ObjectNamePrefix string
+	GrpcConnPoolSize int
+	// ServerTiming records the server-side processing time GCS reports for
+	// every request, see servertiming.Report.
+	ServerTiming bool
+	// CSEKKeyFile holds a base64 encoded AES-256 customer-supplied
+	// encryption key the objects are written and read with.
+	CSEKKeyFile string
+	// ObjectName, when set, names the objects of the workers instead of
+	// ObjectNamePrefix and the default layout, e.g. after a workload spec.
+	ObjectName func(workerID, fileIndex int) string
+
+	// key is the decoded key of CSEKKeyFile.
+	key []byte
+}
+
+// RegisterFlags binds the benchmark flags to fs. It is shared by the
+// standalone binary and the gcsfuse-tools CLI so both accept the same options.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.BucketName, "bucket", "", "GCS bucket name.")
+	fs.StringVar(&c.ClientProtocol, "client-protocol", "http1", "Network protocol: http1 or grpc.")
+	fs.StringVar(&c.BlockSizeStr, "bs", "1M", "Block size (e.g. 128K, 1M, etc.).")
+	fs.StringVar(&c.FileSizeStr, "filesize", "1G", "File size per file (e.g. 1M, 10M, 1G).")
+	fs.IntVar(&c.NumOfWorkers, "numjobs", 128, "Number of concurrent workers (threads) to read.")
+	fs.IntVar(&c.NrFiles, "nrfiles", 10, "How many files does each thread/worker need to read.")
+	fs.StringVar(&c.ObjectNamePrefix, "obj-prefix", "", "Prefix for GCS objects.")
+	fs.IntVar(&c.GrpcConnPoolSize, "grpc-conn-pool-size", 1, "gRPC connection pool size.")
+	fs.BoolVar(&c.ServerTiming, "server-timing", false, "Record the Server-Timing GCS reports for every request and split the time to response headers into GCS processing and network/client time.")
+	fs.StringVar(&c.CSEKKeyFile, "csek-key-file", "", "File holding a base64 encoded AES-256 customer-supplied encryption key (e.g. from openssl rand -base64 32). The objects are written and read encrypted with it, under a separate read-csek/ prefix.")
+}
+
+// loadKey reads the key of CSEKKeyFile, if set.
+func (c *Config) loadKey() error {
+	if c.CSEKKeyFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(c.CSEKKeyFile)
+	if err != nil {
+		return err
+	}
+	if c.key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err != nil {
+		return fmt.Errorf("decoding %s: %w", c.CSEKKeyFile, err)
+	}
+	if len(c.key) != 32 {
+		return fmt.Errorf("%s holds a %d byte key, want a 32 byte AES-256 key", c.CSEKKeyFile, len(c.key))
+	}
+	return nil
+}
+
+// object returns the handle of the object name, encrypted with the key if
+// set.
+func (c *Config) object(bucket *storage.BucketHandle, name string) *storage.ObjectHandle {
+	obj := bucket.Object(name)
+	if c.key != nil {
+		obj = obj.Key(c.key)
+	}
+	return obj
+}
+
+// keySHA256 returns the base64 encoded SHA-256 of the key, which GCS
+// reports for the objects encrypted with it.
+func (c *Config) keySHA256() string {
+	sum := sha256.Sum256(c.key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type ZeroReader struct{}
+
+func (ZeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func parseSize(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, fmt.Errorf("empty size string")
+	}
+	unit := s[len(s)-1]
+	valStr := s[:len(s)-1]
+	val, err := strconv.ParseInt(valStr, 10, 64)
+	if err != nil {
+		val, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return val, nil
+	}
+	switch unit {
+	case 'k', 'K':
+		return val * 1024, nil
+	case 'm', 'M':
+		return val * 1024 * 1024, nil
+	case 'g', 'G':
+		return val * 1024 * 1024 * 1024, nil
+	default:
+		val, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size format: %s", s)
+		}
+		return val, nil
+	}
+}
+
+// CreateHTTPClient returns a JSON API client. Responses are recorded in rec,
+// if not nil.
+func CreateHTTPClient(ctx context.Context, rec *servertiming.Recorder) (*storage.Client, error) {
+	var transport http.RoundTripper = &http.Transport{
+		MaxConnsPerHost:     1000,
+		MaxIdleConnsPerHost: 1000,
+		TLSNextProto:        make(map[string]func(string, *tls.Conn) http.RoundTripper),
+	}
+	if rec != nil {
+		transport = rec.Transport(transport)
+	}
+
+	tokenSource, err := google.DefaultTokenSource(ctx, storage.ScopeFullControl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default token source: %w", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &oauth2.Transport{
+			Base:   transport,
+			Source: tokenSource,
+		},
+		Timeout: 0,
+	}
+	return storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+}
+
+// CreateGrpcClient returns a gRPC API client. Calls are recorded in rec, if
+// not nil.
+func CreateGrpcClient(ctx context.Context, connPoolSize int, rec *servertiming.Recorder) (*storage.Client, error) {
+	tokenSource, err := google.DefaultTokenSource(ctx, storage.ScopeFullControl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default token source: %w", err)
+	}
+	opts := []option.ClientOption{
+		option.WithGRPCConnectionPool(connPoolSize),
+		option.WithTokenSource(tokenSource),
+		storage.WithDisabledClientMetrics(),
+	}
+	if rec != nil {
+		for _, o := range rec.DialOptions() {
+			opts = append(opts, option.WithGRPCDialOption(o))
+		}
+	}
+	return storage.NewGRPCClient(ctx, opts...)
+}
+
+func (c *Config) getObjectPath(workerID, fileIndex int) string {
+	if c.ObjectName != nil {
+		return c.ObjectName(workerID, fileIndex)
+	}
+	// e.g. go-benchmark/read/1G/10/experiment.0.1
+	dir := "read"
+	if c.key != nil {
+		dir = "read-csek"
+	}
+	return fmt.Sprintf("%s%s/%s/%d/experiment.%d.%d",
+		c.ObjectNamePrefix, dir, c.FileSizeStr, c.NrFiles, workerID, fileIndex)
+}
+
+func (c *Config) populateFilesIfMissing(ctx context.Context, client *storage.Client, fileSize int64) error {
+	bucketName := c.BucketName
+	bucket := client.Bucket(bucketName)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, 50) // limit concurrency to 50
+	errChan := make(chan error, c.NumOfWorkers*c.NrFiles)
+
+	fmt.Fprintf(os.Stderr, "Checking and preparing benchmark files in GCS bucket gs://%s...\n", bucketName)
+
+	for w := 0; w < c.NumOfWorkers; w++ {
+		for f := 0; f < c.NrFiles; f++ {
+			workerID := w
+			fileIndex := f
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case <-ctx.Done():
+					return
+				case semaphore <- struct{}{}:
+					defer func() { <-semaphore }()
+				}
+
+				objName := c.getObjectPath(workerID, fileIndex)
+				obj := c.object(bucket, objName)
+				attrs, err := obj.Attrs(ctx)
+				if err == nil {
+					// Objects encrypted with another key cannot be read.
+					if attrs.Size == fileSize && (c.key == nil || attrs.CustomerKeySHA256 == c.keySHA256()) {
+						// File already exists and has correct size, skip upload
+						return
+					}
+				} else if !errors.Is(err, storage.ErrObjectNotExist) {
+					errChan <- fmt.Errorf("failed to check status of %s: %w", objName, err)
+					return
+				}
+
+				// Upload file
+				fmt.Fprintf(os.Stderr, "Creating gs://%s/%s (%s)...\n", bucketName, objName, c.FileSizeStr)
+				wc := obj.NewWriter(ctx)
+				src := io.LimitReader(ZeroReader{}, fileSize)
+				if _, err := io.Copy(wc, src); err != nil {
+					errChan <- fmt.Errorf("failed to write %s: %w", objName, err)
+					wc.Close()
+					return
+				}
+				if err := wc.Close(); err != nil {
+					errChan <- fmt.Errorf("failed to close %s: %w", objName, err)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "All benchmark files prepared successfully.")
+	return nil
+}
+
+type LatencyStats struct {
+	Mean int64            `json:"mean"`
+	P99  int64            `json:"99.000000"`
+	P995 int64            `json:"99.500000"`
+	P999 int64            `json:"99.900000"`
+	Percentiles map[string]int64 `json:"percentiles"`
+}
+
+type ReadOpStats struct {
+	Bw          float64      `json:"bw"` // KiB/s
+	Iops        float64      `json:"iops"`
+	LatNs       LatencyStats `json:"lat_ns"`
+}
+
+type JobResult struct {
+	JobName    string            `json:"jobname"`
+	JobOptions map[string]string `json:"job options"`
+	ReadStats  ReadOpStats       `json:"read"`
+}
+
+type OutputSchema struct {
+	GlobalOptions map[string]string `json:"global options"`
+	Jobs          []JobResult       `json:"jobs"`
+	// ServerTiming covers every request of the run, including the
+	// preparation of missing objects, with --server-timing.
+	ServerTiming *servertiming.Report `json:"server_timing,omitempty"`
+}
+
+// Validate reports missing or out-of-range flag values.
+func (c *Config) Validate() error {
+	if c.BucketName == "" {
+		return errors.New("--bucket flag is required")
+	}
+	if c.NumOfWorkers <= 0 {
+		return errors.New("--numjobs must be greater than 0")
+	}
+	if c.NrFiles <= 0 {
+		return errors.New("--nrfiles must be greater than 0")
+	}
+	return nil
+}
+
+// Run prepares the benchmark objects if needed, reads them back with
+// NumOfWorkers concurrent readers and returns the results in the FIO JSON
+// layout.
+func Run(ctx context.Context, c Config) (*OutputSchema, error) {
+	var client *storage.Client
+	var err error
+	var rec *servertiming.Recorder
+	if c.ServerTiming {
+		rec = servertiming.NewRecorder()
+	}
+
+	if c.ClientProtocol == "http1" {
+		client, err = CreateHTTPClient(ctx, rec)
+	} else if c.ClientProtocol == "grpc" {
+		client, err = CreateGrpcClient(ctx, c.GrpcConnPoolSize, rec)
+	} else {
+		return nil, fmt.Errorf("invalid client-protocol: %s", c.ClientProtocol)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("creating storage client: %w", err)
+	}
+	defer client.Close()
+
+	if err := c.loadKey(); err != nil {
+		return nil, fmt.Errorf("loading csek-key-file: %w", err)
+	}
+
+	fileSize, err := parseSize(c.FileSizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing filesize: %w", err)
+	}
+	if fileSize <= 0 {
+		return nil, errors.New("filesize must be greater than 0")
+	}
+
+	blockSize, err := parseSize(c.BlockSizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing bs (block size): %w", err)
+	}
+	if blockSize <= 0 {
+		return nil, errors.New("bs (block size) must be greater than 0")
+	}
+
+	// 1. Ensure files exist
+	if err := c.populateFilesIfMissing(ctx, client, fileSize); err != nil {
+		return nil, fmt.Errorf("preparing benchmark files: %w", err)
+	}
+
+	// 2. Perform the benchmark
+	bucket := client.Bucket(c.BucketName)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var totalBytesRead int64
+	var totalIOOperations int64
+
+	// Slice of latency list for each worker to avoid synchronization overhead
+	workerLatencies := make([][]time.Duration, c.NumOfWorkers)
+	for i := range workerLatencies {
+		workerLatencies[i] = make([]time.Duration, 0, 1000)
+	}
+
+	var wg sync.WaitGroup
+	// The first failed worker cancels the others; their errors are expected.
+	errChan := make(chan error, c.NumOfWorkers)
+
+	fmt.Fprintln(os.Stderr, "Starting benchmark read phase...")
+	startTime := time.Now()
+
+	for w := 0; w < c.NumOfWorkers; w++ {
+		workerID := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, blockSize)
+
+			for f := 0; f < c.NrFiles; f++ {
+				// Check for cancellation before starting next file
+				select {
+				case <-runCtx.Done():
+					return
+				default:
+				}
+
+				objName := c.getObjectPath(workerID, f)
+				obj := c.object(bucket, objName)
+
+				rc, err := obj.NewReader(runCtx)
+				if err != nil {
+					// If context is cancelled, this error is expected
+					if runCtx.Err() == nil {
+						errChan <- fmt.Errorf("worker %d failed to open %s: %w", workerID, objName, err)
+						cancel()
+					}
+					return
+				}
+
+				for {
+					select {
+					case <-runCtx.Done():
+						rc.Close()
+						return
+					default:
+					}
+
+					ioStart := time.Now()
+					n, err := rc.Read(buf)
+					ioDuration := time.Since(ioStart)
+
+					if n > 0 {
+						atomic.AddInt64(&totalBytesRead, int64(n))
+						atomic.AddInt64(&totalIOOperations, 1)
+						workerLatencies[workerID] = append(workerLatencies[workerID], ioDuration)
+					}
+
+					if err != nil {
+						if err == io.EOF {
+							break
+						}
+						if runCtx.Err() == nil {
+							errChan <- fmt.Errorf("worker %d failed to read %s: %w", workerID, objName, err)
+							cancel()
+						}
+						rc.Close()
+						return
+					}
+				}
+				rc.Close()
+			}
+
+			// If we reached here, this worker has completed reading all its files!
+			// We cancel the context to signal all other workers to stop immediately (similar to exitall=1).
+			cancel()
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(startTime)
+	fmt.Fprintf(os.Stderr, "Benchmark read phase completed in %v.\n", elapsed)
+
+	// Combine and sort latencies
+	var allLatencies []time.Duration
+	for _, wl := range workerLatencies {
+		allLatencies = append(allLatencies, wl...)
+	}
+
+	sort.Slice(allLatencies, func(i, j int) bool {
+		return allLatencies[i] < allLatencies[j]
+	})
+
+	var meanLatNs int64
+	var p99LatNs int64
+	var p995LatNs int64
+	var p999LatNs int64
+
+	totalIOs := int64(len(allLatencies))
+	if totalIOs > 0 {
+		var sum int64
+		for _, lat := range allLatencies {
+			sum += lat.Nanoseconds()
+		}
+		meanLatNs = sum / totalIOs
+		p99Idx := int(float64(totalIOs) * 0.99)
+		p995Idx := int(float64(totalIOs) * 0.995)
+		p999Idx := int(float64(totalIOs) * 0.999)
+		if p99Idx >= int(totalIOs) {
+			p99Idx = int(totalIOs) - 1
+		}
+		if p995Idx >= int(totalIOs) {
+			p995Idx = int(totalIOs) - 1
+		}
+		if p999Idx >= int(totalIOs) {
+			p999Idx = int(totalIOs) - 1
+		}
+		p99LatNs = allLatencies[p99Idx].Nanoseconds()
+		p995LatNs = allLatencies[p995Idx].Nanoseconds()
+		p999LatNs = allLatencies[p999Idx].Nanoseconds()
+	}
+
+	bwKiBps := (float64(totalBytesRead) / 1024.0) / elapsed.Seconds()
+	iops := float64(totalIOOperations) / elapsed.Seconds()
+
+	// Format output to JSON matching FIO runner expected format
+	output := OutputSchema{
+		GlobalOptions: map[string]string{
+			"iodepth": "1",
+			"rw":      "read",
+		},
+		Jobs: []JobResult{
+			{
+				JobName: "go-client-read",
+				JobOptions: map[string]string{
+					"bs":              c.BlockSizeStr,
+					"filesize":        c.FileSizeStr,
+					"nrfiles":         strconv.Itoa(c.NrFiles),
+					"numjobs":         strconv.Itoa(c.NumOfWorkers),
+					"client_protocol": c.ClientProtocol,
+					"csek":            strconv.FormatBool(c.key != nil),
+				},
+				ReadStats: ReadOpStats{
+					Bw:   bwKiBps,
+					Iops: iops,
+					LatNs: LatencyStats{
+						Mean: meanLatNs,
+						P99:  p99LatNs,
+						P995: p995LatNs,
+						P999: p999LatNs,
+						Percentiles: map[string]int64{
+							"99.000000":  p99LatNs,
+							"99.500000":  p995LatNs,
+							"99.900000":  p999LatNs,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if rec != nil {
+		output.ServerTiming = rec.Report()
+		output.ServerTiming.WriteText(os.Stderr)
+	}
+
+	return &output, nil
+}
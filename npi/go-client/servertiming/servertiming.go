@@ -0,0 +1,210 @@
+// Package servertiming separates the time GCS spends processing requests,
+// which it reports in the Server-Timing response header or gRPC metadata,
+// from the network and client time of a benchmark run, answering whether a
+// slow run is slow because of GCS or because of everything in between.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderName is the response header, and gRPC metadata key, GCS reports its
+// processing time in, e.g. "gfet4t7; dur=12".
+const HeaderName = "Server-Timing"
+
+// Sample is the timing of one request.
+type Sample struct {
+	// Total is the time from sending the request to receiving the response
+	// headers.
+	Total time.Duration
+	// Server is the processing time GCS reported, if HasServer.
+	Server    time.Duration
+	HasServer bool
+}
+
+// Recorder collects the samples of the requests sent through its transport
+// or gRPC dial options. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) add(total time.Duration, values []string) {
+	s := Sample{Total: total}
+	s.Server, s.HasServer = Parse(values)
+	r.mu.Lock()
+	r.samples = append(r.samples, s)
+	r.mu.Unlock()
+}
+
+// Parse returns the largest dur of the metrics of Server-Timing header
+// values, e.g. 12ms for "gfet4t7; dur=12". The front end metric covers the
+// others, so the largest one is the server-side processing time.
+func Parse(values []string) (time.Duration, bool) {
+	var longest float64
+	found := false
+	for _, v := range values {
+		for _, metric := range strings.Split(v, ",") {
+			for _, param := range strings.Split(metric, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(k, "dur") {
+					continue
+				}
+				ms, err := strconv.ParseFloat(strings.Trim(val, `"`), 64)
+				if err != nil || ms < 0 {
+					continue
+				}
+				longest = max(longest, ms)
+				found = true
+			}
+		}
+	}
+	return time.Duration(longest * float64(time.Millisecond)), found
+}
+
+// Transport wraps base, recording the time to the response headers and the
+// Server-Timing of every response.
+func (r *Recorder) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base, rec: r}
+}
+
+type transport struct {
+	base http.RoundTripper
+	rec  *Recorder
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.rec.add(time.Since(start), resp.Header.Values(HeaderName))
+	}
+	return resp, err
+}
+
+// DialOptions returns the gRPC dial options recording the time to the
+// response headers and the server-timing metadata of every call.
+func (r *Recorder) DialOptions() []grpc.DialOption {
+	key := strings.ToLower(HeaderName)
+	unary := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var header metadata.MD
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+		if err == nil {
+			r.add(time.Since(start), header.Get(key))
+		}
+		return err
+	}
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return cs, err
+		}
+		// Header blocks until the headers arrive or the stream ends; reads
+		// of the object stream in the meantime.
+		go func() {
+			if header, err := cs.Header(); err == nil && header != nil {
+				r.add(time.Since(start), header.Get(key))
+			}
+		}()
+		return cs, nil
+	}
+	return []grpc.DialOption{grpc.WithChainUnaryInterceptor(unary), grpc.WithChainStreamInterceptor(stream)}
+}
+
+// Stats summarizes durations in nanoseconds.
+type Stats struct {
+	Mean int64 `json:"mean"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+}
+
+func newStats(d []time.Duration) Stats {
+	if len(d) == 0 {
+		return Stats{}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	at := func(p float64) int64 { return d[min(len(d)-1, int(float64(len(d))*p))].Nanoseconds() }
+	return Stats{Mean: (sum / time.Duration(len(d))).Nanoseconds(), P50: at(0.5), P90: at(0.9), P99: at(0.99)}
+}
+
+// Report splits the time to the response headers of the requests of a run
+// into the server-side processing time GCS reported and the rest: network,
+// TLS, queuing in the client and the proxies in between.
+type Report struct {
+	Requests int `json:"requests"`
+	// WithServerTiming counts the requests whose response reported a
+	// server-side time; Server, Other and ServerShare only cover them.
+	WithServerTiming int   `json:"with_server_timing"`
+	TotalNs          Stats `json:"total_ns"`
+	ServerNs         Stats `json:"server_ns"`
+	OtherNs          Stats `json:"network_and_client_ns"`
+	// ServerShare is the fraction of the total time spent in GCS.
+	ServerShare float64 `json:"server_share"`
+}
+
+// Report summarizes the samples recorded so far.
+func (r *Recorder) Report() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := &Report{Requests: len(r.samples)}
+	var total, server, other []time.Duration
+	var totalSum, serverSum time.Duration
+	for _, s := range r.samples {
+		total = append(total, s.Total)
+		if !s.HasServer {
+			continue
+		}
+		rep.WithServerTiming++
+		server = append(server, s.Server)
+		other = append(other, max(0, s.Total-s.Server))
+		totalSum += s.Total
+		serverSum += min(s.Server, s.Total)
+	}
+	rep.TotalNs, rep.ServerNs, rep.OtherNs = newStats(total), newStats(server), newStats(other)
+	if totalSum > 0 {
+		rep.ServerShare = float64(serverSum) / float64(totalSum)
+	}
+	return rep
+}
+
+// WriteText prints where the time of the requests went.
+func (rep *Report) WriteText(w io.Writer) error {
+	round := func(ns int64) string { return time.Duration(ns).Round(10 * time.Microsecond).String() }
+	fmt.Fprintf(w, "%d requests, %d with server timing\n", rep.Requests, rep.WithServerTiming)
+	fmt.Fprintf(w, "  time to headers p50 %s, p99 %s\n", round(rep.TotalNs.P50), round(rep.TotalNs.P99))
+	if rep.WithServerTiming == 0 {
+		_, err := fmt.Fprintln(w, "  GCS reported no server timing; the split is unknown")
+		return err
+	}
+	fmt.Fprintf(w, "  GCS processing  p50 %s, p99 %s\n", round(rep.ServerNs.P50), round(rep.ServerNs.P99))
+	fmt.Fprintf(w, "  network+client  p50 %s, p99 %s\n", round(rep.OtherNs.P50), round(rep.OtherNs.P99))
+	where := "network and client"
+	if rep.ServerShare >= 0.5 {
+		where = "GCS"
+	}
+	_, err := fmt.Fprintf(w, "  %.0f%% of the time was spent in GCS: mostly %s\n", rep.ServerShare*100, where)
+	return err
+}